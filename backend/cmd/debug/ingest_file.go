@@ -3,9 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"time"
 
+	"pcap-analyzer/internal/config"
 	"pcap-analyzer/internal/db"
 	"pcap-analyzer/internal/model"
 	"pcap-analyzer/internal/service/analyzer"
@@ -23,7 +25,13 @@ func main() {
 	filePath := os.Args[1]
 	fmt.Println("Ingesting file:", filePath)
 
-	db.InitDB()
+	cfg, err := config.Load(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Open(cfg.DBDriver, cfg.DBDSN); err != nil {
+		log.Fatal("Failed to initialize database: ", err)
+	}
 
 	// Create Analysis Record
 	id := uuid.New().String()
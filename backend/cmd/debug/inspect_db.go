@@ -2,12 +2,21 @@ package main
 
 import (
 	"fmt"
+	"log"
+
+	"pcap-analyzer/internal/config"
 	"pcap-analyzer/internal/db"
 	"pcap-analyzer/internal/model"
 )
 
 func main() {
-	db.InitDB()
+	cfg, err := config.Load(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Open(cfg.DBDriver, cfg.DBDSN); err != nil {
+		log.Fatal("Failed to initialize database: ", err)
+	}
 
 	var analysis model.Analysis
 	// Get the latest analysis
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"pcap-analyzer/internal/db"
+)
+
+// Usage:
+//
+//	go run ./cmd/migrate            apply pending migrations
+//	go run ./cmd/migrate -down 0    roll back to an empty schema
+//	go run ./cmd/migrate -status    print the current schema version
+//
+// The connection comes from DB_DRIVER/DB_DSN, as for the server.
+func main() {
+	driver := flag.String("driver", os.Getenv("DB_DRIVER"), "database driver (sqlite or postgres)")
+	dsn := flag.String("dsn", os.Getenv("DB_DSN"), "database DSN")
+	down := flag.Int("down", -1, "roll back to this schema version")
+	status := flag.Bool("status", false, "print the current schema version and exit")
+	flag.Parse()
+
+	if *driver == "" {
+		*driver = "sqlite"
+	}
+	conn, err := db.Connect(*driver, *dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case *status:
+	case *down >= 0:
+		err = db.MigrateDown(conn, *down)
+	default:
+		err = db.Migrate(conn)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	version, err := db.SchemaVersion(conn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Schema version: %d\n", version)
+}
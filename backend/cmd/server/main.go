@@ -1,23 +1,87 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"pcap-analyzer/internal/config"
 	"pcap-analyzer/internal/db"
 	"pcap-analyzer/internal/handler"
 	"pcap-analyzer/internal/middleware"
+	"pcap-analyzer/internal/service/analyzer"
+	"pcap-analyzer/internal/service/classify"
+	"pcap-analyzer/internal/service/lifecycle"
+	"pcap-analyzer/internal/service/queue"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// Load Configuration (defaults < config file < env < flags)
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
 	// Initialize Database
-	db.InitDB()
+	if err := db.Open(cfg.DBDriver, cfg.DBDSN); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	// Analysis Settings
+	handler.UploadDir = cfg.UploadDir
+	handler.MaxUploadBytes = cfg.MaxUploadBytes
+	handler.TimeSeriesBucket = time.Duration(cfg.TimeSeriesBucket)
+	if cfg.ThresholdsFile != "" {
+		handler.Thresholds, err = analyzer.LoadThresholds(cfg.ThresholdsFile)
+		if err != nil {
+			log.Fatalf("Failed to load thresholds: %v", err)
+		}
+	}
+	if cfg.PortHintsFile != "" {
+		handler.PortHints, err = classify.LoadPortHints(cfg.PortHintsFile)
+		if err != nil {
+			log.Fatalf("Failed to load port hints: %v", err)
+		}
+	}
+
+	// Initialize Job Queue
+	q, err := queue.Open(cfg.QueueBackend, cfg.RedisAddr)
+	if err != nil {
+		log.Fatalf("Failed to open job queue: %v", err)
+	}
+	pool := queue.NewPool(q, cfg.Workers, handler.RunAnalysisJob)
+	pool.OnFailed = handler.AnalysisJobFailed
+	pool.OnCancelled = handler.AnalysisJobCancelled
+	if err := pool.Start(); err != nil {
+		log.Fatalf("Failed to start workers: %v", err)
+	}
+	handler.Jobs = pool
+
+	// Expire old analyses
+	retention := lifecycle.RetentionPolicy{
+		MaxAge:        time.Duration(cfg.RetentionMaxAge),
+		MaxTotalBytes: cfg.RetentionMaxBytes,
+		Interval:      time.Duration(cfg.RetentionInterval),
+		UploadDir:     cfg.UploadDir,
+	}
+	retentionCtx, stopRetention := context.WithCancel(context.Background())
+	if retention.Enabled() {
+		go retention.Run(retentionCtx)
+	}
 
 	r := gin.Default()
-	r.Use(middleware.CORSMiddleware())
+	r.Use(middleware.CORSMiddleware(cfg.CORSOrigins))
 
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -29,14 +93,53 @@ func main() {
 	// API Routes
 	api := r.Group("/api")
 	{
+		api.GET("/config", handler.ConfigHandler(cfg.Public()))
 		api.POST("/upload", handler.UploadHandler)
+		api.GET("/analyses", handler.ListAnalysesHandler)
 		api.GET("/analysis/:id", handler.AnalysisResultHandler)
+		api.PATCH("/analysis/:id", handler.UpdateAnalysisHandler)
+		api.DELETE("/analysis/:id", handler.DeleteAnalysisHandler)
+		api.GET("/analysis/:id/events", handler.AnalysisEventsHandler)
+		api.GET("/analysis/:id/job", handler.GetJobHandler)
+		api.DELETE("/analysis/:id/job", handler.CancelJobHandler)
+		api.GET("/analysis/:id/dns", handler.GetAnalysisDNSHandler)
+		api.GET("/analysis/:id/calls", handler.GetAnalysisCallsHandler)
+		api.GET("/analysis/:id/queries", handler.GetAnalysisQueriesHandler)
+		api.GET("/analysis/:id/files", handler.GetAnalysisFilesHandler)
+		api.GET("/analysis/:id/findings", handler.GetAnalysisFindingsHandler)
+		api.GET("/analysis/:id/timeseries", handler.GetAnalysisTimeseriesHandler)
+		api.GET("/analysis/:id/top", handler.GetAnalysisTopHandler)
 		api.GET("/stream/:id/packets", handler.GetStreamPacketsHandler)
+		api.GET("/stream/:id/transactions", handler.GetStreamTransactionsHandler)
+		api.GET("/stream/:id/timeseries", handler.GetStreamTimeseriesHandler)
 		api.POST("/dev/ingest", handler.DevIngestHandler)
 	}
 
-	fmt.Println("Server starting on :8080...")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: r}
+	go func() {
+		fmt.Printf("Server starting on %s...\n", cfg.ListenAddr)
+		var err error
+		if cfg.TLSEnabled() {
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Shut down cleanly so running jobs go back to the queue
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown: %v", err)
 	}
+	stopRetention()
+	pool.Stop()
 }
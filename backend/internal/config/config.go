@@ -0,0 +1,323 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the server configuration. Each setting can come from, in
+// increasing precedence: built-in defaults, a JSON/YAML config file, an
+// environment variable (env tag) and a command-line flag (flag tag).
+type Config struct {
+	ListenAddr     string   `json:"listen_addr" yaml:"listen_addr" env:"LISTEN_ADDR" flag:"listen" usage:"HTTP listen address"`
+	TLSCertFile    string   `json:"tls_cert_file" yaml:"tls_cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"TLS certificate file (enables HTTPS with -tls-key)"`
+	TLSKeyFile     string   `json:"tls_key_file" yaml:"tls_key_file" env:"TLS_KEY_FILE" flag:"tls-key" usage:"TLS private key file"`
+	UploadDir      string   `json:"upload_dir" yaml:"upload_dir" env:"UPLOAD_DIR" flag:"upload-dir" usage:"directory for uploaded captures"`
+	MaxUploadBytes int64    `json:"max_upload_bytes" yaml:"max_upload_bytes" env:"MAX_UPLOAD_BYTES" flag:"max-upload-bytes" usage:"largest accepted upload in bytes"`
+	DBDriver       string   `json:"db_driver" yaml:"db_driver" env:"DB_DRIVER" flag:"db-driver" usage:"database driver: sqlite or postgres"`
+	DBDSN          string   `json:"db_dsn" yaml:"db_dsn" env:"DB_DSN" flag:"db-dsn" usage:"database DSN"`
+	QueueBackend   string   `json:"queue_backend" yaml:"queue_backend" env:"QUEUE_BACKEND" flag:"queue" usage:"job queue backend: sqlite or redis"`
+	RedisAddr      string   `json:"redis_addr" yaml:"redis_addr" env:"REDIS_ADDR" flag:"redis-addr" usage:"Redis address for the redis queue"`
+	Workers        int      `json:"workers" yaml:"workers" env:"WORKERS" flag:"workers" usage:"number of analysis workers"`
+	ThresholdsFile string   `json:"thresholds_file" yaml:"thresholds_file" env:"THRESHOLDS_FILE" flag:"thresholds" usage:"detector thresholds profile (JSON or YAML)"`
+	PortHintsFile  string   `json:"port_hints_file" yaml:"port_hints_file" env:"PORT_HINTS_FILE" flag:"port-hints" usage:"protocol port hints overriding the built-in table (JSON or YAML)"`
+	CORSOrigins    []string `json:"cors_origins" yaml:"cors_origins" env:"CORS_ORIGINS" flag:"cors-origins" usage:"comma-separated allowed CORS origins, or *"`
+
+	TimeSeriesBucket Duration `json:"timeseries_bucket" yaml:"timeseries_bucket" env:"TIMESERIES_BUCKET" flag:"timeseries-bucket" usage:"capture time series resolution (e.g. 1s); 0 picks one from the capture duration"`
+
+	RetentionMaxAge   Duration `json:"retention_max_age" yaml:"retention_max_age" env:"RETENTION_MAX_AGE" flag:"retention-max-age" usage:"expire analyses older than this (e.g. 720h)"`
+	RetentionMaxBytes int64    `json:"retention_max_bytes" yaml:"retention_max_bytes" env:"RETENTION_MAX_BYTES" flag:"retention-max-bytes" usage:"expire oldest analyses beyond this many upload bytes"`
+	RetentionInterval Duration `json:"retention_interval" yaml:"retention_interval" env:"RETENTION_INTERVAL" flag:"retention-interval" usage:"how often the retention policy runs (e.g. 1h)"`
+}
+
+// Duration is a time.Duration written as a string ("90s", "720h") in config files
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func Default() Config {
+	workers := runtime.NumCPU() / 2
+	if workers < 1 {
+		workers = 1
+	}
+	return Config{
+		ListenAddr:     ":8080",
+		UploadDir:      "./uploads",
+		MaxUploadBytes: 10 << 30, // 10 GiB
+		DBDriver:       "sqlite",
+		QueueBackend:   "sqlite",
+		Workers:        workers,
+		CORSOrigins:    []string{"*"},
+
+		RetentionInterval: Duration(time.Hour),
+	}
+}
+
+// Load builds the configuration from defaults, the file named by -config
+// (or CONFIG_FILE), the environment and the given command-line args.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "config file (JSON or YAML)")
+	flagValues := map[string]string{}
+	for _, f := range settings() {
+		name := f.flag
+		fs.Func(name, f.usage, func(v string) error {
+			flagValues[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return cfg, err
+		}
+	}
+
+	v := reflect.ValueOf(&cfg).Elem()
+	for _, f := range settings() {
+		if raw, ok := os.LookupEnv(f.env); ok {
+			if err := setField(v.Field(f.index), raw); err != nil {
+				return cfg, fmt.Errorf("%s: %v", f.env, err)
+			}
+		}
+	}
+	cfg.applyComposeDB()
+	for _, f := range settings() {
+		if raw, ok := flagValues[f.flag]; ok {
+			if err := setField(v.Field(f.index), raw); err != nil {
+				return cfg, fmt.Errorf("-%s: %v", f.flag, err)
+			}
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	default:
+		err = json.Unmarshal(data, c)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// applyComposeDB builds a Postgres DSN from the DB_HOST/DB_USER/DB_PASSWORD/
+// DB_NAME variables set by docker-compose when no DSN was given.
+func (c *Config) applyComposeDB() {
+	host := os.Getenv("DB_HOST")
+	if c.DBDSN != "" || host == "" {
+		return
+	}
+	if os.Getenv("DB_DRIVER") == "" {
+		c.DBDriver = "postgres"
+	}
+	c.DBDSN = fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=disable",
+		host, os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"))
+}
+
+// Validate checks the configuration is usable before the server starts
+func (c Config) Validate() error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.ListenAddr == "" {
+		fail("listen_addr is required")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		fail("tls_cert_file and tls_key_file must be set together")
+	}
+	for _, f := range []string{c.TLSCertFile, c.TLSKeyFile, c.ThresholdsFile, c.PortHintsFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			fail("%v", err)
+		}
+	}
+	if c.UploadDir == "" {
+		fail("upload_dir is required")
+	}
+	if c.MaxUploadBytes <= 0 {
+		fail("max_upload_bytes must be positive")
+	}
+	if c.DBDriver != "sqlite" && c.DBDriver != "postgres" {
+		fail("db_driver must be sqlite or postgres, got %q", c.DBDriver)
+	}
+	if c.DBDriver == "postgres" && c.DBDSN == "" {
+		fail("db_dsn is required for postgres")
+	}
+	switch c.QueueBackend {
+	case "sqlite":
+	case "redis":
+		if c.RedisAddr == "" {
+			fail("redis_addr is required for the redis queue")
+		}
+	default:
+		fail("queue_backend must be sqlite or redis, got %q", c.QueueBackend)
+	}
+	if c.Workers < 1 {
+		fail("workers must be at least 1")
+	}
+	if c.TimeSeriesBucket < 0 {
+		fail("timeseries_bucket cannot be negative")
+	}
+	if c.RetentionMaxAge < 0 || c.RetentionMaxBytes < 0 {
+		fail("retention limits cannot be negative")
+	}
+	if c.RetentionInterval < Duration(time.Minute) {
+		fail("retention_interval must be at least 1m")
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			fail("cors origin %q must look like scheme://host[:port]", origin)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// TLSEnabled reports whether the server should listen with HTTPS
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// Public is the subset of the configuration safe to show in the UI.
+// Secrets (the DB DSN, Redis address) and file paths are left out.
+type Public struct {
+	ListenAddr        string   `json:"listen_addr"`
+	TLS               bool     `json:"tls"`
+	MaxUploadBytes    int64    `json:"max_upload_bytes"`
+	DBDriver          string   `json:"db_driver"`
+	QueueBackend      string   `json:"queue_backend"`
+	Workers           int      `json:"workers"`
+	CORSOrigins       []string `json:"cors_origins"`
+	ThresholdsProfile string   `json:"thresholds_profile"`
+	RetentionMaxAge   string   `json:"retention_max_age"`
+	RetentionMaxBytes int64    `json:"retention_max_bytes"`
+	RetentionInterval string   `json:"retention_interval"`
+}
+
+func (c Config) Public() Public {
+	profile := "default"
+	if c.ThresholdsFile != "" {
+		profile = filepath.Base(c.ThresholdsFile)
+	}
+	maxAge := ""
+	if c.RetentionMaxAge > 0 {
+		maxAge = time.Duration(c.RetentionMaxAge).String()
+	}
+	return Public{
+		ListenAddr:        c.ListenAddr,
+		TLS:               c.TLSEnabled(),
+		MaxUploadBytes:    c.MaxUploadBytes,
+		DBDriver:          c.DBDriver,
+		QueueBackend:      c.QueueBackend,
+		Workers:           c.Workers,
+		CORSOrigins:       c.CORSOrigins,
+		ThresholdsProfile: profile,
+		RetentionMaxAge:   maxAge,
+		RetentionMaxBytes: c.RetentionMaxBytes,
+		RetentionInterval: time.Duration(c.RetentionInterval).String(),
+	}
+}
+
+// setting describes one Config field's env and flag names
+type setting struct {
+	index int
+	env   string
+	flag  string
+	usage string
+}
+
+func settings() []setting {
+	t := reflect.TypeOf(Config{})
+	out := make([]setting, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		out = append(out, setting{
+			index: i,
+			env:   f.Tag.Get("env"),
+			flag:  f.Tag.Get("flag"),
+			usage: f.Tag.Get("usage"),
+		})
+	}
+	return out
+}
+
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
@@ -1,8 +1,9 @@
 package db
 
 import (
+	"fmt"
 	"log"
-	"pcap-analyzer/internal/model"
+	"strings"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
@@ -10,19 +11,62 @@ import (
 
 var DB *gorm.DB
 
-func InitDB() {
-	var err error
-	// Use a local file 'pcap.db' for persistence with WAL mode for better concurrency
-	DB, err = gorm.Open(sqlite.Open("pcap.db?_journal_mode=WAL"), &gorm.Config{})
+// DefaultSQLiteDSN keeps a local 'pcap.db' file with WAL mode for better
+// concurrency and foreign keys enforced so deletes cascade.
+const DefaultSQLiteDSN = "pcap.db?_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+
+// dialectors maps a driver name to its GORM dialector. Postgres registers
+// itself from postgres.go when built with -tags postgres.
+var dialectors = map[string]func(dsn string) gorm.Dialector{
+	"sqlite": sqlite.Open,
+}
+
+// Open connects to the database and applies pending migrations
+func Open(driver, dsn string) error {
+	conn, err := Connect(driver, dsn)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return err
+	}
+	if err := Migrate(conn); err != nil {
+		return fmt.Errorf("migrating database: %v", err)
 	}
 
-	// Auto Migrate the schema
-	err = DB.AutoMigrate(&model.Analysis{}, &model.Stream{}, &model.Packet{})
+	DB = conn
+	log.Printf("Database initialized (%s) and migrated successfully.", driver)
+	return nil
+}
+
+// Connect opens a connection without touching the schema
+func Connect(driver, dsn string) (*gorm.DB, error) {
+	open, ok := dialectors[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver %q (postgres requires building with -tags postgres)", driver)
+	}
+	if driver == "sqlite" {
+		dsn = sqliteDSN(dsn)
+	}
+
+	conn, err := gorm.Open(open(dsn), &gorm.Config{})
 	if err != nil {
-		log.Fatal("Failed to migrate database:", err)
+		return nil, fmt.Errorf("connecting to %s: %v", driver, err)
 	}
+	return conn, nil
+}
 
-	log.Println("Database initialized and migrated successfully.")
+// sqliteDSN defaults an empty DSN and enforces foreign keys on any other:
+// SQLite leaves them off per connection unless asked, and deletes rely on
+// the schema's cascades. A later pragma wins, so this overrides
+// foreign_keys(0) too.
+func sqliteDSN(dsn string) string {
+	if dsn == "" {
+		return DefaultSQLiteDSN
+	}
+	if strings.Contains(dsn, "_pragma=foreign_keys(1)") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "_pragma=foreign_keys(1)"
 }
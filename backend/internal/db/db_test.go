@@ -0,0 +1,53 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"pcap-analyzer/internal/model"
+)
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		dsn, want string
+	}{
+		{"", DefaultSQLiteDSN},
+		{"a.db", "a.db?_pragma=foreign_keys(1)"},
+		{"a.db?_pragma=busy_timeout(5000)", "a.db?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"},
+		{"a.db?_pragma=foreign_keys(0)", "a.db?_pragma=foreign_keys(0)&_pragma=foreign_keys(1)"},
+		{"a.db?_pragma=foreign_keys(1)", "a.db?_pragma=foreign_keys(1)"},
+	}
+	for _, tt := range tests {
+		if got := sqliteDSN(tt.dsn); got != tt.want {
+			t.Errorf("sqliteDSN(%q) = %q, want %q", tt.dsn, got, tt.want)
+		}
+	}
+}
+
+// A DSN without the pragma still gets cascading deletes
+func TestSQLiteCascade(t *testing.T) {
+	conn, err := Connect("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Create(&model.Analysis{ID: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Create(&model.Stream{ID: "s", AnalysisID: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Where("id = ?", "a").Delete(&model.Analysis{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var streams int64
+	conn.Model(&model.Stream{}).Count(&streams)
+	if streams != 0 {
+		t.Errorf("%d orphan streams left", streams)
+	}
+	if err := conn.Create(&model.Stream{ID: "orphan", AnalysisID: "missing"}).Error; err == nil {
+		t.Error("stream without an analysis inserted")
+	}
+}
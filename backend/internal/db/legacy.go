@@ -0,0 +1,86 @@
+package db
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Databases created before versioned migrations were built by AutoMigrate.
+// adoptLegacySchema brings such a database up to the shape of migration 1
+// (without its foreign keys, which SQLite can't add to existing tables) and
+// records it as applied. The structs below are frozen copies of the v1 models.
+
+type v1Analysis struct {
+	ID        string `gorm:"primaryKey"`
+	Name      string
+	Tags      string
+	Notes     string
+	FileName  string
+	FilePath  string
+	FileSize  int64
+	Status    string `gorm:"index"`
+	Progress  int
+	CreatedAt time.Time `gorm:"index"`
+	Summary   string
+	Error     string
+}
+
+type v1Stream struct {
+	ID                  string `gorm:"primaryKey"`
+	StreamHash          string `gorm:"index"`
+	AnalysisID          string `gorm:"index"`
+	ClientIP            string
+	ServerIP            string
+	ServerPort          uint16
+	Protocol            string
+	Severity            string
+	PacketCount         int
+	RetransmissionCount int
+	ResetCount          int
+	HasTimeout          bool
+	AnalysisIssues      string
+	StartTime           float64
+	EndTime             float64
+}
+
+type v1Packet struct {
+	ID         uint   `gorm:"primaryKey"`
+	StreamID   string `gorm:"index"`
+	Timestamp  time.Time
+	SrcIP      string
+	DstIP      string
+	Seq        uint32
+	Ack        uint32
+	Flags      string
+	PayloadLen int
+	WindowSize int
+	Payload    []byte
+}
+
+type v1Job struct {
+	ID          string `gorm:"primaryKey"`
+	FilePath    string
+	Priority    int
+	Status      string `gorm:"index"`
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	RunAt       time.Time `gorm:"index"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (v1Analysis) TableName() string { return "analyses" }
+func (v1Stream) TableName() string   { return "streams" }
+func (v1Packet) TableName() string   { return "packets" }
+func (v1Job) TableName() string      { return "jobs" }
+
+func adoptLegacySchema(conn *gorm.DB) error {
+	log.Println("Adopting database created before versioned migrations")
+	if err := conn.AutoMigrate(&v1Analysis{}, &v1Stream{}, &v1Packet{}, &v1Job{}); err != nil {
+		return err
+	}
+	return conn.Create(&schemaMigration{Version: 1, Name: migrations[0].Name + " (adopted)", AppliedAt: time.Now()}).Error
+}
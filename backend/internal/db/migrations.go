@@ -0,0 +1,420 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one versioned schema change. Statements may use {{type}}
+// placeholders (see dialectTypes) so one migration serves every driver.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+var dialectTypes = map[string]map[string]string{
+	"sqlite": {
+		"pk_auto":   "INTEGER PRIMARY KEY AUTOINCREMENT",
+		"bigint":    "INTEGER",
+		"float":     "REAL",
+		"blob":      "BLOB",
+		"timestamp": "DATETIME",
+	},
+	"postgres": {
+		"pk_auto":   "BIGSERIAL PRIMARY KEY",
+		"bigint":    "BIGINT",
+		"float":     "DOUBLE PRECISION",
+		"blob":      "BYTEA",
+		"timestamp": "TIMESTAMPTZ",
+	},
+}
+
+// migrations must stay sorted by version; never edit one that has shipped
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS analyses (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL DEFAULT '',
+				tags TEXT NOT NULL DEFAULT '[]',
+				notes TEXT NOT NULL DEFAULT '',
+				file_name TEXT NOT NULL DEFAULT '',
+				file_path TEXT NOT NULL DEFAULT '',
+				file_size {{bigint}} NOT NULL DEFAULT 0,
+				status TEXT NOT NULL DEFAULT '',
+				progress INTEGER NOT NULL DEFAULT 0,
+				created_at {{timestamp}},
+				summary TEXT NOT NULL DEFAULT '',
+				error TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_analyses_created_at ON analyses (created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_analyses_status ON analyses (status)`,
+			`CREATE TABLE IF NOT EXISTS streams (
+				id TEXT PRIMARY KEY,
+				stream_hash TEXT NOT NULL DEFAULT '',
+				analysis_id TEXT NOT NULL REFERENCES analyses (id) ON DELETE CASCADE,
+				client_ip TEXT NOT NULL DEFAULT '',
+				server_ip TEXT NOT NULL DEFAULT '',
+				server_port INTEGER NOT NULL DEFAULT 0,
+				protocol TEXT NOT NULL DEFAULT '',
+				severity TEXT NOT NULL DEFAULT '',
+				packet_count INTEGER NOT NULL DEFAULT 0,
+				retransmission_count INTEGER NOT NULL DEFAULT 0,
+				reset_count INTEGER NOT NULL DEFAULT 0,
+				has_timeout BOOLEAN NOT NULL DEFAULT FALSE,
+				analysis_issues TEXT NOT NULL DEFAULT '',
+				start_time {{float}} NOT NULL DEFAULT 0,
+				end_time {{float}} NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_streams_analysis_id ON streams (analysis_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_streams_analysis_severity ON streams (analysis_id, severity)`,
+			`CREATE INDEX IF NOT EXISTS idx_streams_stream_hash ON streams (stream_hash)`,
+			`CREATE TABLE IF NOT EXISTS packets (
+				id {{pk_auto}},
+				stream_id TEXT NOT NULL REFERENCES streams (id) ON DELETE CASCADE,
+				timestamp {{timestamp}},
+				src_ip TEXT NOT NULL DEFAULT '',
+				dst_ip TEXT NOT NULL DEFAULT '',
+				seq {{bigint}} NOT NULL DEFAULT 0,
+				ack {{bigint}} NOT NULL DEFAULT 0,
+				flags TEXT NOT NULL DEFAULT '',
+				payload_len INTEGER NOT NULL DEFAULT 0,
+				window_size INTEGER NOT NULL DEFAULT 0,
+				payload {{blob}}
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_packets_stream_id ON packets (stream_id, timestamp)`,
+			`CREATE TABLE IF NOT EXISTS jobs (
+				id TEXT PRIMARY KEY REFERENCES analyses (id) ON DELETE CASCADE,
+				file_path TEXT NOT NULL DEFAULT '',
+				priority INTEGER NOT NULL DEFAULT 0,
+				status TEXT NOT NULL DEFAULT '',
+				attempts INTEGER NOT NULL DEFAULT 0,
+				max_attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				run_at {{timestamp}},
+				created_at {{timestamp}},
+				updated_at {{timestamp}}
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs (status, run_at)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS jobs`,
+			`DROP TABLE IF EXISTS packets`,
+			`DROP TABLE IF EXISTS streams`,
+			`DROP TABLE IF EXISTS analyses`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "application transactions",
+		Up: []string{
+			`CREATE TABLE transactions (
+				id {{pk_auto}},
+				analysis_id TEXT NOT NULL REFERENCES analyses (id) ON DELETE CASCADE,
+				stream_id TEXT NOT NULL REFERENCES streams (id) ON DELETE CASCADE,
+				protocol TEXT NOT NULL DEFAULT '',
+				method TEXT NOT NULL DEFAULT '',
+				target TEXT NOT NULL DEFAULT '',
+				host TEXT NOT NULL DEFAULT '',
+				status INTEGER NOT NULL DEFAULT 0,
+				status_text TEXT NOT NULL DEFAULT '',
+				request_time {{timestamp}},
+				latency_ms {{float}} NOT NULL DEFAULT 0,
+				request_bytes {{bigint}} NOT NULL DEFAULT 0,
+				response_bytes {{bigint}} NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				attributes TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX idx_transactions_stream_id ON transactions (stream_id, request_time)`,
+			`CREATE INDEX idx_transactions_analysis_protocol ON transactions (analysis_id, protocol)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS transactions`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "stream transport and metrics",
+		Up: []string{
+			`ALTER TABLE streams ADD COLUMN transport TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE streams ADD COLUMN client_port INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE streams ADD COLUMN metrics TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE streams DROP COLUMN metrics`,
+			`ALTER TABLE streams DROP COLUMN client_port`,
+			`ALTER TABLE streams DROP COLUMN transport`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "voip calls",
+		Up: []string{
+			`CREATE TABLE calls (
+				id {{pk_auto}},
+				analysis_id TEXT NOT NULL REFERENCES analyses (id) ON DELETE CASCADE,
+				stream_id TEXT NOT NULL DEFAULT '',
+				call_id TEXT NOT NULL DEFAULT '',
+				from_uri TEXT NOT NULL DEFAULT '',
+				to_uri TEXT NOT NULL DEFAULT '',
+				state TEXT NOT NULL DEFAULT '',
+				final_status INTEGER NOT NULL DEFAULT 0,
+				invite_time {{timestamp}},
+				setup_ms {{float}} NOT NULL DEFAULT 0,
+				duration_sec {{float}} NOT NULL DEFAULT 0,
+				codec TEXT NOT NULL DEFAULT '',
+				caller_media TEXT NOT NULL DEFAULT '',
+				callee_media TEXT NOT NULL DEFAULT '',
+				mos {{float}} NOT NULL DEFAULT 0,
+				media TEXT NOT NULL DEFAULT '',
+				issues TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX idx_calls_analysis_id ON calls (analysis_id, invite_time)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS calls`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "tls key logs",
+		Up: []string{
+			`ALTER TABLE analyses ADD COLUMN keylog_path TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE analyses DROP COLUMN keylog_path`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "protocol classification",
+		Up: []string{
+			`ALTER TABLE streams ADD COLUMN protocol_confidence INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE streams ADD COLUMN protocol_source TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE streams DROP COLUMN protocol_source`,
+			`ALTER TABLE streams DROP COLUMN protocol_confidence`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "capture findings",
+		Up: []string{
+			`CREATE TABLE findings (
+				id {{pk_auto}},
+				analysis_id TEXT NOT NULL REFERENCES analyses (id) ON DELETE CASCADE,
+				severity TEXT NOT NULL DEFAULT '',
+				category TEXT NOT NULL DEFAULT '',
+				message TEXT NOT NULL DEFAULT '',
+				time {{timestamp}},
+				hosts TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX idx_findings_analysis_id ON findings (analysis_id, time)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS findings`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "capture stats",
+		Up: []string{
+			`CREATE TABLE capture_stats (
+				analysis_id TEXT PRIMARY KEY REFERENCES analyses (id) ON DELETE CASCADE,
+				start_time {{timestamp}},
+				bucket_ms INTEGER NOT NULL DEFAULT 0,
+				series TEXT NOT NULL DEFAULT '',
+				protocols TEXT NOT NULL DEFAULT '',
+				talkers TEXT NOT NULL DEFAULT '',
+				conversations TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS capture_stats`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "stream byte accounting",
+		Up: []string{
+			`ALTER TABLE streams ADD COLUMN total_bytes {{bigint}} NOT NULL DEFAULT 0`,
+			`ALTER TABLE streams ADD COLUMN payload_bytes {{bigint}} NOT NULL DEFAULT 0`,
+			`ALTER TABLE streams ADD COLUMN goodput_bytes {{bigint}} NOT NULL DEFAULT 0`,
+			`ALTER TABLE streams ADD COLUMN retransmitted_bytes {{bigint}} NOT NULL DEFAULT 0`,
+			`ALTER TABLE streams ADD COLUMN goodput_ratio {{float}} NOT NULL DEFAULT 1`,
+			`ALTER TABLE streams ADD COLUMN avg_throughput_bps {{float}} NOT NULL DEFAULT 0`,
+			`ALTER TABLE streams ADD COLUMN peak_throughput_bps {{float}} NOT NULL DEFAULT 0`,
+			`ALTER TABLE streams ADD COLUMN max_bytes_in_flight {{bigint}} NOT NULL DEFAULT 0`,
+			`ALTER TABLE streams ADD COLUMN directions TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE streams DROP COLUMN directions`,
+			`ALTER TABLE streams DROP COLUMN max_bytes_in_flight`,
+			`ALTER TABLE streams DROP COLUMN peak_throughput_bps`,
+			`ALTER TABLE streams DROP COLUMN avg_throughput_bps`,
+			`ALTER TABLE streams DROP COLUMN goodput_ratio`,
+			`ALTER TABLE streams DROP COLUMN retransmitted_bytes`,
+			`ALTER TABLE streams DROP COLUMN goodput_bytes`,
+			`ALTER TABLE streams DROP COLUMN payload_bytes`,
+			`ALTER TABLE streams DROP COLUMN total_bytes`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "packet tcp options",
+		Up:      []string{`ALTER TABLE packets ADD COLUMN options TEXT NOT NULL DEFAULT ''`},
+		Down:    []string{`ALTER TABLE packets DROP COLUMN options`},
+	},
+	{
+		Version: 11,
+		Name:    "middlebox evidence",
+		Up: []string{
+			`ALTER TABLE packets ADD COLUMN ttl INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE packets ADD COLUMN ip_id INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE findings ADD COLUMN evidence TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE findings DROP COLUMN evidence`,
+			`ALTER TABLE packets DROP COLUMN ip_id`,
+			`ALTER TABLE packets DROP COLUMN ttl`,
+		},
+	},
+}
+
+// schemaMigration records an applied version
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+// Migrate applies all pending migrations, each in its own transaction
+func Migrate(conn *gorm.DB) error {
+	if err := prepareMigrations(conn); err != nil {
+		return err
+	}
+	applied, err := appliedVersions(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := execAll(tx, m.Up); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %v", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %d: %s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// MigrateDown rolls back applied migrations newer than target, newest first
+func MigrateDown(conn *gorm.DB, target int) error {
+	if err := prepareMigrations(conn); err != nil {
+		return err
+	}
+	applied, err := appliedVersions(conn)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target || !applied[m.Version] {
+			continue
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := execAll(tx, m.Down); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rolling back migration %d (%s): %v", m.Version, m.Name, err)
+		}
+		log.Printf("Rolled back migration %d: %s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// SchemaVersion returns the highest applied migration version
+func SchemaVersion(conn *gorm.DB) (int, error) {
+	if !conn.Migrator().HasTable(&schemaMigration{}) {
+		return 0, nil
+	}
+	applied, err := appliedVersions(conn)
+	if err != nil {
+		return 0, err
+	}
+	versions := make([]int, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	sort.Ints(versions)
+	return versions[len(versions)-1], nil
+}
+
+func prepareMigrations(conn *gorm.DB) error {
+	if _, ok := dialectTypes[conn.Dialector.Name()]; !ok {
+		return fmt.Errorf("no migration types for dialect %q", conn.Dialector.Name())
+	}
+	legacy := !conn.Migrator().HasTable(&schemaMigration{}) && conn.Migrator().HasTable("analyses")
+	if err := conn.AutoMigrate(&schemaMigration{}); err != nil {
+		return err
+	}
+	if legacy {
+		return adoptLegacySchema(conn)
+	}
+	return nil
+}
+
+func appliedVersions(conn *gorm.DB) (map[int]bool, error) {
+	var rows []schemaMigration
+	if err := conn.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = true
+	}
+	return applied, nil
+}
+
+func execAll(tx *gorm.DB, stmts []string) error {
+	types := dialectTypes[tx.Dialector.Name()]
+	pairs := make([]string, 0, len(types)*2)
+	for k, v := range types {
+		pairs = append(pairs, "{{"+k+"}}", v)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	for _, stmt := range stmts {
+		if err := tx.Exec(replacer.Replace(stmt)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+//go:build integration
+
+package db
+
+// Run with:
+//
+//	go test -tags integration ./internal/db/
+//	TEST_POSTGRES_DSN="host=localhost user=user password=password dbname=pcapdb_test sslmode=disable" \
+//	    go test -tags "integration postgres" ./internal/db/
+//
+// The Postgres case is skipped unless the driver is compiled in and
+// TEST_POSTGRES_DSN points at a database the test may wipe.
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"pcap-analyzer/internal/model"
+)
+
+func testDatabases(t *testing.T) map[string]*gorm.DB {
+	dbs := map[string]*gorm.DB{}
+
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_pragma=foreign_keys(1)"
+	conn, err := Connect("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("sqlite: %v", err)
+	}
+	dbs["sqlite"] = conn
+
+	if _, ok := dialectors["postgres"]; ok && os.Getenv("TEST_POSTGRES_DSN") != "" {
+		conn, err := Connect("postgres", os.Getenv("TEST_POSTGRES_DSN"))
+		if err != nil {
+			t.Fatalf("postgres: %v", err)
+		}
+		if err := MigrateDown(conn, 0); err != nil {
+			t.Fatalf("postgres: resetting schema: %v", err)
+		}
+		dbs["postgres"] = conn
+	}
+	return dbs
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	for name, conn := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := Migrate(conn); err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+			latest := migrations[len(migrations)-1].Version
+			if v, _ := SchemaVersion(conn); v != latest {
+				t.Fatalf("schema version = %d, want %d", v, latest)
+			}
+			for _, table := range []string{"analyses", "streams", "packets", "jobs"} {
+				if !conn.Migrator().HasTable(table) {
+					t.Errorf("table %s missing after migrate", table)
+				}
+			}
+			if !conn.Migrator().HasIndex("streams", "idx_streams_analysis_severity") {
+				t.Errorf("severity index missing")
+			}
+
+			// Re-running is a no-op
+			if err := Migrate(conn); err != nil {
+				t.Fatalf("second Migrate: %v", err)
+			}
+
+			if err := MigrateDown(conn, 0); err != nil {
+				t.Fatalf("MigrateDown: %v", err)
+			}
+			if conn.Migrator().HasTable("analyses") {
+				t.Errorf("analyses still present after rollback")
+			}
+			if v, _ := SchemaVersion(conn); v != 0 {
+				t.Errorf("schema version after rollback = %d, want 0", v)
+			}
+		})
+	}
+}
+
+func TestDeleteCascades(t *testing.T) {
+	for name, conn := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := Migrate(conn); err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+			defer MigrateDown(conn, 0)
+
+			analysis := model.Analysis{ID: "a1", Status: "complete", Tags: "[]", CreatedAt: time.Now()}
+			stream := model.Stream{ID: "s1", AnalysisID: "a1", Severity: "warning"}
+			packet := model.Packet{StreamID: "s1", Timestamp: time.Now(), Payload: []byte{1, 2, 3}}
+			if err := conn.Create(&analysis).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.Omit("Packets").Create(&stream).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.Create(&packet).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.Create(&model.Job{ID: "a1", Status: "done", RunAt: time.Now()}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			if err := conn.Where("id = ?", "a1").Delete(&model.Analysis{}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			for _, m := range []interface{}{&model.Stream{}, &model.Packet{}, &model.Job{}} {
+				var count int64
+				conn.Model(m).Count(&count)
+				if count != 0 {
+					t.Errorf("%T: %d rows left after deleting analysis", m, count)
+				}
+			}
+		})
+	}
+}
+
+func TestForeignKeyRejectsOrphans(t *testing.T) {
+	for name, conn := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := Migrate(conn); err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+			defer MigrateDown(conn, 0)
+
+			err := conn.Omit("Packets").Create(&model.Stream{ID: "s1", AnalysisID: "missing"}).Error
+			if err == nil {
+				t.Errorf("stream with unknown analysis_id was accepted")
+			}
+		})
+	}
+}
+
+func TestAdoptLegacySchema(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "legacy.db")
+	conn, err := Connect("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pre-migration schema: AutoMigrate of the original three models
+	type legacyAnalysis struct {
+		ID        string `gorm:"primaryKey"`
+		Status    string
+		Progress  int
+		CreatedAt time.Time
+		Summary   string
+		Error     string
+	}
+	if err := conn.Table("analyses").AutoMigrate(&legacyAnalysis{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Table("analyses").Create(&legacyAnalysis{ID: "old", Status: "complete"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(conn); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	var analysis model.Analysis
+	if err := conn.Where("id = ?", "old").First(&analysis).Error; err != nil {
+		t.Fatalf("legacy row unreadable after adoption: %v", err)
+	}
+	if !conn.Migrator().HasColumn("analyses", "file_size") {
+		t.Errorf("file_size column not added to legacy table")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build postgres
+
+package db
+
+// The Postgres driver is opt-in to keep the default binary free of pgx.
+// Build with:
+//
+//	go build -tags postgres ./cmd/server
+
+import (
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func init() {
+	dialectors["postgres"] = func(dsn string) gorm.Dialector {
+		return postgres.Open(dsn)
+	}
+}
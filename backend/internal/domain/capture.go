@@ -0,0 +1,104 @@
+package domain
+
+import "time"
+
+// CaptureReport holds the results of analysis that spans streams
+type CaptureReport struct {
+	Calls    []*Call       `json:"calls"`
+	Findings []*Finding    `json:"findings"`
+	Stats    *CaptureStats `json:"stats"`
+}
+
+// CaptureStats are capture-wide traffic totals: a time series bucketed at
+// a fixed width, the protocol mix and the busiest hosts and host pairs
+type CaptureStats struct {
+	Start         time.Time        `json:"start"`
+	Bucket        time.Duration    `json:"bucket"`
+	Series        []*TimeBucket    `json:"series"`
+	Protocols     []*ProtocolShare `json:"protocols"`
+	Talkers       []*Talker        `json:"talkers"`
+	Conversations []*Conversation  `json:"conversations"`
+}
+
+// TimeBucket counts the traffic of one interval of the capture
+type TimeBucket struct {
+	Time            time.Time        `json:"time"`
+	Packets         int              `json:"packets"`
+	Bytes           int64            `json:"bytes"`
+	NewConnections  int              `json:"new_connections"` // TCP SYNs without ACK
+	Resets          int              `json:"resets"`
+	Retransmissions int              `json:"retransmissions"`
+	Protocols       map[string]int64 `json:"protocols"` // bytes per stream protocol
+}
+
+// ProtocolShare is one protocol's part of the capture
+type ProtocolShare struct {
+	Protocol string `json:"protocol"`
+	Streams  int    `json:"streams"`
+	Packets  int    `json:"packets"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Talker is the traffic one address sent and received
+type Talker struct {
+	IP            string `json:"ip"`
+	Packets       int    `json:"packets"`
+	BytesSent     int64  `json:"bytes_sent"`
+	BytesReceived int64  `json:"bytes_received"`
+	Streams       int    `json:"streams"`
+}
+
+// Conversation is the traffic between two addresses over all their streams
+type Conversation struct {
+	AddressA  string    `json:"address_a"`
+	AddressB  string    `json:"address_b"`
+	Packets   int       `json:"packets"`
+	BytesAtoB int64     `json:"bytes_a_to_b"`
+	BytesBtoA int64     `json:"bytes_b_to_a"`
+	Streams   int       `json:"streams"`
+	Protocols []string  `json:"protocols"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+}
+
+// Finding is an issue reported for the capture as a whole, such as an
+// address conflict, a DHCP server not answering or a middlebox interfering
+// with connections
+type Finding struct {
+	Severity Severity    `json:"severity"`
+	Category string      `json:"category"` // "ARP", "DHCP", "DHCPv6", "Middlebox" or "Timeout"
+	Message  string      `json:"message"`
+	Time     time.Time   `json:"time"`               // first packet involved
+	Hosts    []string    `json:"hosts,omitempty"`    // IP and MAC addresses involved
+	Evidence []*Evidence `json:"evidence,omitempty"` // packets behind the finding
+}
+
+// Evidence is a packet of a stream that backs a finding
+type Evidence struct {
+	Stream  string    `json:"stream"` // stream ID
+	Packet  int       `json:"packet"` // position in the stream, from 0
+	Time    time.Time `json:"time"`
+	Summary string    `json:"summary"` // addresses, flags and the header fields that matter
+}
+
+// ARPPacket is an ARP request or reply
+type ARPPacket struct {
+	Timestamp time.Time
+	Length    int
+	Operation uint16 // 1 request, 2 reply
+	SrcMAC    string // Ethernet source, may differ from SenderMAC when proxied
+	SenderMAC string
+	SenderIP  string
+	TargetMAC string
+	TargetIP  string
+}
+
+// IsGratuitous reports an announcement of the sender's own address
+func (a *ARPPacket) IsGratuitous() bool {
+	return a.SenderIP == a.TargetIP && a.SenderIP != "0.0.0.0"
+}
+
+// IsProbe reports an RFC 5227 probe, sent before using an address
+func (a *ARPPacket) IsProbe() bool {
+	return a.Operation == 1 && a.SenderIP == "0.0.0.0"
+}
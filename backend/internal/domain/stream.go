@@ -2,6 +2,7 @@ package domain
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -20,15 +21,57 @@ type Stream struct {
 	ServerIP   string   `json:"server_ip"`
 	ClientPort uint16   `json:"client_port"`
 	ServerPort uint16   `json:"server_port"`
+	Transport  string   `json:"transport"` // "TCP", "UDP", "ICMP" or "ICMPv6"
 	Protocol   string   `json:"protocol"`
 	Severity   Severity `json:"severity"`
 
+	// ProtocolConfidence scores the Protocol label from 0 to 100 and
+	// ProtocolSource names its evidence: "signature", "heuristic", "port"
+	// or "dissector"
+	ProtocolConfidence int    `json:"protocol_confidence"`
+	ProtocolSource     string `json:"protocol_source,omitempty"`
+
 	ClientMSS uint16 `json:"client_mss"`
 	ServerMSS uint16 `json:"server_mss"`
 
-	Packets  []*PacketMeta `json:"packets,omitempty"`
-	Stats    StreamStats   `json:"stats"`
-	Analysis []string      `json:"analysis"`
+	Packets      []*PacketMeta  `json:"packets,omitempty"`
+	Stats        StreamStats    `json:"stats"`
+	Metrics      StreamMetrics  `json:"metrics"`
+	Analysis     []string       `json:"analysis"`
+	Transactions []*Transaction `json:"transactions,omitempty"`
+	ICMPEvents   []*ICMPEvent   `json:"icmp_events,omitempty"`
+}
+
+// Transaction is one application-layer request/response exchange decoded
+// from a stream (an HTTP request, a DNS query, a SQL statement...).
+type Transaction struct {
+	Protocol      string            `json:"protocol"`
+	Method        string            `json:"method"` // method, command or opcode
+	Target        string            `json:"target"` // URI, query name, statement...
+	Host          string            `json:"host,omitempty"`
+	Status        int               `json:"status"` // protocol status/error code, 0 if none
+	StatusText    string            `json:"status_text,omitempty"`
+	RequestTime   time.Time         `json:"request_time"`
+	ResponseTime  time.Time         `json:"response_time"` // zero if unanswered
+	Latency       time.Duration     `json:"latency"`
+	RequestBytes  int64             `json:"request_bytes"`
+	ResponseBytes int64             `json:"response_bytes"`
+	Error         string            `json:"error,omitempty"` // e.g. "closed mid-response"
+	Attributes    map[string]string `json:"attributes,omitempty"`
+}
+
+// ICMPEvent is an ICMP or ICMPv6 message about a stream. Errors are linked
+// to the stream through the packet header quoted in the message.
+type ICMPEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Version   int       `json:"version"` // 4 or 6
+	Type      uint8     `json:"type"`
+	Code      uint8     `json:"code"`
+	Kind      string    `json:"kind"`     // e.g. "fragmentation-needed", "port-unreachable"
+	Reporter  string    `json:"reporter"` // address that sent the message
+	MTU       int       `json:"mtu,omitempty"`
+	Gateway   string    `json:"gateway,omitempty"`
+	Original  string    `json:"original,omitempty"` // quoted flow, "src:port > dst:port/proto"
 }
 
 // StreamStats holds aggregate metrics
@@ -39,13 +82,121 @@ type StreamStats struct {
 	PacketCount         int           `json:"packet_count"`
 	RetransmissionCount int           `json:"retransmission_count"`
 	ResetCount          int           `json:"reset_count"`
-	HasTimeout          bool          `json:"has_timeout"`
+	HasTimeout          bool          `json:"has_timeout"` // closed by an idle timeout other connections to the server share
+
+	// IdleBeforeClose is the silence before the first RST or FIN, when long
+	// enough to be an idle timeout; CloseFlag is the flag that ended it
+	IdleBeforeClose time.Duration `json:"idle_before_close,omitempty"`
+	CloseFlag       string        `json:"close_flag,omitempty"`
+
+	ClientToServer DirectionStats `json:"client_to_server"`
+	ServerToClient DirectionStats `json:"server_to_client"`
+}
+
+// DirectionStats counts what one side of a stream sent. Throughput is
+// payload bits per second; bytes in flight are only estimated for TCP.
+type DirectionStats struct {
+	Packets            int     `json:"packets"`
+	WireBytes          int64   `json:"wire_bytes"`    // frames as on the wire
+	PayloadBytes       int64   `json:"payload_bytes"` // transport payload, retransmissions included
+	GoodputBytes       int64   `json:"goodput_bytes"` // payload sent for the first time
+	RetransmittedBytes int64   `json:"retransmitted_bytes"`
+	AvgThroughputBps   float64 `json:"avg_throughput_bps"`  // over the stream duration
+	PeakThroughputBps  float64 `json:"peak_throughput_bps"` // busiest one-second window
+	MaxBytesInFlight   int64   `json:"max_bytes_in_flight"` // sent and not yet acknowledged
+	AvgBytesInFlight   float64 `json:"avg_bytes_in_flight"` // at each data segment
+}
+
+// GoodputRatio is the share of the payload of both directions that was not
+// retransmitted, 1 for a stream without payload
+func (s *StreamStats) GoodputRatio() float64 {
+	payload := s.ClientToServer.PayloadBytes + s.ServerToClient.PayloadBytes
+	if payload == 0 {
+		return 1
+	}
+	return float64(s.ClientToServer.GoodputBytes+s.ServerToClient.GoodputBytes) / float64(payload)
+}
+
+// StreamMetrics holds transport-specific measurements beyond StreamStats
+type StreamMetrics struct {
+	TCP  *TCPMetrics   `json:"tcp,omitempty"`
+	UDP  *UDPMetrics   `json:"udp,omitempty"`
+	RTP  []*RTPStats   `json:"rtp,omitempty"`
+	RTCP []*RTCPReport `json:"rtcp,omitempty"`
+}
+
+// TCPMetrics describes the negotiated options and the congestion control
+// of both TCP senders
+type TCPMetrics struct {
+	RTT    time.Duration      `json:"rtt"` // handshake round trip, 0 if not captured
+	Events []*CongestionEvent `json:"events,omitempty"`
+
+	ClientOptions *TCPOptions `json:"client_options,omitempty"` // of the client's SYN
+	ServerOptions *TCPOptions `json:"server_options,omitempty"` // of the server's SYN-ACK
+	ECN           bool        `json:"ecn"`                      // negotiated in the handshake
+	SACKs         int         `json:"sacks,omitempty"`          // segments carrying SACK blocks
+	DSACKs        int         `json:"dsacks,omitempty"`         // of those, reporting duplicates
+	CEMarks       int         `json:"ce_marks,omitempty"`       // segments marked Congestion Experienced
+}
+
+// CongestionEvent is a period of one sender's congestion control, such as
+// slow start or the recovery from a loss
+type CongestionEvent struct {
+	Kind         string    `json:"kind"`      // "slow-start", "fast-recovery", "rto", "cwnd-collapse" or "app-limited"
+	Direction    string    `json:"direction"` // "client_to_server" or "server_to_client"
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	FlightBefore int64     `json:"flight_before,omitempty"` // bytes in flight when the period began
+	FlightAfter  int64     `json:"flight_after,omitempty"`  // bytes in flight when it ended
+}
+
+// FlightPoint is a data segment one side of a TCP stream sent, as plotted
+// on a time-sequence (Stevens) or tcptrace graph. Sequence numbers are
+// relative to the sender's first one.
+type FlightPoint struct {
+	Time           time.Time `json:"time"`
+	Seq            int64     `json:"seq"`
+	Length         int       `json:"length"`
+	Acked          int64     `json:"acked"`  // highest acknowledgement from the peer
+	Window         int       `json:"window"` // peer's last advertised window, scaled
+	BytesInFlight  int64     `json:"bytes_in_flight"`
+	Retransmission bool      `json:"retransmission,omitempty"`
+}
+
+// UDPMetrics describes a UDP flow in both directions
+type UDPMetrics struct {
+	ClientToServer UDPDirection `json:"client_to_server"`
+	ServerToClient UDPDirection `json:"server_to_client"`
+	Unidirectional bool         `json:"unidirectional"`
+}
+
+// UDPDirection holds the rates and timing of one direction of a UDP flow.
+// Loss fields are only filled when a sequence extractor recognised the payload.
+type UDPDirection struct {
+	Packets            int     `json:"packets"`
+	Bytes              int64   `json:"bytes"`
+	PacketsPerSec      float64 `json:"packets_per_sec"`
+	BytesPerSec        float64 `json:"bytes_per_sec"`
+	MeanInterArrivalMs float64 `json:"mean_inter_arrival_ms"`
+	JitterMs           float64 `json:"jitter_ms"` // mean inter-arrival variation
+	MaxGapMs           float64 `json:"max_gap_ms"`
+	Burstiness         float64 `json:"burstiness"` // peak / mean packets per 100ms, 1 = smooth
+
+	SequenceSource string  `json:"sequence_source,omitempty"` // extractor name
+	Expected       int     `json:"expected,omitempty"`
+	Lost           int     `json:"lost,omitempty"`
+	Duplicates     int     `json:"duplicates,omitempty"`
+	Reordered      int     `json:"reordered,omitempty"`
+	LossPercent    float64 `json:"loss_percent,omitempty"`
 }
 
 type PacketMeta struct {
 	Timestamp  time.Time
+	Length     int // frame length on the wire
 	SrcIP      string
 	DstIP      string
+	SrcPort    uint16
+	DstPort    uint16
 	Seq        uint32
 	Ack        uint32
 	Flags      []string
@@ -53,6 +204,75 @@ type PacketMeta struct {
 	Payload    []byte
 	IsRetrans  bool
 	Window     uint16
+	ECN        uint8       // IP ECN codepoint: 0 Not-ECT, 1 ECT(1), 2 ECT(0), 3 CE
+	TTL        uint8       // IPv4 TTL or IPv6 hop limit
+	IPID       uint16      // IPv4 identification, 0 for IPv6
+	Options    *TCPOptions // nil when the segment has none of the options parsed
+}
+
+// ECN codepoints of the IP header
+const (
+	ECNNotECT = 0
+	ECNECT1   = 1
+	ECNECT0   = 2
+	ECNCE     = 3
+)
+
+// TCPOptions are the TCP header options the analyzer understands
+type TCPOptions struct {
+	MSS            uint16      `json:"mss,omitempty"`
+	HasWindowScale bool        `json:"has_window_scale,omitempty"`
+	WindowScale    uint8       `json:"window_scale,omitempty"` // shift count
+	SACKPermitted  bool        `json:"sack_permitted,omitempty"`
+	SACK           []SACKBlock `json:"sack,omitempty"`
+	HasTimestamps  bool        `json:"has_timestamps,omitempty"`
+	TSval          uint32      `json:"tsval,omitempty"`
+	TSecr          uint32      `json:"tsecr,omitempty"`
+	FastOpen       bool        `json:"fast_open,omitempty"`
+	FastOpenCookie []byte      `json:"fast_open_cookie,omitempty"` // empty in a cookie request
+}
+
+// SACKBlock is a range of sequence numbers received out of order
+type SACKBlock struct {
+	Left  uint32 `json:"left"`
+	Right uint32 `json:"right"` // first sequence number after the block
+}
+
+// String formats the options the way tcpdump does
+func (o *TCPOptions) String() string {
+	var parts []string
+	if o.MSS > 0 {
+		parts = append(parts, fmt.Sprintf("mss %d", o.MSS))
+	}
+	if o.SACKPermitted {
+		parts = append(parts, "sackOK")
+	}
+	if o.HasTimestamps {
+		parts = append(parts, fmt.Sprintf("TS val %d ecr %d", o.TSval, o.TSecr))
+	}
+	if o.HasWindowScale {
+		parts = append(parts, fmt.Sprintf("wscale %d", o.WindowScale))
+	}
+	if len(o.SACK) > 0 {
+		blocks := make([]string, len(o.SACK))
+		for i, b := range o.SACK {
+			blocks[i] = fmt.Sprintf("{%d:%d}", b.Left, b.Right)
+		}
+		parts = append(parts, fmt.Sprintf("sack %d %s", len(o.SACK), strings.Join(blocks, "")))
+	}
+	if o.FastOpen {
+		if len(o.FastOpenCookie) == 0 {
+			parts = append(parts, "tfo cookiereq")
+		} else {
+			parts = append(parts, fmt.Sprintf("tfo cookie %x", o.FastOpenCookie))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// FromClient reports whether pkt was sent by the stream's client side
+func (s *Stream) FromClient(pkt *PacketMeta) bool {
+	return pkt.SrcIP == s.ClientIP && pkt.SrcPort == s.ClientPort
 }
 
 // GenerateStreamID creates a consistent ID for the 5-tuple
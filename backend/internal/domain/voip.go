@@ -0,0 +1,65 @@
+package domain
+
+import "time"
+
+// RTPStats describes one RTP source (SSRC) seen in one direction of a stream
+type RTPStats struct {
+	SSRC        uint32    `json:"ssrc"`
+	PayloadType uint8     `json:"payload_type"`
+	Codec       string    `json:"codec,omitempty"`
+	ClockRate   int       `json:"clock_rate"`
+	Src         string    `json:"src"` // "ip:port"
+	Dst         string    `json:"dst"`
+	Stream      string    `json:"stream"` // stream (5-tuple) ID
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+
+	Packets      int     `json:"packets"`
+	Expected     int     `json:"expected"`
+	Lost         int     `json:"lost"`
+	LossPercent  float64 `json:"loss_percent"`
+	SequenceGaps int     `json:"sequence_gaps"` // runs of missing sequence numbers
+	MaxGap       int     `json:"max_gap"`       // longest run
+	Duplicates   int     `json:"duplicates"`
+	OutOfOrder   int     `json:"out_of_order"`
+	JitterMs     float64 `json:"jitter_ms"` // RFC 3550 estimate at the end of the stream
+	MaxJitterMs  float64 `json:"max_jitter_ms"`
+	MOS          float64 `json:"mos"`
+
+	// Reported by the receiver through RTCP, when captured
+	RemoteLossPercent float64 `json:"remote_loss_percent,omitempty"`
+	RemoteJitterMs    float64 `json:"remote_jitter_ms,omitempty"`
+
+	CallID    string `json:"call_id,omitempty"`
+	Direction string `json:"direction,omitempty"` // "caller->callee" or "callee->caller" once linked
+}
+
+// RTCPReport is one RTCP reception report block
+type RTCPReport struct {
+	Time           time.Time `json:"time"`
+	Reporter       uint32    `json:"reporter"`
+	Source         uint32    `json:"source"`
+	FractionLost   float64   `json:"fraction_lost"`
+	CumulativeLost int32     `json:"cumulative_lost"`
+	Jitter         uint32    `json:"jitter"` // timestamp units of the source
+}
+
+// Call is a SIP dialog with the RTP streams its SDP negotiated
+type Call struct {
+	CallID      string        `json:"call_id"`
+	From        string        `json:"from"`
+	To          string        `json:"to"`
+	State       string        `json:"state"` // "completed", "answered", "failed", "cancelled", "unanswered"
+	FinalStatus int           `json:"final_status"`
+	InviteTime  time.Time     `json:"invite_time"`
+	AnswerTime  time.Time     `json:"answer_time"`
+	EndTime     time.Time     `json:"end_time"`
+	SetupTime   time.Duration `json:"setup_time"`
+	Codec       string        `json:"codec,omitempty"`
+	CallerMedia string        `json:"caller_media,omitempty"` // "ip:port" offered by the caller
+	CalleeMedia string        `json:"callee_media,omitempty"`
+	Signaling   string        `json:"signaling"` // stream ID carrying the INVITE
+	Media       []*RTPStats   `json:"media"`
+	MOS         float64       `json:"mos"` // worst media stream, 0 if none
+	Issues      []string      `json:"issues"`
+}
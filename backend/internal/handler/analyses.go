@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+	"pcap-analyzer/internal/service/analyzer"
+	"pcap-analyzer/internal/service/classify"
+	"pcap-analyzer/internal/service/events"
+	"pcap-analyzer/internal/service/lifecycle"
+	"pcap-analyzer/internal/service/queue"
+)
+
+// Settings below are overridden from the server config at startup
+var (
+	// UploadDir is where uploaded captures are stored
+	UploadDir = "./uploads"
+	// MaxUploadBytes is the largest capture UploadHandler accepts
+	MaxUploadBytes int64 = 10 << 30
+	// Thresholds tune the analysis engine
+	Thresholds = analyzer.DefaultThresholds()
+	// PortHints override the protocol classifier's built-in port table
+	PortHints classify.PortHints
+	// TimeSeriesBucket is the capture time series resolution; zero picks
+	// one from the capture duration
+	TimeSeriesBucket time.Duration
+)
+
+const maxPageSize = 200
+
+// likeEscaper escapes the LIKE wildcards in user input, for patterns used
+// with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containing is a LIKE pattern matching s anywhere in a column
+func containing(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// ListAnalysesHandler returns past analyses, newest first.
+// Query params: q (name/notes/file name search), status, tag, page, page_size.
+func ListAnalysesHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if pageSize < 1 || pageSize > maxPageSize {
+		pageSize = 20
+	}
+
+	query := db.DB.Model(&model.Analysis{})
+	if q := c.Query("q"); q != "" {
+		like := containing(q)
+		query = query.Where(`name LIKE ? ESCAPE '\' OR notes LIKE ? ESCAPE '\' OR file_name LIKE ? ESCAPE '\' OR id = ?`, like, like, like, q)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if tag := c.Query("tag"); tag != "" {
+		// Tags are stored as a JSON array, so match the quoted element
+		tagJSON, _ := json.Marshal(tag)
+		query = query.Where(`tags LIKE ? ESCAPE '\'`, containing(string(tagJSON)))
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count analyses"})
+		return
+	}
+
+	var analyses []model.Analysis
+	if err := query.Omit("summary").
+		Order("created_at desc").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&analyses).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list analyses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":     analyses,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+type UpdateAnalysisRequest struct {
+	Name  *string   `json:"name"`
+	Tags  *[]string `json:"tags"`
+	Notes *string   `json:"notes"`
+}
+
+// UpdateAnalysisHandler edits the user-owned fields of an analysis
+func UpdateAnalysisHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	var req UpdateAnalysisRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name cannot be empty"})
+			return
+		}
+		updates["name"] = name
+	}
+	if req.Tags != nil {
+		tags := make([]string, 0, len(*req.Tags))
+		for _, t := range *req.Tags {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+		tagsJSON, _ := json.Marshal(tags)
+		updates["tags"] = string(tagsJSON)
+	}
+	if req.Notes != nil {
+		updates["notes"] = *req.Notes
+	}
+
+	var analysis model.Analysis
+	if err := db.DB.Where("id = ?", id).First(&analysis).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Analysis not found"})
+		return
+	}
+	if len(updates) > 0 {
+		if err := db.DB.Model(&analysis).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update analysis"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, analysis)
+}
+
+// DeleteAnalysisHandler cancels any pending job and removes the analysis,
+// its streams and packets, and the uploaded file.
+func DeleteAnalysisHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	// Stop a running analysis before deleting what it writes to
+	_, err := Jobs.Cancel(c.Request.Context(), id)
+	if err == queue.ErrRunningElsewhere {
+		c.JSON(http.StatusConflict, gin.H{"error": "Analysis is still running in another process and could not be cancelled"})
+		return
+	}
+	if err != nil && err != queue.ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+		return
+	}
+	if err := Jobs.Wait(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Analysis is still stopping"})
+		return
+	}
+
+	if err := lifecycle.DeleteAnalysis(id, UploadDir); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Analysis not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete analysis: " + err.Error()})
+		return
+	}
+
+	events.Default.Close(id, events.Event{
+		Type: events.TypeStatus,
+		Data: gin.H{"status": "deleted"},
+	})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+func TestListAnalysesSearch(t *testing.T) {
+	conn, err := db.Connect("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	prev := db.DB
+	db.DB = conn.Session(&gorm.Session{Logger: logger.Discard})
+	defer func() { db.DB = prev }()
+
+	for _, a := range []model.Analysis{
+		{ID: "a", Name: "100% loss", Tags: `["edge_1"]`},
+		{ID: "b", Name: "1000 loss", Tags: `["edgex1"]`},
+		{ID: "c", Name: `C:\captures`, Tags: `["a\"b"]`},
+	} {
+		if err := db.DB.Create(&a).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"q=" + url.QueryEscape("100%"), "a"},
+		{"tag=edge_1", "a"},
+		{"q=" + url.QueryEscape(`C:\c`), "c"},
+		{"tag=" + url.QueryEscape(`a"b`), "c"},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/analyses?"+tt.query, nil)
+			ListAnalysesHandler(c)
+
+			var resp struct {
+				Items []model.Analysis `json:"items"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+				t.Fatalf("%d %s", w.Code, w.Body)
+			}
+			if len(resp.Items) != 1 || resp.Items[0].ID != tt.want {
+				t.Errorf("got %+v, want only %s", resp.Items, tt.want)
+			}
+		})
+	}
+}
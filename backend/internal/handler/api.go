@@ -1,10 +1,16 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,44 +18,78 @@ import (
 	"gorm.io/gorm"
 
 	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/domain"
 	"pcap-analyzer/internal/model"
 	"pcap-analyzer/internal/service/analyzer"
+	tlsdissect "pcap-analyzer/internal/service/dissector/tls"
+	"pcap-analyzer/internal/service/events"
+	"pcap-analyzer/internal/service/lifecycle"
 	"pcap-analyzer/internal/service/pcap"
 )
 
 func UploadHandler(c *gin.Context) {
+	// Allow some slack over the file limit for the multipart framing
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxUploadBytes+1<<20)
 	file, err := c.FormFile("file")
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
 		return
 	}
+	if file.Size > MaxUploadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
+		return
+	}
 
 	// Generate ID and save file
 	id := uuid.New().String()
-	uploadDir := "./uploads"
-	os.MkdirAll(uploadDir, os.ModePerm)
+	os.MkdirAll(UploadDir, os.ModePerm)
 
-	filePath := filepath.Join(uploadDir, id+".pcap")
+	filePath := filepath.Join(UploadDir, id+".pcap")
 	if err := c.SaveUploadedFile(file, filePath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
 		return
 	}
 
+	// Optional NSS key log (SSLKEYLOGFILE) for decrypting TLS sessions
+	var keyLogPath string
+	if keyLog, err := c.FormFile("keylog"); err == nil {
+		keyLogPath = filepath.Join(UploadDir, id+".keys")
+		if err := c.SaveUploadedFile(keyLog, keyLogPath); err != nil {
+			os.Remove(filePath)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save key log"})
+			return
+		}
+	}
+
 	// Initialize Analysis in DB
 	analysis := model.Analysis{
-		ID:        id,
-		Status:    "processing",
-		CreatedAt: time.Now(),
+		ID:         id,
+		Name:       file.Filename,
+		Tags:       "[]",
+		FileName:   file.Filename,
+		FilePath:   filePath,
+		KeyLogPath: keyLogPath,
+		FileSize:   file.Size,
+		Status:     "processing",
+		CreatedAt:  time.Now(),
 	}
 	if err := db.DB.Create(&analysis).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create analysis record"})
 		return
 	}
 
-	// Trigger Analysis (Async)
-	go func() {
-		runAnalysis(id, filePath)
-	}()
+	// Queue Analysis (higher priority runs first)
+	priority, _ := strconv.Atoi(c.PostForm("priority"))
+	if err := Jobs.Enqueue(c.Request.Context(), &model.Job{ID: id, FilePath: filePath, Priority: priority}); err != nil {
+		failAnalysis(id, "Failed to queue analysis: "+err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue analysis"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"id":     id,
@@ -57,6 +97,26 @@ func UploadHandler(c *gin.Context) {
 	})
 }
 
+// streamSortColumns maps the sort keys of AnalysisResultHandler to columns
+var streamSortColumns = map[string]string{
+	"start_time":      "start_time",
+	"packets":         "packet_count",
+	"retransmissions": "retransmission_count",
+	"bytes":           "total_bytes",
+	"payload":         "payload_bytes",
+	"goodput":         "goodput_bytes",
+	"retransmitted":   "retransmitted_bytes",
+	"goodput_ratio":   "goodput_ratio",
+	"throughput":      "avg_throughput_bps",
+	"peak_throughput": "peak_throughput_bps",
+	"bytes_in_flight": "max_bytes_in_flight",
+}
+
+// AnalysisResultHandler returns an analysis with its streams. Query params:
+// src_ip, dst_ip, protocol, sort (a key of streamSortColumns, default
+// start_time), order (asc or desc; ascending for start_time and
+// goodput_ratio, so the worst ratios come first, descending otherwise),
+// limit.
 func AnalysisResultHandler(c *gin.Context) {
 	id := c.Param("id")
 
@@ -65,6 +125,25 @@ func AnalysisResultHandler(c *gin.Context) {
 	dstIP := c.Query("dst_ip")
 	protocol := c.Query("protocol")
 
+	sortBy := c.DefaultQuery("sort", "start_time")
+	column, ok := streamSortColumns[sortBy]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown sort key: " + sortBy})
+		return
+	}
+	order := c.Query("order")
+	if order == "" {
+		order = "desc"
+		if sortBy == "start_time" || sortBy == "goodput_ratio" {
+			order = "asc"
+		}
+	}
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
 	var analysis model.Analysis
 	query := db.DB.Preload("Streams").Where("id = ?", id)
 
@@ -79,7 +158,10 @@ func AnalysisResultHandler(c *gin.Context) {
 		if protocol != "" {
 			filtered = filtered.Where("protocol LIKE ?", "%"+protocol+"%")
 		}
-		return filtered.Order("start_time asc")
+		if limit > 0 {
+			filtered = filtered.Limit(limit)
+		}
+		return filtered.Order(column + " " + order).Order("start_time asc")
 	}).First(&analysis).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Analysis not found: " + err.Error()})
 		return
@@ -105,6 +187,53 @@ func AnalysisResultHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// AnalysisEventsHandler streams progress and partial findings as Server-Sent Events
+// until the analysis completes or fails.
+func AnalysisEventsHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	// Subscribe before reading status so a completion in between isn't missed
+	ch, unsubscribe := events.Default.Subscribe(id)
+	defer unsubscribe()
+
+	var analysis model.Analysis
+	if err := db.DB.Where("id = ?", id).First(&analysis).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Analysis not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	if analysis.Status != "processing" {
+		status := gin.H{"status": analysis.Status, "progress": analysis.Progress}
+		if analysis.Error != "" {
+			status["error"] = analysis.Error
+		}
+		c.SSEvent(events.TypeStatus, status)
+		return
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent(ev.Type, ev.Data)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"time": time.Now().Unix()})
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
 func GetStreamPacketsHandler(c *gin.Context) {
 	streamID := c.Param("id")
 	var packets []model.Packet
@@ -117,6 +246,24 @@ func GetStreamPacketsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, packets)
 }
 
+// GetStreamTransactionsHandler lists decoded application transactions of a
+// stream in request order, optionally filtered by ?protocol=
+func GetStreamTransactionsHandler(c *gin.Context) {
+	streamID := c.Param("id")
+	var txs []model.Transaction
+
+	query := db.DB.Where("stream_id = ?", streamID)
+	if protocol := c.Query("protocol"); protocol != "" {
+		query = query.Where("protocol = ?", protocol)
+	}
+	if err := query.Order("request_time asc").Find(&txs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, txs)
+}
+
 type IngestRequest struct {
 	FilePath string `json:"file_path"`
 }
@@ -133,58 +280,88 @@ func DevIngestHandler(c *gin.Context) {
 	// Create analysis record
 	analysis := model.Analysis{
 		ID:        id,
+		Name:      filepath.Base(req.FilePath),
+		Tags:      "[]",
+		FileName:  filepath.Base(req.FilePath),
+		FilePath:  req.FilePath,
 		Status:    "processing",
 		CreatedAt: time.Now(),
 	}
+	if fi, err := os.Stat(req.FilePath); err == nil {
+		analysis.FileSize = fi.Size()
+	}
 	if err := db.DB.Create(&analysis).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create record"})
 		return
 	}
 
-	go runAnalysis(id, req.FilePath)
+	if err := Jobs.Enqueue(c.Request.Context(), &model.Job{ID: id, FilePath: req.FilePath}); err != nil {
+		failAnalysis(id, "Failed to queue analysis: "+err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue analysis"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"id": id, "status": "processing"})
 }
 
-func runAnalysis(id, filePath string) {
-	// 1. Parse
-	db.DB.Model(&model.Analysis{}).Where("id = ?", id).Update("progress", 10)
+func runAnalysis(ctx context.Context, id, filePath string) error {
+	// A retried or requeued job starts over from a clean slate
+	if err := resetAnalysis(id); err != nil {
+		return err
+	}
 
+	// 1. Parse
 	parser := pcap.NewStreamingParser(filePath)
-	packetChan, err := parser.Parse()
+	packetChan, err := parser.ParseContext(ctx)
 	if err != nil {
-		db.DB.Model(&model.Analysis{}).Where("id = ?", id).Updates(model.Analysis{
-			Status: "failed",
-			Error:  err.Error(),
-		})
-		return
+		return err
 	}
 
-	// 2. Build
-	db.DB.Model(&model.Analysis{}).Where("id = ?", id).Update("progress", 30)
+	// 2. Build (streams that close early are analyzed and pushed as partial findings)
+	engine := analyzer.NewEngineWithThresholds(Thresholds)
+	engine.SetKeyLog(loadKeyLog(id, filePath))
+	engine.SetPortHints(PortHints)
+	engine.SetTimeSeriesBucket(TimeSeriesBucket)
 	builder := analyzer.NewStreamBuilder()
+	builder.OnStreamClosed = func(s *domain.Stream) {
+		publishPartialFinding(id, engine, s)
+	}
+
+	reporter := newProgressReporter(id, parser, builder)
+	reporter.start()
 	for pkt := range packetChan {
 		builder.ProcessPacket(pkt)
 	}
+	reporter.stop()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	// 3. Analyze
-	db.DB.Model(&model.Analysis{}).Where("id = ?", id).Update("progress", 60)
-	engine := analyzer.NewEngine()
+	reporter.setStage("analyzing", parsePercent+5)
 	domainStreams := builder.GetStreams()
 
 	var streamsToInsert []model.Stream
 	var packetsToInsert []model.Packet
+	var txsToInsert []model.Transaction
 	issuesCount := 0
 
 	for _, ds := range domainStreams {
 		engine.AnalyzeStream(ds)
+	}
+	report := engine.AnalyzeCapture(domainStreams, builder.ARPPackets())
+	streamUUIDs := make(map[string]string, len(domainStreams))
+
+	for _, ds := range domainStreams {
 		if ds.Severity != "normal" {
 			issuesCount++
 		}
 
 		// Convert Domain Stream to Model Stream
 		issuesJSON, _ := json.Marshal(ds.Analysis)
+		metricsJSON, _ := json.Marshal(ds.Metrics)
 		streamUUID := uuid.New().String()
+		streamUUIDs[ds.ID] = streamUUID
 
 		ms := model.Stream{
 			ID:                  streamUUID,
@@ -192,8 +369,12 @@ func runAnalysis(id, filePath string) {
 			AnalysisID:          id,
 			ClientIP:            ds.ClientIP,
 			ServerIP:            ds.ServerIP,
+			ClientPort:          ds.ClientPort,
 			ServerPort:          ds.ServerPort,
+			Transport:           ds.Transport,
 			Protocol:            ds.Protocol,
+			ProtocolConfidence:  ds.ProtocolConfidence,
+			ProtocolSource:      ds.ProtocolSource,
 			Severity:            string(ds.Severity),
 			PacketCount:         ds.Stats.PacketCount,
 			RetransmissionCount: ds.Stats.RetransmissionCount,
@@ -202,11 +383,17 @@ func runAnalysis(id, filePath string) {
 			AnalysisIssues:      string(issuesJSON),
 			StartTime:           ds.Stats.StartTime.Sub(time.Time{}).Seconds(), // Simplified timestamp
 			EndTime:             ds.Stats.EndTime.Sub(time.Time{}).Seconds(),
+			Metrics:             string(metricsJSON),
 		}
+		setByteTotals(&ms, &ds.Stats)
 		
 		// Note: We do NOT attach packets to 'ms' here to avoid GORM nested insert slowness.
 		streamsToInsert = append(streamsToInsert, ms)
 
+		for _, tx := range ds.Transactions {
+			txsToInsert = append(txsToInsert, toModelTransaction(id, streamUUID, tx))
+		}
+
 		// Convert Domain Packets to Model Packets
 		for _, pkt := range ds.Packets {
 			// Join flags
@@ -229,39 +416,70 @@ func runAnalysis(id, filePath string) {
 				PayloadLen: pkt.PayloadLen,
 				WindowSize: int(pkt.Window),
 				Payload:    pkt.Payload,
+				TTL:        int(pkt.TTL),
+				IPID:       int(pkt.IPID),
+			}
+			if pkt.Options != nil {
+				mp.Options = pkt.Options.String()
 			}
 			packetsToInsert = append(packetsToInsert, mp)
 		}
 	}
 
 	// Batch Insert Streams
+	reporter.setStage("saving", parsePercent+10)
 	if len(streamsToInsert) > 0 {
 		// Insert Streams (Batch 100)
 		if err := db.DB.Omit("Packets").CreateInBatches(streamsToInsert, 100).Error; err != nil {
-			db.DB.Model(&model.Analysis{}).Where("id = ?", id).Updates(model.Analysis{
-				Status: "failed",
-				Error:  "Failed to save streams: " + err.Error(),
-			})
-			return
+			return fmt.Errorf("Failed to save streams: %v", err)
 		}
 		
 		// Insert Packets (Batch 500)
 		// We insert packets only after streams are successfully saved to enforce foreign key constraints if any (SQLite usually lax but good practice)
 		if len(packetsToInsert) > 0 {
 			if err := db.DB.CreateInBatches(packetsToInsert, 500).Error; err != nil {
-				db.DB.Model(&model.Analysis{}).Where("id = ?", id).Updates(model.Analysis{
-					Status: "failed",
-					Error:  "Failed to save packets: " + err.Error(),
-				})
-				return
+				return fmt.Errorf("Failed to save packets: %v", err)
 			}
 		}
+
+		if len(txsToInsert) > 0 {
+			if err := db.DB.CreateInBatches(txsToInsert, 500).Error; err != nil {
+				return fmt.Errorf("Failed to save transactions: %v", err)
+			}
+		}
+
+		if len(report.Calls) > 0 {
+			calls := make([]model.Call, 0, len(report.Calls))
+			for _, call := range report.Calls {
+				calls = append(calls, toModelCall(id, streamUUIDs[call.Signaling], call))
+			}
+			if err := db.DB.CreateInBatches(calls, 100).Error; err != nil {
+				return fmt.Errorf("Failed to save calls: %v", err)
+			}
+		}
+	}
+
+	if len(report.Findings) > 0 {
+		findings := make([]model.Finding, 0, len(report.Findings))
+		for _, f := range report.Findings {
+			findings = append(findings, toModelFinding(id, f, streamUUIDs))
+		}
+		if err := db.DB.CreateInBatches(findings, 100).Error; err != nil {
+			return fmt.Errorf("Failed to save findings: %v", err)
+		}
+	}
+
+	if report.Stats != nil {
+		if err := db.DB.Create(toModelCaptureStats(id, report.Stats)).Error; err != nil {
+			return fmt.Errorf("Failed to save capture stats: %v", err)
+		}
 	}
 
 	// Update Analysis Status
 	summary := gin.H{
-		"total_streams": len(streamsToInsert),
-		"issues_found":  issuesCount,
+		"total_streams":    len(streamsToInsert),
+		"issues_found":     issuesCount,
+		"capture_findings": len(report.Findings),
 	}
 	summaryJSON, _ := json.Marshal(summary)
 
@@ -270,4 +488,171 @@ func runAnalysis(id, filePath string) {
 		Progress: 100,
 		Summary:  string(summaryJSON),
 	})
+	events.Default.Close(id, events.Event{
+		Type: events.TypeStatus,
+		Data: gin.H{"status": "complete", "progress": 100, "summary": summary},
+	})
+	return nil
+}
+
+// loadKeyLog collects TLS session secrets from the key log uploaded with the
+// analysis and from Decryption Secrets Blocks in the capture itself. Unreadable
+// secrets only cost decryption, so errors are logged and the analysis goes on.
+func loadKeyLog(id, filePath string) *tlsdissect.KeyLog {
+	keys := tlsdissect.NewKeyLog()
+	var analysis model.Analysis
+	if err := db.DB.Select("keylog_path").Where("id = ?", id).First(&analysis).Error; err == nil && analysis.KeyLogPath != "" {
+		if f, err := os.Open(analysis.KeyLogPath); err != nil {
+			log.Printf("analysis %s: opening key log: %v", id, err)
+		} else {
+			if err := keys.Read(f); err != nil {
+				log.Printf("analysis %s: reading key log: %v", id, err)
+			}
+			f.Close()
+		}
+	}
+
+	secrets, err := pcap.ReadTLSSecrets(filePath)
+	if err != nil {
+		log.Printf("analysis %s: reading pcapng secrets: %v", id, err)
+	}
+	for _, s := range secrets {
+		keys.Add(s)
+	}
+	return keys
+}
+
+func toModelTransaction(analysisID, streamID string, tx *domain.Transaction) model.Transaction {
+	latency := -1.0
+	if !tx.ResponseTime.IsZero() {
+		latency = float64(tx.Latency) / float64(time.Millisecond)
+	}
+	attrs, _ := json.Marshal(tx.Attributes)
+	return model.Transaction{
+		AnalysisID:    analysisID,
+		StreamID:      streamID,
+		Protocol:      tx.Protocol,
+		Method:        tx.Method,
+		Target:        tx.Target,
+		Host:          tx.Host,
+		Status:        tx.Status,
+		StatusText:    tx.StatusText,
+		RequestTime:   tx.RequestTime,
+		LatencyMs:     latency,
+		RequestBytes:  tx.RequestBytes,
+		ResponseBytes: tx.ResponseBytes,
+		Error:         tx.Error,
+		Attributes:    string(attrs),
+	}
+}
+
+// toModelFinding converts a finding, pointing its evidence at the stored
+// streams
+func toModelFinding(analysisID string, f *domain.Finding, streamUUIDs map[string]string) model.Finding {
+	hosts, _ := json.Marshal(f.Hosts)
+	mf := model.Finding{
+		AnalysisID: analysisID,
+		Severity:   string(f.Severity),
+		Category:   f.Category,
+		Message:    f.Message,
+		Time:       f.Time,
+		Hosts:      string(hosts),
+	}
+	if len(f.Evidence) > 0 {
+		evidence := make([]domain.Evidence, len(f.Evidence))
+		for i, ev := range f.Evidence {
+			evidence[i] = *ev
+			evidence[i].Stream = streamUUIDs[ev.Stream]
+		}
+		b, _ := json.Marshal(evidence)
+		mf.Evidence = string(b)
+	}
+	return mf
+}
+
+// setByteTotals copies the byte accounting of a stream onto its model
+func setByteTotals(ms *model.Stream, stats *domain.StreamStats) {
+	c2s, s2c := &stats.ClientToServer, &stats.ServerToClient
+	ms.TotalBytes = c2s.WireBytes + s2c.WireBytes
+	ms.PayloadBytes = c2s.PayloadBytes + s2c.PayloadBytes
+	ms.GoodputBytes = c2s.GoodputBytes + s2c.GoodputBytes
+	ms.RetransmittedBytes = c2s.RetransmittedBytes + s2c.RetransmittedBytes
+	ms.GoodputRatio = stats.GoodputRatio()
+	if secs := stats.Duration.Seconds(); secs > 0 {
+		ms.AvgThroughputBps = float64(ms.PayloadBytes*8) / secs
+	}
+	ms.PeakThroughputBps = max(c2s.PeakThroughputBps, s2c.PeakThroughputBps)
+	ms.MaxBytesInFlight = max(c2s.MaxBytesInFlight, s2c.MaxBytesInFlight)
+	directions, _ := json.Marshal(gin.H{"client_to_server": c2s, "server_to_client": s2c})
+	ms.Directions = string(directions)
+}
+
+func toModelCaptureStats(analysisID string, stats *domain.CaptureStats) *model.CaptureStats {
+	series, _ := json.Marshal(stats.Series)
+	protocols, _ := json.Marshal(stats.Protocols)
+	talkers, _ := json.Marshal(stats.Talkers)
+	conversations, _ := json.Marshal(stats.Conversations)
+	return &model.CaptureStats{
+		AnalysisID:    analysisID,
+		StartTime:     stats.Start,
+		BucketMs:      stats.Bucket.Milliseconds(),
+		Series:        string(series),
+		Protocols:     string(protocols),
+		Talkers:       string(talkers),
+		Conversations: string(conversations),
+	}
+}
+
+func toModelCall(analysisID, streamID string, call *domain.Call) model.Call {
+	setupMs := -1.0
+	duration := 0.0
+	if !call.AnswerTime.IsZero() {
+		setupMs = float64(call.SetupTime) / float64(time.Millisecond)
+		if !call.EndTime.IsZero() {
+			duration = call.EndTime.Sub(call.AnswerTime).Seconds()
+		}
+	}
+	media, _ := json.Marshal(call.Media)
+	issues, _ := json.Marshal(call.Issues)
+	return model.Call{
+		AnalysisID:  analysisID,
+		StreamID:    streamID,
+		CallID:      call.CallID,
+		FromURI:     call.From,
+		ToURI:       call.To,
+		State:       call.State,
+		FinalStatus: call.FinalStatus,
+		InviteTime:  call.InviteTime,
+		SetupMs:     setupMs,
+		DurationSec: duration,
+		Codec:       call.Codec,
+		CallerMedia: call.CallerMedia,
+		CalleeMedia: call.CalleeMedia,
+		MOS:         call.MOS,
+		Media:       string(media),
+		Issues:      string(issues),
+	}
+}
+
+// resetAnalysis clears results of an earlier, interrupted attempt
+func resetAnalysis(id string) error {
+	if err := lifecycle.DeleteResults(db.DB, id); err != nil {
+		return err
+	}
+	return db.DB.Model(&model.Analysis{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":   "processing",
+		"progress": 0,
+		"error":    "",
+	}).Error
+}
+
+func failAnalysis(id, msg string) {
+	db.DB.Model(&model.Analysis{}).Where("id = ?", id).Updates(model.Analysis{
+		Status: "failed",
+		Error:  msg,
+	})
+	events.Default.Close(id, events.Event{
+		Type: events.TypeStatus,
+		Data: gin.H{"status": "failed", "error": msg},
+	})
 }
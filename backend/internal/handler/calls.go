@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+// GetAnalysisCallsHandler lists the SIP calls of an analysis with their RTP
+// quality, in INVITE order. Query params: state (e.g. failed), max_mos.
+func GetAnalysisCallsHandler(c *gin.Context) {
+	query := db.DB.Where("analysis_id = ?", c.Param("id"))
+	if state := c.Query("state"); state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if maxMOS := c.Query("max_mos"); maxMOS != "" {
+		query = query.Where("mos > 0 AND mos <= ?", maxMOS)
+	}
+
+	var calls []model.Call
+	if err := query.Order("invite_time asc").Find(&calls).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch calls"})
+		return
+	}
+	c.JSON(http.StatusOK, calls)
+}
@@ -0,0 +1,16 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"pcap-analyzer/internal/config"
+)
+
+// ConfigHandler exposes the non-secret server settings read-only for the UI
+func ConfigHandler(cfg config.Public) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, cfg)
+	}
+}
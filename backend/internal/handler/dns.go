@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+// DNSResolution is one DNS query and its answer as returned by the API
+type DNSResolution struct {
+	StreamID    string    `json:"stream_id"`
+	Client      string    `json:"client"`
+	Resolver    string    `json:"resolver"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	RCode       int       `json:"rcode"`
+	RCodeName   string    `json:"rcode_name,omitempty"`
+	Answers     []string  `json:"answers"`
+	Truncated   bool      `json:"truncated"`
+	Transport   string    `json:"transport"`
+	Attempts    int       `json:"attempts"`
+	RequestTime time.Time `json:"request_time"`
+	LatencyMs   float64   `json:"latency_ms"` // -1 if unanswered
+	Error       string    `json:"error,omitempty"`
+}
+
+// GetAnalysisDNSHandler lists the DNS resolutions of an analysis in query order.
+// Query params: name (substring), rcode (number or name, e.g. NXDOMAIN),
+// resolver, unanswered=true.
+func GetAnalysisDNSHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	query := db.DB.Where("analysis_id = ? AND protocol = ?", id, "DNS")
+	if name := c.Query("name"); name != "" {
+		query = query.Where(`target LIKE ? ESCAPE '\'`, containing(name))
+	}
+	if rcode := c.Query("rcode"); rcode != "" {
+		if n, err := strconv.Atoi(rcode); err == nil {
+			query = query.Where("status = ? AND latency_ms >= 0", n)
+		} else {
+			query = query.Where("status_text = ?", strings.ToUpper(rcode))
+		}
+	}
+	if resolver := c.Query("resolver"); resolver != "" {
+		query = query.Where("host = ?", resolver)
+	}
+	if c.Query("unanswered") == "true" {
+		query = query.Where("latency_ms < 0")
+	}
+
+	var txs []model.Transaction
+	if err := query.Order("request_time asc").Find(&txs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch DNS resolutions"})
+		return
+	}
+
+	resolutions := make([]DNSResolution, 0, len(txs))
+	for _, tx := range txs {
+		resolutions = append(resolutions, toDNSResolution(tx))
+	}
+	c.JSON(http.StatusOK, resolutions)
+}
+
+func toDNSResolution(tx model.Transaction) DNSResolution {
+	var attrs map[string]string
+	json.Unmarshal([]byte(tx.Attributes), &attrs)
+
+	r := DNSResolution{
+		StreamID:    tx.StreamID,
+		Client:      attrs["client"],
+		Resolver:    tx.Host,
+		Name:        tx.Target,
+		Type:        tx.Method,
+		RCode:       tx.Status,
+		RCodeName:   tx.StatusText,
+		Answers:     []string{},
+		Truncated:   attrs["truncated"] == "true",
+		Transport:   attrs["transport"],
+		RequestTime: tx.RequestTime,
+		LatencyMs:   tx.LatencyMs,
+		Error:       tx.Error,
+	}
+	r.Attempts, _ = strconv.Atoi(attrs["attempts"])
+	if answers := attrs["answers"]; answers != "" {
+		r.Answers = strings.Split(answers, "; ")
+	}
+	return r
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+// fileProtocols are the transaction protocols of the file-share dissectors
+var fileProtocols = []string{"SMB", "NFS"}
+
+// FileServer summarizes the operations one file server answered
+type FileServer struct {
+	Server      string         `json:"server"`
+	Protocol    string         `json:"protocol"`
+	Connections int            `json:"connections"`
+	Operations  int            `json:"operations"`
+	Errors      int            `json:"errors"`
+	Slow        int            `json:"slow"`
+	Bytes       int64          `json:"bytes"`
+	TotalMs     float64        `json:"total_ms"`
+	MaxMs       float64        `json:"max_ms"`
+	ErrorCodes  map[string]int `json:"error_codes"`
+	Commands    []OpStats      `json:"commands"`
+	Shares      []ShareStats   `json:"shares"`
+}
+
+// ShareStats aggregates the operations on one SMB share; NFS operations
+// are grouped under an empty share
+type ShareStats struct {
+	Share string `json:"share"`
+	OpStats
+	Files []OpStats `json:"files"`
+}
+
+// OpStats aggregates the latency of a group of operations: one command,
+// one share or one file
+type OpStats struct {
+	Name     string  `json:"name,omitempty"`
+	StreamID string  `json:"stream_id,omitempty"` // stream of the slowest operation
+	Count    int     `json:"count"`
+	Errors   int     `json:"errors"`
+	Slow     int     `json:"slow"`
+	Bytes    int64   `json:"bytes"`
+	TotalMs  float64 `json:"total_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	MaxMs    float64 `json:"max_ms"`
+
+	answered int
+}
+
+func (s *OpStats) add(tx *model.Transaction, bytes int64, slowMs float64) {
+	s.Count++
+	s.Bytes += bytes
+	if tx.Error != "" {
+		s.Errors++
+	}
+	if tx.LatencyMs < 0 {
+		return
+	}
+	s.answered++
+	s.TotalMs += tx.LatencyMs
+	if tx.LatencyMs > slowMs {
+		s.Slow++
+	}
+	if tx.LatencyMs >= s.MaxMs {
+		s.MaxMs, s.StreamID = tx.LatencyMs, tx.StreamID
+	}
+}
+
+func (s *OpStats) finish() {
+	if s.answered > 0 {
+		s.AvgMs = s.TotalMs / float64(s.answered)
+	}
+}
+
+// GetAnalysisFilesHandler summarizes SMB and NFS traffic per server with
+// per-command latency and per-share, per-file latency. Query params:
+// protocol, server, share, sort (total, max, count or errors; default
+// total), limit (files per share, default 10).
+func GetAnalysisFilesHandler(c *gin.Context) {
+	query := db.DB.Where("analysis_id = ? AND protocol IN ?", c.Param("id"), fileProtocols)
+	if protocol := c.Query("protocol"); protocol != "" {
+		for _, p := range fileProtocols {
+			if strings.EqualFold(p, protocol) {
+				protocol = p
+			}
+		}
+		query = query.Where("protocol = ?", protocol)
+	}
+	if server := c.Query("server"); server != "" {
+		query = query.Where("host = ?", server)
+	}
+	limit := 10
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 {
+		limit = n
+	}
+	sortBy := c.DefaultQuery("sort", "total")
+
+	var txs []model.Transaction
+	if err := query.Order("request_time asc").Find(&txs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch file operations"})
+		return
+	}
+	c.JSON(http.StatusOK, summarizeFileServers(txs, c.Query("share"), sortBy, limit))
+}
+
+func summarizeFileServers(txs []model.Transaction, share, sortBy string, limit int) []*FileServer {
+	slowMs := Thresholds.FileSlowOpSeconds * 1000
+	type serverKey struct{ protocol, host string }
+	servers := map[serverKey]*FileServer{}
+	commands := map[serverKey]map[string]*OpStats{}
+	shares := map[serverKey]map[string]*ShareStats{}
+	files := map[serverKey]map[string]map[string]*OpStats{}
+	streams := map[serverKey]map[string]bool{}
+	var order []*FileServer
+
+	for i := range txs {
+		tx := &txs[i]
+		var attrs map[string]string
+		json.Unmarshal([]byte(tx.Attributes), &attrs)
+		if share != "" && !strings.EqualFold(attrs["share"], share) {
+			continue
+		}
+
+		key := serverKey{tx.Protocol, tx.Host}
+		srv := servers[key]
+		if srv == nil {
+			srv = &FileServer{Server: tx.Host, Protocol: tx.Protocol, ErrorCodes: map[string]int{},
+				Commands: []OpStats{}, Shares: []ShareStats{}}
+			servers[key] = srv
+			commands[key] = map[string]*OpStats{}
+			shares[key] = map[string]*ShareStats{}
+			files[key] = map[string]map[string]*OpStats{}
+			streams[key] = map[string]bool{}
+			order = append(order, srv)
+		}
+		streams[key][tx.StreamID] = true
+		bytes, _ := strconv.ParseInt(attrs["bytes"], 10, 64)
+		srv.Operations++
+		srv.Bytes += bytes
+		if tx.Error != "" {
+			srv.Errors++
+			code := attrs["error_code"]
+			if code == "" {
+				code = "closed"
+			}
+			srv.ErrorCodes[code]++
+		}
+
+		cmd := commands[key][tx.Method]
+		if cmd == nil {
+			cmd = &OpStats{Name: tx.Method}
+			commands[key][tx.Method] = cmd
+		}
+		cmd.add(tx, bytes, slowMs)
+
+		// Blocking operations wait on purpose and would swamp the latency
+		// of the share and its files
+		if attrs["blocking"] == "true" {
+			continue
+		}
+		if tx.LatencyMs >= 0 {
+			srv.TotalMs += tx.LatencyMs
+			srv.MaxMs = max(srv.MaxMs, tx.LatencyMs)
+			if tx.LatencyMs > slowMs {
+				srv.Slow++
+			}
+		}
+		sh := shares[key][attrs["share"]]
+		if sh == nil {
+			sh = &ShareStats{Share: attrs["share"], Files: []OpStats{}}
+			shares[key][sh.Share] = sh
+			files[key][sh.Share] = map[string]*OpStats{}
+		}
+		sh.add(tx, bytes, slowMs)
+		name := attrs["file"]
+		if name == "" {
+			continue
+		}
+		f := files[key][sh.Share][name]
+		if f == nil {
+			f = &OpStats{Name: name}
+			files[key][sh.Share][name] = f
+		}
+		f.add(tx, bytes, slowMs)
+	}
+
+	for key, srv := range servers {
+		srv.Connections = len(streams[key])
+		for _, cmd := range commands[key] {
+			cmd.finish()
+			srv.Commands = append(srv.Commands, *cmd)
+		}
+		sortOpStats(srv.Commands, sortBy)
+		for _, sh := range shares[key] {
+			sh.finish()
+			for _, f := range files[key][sh.Share] {
+				f.finish()
+				sh.Files = append(sh.Files, *f)
+			}
+			sortOpStats(sh.Files, sortBy)
+			if len(sh.Files) > limit {
+				sh.Files = sh.Files[:limit]
+			}
+			srv.Shares = append(srv.Shares, *sh)
+		}
+		sort.Slice(srv.Shares, func(i, j int) bool {
+			if srv.Shares[i].TotalMs != srv.Shares[j].TotalMs {
+				return srv.Shares[i].TotalMs > srv.Shares[j].TotalMs
+			}
+			return srv.Shares[i].Share < srv.Shares[j].Share
+		})
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].TotalMs > order[j].TotalMs })
+	return order
+}
+
+func sortOpStats(stats []OpStats, sortBy string) {
+	value := func(s OpStats) float64 {
+		switch sortBy {
+		case "max":
+			return s.MaxMs
+		case "count":
+			return float64(s.Count)
+		case "errors":
+			return float64(s.Errors)
+		}
+		return s.TotalMs
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if vi, vj := value(stats[i]), value(stats[j]); vi != vj {
+			return vi > vj
+		}
+		return stats[i].Name < stats[j].Name
+	})
+}
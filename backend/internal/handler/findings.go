@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+// GetAnalysisFindingsHandler lists the capture-level findings of an
+// analysis (ARP and DHCP issues not tied to a stream, middlebox
+// interference and idle timeouts shared by the connections to a server,
+// with their evidence packets) in time order. Query params: severity,
+// category (ARP, DHCP, DHCPv6, Middlebox or Timeout).
+func GetAnalysisFindingsHandler(c *gin.Context) {
+	query := db.DB.Where("analysis_id = ?", c.Param("id"))
+	if severity := c.Query("severity"); severity != "" {
+		query = query.Where("severity = ?", severity)
+	}
+	if category := c.Query("category"); category != "" {
+		query = query.Where("category = ?", category)
+	}
+
+	var findings []model.Finding
+	if err := query.Order("time asc, id asc").Find(&findings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch findings"})
+		return
+	}
+	c.JSON(http.StatusOK, findings)
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/model"
+	"pcap-analyzer/internal/service/analyzer"
+)
+
+// StreamTimeseries is the sequence, acknowledgement and bytes-in-flight
+// history of both sides of a TCP stream, with its congestion events
+type StreamTimeseries struct {
+	StreamID       string                    `json:"stream_id"`
+	RTTMs          float64                   `json:"rtt_ms"`
+	ClientToServer []*domain.FlightPoint     `json:"client_to_server"`
+	ServerToClient []*domain.FlightPoint     `json:"server_to_client"`
+	Events         []*domain.CongestionEvent `json:"events"`
+}
+
+// GetStreamTimeseriesHandler returns the data segments of a TCP stream for
+// time-sequence (Stevens) and tcptrace graphs: relative sequence numbers,
+// the peer's acknowledgements and window, and the bytes in flight. Query
+// params: direction (client_to_server or server_to_client, default both).
+func GetStreamTimeseriesHandler(c *gin.Context) {
+	var ms model.Stream
+	err := db.DB.Where("id = ?", c.Param("id")).First(&ms).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stream"})
+		return
+	}
+	if ms.Transport != "TCP" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Time series are only available for TCP streams"})
+		return
+	}
+	direction := c.Query("direction")
+	if direction != "" && direction != "client_to_server" && direction != "server_to_client" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "direction must be client_to_server or server_to_client"})
+		return
+	}
+
+	var packets []model.Packet
+	if err := db.DB.Select("timestamp, src_ip, dst_ip, seq, ack, flags, payload_len, window_size").
+		Where("stream_id = ?", ms.ID).Order("timestamp asc, id asc").Find(&packets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch packets"})
+		return
+	}
+	var metrics domain.StreamMetrics
+	json.Unmarshal([]byte(ms.Metrics), &metrics)
+	stream := toDomainStream(&ms, packets, metrics.TCP)
+	series := StreamTimeseries{StreamID: ms.ID, Events: []*domain.CongestionEvent{}}
+	if tcp := metrics.TCP; tcp != nil {
+		series.RTTMs = float64(tcp.RTT.Microseconds()) / 1000
+		for _, ev := range tcp.Events {
+			if direction == "" || ev.Direction == direction {
+				series.Events = append(series.Events, ev)
+			}
+		}
+	}
+	if direction != "server_to_client" {
+		series.ClientToServer = analyzer.FlightSeries(stream, true)
+	}
+	if direction != "client_to_server" {
+		series.ServerToClient = analyzer.FlightSeries(stream, false)
+	}
+	c.JSON(http.StatusOK, series)
+}
+
+// toDomainStream rebuilds enough of a stream from its stored packets for
+// sequence analysis. Packets keep no ports, so the direction comes from
+// the source address; the handshake gets its options back from the
+// metrics for window scaling.
+func toDomainStream(ms *model.Stream, packets []model.Packet, tcp *domain.TCPMetrics) *domain.Stream {
+	s := &domain.Stream{
+		ID: ms.StreamHash, ClientIP: ms.ClientIP, ServerIP: ms.ServerIP,
+		ClientPort: ms.ClientPort, ServerPort: ms.ServerPort, Transport: ms.Transport,
+	}
+	for _, p := range packets {
+		pkt := &domain.PacketMeta{
+			Timestamp:  p.Timestamp,
+			SrcIP:      p.SrcIP,
+			DstIP:      p.DstIP,
+			SrcPort:    ms.ServerPort,
+			DstPort:    ms.ClientPort,
+			Seq:        p.Seq,
+			Ack:        p.Ack,
+			Window:     uint16(p.WindowSize),
+			PayloadLen: p.PayloadLen,
+		}
+		if p.SrcIP == ms.ClientIP {
+			pkt.SrcPort, pkt.DstPort = ms.ClientPort, ms.ServerPort
+		}
+		if p.Flags != "" {
+			pkt.Flags = strings.Split(p.Flags, ",")
+		}
+		if tcp != nil && slices.Contains(pkt.Flags, "SYN") {
+			pkt.Options = tcp.ServerOptions
+			if p.SrcIP == ms.ClientIP {
+				pkt.Options = tcp.ClientOptions
+			}
+		}
+		s.Packets = append(s.Packets, pkt)
+	}
+	return s
+}
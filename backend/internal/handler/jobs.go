@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+	"pcap-analyzer/internal/service/events"
+	"pcap-analyzer/internal/service/queue"
+)
+
+// Jobs runs queued analyses; main sets it up before serving requests
+var Jobs *queue.Pool
+
+// RunAnalysisJob is the worker pool handler for analysis jobs
+func RunAnalysisJob(ctx context.Context, job *model.Job) error {
+	return runAnalysis(ctx, job.ID, job.FilePath)
+}
+
+// AnalysisJobFailed marks the analysis failed once its job has no retries left
+func AnalysisJobFailed(job *model.Job, err error) {
+	failAnalysis(job.ID, err.Error())
+}
+
+// AnalysisJobCancelled marks the analysis cancelled and disconnects event subscribers
+func AnalysisJobCancelled(job *model.Job) {
+	db.DB.Model(&model.Analysis{}).Where("id = ?", job.ID).Updates(model.Analysis{
+		Status: "cancelled",
+	})
+	events.Default.Close(job.ID, events.Event{
+		Type: events.TypeStatus,
+		Data: gin.H{"status": "cancelled"},
+	})
+}
+
+func GetJobHandler(c *gin.Context) {
+	job, err := Jobs.Queue().Get(c.Request.Context(), c.Param("id"))
+	if err == queue.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func CancelJobHandler(c *gin.Context) {
+	cancelled, err := Jobs.Cancel(c.Request.Context(), c.Param("id"))
+	if err == queue.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err == queue.ErrRunningElsewhere {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is still running in another process and could not be cancelled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has already finished"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "status": "cancelled"})
+}
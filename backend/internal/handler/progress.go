@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/model"
+	"pcap-analyzer/internal/service/analyzer"
+	"pcap-analyzer/internal/service/events"
+	"pcap-analyzer/internal/service/pcap"
+)
+
+// Share of the progress bar given to parsing; the rest covers analysis and saving
+const parsePercent = 80
+
+const progressInterval = 500 * time.Millisecond
+
+// progressReporter periodically publishes parser progress for one analysis
+// and mirrors the percentage into the DB for clients that still poll.
+type progressReporter struct {
+	id      string
+	parser  *pcap.StreamingParser
+	builder *analyzer.StreamBuilder
+	started time.Time
+
+	mu          sync.Mutex
+	stage       string
+	percent     int
+	savedPct    int
+	lastPackets int64
+	lastTick    time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newProgressReporter(id string, parser *pcap.StreamingParser, builder *analyzer.StreamBuilder) *progressReporter {
+	now := time.Now()
+	return &progressReporter{
+		id:       id,
+		parser:   parser,
+		builder:  builder,
+		started:  now,
+		lastTick: now,
+		stage:    "parsing",
+		savedPct: -1,
+		done:     make(chan struct{}),
+	}
+}
+
+func (r *progressReporter) start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report()
+			case <-r.done:
+				return
+			}
+		}
+	}()
+}
+
+func (r *progressReporter) stop() {
+	close(r.done)
+	r.wg.Wait()
+}
+
+// setStage moves past parsing; percent is then set explicitly
+func (r *progressReporter) setStage(stage string, percent int) {
+	r.mu.Lock()
+	r.stage = stage
+	r.percent = percent
+	r.mu.Unlock()
+	r.report()
+}
+
+func (r *progressReporter) report() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events.Default.Publish(r.id, events.Event{
+		Type: events.TypeProgress,
+		Data: r.progress(r.parser.Progress(), time.Now()),
+	})
+
+	if r.percent != r.savedPct {
+		r.savedPct = r.percent
+		db.DB.Model(&model.Analysis{}).Where("id = ?", r.id).Update("progress", r.percent)
+	}
+}
+
+// progress turns a parser reading taken at now into the published event,
+// advancing the packet rate window; r.mu must be held
+func (r *progressReporter) progress(p pcap.Progress, now time.Time) events.Progress {
+	pps := 0.0
+	if dt := now.Sub(r.lastTick).Seconds(); dt > 0 {
+		pps = float64(p.PacketsRead-r.lastPackets) / dt
+	}
+	r.lastPackets = p.PacketsRead
+	r.lastTick = now
+
+	eta := 0.0
+	if r.stage == "parsing" && p.TotalBytes > 0 {
+		r.percent = int(float64(p.BytesRead) / float64(p.TotalBytes) * parsePercent)
+		if elapsed := now.Sub(r.started).Seconds(); p.BytesRead > 0 && elapsed > 0 {
+			rate := float64(p.BytesRead) / elapsed
+			eta = float64(p.TotalBytes-p.BytesRead) / rate
+		}
+	}
+
+	return events.Progress{
+		Stage:         r.stage,
+		Percent:       r.percent,
+		BytesRead:     p.BytesRead,
+		TotalBytes:    p.TotalBytes,
+		Packets:       p.PacketsRead,
+		PacketsPerSec: pps,
+		Flows:         r.builder.StreamCount(),
+		ETASeconds:    eta,
+	}
+}
+
+// publishPartialFinding runs the cheap TCP detectors on a copy of a closed
+// stream so engineers can start triaging before the capture is fully read.
+// It runs inside the parse loop, so the dissectors are left to the final
+// pass at the end of runAnalysis, which remains authoritative.
+func publishPartialFinding(id string, engine *analyzer.Engine, stream *domain.Stream) {
+	snapshot := partialSnapshot(stream)
+	engine.AnalyzePartial(snapshot)
+
+	if snapshot.Severity == domain.SeverityNormal {
+		return
+	}
+	events.Default.Publish(id, events.Event{
+		Type: events.TypeFinding,
+		Data: events.Finding{
+			StreamHash: snapshot.ID,
+			ClientIP:   snapshot.ClientIP,
+			ServerIP:   snapshot.ServerIP,
+			ServerPort: snapshot.ServerPort,
+			Protocol:   snapshot.Protocol,
+			Severity:   string(snapshot.Severity),
+			Issues:     snapshot.Analysis,
+		},
+	})
+}
+
+// partialSnapshot copies a stream and its packets, since the detectors mark
+// packets (IsRetrans) and the final pass must start from them unmarked
+func partialSnapshot(stream *domain.Stream) *domain.Stream {
+	snapshot := *stream
+	snapshot.Analysis = nil
+	snapshot.Packets = make([]*domain.PacketMeta, len(stream.Packets))
+	for i, p := range stream.Packets {
+		cp := *p
+		snapshot.Packets[i] = &cp
+	}
+	return &snapshot
+}
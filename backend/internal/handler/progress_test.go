@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/analyzer"
+	"pcap-analyzer/internal/service/events"
+	"pcap-analyzer/internal/service/pcap"
+)
+
+func TestProgress(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 10, 0, time.UTC)
+	r := newProgressReporter("a", nil, analyzer.NewStreamBuilder())
+	r.started = now.Add(-10 * time.Second)
+	r.lastTick = now.Add(-2 * time.Second)
+
+	// A quarter of the file in 10s leaves 30s at the same rate
+	p := r.progress(pcap.Progress{BytesRead: 250, TotalBytes: 1000, PacketsRead: 100}, now)
+	want := events.Progress{Stage: "parsing", Percent: 20, BytesRead: 250, TotalBytes: 1000, Packets: 100, PacketsPerSec: 50, ETASeconds: 30}
+	if p != want {
+		t.Errorf("progress = %+v, want %+v", p, want)
+	}
+
+	// The packet rate covers only the packets since the last report
+	p = r.progress(pcap.Progress{BytesRead: 1000, TotalBytes: 1000, PacketsRead: 150}, now.Add(time.Second))
+	if p.Percent != parsePercent || p.ETASeconds != 0 || p.PacketsPerSec != 50 {
+		t.Errorf("progress at end of file = %+v", p)
+	}
+
+	// Past parsing the percentage is whatever the stage set
+	r.stage, r.percent = "saving", 90
+	if p = r.progress(pcap.Progress{BytesRead: 1000, TotalBytes: 1000, PacketsRead: 150}, now.Add(2*time.Second)); p.Stage != "saving" || p.Percent != 90 || p.ETASeconds != 0 {
+		t.Errorf("progress while saving = %+v", p)
+	}
+}
+
+func TestPublishPartialFinding(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	stream := &domain.Stream{ID: "s", Transport: "TCP", Severity: domain.SeverityNormal, Stats: domain.StreamStats{PacketCount: 2}}
+	for i := 0; i < 2; i++ {
+		stream.Packets = append(stream.Packets, &domain.PacketMeta{Timestamp: base.Add(time.Duration(i) * time.Second), Seq: 1000, PayloadLen: 100})
+	}
+
+	ch, cancel := events.Default.Subscribe("partial")
+	defer cancel()
+	publishPartialFinding("partial", analyzer.NewEngine(), stream)
+
+	ev := <-ch
+	if f, ok := ev.Data.(events.Finding); !ok || f.StreamHash != "s" || f.Severity != string(domain.SeverityWarning) || len(f.Issues) != 1 {
+		t.Errorf("event = %+v", ev)
+	}
+	// The final pass must see the stream as the parser left it
+	if stream.Packets[1].IsRetrans || stream.Stats.RetransmissionCount != 0 || stream.Analysis != nil || stream.Severity != domain.SeverityNormal {
+		t.Errorf("partial pass changed the stream: %+v, packet %+v", stream, stream.Packets[1])
+	}
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+// databaseProtocols are the transaction protocols of the database dissectors
+var databaseProtocols = []string{"MySQL", "PostgreSQL", "Redis"}
+
+// DatabaseServer summarizes the requests one database server answered
+type DatabaseServer struct {
+	Server      string           `json:"server"`
+	Protocol    string           `json:"protocol"`
+	Connections int              `json:"connections"`
+	Requests    int              `json:"requests"`
+	Errors      int              `json:"errors"`
+	Slow        int              `json:"slow"`
+	TotalMs     float64          `json:"total_ms"`
+	MaxMs       float64          `json:"max_ms"`
+	ErrorCodes  map[string]int   `json:"error_codes"`
+	Statements  []StatementStats `json:"statements"`
+}
+
+// StatementStats aggregates the executions of one statement fingerprint
+type StatementStats struct {
+	Fingerprint string  `json:"fingerprint"`
+	Example     string  `json:"example"` // slowest execution as sent
+	StreamID    string  `json:"stream_id"`
+	Count       int     `json:"count"`
+	Errors      int     `json:"errors"`
+	Slow        int     `json:"slow"`
+	Rows        int64   `json:"rows"`
+	TotalMs     float64 `json:"total_ms"`
+	AvgMs       float64 `json:"avg_ms"`
+	MaxMs       float64 `json:"max_ms"`
+
+	answered int
+}
+
+// GetAnalysisQueriesHandler summarizes MySQL, PostgreSQL and Redis traffic
+// per server with its top statements. Query params: protocol, server,
+// sort (total, max, count or errors; default total), limit (statements per
+// server, default 10).
+func GetAnalysisQueriesHandler(c *gin.Context) {
+	query := db.DB.Where("analysis_id = ? AND protocol IN ?", c.Param("id"), databaseProtocols)
+	if protocol := c.Query("protocol"); protocol != "" {
+		for _, p := range databaseProtocols {
+			if strings.EqualFold(p, protocol) {
+				protocol = p
+			}
+		}
+		query = query.Where("protocol = ?", protocol)
+	}
+	if server := c.Query("server"); server != "" {
+		query = query.Where("host = ?", server)
+	}
+	limit := 10
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 {
+		limit = n
+	}
+	sortBy := c.DefaultQuery("sort", "total")
+
+	var txs []model.Transaction
+	if err := query.Order("request_time asc").Find(&txs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch queries"})
+		return
+	}
+	c.JSON(http.StatusOK, summarizeDatabases(txs, sortBy, limit))
+}
+
+func summarizeDatabases(txs []model.Transaction, sortBy string, limit int) []*DatabaseServer {
+	slowMs := Thresholds.DBSlowQuerySeconds * 1000
+	type serverKey struct{ protocol, host string }
+	servers := map[serverKey]*DatabaseServer{}
+	statements := map[serverKey]map[string]*StatementStats{}
+	streams := map[serverKey]map[string]bool{}
+	var order []*DatabaseServer
+
+	for _, tx := range txs {
+		var attrs map[string]string
+		json.Unmarshal([]byte(tx.Attributes), &attrs)
+
+		key := serverKey{tx.Protocol, tx.Host}
+		srv := servers[key]
+		if srv == nil {
+			srv = &DatabaseServer{Server: tx.Host, Protocol: tx.Protocol, ErrorCodes: map[string]int{}, Statements: []StatementStats{}}
+			servers[key] = srv
+			statements[key] = map[string]*StatementStats{}
+			streams[key] = map[string]bool{}
+			order = append(order, srv)
+		}
+		streams[key][tx.StreamID] = true
+		srv.Requests++
+		if tx.Error != "" {
+			srv.Errors++
+			code := attrs["error_code"]
+			if code == "" {
+				code = "closed"
+			}
+			srv.ErrorCodes[code]++
+		}
+		blocking := attrs["blocking"] == "true"
+		if tx.LatencyMs >= 0 && !blocking {
+			srv.TotalMs += tx.LatencyMs
+			if tx.LatencyMs > srv.MaxMs {
+				srv.MaxMs = tx.LatencyMs
+			}
+			if tx.LatencyMs > slowMs {
+				srv.Slow++
+			}
+		}
+
+		fp := attrs["fingerprint"]
+		if fp == "" || blocking {
+			continue
+		}
+		st := statements[key][fp]
+		if st == nil {
+			st = &StatementStats{Fingerprint: fp, Example: tx.Target, StreamID: tx.StreamID}
+			statements[key][fp] = st
+		}
+		st.Count++
+		if tx.Error != "" {
+			st.Errors++
+		}
+		rows, _ := strconv.ParseInt(attrs["rows"], 10, 64)
+		st.Rows += rows
+		if tx.LatencyMs >= 0 {
+			st.answered++
+			st.TotalMs += tx.LatencyMs
+			if tx.LatencyMs > slowMs {
+				st.Slow++
+			}
+			if tx.LatencyMs > st.MaxMs {
+				st.MaxMs, st.Example, st.StreamID = tx.LatencyMs, tx.Target, tx.StreamID
+			}
+		}
+	}
+
+	for key, srv := range servers {
+		srv.Connections = len(streams[key])
+		for _, st := range statements[key] {
+			if st.answered > 0 {
+				st.AvgMs = st.TotalMs / float64(st.answered)
+			}
+			srv.Statements = append(srv.Statements, *st)
+		}
+		sortStatements(srv.Statements, sortBy)
+		if len(srv.Statements) > limit {
+			srv.Statements = srv.Statements[:limit]
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].TotalMs > order[j].TotalMs })
+	return order
+}
+
+func sortStatements(stats []StatementStats, sortBy string) {
+	value := func(s StatementStats) float64 {
+		switch sortBy {
+		case "max":
+			return s.MaxMs
+		case "count":
+			return float64(s.Count)
+		case "errors":
+			return float64(s.Errors)
+		}
+		return s.TotalMs
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if vi, vj := value(stats[i]), value(stats[j]); vi != vj {
+			return vi > vj
+		}
+		return stats[i].Fingerprint < stats[j].Fingerprint
+	})
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/model"
+)
+
+// maxTopEntries is how many talkers and conversations an analysis stores
+const maxTopEntries = 100
+
+// TrafficSeries is the capture time series at the requested resolution
+type TrafficSeries struct {
+	Start    time.Time      `json:"start"`
+	BucketMs int64          `json:"bucket_ms"`
+	Points   []TrafficPoint `json:"points"`
+}
+
+// TrafficPoint is one bucket of the series with its counts as rates
+type TrafficPoint struct {
+	Time               time.Time        `json:"time"`
+	Packets            int              `json:"packets"`
+	Bytes              int64            `json:"bytes"`
+	NewConnections     int              `json:"new_connections"`
+	Resets             int              `json:"resets"`
+	Retransmissions    int              `json:"retransmissions"`
+	PacketsPerSec      float64          `json:"packets_per_sec"`
+	BitsPerSec         float64          `json:"bits_per_sec"`
+	ConnectionsPerSec  float64          `json:"connections_per_sec"`
+	ResetsPerSec       float64          `json:"resets_per_sec"`
+	RetransmissionRate float64          `json:"retransmission_rate"` // share of packets retransmitted
+	Protocols          map[string]int64 `json:"protocols"`           // bytes per protocol
+}
+
+// TrafficTop is the protocol mix with the busiest hosts and host pairs
+type TrafficTop struct {
+	Protocols     []*domain.ProtocolShare `json:"protocols"`
+	Talkers       []*domain.Talker        `json:"talkers"`
+	Conversations []*domain.Conversation  `json:"conversations"`
+}
+
+// GetAnalysisTimeseriesHandler returns packets and bits per second, the
+// protocol mix, connection setups, resets and retransmissions over time.
+// Query params: bucket (a duration such as 10s; rounded up to a multiple of
+// the resolution the analysis stored, which is the default).
+func GetAnalysisTimeseriesHandler(c *gin.Context) {
+	stats, ok := loadCaptureStats(c)
+	if !ok {
+		return
+	}
+	var series []*domain.TimeBucket
+	json.Unmarshal([]byte(stats.Series), &series)
+
+	base := time.Duration(stats.BucketMs) * time.Millisecond
+	factor := 1
+	if bucket := c.Query("bucket"); bucket != "" {
+		d, err := time.ParseDuration(bucket)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be a positive duration such as 10s"})
+			return
+		}
+		if base > 0 && d > base {
+			factor = int((d + base - 1) / base)
+		}
+	}
+	c.JSON(http.StatusOK, TrafficSeries{
+		Start:    stats.StartTime,
+		BucketMs: stats.BucketMs * int64(factor),
+		Points:   trafficPoints(series, base*time.Duration(factor), factor),
+	})
+}
+
+// trafficPoints merges every factor buckets into one and derives the rates
+func trafficPoints(series []*domain.TimeBucket, width time.Duration, factor int) []TrafficPoint {
+	points := make([]TrafficPoint, 0, (len(series)+factor-1)/factor)
+	for i, b := range series {
+		if i%factor == 0 {
+			points = append(points, TrafficPoint{Time: b.Time, Protocols: map[string]int64{}})
+		}
+		p := &points[len(points)-1]
+		p.Packets += b.Packets
+		p.Bytes += b.Bytes
+		p.NewConnections += b.NewConnections
+		p.Resets += b.Resets
+		p.Retransmissions += b.Retransmissions
+		for name, n := range b.Protocols {
+			p.Protocols[name] += n
+		}
+	}
+	if secs := width.Seconds(); secs > 0 {
+		for i := range points {
+			p := &points[i]
+			p.PacketsPerSec = float64(p.Packets) / secs
+			p.BitsPerSec = float64(p.Bytes*8) / secs
+			p.ConnectionsPerSec = float64(p.NewConnections) / secs
+			p.ResetsPerSec = float64(p.Resets) / secs
+			if p.Packets > 0 {
+				p.RetransmissionRate = float64(p.Retransmissions) / float64(p.Packets)
+			}
+		}
+	}
+	return points
+}
+
+// GetAnalysisTopHandler returns the protocol mix, top talkers and top
+// conversations by bytes. Query params: limit (talkers and conversations,
+// default 10, at most 100).
+func GetAnalysisTopHandler(c *gin.Context) {
+	stats, ok := loadCaptureStats(c)
+	if !ok {
+		return
+	}
+	limit := 10
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 {
+		limit = min(n, maxTopEntries)
+	}
+
+	top := TrafficTop{}
+	json.Unmarshal([]byte(stats.Protocols), &top.Protocols)
+	json.Unmarshal([]byte(stats.Talkers), &top.Talkers)
+	json.Unmarshal([]byte(stats.Conversations), &top.Conversations)
+	if len(top.Talkers) > limit {
+		top.Talkers = top.Talkers[:limit]
+	}
+	if len(top.Conversations) > limit {
+		top.Conversations = top.Conversations[:limit]
+	}
+	c.JSON(http.StatusOK, top)
+}
+
+// loadCaptureStats fetches the traffic totals of the analysis in the path,
+// answering 404 when there are none
+func loadCaptureStats(c *gin.Context) (*model.CaptureStats, bool) {
+	var stats model.CaptureStats
+	err := db.DB.Where("analysis_id = ?", c.Param("id")).First(&stats).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No traffic statistics for this analysis"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch traffic statistics"})
+		return nil, false
+	}
+	return &stats, true
+}
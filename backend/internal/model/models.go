@@ -5,13 +5,21 @@ import (
 )
 
 type Analysis struct {
-	ID        string    `gorm:"primaryKey" json:"id"`
-	Status    string    `json:"status"`   // "processing", "complete", "failed"
-	Progress  int       `json:"progress"` // 0-100
-	CreatedAt time.Time `json:"created_at"`
-	Summary   string    `json:"summary"` // JSON string of summary stats
-	Error     string    `json:"error,omitempty"`
-	Streams   []Stream  `gorm:"foreignKey:AnalysisID" json:"streams,omitempty"`
+	ID       string `gorm:"primaryKey" json:"id"`
+	Name     string `json:"name"` // user-editable, defaults to the uploaded file name
+	Tags     string `json:"tags"` // JSON string array
+	Notes    string `json:"notes"`
+	FileName string `json:"file_name"` // original upload name
+	FilePath string `json:"-"`
+	// KeyLogPath is an optional NSS key log uploaded with the capture
+	KeyLogPath string    `gorm:"column:keylog_path" json:"-"`
+	FileSize   int64     `json:"file_size"`
+	Status     string    `gorm:"index" json:"status"` // "processing", "complete", "failed", "cancelled"
+	Progress   int       `json:"progress"`            // 0-100
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+	Summary    string    `json:"summary"` // JSON string of summary stats
+	Error      string    `json:"error,omitempty"`
+	Streams    []Stream  `gorm:"foreignKey:AnalysisID" json:"streams,omitempty"`
 }
 
 type Stream struct {
@@ -20,9 +28,13 @@ type Stream struct {
 	AnalysisID          string   `gorm:"index" json:"analysis_id"`
 	ClientIP            string   `json:"client_ip"`
 	ServerIP            string   `json:"server_ip"`
+	ClientPort          uint16   `json:"client_port"`
 	ServerPort          uint16   `json:"server_port"`
+	Transport           string   `json:"transport"` // "TCP", "UDP", "ICMP", "ICMPv6"
 	Protocol            string   `json:"protocol"`
-	Severity            string   `json:"severity"` // "normal", "warning", "critical"
+	ProtocolConfidence  int      `json:"protocol_confidence"` // 0-100
+	ProtocolSource      string   `json:"protocol_source"`     // "signature", "heuristic", "port" or "dissector"
+	Severity            string   `json:"severity"`            // "normal", "warning", "critical"
 	PacketCount         int      `json:"packet_count"`
 	RetransmissionCount int      `json:"retransmission_count"`
 	ResetCount          int      `json:"reset_count"`
@@ -30,7 +42,19 @@ type Stream struct {
 	AnalysisIssues      string   `json:"analysis_issues"` // JSON string array of issues
 	StartTime           float64  `json:"start_time"`
 	EndTime             float64  `json:"end_time"`
+	Metrics             string   `json:"metrics"` // JSON object of transport-specific measurements
 	Packets             []Packet `gorm:"foreignKey:StreamID" json:"packets,omitempty"`
+
+	// Byte totals of both directions, kept as columns for sorting
+	TotalBytes         int64   `json:"total_bytes"`   // frames on the wire
+	PayloadBytes       int64   `json:"payload_bytes"` // transport payload, retransmissions included
+	GoodputBytes       int64   `json:"goodput_bytes"`
+	RetransmittedBytes int64   `json:"retransmitted_bytes"`
+	GoodputRatio       float64 `json:"goodput_ratio"` // goodput / payload, 1 without payload
+	AvgThroughputBps   float64 `json:"avg_throughput_bps"`
+	PeakThroughputBps  float64 `json:"peak_throughput_bps"` // of the busier direction
+	MaxBytesInFlight   int64   `json:"max_bytes_in_flight"`
+	Directions         string  `json:"directions"` // JSON object of per-direction byte counts, throughput and bytes in flight
 }
 
 type Packet struct {
@@ -44,5 +68,86 @@ type Packet struct {
 	Flags      string    `json:"flags"` // Comma-separated
 	PayloadLen int       `json:"payload_len"`
 	WindowSize int       `json:"window_size"`
-	Payload    []byte    `json:"payload"` // Raw bytes
+	Payload    []byte    `json:"payload"`                   // Raw bytes
+	Options    string    `json:"options"`                   // TCP options as tcpdump prints them
+	TTL        int       `json:"ttl"`                       // IPv4 TTL or IPv6 hop limit
+	IPID       int       `gorm:"column:ip_id" json:"ip_id"` // IPv4 identification
+}
+
+// Job is a queued analysis run. ID is the analysis ID.
+type Job struct {
+	ID          string    `gorm:"primaryKey" json:"id"`
+	FilePath    string    `json:"file_path"`
+	Priority    int       `json:"priority"`            // higher runs first
+	Status      string    `gorm:"index" json:"status"` // "queued", "running", "done", "failed", "cancelled"
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	RunAt       time.Time `gorm:"index" json:"run_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Transaction is an application-layer request/response decoded from a stream
+type Transaction struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	AnalysisID    string    `gorm:"index" json:"analysis_id"`
+	StreamID      string    `gorm:"index" json:"stream_id"`
+	Protocol      string    `json:"protocol"`
+	Method        string    `json:"method"`
+	Target        string    `json:"target"`
+	Host          string    `json:"host"`
+	Status        int       `json:"status"`
+	StatusText    string    `json:"status_text"`
+	RequestTime   time.Time `json:"request_time"`
+	LatencyMs     float64   `json:"latency_ms"` // -1 if unanswered
+	RequestBytes  int64     `json:"request_bytes"`
+	ResponseBytes int64     `json:"response_bytes"`
+	Error         string    `json:"error,omitempty"`
+	Attributes    string    `json:"attributes"` // JSON object of protocol-specific fields
+}
+
+// Call is a SIP call with the quality of the RTP streams it negotiated
+type Call struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	AnalysisID  string    `gorm:"index" json:"analysis_id"`
+	StreamID    string    `json:"stream_id"` // signaling stream
+	CallID      string    `json:"call_id"`
+	FromURI     string    `json:"from"`
+	ToURI       string    `json:"to"`
+	State       string    `json:"state"` // "completed", "answered", "failed", "cancelled", "unanswered"
+	FinalStatus int       `json:"final_status"`
+	InviteTime  time.Time `json:"invite_time"`
+	SetupMs     float64   `json:"setup_ms"`     // -1 if not answered
+	DurationSec float64   `json:"duration_sec"` // answer to BYE, 0 if unknown
+	Codec       string    `json:"codec"`
+	CallerMedia string    `json:"caller_media"`
+	CalleeMedia string    `json:"callee_media"`
+	MOS         float64   `json:"mos"`
+	Media       string    `json:"media"`  // JSON array of per-SSRC RTP statistics
+	Issues      string    `json:"issues"` // JSON string array
+}
+
+// Finding is a capture-level finding, such as an ARP address conflict or an
+// unanswered DHCP discovery, that belongs to no single stream
+type Finding struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	AnalysisID string    `gorm:"index" json:"analysis_id"`
+	Severity   string    `json:"severity"`
+	Category   string    `json:"category"` // "ARP", "DHCP", "DHCPv6", "Middlebox" or "Timeout"
+	Message    string    `json:"message"`
+	Time       time.Time `json:"time"`
+	Hosts      string    `json:"hosts"`    // JSON string array of IP and MAC addresses
+	Evidence   string    `json:"evidence"` // JSON array of the packets behind the finding, by stream ID and position
+}
+
+// CaptureStats holds the capture-wide traffic totals of an analysis
+type CaptureStats struct {
+	AnalysisID    string    `gorm:"primaryKey" json:"analysis_id"`
+	StartTime     time.Time `json:"start_time"`
+	BucketMs      int64     `json:"bucket_ms"`
+	Series        string    `json:"series"`        // JSON array of time buckets
+	Protocols     string    `json:"protocols"`     // JSON array of protocol shares
+	Talkers       string    `json:"talkers"`       // JSON array of the top talkers
+	Conversations string    `json:"conversations"` // JSON array of the top conversations
 }
@@ -0,0 +1,258 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+const (
+	arpReplyTimeout = time.Second     // how long a request waits for its reply
+	arpProbeWindow  = 2 * time.Second // RFC 5227 probes and their defence
+	arpExamples     = 5
+)
+
+// analyzeARP reports address conflicts, gratuitous ARP floods, ARP storms
+// and addresses nobody answers for
+func (e *Engine) analyzeARP(pkts []*domain.ARPPacket) []*domain.Finding {
+	if len(pkts) == 0 {
+		return nil
+	}
+	var findings []*domain.Finding
+	findings = append(findings, detectDuplicateIPs(pkts)...)
+	findings = append(findings, detectARPProbeConflicts(pkts)...)
+	findings = append(findings, e.detectGratuitousARPFloods(pkts)...)
+	findings = append(findings, e.detectARPStorm(pkts)...)
+	findings = append(findings, e.detectUnansweredARP(pkts)...)
+	return findings
+}
+
+// detectDuplicateIPs reports addresses claimed by more than one MAC. Every
+// ARP packet with a sender address claims it for the sender MAC.
+func detectDuplicateIPs(pkts []*domain.ARPPacket) []*domain.Finding {
+	type claim struct {
+		mac   string
+		count int
+	}
+	claims := map[string][]*claim{}
+	conflictAt := map[string]time.Time{}
+	var order []string
+	for _, p := range pkts {
+		if p.SenderIP == "0.0.0.0" {
+			continue
+		}
+		list, seen := claims[p.SenderIP]
+		if !seen {
+			order = append(order, p.SenderIP)
+		}
+		var c *claim
+		for _, existing := range list {
+			if existing.mac == p.SenderMAC {
+				c = existing
+			}
+		}
+		if c == nil {
+			c = &claim{mac: p.SenderMAC}
+			claims[p.SenderIP] = append(list, c)
+			if len(list) == 1 {
+				conflictAt[p.SenderIP] = p.Timestamp
+			}
+		}
+		c.count++
+	}
+
+	var findings []*domain.Finding
+	for _, ip := range order {
+		list := claims[ip]
+		if len(list) < 2 {
+			continue
+		}
+		hosts := []string{ip}
+		parts := make([]string, len(list))
+		for i, c := range list {
+			hosts = append(hosts, c.mac)
+			parts[i] = fmt.Sprintf("%s (%d packet(s))", c.mac, c.count)
+		}
+		at := conflictAt[ip]
+		findings = append(findings, newFinding(domain.SeverityCritical, "ARP", at, hosts,
+			"Duplicate IP Address: %s is claimed by %d MACs: %s; first conflict at %s (unless this is a planned failover, hosts will lose traffic to each other)",
+			ip, len(list), strings.Join(parts, ", "), at.Format("15:04:05.000")))
+	}
+	return findings
+}
+
+// detectARPProbeConflicts reports RFC 5227 probes for an address another
+// host defended, meaning the prober found the address already in use
+func detectARPProbeConflicts(pkts []*domain.ARPPacket) []*domain.Finding {
+	var findings []*domain.Finding
+	reported := map[string]bool{}
+	for i, probe := range pkts {
+		if !probe.IsProbe() || reported[probe.TargetIP] {
+			continue
+		}
+		for _, p := range pkts[i+1:] {
+			if p.Timestamp.Sub(probe.Timestamp) > arpProbeWindow {
+				break
+			}
+			if p.SenderIP == probe.TargetIP && p.SenderMAC != probe.SenderMAC {
+				reported[probe.TargetIP] = true
+				findings = append(findings, newFinding(domain.SeverityCritical, "ARP", probe.Timestamp,
+					[]string{probe.TargetIP, probe.SenderMAC, p.SenderMAC},
+					"IP Address Conflict: %s probed for %s, which %s already uses",
+					probe.SenderMAC, probe.TargetIP, p.SenderMAC))
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// detectGratuitousARPFloods reports hosts repeating gratuitous ARP faster
+// than an address change or failover needs
+func (e *Engine) detectGratuitousARPFloods(pkts []*domain.ARPPacket) []*domain.Finding {
+	type key struct{ ip, mac string }
+	times := map[key][]time.Time{}
+	var order []key
+	for _, p := range pkts {
+		if !p.IsGratuitous() {
+			continue
+		}
+		k := key{p.SenderIP, p.SenderMAC}
+		if _, ok := times[k]; !ok {
+			order = append(order, k)
+		}
+		times[k] = append(times[k], p.Timestamp)
+	}
+
+	window := time.Duration(e.thresholds.ARPGratuitousWindowSecs * float64(time.Second))
+	var findings []*domain.Finding
+	for _, k := range order {
+		ts := times[k]
+		if len(ts) < e.thresholds.ARPGratuitousCount {
+			continue
+		}
+		best, first, _ := densestWindow(ts, window)
+		if best < e.thresholds.ARPGratuitousCount {
+			continue
+		}
+		findings = append(findings, newFinding(domain.SeverityWarning, "ARP", ts[first], []string{k.ip, k.mac},
+			"Gratuitous ARP Flood: %s (%s) announced itself %d times within %.0fs (%d in total)",
+			k.ip, k.mac, best, window.Seconds(), len(ts)))
+	}
+	return findings
+}
+
+// detectARPStorm reports the busiest second of ARP traffic when it exceeds
+// the threshold, with the hosts sending most of it
+func (e *Engine) detectARPStorm(pkts []*domain.ARPPacket) []*domain.Finding {
+	if len(pkts) <= e.thresholds.ARPStormPacketsPerSec {
+		return nil
+	}
+	times := make([]time.Time, len(pkts))
+	for i, p := range pkts {
+		times[i] = p.Timestamp
+	}
+	best, first, last := densestWindow(times, time.Second)
+	if best <= e.thresholds.ARPStormPacketsPerSec {
+		return nil
+	}
+
+	senders := map[string]int{}
+	for _, p := range pkts[first : last+1] {
+		senders[p.SenderMAC]++
+	}
+	macs := make([]string, 0, len(senders))
+	for mac := range senders {
+		macs = append(macs, mac)
+	}
+	sort.Slice(macs, func(i, j int) bool {
+		return senders[macs[i]] > senders[macs[j]] || (senders[macs[i]] == senders[macs[j]] && macs[i] < macs[j])
+	})
+	if len(macs) > arpExamples {
+		macs = macs[:arpExamples]
+	}
+	top := make([]string, len(macs))
+	for i, mac := range macs {
+		top[i] = fmt.Sprintf("%s x%d", mac, senders[mac])
+	}
+	return []*domain.Finding{newFinding(domain.SeverityCritical, "ARP", pkts[first].Timestamp, macs,
+		"ARP Storm: %d ARP packets within 1s from %d host(s), over the %d/s limit (top senders: %s)",
+		best, len(senders), e.thresholds.ARPStormPacketsPerSec, strings.Join(top, ", "))}
+}
+
+// detectUnansweredARP reports addresses that were asked for repeatedly and
+// never answered, such as a host that is down or a wrong gateway. Replies
+// are unicast, so the finding is only made when the capture point saw
+// replies at all.
+func (e *Engine) detectUnansweredARP(pkts []*domain.ARPPacket) []*domain.Finding {
+	replies := map[string][]time.Time{}
+	for _, p := range pkts {
+		if p.Operation == 2 {
+			replies[p.SenderIP] = append(replies[p.SenderIP], p.Timestamp)
+		}
+	}
+	if len(replies) == 0 {
+		return nil
+	}
+
+	type target struct {
+		ip         string
+		first      time.Time
+		unanswered int
+		askers     map[string]bool
+	}
+	targets := map[string]*target{}
+	var order []string
+	for _, p := range pkts {
+		if p.Operation != 1 || p.IsGratuitous() || p.IsProbe() {
+			continue
+		}
+		answered := false
+		for _, at := range replies[p.TargetIP] {
+			if d := at.Sub(p.Timestamp); d >= 0 && d <= arpReplyTimeout {
+				answered = true
+				break
+			}
+		}
+		if answered {
+			continue
+		}
+		t, ok := targets[p.TargetIP]
+		if !ok {
+			t = &target{ip: p.TargetIP, first: p.Timestamp, askers: map[string]bool{}}
+			targets[p.TargetIP] = t
+			order = append(order, p.TargetIP)
+		}
+		t.unanswered++
+		t.askers[p.SenderIP] = true
+	}
+
+	var hits []*target
+	for _, ip := range order {
+		if t := targets[ip]; t.unanswered >= e.thresholds.ARPUnansweredMinRequests {
+			hits = append(hits, t)
+		}
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].unanswered > hits[j].unanswered })
+	var hosts, examples []string
+	for _, t := range hits {
+		hosts = append(hosts, t.ip)
+		if len(examples) < arpExamples {
+			examples = append(examples, fmt.Sprintf("%s (%d request(s) from %d host(s))", t.ip, t.unanswered, len(t.askers)))
+		}
+	}
+	first := hits[0].first
+	for _, t := range hits {
+		if t.first.Before(first) {
+			first = t.first
+		}
+	}
+	return []*domain.Finding{newFinding(domain.SeverityWarning, "ARP", first, hosts,
+		"Unanswered ARP: %d address(es) never replied: %s", len(hits), strings.Join(examples, ", "))}
+}
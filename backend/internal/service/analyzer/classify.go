@@ -0,0 +1,61 @@
+package analyzer
+
+import (
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/classify"
+)
+
+// classifyStream labels a TCP or UDP stream from the first payload in each
+// direction. Dissectors that decode the stream later confirm or correct it.
+func (e *Engine) classifyStream(stream *domain.Stream) {
+	if stream.Transport != "TCP" && stream.Transport != "UDP" {
+		return
+	}
+	in := classify.Input{
+		Transport:  stream.Transport,
+		ClientPort: stream.ClientPort,
+		ServerPort: stream.ServerPort,
+	}
+	for _, pkt := range stream.Packets {
+		if len(pkt.Payload) == 0 {
+			continue
+		}
+		if stream.FromClient(pkt) {
+			if in.Client == nil {
+				in.Client = pkt.Payload
+			}
+		} else if in.Server == nil {
+			in.Server = pkt.Payload
+		}
+		if in.Client != nil && in.Server != nil {
+			break
+		}
+	}
+
+	stream.Protocol, stream.ProtocolConfidence, stream.ProtocolSource = stream.Transport, 0, ""
+	if r := e.classifier.Classify(in); r.Protocol != "" {
+		stream.Protocol, stream.ProtocolConfidence, stream.ProtocolSource = r.Protocol, r.Confidence, r.Source
+	}
+}
+
+// claimable reports whether a dissector for the given protocols may decode
+// the stream: it is unclassified, classified as one of them, or labeled on
+// evidence too weak to rule them out
+func claimable(stream *domain.Stream, protocols ...string) bool {
+	if stream.Protocol == stream.Transport || stream.ProtocolConfidence < classify.Confirmed {
+		return true
+	}
+	for _, p := range protocols {
+		if stream.Protocol == p {
+			return true
+		}
+	}
+	return false
+}
+
+// confirm labels the stream with the protocol a dissector decoded
+func confirm(stream *domain.Stream, protocol string) {
+	stream.Protocol = protocol
+	stream.ProtocolConfidence = classify.Certain
+	stream.ProtocolSource = classify.SourceDissector
+}
@@ -0,0 +1,43 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/service/classify"
+)
+
+func TestClassifyStream(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name       string
+		port       uint16
+		hints      classify.PortHints
+		client     string
+		protocol   string
+		confidence int
+		source     string
+	}{
+		{"signature over the port hint", 443, nil, "SSH-2.0-OpenSSH_9.6\r\n", "SSH", 95, classify.SourceSignature},
+		{"port hint", 443, nil, "\x00\x01\x02\x03", "TLS", classify.PortOnly, classify.SourcePort},
+		{"hint from the configuration", 7000, classify.PortHints{"tcp/7000": "Memcached"}, "\x00\x01\x02\x03", "Memcached", classify.PortOnly, classify.SourcePort},
+		{"hint removed by the configuration", 443, classify.PortHints{"443": ""}, "\x00\x01\x02\x03", "TCP", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConn(40000, tt.port).handshake(0)
+			c.send(true, 10*ms, tt.client)
+			s := c.finish()
+			e := NewEngine()
+			if tt.hints != nil {
+				e.SetPortHints(tt.hints)
+			}
+			e.AnalyzeStream(s)
+
+			if s.Protocol != tt.protocol || s.ProtocolConfidence != tt.confidence || s.ProtocolSource != tt.source {
+				t.Errorf("stream = %s (%d, %q), want %s (%d, %q)", s.Protocol, s.ProtocolConfidence, s.ProtocolSource,
+					tt.protocol, tt.confidence, tt.source)
+			}
+		})
+	}
+}
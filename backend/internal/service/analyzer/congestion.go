@@ -0,0 +1,266 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+const (
+	minRTO          = 200 * time.Millisecond // Linux floor; RFC 6298 asks for 1s
+	dupAckThreshold = 3
+	// slowStartGrowth is the least growth per round trip still counted as
+	// slow start, which doubles the window; slowStartRounds and
+	// slowStartFactor keep short or flat starts out
+	slowStartGrowth = 1.5
+	slowStartRounds = 3
+	slowStartFactor = 4
+	// collapseMinSegments keeps small windows out of the collapse check
+	collapseMinSegments = 8
+	congestionExamples  = 3
+	maxCongestionEvents = 20 // per kind and direction kept in the metrics
+)
+
+// analyzeCongestion follows the bytes in flight of each TCP sender to tell
+// congestion from application-limited transfers. It records slow start,
+// recoveries from loss by fast retransmit or by retransmission timeout,
+// congestion windows collapsing after loss and periods the sender had
+// nothing to send. It runs after detectRetransmissions.
+func (e *Engine) analyzeCongestion(stream *domain.Stream) {
+	if stream.Transport != "TCP" {
+		return
+	}
+	m := &domain.TCPMetrics{RTT: handshakeRTT(stream)}
+	for _, fromClient := range []bool{true, false} {
+		samples := flightSamples(stream, fromClient)
+		if len(samples) == 0 {
+			continue
+		}
+		dir, label, mss := "client_to_server", "client→server", stream.ClientMSS
+		if !fromClient {
+			dir, label, mss = "server_to_client", "server→client", stream.ServerMSS
+		}
+		if mss == 0 {
+			mss = 1460
+		}
+
+		var events []*domain.CongestionEvent
+		if ev := slowStart(samples, m.RTT); ev != nil {
+			events = append(events, ev)
+			raise(stream, domain.SeverityNormal, "Slow Start: %s grew from %d to %d bytes in flight (%s)",
+				label, ev.FlightBefore, ev.FlightAfter, formatTimeRange(ev.Start, ev.End))
+		}
+		losses := lossEvents(samples, m.RTT)
+		events = append(events, losses...)
+		collapses := e.cwndCollapses(samples, losses, m.RTT, int64(mss))
+		events = append(events, collapses...)
+		idle, span := e.appLimitedPeriods(samples, m.RTT)
+		events = append(events, idle...)
+
+		byKind := map[string][]*domain.CongestionEvent{}
+		for _, ev := range events {
+			ev.Direction = dir
+			if len(byKind[ev.Kind]) < maxCongestionEvents {
+				m.Events = append(m.Events, ev)
+			}
+			byKind[ev.Kind] = append(byKind[ev.Kind], ev)
+		}
+		if evs := byKind["rto"]; len(evs) > 0 {
+			raise(stream, domain.SeverityWarning, "Retransmission Timeout: %s waited for the retransmission timer %d time(s) (%s)",
+				label, len(evs), formatEventRanges(evs))
+		}
+		if evs := byKind["fast-recovery"]; len(evs) > 0 {
+			raise(stream, domain.SeverityNormal, "Fast Recovery: %s recovered from %d loss event(s) by fast retransmit (%s)",
+				label, len(evs), formatEventRanges(evs))
+		}
+		if evs := byKind["cwnd-collapse"]; len(evs) > 0 {
+			raise(stream, domain.SeverityWarning, "Congestion Window Collapse: %s dropped from %d to %d bytes in flight after loss, %d time(s) (%s)",
+				label, evs[0].FlightBefore, evs[0].FlightAfter, len(evs), formatEventRanges(evs))
+		}
+		if evs := byKind["app-limited"]; len(evs) > 0 && span > 0 {
+			var total time.Duration
+			for _, ev := range evs {
+				total += ev.End.Sub(ev.Start)
+			}
+			if pct := total.Seconds() / span.Seconds() * 100; pct >= e.thresholds.AppLimitedPercent {
+				raise(stream, domain.SeverityNormal, "Application-Limited: %s had nothing in flight for %.2fs of %.2fs (%.0f%%) in %d period(s) while the peer was not sending (%s)",
+					label, total.Seconds(), span.Seconds(), pct, len(evs), formatEventRanges(evs))
+			}
+		}
+	}
+	stream.Metrics.TCP = m
+}
+
+// handshakeRTT is the time from the client's last SYN to its ACK of the
+// SYN-ACK: a full round trip wherever the capture was taken
+func handshakeRTT(stream *domain.Stream) time.Duration {
+	var syn, synAck time.Time
+	for _, pkt := range stream.Packets {
+		switch {
+		case hasFlag(pkt, "SYN") && !hasFlag(pkt, "ACK"):
+			if synAck.IsZero() {
+				syn = pkt.Timestamp
+			}
+		case hasFlag(pkt, "SYN"):
+			if !syn.IsZero() && synAck.IsZero() {
+				synAck = pkt.Timestamp
+			}
+		case !synAck.IsZero() && stream.FromClient(pkt) && hasFlag(pkt, "ACK"):
+			return pkt.Timestamp.Sub(syn)
+		}
+	}
+	return 0
+}
+
+// slowStart finds the initial growth of the window: round trips, up to the
+// first loss, in which the peak in flight keeps growing about as fast as
+// slow start doubles it
+func slowStart(samples []flightSample, rtt time.Duration) *domain.CongestionEvent {
+	if rtt <= 0 {
+		return nil
+	}
+	start := samples[0].pkt.Timestamp
+	var peaks []int64
+	var ends []time.Time
+	for _, s := range samples {
+		if s.pkt.IsRetrans {
+			break
+		}
+		round := int(s.pkt.Timestamp.Sub(start) / rtt)
+		if round >= len(peaks)+1 {
+			break // a silent round trip ends the growth
+		}
+		if round == len(peaks) {
+			peaks = append(peaks, 0)
+			ends = append(ends, s.pkt.Timestamp)
+		}
+		peaks[round] = max(peaks[round], s.bytes)
+		ends[round] = s.pkt.Timestamp
+	}
+	last := 0
+	for last+1 < len(peaks) && float64(peaks[last+1]) >= slowStartGrowth*float64(peaks[last]) {
+		last++
+	}
+	if last < slowStartRounds || peaks[last] < slowStartFactor*peaks[0] {
+		return nil
+	}
+	return &domain.CongestionEvent{Kind: "slow-start", Start: start, End: ends[last], FlightBefore: peaks[0], FlightAfter: peaks[last]}
+}
+
+// lossEvents groups retransmissions into recoveries, each lasting until
+// the peer acknowledges everything sent before the loss. A recovery the
+// peer's duplicate acknowledgements started is a fast recovery; one the
+// sender started without them, or that retransmits again after falling
+// silent for longer than the minimum timeout, is a retransmission timeout.
+// Keep-alive probes are not losses.
+func lossEvents(samples []flightSample, rtt time.Duration) []*domain.CongestionEvent {
+	rto := minRTO + rtt
+	var events []*domain.CongestionEvent
+	var highest uint32
+	for i := 0; i < len(samples); i++ {
+		s := samples[i]
+		if s.pkt.IsRetrans && s.pkt.PayloadLen == 1 && s.pkt.Seq+1 == highest {
+			continue
+		}
+		if !s.pkt.IsRetrans {
+			if end := s.pkt.Seq + uint32(s.pkt.PayloadLen); i == 0 || int32(end-highest) > 0 {
+				highest = end
+			}
+			continue
+		}
+		recovery := highest
+
+		timedOut := func(j int) bool {
+			return j > 0 && samples[j].pkt.Timestamp.Sub(samples[j-1].pkt.Timestamp) >= rto && samples[j].dupAcks < dupAckThreshold
+		}
+		ev := &domain.CongestionEvent{Kind: "fast-recovery", Start: s.pkt.Timestamp, End: s.pkt.Timestamp, FlightBefore: s.bytes}
+		if s.dupAcks == 0 || timedOut(i) {
+			ev.Kind = "rto"
+		}
+		j := i + 1
+		for ; j < len(samples); j++ {
+			next := samples[j]
+			if int32(next.acked-recovery) >= 0 {
+				break
+			}
+			if next.pkt.IsRetrans && timedOut(j) {
+				ev.Kind = "rto"
+			}
+			if end := next.pkt.Seq + uint32(next.pkt.PayloadLen); int32(end-highest) > 0 {
+				highest = end
+			}
+			ev.End = next.pkt.Timestamp
+		}
+		if j < len(samples) {
+			ev.End, ev.FlightAfter = samples[j].pkt.Timestamp, samples[j].bytes
+		}
+		events = append(events, ev)
+		i = j - 1
+	}
+	return events
+}
+
+// cwndCollapses reports recoveries after which the sender got back to
+// less than the threshold share of what it had in flight before the loss,
+// within two round trips
+func (e *Engine) cwndCollapses(samples []flightSample, losses []*domain.CongestionEvent, rtt time.Duration, mss int64) []*domain.CongestionEvent {
+	window := max(2*rtt, 100*time.Millisecond)
+	var events []*domain.CongestionEvent
+	for _, loss := range losses {
+		before := max(peakFlight(samples, loss.Start.Add(-window), loss.Start), loss.FlightBefore)
+		after := peakFlight(samples, loss.End, loss.End.Add(window))
+		if before < collapseMinSegments*mss || after == 0 || float64(after) >= e.thresholds.CwndCollapseRatio*float64(before) {
+			continue
+		}
+		events = append(events, &domain.CongestionEvent{
+			Kind: "cwnd-collapse", Start: loss.Start, End: loss.End.Add(window), FlightBefore: before, FlightAfter: after,
+		})
+	}
+	return events
+}
+
+// peakFlight is the most in flight at a new segment sent in [from, to)
+func peakFlight(samples []flightSample, from, to time.Time) int64 {
+	var peak int64
+	for _, s := range samples {
+		if t := s.pkt.Timestamp; !t.Before(from) && t.Before(to) && !s.pkt.IsRetrans {
+			peak = max(peak, s.bytes)
+		}
+	}
+	return peak
+}
+
+// appLimitedPeriods finds the sender idle with everything acknowledged and
+// the peer's window open, while the peer was not sending either, so the
+// sender's application had nothing to send. It also returns the span of
+// the sender's data the periods are measured against.
+func (e *Engine) appLimitedPeriods(samples []flightSample, rtt time.Duration) ([]*domain.CongestionEvent, time.Duration) {
+	minIdle := max(time.Duration(e.thresholds.AppLimitedIdleSeconds*float64(time.Second)), 2*rtt)
+	var events []*domain.CongestionEvent
+	for _, s := range samples[1:] {
+		if s.idle < minIdle || s.peerSent || s.window == 0 {
+			continue
+		}
+		events = append(events, &domain.CongestionEvent{Kind: "app-limited", Start: s.pkt.Timestamp.Add(-s.idle), End: s.pkt.Timestamp})
+	}
+	return events, samples[len(samples)-1].pkt.Timestamp.Sub(samples[0].pkt.Timestamp)
+}
+
+func formatTimeRange(start, end time.Time) string {
+	return start.Format("15:04:05.000") + "–" + end.Format("15:04:05.000")
+}
+
+// formatEventRanges lists the time ranges of the first events
+func formatEventRanges(events []*domain.CongestionEvent) string {
+	parts := make([]string, 0, congestionExamples+1)
+	for i, ev := range events {
+		if i == congestionExamples {
+			parts = append(parts, fmt.Sprintf("%d more", len(events)-i))
+			break
+		}
+		parts = append(parts, formatTimeRange(ev.Start, ev.End))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,126 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// bulk is a server sending 1000-byte segments over a connection with a
+// 50ms round trip
+type bulk struct {
+	*conn
+}
+
+func newBulk() bulk {
+	c := newConn(40000, 8080)
+	c.send(true, 0, "", "SYN")
+	c.send(false, 25*time.Millisecond, "", "SYN", "ACK")
+	c.send(true, 50*time.Millisecond, "", "ACK")
+	c.stream.ServerMSS = 1000
+	return bulk{c}
+}
+
+// burst sends n segments 1ms apart and returns the first
+func (b bulk) burst(at time.Duration, n int) *domain.PacketMeta {
+	var first *domain.PacketMeta
+	for i := 0; i < n; i++ {
+		pkt := b.send(false, at+time.Duration(i)*time.Millisecond, strings.Repeat("x", 1000))
+		if first == nil {
+			first = pkt
+		}
+	}
+	return first
+}
+
+// ack acknowledges the server's data up to seq, everything if seq is 0
+func (b bulk) ack(at time.Duration, seq uint32) {
+	pkt := b.send(true, at, "")
+	if seq != 0 {
+		pkt.Ack = seq
+	}
+}
+
+// slowStart sends rounds of 1, 2, 4, 8 and 16 segments, each acknowledged
+// in full within the round trip
+func (b bulk) slowStart() {
+	for r := 0; r < 5; r++ {
+		at := time.Duration(100+50*r) * time.Millisecond
+		b.burst(at, 1<<r)
+		b.ack(at+40*time.Millisecond, 0)
+	}
+}
+
+func TestCongestion(t *testing.T) {
+	ms := time.Millisecond
+	const slowStart = "Slow Start: server→client grew from 1000 to 16000 bytes in flight (00:00:00.100–00:00:00.315)"
+	tests := []struct {
+		name   string
+		loss   func(b bulk)
+		want   []string
+		absent []string
+		kinds  string
+	}{
+		{"slow start", func(b bulk) {}, []string{slowStart},
+			[]string{"Fast Recovery", "Retransmission Timeout", "Congestion Window Collapse"}, "slow-start"},
+		{"fast recovery and collapse", func(b bulk) {
+			lost := b.burst(350*ms, 16)
+			for i := 0; i < 3; i++ {
+				b.ack(time.Duration(390+i)*ms, lost.Seq)
+			}
+			b.resend(lost, 393*ms)
+			b.ack(420*ms, 0)
+			b.burst(430*ms, 2)
+		}, []string{
+			slowStart,
+			"Fast Recovery: server→client recovered from 1 loss event(s) by fast retransmit (00:00:00.393–00:00:00.430)",
+			"Congestion Window Collapse: server→client dropped from 16000 to 2000 bytes in flight after loss, 1 time(s) (00:00:00.393–00:00:00.530)",
+		}, []string{"Retransmission Timeout"}, "slow-start fast-recovery cwnd-collapse"},
+		{"retransmission timeout", func(b bulk) {
+			lost := b.burst(350*ms, 16)
+			b.resend(lost, 665*ms)
+			b.ack(700*ms, 0)
+			b.burst(710*ms, 16)
+		}, []string{
+			slowStart,
+			"Retransmission Timeout: server→client waited for the retransmission timer 1 time(s) (00:00:00.665–00:00:00.710)",
+		}, []string{"Fast Recovery", "Congestion Window Collapse"}, "slow-start rto"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBulk()
+			b.slowStart()
+			tt.loss(b)
+			s := b.finish()
+			NewEngine().AnalyzeStream(s)
+
+			for _, want := range tt.want {
+				prefix, _, _ := strings.Cut(want, ":")
+				if got := findAnalysis(s, prefix); got != want {
+					t.Errorf("finding = %q, want %q", got, want)
+				}
+			}
+			for _, prefix := range tt.absent {
+				if got := findAnalysis(s, prefix); got != "" {
+					t.Errorf("unexpected finding %q", got)
+				}
+			}
+			m := s.Metrics.TCP
+			if m == nil || m.RTT != 50*ms {
+				t.Fatalf("metrics = %+v, want a 50ms round trip", m)
+			}
+			var kinds []string
+			for _, ev := range m.Events {
+				if ev.Direction != "server_to_client" {
+					t.Errorf("event %+v in the wrong direction", *ev)
+				}
+				kinds = append(kinds, ev.Kind)
+			}
+			if got := strings.Join(kinds, " "); got != tt.kinds {
+				t.Errorf("events = %s, want %s", got, tt.kinds)
+			}
+		})
+	}
+}
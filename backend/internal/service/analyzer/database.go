@@ -0,0 +1,257 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"pcap-analyzer/internal/domain"
+)
+
+const (
+	maxStatementLen   = 1024
+	maxFingerprintLen = 256
+)
+
+// isDBLogin reports whether a transaction authenticates the connection
+func isDBLogin(tx *domain.Transaction) bool {
+	switch tx.Method {
+	case "LOGIN", "AUTH", "HELLO":
+		return true
+	}
+	return false
+}
+
+// severeDBErrors are error codes that point at the server or its capacity
+// rather than at one statement: exhausted connections or memory, deadlocks,
+// lock and statement timeouts, replicas or clusters that cannot serve
+var severeDBErrors = map[string]map[string]bool{
+	"MySQL": {
+		"1040": true, // too many connections
+		"1203": true, // max_user_connections
+		"1205": true, // lock wait timeout
+		"1213": true, // deadlock
+		"1290": true, // read-only (--read-only / failover)
+		"3024": true, // max_execution_time exceeded
+	},
+	"PostgreSQL": {
+		"40P01": true, // deadlock
+		"53100": true, // disk full
+		"53200": true, // out of memory
+		"53300": true, // too many connections
+		"55P03": true, // lock not available
+		"57014": true, // statement timeout / cancel
+		"57P01": true, // admin shutdown
+		"57P03": true, // cannot connect now (starting up / recovery)
+		"25006": true, // read-only transaction
+	},
+	"Redis": {
+		"OOM":         true,
+		"BUSY":        true,
+		"LOADING":     true,
+		"MASTERDOWN":  true,
+		"CLUSTERDOWN": true,
+		"READONLY":    true,
+		"MISCONF":     true,
+	},
+}
+
+// detectDBErrors reports statements the server rejected and requests cut
+// off by the connection closing. Capacity, deadlock and timeout errors are
+// critical, the rest warnings. Login failures are reported separately.
+func (e *Engine) detectDBErrors(stream *domain.Stream, protocol string, txs []*domain.Transaction) {
+	codes := map[string]int{}
+	var first *domain.Transaction
+	severity := domain.SeverityWarning
+	failed, total := 0, 0
+	for _, tx := range txs {
+		if isDBLogin(tx) {
+			continue
+		}
+		total++
+		if tx.Error == "" {
+			continue
+		}
+		failed++
+		code := tx.Attributes["error_code"]
+		if code == "" {
+			code = "closed"
+		}
+		codes[code]++
+		if first == nil {
+			first = tx
+		}
+		if severeDBErrors[protocol][code] {
+			severity = domain.SeverityCritical
+		}
+	}
+	if first == nil {
+		return
+	}
+	raise(stream, severity, "%s Errors: %d of %d requests failed (%s); first %s: %s",
+		protocol, failed, total, formatNameCounts(codes), shortenStatement(first.Target, 80), first.Error)
+}
+
+// detectDBLoginFailures reports rejected authentication
+func (e *Engine) detectDBLoginFailures(stream *domain.Stream, protocol string, txs []*domain.Transaction) {
+	for _, tx := range txs {
+		if isDBLogin(tx) && tx.Error != "" {
+			raise(stream, domain.SeverityCritical, "%s Login Failed: %s (%s)", protocol, tx.Error, tx.Target)
+			return
+		}
+	}
+}
+
+// detectSlowDBQueries reports requests answered slower than the threshold.
+// Logins and blocking commands wait on purpose and are not counted.
+func (e *Engine) detectSlowDBQueries(stream *domain.Stream, protocol string, txs []*domain.Transaction) {
+	limit := time.Duration(e.thresholds.DBSlowQuerySeconds * float64(time.Second))
+	var worst *domain.Transaction
+	slow, total := 0, 0
+	for _, tx := range txs {
+		if isDBLogin(tx) || tx.Attributes["blocking"] == "true" {
+			continue
+		}
+		total++
+		if tx.ResponseTime.IsZero() || tx.Latency <= limit {
+			continue
+		}
+		slow++
+		if worst == nil || tx.Latency > worst.Latency {
+			worst = tx
+		}
+	}
+	if worst == nil {
+		return
+	}
+	raise(stream, domain.SeverityWarning, "Slow %s Queries: %d of %d over %.1fs (worst %.2fs for %s)",
+		protocol, slow, total, limit.Seconds(), worst.Latency.Seconds(), shortenStatement(worst.Target, 120))
+}
+
+// statementStats aggregates the executions of one statement fingerprint
+type statementStats struct {
+	fingerprint string
+	count       int
+	slow        int
+	total       time.Duration
+	max         time.Duration
+	slowest     *domain.Stream // stream of the slowest execution
+}
+
+// reportSlowStatements ranks, for each database server, the statements with
+// slow executions by their total time across all connections in the
+// capture. Connection pools spread one slow statement over many streams,
+// so the ranking is attached to the stream of the slowest execution.
+func (e *Engine) reportSlowStatements(streams []*domain.Stream) {
+	limit := time.Duration(e.thresholds.DBSlowQuerySeconds * float64(time.Second))
+	type serverKey struct{ protocol, host string }
+	servers := map[serverKey]map[string]*statementStats{}
+	var order []serverKey
+
+	for _, s := range streams {
+		for _, tx := range s.Transactions {
+			fp := tx.Attributes["fingerprint"]
+			if fp == "" || tx.ResponseTime.IsZero() || tx.Attributes["blocking"] == "true" {
+				continue
+			}
+			key := serverKey{tx.Protocol, tx.Host}
+			stats := servers[key]
+			if stats == nil {
+				stats = map[string]*statementStats{}
+				servers[key] = stats
+				order = append(order, key)
+			}
+			st := stats[fp]
+			if st == nil {
+				st = &statementStats{fingerprint: fp}
+				stats[fp] = st
+			}
+			st.count++
+			st.total += tx.Latency
+			if tx.Latency > limit {
+				st.slow++
+			}
+			if st.slowest == nil || tx.Latency > st.max {
+				st.max, st.slowest = tx.Latency, s
+			}
+		}
+	}
+
+	for _, key := range order {
+		var ranked []*statementStats
+		for _, st := range servers[key] {
+			if st.slow > 0 {
+				ranked = append(ranked, st)
+			}
+		}
+		if len(ranked) == 0 {
+			continue
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			if ranked[i].total != ranked[j].total {
+				return ranked[i].total > ranked[j].total
+			}
+			return ranked[i].fingerprint < ranked[j].fingerprint
+		})
+		worst := ranked[0]
+		for _, st := range ranked {
+			if st.max > worst.max {
+				worst = st
+			}
+		}
+		if n := e.thresholds.DBTopStatements; n > 0 && len(ranked) > n {
+			ranked = ranked[:n]
+		}
+		parts := make([]string, 0, len(ranked))
+		for _, st := range ranked {
+			parts = append(parts, fmt.Sprintf("%s (%dx, %d slow, avg %.2fs, max %.2fs)",
+				shortenStatement(st.fingerprint, 100), st.count, st.slow,
+				(st.total/time.Duration(st.count)).Seconds(), st.max.Seconds()))
+		}
+		raise(worst.slowest, domain.SeverityNormal, "Top Slow %s Statements on %s: %s",
+			key.protocol, key.host, strings.Join(parts, "; "))
+	}
+}
+
+var (
+	sqlBlockComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	sqlLineComment  = regexp.MustCompile(`--[^\n]*`)
+	sqlString       = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'`)
+	sqlNumber       = regexp.MustCompile(`\b(?:0x[0-9a-fA-F]+|[0-9]+(?:\.[0-9]+)?(?:[eE][-+]?[0-9]+)?)\b`)
+	sqlParam        = regexp.MustCompile(`\$[0-9]+`)
+	sqlList         = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	sqlRows         = regexp.MustCompile(`\(\?\+\)(?:\s*,\s*\(\?\+\))+`)
+	sqlSpace        = regexp.MustCompile(`\s+`)
+)
+
+// fingerprintSQL normalizes a statement so executions that differ only in
+// literal values group together: comments are dropped, literals and
+// placeholders become ?, value lists collapse to (?+)
+func fingerprintSQL(statement string) string {
+	s := sqlString.ReplaceAllString(statement, "?")
+	s = sqlBlockComment.ReplaceAllString(s, " ")
+	s = sqlLineComment.ReplaceAllString(s, " ")
+	s = sqlParam.ReplaceAllString(s, "?")
+	s = sqlNumber.ReplaceAllString(s, "?")
+	s = sqlList.ReplaceAllString(s, "(?+)")
+	s = sqlRows.ReplaceAllString(s, "(?+)")
+	s = strings.TrimSpace(sqlSpace.ReplaceAllString(s, " "))
+	return shortenStatement(s, maxFingerprintLen)
+}
+
+// shortenStatement collapses whitespace and cuts s to at most n bytes
+// without splitting a UTF-8 sequence
+func shortenStatement(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= n {
+		return s
+	}
+	cut := n
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
@@ -0,0 +1,93 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// pgMessage encodes a typed PostgreSQL message
+func pgMessage(typ byte, body string) string {
+	return string(binary.BigEndian.AppendUint32([]byte{typ}, uint32(len(body)+4))) + body
+}
+
+// pgSession is a pooled connection, captured mid-session, running each
+// statement with the given latency
+func pgSession(port uint16, statements []string, latencies []time.Duration) *domain.Stream {
+	c := newConn(port, 5432).handshake(0)
+	at := 10 * time.Millisecond
+	for i, sql := range statements {
+		c.send(true, at, pgMessage('Q', sql+"\x00"))
+		at += latencies[i]
+		c.send(false, at, pgMessage('C', "SELECT 1\x00")+pgMessage('Z', "I"))
+		at += 10 * time.Millisecond
+	}
+	return c.finish()
+}
+
+func TestFingerprintSQL(t *testing.T) {
+	tests := []struct {
+		sql, want string
+	}{
+		{"SELECT * FROM t WHERE id = 42 AND name = 'o''brien'", "SELECT * FROM t WHERE id = ? AND name = ?"},
+		{"select a /* hint */ from t -- trailing\n where x in (1, 2, 3)", "select a from t where x in (?+)"},
+		{"INSERT INTO t VALUES (1, 'a'), (2, 'b')", "INSERT INTO t VALUES (?+)"},
+		{"UPDATE t SET v = $1 WHERE k = $2", "UPDATE t SET v = ? WHERE k = ?"},
+		{"SELECT col2 FROM t2 LIMIT 0x10", "SELECT col2 FROM t2 LIMIT ?"},
+	}
+	for _, tt := range tests {
+		if got := fingerprintSQL(tt.sql); got != tt.want {
+			t.Errorf("fingerprintSQL(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestTopSlowStatements(t *testing.T) {
+	s := time.Second
+	ms := time.Millisecond
+	pool := func() []*domain.Stream {
+		return []*domain.Stream{
+			pgSession(40001, []string{"SELECT * FROM orders WHERE id = 1", "SELECT 1"}, []time.Duration{1500 * ms, 10 * ms}),
+			pgSession(40002, []string{"SELECT * FROM orders WHERE id = 2", "UPDATE stock SET n = 5"}, []time.Duration{2500 * ms, 1200 * ms}),
+			pgSession(40003, []string{"SELECT * FROM orders WHERE id = 3", "UPDATE stock SET n = 7", "DELETE FROM carts WHERE age > 30"},
+				[]time.Duration{500 * ms, 1100 * ms, 2 * s}),
+		}
+	}
+
+	tests := []struct {
+		name string
+		top  int
+		want string
+	}{
+		{"ranked by total time", 5, "Top Slow PostgreSQL Statements on 10.0.0.2:5432: " +
+			"SELECT * FROM orders WHERE id = ? (3x, 2 slow, avg 1.50s, max 2.50s); " +
+			"UPDATE stock SET n = ? (2x, 2 slow, avg 1.15s, max 1.20s); " +
+			"DELETE FROM carts WHERE age > ? (1x, 1 slow, avg 2.00s, max 2.00s)"},
+		{"cut to the top", 1, "Top Slow PostgreSQL Statements on 10.0.0.2:5432: " +
+			"SELECT * FROM orders WHERE id = ? (3x, 2 slow, avg 1.50s, max 2.50s)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streams := pool()
+			th := DefaultThresholds()
+			th.DBTopStatements = tt.top
+			e := NewEngineWithThresholds(th)
+			for _, st := range streams {
+				e.AnalyzeStream(st)
+			}
+			e.AnalyzeCapture(streams, nil)
+
+			// The ranking goes on the stream with the slowest execution
+			for i, st := range streams {
+				got := findAnalysis(st, "Top Slow")
+				if i == 1 && got != tt.want {
+					t.Errorf("ranking = %q, want %q", got, tt.want)
+				} else if i != 1 && got != "" {
+					t.Errorf("ranking also on stream %d: %q", i, got)
+				}
+			}
+		})
+	}
+}
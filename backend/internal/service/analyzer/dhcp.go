@@ -0,0 +1,372 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/dhcp"
+)
+
+const dhcpExamples = 3
+
+// dhcpMessage is a DHCP message with the packet that carried it
+type dhcpMessage struct {
+	*dhcp.Message
+	at  time.Time
+	src string
+}
+
+// client names the client in findings: its MAC for DHCP, its address for
+// DHCPv6, whose DUIDs are opaque
+func (m *dhcpMessage) client() string {
+	if m.Version == 6 {
+		return m.src
+	}
+	return m.Client
+}
+
+// collectDHCP decodes the DHCP and DHCPv6 messages of every UDP stream.
+// Clients broadcast and servers often answer a different address, so one
+// exchange is spread over several streams and is analyzed for the capture.
+func collectDHCP(streams []*domain.Stream) []*dhcpMessage {
+	var msgs []*dhcpMessage
+	for _, s := range streams {
+		if s.Transport != "UDP" {
+			continue
+		}
+		for _, pkt := range s.Packets {
+			var m *dhcp.Message
+			switch {
+			case isPortPair(pkt, dhcp.ClientPort, dhcp.ServerPort):
+				m = dhcp.Parse(pkt.Payload)
+			case isPortPair(pkt, dhcp.ClientPortV6, dhcp.ServerPortV6):
+				m = dhcp.ParseV6(pkt.Payload)
+			}
+			if m != nil {
+				msgs = append(msgs, &dhcpMessage{Message: m, at: pkt.Timestamp, src: pkt.SrcIP})
+			}
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].at.Before(msgs[j].at) })
+	return msgs
+}
+
+// isPortPair reports a packet between the two ports, in either direction.
+// Relay agents send from the server port on both sides.
+func isPortPair(pkt *domain.PacketMeta, client, server uint16) bool {
+	return (pkt.SrcPort == client || pkt.SrcPort == server) && (pkt.DstPort == client || pkt.DstPort == server)
+}
+
+// dhcpKey identifies a client transaction
+type dhcpKey struct {
+	xid    uint32
+	client string
+}
+
+// dhcpExchange follows one client transaction: the discovery (DISCOVER or
+// SOLICIT answered by OFFER or ADVERTISE) and the request answered by ACK,
+// NAK or REPLY
+type dhcpExchange struct {
+	discover, offer *dhcpMessage
+	discovers       int
+	request, reply  *dhcpMessage
+}
+
+// dhcpServer counts what one server sent
+type dhcpServer struct {
+	id, addr string
+	offers   int
+	replies  int
+	refusals map[string]int // reason -> count
+	clients  map[string]bool
+	first    time.Time
+}
+
+func (s *dhcpServer) name() string {
+	if s.addr != "" && s.addr != s.id {
+		if len(s.id) > 16 {
+			return s.addr
+		}
+		return fmt.Sprintf("%s (id %s)", s.addr, s.id)
+	}
+	return s.id
+}
+
+// analyzeDHCP reports DHCP and DHCPv6 clients left without an answer,
+// refusals, declined addresses, slow servers and more than one server
+// handing out addresses
+func (e *Engine) analyzeDHCP(msgs []*dhcpMessage) []*domain.Finding {
+	var findings []*domain.Finding
+	for _, version := range []int{4, 6} {
+		var own []*dhcpMessage
+		for _, m := range msgs {
+			if m.Version == version {
+				own = append(own, m)
+			}
+		}
+		if len(own) > 0 {
+			findings = append(findings, e.analyzeDHCPVersion(own)...)
+		}
+	}
+	return findings
+}
+
+func (e *Engine) analyzeDHCPVersion(msgs []*dhcpMessage) []*domain.Finding {
+	category := "DHCP"
+	discoverType, offerType := dhcp.Discover, dhcp.Offer
+	if msgs[0].Version == 6 {
+		category = "DHCPv6"
+		discoverType, offerType = dhcp.Solicit, dhcp.Advertise
+	}
+
+	exchanges := map[dhcpKey]*dhcpExchange{}
+	var order []dhcpKey
+	servers := map[string]*dhcpServer{}
+	var serverOrder []string
+	var declines []*dhcpMessage
+
+	for _, m := range msgs {
+		k := dhcpKey{m.XID, m.Client}
+		ex, ok := exchanges[k]
+		if !ok {
+			ex = &dhcpExchange{}
+			exchanges[k] = ex
+			order = append(order, k)
+		}
+
+		if m.FromClient() {
+			switch {
+			case m.Type == discoverType:
+				ex.discovers++
+				if ex.discover == nil {
+					ex.discover = m
+				}
+			case m.Type == dhcp.Decline && m.Version == 4, m.Type == dhcp.DeclineV6 && m.Version == 6:
+				declines = append(declines, m)
+			case m.Type == dhcp.Release && m.Version == 4, m.Type == dhcp.ReleaseV6 && m.Version == 6:
+			default:
+				if ex.request == nil {
+					ex.request = m
+				}
+			}
+			continue
+		}
+
+		id := m.Server
+		if id == "" {
+			id = m.src
+		}
+		srv, ok := servers[id]
+		if !ok {
+			srv = &dhcpServer{id: id, addr: m.src, refusals: map[string]int{}, clients: map[string]bool{}, first: m.at}
+			servers[id] = srv
+			serverOrder = append(serverOrder, id)
+		}
+		if m.Type == offerType {
+			srv.offers++
+			srv.clients[m.Client] = true
+			if ex.offer == nil {
+				ex.offer = m
+			}
+			continue
+		}
+		srv.replies++
+		if m.Failed() {
+			reason := m.Text
+			if m.Version == 6 {
+				reason = dhcp.StatusName(m.Status)
+				if m.Text != "" {
+					reason += ": " + m.Text
+				}
+			}
+			if reason == "" {
+				reason = "no reason given"
+			}
+			srv.refusals[reason]++
+		}
+		// A REPLY without a REQUEST answers a rapid-commit SOLICIT
+		if ex.request == nil && ex.discover != nil && ex.offer == nil {
+			ex.offer = m
+		}
+		if ex.reply == nil {
+			ex.reply = m
+		}
+	}
+
+	ordered := make([]*dhcpExchange, len(order))
+	for i, k := range order {
+		ordered[i] = exchanges[k]
+	}
+	var findings []*domain.Finding
+	findings = append(findings, detectUnansweredDHCP(category, ordered)...)
+	findings = append(findings, e.detectSlowDHCP(category, ordered)...)
+	findings = append(findings, detectDHCPRefusals(category, servers, serverOrder)...)
+	findings = append(findings, detectDHCPDeclines(category, declines)...)
+	findings = append(findings, detectMultipleDHCPServers(category, servers, serverOrder)...)
+	return findings
+}
+
+// detectUnansweredDHCP reports discoveries no server offered an address for
+// and requests no server confirmed or refused
+func detectUnansweredDHCP(category string, exchanges []*dhcpExchange) []*domain.Finding {
+	var findings []*domain.Finding
+	var first time.Time
+	clients := map[string]bool{}
+	var hosts []string
+	sent := 0
+	for _, ex := range exchanges {
+		if ex.discover == nil || ex.offer != nil || ex.reply != nil {
+			continue
+		}
+		sent += ex.discovers
+		if first.IsZero() {
+			first = ex.discover.at
+		}
+		if c := ex.discover.client(); !clients[c] {
+			clients[c] = true
+			hosts = append(hosts, c)
+		}
+	}
+	if sent > 0 {
+		name := "DISCOVER"
+		if category == "DHCPv6" {
+			name = "SOLICIT"
+		}
+		findings = append(findings, newFinding(domain.SeverityCritical, category, first, hosts,
+			"%s Discovery Unanswered: %d %s(s) from %d client(s) got no offer (e.g. %s); no server is reachable on this segment or the relay is missing",
+			category, sent, name, len(clients), strings.Join(hosts[:min(len(hosts), dhcpExamples)], ", ")))
+	}
+
+	var pending []*dhcpMessage
+	for _, ex := range exchanges {
+		if ex.request != nil && ex.reply == nil {
+			pending = append(pending, ex.request)
+		}
+	}
+	if len(pending) > 0 {
+		types := map[string]int{}
+		var examples []string
+		for _, m := range pending {
+			types[m.TypeName()]++
+			if len(examples) < dhcpExamples {
+				examples = append(examples, m.client())
+			}
+		}
+		findings = append(findings, newFinding(domain.SeverityWarning, category, pending[0].at, examples,
+			"%s Request Unanswered: %d request(s) got no reply (%s; e.g. %s)",
+			category, len(pending), formatNameCounts(types), strings.Join(examples, ", ")))
+	}
+	return findings
+}
+
+// detectSlowDHCP reports servers answering a discovery or a request slower
+// than the threshold. Client retransmissions count towards the delay, as
+// the client waited for them.
+func (e *Engine) detectSlowDHCP(category string, exchanges []*dhcpExchange) []*domain.Finding {
+	limit := time.Duration(e.thresholds.DHCPSlowSeconds * float64(time.Second))
+	var worst, worstAnswer *dhcpMessage
+	var worstDelay time.Duration
+	slow, total := 0, 0
+	check := func(asked, answer *dhcpMessage) {
+		if asked == nil || answer == nil {
+			return
+		}
+		total++
+		d := answer.at.Sub(asked.at)
+		if d <= limit {
+			return
+		}
+		slow++
+		if d > worstDelay {
+			worst, worstAnswer, worstDelay = asked, answer, d
+		}
+	}
+	for _, ex := range exchanges {
+		check(ex.discover, ex.offer)
+		check(ex.request, ex.reply)
+	}
+	if worst == nil {
+		return nil
+	}
+	server := worstAnswer.Server
+	if server == "" || worstAnswer.Version == 6 {
+		server = worstAnswer.src
+	}
+	return []*domain.Finding{newFinding(domain.SeverityWarning, category, worst.at, []string{worst.client(), worstAnswer.src},
+		"Slow %s Server: %d of %d answers over %.1fs (worst %.2fs: %s from %s to %s of %s)",
+		category, slow, total, limit.Seconds(), worstDelay.Seconds(), worstAnswer.TypeName(), server, worst.TypeName(), worst.client())}
+}
+
+// detectDHCPRefusals reports NAKs and DHCPv6 error statuses per server
+func detectDHCPRefusals(category string, servers map[string]*dhcpServer, order []string) []*domain.Finding {
+	var findings []*domain.Finding
+	for _, id := range order {
+		srv := servers[id]
+		total := 0
+		for _, n := range srv.refusals {
+			total += n
+		}
+		if total == 0 {
+			continue
+		}
+		findings = append(findings, newFinding(domain.SeverityWarning, category, srv.first, []string{srv.addr},
+			"%s Refused: server %s refused %d of %d request(s) (%s)",
+			category, srv.name(), total, srv.replies, formatNameCounts(srv.refusals)))
+	}
+	return findings
+}
+
+// detectDHCPDeclines reports clients that found the address they were
+// given already in use
+func detectDHCPDeclines(category string, declines []*dhcpMessage) []*domain.Finding {
+	if len(declines) == 0 {
+		return nil
+	}
+	clients := map[string]bool{}
+	var hosts, examples []string
+	for _, m := range declines {
+		c := m.client()
+		if clients[c] {
+			continue
+		}
+		clients[c] = true
+		hosts = append(hosts, c)
+		if len(examples) < dhcpExamples {
+			ex := c
+			if m.RequestedIP != "" {
+				ex += " declined " + m.RequestedIP
+			}
+			examples = append(examples, ex)
+		}
+	}
+	return []*domain.Finding{newFinding(domain.SeverityCritical, category, declines[0].at, hosts,
+		"%s Address Conflict: %d DECLINE(s) from %d client(s) that found the leased address already in use (%s)",
+		category, len(declines), len(clients), strings.Join(examples, ", "))}
+}
+
+// detectMultipleDHCPServers reports more than one server offering
+// addresses. Failover pairs do this by design; anything else is usually a
+// rogue server, such as a home router, handing out wrong addresses.
+func detectMultipleDHCPServers(category string, servers map[string]*dhcpServer, order []string) []*domain.Finding {
+	var offering []*dhcpServer
+	for _, id := range order {
+		if srv := servers[id]; srv.offers > 0 {
+			offering = append(offering, srv)
+		}
+	}
+	if len(offering) < 2 {
+		return nil
+	}
+	parts := make([]string, len(offering))
+	hosts := make([]string, len(offering))
+	for i, srv := range offering {
+		parts[i] = fmt.Sprintf("%s (%d offer(s) to %d client(s))", srv.name(), srv.offers, len(srv.clients))
+		hosts[i] = srv.addr
+	}
+	return []*domain.Finding{newFinding(domain.SeverityWarning, category, offering[1].first, hosts,
+		"Multiple %s Servers: %d servers offered addresses: %s; unless they are a failover pair, one may be a rogue server",
+		category, len(offering), strings.Join(parts, ", "))}
+}
@@ -0,0 +1,101 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/dhcp"
+)
+
+// dhcpStream is a one-packet UDP stream carrying a DHCP message. Servers
+// send with their identifier in option 54 and offer yiaddr.
+func dhcpStream(at time.Duration, typ byte, xid uint32, mac, server, yiaddr string) *domain.Stream {
+	b := make([]byte, 240)
+	b[0], b[1], b[2] = 1, 1, 6
+	binary.BigEndian.PutUint32(b[4:], xid)
+	hw, _ := net.ParseMAC(mac)
+	copy(b[28:], hw)
+	binary.BigEndian.PutUint32(b[236:], 0x63825363)
+	b = append(b, 53, 1, typ)
+
+	src, dst := "0.0.0.0", "255.255.255.255"
+	sport, dport := uint16(dhcp.ClientPort), uint16(dhcp.ServerPort)
+	if server != "" {
+		b[0] = 2
+		copy(b[16:], net.ParseIP(yiaddr).To4())
+		b = append(b, 54, 4)
+		b = append(b, net.ParseIP(server).To4()...)
+		src, sport, dport = server, dhcp.ServerPort, dhcp.ClientPort
+	}
+	b = append(b, 255)
+
+	pkt := &domain.PacketMeta{
+		Timestamp: testStart.Add(at), SrcIP: src, DstIP: dst, SrcPort: sport, DstPort: dport,
+		Length: 42 + len(b), PayloadLen: len(b), Payload: b, TTL: 64,
+	}
+	return &domain.Stream{
+		ID: domain.GenerateStreamID(src, dst, sport, dport), ClientIP: src, ServerIP: dst,
+		ClientPort: sport, ServerPort: dport, Transport: "UDP", Protocol: "UDP", Severity: domain.SeverityNormal,
+		Packets: []*domain.PacketMeta{pkt},
+		Stats:   domain.StreamStats{PacketCount: 1, StartTime: pkt.Timestamp, EndTime: pkt.Timestamp},
+	}
+}
+
+func TestRogueDHCPServer(t *testing.T) {
+	ms := time.Millisecond
+	const (
+		macA = "02:00:00:00:00:0a"
+		macB = "02:00:00:00:00:0b"
+	)
+	exchangeA := []*domain.Stream{
+		dhcpStream(0, dhcp.Discover, 1, macA, "", ""),
+		dhcpStream(10*ms, dhcp.Offer, 1, macA, "10.0.0.1", "10.0.0.50"),
+		dhcpStream(20*ms, dhcp.Request, 1, macA, "", ""),
+		dhcpStream(30*ms, dhcp.Ack, 1, macA, "10.0.0.1", "10.0.0.50"),
+	}
+	tests := []struct {
+		name    string
+		streams []*domain.Stream
+		want    string
+		hosts   []string
+	}{
+		{"one server", exchangeA, "", nil},
+		{"rogue server", append(exchangeA[:len(exchangeA):len(exchangeA)],
+			dhcpStream(15*ms, dhcp.Offer, 1, macA, "192.168.1.1", "192.168.1.100"),
+			dhcpStream(time.Second, dhcp.Discover, 2, macB, "", ""),
+			dhcpStream(time.Second+5*ms, dhcp.Offer, 2, macB, "192.168.1.1", "192.168.1.101")),
+			"Multiple DHCP Servers: 2 servers offered addresses: 10.0.0.1 (1 offer(s) to 1 client(s)), 192.168.1.1 (2 offer(s) to 2 client(s)); unless they are a failover pair, one may be a rogue server",
+			[]string{"10.0.0.1", "192.168.1.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewEngine().AnalyzeCapture(tt.streams, nil)
+
+			var found []*domain.Finding
+			var messages []string
+			for _, f := range report.Findings {
+				if f.Category == "DHCP" {
+					found = append(found, f)
+					messages = append(messages, f.Message)
+				}
+			}
+			if tt.want == "" {
+				if len(found) != 0 {
+					t.Errorf("findings = %q, want none", messages)
+				}
+				return
+			}
+			if len(found) != 1 || found[0].Message != tt.want {
+				t.Fatalf("findings = %q, want %q", messages, tt.want)
+			}
+			f := found[0]
+			if f.Severity != domain.SeverityWarning || !f.Time.Equal(testStart.Add(15*ms)) || len(f.Hosts) != 2 ||
+				f.Hosts[0] != tt.hosts[0] || f.Hosts[1] != tt.hosts[1] {
+				t.Errorf("finding = %s at %v for %v", f.Severity, f.Time, f.Hosts)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package analyzer
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/dns"
+)
+
+// dnsFallbackWindow is how long after a truncated UDP answer a TCP retry
+// for the same question is still considered a fallback
+const dnsFallbackWindow = 5 * time.Second
+
+// dissectDNS decodes DNS messages over UDP or TCP, pairs queries with
+// responses by transaction ID and reports unanswered queries and slow
+// resolvers. Storms and TCP fallback span streams and are handled in
+// AnalyzeCapture.
+func (e *Engine) dissectDNS(sc *streamContext) {
+	stream := sc.stream
+	if !claimable(stream, "DNS") || (stream.Protocol != "DNS" && stream.ServerPort != 53 && stream.ClientPort != 53) {
+		return
+	}
+	// mDNS and LLMNR queries go to a multicast group and are answered from
+	// each responder's own address, so they cannot be paired here
+	if ip := net.ParseIP(stream.ServerIP); ip != nil && ip.IsMulticast() {
+		return
+	}
+
+	type dnsMsg struct {
+		*dns.Message
+		fromClient bool
+		at         time.Time
+	}
+	var msgs []dnsMsg
+
+	if stream.Transport == "TCP" {
+		client, server := sc.flows()
+		for _, m := range dns.ParseTCP(client.Data) {
+			msgs = append(msgs, dnsMsg{m, true, client.TimeAt(m.Offset)})
+		}
+		for _, m := range dns.ParseTCP(server.Data) {
+			msgs = append(msgs, dnsMsg{m, false, server.TimeAt(m.Offset)})
+		}
+		sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].at.Before(msgs[j].at) })
+	} else {
+		for _, pkt := range stream.Packets {
+			if len(pkt.Payload) == 0 {
+				continue
+			}
+			if m, err := dns.Parse(pkt.Payload); err == nil {
+				msgs = append(msgs, dnsMsg{m, stream.FromClient(pkt), pkt.Timestamp})
+			}
+		}
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	confirm(stream, "DNS")
+
+	var txs []*domain.Transaction
+	pending := map[uint16]*domain.Transaction{}
+	for _, m := range msgs {
+		if !m.Response {
+			// A retransmitted query reuses its ID; count it as another attempt
+			if tx, ok := pending[m.ID]; ok && tx.Target == m.Name {
+				n, _ := strconv.Atoi(tx.Attributes["attempts"])
+				tx.Attributes["attempts"] = strconv.Itoa(n + 1)
+				continue
+			}
+			client, resolver := stream.ClientIP, stream.ServerIP
+			if !m.fromClient {
+				client, resolver = resolver, client
+			}
+			tx := &domain.Transaction{
+				Protocol:     "DNS",
+				Method:       m.Type,
+				Target:       m.Name,
+				Host:         resolver,
+				RequestTime:  m.at,
+				RequestBytes: int64(m.Size),
+				Error:        "unanswered",
+				Attributes: map[string]string{
+					"id":        strconv.Itoa(int(m.ID)),
+					"client":    client,
+					"transport": stream.Transport,
+					"attempts":  "1",
+				},
+			}
+			pending[m.ID] = tx
+			txs = append(txs, tx)
+			continue
+		}
+
+		tx, ok := pending[m.ID]
+		if !ok {
+			continue // answer to a query sent before the capture started
+		}
+		delete(pending, m.ID)
+		tx.Status = m.RCode
+		tx.StatusText = dns.RCodeName(m.RCode)
+		tx.ResponseTime = m.at
+		tx.ResponseBytes = int64(m.Size)
+		tx.Error = ""
+		if latency := m.at.Sub(tx.RequestTime); latency > 0 {
+			tx.Latency = latency
+		}
+		tx.Attributes["answer_count"] = strconv.Itoa(len(m.Answers))
+		if len(m.Answers) > 0 {
+			tx.Attributes["answers"] = strings.Join(m.Answers, "; ")
+			tx.Attributes["min_ttl"] = strconv.FormatUint(uint64(m.MinTTL), 10)
+		}
+		if m.Truncated {
+			tx.Attributes["truncated"] = "true"
+		}
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	e.detectUnansweredDNS(stream, txs)
+	e.detectSlowDNS(stream, txs)
+}
+
+func (e *Engine) detectUnansweredDNS(stream *domain.Stream, txs []*domain.Transaction) {
+	var unanswered []*domain.Transaction
+	for _, tx := range txs {
+		if tx.ResponseTime.IsZero() {
+			unanswered = append(unanswered, tx)
+		}
+	}
+	if len(unanswered) == 0 {
+		return
+	}
+	first := unanswered[0]
+	raise(stream, domain.SeverityWarning, "Unanswered DNS Queries: %d of %d got no response (first %s %s to %s, %s attempt(s))",
+		len(unanswered), len(txs), first.Method, first.Target, first.Host, first.Attributes["attempts"])
+}
+
+func (e *Engine) detectSlowDNS(stream *domain.Stream, txs []*domain.Transaction) {
+	limit := time.Duration(e.thresholds.DNSSlowResponseSeconds * float64(time.Second))
+	var worst *domain.Transaction
+	slow := 0
+	for _, tx := range txs {
+		if tx.ResponseTime.IsZero() || tx.Latency <= limit {
+			continue
+		}
+		slow++
+		if worst == nil || tx.Latency > worst.Latency {
+			worst = tx
+		}
+	}
+	if worst == nil {
+		return
+	}
+	raise(stream, domain.SeverityWarning, "Slow DNS Resolver: %d of %d responses from %s over %.0fms (worst %.0fms for %s %s)",
+		slow, len(txs), worst.Host, float64(limit)/float64(time.Millisecond),
+		float64(worst.Latency)/float64(time.Millisecond), worst.Method, worst.Target)
+}
+
+// dnsRef ties a DNS transaction to the stream it was decoded from
+type dnsRef struct {
+	stream *domain.Stream
+	tx     *domain.Transaction
+}
+
+func collectDNS(streams []*domain.Stream) []dnsRef {
+	var refs []dnsRef
+	for _, s := range streams {
+		for _, tx := range s.Transactions {
+			if tx.Protocol == "DNS" {
+				refs = append(refs, dnsRef{s, tx})
+			}
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].tx.RequestTime.Before(refs[j].tx.RequestTime) })
+	return refs
+}
+
+// detectDNSErrorStorms looks for bursts of NXDOMAIN or SERVFAIL answers from
+// one resolver. Clients usually pick a new source port per query, so the
+// answers are spread over many streams; the finding goes on the stream that
+// completes the densest burst.
+func (e *Engine) detectDNSErrorStorms(refs []dnsRef) {
+	type key struct {
+		resolver string
+		rcode    int
+	}
+	groups := map[key][]dnsRef{}
+	var order []key
+	for _, r := range refs {
+		if r.tx.ResponseTime.IsZero() || (r.tx.Status != dns.RCodeNXDomain && r.tx.Status != dns.RCodeServFail) {
+			continue
+		}
+		k := key{r.tx.Host, r.tx.Status}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], r)
+	}
+
+	window := time.Duration(e.thresholds.DNSErrorStormWindowSecs * float64(time.Second))
+	for _, k := range order {
+		group := groups[k]
+		if len(group) < e.thresholds.DNSErrorStormCount {
+			continue
+		}
+		times := make([]time.Time, len(group))
+		for i, r := range group {
+			times[i] = r.tx.ResponseTime
+		}
+		best, start, end := densestWindow(times, window)
+		if best < e.thresholds.DNSErrorStormCount {
+			continue
+		}
+
+		names := map[string]bool{}
+		var examples []string
+		for _, r := range group[start : end+1] {
+			if !names[r.tx.Target] && len(examples) < 3 {
+				examples = append(examples, r.tx.Target)
+			}
+			names[r.tx.Target] = true
+		}
+		raise(group[end].stream, domain.SeverityCritical, "DNS %s Storm: %d %s responses from %s within %.0fs for %d distinct name(s) (e.g. %s)",
+			dns.RCodeName(k.rcode), best, dns.RCodeName(k.rcode), k.resolver, window.Seconds(), len(names), strings.Join(examples, ", "))
+	}
+}
+
+// detectDNSTCPFallback follows truncated UDP answers to the TCP retry the
+// client should make for the same question.
+func (e *Engine) detectDNSTCPFallback(refs []dnsRef) {
+	for _, r := range refs {
+		tx := r.tx
+		if tx.Attributes["transport"] != "UDP" || tx.Attributes["truncated"] != "true" {
+			continue
+		}
+
+		var retry *domain.Transaction
+		for _, c := range refs {
+			t := c.tx
+			if t.Attributes["transport"] != "TCP" || t.Target != tx.Target || t.Method != tx.Method ||
+				t.Host != tx.Host || t.Attributes["client"] != tx.Attributes["client"] {
+				continue
+			}
+			if d := t.RequestTime.Sub(tx.ResponseTime); d >= 0 && d <= dnsFallbackWindow {
+				retry = t
+				break
+			}
+		}
+
+		switch {
+		case retry == nil:
+			raise(r.stream, domain.SeverityWarning, "DNS Truncated Response Not Retried: %s %s from %s was truncated and no TCP query followed",
+				tx.Method, tx.Target, tx.Host)
+		case retry.ResponseTime.IsZero():
+			raise(r.stream, domain.SeverityCritical, "DNS TCP Fallback Failed: %s %s was truncated over UDP and the TCP retry to %s got no answer",
+				tx.Method, tx.Target, tx.Host)
+		default:
+			raise(r.stream, domain.SeverityWarning, "DNS TCP Fallback: %s %s was truncated over UDP and retried over TCP (answered in %.0fms, %s)",
+				tx.Method, tx.Target, float64(retry.ResponseTime.Sub(tx.RequestTime))/float64(time.Millisecond), retry.StatusText)
+		}
+	}
+}
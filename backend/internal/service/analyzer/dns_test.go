@@ -0,0 +1,130 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// dnsMessage encodes an A query for name, or its answer when rcode >= 0
+func dnsMessage(t *testing.T, id uint16, name string, rcode int, truncated bool) string {
+	t.Helper()
+	d := &layers.DNS{
+		ID: id, RD: true,
+		Questions: []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+	}
+	if rcode >= 0 {
+		d.QR, d.RA, d.TC, d.ResponseCode = true, true, truncated, layers.DNSResponseCode(rcode)
+		if rcode == 0 && !truncated {
+			d.Answers = []layers.DNSResourceRecord{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.IPv4(192, 0, 2, 1)}}
+		}
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := d.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf.Bytes())
+}
+
+// framed prefixes a DNS message with its length, as sent over TCP
+func framed(msg string) string {
+	return string(binary.BigEndian.AppendUint16(nil, uint16(len(msg)))) + msg
+}
+
+// dnsLookup is one UDP query answered with rcode after 5ms, from its own
+// client port like a stub resolver
+func dnsLookup(t *testing.T, port uint16, at time.Duration, name string, rcode int, truncated bool) *domain.Stream {
+	c := newDatagrams(port, 53)
+	c.send(true, at, dnsMessage(t, port, name, -1, false))
+	c.send(false, at+5*time.Millisecond, dnsMessage(t, port, name, rcode, truncated))
+	return c.finish()
+}
+
+func TestDNSErrorStorm(t *testing.T) {
+	e := NewEngine()
+	var streams []*domain.Stream
+	for i := 0; i < 12; i++ {
+		streams = append(streams, dnsLookup(t, uint16(50000+i), time.Duration(i)*500*time.Millisecond, fmt.Sprintf("h%d.example.com", i), 3, false))
+	}
+	// Failures below the storm count and a slower trickle don't qualify
+	for i := 0; i < 5; i++ {
+		streams = append(streams, dnsLookup(t, uint16(51000+i), time.Duration(i)*time.Second, "down.example.com", 2, false))
+	}
+	for i := 0; i < 12; i++ {
+		streams = append(streams, dnsLookup(t, uint16(52000+i), time.Minute+time.Duration(i)*2*time.Second, "slow.example.com", 3, false))
+	}
+	for _, s := range streams {
+		e.AnalyzeStream(s)
+		if s.Protocol != "DNS" || len(s.Transactions) != 1 {
+			t.Fatalf("stream %s = %s with %d transactions", s.ID, s.Protocol, len(s.Transactions))
+		}
+	}
+	e.AnalyzeCapture(streams, nil)
+
+	var storms []string
+	for _, s := range streams {
+		if f := findAnalysis(s, "DNS "); strings.Contains(f, "Storm") {
+			storms = append(storms, f)
+			if s != streams[11] {
+				t.Errorf("storm reported on %s, want the stream completing the burst", s.ID)
+			}
+			if s.Severity != domain.SeverityCritical {
+				t.Errorf("severity = %s, want critical", s.Severity)
+			}
+		}
+	}
+	want := "DNS NXDOMAIN Storm: 12 NXDOMAIN responses from 10.0.0.2 within 10s for 12 distinct name(s) (e.g. h0.example.com, h1.example.com, h2.example.com)"
+	if len(storms) != 1 || storms[0] != want {
+		t.Errorf("storms = %q, want %q", storms, want)
+	}
+}
+
+func TestDNSTCPFallback(t *testing.T) {
+	ms := time.Millisecond
+	retry := func(answered bool) *domain.Stream {
+		c := newConn(40000, 53).handshake(20 * ms)
+		c.send(true, 25*ms, framed(dnsMessage(t, 7, "big.example.com", -1, false)))
+		if answered {
+			c.send(false, 60*ms, framed(dnsMessage(t, 7, "big.example.com", 0, false)))
+		}
+		return c.finish()
+	}
+	tests := []struct {
+		name  string
+		retry *domain.Stream
+		want  string
+	}{
+		{"retried", retry(true), "DNS TCP Fallback: A big.example.com was truncated over UDP and retried over TCP (answered in 60ms, NOERROR)"},
+		{"retry unanswered", retry(false), "DNS TCP Fallback Failed: A big.example.com was truncated over UDP and the TCP retry to 10.0.0.2 got no answer"},
+		{"not retried", nil, "DNS Truncated Response Not Retried: A big.example.com from 10.0.0.2 was truncated and no TCP query followed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			udp := dnsLookup(t, 50000, 0, "big.example.com", 0, true)
+			streams := []*domain.Stream{udp}
+			if tt.retry != nil {
+				streams = append(streams, tt.retry)
+			}
+			for _, s := range streams {
+				e.AnalyzeStream(s)
+			}
+			if tt.retry != nil && (tt.retry.Protocol != "DNS" || len(tt.retry.Transactions) != 1) {
+				t.Fatalf("TCP retry = %s with %d transactions", tt.retry.Protocol, len(tt.retry.Transactions))
+			}
+			e.AnalyzeCapture(streams, nil)
+
+			if !hasAnalysis(udp, tt.want) {
+				t.Errorf("findings = %q, want %q", udp.Analysis, tt.want)
+			}
+		})
+	}
+}
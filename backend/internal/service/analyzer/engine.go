@@ -5,69 +5,108 @@ import (
 	"time"
 
 	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/classify"
+	tlsdissect "pcap-analyzer/internal/service/dissector/tls"
+	"pcap-analyzer/internal/service/reassembly"
 )
 
 // Engine runs the analysis algorithms on streams
-type Engine struct{}
+type Engine struct {
+	thresholds   Thresholds
+	keys         *tlsdissect.KeyLog
+	classifier   *classify.Classifier
+	seriesBucket time.Duration
+}
 
 func NewEngine() *Engine {
-	return &Engine{}
+	return NewEngineWithThresholds(DefaultThresholds())
+}
+
+func NewEngineWithThresholds(t Thresholds) *Engine {
+	return &Engine{thresholds: t, classifier: classify.New(nil)}
+}
+
+// SetKeyLog supplies TLS session secrets so encrypted streams can be decrypted
+func (e *Engine) SetKeyLog(keys *tlsdissect.KeyLog) {
+	e.keys = keys
+}
+
+// SetPortHints replaces the classifier's port hints with the defaults plus
+// the given overrides
+func (e *Engine) SetPortHints(overrides classify.PortHints) {
+	e.classifier = classify.New(overrides)
 }
 
 // AnalyzeStream runs all detection logic on a single stream
 func (e *Engine) AnalyzeStream(stream *domain.Stream) {
 	e.detectRetransmissions(stream)
+	measureThroughput(stream)
+	e.analyzeCongestion(stream)
+	analyzeTCPOptions(stream)
 	e.detectResetsAndTimouts(stream)
 	e.detectLowMSS(stream)
-	e.detectDillonsSymptoms(stream)
-	e.detectApplicationLayer(stream)
+	e.classifyStream(stream)
+	e.detectICMP(stream)
+
+	// Application-layer dissectors share one reassembly of the stream
+	sc := &streamContext{stream: stream}
+	e.dissectHTTP2(sc)
+	e.dissectHTTP(sc)
+	e.dissectMySQL(sc)
+	e.dissectPostgreSQL(sc)
+	e.dissectRedis(sc)
+	e.dissectSMB(sc)
+	e.dissectNFS(sc)
+	e.dissectKafka(sc)
+	e.dissectMQTT(sc)
+	e.dissectTLS(sc)
+	e.dissectQUIC(sc)
+	e.dissectDNS(sc)
+	e.dissectSIP(sc)
+	e.analyzeRTP(stream)
+
+	// Runs last so it can defer to dissectors that explain the traffic
+	e.analyzeUDP(stream)
 }
 
-func (e *Engine) detectApplicationLayer(stream *domain.Stream) {
-	// If already detected as something specific (not just TCP/UDP), skip
-	if stream.Protocol != "TCP" && stream.Protocol != "UDP" {
-		return
-	}
-
-	for _, pkt := range stream.Packets {
-		if len(pkt.Payload) == 0 {
-			continue
-		}
-
-		payload := pkt.Payload
-
-		// 1. TLS Client Hello (Content Type 22, Version 0x03xx, Handshake Type 1)
-		if len(payload) > 5 && payload[0] == 0x16 && payload[1] == 0x03 && payload[5] == 0x01 {
-			stream.Protocol = "TLS"
-			return
-		}
-
-		// 2. HTTP Methods
-		if len(payload) > 4 {
-			prefix := string(payload[:4])
-			if prefix == "GET " || prefix == "POST" || prefix == "HEAD" || prefix == "PUT " || prefix == "HTTP" {
-				stream.Protocol = "HTTP"
-				return
-			}
-		}
-
-		// 3. SSH
-		if len(payload) > 4 && string(payload[:4]) == "SSH-" {
-			stream.Protocol = "SSH"
-			return
-		}
+// AnalyzePartial runs only the cheap TCP detectors (retransmissions, resets
+// and low MSS) on a stream, without reassembly or dissectors, for findings
+// published while the capture is still being read. AnalyzeStream repeats
+// them in the final pass.
+func (e *Engine) AnalyzePartial(stream *domain.Stream) {
+	e.detectRetransmissions(stream)
+	e.detectResetsAndTimouts(stream)
+	e.detectLowMSS(stream)
+}
 
-		// 4. DNS (usually UDP, but check payload for Transaction ID + Flags)
-		// Simple heuristic: UDP + Port 53 is usually enough, but let's check if it's not already set
-		if stream.ServerPort == 53 || stream.ClientPort == 53 {
-			stream.Protocol = "DNS"
-			return
-		}
+// AnalyzeCapture runs the detectors that correlate several streams and
+// those for ARP, DHCP, middleboxes and idle timeouts, whose findings belong
+// to the capture. It must be called after AnalyzeStream has run on every
+// stream.
+func (e *Engine) AnalyzeCapture(streams []*domain.Stream, arp []*domain.ARPPacket) *domain.CaptureReport {
+	refs := collectDNS(streams)
+	e.detectDNSErrorStorms(refs)
+	e.detectDNSTCPFallback(refs)
+	linkQUICMigrations(streams)
+	e.reportSlowStatements(streams)
+	e.reportSlowFiles(streams)
+
+	report := &domain.CaptureReport{
+		Calls: e.analyzeCalls(streams),
+		Stats: e.captureStats(streams, arp),
+	}
+	report.Findings = append(report.Findings, e.analyzeARP(arp)...)
+	report.Findings = append(report.Findings, e.analyzeDHCP(collectDHCP(streams))...)
+	report.Findings = append(report.Findings, e.detectMiddleboxes(streams)...)
+	report.Findings = append(report.Findings, e.inferIdleTimeouts(streams)...)
+	for _, s := range streams {
+		e.detectDillonsSymptoms(s)
 	}
+	return report
 }
 
 func (e *Engine) detectLowMSS(stream *domain.Stream) {
-	if (stream.ClientMSS > 0 && stream.ClientMSS < 1260) || (stream.ServerMSS > 0 && stream.ServerMSS < 1260) {
+	if e.isLowMSS(stream) {
 		stream.Analysis = append(stream.Analysis, fmt.Sprintf("Low MSS Detected (Client: %d, Server: %d)", stream.ClientMSS, stream.ServerMSS))
 		if stream.Severity != domain.SeverityCritical {
 			stream.Severity = domain.SeverityWarning
@@ -76,6 +115,9 @@ func (e *Engine) detectLowMSS(stream *domain.Stream) {
 }
 
 func (e *Engine) detectRetransmissions(stream *domain.Stream) {
+	if stream.Transport != "TCP" {
+		return // sequence numbers only exist in TCP
+	}
 	seqMap := make(map[uint32]int)
 	retransCount := 0
 
@@ -93,7 +135,7 @@ func (e *Engine) detectRetransmissions(stream *domain.Stream) {
 	stream.Stats.RetransmissionCount = retransCount
 	if retransCount > 0 {
 		rate := float64(retransCount) / float64(stream.Stats.PacketCount) * 100
-		if rate > 5.0 {
+		if rate > e.thresholds.RetransRatePercent {
 			stream.Analysis = append(stream.Analysis, fmt.Sprintf("High Retransmission Rate: %.2f%%", rate))
 			if stream.Severity != domain.SeverityCritical {
 				stream.Severity = domain.SeverityWarning
@@ -102,41 +144,76 @@ func (e *Engine) detectRetransmissions(stream *domain.Stream) {
 	}
 }
 
+// detectResetsAndTimouts counts resets and records the silence before the
+// stream was closed, which inferIdleTimeouts clusters across the streams
+// to each server
 func (e *Engine) detectResetsAndTimouts(stream *domain.Stream) {
-	var lastPktTime time.Time
 	rstCount := 0
-	hasTimeout := false
-
 	for i, pkt := range stream.Packets {
-		// Check for RST
-		for _, flag := range pkt.Flags {
-			if flag == "RST" {
-				rstCount++
-				// Check gap before RST
-				if i > 0 {
-					gap := pkt.Timestamp.Sub(lastPktTime)
-					// 9.6s is the magic number from the requirements
-					if gap > 9*time.Second && gap < 11*time.Second {
-						stream.Analysis = append(stream.Analysis, fmt.Sprintf("Timeout Pattern: RST after %.2fs gap", gap.Seconds()))
-						hasTimeout = true
-						stream.Severity = domain.SeverityCritical
-					}
+		rst := hasFlag(pkt, "RST")
+		if rst {
+			rstCount++
+		}
+		if (rst || hasFlag(pkt, "FIN")) && stream.Stats.CloseFlag == "" {
+			stream.Stats.CloseFlag = "FIN"
+			if rst {
+				stream.Stats.CloseFlag = "RST"
+			}
+			if i > 0 {
+				if gap := pkt.Timestamp.Sub(stream.Packets[i-1].Timestamp); gap.Seconds() >= e.thresholds.IdleTimeoutMinSeconds {
+					stream.Stats.IdleBeforeClose = gap
 				}
 			}
 		}
-		lastPktTime = pkt.Timestamp
 	}
-
 	stream.Stats.ResetCount = rstCount
-	stream.Stats.HasTimeout = hasTimeout
 }
 
 func (e *Engine) detectDillonsSymptoms(stream *domain.Stream) {
 	// "Dillon's Symptoms": Low MSS + High Retrans + Timeout
-	isLowMSS := (stream.ClientMSS > 0 && stream.ClientMSS < 1260) || (stream.ServerMSS > 0 && stream.ServerMSS < 1260)
-
-	if isLowMSS && stream.Stats.RetransmissionCount > 5 && stream.Stats.HasTimeout {
+	if e.isLowMSS(stream) && stream.Stats.RetransmissionCount > e.thresholds.DillonMinRetransmissions && stream.Stats.HasTimeout {
 		stream.Analysis = append(stream.Analysis, "MATCH: Dillon's Symptoms (Low MSS + Retrans + Timeout)")
 		stream.Severity = domain.SeverityCritical
 	}
 }
+
+func (e *Engine) isLowMSS(stream *domain.Stream) bool {
+	low := e.thresholds.LowMSS
+	return (stream.ClientMSS > 0 && stream.ClientMSS < low) || (stream.ServerMSS > 0 && stream.ServerMSS < low)
+}
+
+// streamContext carries per-stream state shared by the dissectors
+type streamContext struct {
+	stream *domain.Stream
+
+	reassembled    bool
+	client, server *reassembly.Flow
+}
+
+// flows returns the reassembled TCP payload of both directions, built on first use
+func (sc *streamContext) flows() (client, server *reassembly.Flow) {
+	if !sc.reassembled {
+		sc.client, sc.server = reassembly.Reassemble(sc.stream)
+		sc.reassembled = true
+	}
+	return sc.client, sc.server
+}
+
+// newFinding builds a capture-level finding
+func newFinding(severity domain.Severity, category string, at time.Time, hosts []string, format string, args ...interface{}) *domain.Finding {
+	return &domain.Finding{
+		Severity: severity,
+		Category: category,
+		Message:  fmt.Sprintf(format, args...),
+		Time:     at,
+		Hosts:    hosts,
+	}
+}
+
+// raise records a finding and escalates the stream severity (never lowers it)
+func raise(stream *domain.Stream, severity domain.Severity, format string, args ...interface{}) {
+	stream.Analysis = append(stream.Analysis, fmt.Sprintf(format, args...))
+	if severity == domain.SeverityCritical || stream.Severity == domain.SeverityNormal {
+		stream.Severity = severity
+	}
+}
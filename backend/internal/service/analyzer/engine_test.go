@@ -0,0 +1,140 @@
+package analyzer
+
+import (
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+var testStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// conn builds a TCP stream between 10.0.0.1 and 10.0.0.2 packet by packet,
+// keeping the sequence and acknowledgment numbers of both sides
+type conn struct {
+	stream *domain.Stream
+	seq    [2]uint32 // next sequence number of the client and the server
+}
+
+func newConn(clientPort, serverPort uint16) *conn {
+	return &conn{
+		stream: &domain.Stream{
+			ID:         domain.GenerateStreamID("10.0.0.1", "10.0.0.2", clientPort, serverPort),
+			ClientIP:   "10.0.0.1",
+			ServerIP:   "10.0.0.2",
+			ClientPort: clientPort,
+			ServerPort: serverPort,
+			Transport:  "TCP",
+			Protocol:   "TCP",
+			Severity:   domain.SeverityNormal,
+		},
+		seq: [2]uint32{1000, 5000},
+	}
+}
+
+// newDatagrams is newConn for a UDP flow; send then ignores sequence
+// numbers and flags
+func newDatagrams(clientPort, serverPort uint16) *conn {
+	c := newConn(clientPort, serverPort)
+	c.stream.Transport, c.stream.Protocol = "UDP", "UDP"
+	return c
+}
+
+// handshake adds a SYN, SYN-ACK and ACK starting at the given time
+func (c *conn) handshake(at time.Duration) *conn {
+	c.send(true, at, "", "SYN")
+	c.send(false, at+time.Millisecond, "", "SYN", "ACK")
+	c.send(true, at+2*time.Millisecond, "", "ACK")
+	return c
+}
+
+// send adds a packet carrying payload from one side and advances that
+// side's sequence number; SYN and FIN take one number each
+func (c *conn) send(fromClient bool, at time.Duration, payload string, flags ...string) *domain.PacketMeta {
+	from, to := 1, 0
+	if fromClient {
+		from, to = 0, 1
+	}
+	s := c.stream
+	pkt := &domain.PacketMeta{
+		Timestamp:  testStart.Add(at),
+		Length:     54 + len(payload),
+		SrcIP:      s.ServerIP,
+		DstIP:      s.ClientIP,
+		SrcPort:    s.ServerPort,
+		DstPort:    s.ClientPort,
+		Seq:        c.seq[from],
+		Ack:        c.seq[to],
+		Flags:      flags,
+		PayloadLen: len(payload),
+		Payload:    []byte(payload),
+		Window:     65535,
+		TTL:        64,
+	}
+	if fromClient {
+		pkt.SrcIP, pkt.DstIP, pkt.SrcPort, pkt.DstPort = s.ClientIP, s.ServerIP, s.ClientPort, s.ServerPort
+	}
+	if s.Transport == "UDP" {
+		pkt.Length, pkt.Seq, pkt.Ack, pkt.Window = 42+len(payload), 0, 0, 0
+		s.Packets = append(s.Packets, pkt)
+		return pkt
+	}
+	if len(flags) == 0 {
+		pkt.Flags = []string{"ACK"}
+	}
+	c.seq[from] += uint32(len(payload))
+	for _, f := range flags {
+		if f == "SYN" || f == "FIN" {
+			c.seq[from]++
+		}
+	}
+	s.Packets = append(s.Packets, pkt)
+	return pkt
+}
+
+// resend adds a copy of an earlier packet at a later time, as a
+// retransmission
+func (c *conn) resend(pkt *domain.PacketMeta, at time.Duration) *domain.PacketMeta {
+	again := *pkt
+	again.Timestamp = testStart.Add(at)
+	again.IsRetrans = false
+	c.stream.Packets = append(c.stream.Packets, &again)
+	return &again
+}
+
+// lose advances one side's sequence number as if n bytes were sent in
+// packets the capture missed
+func (c *conn) lose(fromClient bool, n int) {
+	if fromClient {
+		c.seq[0] += uint32(n)
+	} else {
+		c.seq[1] += uint32(n)
+	}
+}
+
+// finish fills in the stream's packet count and times
+func (c *conn) finish() *domain.Stream {
+	s := c.stream
+	s.Stats.PacketCount = len(s.Packets)
+	if len(s.Packets) > 0 {
+		s.Stats.StartTime = s.Packets[0].Timestamp
+		s.Stats.EndTime = s.Packets[len(s.Packets)-1].Timestamp
+		s.Stats.Duration = s.Stats.EndTime.Sub(s.Stats.StartTime)
+	}
+	return s
+}
+
+// hasAnalysis reports whether one of the stream's findings starts with prefix
+func hasAnalysis(stream *domain.Stream, prefix string) bool {
+	return findAnalysis(stream, prefix) != ""
+}
+
+// findAnalysis returns the first finding that starts with prefix
+func findAnalysis(stream *domain.Stream, prefix string) string {
+	for _, a := range stream.Analysis {
+		if strings.HasPrefix(a, prefix) {
+			return a
+		}
+	}
+	return ""
+}
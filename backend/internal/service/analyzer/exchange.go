@@ -0,0 +1,44 @@
+package analyzer
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flowRequest builds the transaction for one request spanning [offset, end)
+// of the client flow
+func flowRequest(stream *domain.Stream, protocol, method, target string, client *reassembly.Flow, offset, end int) *domain.Transaction {
+	return &domain.Transaction{
+		Protocol:     protocol,
+		Method:       method,
+		Target:       shortenStatement(target, maxStatementLen),
+		Host:         net.JoinHostPort(stream.ServerIP, strconv.Itoa(int(stream.ServerPort))),
+		RequestTime:  client.TimeAt(offset),
+		RequestBytes: int64(end - offset),
+		Attributes:   map[string]string{},
+	}
+}
+
+// flowRespond records the response spanning [offset, end) of the server flow.
+// Latency runs from the last request byte to the first response byte;
+// duration_ms also covers transferring the response.
+func flowRespond(tx *domain.Transaction, client, server *reassembly.Flow, reqEnd, offset, end int) {
+	sent := client.TimeAt(reqEnd - 1)
+	tx.ResponseTime = server.TimeAt(offset)
+	tx.ResponseBytes = int64(end - offset)
+	if latency := tx.ResponseTime.Sub(sent); latency > 0 {
+		tx.Latency = latency
+	}
+	tx.Attributes["duration_ms"] = fmt.Sprintf("%.1f", msBetween(sent, server.TimeAt(end-1)))
+}
+
+// flowUnanswered marks a request the server never answered
+func flowUnanswered(tx *domain.Transaction, client, server *reassembly.Flow) {
+	if server.Closed() || client.RST {
+		tx.Error = "closed before response"
+	}
+}
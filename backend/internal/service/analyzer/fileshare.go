@@ -0,0 +1,220 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// severeFileErrors are statuses that point at the server, its storage or
+// the session rather than at one file: full or failing disks, exhausted
+// resources, stale handles, servers asking clients to back off, lost
+// sessions and rejected credentials
+var severeFileErrors = map[string]map[string]bool{
+	"SMB": {
+		"STATUS_DISK_FULL":               true,
+		"STATUS_INSUFFICIENT_RESOURCES":  true,
+		"STATUS_IO_TIMEOUT":              true,
+		"STATUS_NETWORK_SESSION_EXPIRED": true,
+		"STATUS_USER_SESSION_DELETED":    true,
+		"STATUS_CONNECTION_DISCONNECTED": true,
+		"STATUS_SERVER_UNAVAILABLE":      true,
+		"STATUS_NETWORK_NAME_DELETED":    true,
+	},
+	"NFS": {
+		"NFS3ERR_IO":          true,
+		"NFS4ERR_IO":          true,
+		"NFS3ERR_NOSPC":       true,
+		"NFS4ERR_NOSPC":       true,
+		"NFS3ERR_DQUOT":       true,
+		"NFS4ERR_DQUOT":       true,
+		"NFS3ERR_ROFS":        true,
+		"NFS4ERR_ROFS":        true,
+		"NFS3ERR_STALE":       true,
+		"NFS4ERR_STALE":       true,
+		"NFS3ERR_SERVERFAULT": true,
+		"NFS4ERR_SERVERFAULT": true,
+		"NFS3ERR_JUKEBOX":     true,
+		"NFS4ERR_DELAY":       true,
+		"NFS4ERR_GRACE":       true,
+		"NFS4ERR_RESOURCE":    true,
+		"NFS4ERR_BADSESSION":  true,
+		"NFS4ERR_EXPIRED":     true,
+		"AUTH_BADCRED":        true,
+		"AUTH_REJECTEDCRED":   true,
+		"AUTH_TOOWEAK":        true,
+		"AUTH_FAILED":         true,
+		"SYSTEM_ERR":          true,
+	},
+}
+
+// fileTarget names the object of a file operation for findings
+func fileTarget(tx *domain.Transaction) string {
+	if tx.Target == "" {
+		return tx.Method
+	}
+	return tx.Method + " " + shortenStatement(tx.Target, 80)
+}
+
+// detectFileErrors reports failed file operations and requests cut off by
+// the connection closing. Storage, resource and session errors are
+// critical, the rest warnings. Operations the dissector reports on its own
+// (logins, share connections) are skipped.
+func (e *Engine) detectFileErrors(stream *domain.Stream, protocol string, txs []*domain.Transaction, skip func(*domain.Transaction) bool) {
+	codes := map[string]int{}
+	var first *domain.Transaction
+	severity := domain.SeverityWarning
+	failed, total := 0, 0
+	for _, tx := range txs {
+		if skip != nil && skip(tx) {
+			continue
+		}
+		total++
+		if tx.Error == "" {
+			continue
+		}
+		failed++
+		code := tx.Attributes["error_code"]
+		if code == "" {
+			code = "closed"
+		}
+		codes[code]++
+		if first == nil {
+			first = tx
+		}
+		if severeFileErrors[protocol][code] {
+			severity = domain.SeverityCritical
+		}
+	}
+	if first == nil {
+		return
+	}
+	raise(stream, severity, "%s Errors: %d of %d operations failed (%s); first %s: %s",
+		protocol, failed, total, formatNameCounts(codes), fileTarget(first), first.Error)
+}
+
+// detectSlowFileOps reports operations answered slower than the threshold.
+// Blocking operations (change notifications) wait on purpose.
+func (e *Engine) detectSlowFileOps(stream *domain.Stream, protocol string, txs []*domain.Transaction) {
+	limit := time.Duration(e.thresholds.FileSlowOpSeconds * float64(time.Second))
+	var worst *domain.Transaction
+	slow, total := 0, 0
+	for _, tx := range txs {
+		if tx.Attributes["blocking"] == "true" {
+			continue
+		}
+		total++
+		if tx.ResponseTime.IsZero() || tx.Latency <= limit {
+			continue
+		}
+		slow++
+		if worst == nil || tx.Latency > worst.Latency {
+			worst = tx
+		}
+	}
+	if worst == nil {
+		return
+	}
+	raise(stream, domain.SeverityWarning, "Slow %s Operations: %d of %d over %.1fs (worst %.2fs for %s)",
+		protocol, slow, total, limit.Seconds(), worst.Latency.Seconds(), fileTarget(worst))
+}
+
+// fileStats aggregates the operations on one file
+type fileStats struct {
+	name    string
+	count   int
+	slow    int
+	failed  int
+	total   time.Duration
+	max     time.Duration
+	slowest *domain.Stream // stream of the slowest operation
+}
+
+// reportSlowFiles ranks, for each file server, the files with slow
+// operations by their total time across all connections in the capture.
+// The ranking is attached to the stream of the slowest operation.
+func (e *Engine) reportSlowFiles(streams []*domain.Stream) {
+	limit := time.Duration(e.thresholds.FileSlowOpSeconds * float64(time.Second))
+	type serverKey struct{ protocol, host string }
+	servers := map[serverKey]map[string]*fileStats{}
+	var order []serverKey
+
+	for _, s := range streams {
+		for _, tx := range s.Transactions {
+			if tx.Protocol != "SMB" && tx.Protocol != "NFS" {
+				continue
+			}
+			file := tx.Attributes["file"]
+			if file == "" || tx.ResponseTime.IsZero() || tx.Attributes["blocking"] == "true" {
+				continue
+			}
+			if share := tx.Attributes["share"]; share != "" {
+				file = share + `\` + file
+			}
+			key := serverKey{tx.Protocol, tx.Host}
+			stats := servers[key]
+			if stats == nil {
+				stats = map[string]*fileStats{}
+				servers[key] = stats
+				order = append(order, key)
+			}
+			st := stats[file]
+			if st == nil {
+				st = &fileStats{name: file}
+				stats[file] = st
+			}
+			st.count++
+			st.total += tx.Latency
+			if tx.Latency > limit {
+				st.slow++
+			}
+			if tx.Error != "" {
+				st.failed++
+			}
+			if st.slowest == nil || tx.Latency > st.max {
+				st.max, st.slowest = tx.Latency, s
+			}
+		}
+	}
+
+	for _, key := range order {
+		var ranked []*fileStats
+		for _, st := range servers[key] {
+			if st.slow > 0 {
+				ranked = append(ranked, st)
+			}
+		}
+		if len(ranked) == 0 {
+			continue
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			if ranked[i].total != ranked[j].total {
+				return ranked[i].total > ranked[j].total
+			}
+			return ranked[i].name < ranked[j].name
+		})
+		worst := ranked[0]
+		for _, st := range ranked {
+			if st.max > worst.max {
+				worst = st
+			}
+		}
+		if n := e.thresholds.FileTopFiles; n > 0 && len(ranked) > n {
+			ranked = ranked[:n]
+		}
+		parts := make([]string, 0, len(ranked))
+		for _, st := range ranked {
+			part := fmt.Sprintf("%s (%d ops, %d slow, avg %.2fs, max %.2fs", shortenStatement(st.name, 100),
+				st.count, st.slow, (st.total / time.Duration(st.count)).Seconds(), st.max.Seconds())
+			if st.failed > 0 {
+				part += fmt.Sprintf(", %d failed", st.failed)
+			}
+			parts = append(parts, part+")")
+		}
+		raise(worst.slowest, domain.SeverityNormal, "Top Slow %s Files on %s: %s",
+			key.protocol, key.host, strings.Join(parts, "; "))
+	}
+}
@@ -0,0 +1,195 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/http1"
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// dissectHTTP decodes HTTP/1.x transactions from the reassembled stream and
+// reports slow responses, 5xx bursts and connections closed mid-response.
+func (e *Engine) dissectHTTP(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" {
+		return
+	}
+	if !claimable(stream, "HTTP") {
+		return
+	}
+
+	client, server := sc.flows()
+	if !http1.IsRequestStart(client.Data) && !http1.IsResponseStart(server.Data) {
+		return
+	}
+	e.analyzeHTTP1(stream, client, server)
+}
+
+// analyzeHTTP1 records the HTTP/1.x transactions of a pair of flows, which
+// may be cleartext or decrypted TLS
+func (e *Engine) analyzeHTTP1(stream *domain.Stream, client, server *reassembly.Flow) {
+	confirm(stream, "HTTP")
+
+	reqs := http1.ParseRequests(client)
+	resps := http1.ParseResponses(server, reqs, server.Closed())
+
+	// Pair final responses with requests in order (pipelining keeps order).
+	// Once a message was lost the order is unknown, so pairing stops there.
+	lost := false
+	final := make([]http1.Response, 0, len(resps))
+	for _, r := range resps {
+		if r.AfterLoss {
+			lost = true
+			break
+		}
+		if r.StatusCode >= 200 {
+			final = append(final, r)
+		}
+	}
+	paired := len(final)
+	for i, req := range reqs {
+		if req.AfterLoss {
+			lost = true
+			paired = min(paired, i)
+			break
+		}
+	}
+
+	var txs []*domain.Transaction
+	for i, req := range reqs {
+		tx := &domain.Transaction{
+			Protocol:     "HTTP",
+			Method:       req.Method,
+			Target:       req.URI,
+			Host:         req.Host,
+			RequestTime:  client.TimeAt(req.Start),
+			RequestBytes: int64(req.End - req.Start),
+			Attributes:   map[string]string{"version": req.Version},
+		}
+		if i < paired {
+			resp := final[i]
+			tx.Status = resp.StatusCode
+			tx.StatusText = resp.Reason
+			tx.ResponseTime = server.TimeAt(resp.Start)
+			tx.ResponseBytes = int64(resp.End - resp.Start)
+			tx.Attributes["content_length"] = strconv.FormatInt(resp.ContentLength, 10)
+
+			// Latency: last request byte to first response byte
+			if latency := tx.ResponseTime.Sub(client.TimeAt(req.End - 1)); latency > 0 {
+				tx.Latency = latency
+			}
+			if !resp.Complete && (server.Closed() || client.RST) && !server.HasGap(resp.HeaderEnd, len(server.Data)+1) {
+				tx.Error = fmt.Sprintf("closed mid-response (received %d of %d body bytes)",
+					resp.End-resp.HeaderEnd, resp.ContentLength)
+			}
+		} else if !lost && (server.Closed() || client.RST) {
+			tx.Error = "closed before response"
+		}
+		txs = append(txs, tx)
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	e.detectSlowHTTP(stream, txs)
+	e.detectHTTP5xxBurst(stream, txs)
+	e.detectHTTPAborts(stream, txs)
+}
+
+func (e *Engine) detectSlowHTTP(stream *domain.Stream, txs []*domain.Transaction) {
+	limit := time.Duration(e.thresholds.HTTPSlowResponseSeconds * float64(time.Second))
+	var slow []*domain.Transaction
+	for _, tx := range txs {
+		if !tx.ResponseTime.IsZero() && tx.Latency > limit {
+			slow = append(slow, tx)
+		}
+	}
+	if len(slow) == 0 {
+		return
+	}
+
+	worst := slow[0]
+	for _, tx := range slow {
+		if tx.Latency > worst.Latency {
+			worst = tx
+		}
+	}
+	raise(stream, domain.SeverityWarning, "Slow HTTP Responses: %d of %d over %.1fs (worst %.2fs for %s %s)",
+		len(slow), len(txs), limit.Seconds(), worst.Latency.Seconds(), worst.Method, worst.Target)
+}
+
+func (e *Engine) detectHTTP5xxBurst(stream *domain.Stream, txs []*domain.Transaction) {
+	var errTimes []time.Time
+	codes := map[int]int{}
+	for _, tx := range txs {
+		if tx.Status >= 500 && tx.Status <= 599 {
+			errTimes = append(errTimes, tx.ResponseTime)
+			codes[tx.Status]++
+		}
+	}
+	if len(errTimes) < e.thresholds.HTTP5xxBurstCount {
+		return
+	}
+	sort.Slice(errTimes, func(i, j int) bool { return errTimes[i].Before(errTimes[j]) })
+
+	window := time.Duration(e.thresholds.HTTP5xxBurstWindowSecs * float64(time.Second))
+	best, _, _ := densestWindow(errTimes, window)
+	if best < e.thresholds.HTTP5xxBurstCount {
+		return
+	}
+
+	raise(stream, domain.SeverityCritical, "HTTP 5xx Burst: %d server errors within %.0fs (%s)",
+		best, window.Seconds(), formatCodeCounts(codes))
+}
+
+func (e *Engine) detectHTTPAborts(stream *domain.Stream, txs []*domain.Transaction) {
+	var aborted []*domain.Transaction
+	for _, tx := range txs {
+		if tx.Error != "" {
+			aborted = append(aborted, tx)
+		}
+	}
+	if len(aborted) == 0 {
+		return
+	}
+	first := aborted[0]
+	raise(stream, domain.SeverityCritical, "HTTP Connection Closed Mid-Response: %d request(s), first %s %s (%s)",
+		len(aborted), first.Method, first.Target, first.Error)
+}
+
+// densestWindow returns the largest number of sorted times falling inside
+// any window of the given length, with the index range of that window
+func densestWindow(times []time.Time, window time.Duration) (best, first, last int) {
+	start := 0
+	for end := range times {
+		for times[end].Sub(times[start]) > window {
+			start++
+		}
+		if n := end - start + 1; n > best {
+			best, first, last = n, start, end
+		}
+	}
+	return best, first, last
+}
+
+// formatCodeCounts renders {503: 4, 500: 1} as "503 x4, 500 x1"
+func formatCodeCounts(codes map[int]int) string {
+	keys := make([]int, 0, len(codes))
+	for k := range codes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return codes[keys[i]] > codes[keys[j]] || (codes[keys[i]] == codes[keys[j]] && keys[i] < keys[j])
+	})
+
+	out := ""
+	for i, k := range keys {
+		if i > 0 {
+			out += ", "
+		}
+		out += fmt.Sprintf("%d x%d", k, codes[k])
+	}
+	return out
+}
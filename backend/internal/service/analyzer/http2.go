@@ -0,0 +1,475 @@
+package analyzer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/http2"
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// dissectHTTP2 decodes cleartext HTTP/2 (h2c), either with prior knowledge
+// or after an HTTP/1.1 Upgrade. It runs before dissectHTTP so upgraded
+// connections are not taken for HTTP/1.
+func (e *Engine) dissectHTTP2(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" {
+		return
+	}
+	if !claimable(stream, "HTTP", "HTTP2", "gRPC") {
+		return
+	}
+
+	client, server := sc.flows()
+	cStart, sStart, ok := http2.Start(client.Data, server.Data)
+	if !ok {
+		return
+	}
+	e.analyzeHTTP2(stream, client, server, cStart, sStart)
+}
+
+// h2Event is a frame placed on the connection timeline
+type h2Event struct {
+	*http2.Frame
+	fromClient bool
+	at         time.Time
+}
+
+// h2Stream tracks one HTTP/2 stream while its frames are replayed
+type h2Stream struct {
+	tx             *domain.Transaction
+	grpc           bool
+	grpcStatus     string
+	reqEnd         time.Time
+	respEnd        time.Time
+	sawFinalStatus bool
+}
+
+// h2Stall is a period a sender spent with an exhausted flow-control window
+type h2Stall struct {
+	fromClient bool
+	streamID   uint32 // 0 for the connection window
+	since      time.Time
+	duration   time.Duration
+	open       bool // still blocked when the capture ended
+}
+
+// analyzeHTTP2 pairs requests and responses per stream ID from the frames
+// of both directions, records them as "HTTP2" or "gRPC" transactions and
+// reports stream resets, GOAWAY, gRPC errors and flow-control stalls. The
+// flows may be cleartext or decrypted TLS; frames begin at the given offsets.
+func (e *Engine) analyzeHTTP2(stream *domain.Stream, client, server *reassembly.Flow, cStart, sStart int) {
+	cs := http2.Parse(client, cStart)
+	ss := http2.Parse(server, sStart)
+	if len(cs.Frames) == 0 && len(ss.Frames) == 0 {
+		return
+	}
+
+	var events []h2Event
+	for _, f := range cs.Frames {
+		events = append(events, h2Event{f, true, client.TimeAt(f.Offset)})
+	}
+	for _, f := range ss.Frames {
+		events = append(events, h2Event{f, false, server.TimeAt(f.Offset)})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+
+	streams := map[uint32]*h2Stream{}
+	var order []*h2Stream
+	var resets, goaways []h2Event
+	for _, ev := range events {
+		if ev.StreamID == 0 {
+			if ev.Type == http2.FrameGoAway {
+				goaways = append(goaways, ev)
+			}
+			continue
+		}
+		st := streams[ev.StreamID]
+		if st == nil {
+			// Only client HEADERS open a request; pushes and stray frames are skipped
+			if !ev.fromClient || ev.Type != http2.FrameHeaders {
+				continue
+			}
+			st = newH2Stream(ev)
+			streams[ev.StreamID] = st
+			order = append(order, st)
+		}
+		tx := st.tx
+
+		switch ev.Type {
+		case http2.FrameHeaders:
+			if ev.fromClient {
+				break
+			}
+			if code := ev.Header(":status"); code != "" && !st.sawFinalStatus {
+				status, _ := strconv.Atoi(code)
+				if status >= 200 {
+					st.sawFinalStatus = true
+					tx.Status = status
+					tx.StatusText = http.StatusText(status)
+					tx.ResponseTime = ev.at
+				}
+			}
+			if gs := ev.Header("grpc-status"); gs != "" {
+				st.grpcStatus = gs
+				if msg := ev.Header("grpc-message"); msg != "" {
+					if unescaped, err := url.PathUnescape(msg); err == nil {
+						msg = unescaped
+					}
+					tx.Attributes["grpc_message"] = msg
+				}
+			}
+		case http2.FrameData:
+			if ev.fromClient {
+				tx.RequestBytes += int64(ev.Length)
+			} else {
+				tx.ResponseBytes += int64(ev.Length)
+			}
+		case http2.FrameRSTStream:
+			if tx.Error == "" {
+				tx.Error = "reset by " + peerName(!ev.fromClient) + ": " + http2.ErrorCodeName(ev.ErrorCode)
+			}
+			resets = append(resets, ev)
+		}
+
+		if ev.EndStream() {
+			if ev.fromClient {
+				st.reqEnd = ev.at
+			} else {
+				st.respEnd = ev.at
+			}
+		}
+	}
+
+	// Requests above a GOAWAY's last stream ID were never processed
+	var lastGoAway *h2Event
+	for i := range goaways {
+		if !goaways[i].fromClient {
+			lastGoAway = &goaways[i]
+		}
+	}
+
+	closed := server.Closed() || client.RST
+	var txs, httpTxs []*domain.Transaction
+	hasGRPC := false
+	for id, st := range streams {
+		tx := st.tx
+		if st.grpc {
+			hasGRPC = true
+			if tx.Status != 0 {
+				tx.Attributes["http_status"] = strconv.Itoa(tx.Status)
+			}
+			tx.Status, tx.StatusText = 0, ""
+			if st.grpcStatus != "" {
+				code, _ := strconv.Atoi(st.grpcStatus)
+				tx.Status = code
+				tx.StatusText = http2.GRPCCodeName(code)
+			}
+		}
+		if !tx.ResponseTime.IsZero() {
+			reqDone := st.reqEnd
+			if reqDone.IsZero() {
+				reqDone = tx.RequestTime
+			}
+			if latency := tx.ResponseTime.Sub(reqDone); latency > 0 {
+				tx.Latency = latency
+			}
+		}
+		if !st.respEnd.IsZero() {
+			tx.Attributes["duration_ms"] = fmt.Sprintf("%.1f", msBetween(tx.RequestTime, st.respEnd))
+		}
+		if tx.Error == "" && tx.ResponseTime.IsZero() {
+			if lastGoAway != nil && id > lastGoAway.LastStreamID {
+				tx.Error = "refused by GOAWAY"
+			} else if closed && !ss.Lost {
+				// After a loss the response may be in the frames not decoded
+				tx.Error = "closed before response"
+			}
+		}
+	}
+	for _, st := range order {
+		txs = append(txs, st.tx)
+		if !st.grpc {
+			httpTxs = append(httpTxs, st.tx)
+		}
+	}
+
+	if hasGRPC {
+		confirm(stream, "gRPC")
+	} else {
+		confirm(stream, "HTTP2")
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	if cs.HeaderErr != nil || ss.HeaderErr != nil {
+		raise(stream, domain.SeverityNormal, "HTTP/2 Headers Not Decoded: HPACK state unavailable (capture likely started mid-connection)")
+	}
+	if cs.Lost || ss.Lost {
+		raise(stream, domain.SeverityNormal, "HTTP/2 Frames Lost: decoding stopped at a gap in the capture (%s)", lostSides(cs.Lost, ss.Lost))
+	}
+	e.detectSlowHTTP(stream, txs)
+	e.detectHTTP5xxBurst(stream, httpTxs)
+	e.detectGRPCErrors(stream, order)
+	e.detectHTTP2Resets(stream, streams, resets)
+	e.detectHTTP2GoAway(stream, goaways, txs)
+	e.detectHTTP2WindowStalls(stream, events, streams)
+}
+
+// lostSides names the directions whose frames were lost
+func lostSides(client, server bool) string {
+	switch {
+	case client && server:
+		return "both directions"
+	case client:
+		return "client frames"
+	}
+	return "server frames"
+}
+
+func newH2Stream(ev h2Event) *h2Stream {
+	tx := &domain.Transaction{
+		Protocol:    "HTTP2",
+		Method:      ev.Header(":method"),
+		Target:      ev.Header(":path"),
+		Host:        ev.Header(":authority"),
+		RequestTime: ev.at,
+		Attributes:  map[string]string{"stream_id": strconv.FormatUint(uint64(ev.StreamID), 10)},
+	}
+	st := &h2Stream{tx: tx}
+	if ct := ev.Header("content-type"); ct != "" {
+		tx.Attributes["content_type"] = ct
+		if http2.IsGRPC(ct) {
+			st.grpc = true
+			tx.Protocol = "gRPC"
+		}
+	}
+	return st
+}
+
+// detectGRPCErrors reports calls that finished with a non-OK status.
+// Server-side failures are critical, the rest warnings.
+func (e *Engine) detectGRPCErrors(stream *domain.Stream, order []*h2Stream) {
+	codes := map[string]int{}
+	var failed []*domain.Transaction
+	total := 0
+	severity := domain.SeverityWarning
+	for _, st := range order {
+		if !st.grpc {
+			continue
+		}
+		total++
+		if st.grpcStatus == "" || st.grpcStatus == "0" {
+			continue
+		}
+		failed = append(failed, st.tx)
+		codes[st.tx.StatusText]++
+		switch st.tx.StatusText {
+		case "UNAVAILABLE", "INTERNAL", "DATA_LOSS", "UNKNOWN":
+			severity = domain.SeverityCritical
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	first := failed[0]
+	detail := first.StatusText
+	if msg := first.Attributes["grpc_message"]; msg != "" {
+		detail += ": " + msg
+	}
+	raise(stream, severity, "gRPC Errors: %d of %d calls failed (%s; first %s %s)",
+		len(failed), total, formatNameCounts(codes), first.Target, detail)
+}
+
+// detectHTTP2Resets reports streams reset with an error. Client CANCEL and
+// NO_ERROR are how clients abandon requests they no longer need.
+func (e *Engine) detectHTTP2Resets(stream *domain.Stream, streams map[uint32]*h2Stream, resets []h2Event) {
+	codes := map[string]int{}
+	var first *h2Event
+	for i, ev := range resets {
+		if ev.ErrorCode == http2.ErrCodeNo || (ev.fromClient && ev.ErrorCode == http2.ErrCodeCancel) {
+			continue
+		}
+		codes[peerName(!ev.fromClient)+" "+http2.ErrorCodeName(ev.ErrorCode)]++
+		if first == nil {
+			first = &resets[i]
+		}
+	}
+	if first == nil {
+		return
+	}
+	n := 0
+	for _, c := range codes {
+		n += c
+	}
+	raise(stream, domain.SeverityWarning, "HTTP/2 Stream Resets: %d stream(s) reset with an error (%s; first stream %d %s)",
+		n, formatNameCounts(codes), first.StreamID, streams[first.StreamID].tx.Target)
+}
+
+func (e *Engine) detectHTTP2GoAway(stream *domain.Stream, goaways []h2Event, txs []*domain.Transaction) {
+	for _, ev := range goaways {
+		if ev.ErrorCode != http2.ErrCodeNo {
+			debug := ""
+			if ev.Debug != "" {
+				debug = ": " + ev.Debug
+			}
+			raise(stream, domain.SeverityCritical, "HTTP/2 GOAWAY: %s closed the connection with %s (last stream %d)%s",
+				peerName(!ev.fromClient), http2.ErrorCodeName(ev.ErrorCode), ev.LastStreamID, debug)
+			return
+		}
+	}
+	refused := 0
+	for _, tx := range txs {
+		if tx.Error == "refused by GOAWAY" {
+			refused++
+		}
+	}
+	if refused > 0 {
+		raise(stream, domain.SeverityWarning, "HTTP/2 GOAWAY: %d request(s) sent during graceful shutdown were not processed and must be retried",
+			refused)
+	}
+}
+
+// detectHTTP2WindowStalls replays flow control for both senders and
+// reports time spent with the connection or a stream window exhausted
+// while the stream still had data to send
+func (e *Engine) detectHTTP2WindowStalls(stream *domain.Stream, events []h2Event, streams map[uint32]*h2Stream) {
+	type sender struct {
+		initial int64 // stream window size announced by the peer
+		conn    int64
+		windows map[uint32]int64
+		blocked map[uint32]time.Time // stream ID, 0 for the connection
+	}
+	newSender := func() *sender {
+		return &sender{initial: http2.DefaultWindowSize, conn: http2.DefaultWindowSize,
+			windows: map[uint32]int64{}, blocked: map[uint32]time.Time{}}
+	}
+	senders := map[bool]*sender{true: newSender(), false: newSender()}
+
+	var stalls []h2Stall
+	unblock := func(s *sender, fromClient bool, id uint32, at time.Time) {
+		if since, ok := s.blocked[id]; ok {
+			stalls = append(stalls, h2Stall{fromClient: fromClient, streamID: id, since: since, duration: at.Sub(since)})
+			delete(s.blocked, id)
+		}
+	}
+
+	for _, ev := range events {
+		// Frames from one peer adjust the windows of the other
+		peer := senders[!ev.fromClient]
+		switch ev.Type {
+		case http2.FrameSettings:
+			if ev.Flags&http2.FlagAck != 0 {
+				break
+			}
+			for _, st := range ev.Settings {
+				if st.ID != http2.SettingInitialWindowSize {
+					continue
+				}
+				delta := int64(st.Value) - peer.initial
+				peer.initial = int64(st.Value)
+				for id := range peer.windows {
+					peer.windows[id] += delta
+					if peer.windows[id] > 0 {
+						unblock(peer, !ev.fromClient, id, ev.at)
+					}
+				}
+			}
+		case http2.FrameWindowUpdate:
+			if ev.StreamID == 0 {
+				peer.conn += int64(ev.Increment)
+				if peer.conn > 0 {
+					unblock(peer, !ev.fromClient, 0, ev.at)
+				}
+			} else if w, ok := peer.windows[ev.StreamID]; ok {
+				peer.windows[ev.StreamID] = w + int64(ev.Increment)
+				if peer.windows[ev.StreamID] > 0 {
+					unblock(peer, !ev.fromClient, ev.StreamID, ev.at)
+				}
+			}
+		case http2.FrameData:
+			s := senders[ev.fromClient]
+			w, ok := s.windows[ev.StreamID]
+			if !ok {
+				w = s.initial
+			}
+			w -= int64(ev.Length)
+			s.windows[ev.StreamID] = w
+			s.conn -= int64(ev.Length)
+			if ev.EndStream() {
+				delete(s.windows, ev.StreamID)
+				break
+			}
+			if s.conn <= 0 {
+				if _, ok := s.blocked[0]; !ok {
+					s.blocked[0] = ev.at
+				}
+			}
+			if w <= 0 {
+				if _, ok := s.blocked[ev.StreamID]; !ok {
+					s.blocked[ev.StreamID] = ev.at
+				}
+			}
+		}
+	}
+	end := stream.Stats.EndTime
+	for fromClient, s := range senders {
+		for id, since := range s.blocked {
+			stalls = append(stalls, h2Stall{fromClient: fromClient, streamID: id, since: since, duration: end.Sub(since), open: true})
+		}
+	}
+
+	limit := time.Duration(e.thresholds.HTTP2WindowStallSeconds * float64(time.Second))
+	var worst *h2Stall
+	// A stall that exhausts both the stream and connection windows counts once
+	starts := map[time.Time]bool{}
+	for i := range stalls {
+		if stalls[i].duration < limit {
+			continue
+		}
+		starts[stalls[i].since] = true
+		if worst == nil || stalls[i].duration > worst.duration {
+			worst = &stalls[i]
+		}
+	}
+	if worst == nil {
+		return
+	}
+	window := "connection window"
+	if worst.streamID != 0 {
+		window = "stream " + strconv.FormatUint(uint64(worst.streamID), 10)
+		if st := streams[worst.streamID]; st != nil && st.tx.Target != "" {
+			window += " (" + st.tx.Target + ")"
+		}
+	}
+	suffix := ""
+	if worst.open {
+		suffix = ", still blocked at end of capture"
+	}
+	raise(stream, domain.SeverityWarning, "HTTP/2 Flow-Control Stall: %s blocked by the %s's receive window %d time(s), longest %.0fms on %s%s",
+		peerName(!worst.fromClient), peerName(worst.fromClient), len(starts), float64(worst.duration)/float64(time.Millisecond), window, suffix)
+}
+
+// formatNameCounts renders counts as "A x3, B x1", most frequent first
+func formatNameCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s x%d", name, counts[name]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,164 @@
+package analyzer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"pcap-analyzer/internal/domain"
+	h2 "pcap-analyzer/internal/service/dissector/http2"
+)
+
+// h2Writer encodes the frames of one direction, each returned on its own so
+// it can be sent in a packet
+type h2Writer struct {
+	buf   bytes.Buffer
+	fr    *http2.Framer
+	block bytes.Buffer
+	enc   *hpack.Encoder
+}
+
+func newH2Writer() *h2Writer {
+	w := &h2Writer{}
+	w.fr = http2.NewFramer(&w.buf, nil)
+	w.enc = hpack.NewEncoder(&w.block)
+	return w
+}
+
+func (w *h2Writer) take() string {
+	s := w.buf.String()
+	w.buf.Reset()
+	return s
+}
+
+func (w *h2Writer) headers(id uint32, end bool, fields ...string) string {
+	w.block.Reset()
+	for i := 0; i+1 < len(fields); i += 2 {
+		w.enc.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	w.fr.WriteHeaders(http2.HeadersFrameParam{StreamID: id, EndHeaders: true, EndStream: end, BlockFragment: w.block.Bytes()})
+	return w.take()
+}
+
+func (w *h2Writer) data(id uint32, end bool, n int) string {
+	w.fr.WriteData(id, end, bytes.Repeat([]byte{0}, n))
+	return w.take()
+}
+
+func TestGRPCStatusAndLatency(t *testing.T) {
+	ms := time.Millisecond
+	cw, sw := newH2Writer(), newH2Writer()
+	call := func(id uint32, method string) string {
+		return cw.headers(id, false, ":method", "POST", ":path", method, "content-type", "application/grpc") + cw.data(id, true, 5)
+	}
+
+	c := newConn(40000, 50051).handshake(0)
+	cw.fr.WriteSettings()
+	c.send(true, 10*ms, h2.Preface+cw.take())
+	sw.fr.WriteSettings()
+	c.send(false, 11*ms, sw.take())
+
+	c.send(true, 20*ms, call(1, "/pkg.Svc/Ok"))
+	c.send(true, 30*ms, call(3, "/pkg.Svc/Fail"))
+	// The reply to /Ok carries a body whose middle the capture lost
+	c.send(false, 170*ms, sw.headers(1, false, ":status", "200", "content-type", "application/grpc"))
+	body := sw.data(1, false, 3000)
+	c.send(false, 171*ms, body[:1000])
+	c.lose(false, 1000)
+	c.send(false, 172*ms, body[2000:])
+	c.send(false, 173*ms, sw.headers(1, true, "grpc-status", "0"))
+	c.send(false, 230*ms, sw.headers(3, true, ":status", "200", "content-type", "application/grpc",
+		"grpc-status", "14", "grpc-message", "backend%20down"))
+	s := c.finish()
+	NewEngine().AnalyzeStream(s)
+
+	if s.Protocol != "gRPC" {
+		t.Fatalf("protocol = %s, want gRPC", s.Protocol)
+	}
+	if len(s.Transactions) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(s.Transactions))
+	}
+	ok, failed := s.Transactions[0], s.Transactions[1]
+	if ok.Target != "/pkg.Svc/Ok" || ok.Status != 0 || ok.Latency != 150*ms || ok.ResponseBytes != 3000 {
+		t.Errorf("ok call = %s status %d, latency %v, %d response bytes; want status 0, 150ms, 3000 bytes",
+			ok.Target, ok.Status, ok.Latency, ok.ResponseBytes)
+	}
+	if failed.Status != 14 || failed.StatusText != "UNAVAILABLE" || failed.Latency != 200*ms || failed.Attributes["grpc_message"] != "backend down" {
+		t.Errorf("failed call = status %d %s, latency %v, message %q", failed.Status, failed.StatusText, failed.Latency, failed.Attributes["grpc_message"])
+	}
+
+	finding := findAnalysis(s, "gRPC Errors:")
+	if !strings.HasPrefix(finding, "gRPC Errors: 1 of 2 calls failed") || !strings.Contains(finding, "/pkg.Svc/Fail UNAVAILABLE: backend down") {
+		t.Errorf("gRPC finding = %q", finding)
+	}
+	if s.Severity != domain.SeverityCritical {
+		t.Errorf("severity = %s, want critical for UNAVAILABLE", s.Severity)
+	}
+	if hasAnalysis(s, "HTTP/2 Frames Lost") {
+		t.Errorf("a gap inside a DATA frame stopped decoding: %q", s.Analysis)
+	}
+}
+
+func TestHTTP2HeadersLost(t *testing.T) {
+	ms := time.Millisecond
+	cw, sw := newH2Writer(), newH2Writer()
+	c := newConn(40000, 8080).handshake(0)
+	c.send(true, 10*ms, h2.Preface+cw.headers(1, true, ":method", "GET", ":path", "/a")+cw.headers(3, true, ":method", "GET", ":path", "/b"))
+	resp := sw.headers(1, true, ":status", "200", "server", strings.Repeat("x", 40))
+	c.send(false, 20*ms, resp[:20])
+	c.lose(false, 10)
+	c.send(false, 21*ms, resp[30:])
+	c.send(false, 30*ms, sw.headers(3, true, ":status", "503"))
+	c.send(false, 40*ms, "", "FIN", "ACK")
+	s := c.finish()
+	NewEngine().AnalyzeStream(s)
+
+	if len(s.Transactions) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(s.Transactions))
+	}
+	for _, tx := range s.Transactions {
+		// Headers after the lost block can't be decoded, and the
+		// responses may be among them
+		if tx.Status != 0 || tx.Error != "" {
+			t.Errorf("%s = status %d, error %q after the lost header block", tx.Target, tx.Status, tx.Error)
+		}
+	}
+	if f := findAnalysis(s, "HTTP/2 Frames Lost"); !strings.HasSuffix(f, "(server frames)") {
+		t.Errorf("lost finding = %q", f)
+	}
+}
+
+func TestGRPCErrorSeverity(t *testing.T) {
+	tests := []struct {
+		status   string
+		finding  string
+		severity domain.Severity
+	}{
+		{"0", "", domain.SeverityNormal},
+		{"5", "gRPC Errors: 1 of 1 calls failed (NOT_FOUND x1; first /pkg.Svc/Get NOT_FOUND)", domain.SeverityWarning},
+		{"13", "gRPC Errors: 1 of 1 calls failed (INTERNAL x1; first /pkg.Svc/Get INTERNAL)", domain.SeverityCritical},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			ms := time.Millisecond
+			cw, sw := newH2Writer(), newH2Writer()
+			c := newConn(40000, 50051).handshake(0)
+			c.send(true, 10*ms, h2.Preface+cw.headers(1, false, ":method", "POST", ":path", "/pkg.Svc/Get", "content-type", "application/grpc")+cw.data(1, true, 5))
+			// A trailers-only response carries the status in its only HEADERS
+			c.send(false, 20*ms, sw.headers(1, true, ":status", "200", "content-type", "application/grpc", "grpc-status", tt.status))
+			s := c.finish()
+			NewEngine().AnalyzeStream(s)
+
+			if got := findAnalysis(s, "gRPC Errors"); got != tt.finding {
+				t.Errorf("finding = %q, want %q", got, tt.finding)
+			}
+			if s.Severity != tt.severity {
+				t.Errorf("severity = %s, want %s", s.Severity, tt.severity)
+			}
+		})
+	}
+}
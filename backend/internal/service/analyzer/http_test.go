@@ -0,0 +1,109 @@
+package analyzer
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPPairing(t *testing.T) {
+	ms := time.Millisecond
+	ok := func(body string) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	}
+
+	t.Run("in order", func(t *testing.T) {
+		c := newConn(40000, 80).handshake(0)
+		c.send(true, 10*ms, "GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\n")
+		c.send(false, 20*ms, ok("a"))
+		c.send(false, 30*ms, ok("bb"))
+		s := c.finish()
+		NewEngine().AnalyzeStream(s)
+
+		if len(s.Transactions) != 2 {
+			t.Fatalf("got %d transactions, want 2", len(s.Transactions))
+		}
+		for i, want := range []string{"1", "2"} {
+			if got := s.Transactions[i].Attributes["content_length"]; got != want {
+				t.Errorf("transaction %d content length = %q, want %q", i, got, want)
+			}
+		}
+	})
+
+	t.Run("response lost", func(t *testing.T) {
+		c := newConn(40000, 80).handshake(0)
+		c.send(true, 10*ms, "GET /a HTTP/1.1\r\n\r\nGET /b HTTP/1.1\r\n\r\nGET /c HTTP/1.1\r\n\r\n")
+		c.lose(false, len(ok("a")))
+		c.send(false, 30*ms, ok("bb"))
+		c.send(false, 40*ms, ok("ccc"))
+		c.send(false, 50*ms, "", "FIN", "ACK")
+		s := c.finish()
+		NewEngine().AnalyzeStream(s)
+
+		if len(s.Transactions) != 3 {
+			t.Fatalf("got %d transactions, want 3", len(s.Transactions))
+		}
+		for _, tx := range s.Transactions {
+			// /a must not get the response to /b
+			if tx.Status != 0 || tx.Error != "" {
+				t.Errorf("%s paired with status %d, error %q after the loss", tx.Target, tx.Status, tx.Error)
+			}
+		}
+	})
+
+	t.Run("request lost", func(t *testing.T) {
+		c := newConn(40000, 80).handshake(0)
+		c.send(true, 10*ms, "GET /a HTTP/1.1\r\n\r\n")
+		c.lose(true, len("GET /b HTTP/1.1\r\n\r\n"))
+		c.send(true, 20*ms, "GET /c HTTP/1.1\r\n\r\n")
+		c.send(false, 30*ms, ok("a")+ok("bb")+ok("ccc"))
+		s := c.finish()
+		NewEngine().AnalyzeStream(s)
+
+		if len(s.Transactions) != 2 {
+			t.Fatalf("got %d transactions, want 2", len(s.Transactions))
+		}
+		if tx := s.Transactions[0]; tx.Target != "/a" || tx.Attributes["content_length"] != "1" {
+			t.Errorf("first transaction = %s with %s body bytes, want /a with 1", tx.Target, tx.Attributes["content_length"])
+		}
+		if tx := s.Transactions[1]; tx.Status != 0 {
+			t.Errorf("%s paired with status %d after the loss", tx.Target, tx.Status)
+		}
+	})
+}
+
+func TestHTTPClosedMidResponse(t *testing.T) {
+	ms := time.Millisecond
+	head := "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n"
+	tests := []struct {
+		name    string
+		lost    int // body bytes missing from the capture
+		aborted bool
+	}{
+		{"body cut short", 0, true},
+		{"body bytes lost", 30, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConn(40000, 80).handshake(0)
+			c.send(true, 10*ms, "GET /big HTTP/1.1\r\n\r\n")
+			c.send(false, 20*ms, head)
+			c.lose(false, tt.lost)
+			c.send(false, 30*ms, strings.Repeat("x", 40))
+			c.send(false, 40*ms, "", "FIN", "ACK")
+			s := c.finish()
+			NewEngine().AnalyzeStream(s)
+
+			if len(s.Transactions) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(s.Transactions))
+			}
+			if got := s.Transactions[0].Error != ""; got != tt.aborted {
+				t.Errorf("error = %q, want aborted %v", s.Transactions[0].Error, tt.aborted)
+			}
+			if got := hasAnalysis(s, "HTTP Connection Closed Mid-Response"); got != tt.aborted {
+				t.Errorf("mid-response finding = %v, want %v: %q", got, tt.aborted, s.Analysis)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package analyzer
+
+import (
+	"fmt"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/icmp"
+)
+
+// IP + TCP header overhead without options, used to relate MSS to MTU
+const (
+	ipv4TCPOverhead = 40
+	ipv6TCPOverhead = 60
+)
+
+// detectICMP reports the ICMP errors linked to a stream: PMTU messages,
+// unreachables, TTL expiry and redirects. Repeated messages of the same
+// kind from the same reporter are reported once with a count.
+func (e *Engine) detectICMP(stream *domain.Stream) {
+	type group struct {
+		first *domain.ICMPEvent
+		count int
+	}
+	type key struct {
+		kind, reporter, gateway string
+		mtu                     int
+	}
+	groups := map[key]*group{}
+	var order []key
+	for _, ev := range stream.ICMPEvents {
+		k := key{ev.Kind, ev.Reporter, ev.Gateway, ev.MTU}
+		if g, ok := groups[k]; ok {
+			g.count++
+			continue
+		}
+		groups[k] = &group{first: ev, count: 1}
+		order = append(order, k)
+	}
+
+	for _, k := range order {
+		g := groups[k]
+		ev := g.first
+		target := ev.Original
+		if target == "" {
+			target = stream.ServerIP
+		}
+
+		switch ev.Kind {
+		case icmp.KindFragNeeded, icmp.KindPacketTooBig:
+			e.reportPMTU(stream, ev, g.count)
+		case icmp.KindPortUnreachable:
+			raise(stream, domain.SeverityCritical, "Port Unreachable: %s reported %s as closed (%d message(s))",
+				ev.Reporter, target, g.count)
+		case icmp.KindAdminProhibited:
+			raise(stream, domain.SeverityCritical, "Administratively Prohibited: %s filtered %s (%d message(s))",
+				ev.Reporter, target, g.count)
+		case icmp.KindNetUnreachable, icmp.KindHostUnreachable, icmp.KindProtoUnreachable, icmp.KindUnreachable:
+			raise(stream, domain.SeverityCritical, "Destination Unreachable (%s): reported by %s for %s (%d message(s))",
+				ev.Kind, ev.Reporter, target, g.count)
+		case icmp.KindTTLExceeded:
+			raise(stream, domain.SeverityWarning, "TTL Exceeded in Transit: router %s dropped %s (%d message(s); routing loop or TTL too small)",
+				ev.Reporter, target, g.count)
+		case icmp.KindReassemblyTime:
+			raise(stream, domain.SeverityWarning, "Fragment Reassembly Timeout: %s could not reassemble %s (%d message(s))",
+				ev.Reporter, target, g.count)
+		case icmp.KindRedirect:
+			raise(stream, domain.SeverityWarning, "ICMP Redirect: %s redirected %s to gateway %s (%d message(s))",
+				ev.Reporter, target, ev.Gateway, g.count)
+		case icmp.KindParameterProblem:
+			raise(stream, domain.SeverityWarning, "ICMP Parameter Problem: %s rejected a header of %s (%d message(s))",
+				ev.Reporter, target, g.count)
+		}
+	}
+}
+
+// reportPMTU explains a fragmentation-needed / packet-too-big message. On a
+// TCP stream that kept retransmitting, the message was evidently not acted
+// upon, which is the classic PMTU blackhole.
+func (e *Engine) reportPMTU(stream *domain.Stream, ev *domain.ICMPEvent, count int) {
+	overhead := ipv4TCPOverhead
+	if ev.Version == 6 {
+		overhead = ipv6TCPOverhead
+	}
+	mss := stream.ClientMSS
+	if stream.ServerMSS > mss {
+		mss = stream.ServerMSS
+	}
+
+	detail := ""
+	if stream.Transport == "TCP" && mss > 0 && int(mss)+overhead > ev.MTU {
+		detail = fmt.Sprintf(" - negotiated MSS %d needs MTU %d, segments above MSS %d are dropped",
+			mss, int(mss)+overhead, ev.MTU-overhead)
+	}
+
+	if stream.Transport == "TCP" && stream.Stats.RetransmissionCount > 0 {
+		raise(stream, domain.SeverityCritical, "Possible PMTU Blackhole: %s reported %s with next-hop MTU %d (%d message(s)) and %d retransmissions followed%s",
+			ev.Reporter, ev.Kind, ev.MTU, count, stream.Stats.RetransmissionCount, detail)
+		return
+	}
+	raise(stream, domain.SeverityWarning, "Path MTU Limited: %s reported %s with next-hop MTU %d (%d message(s))%s",
+		ev.Reporter, ev.Kind, ev.MTU, count, detail)
+}
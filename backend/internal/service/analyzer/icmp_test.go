@@ -0,0 +1,101 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/icmp"
+	"pcap-analyzer/internal/service/pcap"
+)
+
+func TestPMTULinkage(t *testing.T) {
+	ms := time.Millisecond
+	segment := func(at time.Duration, fromClient bool, seq uint32, n int, flags ...string) pcap.PacketMeta {
+		pkt := pcap.PacketMeta{
+			Timestamp: testStart.Add(at), Transport: "TCP", Protocol: "TCP",
+			SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcPort: 40000, DstPort: 443,
+			Seq: seq, Flags: flags, PayloadLen: n, Payload: make([]byte, n), Length: 54 + n, TTL: 64,
+		}
+		if !fromClient {
+			pkt.SrcIP, pkt.DstIP, pkt.SrcPort, pkt.DstPort = pkt.DstIP, pkt.SrcIP, pkt.DstPort, pkt.SrcPort
+		}
+		return pkt
+	}
+	// fragNeeded is a router's complaint about a packet src:sport > dst:dport
+	fragNeeded := func(at time.Duration, src, dst string, sport, dport uint16) pcap.PacketMeta {
+		return pcap.PacketMeta{
+			Timestamp: testStart.Add(at), Transport: "ICMP", Protocol: "ICMP",
+			SrcIP: "192.0.2.254", DstIP: src, Length: 70, TTL: 250,
+			ICMP: &icmp.Message{Version: 4, Type: 3, Code: 4, Kind: icmp.KindFragNeeded, MTU: 1400,
+				Original: &icmp.Original{SrcIP: src, DstIP: dst, Transport: "TCP", SrcPort: sport, DstPort: dport}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		retrans bool
+		want    string
+	}{
+		{"blackhole", true, "Possible PMTU Blackhole: 192.0.2.254 reported fragmentation-needed with next-hop MTU 1400 (2 message(s)) and 1 retransmissions followed - negotiated MSS 1460 needs MTU 1500, segments above MSS 1360 are dropped"},
+		{"acted upon", false, "Path MTU Limited: 192.0.2.254 reported fragmentation-needed with next-hop MTU 1400 (2 message(s)) - negotiated MSS 1460 needs MTU 1500, segments above MSS 1360 are dropped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := NewStreamBuilder()
+			syn := segment(0, true, 1000, 0, "SYN")
+			syn.TCPOptions = &domain.TCPOptions{MSS: 1460}
+			synAck := segment(ms, false, 5000, 0, "SYN", "ACK")
+			synAck.TCPOptions = &domain.TCPOptions{MSS: 1460}
+			for _, pkt := range []pcap.PacketMeta{
+				syn, synAck,
+				segment(2*ms, true, 1001, 100, "ACK"),
+				segment(10*ms, false, 5001, 1460, "ACK"),
+				fragNeeded(11*ms, "10.0.0.2", "10.0.0.1", 443, 40000),
+				fragNeeded(12*ms, "10.0.0.2", "10.0.0.1", 443, 40000),
+				// An error about a flow that is not in the capture
+				fragNeeded(13*ms, "10.0.0.2", "10.0.0.9", 443, 40001),
+			} {
+				sb.ProcessPacket(pkt)
+			}
+			if tt.retrans {
+				sb.ProcessPacket(segment(300*ms, false, 5001, 1460, "ACK"))
+			} else {
+				sb.ProcessPacket(segment(20*ms, true, 1101, 0, "ACK"))
+			}
+
+			var tcp, other *domain.Stream
+			for _, s := range sb.GetStreams() {
+				if s.Transport == "TCP" {
+					tcp = s
+				} else {
+					other = s
+				}
+			}
+			if tcp == nil || other == nil || len(sb.GetStreams()) != 2 {
+				t.Fatalf("streams = %+v, want the TCP flow and one ICMP stream", sb.GetStreams())
+			}
+			if len(tcp.ICMPEvents) != 2 || tcp.ICMPEvents[0].Original != "10.0.0.2:443 > 10.0.0.1:40000/TCP" || tcp.ICMPEvents[0].MTU != 1400 {
+				t.Fatalf("linked events = %+v", tcp.ICMPEvents)
+			}
+			if tcp.Stats.PacketCount != 5 {
+				t.Errorf("ICMP errors counted as TCP packets: %d packets", tcp.Stats.PacketCount)
+			}
+			if len(other.ICMPEvents) != 1 || other.Transport != "ICMP" {
+				t.Errorf("unlinked error = %+v", other)
+			}
+
+			NewEngine().AnalyzeStream(tcp)
+			var pmtu []string
+			for _, a := range tcp.Analysis {
+				if strings.Contains(a, "MTU") {
+					pmtu = append(pmtu, a)
+				}
+			}
+			if len(pmtu) != 1 || pmtu[0] != tt.want {
+				t.Errorf("PMTU findings = %q, want %q", pmtu, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// knownIdleTimeouts are default idle timeouts of common devices, load
+// balancers and servers, shortest first
+var knownIdleTimeouts = []struct {
+	timeout time.Duration
+	source  string
+}{
+	{5 * time.Second, "Apache httpd 2.4 KeepAliveTimeout, Node.js keepAliveTimeout"},
+	{30 * time.Second, "Google Cloud HTTP(S) load balancer backend timeout"},
+	{60 * time.Second, "AWS Application and Classic Load Balancer idle timeout"},
+	{75 * time.Second, "nginx keepalive_timeout"},
+	{120 * time.Second, "IIS connection timeout"},
+	{240 * time.Second, "Azure Load Balancer and SNAT idle timeout"},
+	{300 * time.Second, "F5 BIG-IP TCP profile idle timeout"},
+	{350 * time.Second, "AWS NAT Gateway and Network Load Balancer idle timeout"},
+	{1800 * time.Second, "Juniper SRX TCP session timeout"},
+	{3600 * time.Second, "Cisco ASA connection timeout, Palo Alto and FortiGate TCP session timeout"},
+}
+
+// idleClose is a stream closed after a silence
+type idleClose struct {
+	stream *domain.Stream
+	idle   time.Duration
+}
+
+// inferIdleTimeouts clusters the silences before streams to each server
+// were closed. Enough closes after about the same silence point to an idle
+// timeout in the server or a device in front of it, which is matched
+// against knownIdleTimeouts. The streams in a cluster are marked as timed
+// out. It runs after detectResetsAndTimouts.
+func (e *Engine) inferIdleTimeouts(streams []*domain.Stream) []*domain.Finding {
+	byServer := map[string][]idleClose{}
+	var servers []string
+	for _, s := range streams {
+		if s.Transport != "TCP" || s.Stats.IdleBeforeClose == 0 {
+			continue
+		}
+		server := fmt.Sprintf("%s:%d", s.ServerIP, s.ServerPort)
+		if _, ok := byServer[server]; !ok {
+			servers = append(servers, server)
+		}
+		byServer[server] = append(byServer[server], idleClose{s, s.Stats.IdleBeforeClose})
+	}
+	sort.Strings(servers)
+
+	tolerance := e.thresholds.IdleTimeoutTolerancePercent / 100
+	var findings []*domain.Finding
+	for _, server := range servers {
+		closes := byServer[server]
+		sort.Slice(closes, func(i, j int) bool { return closes[i].idle < closes[j].idle })
+		for i := 0; i < len(closes); {
+			// A cluster spans twice the tolerance from its shortest silence
+			j := i + 1
+			for j < len(closes) && float64(closes[j].idle) <= float64(closes[i].idle)*(1+2*tolerance) {
+				j++
+			}
+			if j-i >= e.thresholds.IdleTimeoutMinStreams {
+				findings = append(findings, idleTimeoutFinding(server, closes[i:j], len(closes), tolerance))
+			}
+			i = j
+		}
+	}
+	return findings
+}
+
+// idleTimeoutFinding reports one cluster of closes and marks its streams
+func idleTimeoutFinding(server string, cluster []idleClose, closes int, tolerance float64) *domain.Finding {
+	timeout := cluster[len(cluster)/2].idle
+	match := "no known default; likely a configured timeout"
+	for _, known := range knownIdleTimeouts {
+		if d := timeout - known.timeout; d.Abs().Seconds() <= known.timeout.Seconds()*tolerance {
+			match = fmt.Sprintf("matching the %s default of %s", known.source, formatIdle(known.timeout))
+			break
+		}
+	}
+	flags := map[string]int{}
+	var hosts []string
+	seen := map[string]bool{}
+	first := closePacket(cluster[0].stream).Timestamp
+	for _, c := range cluster {
+		flags[c.stream.Stats.CloseFlag]++
+		if !seen[c.stream.ClientIP] {
+			seen[c.stream.ClientIP] = true
+			hosts = append(hosts, c.stream.ClientIP)
+		}
+		if at := closePacket(c.stream).Timestamp; at.Before(first) {
+			first = at
+		}
+	}
+	sort.Strings(hosts)
+	hosts = append([]string{cluster[0].stream.ServerIP}, hosts...)
+	var by []string
+	for _, flag := range []string{"RST", "FIN"} {
+		if flags[flag] > 0 {
+			by = append(by, fmt.Sprintf("%d by %s", flags[flag], flag))
+		}
+	}
+
+	f := newFinding(domain.SeverityWarning, "Timeout", first, hosts,
+		"Idle Timeout: %d of %d connections to %s closed after idling %s (%s–%s; %s), %s",
+		len(cluster), closes, server, formatIdle(timeout), formatIdle(cluster[0].idle), formatIdle(cluster[len(cluster)-1].idle),
+		strings.Join(by, ", "), match)
+	for _, c := range cluster {
+		c.stream.Stats.HasTimeout = true
+		raise(c.stream, domain.SeverityWarning, "Idle Timeout: closed by %s after %s idle, like %d other connection(s) to this server; %s",
+			c.stream.Stats.CloseFlag, formatIdle(c.idle), len(cluster)-1, match)
+		if len(f.Evidence) < congestionExamples {
+			f.Evidence = append(f.Evidence, newEvidence(c.stream, closePacket(c.stream)))
+		}
+	}
+	return f
+}
+
+// closePacket returns the first RST or FIN of a stream
+func closePacket(stream *domain.Stream) *domain.PacketMeta {
+	for _, pkt := range stream.Packets {
+		if hasFlag(pkt, "RST") || hasFlag(pkt, "FIN") {
+			return pkt
+		}
+	}
+	return stream.Packets[len(stream.Packets)-1]
+}
+
+func formatIdle(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	return d.Round(time.Second).String()
+}
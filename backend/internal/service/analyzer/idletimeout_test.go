@@ -0,0 +1,76 @@
+package analyzer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+func TestInferIdleTimeouts(t *testing.T) {
+	ms := time.Millisecond
+	closes := []struct {
+		port    uint16
+		idle    time.Duration
+		flag    string
+		timeout bool
+	}{
+		{443, 60 * time.Second, "RST", true},
+		{443, 59800 * ms, "RST", true},
+		{443, 60100 * ms, "RST", true},
+		{443, 60300 * ms, "FIN", true},
+		{443, 20 * time.Second, "RST", false}, // the outlier
+		{8080, 60 * time.Second, "RST", false},
+	}
+	var streams []*domain.Stream
+	for i, cl := range closes {
+		start := time.Duration(i) * time.Second
+		c := newConn(uint16(40001+i), cl.port).handshake(start)
+		c.send(true, start+10*ms, "ping")
+		if cl.flag == "FIN" {
+			c.send(false, start+10*ms+cl.idle, "", "FIN", "ACK")
+		} else {
+			c.send(false, start+10*ms+cl.idle, "", "RST")
+		}
+		streams = append(streams, c.finish())
+	}
+	e := NewEngine()
+	for _, s := range streams {
+		e.AnalyzeStream(s)
+	}
+	report := e.AnalyzeCapture(streams, nil)
+
+	var found []*domain.Finding
+	for _, f := range report.Findings {
+		if f.Category == "Timeout" {
+			found = append(found, f)
+		}
+	}
+	const match = "matching the AWS Application and Classic Load Balancer idle timeout default of 1m0s"
+	want := "Idle Timeout: 4 of 5 connections to 10.0.0.2:443 closed after idling 1m0s (59.8s–1m0s; 3 by RST, 1 by FIN), " + match
+	if len(found) != 1 || found[0].Message != want {
+		var messages []string
+		for _, f := range found {
+			messages = append(messages, f.Message)
+		}
+		t.Fatalf("findings = %q, want %q", messages, want)
+	}
+	f := found[0]
+	if f.Severity != domain.SeverityWarning || !f.Time.Equal(testStart.Add(60010*ms)) ||
+		fmt.Sprint(f.Hosts) != "[10.0.0.2 10.0.0.1]" || len(f.Evidence) != 3 {
+		t.Errorf("finding = %s at %v for %v with %d evidence", f.Severity, f.Time, f.Hosts, len(f.Evidence))
+	}
+
+	for i, s := range streams {
+		if s.Stats.HasTimeout != closes[i].timeout {
+			t.Errorf("stream %d: HasTimeout = %v, want %v", i, s.Stats.HasTimeout, closes[i].timeout)
+		}
+		if got := findAnalysis(s, "Idle Timeout"); (got != "") != closes[i].timeout {
+			t.Errorf("stream %d: finding %q", i, got)
+		}
+	}
+	if got, want := findAnalysis(streams[0], "Idle Timeout"), "Idle Timeout: closed by RST after 1m0s idle, like 3 other connection(s) to this server; "+match; got != want {
+		t.Errorf("stream finding = %q, want %q", got, want)
+	}
+}
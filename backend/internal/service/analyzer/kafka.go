@@ -0,0 +1,223 @@
+package analyzer
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/kafka"
+)
+
+// severeKafkaErrors point at the cluster rather than the client: lost
+// replicas, storage and timeouts, rejected credentials and permissions
+var severeKafkaErrors = map[string]bool{
+	"UNKNOWN_SERVER_ERROR":                  true,
+	"REQUEST_TIMED_OUT":                     true,
+	"BROKER_NOT_AVAILABLE":                  true,
+	"MESSAGE_TOO_LARGE":                     true,
+	"NOT_ENOUGH_REPLICAS":                   true,
+	"NOT_ENOUGH_REPLICAS_AFTER_APPEND":      true,
+	"TOPIC_AUTHORIZATION_FAILED":            true,
+	"GROUP_AUTHORIZATION_FAILED":            true,
+	"CLUSTER_AUTHORIZATION_FAILED":          true,
+	"TRANSACTIONAL_ID_AUTHORIZATION_FAILED": true,
+	"KAFKA_STORAGE_ERROR":                   true,
+	"SASL_AUTHENTICATION_FAILED":            true,
+	"GROUP_MAX_SIZE_REACHED":                true,
+}
+
+// dissectKafka decodes Kafka connections from the first request header or,
+// for connections captured mid-message, on port 9092. Each request becomes
+// a "Kafka" transaction paired with its response by correlation ID;
+// authentication failures, error codes, slow requests, quota throttling and
+// unacknowledged produce requests are reported.
+func (e *Engine) dissectKafka(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" || !claimable(stream, "Kafka") {
+		return
+	}
+	client, server := sc.flows()
+	if !kafka.IsRequest(client.Data) && !(stream.ServerPort == 9092 && len(client.Data) > 0) {
+		return
+	}
+
+	conv := kafka.Parse(client, server)
+	if len(conv.Exchanges) == 0 {
+		return
+	}
+	confirm(stream, "Kafka")
+
+	var txs []*domain.Transaction
+	fireAndForget := 0
+	for _, ex := range conv.Exchanges {
+		req := ex.Request
+		target := strings.Join(req.Topics, ",")
+		if req.Group != "" {
+			target = req.Group
+		}
+		tx := flowRequest(stream, "Kafka", kafka.APIName(req.APIKey), target, client, req.Offset, req.End)
+		tx.Attributes["api_version"] = strconv.Itoa(int(req.Version))
+		tx.Attributes["correlation_id"] = strconv.Itoa(int(req.CorrelationID))
+		if req.ClientID != "" {
+			tx.Attributes["client_id"] = req.ClientID
+		}
+		switch req.APIKey {
+		case kafka.APIProduce:
+			tx.Attributes["acks"] = strconv.Itoa(int(req.Acks))
+		case kafka.APIFetch:
+			tx.Attributes["max_wait_ms"] = strconv.Itoa(int(req.MaxWaitMs))
+		case kafka.APIJoinGroup:
+			// The broker holds JoinGroup until the rebalance completes
+			tx.Attributes["blocking"] = "true"
+		}
+
+		resp := ex.Response
+		switch {
+		case resp != nil:
+			flowRespond(tx, client, server, req.End, resp.Offset, resp.End)
+			setKafkaResponse(tx, req, resp)
+		case req.APIKey == kafka.APIProduce && req.Acks == 0:
+			tx.Attributes["no_response"] = "true"
+			fireAndForget++
+		default:
+			flowUnanswered(tx, client, server)
+		}
+		txs = append(txs, tx)
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	if fireAndForget > 0 {
+		raise(stream, domain.SeverityNormal, "Kafka Produce Without Acks: %d produce request(s) use acks=0; the broker confirms nothing and lost records go unnoticed",
+			fireAndForget)
+	}
+	for _, tx := range txs {
+		if strings.HasPrefix(tx.Method, "Sasl") && tx.Error != "" {
+			raise(stream, domain.SeverityCritical, "Kafka Authentication Failed: %s returned %s", tx.Method, tx.Error)
+			break
+		}
+	}
+	e.detectKafkaErrors(stream, txs)
+	e.detectSlowKafkaRequests(stream, txs)
+	detectKafkaThrottling(stream, txs)
+}
+
+func setKafkaResponse(tx *domain.Transaction, req *kafka.Request, resp *kafka.Response) {
+	if resp.ThrottleMs > 0 {
+		tx.Attributes["throttle_ms"] = strconv.Itoa(int(resp.ThrottleMs))
+	}
+	if resp.Bytes > 0 {
+		tx.Attributes["bytes"] = strconv.FormatInt(resp.Bytes, 10)
+	}
+	if len(resp.Errors) > 0 {
+		names := map[string]int{}
+		for code, n := range resp.Errors {
+			names[kafka.ErrorName(code)] += n
+		}
+		tx.Attributes["error_codes"] = formatNameCounts(names)
+	}
+	if code, failed := resp.FirstError(req.APIKey); failed {
+		tx.Status = int(code)
+		tx.StatusText = kafka.ErrorName(code)
+		tx.Error = tx.StatusText
+		tx.Attributes["error_code"] = tx.StatusText
+	} else if resp.Decoded {
+		tx.StatusText = "NONE"
+	}
+}
+
+// detectKafkaErrors reports requests the broker failed, in whole or for
+// some partitions, and requests cut off by the connection closing.
+// Replication, storage, timeout and authorization errors are critical.
+func (e *Engine) detectKafkaErrors(stream *domain.Stream, txs []*domain.Transaction) {
+	codes := map[string]int{}
+	var first *domain.Transaction
+	severity := domain.SeverityWarning
+	failed := 0
+	for _, tx := range txs {
+		if tx.Error == "" {
+			continue
+		}
+		failed++
+		code := tx.Attributes["error_code"]
+		if code == "" {
+			code = "closed"
+		}
+		codes[code]++
+		if first == nil {
+			first = tx
+		}
+		if severeKafkaErrors[code] {
+			severity = domain.SeverityCritical
+		}
+	}
+	if first == nil {
+		return
+	}
+	target := first.Method
+	if first.Target != "" {
+		target += " " + shortenStatement(first.Target, 80)
+	}
+	raise(stream, severity, "Kafka Errors: %d of %d requests failed (%s); first %s: %s",
+		failed, len(txs), formatNameCounts(codes), target, first.Error)
+}
+
+// detectSlowKafkaRequests reports requests answered slower than the
+// threshold. A fetch may be held up to its max wait when there is nothing
+// to return, so only the time beyond that counts.
+func (e *Engine) detectSlowKafkaRequests(stream *domain.Stream, txs []*domain.Transaction) {
+	limit := time.Duration(e.thresholds.KafkaSlowRequestSeconds * float64(time.Second))
+	var worst *domain.Transaction
+	var worstDelay time.Duration
+	slow, total := 0, 0
+	for _, tx := range txs {
+		if tx.Attributes["blocking"] == "true" || tx.Attributes["no_response"] == "true" {
+			continue
+		}
+		total++
+		if tx.ResponseTime.IsZero() {
+			continue
+		}
+		delay := tx.Latency
+		if wait, err := strconv.Atoi(tx.Attributes["max_wait_ms"]); err == nil {
+			delay -= time.Duration(wait) * time.Millisecond
+		}
+		if delay <= limit {
+			continue
+		}
+		slow++
+		if worst == nil || delay > worstDelay {
+			worst, worstDelay = tx, delay
+		}
+	}
+	if worst == nil {
+		return
+	}
+	target := worst.Method
+	if worst.Target != "" {
+		target += " " + shortenStatement(worst.Target, 80)
+	}
+	raise(stream, domain.SeverityWarning, "Slow Kafka Requests: %d of %d over %.1fs (worst %.2fs for %s)",
+		slow, total, limit.Seconds(), worst.Latency.Seconds(), target)
+}
+
+// detectKafkaThrottling reports responses the broker delayed to enforce a
+// client quota
+func detectKafkaThrottling(stream *domain.Stream, txs []*domain.Transaction) {
+	throttled, worst := 0, 0
+	apis := map[string]int{}
+	for _, tx := range txs {
+		ms, _ := strconv.Atoi(tx.Attributes["throttle_ms"])
+		if ms <= 0 {
+			continue
+		}
+		throttled++
+		apis[tx.Method]++
+		worst = max(worst, ms)
+	}
+	if throttled == 0 {
+		return
+	}
+	raise(stream, domain.SeverityWarning, "Kafka Quota Throttling: broker throttled %d of %d responses (%s), up to %d ms",
+		throttled, len(txs), formatNameCounts(apis), worst)
+}
@@ -0,0 +1,416 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"pcap-analyzer/internal/domain"
+	tlsdissect "pcap-analyzer/internal/service/dissector/tls"
+)
+
+const (
+	// viewWindow is how soon the same segment must be seen again to be a
+	// second view of it, from the other side of a device, rather than a
+	// retransmission
+	viewWindow = 200 * time.Millisecond
+	// injectionWindow is how soon after the peer's data a reset counts as
+	// answering it
+	injectionWindow = time.Second
+	// IP IDs count as sequential when at least 90% of the steps between a
+	// sender's packets are small; a reset further off did not come from it
+	ipidMaxStep       = 64
+	ipidMaxGap        = 1024
+	ipidMinPairs      = 4
+	triggerExcerptLen = 40
+)
+
+// detectMiddleboxes looks for devices in the path of TCP connections: TTL
+// changes within a stream, resets whose TTL or IP ID does not match the
+// endpoint they claim to come from, resets injected right after a payload,
+// and the MSS or options of a handshake rewritten between two views of it.
+// The findings carry the packets behind them and are raised on their
+// streams too.
+func (e *Engine) detectMiddleboxes(streams []*domain.Stream) []*domain.Finding {
+	var findings []*domain.Finding
+	for _, s := range streams {
+		if s.Transport != "TCP" {
+			continue
+		}
+		for _, fromClient := range []bool{true, false} {
+			if f := ttlChanges(s, fromClient); f != nil {
+				findings = append(findings, f)
+			}
+			findings = append(findings, suspiciousResets(s, fromClient)...)
+		}
+	}
+	return append(findings, rewrittenHandshakes(streams)...)
+}
+
+// middleboxFinding builds a finding with its evidence and raises its
+// message on the streams involved
+func middleboxFinding(streams []*domain.Stream, severity domain.Severity, evidence []*domain.Evidence, format string, args ...interface{}) *domain.Finding {
+	var hosts []string
+	seen := map[string]bool{}
+	for _, s := range streams {
+		for _, ip := range []string{s.ClientIP, s.ServerIP} {
+			if !seen[ip] {
+				seen[ip] = true
+				hosts = append(hosts, ip)
+			}
+		}
+	}
+	f := newFinding(severity, "Middlebox", evidence[0].Time, hosts, format, args...)
+	f.Evidence = evidence
+	for i, s := range streams {
+		if i == 0 || s != streams[0] {
+			raise(s, severity, "%s", f.Message)
+		}
+	}
+	return f
+}
+
+func newEvidence(stream *domain.Stream, pkt *domain.PacketMeta) *domain.Evidence {
+	ev := &domain.Evidence{Stream: stream.ID, Time: pkt.Timestamp, Summary: packetSummary(pkt)}
+	for i, p := range stream.Packets {
+		if p == pkt {
+			ev.Packet = i
+			break
+		}
+	}
+	return ev
+}
+
+// packetSummary describes a segment the way tcpdump does, with the IP
+// fields middleboxes give away
+func packetSummary(pkt *domain.PacketMeta) string {
+	s := fmt.Sprintf("%s:%d > %s:%d [%s] seq %d", pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, strings.Join(pkt.Flags, ","), pkt.Seq)
+	if hasFlag(pkt, "ACK") {
+		s += fmt.Sprintf(" ack %d", pkt.Ack)
+	}
+	s += fmt.Sprintf(" len %d ttl %d", pkt.PayloadLen, pkt.TTL)
+	if pkt.IPID != 0 {
+		s += fmt.Sprintf(" id %d", pkt.IPID)
+	}
+	if pkt.Options != nil {
+		s += " [" + pkt.Options.String() + "]"
+	}
+	return s
+}
+
+// senderPackets returns the packets one side of a stream sent, leaving out
+// second views: the same segment seen again right after with another TTL,
+// as a capture on both sides of a router records it
+func senderPackets(stream *domain.Stream, fromClient bool) []*domain.PacketMeta {
+	var pkts []*domain.PacketMeta
+	for _, pkt := range stream.Packets {
+		if stream.FromClient(pkt) != fromClient {
+			continue
+		}
+		if n := len(pkts); n > 0 && secondView(pkts[n-1], pkt) {
+			continue
+		}
+		pkts = append(pkts, pkt)
+	}
+	return pkts
+}
+
+func secondView(a, b *domain.PacketMeta) bool {
+	return a.Seq == b.Seq && a.Ack == b.Ack && a.PayloadLen == b.PayloadLen && a.TTL != b.TTL &&
+		strings.Join(a.Flags, ",") == strings.Join(b.Flags, ",") && b.Timestamp.Sub(a.Timestamp) < viewWindow
+}
+
+// ttlChanges reports the TTL of one side's packets changing within the
+// stream: another route, or another device answering for the endpoint.
+// Resets are left to suspiciousResets.
+func ttlChanges(stream *domain.Stream, fromClient bool) *domain.Finding {
+	var prev *domain.PacketMeta
+	var evidence []*domain.Evidence
+	var first [2]*domain.PacketMeta
+	changes := 0
+	for _, pkt := range senderPackets(stream, fromClient) {
+		if hasFlag(pkt, "RST") || pkt.TTL == 0 {
+			continue
+		}
+		if prev != nil && pkt.TTL != prev.TTL {
+			if changes == 0 {
+				first = [2]*domain.PacketMeta{prev, pkt}
+			}
+			if changes < congestionExamples {
+				evidence = append(evidence, newEvidence(stream, prev), newEvidence(stream, pkt))
+			}
+			changes++
+		}
+		prev = pkt
+	}
+	if changes == 0 {
+		return nil
+	}
+	label := "client→server"
+	if !fromClient {
+		label = "server→client"
+	}
+	return middleboxFinding([]*domain.Stream{stream}, domain.SeverityWarning, evidence,
+		"TTL Change: %s TTL changed %d time(s), first from %d to %d at %s; the packets took another route or another device answered for the endpoint",
+		label, changes, first[0].TTL, first[1].TTL, first[1].Timestamp.Format("15:04:05.000"))
+}
+
+// suspiciousResets checks the resets one side of a stream sent against
+// its other packets. A reset right after the peer's data is reported as
+// injected when its TTL or IP ID does not fit the endpoint, the endpoint
+// kept sending after it, or several resets came at once; any other reset
+// with such a TTL or IP ID is reported as suspicious.
+func suspiciousResets(stream *domain.Stream, fromClient bool) []*domain.Finding {
+	sent := map[*domain.PacketMeta]bool{}
+	var normal []*domain.PacketMeta
+	ttls := map[uint8]int{}
+	var lastAlive time.Time
+	for _, pkt := range senderPackets(stream, fromClient) {
+		sent[pkt] = true
+		if !hasFlag(pkt, "RST") {
+			normal = append(normal, pkt)
+			ttls[pkt.TTL]++
+			lastAlive = pkt.Timestamp
+		}
+	}
+	var ttl uint8
+	for t, n := range ttls {
+		if t != 0 && (n > ttls[ttl] || n == ttls[ttl] && t < ttl) {
+			ttl = t
+		}
+	}
+	sequential := ipidSequential(normal)
+
+	side, peer := "client", "server"
+	if !fromClient {
+		side, peer = "server", "client"
+	}
+	var injected, suspicious []*domain.Evidence
+	var injectedReasons, suspiciousReasons []string
+	var trigger, prevSent, triggerPkt *domain.PacketMeta
+	var afterTrigger []*domain.PacketMeta // resets since the trigger
+	suspiciousCount := 0
+	severity := domain.SeverityWarning
+	for _, pkt := range stream.Packets {
+		if stream.FromClient(pkt) != fromClient {
+			if pkt.PayloadLen > 0 {
+				trigger, afterTrigger = pkt, nil
+			}
+			continue
+		}
+		if !sent[pkt] {
+			continue
+		}
+		if !hasFlag(pkt, "RST") {
+			prevSent, trigger = pkt, nil
+			continue
+		}
+		var reasons []string
+		strong := false
+		if ttl != 0 && pkt.TTL != 0 && pkt.TTL != ttl {
+			reasons = append(reasons, fmt.Sprintf("TTL %d where the %s's packets have %d", pkt.TTL, side, ttl))
+			strong = true
+		}
+		if sequential && prevSent != nil && pkt.IPID != 0 && ipidOff(prevSent.IPID, pkt.IPID) {
+			reasons = append(reasons, fmt.Sprintf("IP ID %d off the %s's counter at %d", pkt.IPID, side, prevSent.IPID))
+		}
+		if trigger != nil && pkt.Timestamp.Sub(trigger.Timestamp) <= injectionWindow {
+			afterTrigger = append(afterTrigger, pkt)
+			if lastAlive.After(pkt.Timestamp) {
+				reasons = append(reasons, fmt.Sprintf("the %s kept sending afterwards", side))
+				strong = true
+			}
+			if len(afterTrigger) > 1 {
+				reasons = append(reasons, fmt.Sprintf("%d resets in a row", len(afterTrigger)))
+			}
+			if len(reasons) == 0 {
+				continue
+			}
+			if len(injected) == 0 {
+				triggerPkt, injectedReasons = trigger, reasons
+				injected = append(injected, newEvidence(stream, trigger))
+				for _, rst := range afterTrigger[:len(afterTrigger)-1] {
+					injected = append(injected, newEvidence(stream, rst))
+				}
+			}
+			if len(injected) <= congestionExamples {
+				injected = append(injected, newEvidence(stream, pkt))
+			}
+			if strong {
+				// Real endpoints do not change their TTL or keep talking
+				// after resetting
+				severity = domain.SeverityCritical
+			}
+			continue
+		}
+		if len(reasons) > 0 {
+			if suspiciousCount == 0 {
+				suspiciousReasons = reasons
+			}
+			suspiciousCount++
+			if len(suspicious) < congestionExamples {
+				suspicious = append(suspicious, newEvidence(stream, pkt))
+			}
+		}
+	}
+
+	var findings []*domain.Finding
+	if len(injected) > 0 {
+		findings = append(findings, middleboxFinding([]*domain.Stream{stream}, severity, injected,
+			"RST Injection: a reset claiming to come from the %s followed the %s's %s by %s, with %s; a firewall or IPS in the path likely sent it",
+			side, peer, triggerExcerpt(triggerPkt), formatGap(injected[1].Time.Sub(triggerPkt.Timestamp)), strings.Join(injectedReasons, ", ")))
+	}
+	if len(suspicious) > 0 {
+		findings = append(findings, middleboxFinding([]*domain.Stream{stream}, domain.SeverityWarning, suspicious,
+			"Suspicious RST: %d reset(s) claiming to come from the %s, the first with %s; a device in the path may have sent them",
+			suspiciousCount, side, strings.Join(suspiciousReasons, ", ")))
+	}
+	return findings
+}
+
+// ipidSequential reports whether a sender numbers its IP packets from one
+// counter, as many stacks do per host or per connection
+func ipidSequential(pkts []*domain.PacketMeta) bool {
+	pairs, steps := 0, 0
+	for i := 1; i < len(pkts); i++ {
+		pairs++
+		if d := pkts[i].IPID - pkts[i-1].IPID; d >= 1 && d <= ipidMaxStep {
+			steps++
+		}
+	}
+	return pairs >= ipidMinPairs && steps*10 >= pairs*9
+}
+
+func ipidOff(prev, id uint16) bool {
+	d := id - prev
+	return d == 0 || d > ipidMaxGap
+}
+
+// triggerExcerpt names the payload a reset followed: the server name of a
+// TLS ClientHello, else its first printable line
+func triggerExcerpt(pkt *domain.PacketMeta) string {
+	if tlsdissect.LooksLikeTLS(pkt.Payload) {
+		if side := tlsdissect.ParseSide(pkt.Payload); side.ClientHello != nil && side.ClientHello.ServerName != "" {
+			return fmt.Sprintf("TLS ClientHello for %s", side.ClientHello.ServerName)
+		}
+	}
+	line, _, _ := strings.Cut(string(pkt.Payload), "\n")
+	line = strings.TrimRight(line, "\r")
+	if len(line) > triggerExcerptLen {
+		line = line[:triggerExcerptLen] + "…"
+	}
+	if line == "" || strings.IndexFunc(line, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return fmt.Sprintf("%d-byte segment", pkt.PayloadLen)
+	}
+	return fmt.Sprintf("%d-byte segment %q", pkt.PayloadLen, line)
+}
+
+func formatGap(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d.Microseconds())/1000)
+}
+
+// handshakeView is a SYN or SYN-ACK as seen at one point of the capture
+type handshakeView struct {
+	stream *domain.Stream
+	pkt    *domain.PacketMeta
+}
+
+// rewrittenHandshakes compares the views of a SYN or SYN-ACK seen twice
+// within viewWindow, in one stream or in two when an address translator
+// sits between the capture points, and reports the MSS or options a device
+// changed between them
+func rewrittenHandshakes(streams []*domain.Stream) []*domain.Finding {
+	type key struct {
+		seq, ack uint32
+		synAck   bool
+	}
+	groups := map[key][]handshakeView{}
+	var order []key
+	for _, s := range streams {
+		if s.Transport != "TCP" {
+			continue
+		}
+		for _, pkt := range s.Packets {
+			if !hasFlag(pkt, "SYN") {
+				continue
+			}
+			k := key{seq: pkt.Seq, synAck: hasFlag(pkt, "ACK")}
+			if k.synAck {
+				k.ack = pkt.Ack
+			}
+			if _, ok := groups[k]; !ok {
+				order = append(order, k)
+			}
+			groups[k] = append(groups[k], handshakeView{s, pkt})
+		}
+	}
+
+	var findings []*domain.Finding
+	for _, k := range order {
+		views := groups[k]
+		sort.SliceStable(views, func(i, j int) bool { return views[i].pkt.Timestamp.Before(views[j].pkt.Timestamp) })
+		for i := 1; i < len(views); i++ {
+			a, b := views[i-1], views[i]
+			if b.pkt.Timestamp.Sub(a.pkt.Timestamp) >= viewWindow || !sameEndpoint(a.pkt, b.pkt) {
+				continue
+			}
+			findings = append(findings, compareViews(a, b)...)
+		}
+	}
+	return findings
+}
+
+// sameEndpoint reports whether two views keep the source or the
+// destination, as source and destination translation do
+func sameEndpoint(a, b *domain.PacketMeta) bool {
+	return a.SrcIP == b.SrcIP && a.SrcPort == b.SrcPort || a.DstIP == b.DstIP && a.DstPort == b.DstPort
+}
+
+// compareViews reports what changed between the earlier view of a
+// handshake segment, nearer its sender, and the later one
+func compareViews(a, b handshakeView) []*domain.Finding {
+	what := "client's SYN"
+	if hasFlag(a.pkt, "ACK") {
+		what = "server's SYN-ACK"
+	}
+	where := ""
+	if a.stream != b.stream {
+		where = fmt.Sprintf(" (translated from %s:%d > %s:%d to %s:%d > %s:%d)",
+			a.pkt.SrcIP, a.pkt.SrcPort, a.pkt.DstIP, a.pkt.DstPort, b.pkt.SrcIP, b.pkt.SrcPort, b.pkt.DstIP, b.pkt.DstPort)
+	}
+	streams := []*domain.Stream{a.stream, b.stream}
+	evidence := []*domain.Evidence{newEvidence(a.stream, a.pkt), newEvidence(b.stream, b.pkt)}
+	ao, bo := optionsOrEmpty(a.pkt.Options), optionsOrEmpty(b.pkt.Options)
+	gap := formatGap(b.pkt.Timestamp.Sub(a.pkt.Timestamp))
+
+	var findings []*domain.Finding
+	if ao.MSS != 0 && bo.MSS != 0 && ao.MSS != bo.MSS {
+		findings = append(findings, middleboxFinding(streams, domain.SeverityNormal, evidence,
+			"MSS Rewritten: the %s carried MSS %d and, %s later, MSS %d%s; a device between the capture points clamps the MSS",
+			what, ao.MSS, gap, bo.MSS, where))
+	}
+	var stripped []string
+	for _, o := range []struct {
+		name    string
+		was, is bool
+	}{
+		{"MSS", ao.MSS != 0, bo.MSS != 0},
+		{"SACK", ao.SACKPermitted, bo.SACKPermitted},
+		{"window scaling", ao.HasWindowScale, bo.HasWindowScale},
+		{"timestamps", ao.HasTimestamps, bo.HasTimestamps},
+		{"Fast Open", ao.FastOpen, bo.FastOpen},
+		{"ECN setup", hasFlag(a.pkt, "ECE"), hasFlag(b.pkt, "ECE")},
+	} {
+		if o.was && !o.is {
+			stripped = append(stripped, o.name)
+		}
+	}
+	if len(stripped) > 0 {
+		findings = append(findings, middleboxFinding(streams, domain.SeverityWarning, evidence,
+			"Options Stripped: a device between the capture points removed %s from the %s, seen again %s later%s",
+			strings.Join(stripped, ", "), what, gap, where))
+	}
+	return findings
+}
@@ -0,0 +1,108 @@
+package analyzer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+func TestMiddleboxes(t *testing.T) {
+	ms := time.Millisecond
+	const request = "GET /blocked HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	tests := []struct {
+		name     string
+		build    func() *conn
+		want     string
+		severity domain.Severity
+		evidence []int // packets behind the finding
+	}{
+		{"clean", func() *conn {
+			c := newConn(40000, 80).handshake(0)
+			c.send(true, 10*ms, request)
+			c.send(false, 20*ms, "HTTP/1.1 200 OK\r\n\r\n")
+			return c
+		}, "", "", nil},
+		{"TTL change", func() *conn {
+			c := newConn(40000, 80).handshake(0)
+			c.send(true, 10*ms, request)
+			c.send(false, 20*ms, "HTTP/1.1 200 OK\r\n")
+			c.send(false, 30*ms, "\r\n").TTL = 52
+			return c
+		}, "TTL Change: server→client TTL changed 1 time(s), first from 64 to 52 at 00:00:00.030; the packets took another route or another device answered for the endpoint",
+			domain.SeverityWarning, []int{4, 5}},
+		{"reset with another TTL", func() *conn {
+			c := newConn(40000, 80).handshake(0)
+			c.send(true, 10*ms, request)
+			c.send(false, 12*ms, "", "RST").TTL = 250
+			return c
+		}, fmt.Sprintf(`RST Injection: a reset claiming to come from the server followed the client's %d-byte segment "GET /blocked HTTP/1.1" by 2.0ms, with TTL 250 where the server's packets have 64; a firewall or IPS in the path likely sent it`, len(request)),
+			domain.SeverityCritical, []int{3, 4}},
+		{"reset off the IP ID counter", func() *conn {
+			c := newConn(40000, 80).handshake(0)
+			c.stream.Packets[1].IPID = 100
+			for i := 1; i <= 4; i++ {
+				c.send(false, time.Duration(10*i)*ms, "data").IPID = uint16(100 + i)
+			}
+			c.send(true, 60*ms, request)
+			c.send(false, 61*ms, "", "RST").IPID = 40000
+			return c
+		}, fmt.Sprintf(`RST Injection: a reset claiming to come from the server followed the client's %d-byte segment "GET /blocked HTTP/1.1" by 1.0ms, with IP ID 40000 off the server's counter at 104; a firewall or IPS in the path likely sent it`, len(request)),
+			domain.SeverityWarning, []int{7, 8}},
+		{"MSS rewritten", func() *conn {
+			c := newConn(40000, 80)
+			syn := c.send(true, 0, "", "SYN")
+			syn.Options = &domain.TCPOptions{MSS: 1460}
+			clamped := c.resend(syn, 500*time.Microsecond)
+			clamped.TTL, clamped.Options = 63, &domain.TCPOptions{MSS: 1400}
+			c.send(false, ms, "", "SYN", "ACK")
+			c.send(true, 2*ms, "")
+			return c
+		}, "MSS Rewritten: the client's SYN carried MSS 1460 and, 0.5ms later, MSS 1400; a device between the capture points clamps the MSS",
+			domain.SeverityNormal, []int{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.build().finish()
+			e := NewEngine()
+			e.AnalyzeStream(s)
+			report := e.AnalyzeCapture([]*domain.Stream{s}, nil)
+
+			var found []*domain.Finding
+			var messages []string
+			for _, f := range report.Findings {
+				if f.Category == "Middlebox" {
+					found = append(found, f)
+					messages = append(messages, f.Message)
+				}
+			}
+			if tt.want == "" {
+				if len(found) != 0 {
+					t.Errorf("findings = %q, want none", messages)
+				}
+				return
+			}
+			if len(found) != 1 || found[0].Message != tt.want {
+				t.Fatalf("findings = %q, want %q", messages, tt.want)
+			}
+			f := found[0]
+			if f.Severity != tt.severity {
+				t.Errorf("severity = %s, want %s", f.Severity, tt.severity)
+			}
+			var packets []int
+			for _, ev := range f.Evidence {
+				if ev.Stream != s.ID {
+					t.Errorf("evidence from stream %s", ev.Stream)
+				}
+				packets = append(packets, ev.Packet)
+			}
+			if fmt.Sprint(packets) != fmt.Sprint(tt.evidence) {
+				t.Errorf("evidence = packets %v, want %v", packets, tt.evidence)
+			}
+			if !hasAnalysis(s, tt.want) {
+				t.Errorf("finding not raised on the stream: %q", s.Analysis)
+			}
+		})
+	}
+}
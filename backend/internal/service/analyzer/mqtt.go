@@ -0,0 +1,252 @@
+package analyzer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/mqtt"
+)
+
+// mqttKeepAliveGrace is how far past the keep alive interval a broker
+// waits before dropping a silent client (MQTT 3.1.1 section 3.1.2.10)
+const mqttKeepAliveGrace = 1.5
+
+// dissectMQTT decodes MQTT connections from the CONNECT or, for
+// connections captured mid-session, on port 1883. CONNECT, PUBLISH,
+// SUBSCRIBE, UNSUBSCRIBE and PINGREQ become "MQTT" transactions completed
+// by their acks; refused connections, failed acks, slow QoS
+// acknowledgements, unanswered pings and missed keep alives are reported.
+func (e *Engine) dissectMQTT(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" || !claimable(stream, "MQTT") {
+		return
+	}
+	client, server := sc.flows()
+	if !mqtt.IsConnect(client.Data) && !(stream.ServerPort == 1883 && len(client.Data) > 0) {
+		return
+	}
+
+	conv := mqtt.Parse(client, server)
+	if len(conv.Exchanges) == 0 {
+		return
+	}
+	confirm(stream, "MQTT")
+	version := conv.Version()
+
+	var txs []*domain.Transaction
+	for _, ex := range conv.Exchanges {
+		p := ex.Request
+		sender, receiver := client, server
+		if !p.FromClient {
+			sender, receiver = server, client
+		}
+		target := p.Topic
+		switch p.Type {
+		case mqtt.Connect:
+			target = p.ClientID
+		case mqtt.Subscribe, mqtt.Unsubscribe:
+			target = strings.Join(p.Filters, ",")
+		}
+		tx := flowRequest(stream, "MQTT", mqtt.TypeName(p.Type), target, sender, p.Offset, p.End)
+		tx.Attributes["version"] = mqtt.VersionName(version)
+		if !p.FromClient {
+			tx.Attributes["direction"] = "server_to_client"
+		}
+		switch p.Type {
+		case mqtt.Connect:
+			tx.Attributes["keepalive_s"] = strconv.Itoa(int(p.KeepAlive))
+			tx.Attributes["clean_session"] = strconv.FormatBool(p.CleanSession)
+		case mqtt.Publish:
+			tx.Attributes["qos"] = strconv.Itoa(int(p.QoS))
+			tx.Attributes["bytes"] = strconv.Itoa(p.Payload)
+			if p.Retain {
+				tx.Attributes["retain"] = "true"
+			}
+			if p.Dup {
+				tx.Attributes["dup"] = "true"
+			}
+		}
+		if ex.Received != nil {
+			tx.Attributes["pubrec_ms"] = fmt.Sprintf("%.1f", msBetween(p.At, ex.Received.At))
+		}
+
+		switch r := ex.Response; {
+		case r != nil:
+			flowRespond(tx, sender, receiver, p.End, r.Offset, r.End)
+			setMQTTAck(tx, version, r)
+		case p.Type == mqtt.Publish && p.QoS == 0:
+			// QoS 0 is never acknowledged
+		default:
+			flowUnanswered(tx, sender, receiver)
+		}
+		txs = append(txs, tx)
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	for _, tx := range txs {
+		if tx.Method == "CONNECT" && tx.Error != "" {
+			raise(stream, domain.SeverityCritical, "MQTT Connection Refused: broker answered CONNECT from client %q with %s",
+				tx.Target, tx.Error)
+			break
+		}
+	}
+	e.detectMQTTErrors(stream, txs)
+	e.detectSlowMQTTAcks(stream, txs)
+	detectMQTTPings(stream, txs)
+	detectMQTTKeepAlive(stream, conv)
+}
+
+func setMQTTAck(tx *domain.Transaction, version byte, ack *mqtt.Packet) {
+	if ack.Type == mqtt.PingResp {
+		return
+	}
+	codes := []byte{ack.Code}
+	if ack.Type == mqtt.SubAck || ack.Type == mqtt.UnsubAck {
+		codes = ack.Codes
+	}
+	for _, code := range codes {
+		if mqtt.IsFailure(ack.Type, code) {
+			tx.Status = int(code)
+			tx.StatusText = mqtt.CodeName(ack.Type, version, code)
+			tx.Error = tx.StatusText
+			tx.Attributes["error_code"] = tx.StatusText
+			return
+		}
+	}
+	if len(codes) > 0 {
+		tx.Status = int(codes[0])
+		tx.StatusText = mqtt.CodeName(ack.Type, version, codes[0])
+	}
+}
+
+// detectMQTTErrors reports refused subscriptions and publishes and
+// requests left unacknowledged when the connection closed. Refused
+// connections and unanswered pings have findings of their own.
+func (e *Engine) detectMQTTErrors(stream *domain.Stream, txs []*domain.Transaction) {
+	codes := map[string]int{}
+	var first *domain.Transaction
+	failed, total := 0, 0
+	for _, tx := range txs {
+		if tx.Method == "CONNECT" || tx.Method == "PINGREQ" {
+			continue
+		}
+		total++
+		if tx.Error == "" {
+			continue
+		}
+		failed++
+		code := tx.Attributes["error_code"]
+		if code == "" {
+			code = "closed"
+		}
+		codes[code]++
+		if first == nil {
+			first = tx
+		}
+	}
+	if first == nil {
+		return
+	}
+	raise(stream, domain.SeverityWarning, "MQTT Errors: %d of %d operations failed (%s); first %s %s: %s",
+		failed, total, formatNameCounts(codes), first.Method, shortenStatement(first.Target, 80), first.Error)
+}
+
+// detectSlowMQTTAcks reports QoS 1 and 2 publishes and subscriptions
+// acknowledged slower than the threshold
+func (e *Engine) detectSlowMQTTAcks(stream *domain.Stream, txs []*domain.Transaction) {
+	limit := time.Duration(e.thresholds.MQTTSlowAckSeconds * float64(time.Second))
+	var worst *domain.Transaction
+	slow, total := 0, 0
+	for _, tx := range txs {
+		if tx.Method == "PINGREQ" || tx.Attributes["qos"] == "0" {
+			continue
+		}
+		total++
+		if tx.ResponseTime.IsZero() || tx.Latency <= limit {
+			continue
+		}
+		slow++
+		if worst == nil || tx.Latency > worst.Latency {
+			worst = tx
+		}
+	}
+	if worst == nil {
+		return
+	}
+	raise(stream, domain.SeverityWarning, "Slow MQTT Acknowledgements: %d of %d over %.1fs (worst %.2fs for %s %s)",
+		slow, total, limit.Seconds(), worst.Latency.Seconds(), worst.Method, shortenStatement(worst.Target, 80))
+}
+
+// detectMQTTPings reports keep alive pings the broker did not answer
+func detectMQTTPings(stream *domain.Stream, txs []*domain.Transaction) {
+	unanswered, total := 0, 0
+	var first *domain.Transaction
+	for _, tx := range txs {
+		if tx.Method != "PINGREQ" {
+			continue
+		}
+		total++
+		if tx.ResponseTime.IsZero() {
+			unanswered++
+			if first == nil {
+				first = tx
+			}
+		}
+	}
+	if unanswered == 0 {
+		return
+	}
+	raise(stream, domain.SeverityWarning, "MQTT Unanswered Pings: %d of %d PINGREQ got no PINGRESP (first at %s)",
+		unanswered, total, first.RequestTime.Format("15:04:05.000"))
+}
+
+// detectMQTTKeepAlive reports the client going silent for longer than the
+// broker tolerates: 1.5 times the keep alive interval it announced in
+// CONNECT. A silence that runs into the broker closing the connection
+// means the broker dropped the client.
+func detectMQTTKeepAlive(stream *domain.Stream, conv *mqtt.Conversation) {
+	if conv.Connect == nil || conv.Connect.KeepAlive == 0 {
+		return
+	}
+	allowed := time.Duration(float64(conv.Connect.KeepAlive) * mqttKeepAliveGrace * float64(time.Second))
+	var last time.Time
+	missed := 0
+	var worst time.Duration
+	for _, p := range conv.Packets {
+		if !p.FromClient {
+			continue
+		}
+		if !last.IsZero() {
+			if gap := p.At.Sub(last); gap > allowed {
+				missed++
+				worst = max(worst, gap)
+			}
+		}
+		last = p.At
+	}
+
+	dropped := false
+	for _, pkt := range stream.Packets {
+		if last.IsZero() || stream.FromClient(pkt) || !pkt.Timestamp.After(last) {
+			continue
+		}
+		if gap := pkt.Timestamp.Sub(last); gap > allowed && (hasFlag(pkt, "FIN") || hasFlag(pkt, "RST")) {
+			dropped = true
+			missed++
+			worst = max(worst, gap)
+			break
+		}
+	}
+	if missed == 0 {
+		return
+	}
+	msg := fmt.Sprintf("MQTT Missed Keep Alive: client %q was silent for up to %.1fs %d time(s); keep alive is %ds, so the broker allows %.0fs",
+		conv.Connect.ClientID, worst.Seconds(), missed, conv.Connect.KeepAlive, allowed.Seconds())
+	if dropped {
+		msg += "; the broker then closed the connection"
+	}
+	raise(stream, domain.SeverityWarning, "%s", msg)
+}
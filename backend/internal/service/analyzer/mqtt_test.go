@@ -0,0 +1,70 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	mqttPingReq  = "\xc0\x00"
+	mqttPingResp = "\xd0\x00"
+	mqttConnAck  = "\x20\x02\x00\x00"
+)
+
+// mqttConnect is an MQTT 3.1.1 CONNECT with the given keep alive
+func mqttConnect(clientID string, keepAlive uint16) string {
+	body := "\x00\x04MQTT\x04\x02" + string([]byte{byte(keepAlive >> 8), byte(keepAlive)}) +
+		string([]byte{0, byte(len(clientID))}) + clientID
+	return "\x10" + string([]byte{byte(len(body))}) + body
+}
+
+func TestMQTTPings(t *testing.T) {
+	s := time.Second
+	type ping struct {
+		at       time.Duration
+		answered bool
+	}
+	tests := []struct {
+		name      string
+		pings     []ping
+		closeAt   time.Duration // broker FIN, 0 for none
+		unanswer  string
+		keepAlive string
+	}{
+		{"on time", []ping{{10 * s, true}, {20 * s, true}, {30 * s, true}}, 0, "", ""},
+		{"unanswered", []ping{{10 * s, true}, {20 * s, false}, {30 * s, true}}, 0,
+			"MQTT Unanswered Pings: 1 of 3 PINGREQ got no PINGRESP (first at 00:00:20.000)", ""},
+		{"missed", []ping{{10 * s, true}, {28 * s, true}}, 0, "",
+			`MQTT Missed Keep Alive: client "sensor-1" was silent for up to 18.0s 1 time(s); keep alive is 10s, so the broker allows 15s`},
+		{"dropped", []ping{{10 * s, true}}, 26 * s, "",
+			`MQTT Missed Keep Alive: client "sensor-1" was silent for up to 16.0s 1 time(s); keep alive is 10s, so the broker allows 15s; the broker then closed the connection`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConn(40000, 1883).handshake(-5 * time.Millisecond)
+			c.send(true, 0, mqttConnect("sensor-1", 10))
+			c.send(false, 5*time.Millisecond, mqttConnAck)
+			for _, p := range tt.pings {
+				c.send(true, p.at, mqttPingReq)
+				if p.answered {
+					c.send(false, p.at+5*time.Millisecond, mqttPingResp)
+				}
+			}
+			if tt.closeAt > 0 {
+				c.send(false, tt.closeAt, "", "FIN", "ACK")
+			}
+			st := c.finish()
+			NewEngine().AnalyzeStream(st)
+
+			if st.Protocol != "MQTT" {
+				t.Fatalf("protocol = %s, want MQTT", st.Protocol)
+			}
+			if got := findAnalysis(st, "MQTT Unanswered Pings"); got != tt.unanswer {
+				t.Errorf("ping finding = %q, want %q", got, tt.unanswer)
+			}
+			if got := findAnalysis(st, "MQTT Missed Keep Alive"); got != tt.keepAlive {
+				t.Errorf("keep alive finding = %q, want %q", got, tt.keepAlive)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package analyzer
+
+import (
+	"strconv"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/mysql"
+)
+
+// dissectMySQL decodes MySQL connections, from the server greeting or, for
+// connections captured mid-session on port 3306, from the first command.
+// Each command becomes a "MySQL" transaction; login, errors and slow
+// statements are reported.
+func (e *Engine) dissectMySQL(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" || !claimable(stream, "MySQL") {
+		return
+	}
+	client, server := sc.flows()
+	if !mysql.IsGreeting(server.Data) && !(stream.ServerPort == 3306 && mysql.LooksLikeCommand(client.Data)) {
+		return
+	}
+	confirm(stream, "MySQL")
+
+	conv := mysql.Parse(client, server)
+	var txs []*domain.Transaction
+	if hs := conv.Handshake; hs != nil {
+		if hs.TLS {
+			raise(stream, domain.SeverityNormal, "MySQL Encrypted: client switched to TLS after the greeting from server %s; statements are not visible",
+				hs.ServerVersion)
+			return
+		}
+		if hs.End > hs.Offset {
+			target := hs.User
+			if hs.Database != "" {
+				target += "@" + hs.Database
+			}
+			tx := flowRequest(stream, "MySQL", "LOGIN", target, client, hs.Offset, hs.End)
+			tx.Attributes["server_version"] = hs.ServerVersion
+			if hs.Result != nil {
+				flowRespond(tx, client, server, hs.End, hs.Result.Offset, hs.Result.End)
+				setMySQLResult(tx, hs.Result)
+			} else {
+				flowUnanswered(tx, client, server)
+			}
+			txs = append(txs, tx)
+		}
+	}
+
+	for _, ex := range conv.Exchanges {
+		c := ex.Command
+		if !c.ExpectsResponse() {
+			continue
+		}
+		tx := flowRequest(stream, "MySQL", c.Name, c.Statement, client, c.Offset, c.End)
+		switch c.Code {
+		case mysql.ComQuery, mysql.ComStmtPrepare, mysql.ComStmtExecute:
+			if c.Statement != "" {
+				tx.Attributes["fingerprint"] = fingerprintSQL(c.Statement)
+			}
+		case mysql.ComBinlogDump:
+			tx.Attributes["blocking"] = "true"
+		}
+		if c.Truncated {
+			tx.Attributes["truncated"] = "true"
+		}
+		if hs := conv.Handshake; hs != nil {
+			if hs.User != "" {
+				tx.Attributes["user"] = hs.User
+			}
+			if hs.Database != "" {
+				tx.Attributes["database"] = hs.Database
+			}
+		}
+		if r := ex.Response; r != nil {
+			flowRespond(tx, client, server, c.End, r.Offset, r.End)
+			setMySQLResult(tx, r)
+		} else {
+			flowUnanswered(tx, client, server)
+		}
+		txs = append(txs, tx)
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	e.detectDBLoginFailures(stream, "MySQL", txs)
+	e.detectDBErrors(stream, "MySQL", txs)
+	e.detectSlowDBQueries(stream, "MySQL", txs)
+}
+
+func setMySQLResult(tx *domain.Transaction, r *mysql.Response) {
+	tx.StatusText = r.Kind
+	switch r.Kind {
+	case mysql.ResponseError:
+		tx.Status = r.ErrorCode
+		tx.StatusText = r.SQLState
+		tx.Error = r.Message
+		if tx.Error == "" {
+			tx.Error = "error " + strconv.Itoa(r.ErrorCode)
+		}
+		tx.Attributes["error_code"] = strconv.Itoa(r.ErrorCode)
+	case mysql.ResponseOK:
+		tx.Attributes["affected_rows"] = strconv.FormatUint(r.AffectedRows, 10)
+	case mysql.ResponseResultSet:
+		tx.Attributes["columns"] = strconv.Itoa(r.Columns)
+		tx.Attributes["rows"] = strconv.Itoa(r.Rows)
+	}
+	if r.Incomplete {
+		tx.Attributes["incomplete"] = "true"
+	}
+}
@@ -0,0 +1,82 @@
+package analyzer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/nfs"
+)
+
+// dissectNFS decodes NFSv3 and NFSv4 over TCP, from the first RPC call to
+// the NFS program or, for connections captured mid-record, on port 2049.
+// Each call becomes an "NFS" transaction paired with its reply by XID;
+// failed and slow operations are reported.
+func (e *Engine) dissectNFS(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" || !claimable(stream, "NFS") {
+		return
+	}
+	client, server := sc.flows()
+	if !nfs.IsCall(client.Data) && !(stream.ServerPort == 2049 && len(client.Data) > 0) {
+		return
+	}
+
+	conv := nfs.Parse(client, server)
+	if len(conv.Exchanges) == 0 {
+		return
+	}
+	confirm(stream, "NFS")
+
+	var txs []*domain.Transaction
+	private := false
+	for _, ex := range conv.Exchanges {
+		c := ex.Call
+		tx := flowRequest(stream, "NFS", c.Op, ex.File, client, c.Offset, c.End)
+		tx.Attributes["xid"] = fmt.Sprintf("0x%08x", c.XID)
+		version := strconv.Itoa(int(c.Version))
+		if c.Version == 4 && len(c.Ops) > 0 {
+			version += "." + strconv.Itoa(int(c.Minor))
+			tx.Attributes["ops"] = strings.Join(c.Ops, ",")
+		}
+		tx.Attributes["version"] = version
+		if ex.File != "" {
+			tx.Attributes["file"] = ex.File
+		}
+		if c.Count > 0 {
+			tx.Attributes["bytes"] = strconv.FormatUint(uint64(c.Count), 10)
+		}
+		if c.Private {
+			tx.Attributes["encrypted"] = "true"
+			private = true
+		}
+		if r := ex.Reply; r != nil {
+			flowRespond(tx, client, server, c.End, r.Offset, r.End)
+			switch {
+			case r.Error != "":
+				tx.StatusText = r.Error
+				tx.Error = r.Error
+				tx.Attributes["error_code"] = r.Error
+			case c.Private:
+			default:
+				tx.Status = int(r.Status)
+				tx.StatusText = nfs.StatusName(c.Version, r.Status)
+				if nfs.IsError(c.Op, r.Status) {
+					tx.Error = tx.StatusText
+					tx.Attributes["error_code"] = tx.StatusText
+				}
+			}
+		} else {
+			flowUnanswered(tx, client, server)
+		}
+		txs = append(txs, tx)
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	if private {
+		raise(stream, domain.SeverityNormal, "NFS Encrypted: RPCSEC_GSS privacy hides the arguments and results of some calls")
+	}
+	e.detectFileErrors(stream, "NFS", txs, nil)
+	e.detectSlowFileOps(stream, "NFS", txs)
+}
@@ -0,0 +1,102 @@
+package analyzer
+
+import (
+	"strconv"
+	"strings"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/postgres"
+)
+
+// dissectPostgreSQL decodes PostgreSQL connections, from the startup
+// message or, for connections captured mid-session on port 5432, from the
+// first query. Each unit of work answered by a ReadyForQuery becomes a
+// "PostgreSQL" transaction; login, errors and slow statements are reported.
+func (e *Engine) dissectPostgreSQL(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" || !claimable(stream, "PostgreSQL") {
+		return
+	}
+	client, server := sc.flows()
+	if !postgres.IsStartup(client.Data) && !(stream.ServerPort == 5432 && postgres.LooksLikeQuery(client.Data)) {
+		return
+	}
+	confirm(stream, "PostgreSQL")
+
+	conv := postgres.Parse(client, server)
+	if s := conv.Startup; s != nil {
+		switch {
+		case s.TLS:
+			raise(stream, domain.SeverityNormal, "PostgreSQL Encrypted: server accepted SSL/GSS encryption; statements are not visible")
+			return
+		case s.Cancel:
+			raise(stream, domain.SeverityNormal, "PostgreSQL Cancel Request: client asked the server to cancel a running query")
+			return
+		}
+	}
+
+	var txs []*domain.Transaction
+	for _, ex := range conv.Exchanges {
+		q := ex.Query
+		method, statement := q.Method, q.Statement
+		if method == "STARTUP" {
+			method = "LOGIN"
+			statement = conv.Startup.User
+			if conv.Startup.Database != "" {
+				statement += "@" + conv.Startup.Database
+			}
+		}
+		tx := flowRequest(stream, "PostgreSQL", method, statement, client, q.Offset, q.End)
+		if q.Method != "STARTUP" && q.Statement != "" {
+			tx.Attributes["fingerprint"] = fingerprintSQL(q.Statement)
+		}
+		if q.Truncated {
+			tx.Attributes["truncated"] = "true"
+		}
+		if s := conv.Startup; s != nil {
+			if s.User != "" {
+				tx.Attributes["user"] = s.User
+			}
+			if s.Database != "" {
+				tx.Attributes["database"] = s.Database
+			}
+			if s.Application != "" {
+				tx.Attributes["application"] = s.Application
+			}
+		}
+		if r := ex.Result; r != nil {
+			flowRespond(tx, client, server, q.End, r.Offset, r.End)
+			setPostgresResult(tx, r)
+		} else {
+			flowUnanswered(tx, client, server)
+		}
+		txs = append(txs, tx)
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	e.detectDBLoginFailures(stream, "PostgreSQL", txs)
+	e.detectDBErrors(stream, "PostgreSQL", txs)
+	e.detectSlowDBQueries(stream, "PostgreSQL", txs)
+}
+
+func setPostgresResult(tx *domain.Transaction, r *postgres.Result) {
+	tx.StatusText = strings.Join(r.Tags, "; ")
+	if r.Rows > 0 || len(r.Tags) > 0 {
+		tx.Attributes["rows"] = strconv.Itoa(r.Rows)
+	}
+	if r.Ready {
+		tx.Attributes["tx_status"] = string(r.TxStatus)
+	}
+	if r.Error != nil {
+		tx.StatusText = r.Error.Code
+		tx.Error = r.Error.Message
+		if tx.Error == "" {
+			tx.Error = r.Error.Severity + " " + r.Error.Code
+		}
+		tx.Attributes["error_code"] = r.Error.Code
+		tx.Attributes["error_severity"] = r.Error.Severity
+	}
+	if r.Incomplete {
+		tx.Attributes["incomplete"] = "true"
+	}
+}
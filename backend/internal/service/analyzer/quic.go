@@ -0,0 +1,302 @@
+package analyzer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/classify"
+	"pcap-analyzer/internal/service/dissector/quic"
+	tlsdissect "pcap-analyzer/internal/service/dissector/tls"
+)
+
+// quicMinInitialDatagram is the smallest UDP payload allowed to carry a
+// client Initial (RFC 9000 section 14.1)
+const quicMinInitialDatagram = 1200
+
+// dissectQUIC recognizes QUIC connections from their long-header packets,
+// decrypts the Initial packets to read the ClientHello and ServerHello, and
+// records a "QUIC" handshake transaction with the version, connection IDs,
+// SNI, ALPN, JA4 and handshake timing. Streams offering h3 are labeled
+// HTTP3. Migration to a new 5-tuple is followed in AnalyzeCapture.
+func (e *Engine) dissectQUIC(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "UDP" || !claimable(stream, "QUIC", "HTTP3") {
+		return
+	}
+
+	var (
+		version                uint32
+		origDCID, clientCID    []byte
+		serverCID              []byte
+		haveClientCID          bool
+		keys                   *quic.InitialKeys
+		clientCrypto           []quic.CryptoFrame
+		serverCrypto           []quic.CryptoFrame
+		closeFrame             *quic.ConnectionClose
+		closeFromServer, retry bool
+		offered                []uint32
+		negotiatedFrom         uint32
+		clientInitials         int
+		firstInitial           time.Time
+		firstServer, handshake time.Time
+	)
+
+	for _, pkt := range stream.Packets {
+		if len(pkt.Payload) == 0 {
+			continue
+		}
+		fromClient := stream.FromClient(pkt)
+		// Short headers carry the peer's connection ID without its length
+		dcidLen := -1
+		if fromClient && serverCID != nil {
+			dcidLen = len(serverCID)
+		} else if !fromClient && haveClientCID {
+			dcidLen = len(clientCID)
+		}
+		if version == 0 && !quic.IsLongHeader(pkt.Payload) {
+			continue // wait for a handshake packet before trusting short headers
+		}
+		pkts, _ := quic.Parse(pkt.Payload, dcidLen)
+
+		for _, p := range pkts {
+			switch {
+			case fromClient && p.Type == quic.PacketInitial:
+				if version == 0 {
+					if !quic.Known(p.Version) && len(pkt.Payload) < quicMinInitialDatagram {
+						continue
+					}
+					version, origDCID = p.Version, p.DCID
+					clientCID, haveClientCID = p.SCID, true
+					firstInitial = pkt.Timestamp
+					keys, _ = quic.NewInitialKeys(version, origDCID)
+				} else if offered != nil && p.Version != version {
+					// The client switched to a version the server offered
+					negotiatedFrom, version = version, p.Version
+					keys, clientCrypto = nil, nil
+					offered = nil
+				}
+				clientInitials++
+				payload, err := decryptInitial(&keys, version, p, true)
+				if err != nil {
+					continue
+				}
+				frames := quic.ParseFrames(payload)
+				clientCrypto = append(clientCrypto, frames.Crypto...)
+				if frames.Close != nil && closeFrame == nil {
+					closeFrame = frames.Close
+				}
+			case version == 0:
+				// Nothing from the server counts before the client's Initial
+			case fromClient:
+				if handshake.IsZero() && !firstServer.IsZero() && (p.Type == quic.PacketHandshake || p.Type == quic.Packet1RTT) {
+					handshake = pkt.Timestamp
+				}
+			case p.Type == quic.PacketVersionNegotiation:
+				offered = p.SupportedVersions
+				if firstServer.IsZero() {
+					firstServer = pkt.Timestamp
+				}
+			case p.Type == quic.PacketRetry:
+				// The client retries with the Retry's SCID as its new DCID
+				retry = true
+				keys = nil
+			default:
+				if firstServer.IsZero() {
+					firstServer = pkt.Timestamp
+				}
+				if p.Type != quic.PacketInitial {
+					continue
+				}
+				if serverCID == nil {
+					serverCID = p.SCID
+				}
+				payload, err := decryptInitial(&keys, version, p, false)
+				if err != nil {
+					continue
+				}
+				frames := quic.ParseFrames(payload)
+				serverCrypto = append(serverCrypto, frames.Crypto...)
+				if frames.Close != nil && closeFrame == nil {
+					closeFrame, closeFromServer = frames.Close, true
+				}
+			}
+		}
+	}
+	if version == 0 {
+		return
+	}
+
+	ch := tlsdissect.ParseHandshake(quic.AssembleCrypto(clientCrypto)).ClientHello
+	sh := tlsdissect.ParseHandshake(quic.AssembleCrypto(serverCrypto)).ServerHello
+
+	confirm(stream, "QUIC")
+	tx := &domain.Transaction{
+		Protocol:    "QUIC",
+		Method:      "handshake",
+		StatusText:  quic.VersionName(version),
+		RequestTime: firstInitial,
+		Attributes: map[string]string{
+			"version":       quic.VersionName(version),
+			"dcid":          hex.EncodeToString(origDCID),
+			"scid":          hex.EncodeToString(clientCID),
+			"initial_count": strconv.Itoa(clientInitials),
+		},
+	}
+	if serverCID != nil {
+		tx.Attributes["server_cid"] = hex.EncodeToString(serverCID)
+	}
+	if !firstServer.IsZero() {
+		tx.ResponseTime = firstServer
+		if latency := firstServer.Sub(firstInitial); latency > 0 {
+			tx.Latency = latency
+		}
+	}
+	if !handshake.IsZero() {
+		tx.Attributes["handshake_ms"] = fmt.Sprintf("%.1f", msBetween(firstInitial, handshake))
+	}
+	if retry {
+		tx.Attributes["retry"] = "true"
+	}
+	if negotiatedFrom != 0 {
+		tx.Attributes["negotiated_from"] = quic.VersionName(negotiatedFrom)
+	}
+	if ch != nil {
+		tx.Target = ch.ServerName
+		tx.Host = ch.ServerName
+		tx.Attributes["sni"] = ch.ServerName
+		tx.Attributes["offered_alpn"] = strings.Join(ch.ALPN, ",")
+		tx.Attributes["ja4"] = tlsdissect.JA4(ch, true)
+		for _, proto := range ch.ALPN {
+			if proto == "h3" || strings.HasPrefix(proto, "h3-") {
+				confirm(stream, "HTTP3")
+				break
+			}
+		}
+	}
+	if sh != nil {
+		tx.Attributes["cipher"] = tlsdissect.CipherName(sh.CipherSuite)
+	}
+	if closeFrame != nil {
+		tx.Status = int(closeFrame.ErrorCode)
+		tx.Error = peerName(closeFromServer) + " closed the connection: " + quicCloseReason(closeFrame)
+	} else if firstServer.IsZero() {
+		tx.Error = "unanswered"
+	}
+	stream.Transactions = append(stream.Transactions, tx)
+
+	name := tx.Target
+	if name == "" {
+		name = stream.ServerIP
+	}
+	switch {
+	case offered != nil:
+		raise(stream, domain.SeverityCritical, "QUIC Version Negotiation: server does not support %s for %s (offers %s)",
+			quic.VersionName(version), name, quicVersionList(offered))
+	case closeFrame != nil:
+		raise(stream, domain.SeverityCritical, "QUIC Handshake Failure: %s closed the connection during the handshake (%s) for %s",
+			peerName(closeFromServer), quicCloseReason(closeFrame), name)
+	case firstServer.IsZero():
+		raise(stream, domain.SeverityWarning, "Unanswered QUIC Handshake: %d Initial packet(s) to %s got no response (UDP/%d may be blocked)",
+			clientInitials, name, stream.ServerPort)
+	}
+}
+
+// decryptInitial opens an Initial packet, re-deriving the keys from the
+// packet's DCID when there are none yet (after a Retry)
+func decryptInitial(keys **quic.InitialKeys, version uint32, p *quic.Packet, fromClient bool) ([]byte, error) {
+	if *keys == nil {
+		if !fromClient {
+			return nil, fmt.Errorf("no initial keys")
+		}
+		k, err := quic.NewInitialKeys(version, p.DCID)
+		if err != nil {
+			return nil, err
+		}
+		*keys = k
+	}
+	return (*keys).Decrypt(p, fromClient)
+}
+
+func quicCloseReason(cc *quic.ConnectionClose) string {
+	var reason string
+	if alert, ok := quic.CryptoErrorAlert(cc.ErrorCode); ok && !cc.Application {
+		reason = "TLS alert " + tlsdissect.AlertName(alert)
+	} else {
+		reason = fmt.Sprintf("error 0x%x", cc.ErrorCode)
+	}
+	if cc.Reason != "" {
+		reason += ": " + cc.Reason
+	}
+	return reason
+}
+
+func quicVersionList(versions []uint32) string {
+	names := make([]string, 0, len(versions))
+	for _, v := range versions {
+		names = append(names, quic.VersionName(v))
+	}
+	return strings.Join(names, ", ")
+}
+
+// linkQUICMigrations follows QUIC connections that moved to a new 5-tuple
+// (NAT rebinding or client migration). Short-header packets on unlabeled
+// UDP streams are matched against the connection IDs of known handshakes.
+func linkQUICMigrations(streams []*domain.Stream) {
+	type origin struct {
+		stream *domain.Stream
+		tx     *domain.Transaction
+	}
+	byCID := map[string]origin{}
+	lengths := map[int]bool{}
+	for _, s := range streams {
+		for _, tx := range s.Transactions {
+			if tx.Protocol != "QUIC" {
+				continue
+			}
+			for _, key := range []string{"server_cid", "scid"} {
+				cid, err := hex.DecodeString(tx.Attributes[key])
+				if err != nil || len(cid) == 0 {
+					continue
+				}
+				byCID[string(cid)] = origin{s, tx}
+				lengths[len(cid)] = true
+			}
+		}
+	}
+	if len(byCID) == 0 {
+		return
+	}
+
+	for _, s := range streams {
+		if s.Transport != "UDP" || s.ProtocolSource == classify.SourceDissector || !claimable(s, "QUIC", "HTTP3") {
+			continue
+		}
+	packets:
+		for _, pkt := range s.Packets {
+			for n := range lengths {
+				if !quic.IsShortHeader(pkt.Payload, n) {
+					continue
+				}
+				o, ok := byCID[string(pkt.Payload[1:1+n])]
+				if !ok || o.stream == s {
+					continue
+				}
+				confirm(s, o.stream.Protocol)
+				to := fmt.Sprintf("%s:%d-%s:%d", s.ClientIP, s.ClientPort, s.ServerIP, s.ServerPort)
+				if prev := o.tx.Attributes["migrated_to"]; prev != "" {
+					to = prev + "," + to
+				}
+				o.tx.Attributes["migrated_to"] = to
+				raise(s, domain.SeverityNormal, "QUIC Connection Migration: continues the %s connection from %s:%d (connection ID %s)",
+					o.stream.Protocol, o.stream.ClientIP, o.stream.ClientPort, hex.EncodeToString(pkt.Payload[1:1+n]))
+				raise(o.stream, domain.SeverityNormal, "QUIC Connection Migration: connection moved to %s:%d-%s:%d",
+					s.ClientIP, s.ClientPort, s.ServerIP, s.ServerPort)
+				break packets
+			}
+		}
+	}
+}
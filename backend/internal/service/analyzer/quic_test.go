@@ -0,0 +1,74 @@
+package analyzer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/quic"
+)
+
+var (
+	quicClientCID = []byte{0xc1, 0xc1, 0xc1, 0xc1}
+	quicServerCID = []byte{0x5e, 0x5e, 0x5e, 0x5e, 0x5e, 0x5e, 0x5e, 0x5e}
+)
+
+// quicInitial is a QUIC v1 Initial packet padded to a full datagram. The
+// payload is not protected, which only matters for reading the TLS hello.
+func quicInitial(dcid, scid []byte) string {
+	b := []byte{0xc1, byte(quic.Version1 >> 24), byte(quic.Version1 >> 16), byte(quic.Version1 >> 8), byte(quic.Version1)}
+	b = append(b, byte(len(dcid)))
+	b = append(b, dcid...)
+	b = append(b, byte(len(scid)))
+	b = append(b, scid...)
+	b = append(b, 0) // no token
+	const length = 1200
+	b = append(b, 0x40|length>>8, length&0xff)
+	return string(append(b, bytes.Repeat([]byte{0xaa}, length)...))
+}
+
+// quicShort is a 1-RTT packet to the given connection ID
+func quicShort(dcid []byte) string {
+	return string(append(append([]byte{0x41}, dcid...), bytes.Repeat([]byte{0xbb}, 40)...))
+}
+
+func TestQUICMigration(t *testing.T) {
+	ms := time.Millisecond
+	orig := newDatagrams(50000, 443)
+	orig.send(true, 0, quicInitial([]byte{1, 2, 3, 4, 5, 6, 7, 8}, quicClientCID))
+	orig.send(false, 20*ms, quicInitial(quicClientCID, quicServerCID))
+	orig.send(true, 30*ms, quicShort(quicServerCID))
+	// After a NAT rebinding the client continues from another port
+	moved := newDatagrams(50001, 443)
+	moved.send(true, 5*time.Second, quicShort(quicServerCID))
+	moved.send(false, 5*time.Second+20*ms, quicShort(quicClientCID))
+	// Short-header lookalikes to an unknown connection ID stay unrelated
+	other := newDatagrams(50002, 443)
+	other.send(true, 6*time.Second, quicShort([]byte{9, 9, 9, 9, 9, 9, 9, 9}))
+
+	streams := []*domain.Stream{orig.finish(), moved.finish(), other.finish()}
+	e := NewEngine()
+	for _, s := range streams {
+		e.AnalyzeStream(s)
+	}
+	e.AnalyzeCapture(streams, nil)
+
+	o, m := streams[0], streams[1]
+	if o.Protocol != "QUIC" || len(o.Transactions) != 1 {
+		t.Fatalf("handshake stream = %s with %d transactions", o.Protocol, len(o.Transactions))
+	}
+	tx := o.Transactions[0]
+	if tx.Attributes["server_cid"] != "5e5e5e5e5e5e5e5e" || tx.Attributes["migrated_to"] != "10.0.0.1:50001-10.0.0.2:443" {
+		t.Errorf("handshake attributes = %v", tx.Attributes)
+	}
+	if !hasAnalysis(o, "QUIC Connection Migration: connection moved to 10.0.0.1:50001-10.0.0.2:443") {
+		t.Errorf("handshake stream findings = %q", o.Analysis)
+	}
+	if m.Protocol != "QUIC" || !hasAnalysis(m, "QUIC Connection Migration: continues the QUIC connection from 10.0.0.1:50000 (connection ID 5e5e5e5e5e5e5e5e)") {
+		t.Errorf("migrated stream = %s with findings %q", m.Protocol, m.Analysis)
+	}
+	if hasAnalysis(streams[2], "QUIC Connection Migration") {
+		t.Errorf("unrelated stream linked: %q", streams[2].Analysis)
+	}
+}
@@ -0,0 +1,77 @@
+package analyzer
+
+import (
+	"strconv"
+	"strings"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/redis"
+)
+
+// dissectRedis decodes RESP connections: any stream whose client opens with
+// a familiar command array, or traffic to port 6379. Each command becomes a
+// "Redis" transaction; error replies and slow commands are reported.
+// Statements are grouped by command name since keys carry the values.
+func (e *Engine) dissectRedis(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" || !claimable(stream, "Redis") {
+		return
+	}
+	client, server := sc.flows()
+	if !redis.LooksLikeCommand(client.Data) && !(stream.ServerPort == 6379 && len(client.Data) > 0) {
+		return
+	}
+
+	conv := redis.Parse(client, server)
+	if len(conv.Exchanges) == 0 {
+		return
+	}
+	confirm(stream, "Redis")
+
+	var txs []*domain.Transaction
+	for _, ex := range conv.Exchanges {
+		c := ex.Command
+		target := strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+		if c.Name == "AUTH" {
+			target = "AUTH" // never keep the password
+		}
+		tx := flowRequest(stream, "Redis", c.Name, target, client, c.Offset, c.End)
+		tx.Attributes["fingerprint"] = c.Name
+		tx.Attributes["argc"] = strconv.Itoa(c.Argc)
+		if c.Blocking {
+			tx.Attributes["blocking"] = "true"
+		}
+		if r := ex.Reply; r != nil {
+			flowRespond(tx, client, server, c.End, r.Offset, r.End)
+			setRedisReply(tx, r)
+		} else if !conv.Lost && !conv.PubSub {
+			flowUnanswered(tx, client, server)
+		}
+		txs = append(txs, tx)
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	if conv.PubSub {
+		raise(stream, domain.SeverityNormal, "Redis Pub/Sub: connection subscribed or entered MONITOR; pushed messages are not paired with commands")
+	}
+	e.detectDBLoginFailures(stream, "Redis", txs)
+	e.detectDBErrors(stream, "Redis", txs)
+	e.detectSlowDBQueries(stream, "Redis", txs)
+}
+
+func setRedisReply(tx *domain.Transaction, r *redis.Reply) {
+	switch {
+	case r.IsError():
+		tx.StatusText = r.ErrorPrefix()
+		tx.Error = r.Text
+		tx.Attributes["error_code"] = r.ErrorPrefix()
+	case r.Type == '+':
+		tx.StatusText = r.Text
+	}
+	if r.Len >= 0 && (r.Type == '*' || r.Type == '~' || r.Type == '%') {
+		tx.Attributes["elements"] = strconv.Itoa(r.Len)
+	}
+	if r.Incomplete {
+		tx.Attributes["incomplete"] = "true"
+	}
+}
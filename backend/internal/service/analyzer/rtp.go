@@ -0,0 +1,169 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/rtp"
+)
+
+// analyzeRTP measures every RTP source (SSRC) of a UDP stream: loss,
+// sequence gaps, RFC 3550 jitter and an estimated MOS. Streams carrying
+// RTCP instead have their reception reports collected for analyzeCalls.
+func (e *Engine) analyzeRTP(stream *domain.Stream) {
+	if stream.Transport != "UDP" || !claimable(stream, "RTP", "RTCP") {
+		return
+	}
+
+	type source struct {
+		fromClient bool
+		ssrc       uint32
+	}
+	groups := map[source][]*domain.PacketMeta{}
+	headers := map[*domain.PacketMeta]rtp.Header{}
+	var order []source
+	payloadPkts, rtpPkts, rtcpPkts := 0, 0, 0
+	var reports []*domain.RTCPReport
+
+	for _, pkt := range stream.Packets {
+		if len(pkt.Payload) == 0 {
+			continue
+		}
+		payloadPkts++
+		if rtp.IsRTCP(pkt.Payload) {
+			rtcpPkts++
+			for _, b := range rtp.ParseRTCP(pkt.Payload) {
+				reports = append(reports, &domain.RTCPReport{
+					Time:           pkt.Timestamp,
+					Reporter:       b.Reporter,
+					Source:         b.Source,
+					FractionLost:   b.FractionLost,
+					CumulativeLost: b.CumulativeLost,
+					Jitter:         b.Jitter,
+				})
+			}
+			continue
+		}
+		h, ok := rtp.ParseRTP(pkt.Payload)
+		if !ok {
+			continue
+		}
+		rtpPkts++
+		src := source{stream.FromClient(pkt), h.SSRC}
+		if _, seen := groups[src]; !seen {
+			order = append(order, src)
+		}
+		groups[src] = append(groups[src], pkt)
+		headers[pkt] = h
+	}
+
+	if rtcpPkts > 0 && float64(rtcpPkts) >= udpSequenceMatch*float64(payloadPkts) {
+		confirm(stream, "RTCP")
+		stream.Metrics.RTCP = reports
+		return
+	}
+	if rtpPkts < udpMinSequenced || float64(rtpPkts) < udpSequenceMatch*float64(payloadPkts) {
+		return
+	}
+
+	for _, src := range order {
+		pkts := groups[src]
+		if len(pkts) < udpMinSequenced {
+			continue
+		}
+		st := rtpSourceStats(stream, pkts, headers)
+		stream.Metrics.RTP = append(stream.Metrics.RTP, st)
+	}
+	if len(stream.Metrics.RTP) == 0 {
+		return
+	}
+	confirm(stream, "RTP")
+	sort.SliceStable(stream.Metrics.RTP, func(i, j int) bool {
+		return stream.Metrics.RTP[i].StartTime.Before(stream.Metrics.RTP[j].StartTime)
+	})
+
+	for _, st := range stream.Metrics.RTP {
+		e.detectRTPQuality(stream, st)
+	}
+}
+
+func rtpSourceStats(stream *domain.Stream, pkts []*domain.PacketMeta, headers map[*domain.PacketMeta]rtp.Header) *domain.RTPStats {
+	first, last := pkts[0], pkts[len(pkts)-1]
+	h0 := headers[first]
+	st := &domain.RTPStats{
+		SSRC:        h0.SSRC,
+		PayloadType: h0.PayloadType,
+		Codec:       rtp.StaticPayloadTypes[h0.PayloadType],
+		Src:         fmt.Sprintf("%s:%d", first.SrcIP, first.SrcPort),
+		Dst:         fmt.Sprintf("%s:%d", first.DstIP, first.DstPort),
+		Stream:      stream.ID,
+		StartTime:   first.Timestamp,
+		EndTime:     last.Timestamp,
+		Packets:     len(pkts),
+	}
+
+	st.ClockRate = rtp.StaticClockRate(h0.PayloadType)
+	if st.ClockRate == 0 {
+		st.ClockRate = rtp.EstimateClockRate(headers[last].Timestamp-h0.Timestamp, last.Timestamp.Sub(first.Timestamp).Seconds())
+	}
+
+	jitter := rtp.Jitter{ClockRate: st.ClockRate}
+	seen := map[int64]bool{}
+	highest := int64(h0.Sequence)
+	lowest := highest
+	for i, pkt := range pkts {
+		h := headers[pkt]
+		jitter.Add(pkt.Timestamp.Sub(first.Timestamp).Seconds(), h.Timestamp)
+
+		ext := int64(h.Sequence)
+		if i > 0 {
+			ext = unwrapSequence(highest, uint64(h.Sequence), 16)
+		}
+		switch {
+		case seen[ext]:
+			st.Duplicates++
+			continue
+		case ext < highest:
+			st.OutOfOrder++
+		case ext > highest+1:
+			st.SequenceGaps++
+			if gap := int(ext - highest - 1); gap > st.MaxGap {
+				st.MaxGap = gap
+			}
+		}
+		seen[ext] = true
+		if ext > highest {
+			highest = ext
+		}
+		if ext < lowest {
+			lowest = ext
+		}
+	}
+
+	st.Expected = int(highest - lowest + 1)
+	st.Lost = st.Expected - len(seen)
+	if st.Expected > 0 {
+		st.LossPercent = float64(st.Lost) / float64(st.Expected) * 100
+	}
+	st.JitterMs = jitter.Ms()
+	st.MaxJitterMs = jitter.MaxMs()
+	// One-way latency is not observable from a single capture point
+	st.MOS = rtp.MOS(0, st.JitterMs, st.LossPercent)
+	return st
+}
+
+func (e *Engine) detectRTPQuality(stream *domain.Stream, st *domain.RTPStats) {
+	label := fmt.Sprintf("%s -> %s (SSRC %08x)", st.Src, st.Dst, st.SSRC)
+	if st.JitterMs > e.thresholds.RTPJitterMs {
+		raise(stream, domain.SeverityWarning, "High RTP Jitter: %.1fms (max %.1fms) on %s",
+			st.JitterMs, st.MaxJitterMs, label)
+	}
+	if st.LossPercent > e.thresholds.UDPLossPercent {
+		raise(stream, domain.SeverityWarning, "RTP Packet Loss: %.1f%% (%d lost in %d gap(s), longest %d) on %s",
+			st.LossPercent, st.Lost, st.SequenceGaps, st.MaxGap, label)
+	}
+	if st.MOS < e.thresholds.RTPMinMOS {
+		raise(stream, domain.SeverityWarning, "Low Estimated MOS: %.2f on %s", st.MOS, label)
+	}
+}
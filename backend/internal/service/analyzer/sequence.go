@@ -0,0 +1,53 @@
+package analyzer
+
+import (
+	"sync"
+
+	"pcap-analyzer/internal/service/dissector/rtp"
+)
+
+// SequenceExtractor recovers per-packet sequence numbers from the payload of
+// a UDP protocol, so loss, duplication and reordering can be measured.
+type SequenceExtractor interface {
+	// Name identifies the protocol in metrics, e.g. "RTP"
+	Name() string
+	// Sequence returns the packet's sequence number and its width in bits
+	// (for wraparound), or ok=false if the payload is not this protocol.
+	Sequence(payload []byte) (seq uint64, bits uint, ok bool)
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   []SequenceExtractor
+)
+
+// RegisterSequenceExtractor makes x available to the UDP flow analysis.
+// Extractors are tried in registration order.
+func RegisterSequenceExtractor(x SequenceExtractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors = append(extractors, x)
+}
+
+func sequenceExtractors() []SequenceExtractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	return append([]SequenceExtractor(nil), extractors...)
+}
+
+func init() {
+	RegisterSequenceExtractor(rtpSequence{})
+}
+
+// rtpSequence reads the 16-bit RTP sequence number (RFC 3550)
+type rtpSequence struct{}
+
+func (rtpSequence) Name() string { return "RTP" }
+
+func (rtpSequence) Sequence(p []byte) (uint64, uint, bool) {
+	h, ok := rtp.ParseRTP(p)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(h.Sequence), 16, true
+}
@@ -0,0 +1,136 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/sip"
+)
+
+// dissectSIP decodes SIP over UDP or TCP into one "SIP" transaction per
+// request, carrying the Call-ID and the SDP media endpoints that analyzeCalls
+// later uses to link RTP streams to their call.
+func (e *Engine) dissectSIP(sc *streamContext) {
+	stream := sc.stream
+	if !claimable(stream, "SIP") {
+		return
+	}
+
+	type sipMsg struct {
+		*sip.Message
+		at time.Time
+	}
+	var msgs []sipMsg
+
+	switch stream.Transport {
+	case "TCP":
+		client, server := sc.flows()
+		if !sip.IsStart(client.Data) && !sip.IsStart(server.Data) {
+			return
+		}
+		for _, m := range sip.ParseStream(client.Data) {
+			msgs = append(msgs, sipMsg{m, client.TimeAt(m.Offset)})
+		}
+		for _, m := range sip.ParseStream(server.Data) {
+			msgs = append(msgs, sipMsg{m, server.TimeAt(m.Offset)})
+		}
+		sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].at.Before(msgs[j].at) })
+	case "UDP":
+		for _, pkt := range stream.Packets {
+			if !sip.IsStart(pkt.Payload) {
+				continue
+			}
+			if m, ok := sip.Parse(pkt.Payload); ok {
+				msgs = append(msgs, sipMsg{m, pkt.Timestamp})
+			}
+		}
+	default:
+		return
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	confirm(stream, "SIP")
+
+	var txs []*domain.Transaction
+	byKey := map[string]*domain.Transaction{}
+	for _, m := range msgs {
+		key := fmt.Sprintf("%s|%d|%s", m.CallID, m.CSeq, m.CSeqMethod)
+
+		if m.Request {
+			if m.Method == "ACK" {
+				continue // ACK has no response
+			}
+			if tx, ok := byKey[key]; ok {
+				n, _ := strconv.Atoi(tx.Attributes["attempts"])
+				tx.Attributes["attempts"] = strconv.Itoa(n + 1)
+				continue
+			}
+			tx := &domain.Transaction{
+				Protocol:     "SIP",
+				Method:       m.Method,
+				Target:       m.URI,
+				RequestTime:  m.at,
+				RequestBytes: int64(m.Size),
+				Error:        "no final response",
+				Attributes: map[string]string{
+					"call_id":  m.CallID,
+					"from":     m.From,
+					"to":       m.To,
+					"cseq":     strconv.Itoa(m.CSeq),
+					"attempts": "1",
+				},
+			}
+			if m.SDP != nil {
+				if audio, ok := m.SDP.Audio(); ok {
+					tx.Attributes["offer"] = fmt.Sprintf("%s:%d", audio.Address, audio.Port)
+					tx.Attributes["offer_codec"] = audio.Codec()
+				}
+			}
+			byKey[key] = tx
+			txs = append(txs, tx)
+			continue
+		}
+
+		tx, ok := byKey[key]
+		if !ok || tx.Status >= 200 {
+			continue // response to a request before the capture, or a retransmission
+		}
+		if m.StatusCode < 200 {
+			if (m.StatusCode == 180 || m.StatusCode == 183) && tx.Attributes["ringing_ms"] == "" {
+				tx.Attributes["ringing_ms"] = strconv.FormatInt(m.at.Sub(tx.RequestTime).Milliseconds(), 10)
+			}
+			continue
+		}
+		tx.Status = m.StatusCode
+		tx.StatusText = m.Reason
+		tx.ResponseTime = m.at
+		tx.ResponseBytes = int64(m.Size)
+		tx.Error = ""
+		if latency := m.at.Sub(tx.RequestTime); latency > 0 {
+			tx.Latency = latency
+		}
+		if m.SDP != nil {
+			if audio, ok := m.SDP.Audio(); ok {
+				tx.Attributes["answer"] = fmt.Sprintf("%s:%d", audio.Address, audio.Port)
+				tx.Attributes["answer_codec"] = audio.Codec()
+			}
+		}
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	var unanswered []*domain.Transaction
+	for _, tx := range txs {
+		if tx.ResponseTime.IsZero() {
+			unanswered = append(unanswered, tx)
+		}
+	}
+	if len(unanswered) > 0 {
+		first := unanswered[0]
+		raise(stream, domain.SeverityWarning, "Unanswered SIP Requests: %d of %d got no final response (first %s %s, %s attempt(s))",
+			len(unanswered), len(txs), first.Method, first.Target, first.Attributes["attempts"])
+	}
+}
@@ -0,0 +1,174 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/smb"
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// dissectSMB decodes SMB2/3 connections. Each command becomes an "SMB"
+// transaction named after the share and file it works on; failed logins
+// and tree connects, error statuses, slow operations and credit starvation
+// are reported.
+func (e *Engine) dissectSMB(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" || !claimable(stream, "SMB", "NetBIOS") {
+		return
+	}
+	client, server := sc.flows()
+	// On port 139 a NetBIOS session request precedes the first SMB message
+	if !smb.IsStart(client.Data) && !smb.IsStart(server.Data) && stream.Protocol != "NetBIOS" {
+		return
+	}
+
+	conv := smb.Parse(client, server)
+	if len(conv.Exchanges) == 0 && !conv.SMB1 && !conv.Encrypted {
+		return
+	}
+	confirm(stream, "SMB")
+	if conv.SMB1 {
+		raise(stream, domain.SeverityWarning, "SMB1 In Use: client offered the deprecated SMB1 dialect")
+	}
+	if conv.Encrypted {
+		raise(stream, domain.SeverityNormal, "SMB3 Encrypted: messages use the transform header; commands are not visible")
+	}
+
+	dialect := ""
+	if conv.Dialect != 0 {
+		dialect = smb.DialectName(conv.Dialect)
+	}
+	var txs []*domain.Transaction
+	for _, ex := range conv.Exchanges {
+		req := ex.Request
+		target := req.Path
+		if req.Command != smb.CmdTreeConnect {
+			target = ex.Share
+			if ex.File != "" {
+				target += `\` + ex.File
+			}
+		}
+		tx := flowRequest(stream, "SMB", smb.CommandName(req.Command), target, client, req.Offset, req.End)
+		tx.Attributes["message_id"] = strconv.FormatUint(req.MessageID, 10)
+		tx.Attributes["credit_charge"] = strconv.Itoa(int(req.CreditCharge))
+		if ex.Share != "" {
+			tx.Attributes["share"] = ex.Share
+		}
+		if ex.File != "" {
+			tx.Attributes["file"] = ex.File
+		}
+		if dialect != "" {
+			tx.Attributes["dialect"] = dialect
+		}
+		if req.Command == smb.CmdWrite {
+			tx.Attributes["bytes"] = strconv.FormatUint(uint64(req.Length), 10)
+		}
+		if req.Command == smb.CmdChangeNotify {
+			tx.Attributes["blocking"] = "true"
+		}
+		if resp := ex.Response; resp != nil {
+			flowRespond(tx, client, server, req.End, resp.Offset, resp.End)
+			tx.Status = int(resp.Status)
+			tx.StatusText = smb.StatusName(resp.Status)
+			tx.Attributes["credits_granted"] = strconv.Itoa(int(resp.Credits))
+			if smb.IsError(req.Command, resp.Status) {
+				tx.Error = tx.StatusText
+				tx.Attributes["error_code"] = tx.StatusText
+			}
+			if req.Command == smb.CmdRead && resp.Status == smb.StatusSuccess {
+				tx.Attributes["bytes"] = strconv.FormatUint(uint64(resp.Length), 10)
+			}
+		} else if !req.Lost {
+			flowUnanswered(tx, client, server)
+		}
+		if ex.Interim != nil {
+			tx.Attributes["async"] = "true"
+			tx.Attributes["interim_ms"] = fmt.Sprintf("%.1f", msBetween(client.TimeAt(req.End-1), server.TimeAt(ex.Interim.Offset)))
+		}
+		txs = append(txs, tx)
+	}
+	stream.Transactions = append(stream.Transactions, txs...)
+
+	loginFailed := false
+	for _, tx := range txs {
+		switch {
+		case tx.Error == "":
+		case tx.Method == "SESSION_SETUP" && !loginFailed:
+			raise(stream, domain.SeverityCritical, "SMB Login Failed: %s", tx.Error)
+			loginFailed = true
+		case tx.Method == "TREE_CONNECT":
+			raise(stream, domain.SeverityCritical, "SMB Tree Connect Failed: %s (%s)", tx.Target, tx.Error)
+		}
+	}
+	e.detectFileErrors(stream, "SMB", txs, func(tx *domain.Transaction) bool {
+		return tx.Method == "SESSION_SETUP" || tx.Method == "TREE_CONNECT"
+	})
+	e.detectSlowFileOps(stream, "SMB", txs)
+	e.detectCreditStarvation(stream, conv, client, server)
+}
+
+// detectCreditStarvation replays the credit balance of a connection whose
+// negotiation was captured: the client starts with one credit, each request
+// spends its charge and each response grants credits. When the balance hits
+// zero and the client sends again as soon as credits arrive, the wait was
+// imposed by the server's grants rather than by the client.
+func (e *Engine) detectCreditStarvation(stream *domain.Stream, conv *smb.Conversation, client, server *reassembly.Flow) {
+	if len(conv.Requests) == 0 || conv.Requests[0].Command != smb.CmdNegotiate || conv.Requests[0].MessageID != 0 {
+		return
+	}
+	type event struct {
+		at      time.Time
+		request bool
+		credits int
+	}
+	var events []event
+	for _, m := range conv.Requests {
+		events = append(events, event{client.TimeAt(m.Offset), true, max(int(m.CreditCharge), 1)})
+	}
+	for _, m := range conv.Responses {
+		events = append(events, event{server.TimeAt(m.Offset), false, int(m.Credits)})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+
+	// A request this soon after a grant was waiting for it
+	const followUp = 5 * time.Millisecond
+	limit := time.Duration(e.thresholds.SMBCreditStallMs * float64(time.Millisecond))
+	balance, granted := 1, 0
+	var exhausted, grant time.Time
+	var stalls int
+	var worst, total time.Duration
+	for _, ev := range events {
+		if !ev.request {
+			balance += ev.credits
+			granted += ev.credits
+			if !exhausted.IsZero() && grant.IsZero() && ev.credits > 0 {
+				grant = ev.at
+			}
+			continue
+		}
+		if !grant.IsZero() && ev.at.Sub(grant) <= followUp {
+			if wait := grant.Sub(exhausted); wait >= limit {
+				stalls++
+				total += wait
+				worst = max(worst, wait)
+			}
+		}
+		exhausted, grant = time.Time{}, time.Time{}
+		balance -= ev.credits
+		if balance < 0 {
+			return // multi-credit charges or a missed response: the replay is unreliable
+		}
+		if balance == 0 {
+			exhausted = ev.at
+		}
+	}
+	if stalls == 0 {
+		return
+	}
+	raise(stream, domain.SeverityWarning, "SMB Credit Starvation: client ran out of credits %d time(s) and waited up to %.0f ms (%.0f ms total) for the server to grant more; %d credits granted over %d responses",
+		stalls, worst.Seconds()*1000, total.Seconds()*1000, granted, len(conv.Responses))
+}
@@ -0,0 +1,81 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/service/dissector/smb"
+)
+
+// smbFrame is one SMB2 message in a NetBIOS session frame. Responses carry
+// the credits granted, requests a charge of one.
+func smbFrame(cmd uint16, id uint64, response bool, credits uint16, body int) string {
+	le := binary.LittleEndian
+	h := make([]byte, 64+body)
+	copy(h, "\xfeSMB")
+	le.PutUint16(h[4:], 64)
+	le.PutUint16(h[12:], cmd)
+	le.PutUint64(h[24:], id)
+	if response {
+		le.PutUint16(h[14:], credits)
+		le.PutUint32(h[16:], 1)
+	} else {
+		le.PutUint16(h[6:], 1)
+		le.PutUint16(h[14:], 1)
+	}
+	n := len(h)
+	return string(append([]byte{0, byte(n >> 16), byte(n >> 8), byte(n)}, h...))
+}
+
+func TestSMBCreditStarvation(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		credits uint16        // granted with each response
+		think   time.Duration // between a response and the next request
+		want    string
+	}{
+		{"starved", 1, ms,
+			"SMB Credit Starvation: client ran out of credits 2 time(s) and waited up to 150 ms (300 ms total) for the server to grant more; 4 credits granted over 4 responses"},
+		{"enough credits", 64, ms, ""},
+		{"client in no hurry", 1, 50 * ms, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConn(40000, 445).handshake(0)
+			c.send(true, 10*ms, smbFrame(smb.CmdNegotiate, 0, false, 0, 36))
+			c.send(false, 11*ms, smbFrame(smb.CmdNegotiate, 0, true, tt.credits, 64))
+			at := 11 * ms
+			for id := uint64(1); id <= 3; id++ {
+				at += tt.think
+				c.send(true, at, smbFrame(smb.CmdRead, id, false, 0, 48))
+				at += 150 * ms
+				c.send(false, at, smbFrame(smb.CmdRead, id, true, tt.credits, 16))
+			}
+			s := c.finish()
+			NewEngine().AnalyzeStream(s)
+
+			if s.Protocol != "SMB" || len(s.Transactions) != 4 {
+				t.Fatalf("stream = %s with %d transactions", s.Protocol, len(s.Transactions))
+			}
+			if got := findAnalysis(s, "SMB Credit Starvation"); got != tt.want {
+				t.Errorf("finding = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("negotiation not captured", func(t *testing.T) {
+		c := newConn(40000, 445).handshake(0)
+		for id := uint64(5); id <= 7; id++ {
+			at := time.Duration(id) * 200 * ms
+			c.send(true, at, smbFrame(smb.CmdRead, id, false, 0, 48))
+			c.send(false, at+150*ms, smbFrame(smb.CmdRead, id, true, 1, 16))
+		}
+		s := c.finish()
+		NewEngine().AnalyzeStream(s)
+		if hasAnalysis(s, "SMB Credit Starvation") {
+			t.Errorf("credits replayed without the negotiation: %q", s.Analysis)
+		}
+	})
+}
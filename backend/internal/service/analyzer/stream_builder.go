@@ -1,6 +1,7 @@
 package analyzer
 
 import (
+	"fmt"
 	"sync"
 
 	"pcap-analyzer/internal/domain"
@@ -10,12 +11,26 @@ import (
 // StreamBuilder handles the reconstruction of streams from packets
 type StreamBuilder struct {
 	streams map[string]*domain.Stream
+	closing map[string]*closeState
+	arp     []*domain.ARPPacket
 	mu      sync.RWMutex
+
+	// OnStreamClosed is called once per stream when it is torn down (RST or
+	// FIN from both sides). Later packets are still added to the stream.
+	// It runs with the builder lock held, so it must not call back into sb.
+	OnStreamClosed func(stream *domain.Stream)
+}
+
+type closeState struct {
+	clientFIN bool
+	serverFIN bool
+	notified  bool
 }
 
 func NewStreamBuilder() *StreamBuilder {
 	return &StreamBuilder{
 		streams: make(map[string]*domain.Stream),
+		closing: make(map[string]*closeState),
 	}
 }
 
@@ -26,6 +41,26 @@ func (sb *StreamBuilder) ProcessPacket(pkt pcap.PacketMeta) {
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
 
+	// ARP has no ports or connections; it is analyzed for the whole capture
+	if a := pkt.ARP; a != nil {
+		sb.arp = append(sb.arp, &domain.ARPPacket{
+			Timestamp: pkt.Timestamp,
+			Length:    pkt.Length,
+			Operation: a.Operation,
+			SrcMAC:    a.SrcMAC,
+			SenderMAC: a.SenderMAC,
+			SenderIP:  a.SenderIP,
+			TargetMAC: a.TargetMAC,
+			TargetIP:  a.TargetIP,
+		})
+		return
+	}
+
+	// ICMP errors belong to the flow they quote, not to a stream of their own
+	if sb.linkICMPError(pkt) {
+		return
+	}
+
 	stream, exists := sb.streams[streamID]
 	if !exists {
 		stream = &domain.Stream{
@@ -34,6 +69,7 @@ func (sb *StreamBuilder) ProcessPacket(pkt pcap.PacketMeta) {
 			ServerIP:   pkt.DstIP,
 			ClientPort: pkt.SrcPort,
 			ServerPort: pkt.DstPort,
+			Transport:  pkt.Transport,
 			Protocol:   pkt.Protocol,
 			Stats: domain.StreamStats{
 				StartTime: pkt.Timestamp,
@@ -43,17 +79,12 @@ func (sb *StreamBuilder) ProcessPacket(pkt pcap.PacketMeta) {
 		sb.streams[streamID] = stream
 	}
 
-	// Update Protocol if we detect a more specific one (e.g., TCP -> TLS)
-	if stream.Protocol == "TCP" && pkt.Protocol != "TCP" {
-		stream.Protocol = pkt.Protocol
-	}
-
 	// Capture MSS
-	if pkt.MSS > 0 {
+	if pkt.TCPOptions != nil && pkt.TCPOptions.MSS > 0 {
 		if pkt.SrcIP == stream.ClientIP {
-			stream.ClientMSS = pkt.MSS
+			stream.ClientMSS = pkt.TCPOptions.MSS
 		} else {
-			stream.ServerMSS = pkt.MSS
+			stream.ServerMSS = pkt.TCPOptions.MSS
 		}
 	}
 
@@ -65,16 +96,102 @@ func (sb *StreamBuilder) ProcessPacket(pkt pcap.PacketMeta) {
 	// Convert pcap.PacketMeta to domain.PacketMeta (lighter weight)
 	dPkt := &domain.PacketMeta{
 		Timestamp:  pkt.Timestamp,
+		Length:     pkt.Length,
 		SrcIP:      pkt.SrcIP,
 		DstIP:      pkt.DstIP,
+		SrcPort:    pkt.SrcPort,
+		DstPort:    pkt.DstPort,
 		Seq:        pkt.Seq,
 		Ack:        pkt.Ack,
 		Flags:      pkt.Flags,
 		PayloadLen: pkt.PayloadLen,
 		Payload:    pkt.Payload,
 		Window:     pkt.Window,
+		ECN:        pkt.ECN,
+		TTL:        pkt.TTL,
+		IPID:       pkt.IPID,
+		Options:    pkt.TCPOptions,
 	}
 	stream.Packets = append(stream.Packets, dPkt)
+
+	if pkt.ICMP != nil && pkt.ICMP.IsError() {
+		stream.ICMPEvents = append(stream.ICMPEvents, newICMPEvent(pkt))
+	}
+
+	sb.trackClose(stream, pkt)
+}
+
+// linkICMPError attaches an ICMP error to the TCP/UDP stream whose packet it
+// quotes. Errors about flows not in the capture are kept as ICMP streams.
+func (sb *StreamBuilder) linkICMPError(pkt pcap.PacketMeta) bool {
+	if pkt.ICMP == nil || pkt.ICMP.Original == nil || pkt.ICMP.Original.Transport == "" {
+		return false
+	}
+	orig := pkt.ICMP.Original
+	target, ok := sb.streams[domain.GenerateStreamID(orig.SrcIP, orig.DstIP, orig.SrcPort, orig.DstPort)]
+	if !ok || target.Transport != orig.Transport {
+		return false
+	}
+	target.ICMPEvents = append(target.ICMPEvents, newICMPEvent(pkt))
+	return true
+}
+
+func newICMPEvent(pkt pcap.PacketMeta) *domain.ICMPEvent {
+	m := pkt.ICMP
+	ev := &domain.ICMPEvent{
+		Timestamp: pkt.Timestamp,
+		Version:   m.Version,
+		Type:      m.Type,
+		Code:      m.Code,
+		Kind:      m.Kind,
+		Reporter:  pkt.SrcIP,
+		MTU:       m.MTU,
+		Gateway:   m.Gateway,
+	}
+	if o := m.Original; o != nil {
+		ev.Original = fmt.Sprintf("%s:%d > %s:%d/%s", o.SrcIP, o.SrcPort, o.DstIP, o.DstPort, o.Transport)
+	}
+	return ev
+}
+
+func (sb *StreamBuilder) trackClose(stream *domain.Stream, pkt pcap.PacketMeta) {
+	if sb.OnStreamClosed == nil {
+		return
+	}
+
+	state, ok := sb.closing[stream.ID]
+	if !ok {
+		state = &closeState{}
+		sb.closing[stream.ID] = state
+	}
+	if state.notified {
+		return
+	}
+
+	closed := false
+	for _, flag := range pkt.Flags {
+		switch flag {
+		case "RST":
+			closed = true
+		case "FIN":
+			if pkt.SrcIP == stream.ClientIP && pkt.SrcPort == stream.ClientPort {
+				state.clientFIN = true
+			} else {
+				state.serverFIN = true
+			}
+		}
+	}
+	if closed || (state.clientFIN && state.serverFIN) {
+		state.notified = true
+		sb.OnStreamClosed(stream)
+	}
+}
+
+// StreamCount returns the number of streams discovered so far
+func (sb *StreamBuilder) StreamCount() int {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return len(sb.streams)
 }
 
 // GetStreams returns all built streams
@@ -88,3 +205,10 @@ func (sb *StreamBuilder) GetStreams() []*domain.Stream {
 	}
 	return result
 }
+
+// ARPPackets returns the ARP packets seen, in capture order
+func (sb *StreamBuilder) ARPPackets() []*domain.ARPPacket {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.arp
+}
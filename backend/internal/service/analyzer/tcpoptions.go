@@ -0,0 +1,275 @@
+package analyzer
+
+import (
+	"strings"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// analyzeTCPOptions records the options both sides of a TCP stream
+// negotiated and checks how they are used afterwards: options one side did
+// not return or that vanish mid-stream, which is typical of middleboxes,
+// duplicate SACKs, timestamps going backwards (PAWS) and ECN signalling. It
+// runs after analyzeCongestion, which creates the TCP metrics.
+func analyzeTCPOptions(stream *domain.Stream) {
+	m := stream.Metrics.TCP
+	if stream.Transport != "TCP" || m == nil {
+		return
+	}
+	syn, synAck := handshakePackets(stream)
+	handshake := syn != nil && synAck != nil
+	if syn != nil {
+		m.ClientOptions = syn.Options
+	}
+	if synAck != nil {
+		m.ServerOptions = synAck.Options
+	}
+	if handshake {
+		checkNegotiation(stream, m.ClientOptions, m.ServerOptions)
+		m.ECN = checkECNSetup(stream, syn, synAck)
+	}
+	c, s := optionsOrEmpty(m.ClientOptions), optionsOrEmpty(m.ServerOptions)
+
+	for _, fromClient := range []bool{true, false} {
+		label, sender, receiver := "client→server", "client", "server"
+		if !fromClient {
+			label, sender, receiver = "server→client", "server", "client"
+		}
+		if handshake && c.HasTimestamps && s.HasTimestamps {
+			checkTimestampsKept(stream, fromClient, label)
+		}
+		sacks, dsacks := checkSACKs(stream, fromClient, sender, handshake && !(c.SACKPermitted && s.SACKPermitted))
+		m.SACKs += sacks
+		m.DSACKs += dsacks
+		checkPAWS(stream, fromClient, label)
+		m.CEMarks += checkECN(stream, fromClient, label, sender, receiver, m.ECN, handshake)
+	}
+}
+
+// handshakePackets returns the client's last SYN before the server's
+// SYN-ACK and that SYN-ACK, either nil when not captured
+func handshakePackets(stream *domain.Stream) (syn, synAck *domain.PacketMeta) {
+	for _, pkt := range stream.Packets {
+		if !hasFlag(pkt, "SYN") {
+			continue
+		}
+		switch fromClient := stream.FromClient(pkt); {
+		case fromClient && !hasFlag(pkt, "ACK") && synAck == nil:
+			syn = pkt
+		case !fromClient && hasFlag(pkt, "ACK") && synAck == nil:
+			synAck = pkt
+		}
+	}
+	return syn, synAck
+}
+
+func optionsOrEmpty(o *domain.TCPOptions) *domain.TCPOptions {
+	if o == nil {
+		return &domain.TCPOptions{}
+	}
+	return o
+}
+
+// negotiatedOptions names the options a SYN offers that both sides must
+// agree on
+func negotiatedOptions(o *domain.TCPOptions) map[string]bool {
+	return map[string]bool{"SACK": o.SACKPermitted, "window scaling": o.HasWindowScale, "timestamps": o.HasTimestamps}
+}
+
+// checkNegotiation compares the options of the SYN and the SYN-ACK. A
+// server may decline an option, but a middlebox stripping options looks
+// the same from one side; a SYN-ACK carrying options the SYN did not offer
+// means they were stripped on the way to the server, or its stack is broken.
+func checkNegotiation(stream *domain.Stream, client, server *domain.TCPOptions) {
+	offered, returned := negotiatedOptions(optionsOrEmpty(client)), negotiatedOptions(optionsOrEmpty(server))
+	var missing, extra []string
+	for _, name := range []string{"SACK", "window scaling", "timestamps"} {
+		if offered[name] && !returned[name] {
+			missing = append(missing, name)
+		}
+		if returned[name] && !offered[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(missing) > 0 {
+		// Without SACK or window scaling throughput suffers on lossy or
+		// long paths; timestamps are commonly turned off
+		severity := domain.SeverityNormal
+		if offered["SACK"] && !returned["SACK"] || offered["window scaling"] && !returned["window scaling"] {
+			severity = domain.SeverityWarning
+		}
+		raise(stream, severity, "Asymmetric Options: the server's SYN-ACK did not return %s offered in the client's SYN; the server declined or a middlebox stripped the options",
+			strings.Join(missing, ", "))
+	}
+	if len(extra) > 0 {
+		raise(stream, domain.SeverityWarning, "Asymmetric Options: the server's SYN-ACK carries %s the client's SYN did not offer; a middlebox may have stripped the options from the SYN on its way to the server",
+			strings.Join(extra, ", "))
+	}
+	if client != nil && client.FastOpen && (server == nil || !server.FastOpen) {
+		request := "cookie request"
+		if len(client.FastOpenCookie) > 0 {
+			request = "cookie"
+		}
+		raise(stream, domain.SeverityNormal, "Fast Open: the server's SYN-ACK carries no Fast Open option, so the client's %s was not honored", request)
+	}
+}
+
+// checkTimestampsKept counts segments after the handshake without the
+// timestamps both sides negotiated; RFC 7323 requires them on every
+// segment but resets
+func checkTimestampsKept(stream *domain.Stream, fromClient bool, label string) {
+	missing, total := 0, 0
+	for _, pkt := range stream.Packets {
+		if stream.FromClient(pkt) != fromClient || hasFlag(pkt, "SYN") || hasFlag(pkt, "RST") {
+			continue
+		}
+		total++
+		if pkt.Options == nil || !pkt.Options.HasTimestamps {
+			missing++
+		}
+	}
+	if missing > 0 {
+		raise(stream, domain.SeverityWarning, "Timestamps Missing: %d of %d %s segment(s) after the handshake carry no timestamp although both sides negotiated them; a middlebox may be stripping options",
+			missing, total, label)
+	}
+}
+
+// checkSACKs counts the segments one side sent with SACK blocks and the
+// D-SACKs among them: a first block below the cumulative acknowledgement or
+// inside the second block reports data received twice (RFC 2883)
+func checkSACKs(stream *domain.Stream, fromClient bool, sender string, unnegotiated bool) (sacks, dsacks int) {
+	var first *domain.PacketMeta
+	for _, pkt := range stream.Packets {
+		if stream.FromClient(pkt) != fromClient || pkt.Options == nil || len(pkt.Options.SACK) == 0 {
+			continue
+		}
+		sacks++
+		b := pkt.Options.SACK
+		if int32(b[0].Right-pkt.Ack) <= 0 || len(b) > 1 && int32(b[0].Left-b[1].Left) >= 0 && int32(b[1].Right-b[0].Right) >= 0 {
+			dsacks++
+			if first == nil {
+				first = pkt
+			}
+		}
+	}
+	if unnegotiated && sacks > 0 {
+		raise(stream, domain.SeverityWarning, "SACK Without Negotiation: the %s sent %d segment(s) with SACK blocks although SACK was not negotiated",
+			sender, sacks)
+	}
+	if dsacks > 0 {
+		raise(stream, domain.SeverityNormal, "D-SACK: the %s reported %d duplicate segment(s) received, first at %s; the peer retransmitted data that was not lost",
+			sender, dsacks, first.Timestamp.Format("15:04:05.000"))
+	}
+	return sacks, dsacks
+}
+
+// checkPAWS finds timestamps going backwards within one direction. The
+// receiver's protection against wrapped sequence numbers (PAWS, RFC 7323)
+// discards such segments as old duplicates.
+func checkPAWS(stream *domain.Stream, fromClient bool, label string) {
+	var last uint32
+	var example *domain.PacketMeta
+	var exampleLast uint32
+	seen, backwards := false, 0
+	for _, pkt := range stream.Packets {
+		if stream.FromClient(pkt) != fromClient || hasFlag(pkt, "RST") || pkt.Options == nil || !pkt.Options.HasTimestamps {
+			continue
+		}
+		ts := pkt.Options.TSval
+		if seen && int32(ts-last) < 0 {
+			backwards++
+			if example == nil {
+				example, exampleLast = pkt, last
+			}
+			continue
+		}
+		last, seen = ts, true
+	}
+	if backwards > 0 {
+		raise(stream, domain.SeverityWarning, "PAWS: %s timestamps went backwards %d time(s), first TSval %d after %d at %s; the receiver discards such segments as old duplicates",
+			label, backwards, example.Options.TSval, exampleLast, example.Timestamp.Format("15:04:05.000"))
+	}
+}
+
+// checkECNSetup reports whether the handshake negotiated ECN (RFC 3168:
+// ECE and CWR on the SYN, ECE alone on the SYN-ACK), flagging SYN-ACKs
+// that get it wrong
+func checkECNSetup(stream *domain.Stream, syn, synAck *domain.PacketMeta) bool {
+	requested := hasFlag(syn, "ECE") && hasFlag(syn, "CWR")
+	ece, cwr := hasFlag(synAck, "ECE"), hasFlag(synAck, "CWR")
+	switch {
+	case ece && cwr:
+		raise(stream, domain.SeverityWarning, "ECN Misbehavior: the server's SYN-ACK set both ECE and CWR, reflecting the SYN's flags instead of negotiating ECN")
+	case ece && !requested:
+		raise(stream, domain.SeverityWarning, "ECN Misbehavior: the server's SYN-ACK set ECE although the client's SYN did not ask for ECN")
+	case requested && ece:
+		return true
+	}
+	return false
+}
+
+// checkECN follows the ECN signalling of one sender's segments: ECT marks
+// on its data, Congestion Experienced marks the network set on them, the
+// receiver's ECE echoes and the sender's CWR answers. It returns the
+// number of CE marks.
+func checkECN(stream *domain.Stream, fromClient bool, label, sender, receiver string, negotiated, handshake bool) int {
+	data, ect, ce, flagged := 0, 0, 0, 0
+	echoed, answered, sentAfterEcho := false, false, false
+	for _, pkt := range stream.Packets {
+		if hasFlag(pkt, "SYN") {
+			continue
+		}
+		if stream.FromClient(pkt) != fromClient {
+			if ce > 0 && hasFlag(pkt, "ECE") {
+				echoed = true
+			}
+			continue
+		}
+		if hasFlag(pkt, "ECE") || hasFlag(pkt, "CWR") {
+			flagged++
+		}
+		if echoed && hasFlag(pkt, "CWR") {
+			answered = true
+		}
+		if pkt.ECN == domain.ECNCE {
+			ce++
+		}
+		if pkt.PayloadLen == 0 {
+			continue
+		}
+		data++
+		if pkt.ECN != domain.ECNNotECT {
+			ect++
+		}
+		if echoed {
+			sentAfterEcho = true
+		}
+	}
+
+	switch {
+	case !negotiated && handshake && ect > 0:
+		raise(stream, domain.SeverityWarning, "ECN Misbehavior: %s marked %d data segment(s) ECN-capable although ECN was not negotiated",
+			label, ect)
+	case !negotiated && handshake && flagged > 0:
+		raise(stream, domain.SeverityWarning, "ECN Misbehavior: the %s set ECE or CWR on %d segment(s) although ECN was not negotiated",
+			sender, flagged)
+	case negotiated && data > 0 && ect == 0:
+		raise(stream, domain.SeverityWarning, "ECN Misbehavior: %s sent %d data segment(s) without ECT marks although ECN was negotiated; a middlebox may be clearing the ECN field",
+			label, data)
+	}
+	if ce == 0 || !negotiated {
+		return ce
+	}
+	switch {
+	case !echoed:
+		raise(stream, domain.SeverityWarning, "ECN Misbehavior: the %s received %d segment(s) marked Congestion Experienced but never echoed ECE, so the %s did not slow down",
+			receiver, ce, sender)
+	case sentAfterEcho && !answered:
+		raise(stream, domain.SeverityWarning, "ECN Misbehavior: the %s never answered the %s's ECE with CWR, so it did not reduce its window",
+			sender, receiver)
+	default:
+		raise(stream, domain.SeverityNormal, "Congestion Experienced: the network marked %d %s segment(s) CE instead of dropping them, and the endpoints reacted",
+			ce, label)
+	}
+	return ce
+}
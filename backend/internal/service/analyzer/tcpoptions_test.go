@@ -0,0 +1,127 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// synOptions are the options of a Linux SYN
+func synOptions() *domain.TCPOptions {
+	return &domain.TCPOptions{MSS: 1460, SACKPermitted: true, HasWindowScale: true, WindowScale: 7, HasTimestamps: true, TSval: 100}
+}
+
+func stamped(tsval uint32) *domain.TCPOptions {
+	return &domain.TCPOptions{HasTimestamps: true, TSval: tsval}
+}
+
+// optionsExchange is a handshake with the given SYN and SYN-ACK options and
+// a request and response carrying timestamps
+func optionsExchange(syn, synAck *domain.TCPOptions) *conn {
+	ms := time.Millisecond
+	c := newConn(40000, 443)
+	c.send(true, 0, "", "SYN").Options = syn
+	c.send(false, ms, "", "SYN", "ACK").Options = synAck
+	c.send(true, 2*ms, "").Options = stamped(101)
+	c.send(true, 10*ms, "hello").Options = stamped(102)
+	c.send(false, 20*ms, "world").Options = stamped(501)
+	return c
+}
+
+func TestTCPOptions(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name          string
+		build         func() *conn
+		want          []string
+		severity      domain.Severity
+		sacks, dsacks int
+	}{
+		{"negotiated", func() *conn {
+			synAck := synOptions()
+			synAck.TSval = 500
+			return optionsExchange(synOptions(), synAck)
+		}, nil, domain.SeverityNormal, 0, 0},
+		{"options stripped from the SYN-ACK", func() *conn {
+			c := optionsExchange(synOptions(), &domain.TCPOptions{MSS: 1460, HasTimestamps: true, TSval: 500})
+			sack := c.send(true, 30*ms, "")
+			sack.Options = stamped(103)
+			sack.Options.SACK = []domain.SACKBlock{{Left: sack.Ack + 100, Right: sack.Ack + 200}}
+			return c
+		}, []string{
+			"Asymmetric Options: the server's SYN-ACK did not return SACK, window scaling offered in the client's SYN; the server declined or a middlebox stripped the options",
+			"SACK Without Negotiation: the client sent 1 segment(s) with SACK blocks although SACK was not negotiated",
+		}, domain.SeverityWarning, 1, 0},
+		{"options stripped from the SYN", func() *conn {
+			synAck := synOptions()
+			synAck.TSval = 500
+			return optionsExchange(&domain.TCPOptions{MSS: 1460, HasTimestamps: true, TSval: 100}, synAck)
+		}, []string{
+			"Asymmetric Options: the server's SYN-ACK carries SACK, window scaling the client's SYN did not offer; a middlebox may have stripped the options from the SYN on its way to the server",
+		}, domain.SeverityWarning, 0, 0},
+		{"timestamps stripped mid-stream", func() *conn {
+			synAck := synOptions()
+			synAck.TSval = 500
+			c := optionsExchange(synOptions(), synAck)
+			c.send(false, 30*ms, "again")
+			return c
+		}, []string{
+			"Timestamps Missing: 1 of 2 server→client segment(s) after the handshake carry no timestamp although both sides negotiated them; a middlebox may be stripping options",
+		}, domain.SeverityWarning, 0, 0},
+		{"D-SACK", func() *conn {
+			synAck := synOptions()
+			synAck.TSval = 500
+			c := optionsExchange(synOptions(), synAck)
+			sack := c.send(true, 30*ms, "")
+			sack.Options = stamped(103)
+			sack.Options.SACK = []domain.SACKBlock{{Left: sack.Ack + 100, Right: sack.Ack + 200}}
+			dup := c.send(true, 60*ms, "")
+			dup.Options = stamped(104)
+			dup.Options.SACK = []domain.SACKBlock{{Left: dup.Ack - 5, Right: dup.Ack}}
+			return c
+		}, []string{
+			"D-SACK: the client reported 1 duplicate segment(s) received, first at 00:00:00.060; the peer retransmitted data that was not lost",
+		}, domain.SeverityNormal, 2, 1},
+		{"PAWS", func() *conn {
+			synAck := synOptions()
+			synAck.TSval = 500
+			c := optionsExchange(synOptions(), synAck)
+			c.send(false, 30*ms, "again").Options = stamped(400)
+			c.send(false, 40*ms, "more").Options = stamped(502)
+			return c
+		}, []string{
+			"PAWS: server→client timestamps went backwards 1 time(s), first TSval 400 after 501 at 00:00:00.030; the receiver discards such segments as old duplicates",
+		}, domain.SeverityWarning, 0, 0},
+	}
+	findings := []string{"Asymmetric Options", "SACK Without Negotiation", "Timestamps Missing", "D-SACK", "PAWS"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.build().finish()
+			NewEngine().AnalyzeStream(s)
+
+			var got []string
+			for _, a := range s.Analysis {
+				for _, prefix := range findings {
+					if strings.HasPrefix(a, prefix+":") {
+						got = append(got, a)
+					}
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("findings = %q, want %q", got, tt.want)
+			}
+			if s.Severity != tt.severity {
+				t.Errorf("severity = %s, want %s", s.Severity, tt.severity)
+			}
+			m := s.Metrics.TCP
+			if m.ClientOptions == nil || m.ServerOptions == nil || m.ClientOptions.MSS != 1460 {
+				t.Errorf("negotiated options = %v and %v", m.ClientOptions, m.ServerOptions)
+			}
+			if m.SACKs != tt.sacks || m.DSACKs != tt.dsacks {
+				t.Errorf("SACKs = %d, D-SACKs = %d, want %d and %d", m.SACKs, m.DSACKs, tt.sacks, tt.dsacks)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Thresholds are the tunable limits used by the detectors. A profile file
+// only needs the keys it changes; the rest keep their defaults.
+type Thresholds struct {
+	LowMSS                   uint16  `json:"low_mss" yaml:"low_mss"`
+	RetransRatePercent       float64 `json:"retransmission_rate_percent" yaml:"retransmission_rate_percent"`
+	DillonMinRetransmissions int     `json:"dillon_min_retransmissions" yaml:"dillon_min_retransmissions"`
+
+	IdleTimeoutMinSeconds       float64 `json:"idle_timeout_min_seconds" yaml:"idle_timeout_min_seconds"`
+	IdleTimeoutMinStreams       int     `json:"idle_timeout_min_streams" yaml:"idle_timeout_min_streams"`
+	IdleTimeoutTolerancePercent float64 `json:"idle_timeout_tolerance_percent" yaml:"idle_timeout_tolerance_percent"`
+
+	HTTPSlowResponseSeconds float64 `json:"http_slow_response_seconds" yaml:"http_slow_response_seconds"`
+	HTTP5xxBurstCount       int     `json:"http_5xx_burst_count" yaml:"http_5xx_burst_count"`
+	HTTP5xxBurstWindowSecs  float64 `json:"http_5xx_burst_window_seconds" yaml:"http_5xx_burst_window_seconds"`
+	HTTP2WindowStallSeconds float64 `json:"http2_window_stall_seconds" yaml:"http2_window_stall_seconds"`
+
+	TLSHelloResetSeconds float64 `json:"tls_hello_reset_seconds" yaml:"tls_hello_reset_seconds"`
+
+	DNSSlowResponseSeconds  float64 `json:"dns_slow_response_seconds" yaml:"dns_slow_response_seconds"`
+	DNSErrorStormCount      int     `json:"dns_error_storm_count" yaml:"dns_error_storm_count"`
+	DNSErrorStormWindowSecs float64 `json:"dns_error_storm_window_seconds" yaml:"dns_error_storm_window_seconds"`
+
+	DBSlowQuerySeconds float64 `json:"db_slow_query_seconds" yaml:"db_slow_query_seconds"`
+	DBTopStatements    int     `json:"db_top_statements" yaml:"db_top_statements"`
+
+	FileSlowOpSeconds float64 `json:"file_slow_op_seconds" yaml:"file_slow_op_seconds"`
+	FileTopFiles      int     `json:"file_top_files" yaml:"file_top_files"`
+	SMBCreditStallMs  float64 `json:"smb_credit_stall_ms" yaml:"smb_credit_stall_ms"`
+
+	KafkaSlowRequestSeconds float64 `json:"kafka_slow_request_seconds" yaml:"kafka_slow_request_seconds"`
+	MQTTSlowAckSeconds      float64 `json:"mqtt_slow_ack_seconds" yaml:"mqtt_slow_ack_seconds"`
+
+	ARPStormPacketsPerSec    int     `json:"arp_storm_packets_per_second" yaml:"arp_storm_packets_per_second"`
+	ARPGratuitousCount       int     `json:"arp_gratuitous_count" yaml:"arp_gratuitous_count"`
+	ARPGratuitousWindowSecs  float64 `json:"arp_gratuitous_window_seconds" yaml:"arp_gratuitous_window_seconds"`
+	ARPUnansweredMinRequests int     `json:"arp_unanswered_min_requests" yaml:"arp_unanswered_min_requests"`
+	DHCPSlowSeconds          float64 `json:"dhcp_slow_seconds" yaml:"dhcp_slow_seconds"`
+
+	CwndCollapseRatio     float64 `json:"cwnd_collapse_ratio" yaml:"cwnd_collapse_ratio"`
+	AppLimitedIdleSeconds float64 `json:"app_limited_idle_seconds" yaml:"app_limited_idle_seconds"`
+	AppLimitedPercent     float64 `json:"app_limited_percent" yaml:"app_limited_percent"`
+
+	UDPUnidirectionalMinPackets int     `json:"udp_unidirectional_min_packets" yaml:"udp_unidirectional_min_packets"`
+	UDPLossPercent              float64 `json:"udp_loss_percent" yaml:"udp_loss_percent"`
+	UDPJitterMs                 float64 `json:"udp_jitter_ms" yaml:"udp_jitter_ms"`
+	UDPBurstinessRatio          float64 `json:"udp_burstiness_ratio" yaml:"udp_burstiness_ratio"`
+
+	RTPJitterMs float64 `json:"rtp_jitter_ms" yaml:"rtp_jitter_ms"`
+	RTPMinMOS   float64 `json:"rtp_min_mos" yaml:"rtp_min_mos"`
+}
+
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		LowMSS:                   1260,
+		RetransRatePercent:       5.0,
+		DillonMinRetransmissions: 5,
+
+		IdleTimeoutMinSeconds:       3,
+		IdleTimeoutMinStreams:       3,
+		IdleTimeoutTolerancePercent: 5,
+
+		HTTPSlowResponseSeconds: 1.0,
+		HTTP5xxBurstCount:       3,
+		HTTP5xxBurstWindowSecs:  10,
+		HTTP2WindowStallSeconds: 0.2,
+
+		TLSHelloResetSeconds: 1.0,
+
+		DNSSlowResponseSeconds:  0.5,
+		DNSErrorStormCount:      10,
+		DNSErrorStormWindowSecs: 10,
+
+		DBSlowQuerySeconds: 1.0,
+		DBTopStatements:    5,
+
+		FileSlowOpSeconds: 0.5,
+		FileTopFiles:      5,
+		SMBCreditStallMs:  100,
+
+		KafkaSlowRequestSeconds: 1.0,
+		MQTTSlowAckSeconds:      1.0,
+
+		ARPStormPacketsPerSec:    50,
+		ARPGratuitousCount:       10,
+		ARPGratuitousWindowSecs:  10,
+		ARPUnansweredMinRequests: 3,
+		DHCPSlowSeconds:          1.0,
+
+		CwndCollapseRatio:     0.25,
+		AppLimitedIdleSeconds: 0.2,
+		AppLimitedPercent:     20,
+
+		UDPUnidirectionalMinPackets: 3,
+		UDPLossPercent:              1.0,
+		UDPJitterMs:                 30,
+		UDPBurstinessRatio:          10,
+
+		RTPJitterMs: 30,
+		RTPMinMOS:   3.6,
+	}
+}
+
+// LoadThresholds reads a JSON or YAML profile over the defaults
+func LoadThresholds(path string) (Thresholds, error) {
+	t := DefaultThresholds()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return t, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &t)
+	default:
+		err = json.Unmarshal(data, &t)
+	}
+	if err != nil {
+		return t, fmt.Errorf("parsing thresholds profile %s: %v", path, err)
+	}
+	return t, t.Validate()
+}
+
+func (t Thresholds) Validate() error {
+	if t.RetransRatePercent <= 0 || t.RetransRatePercent > 100 {
+		return fmt.Errorf("retransmission_rate_percent must be in (0, 100], got %v", t.RetransRatePercent)
+	}
+	if t.IdleTimeoutMinSeconds <= 0 {
+		return fmt.Errorf("idle_timeout_min_seconds must be positive, got %v", t.IdleTimeoutMinSeconds)
+	}
+	if t.IdleTimeoutMinStreams < 2 {
+		return fmt.Errorf("idle_timeout_min_streams must be at least 2, got %v", t.IdleTimeoutMinStreams)
+	}
+	if t.IdleTimeoutTolerancePercent <= 0 || t.IdleTimeoutTolerancePercent > 50 {
+		return fmt.Errorf("idle_timeout_tolerance_percent must be in (0, 50], got %v", t.IdleTimeoutTolerancePercent)
+	}
+	if t.CwndCollapseRatio <= 0 || t.CwndCollapseRatio >= 1 {
+		return fmt.Errorf("cwnd_collapse_ratio must be in (0, 1), got %v", t.CwndCollapseRatio)
+	}
+	return nil
+}
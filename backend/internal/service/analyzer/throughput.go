@@ -0,0 +1,215 @@
+package analyzer
+
+import (
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// throughputWindow is the window peak throughput is measured over
+const throughputWindow = time.Second
+
+// measureThroughput fills the per-direction byte counts, throughput and
+// bytes in flight of a stream. It runs after detectRetransmissions, which
+// marks the retransmitted segments.
+func measureThroughput(stream *domain.Stream) {
+	var fwd, rev []*domain.PacketMeta
+	for _, pkt := range stream.Packets {
+		if stream.FromClient(pkt) {
+			fwd = append(fwd, pkt)
+		} else {
+			rev = append(rev, pkt)
+		}
+	}
+	stream.Stats.ClientToServer = directionStats(fwd, stream.Stats.Duration)
+	stream.Stats.ServerToClient = directionStats(rev, stream.Stats.Duration)
+	if stream.Transport == "TCP" {
+		setBytesInFlight(&stream.Stats.ClientToServer, flightSamples(stream, true))
+		setBytesInFlight(&stream.Stats.ServerToClient, flightSamples(stream, false))
+	}
+}
+
+func directionStats(pkts []*domain.PacketMeta, duration time.Duration) domain.DirectionStats {
+	d := domain.DirectionStats{Packets: len(pkts)}
+	for _, pkt := range pkts {
+		d.WireBytes += int64(pkt.Length)
+		d.PayloadBytes += int64(pkt.PayloadLen)
+		if pkt.IsRetrans {
+			d.RetransmittedBytes += int64(pkt.PayloadLen)
+		}
+	}
+	d.GoodputBytes = d.PayloadBytes - d.RetransmittedBytes
+	if duration <= 0 || d.PayloadBytes == 0 {
+		return d
+	}
+	// The peak is never below the average, which streams spanning less
+	// than two windows can exceed
+	d.AvgThroughputBps = float64(d.PayloadBytes*8) / duration.Seconds()
+	d.PeakThroughputBps = d.AvgThroughputBps
+	if duration < throughputWindow {
+		return d
+	}
+
+	// Busiest window starting at a packet
+	var inWindow int64
+	first := 0
+	for _, pkt := range pkts {
+		inWindow += int64(pkt.PayloadLen)
+		for pkt.Timestamp.Sub(pkts[first].Timestamp) >= throughputWindow {
+			inWindow -= int64(pkts[first].PayloadLen)
+			first++
+		}
+		if bps := float64(inWindow*8) / throughputWindow.Seconds(); bps > d.PeakThroughputBps {
+			d.PeakThroughputBps = bps
+		}
+	}
+	return d
+}
+
+// flightSample is one data segment a TCP sender sent, with what it had
+// in flight and what it knew of the peer at the time
+type flightSample struct {
+	pkt      *domain.PacketMeta
+	bytes    int64         // sent and not yet acknowledged, this segment included
+	acked    uint32        // highest acknowledgement from the peer
+	window   int64         // peer's last advertised window, scaled
+	dupAcks  int           // duplicate acknowledgements since the last one that advanced
+	idle     time.Duration // time since the peer acknowledged everything, 0 if data was outstanding
+	peerSent bool          // the peer sent data while everything was acknowledged
+}
+
+// flightSamples estimates, at each data segment one side of a TCP stream
+// sends, how much of its data the peer has not acknowledged: the highest
+// sequence number sent minus the highest acknowledgement seen from the peer.
+// Segments sent before the capture saw the peer acknowledge are skipped.
+func flightSamples(stream *domain.Stream, fromClient bool) []flightSample {
+	clientShift, serverShift := windowShifts(stream)
+	peerShift := serverShift
+	if !fromClient {
+		peerShift = clientShift
+	}
+	var samples []flightSample
+	var next, acked uint32
+	var window int64
+	var drained time.Time
+	sending, acking, peerSent := false, false, false
+	dupAcks := 0
+	for _, pkt := range stream.Packets {
+		if stream.FromClient(pkt) != fromClient {
+			if !hasFlag(pkt, "ACK") {
+				continue
+			}
+			window = int64(pkt.Window)
+			if !hasFlag(pkt, "SYN") {
+				window <<= peerShift
+			}
+			switch {
+			case !acking || int32(pkt.Ack-acked) > 0:
+				acked, acking = pkt.Ack, true
+				dupAcks = 0
+				if sending && int32(acked-next) >= 0 && drained.IsZero() {
+					drained = pkt.Timestamp
+				}
+			case pkt.Ack == acked && pkt.PayloadLen == 0 && !hasFlag(pkt, "SYN") && !hasFlag(pkt, "FIN"):
+				dupAcks++
+			}
+			if pkt.PayloadLen > 0 && !drained.IsZero() {
+				peerSent = true
+			}
+			continue
+		}
+		end := pkt.Seq + uint32(pkt.PayloadLen)
+		if hasFlag(pkt, "SYN") || hasFlag(pkt, "FIN") {
+			end++
+		}
+		if !sending || int32(end-next) > 0 {
+			next, sending = end, true
+		}
+		if pkt.PayloadLen == 0 || !acking {
+			continue
+		}
+		flight := int64(int32(next - acked))
+		if flight < 0 {
+			flight = 0
+		}
+		sample := flightSample{pkt: pkt, bytes: flight, acked: acked, window: window, dupAcks: dupAcks}
+		if !drained.IsZero() {
+			sample.idle, sample.peerSent = pkt.Timestamp.Sub(drained), peerSent
+			drained, peerSent = time.Time{}, false
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// windowShifts returns the window scale each side announced in its SYN,
+// both zero unless both sides did (RFC 7323 scales no window otherwise)
+func windowShifts(stream *domain.Stream) (client, server uint8) {
+	var clientSet, serverSet bool
+	for _, pkt := range stream.Packets {
+		if !hasFlag(pkt, "SYN") || pkt.Options == nil || !pkt.Options.HasWindowScale {
+			continue
+		}
+		// Shifts above 14 are treated as 14
+		shift := min(pkt.Options.WindowScale, 14)
+		if stream.FromClient(pkt) {
+			client, clientSet = shift, true
+		} else {
+			server, serverSet = shift, true
+		}
+	}
+	if !clientSet || !serverSet {
+		return 0, 0
+	}
+	return client, server
+}
+
+func setBytesInFlight(d *domain.DirectionStats, samples []flightSample) {
+	if len(samples) == 0 {
+		return
+	}
+	var sum int64
+	for _, s := range samples {
+		sum += s.bytes
+		if s.bytes > d.MaxBytesInFlight {
+			d.MaxBytesInFlight = s.bytes
+		}
+	}
+	d.AvgBytesInFlight = float64(sum) / float64(len(samples))
+}
+
+// FlightSeries returns the data segments one side of a TCP stream sent,
+// with the peer's acknowledgements and window and the bytes in flight at
+// each, for time-sequence and tcptrace graphs
+func FlightSeries(stream *domain.Stream, fromClient bool) []*domain.FlightPoint {
+	var base uint32
+	for _, pkt := range stream.Packets {
+		if stream.FromClient(pkt) == fromClient {
+			base = pkt.Seq
+			if hasFlag(pkt, "SYN") {
+				base++
+			}
+			break
+		}
+	}
+
+	samples := flightSamples(stream, fromClient)
+	points := make([]*domain.FlightPoint, len(samples))
+	var highest uint32
+	for i, s := range samples {
+		end := s.pkt.Seq + uint32(s.pkt.PayloadLen)
+		points[i] = &domain.FlightPoint{
+			Time:           s.pkt.Timestamp,
+			Seq:            int64(int32(s.pkt.Seq - base)),
+			Length:         s.pkt.PayloadLen,
+			Acked:          int64(int32(s.acked - base)),
+			Window:         int(s.window),
+			BytesInFlight:  s.bytes,
+			Retransmission: i > 0 && int32(end-highest) <= 0,
+		}
+		if i == 0 || int32(end-highest) > 0 {
+			highest = end
+		}
+	}
+	return points
+}
@@ -0,0 +1,87 @@
+package analyzer
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+func TestThroughputGoodput(t *testing.T) {
+	ms := time.Millisecond
+	segment := strings.Repeat("x", 1000)
+
+	c := newConn(40000, 8080).handshake(0)
+	c.send(true, 10*ms, strings.Repeat("q", 100))
+	d1 := c.send(false, 20*ms, segment)
+	d2 := c.send(false, 30*ms, segment)
+	c.send(false, 40*ms, segment)
+	// The client acknowledges the first segment only, so the server
+	// retransmits the second
+	ack := c.send(true, 50*ms, "")
+	ack.Ack = d1.Seq + 1000
+	c.resend(d2, 250*ms)
+	c.send(true, 260*ms, "")
+	s := c.finish()
+	NewEngine().AnalyzeStream(s)
+
+	up, down := s.Stats.ClientToServer, s.Stats.ServerToClient
+	if up.Packets != 5 || up.PayloadBytes != 100 || up.GoodputBytes != 100 || up.RetransmittedBytes != 0 ||
+		up.WireBytes != 5*54+100 || up.MaxBytesInFlight != 100 {
+		t.Errorf("client to server = %+v", up)
+	}
+	if down.Packets != 5 || down.PayloadBytes != 4000 || down.GoodputBytes != 3000 || down.RetransmittedBytes != 1000 ||
+		down.WireBytes != 5*54+4000 {
+		t.Errorf("server to client = %+v", down)
+	}
+	// 1000, 2000 and 3000 bytes in flight, then 2000 after the partial
+	// acknowledgement
+	if down.MaxBytesInFlight != 3000 || down.AvgBytesInFlight != 2000 {
+		t.Errorf("bytes in flight = max %d, avg %.0f, want 3000 and 2000", down.MaxBytesInFlight, down.AvgBytesInFlight)
+	}
+	// Shorter than a window: the peak is the average, retransmissions included
+	want := 4000 * 8 / 0.26
+	if math.Abs(down.AvgThroughputBps-want) > 1 || down.PeakThroughputBps != down.AvgThroughputBps {
+		t.Errorf("throughput = avg %.0f, peak %.0f, want %.0f", down.AvgThroughputBps, down.PeakThroughputBps, want)
+	}
+	if got := s.Stats.GoodputRatio(); math.Abs(got-3100.0/4100) > 1e-9 {
+		t.Errorf("goodput ratio = %.4f", got)
+	}
+}
+
+func TestPeakThroughput(t *testing.T) {
+	ms := time.Millisecond
+	segment := strings.Repeat("x", 1000)
+	c := newConn(40000, 8080).handshake(0)
+	for i := 1; i <= 5; i++ {
+		c.send(false, time.Duration(i)*100*ms, segment)
+	}
+	c.send(false, 2900*ms, segment)
+	c.send(true, 3000*ms, "")
+	s := c.finish()
+	NewEngine().AnalyzeStream(s)
+
+	down := s.Stats.ServerToClient
+	if down.AvgThroughputBps != 6000*8/3.0 || down.PeakThroughputBps != 5000*8 {
+		t.Errorf("throughput = avg %.0f, peak %.0f, want 16000 and 40000", down.AvgThroughputBps, down.PeakThroughputBps)
+	}
+	if down.RetransmittedBytes != 0 || down.GoodputBytes != 6000 {
+		t.Errorf("server to client = %+v", down)
+	}
+}
+
+func TestThroughputUDP(t *testing.T) {
+	c := newDatagrams(40000, 9999)
+	c.send(true, 0, strings.Repeat("x", 500))
+	c.send(true, 500*time.Millisecond, strings.Repeat("x", 500))
+	s := c.finish()
+	NewEngine().AnalyzeStream(s)
+
+	want := domain.DirectionStats{Packets: 2, WireBytes: 2 * 542, PayloadBytes: 1000, GoodputBytes: 1000,
+		AvgThroughputBps: 16000, PeakThroughputBps: 16000}
+	if s.Stats.ClientToServer != want {
+		t.Errorf("client to server = %+v, want %+v", s.Stats.ClientToServer, want)
+	}
+}
@@ -0,0 +1,260 @@
+package analyzer
+
+import (
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	tlsdissect "pcap-analyzer/internal/service/dissector/tls"
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// dissectTLS decodes the cleartext TLS handshake and records it as a "TLS"
+// transaction carrying SNI, versions, cipher, fingerprints and certificate
+// details. It reports handshake failures, certificate problems, version
+// mismatches and connections reset right after the ClientHello.
+func (e *Engine) dissectTLS(sc *streamContext) {
+	stream := sc.stream
+	if stream.Transport != "TCP" {
+		return
+	}
+	if !claimable(stream, "TLS") {
+		return
+	}
+
+	client, server := sc.flows()
+	if !tlsdissect.LooksLikeTLS(client.Data) && !tlsdissect.LooksLikeTLS(server.Data) {
+		return
+	}
+	cs := tlsdissect.ParseSide(client.Data)
+	ss := tlsdissect.ParseSide(server.Data)
+	ch, sh := cs.ClientHello, ss.ServerHello
+	if ch == nil && sh == nil {
+		return
+	}
+	confirm(stream, "TLS")
+
+	tx := &domain.Transaction{
+		Protocol:   "TLS",
+		Method:     "handshake",
+		Attributes: map[string]string{},
+	}
+	if ch != nil {
+		tx.Target = ch.ServerName
+		tx.Host = ch.ServerName
+		tx.RequestTime = client.TimeAt(cs.HelloOffset)
+
+		ja3, ja3Hash := tlsdissect.JA3(ch)
+		tx.Attributes["sni"] = ch.ServerName
+		tx.Attributes["offered_version"] = tlsdissect.VersionName(ch.MaxVersion())
+		tx.Attributes["offered_alpn"] = strings.Join(ch.ALPN, ",")
+		tx.Attributes["ja3"] = ja3
+		tx.Attributes["ja3_hash"] = ja3Hash
+		tx.Attributes["ja4"] = tlsdissect.JA4(ch, false)
+	}
+	if sh != nil {
+		tx.ResponseTime = server.TimeAt(ss.HelloOffset)
+		tx.StatusText = tlsdissect.VersionName(sh.SelectedVersion)
+		if ch != nil {
+			if latency := tx.ResponseTime.Sub(tx.RequestTime); latency > 0 {
+				tx.Latency = latency
+			}
+		}
+
+		tx.Attributes["version"] = tlsdissect.VersionName(sh.SelectedVersion)
+		tx.Attributes["cipher"] = tlsdissect.CipherName(sh.CipherSuite)
+		if sh.ALPN != "" {
+			tx.Attributes["alpn"] = sh.ALPN
+		}
+	}
+
+	// Decrypt first: TLS 1.3 hides the certificate and alerts
+	name := tx.Target
+	if name == "" {
+		name = stream.ServerIP
+	}
+	sess := e.decryptTLS(stream, tx, name, ch, sh, client, server)
+
+	certs := ss.Certificates
+	if len(certs) == 0 && sess != nil {
+		certs = sess.Certificates
+	}
+	var leaf *x509.Certificate
+	if len(certs) > 0 {
+		if cert, err := x509.ParseCertificate(certs[0]); err == nil {
+			leaf = cert
+			tx.Attributes["cert_subject"] = cert.Subject.String()
+			tx.Attributes["cert_issuer"] = cert.Issuer.String()
+			tx.Attributes["cert_not_before"] = cert.NotBefore.UTC().Format(time.RFC3339)
+			tx.Attributes["cert_not_after"] = cert.NotAfter.UTC().Format(time.RFC3339)
+			if len(cert.DNSNames) > 0 {
+				tx.Attributes["cert_dns_names"] = strings.Join(cert.DNSNames, ",")
+			}
+		}
+	}
+
+	// The first fatal alert in the clear decides the outcome
+	alert, alertFromServer := firstFatalAlert(cs, ss)
+	if alert == nil && sess != nil {
+		alert, alertFromServer = firstFatalAlert(&tlsdissect.Side{Alerts: sess.Client.Alerts}, &tlsdissect.Side{Alerts: sess.Server.Alerts})
+	}
+	if alert != nil {
+		tx.Status = int(alert.Description)
+		tx.Attributes["alert"] = tlsdissect.AlertName(alert.Description)
+		sender := "client"
+		if alertFromServer {
+			sender = "server"
+		}
+		tx.Attributes["alert_sender"] = sender
+		tx.Error = sender + " sent fatal alert " + tlsdissect.AlertName(alert.Description)
+	}
+	stream.Transactions = append(stream.Transactions, tx)
+	if sess != nil {
+		e.dissectDecrypted(stream, sess, client, server)
+	}
+
+	e.detectTLSAlert(stream, name, ch, alert, alertFromServer)
+	e.detectTLSVersionMismatch(stream, name, ch, sh)
+	if leaf != nil {
+		e.detectTLSCertificate(stream, name, leaf, len(certs))
+	}
+	if ch != nil && sh == nil && alert == nil {
+		e.detectTLSHelloReset(stream, name, tx.RequestTime, server)
+	}
+}
+
+func firstFatalAlert(cs, ss *tlsdissect.Side) (*tlsdissect.Alert, bool) {
+	for i := range ss.Alerts {
+		if a := &ss.Alerts[i]; !a.Encrypted && a.Level == tlsdissect.AlertLevelFatal {
+			return a, true
+		}
+	}
+	for i := range cs.Alerts {
+		if a := &cs.Alerts[i]; !a.Encrypted && a.Level == tlsdissect.AlertLevelFatal {
+			return a, false
+		}
+	}
+	return nil, false
+}
+
+func (e *Engine) detectTLSAlert(stream *domain.Stream, name string, ch *tlsdissect.ClientHello,
+	alert *tlsdissect.Alert, fromServer bool) {
+	if alert == nil {
+		return
+	}
+	desc := tlsdissect.AlertName(alert.Description)
+
+	switch {
+	case alert.Description == tlsdissect.AlertProtocolVersion && ch != nil:
+		raise(stream, domain.SeverityCritical, "TLS Version Mismatch: %s rejected the handshake with protocol_version (client offered up to %s) for %s",
+			peerName(fromServer), tlsdissect.VersionName(ch.MaxVersion()), name)
+	case !fromServer && alert.Description >= tlsdissect.AlertBadCertificate && alert.Description <= tlsdissect.AlertUnknownCA:
+		raise(stream, domain.SeverityCritical, "TLS Certificate Rejected: client sent %s (%d) for %s",
+			desc, alert.Description, name)
+	default:
+		raise(stream, domain.SeverityCritical, "TLS Handshake Failure: %s sent fatal alert %s (%d) for %s",
+			peerName(fromServer), desc, alert.Description, name)
+	}
+}
+
+func (e *Engine) detectTLSVersionMismatch(stream *domain.Stream, name string, ch *tlsdissect.ClientHello, sh *tlsdissect.ServerHello) {
+	if ch == nil || sh == nil {
+		return
+	}
+
+	offered := ch.SupportedVersions
+	if len(offered) == 0 {
+		// Pre-1.3 clients accept anything up to their legacy version
+		if sh.SelectedVersion > ch.Version {
+			raise(stream, domain.SeverityCritical, "TLS Version Mismatch: server selected %s but client only offered up to %s for %s",
+				tlsdissect.VersionName(sh.SelectedVersion), tlsdissect.VersionName(ch.Version), name)
+		}
+	} else if !containsVersion(offered, sh.SelectedVersion) {
+		raise(stream, domain.SeverityCritical, "TLS Version Mismatch: server selected %s which the client did not offer for %s",
+			tlsdissect.VersionName(sh.SelectedVersion), name)
+		return
+	}
+
+	if sh.SelectedVersion < 0x0303 && ch.MaxVersion() >= 0x0303 {
+		raise(stream, domain.SeverityWarning, "TLS Version Downgrade: client offered up to %s, server negotiated %s for %s",
+			tlsdissect.VersionName(ch.MaxVersion()), tlsdissect.VersionName(sh.SelectedVersion), name)
+	}
+}
+
+func (e *Engine) detectTLSCertificate(stream *domain.Stream, name string, cert *x509.Certificate, chainLen int) {
+	// Validity is judged against the capture time, not the analysis time
+	at := stream.Stats.StartTime
+	if at.IsZero() {
+		at = time.Now()
+	}
+	subject := cert.Subject.CommonName
+	if subject == "" {
+		subject = cert.Subject.String()
+	}
+
+	if at.After(cert.NotAfter) {
+		raise(stream, domain.SeverityCritical, "Expired TLS Certificate: %q expired %s (%.0f days before capture) for %s",
+			subject, cert.NotAfter.UTC().Format("2006-01-02"), at.Sub(cert.NotAfter).Hours()/24, name)
+	} else if at.Before(cert.NotBefore) {
+		raise(stream, domain.SeverityCritical, "TLS Certificate Not Yet Valid: %q valid from %s for %s",
+			subject, cert.NotBefore.UTC().Format("2006-01-02"), name)
+	}
+
+	if chainLen == 1 && isSelfSigned(cert) {
+		raise(stream, domain.SeverityWarning, "Self-Signed TLS Certificate: %q presented by %s", subject, name)
+	}
+}
+
+// isSelfSigned reports whether the certificate is its own issuer
+func isSelfSigned(cert *x509.Certificate) bool {
+	if cert.Subject.String() != cert.Issuer.String() {
+		return false
+	}
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// detectTLSHelloReset flags a reset arriving shortly after the ClientHello
+// with no server handshake, the usual signature of an SNI-filtering middlebox.
+func (e *Engine) detectTLSHelloReset(stream *domain.Stream, name string, helloTime time.Time, server *reassembly.Flow) {
+	if helloTime.IsZero() || len(server.Data) > 0 {
+		return
+	}
+	window := time.Duration(e.thresholds.TLSHelloResetSeconds * float64(time.Second))
+
+	for _, pkt := range stream.Packets {
+		if pkt.Timestamp.Before(helloTime) || !hasFlag(pkt, "RST") {
+			continue
+		}
+		if delay := pkt.Timestamp.Sub(helloTime); delay <= window {
+			raise(stream, domain.SeverityCritical, "TLS Reset After ClientHello: connection reset %.0fms after the hello for %s with no server response (typical of a middlebox block)",
+				float64(delay)/float64(time.Millisecond), name)
+		}
+		return
+	}
+}
+
+func hasFlag(pkt *domain.PacketMeta, flag string) bool {
+	for _, f := range pkt.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func containsVersion(list []uint16, v uint16) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func peerName(server bool) string {
+	if server {
+		return "server"
+	}
+	return "client"
+}
@@ -0,0 +1,95 @@
+package analyzer
+
+import (
+	"errors"
+	"strconv"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/http1"
+	"pcap-analyzer/internal/service/dissector/http2"
+	tlsdissect "pcap-analyzer/internal/service/dissector/tls"
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// decryptTLS decrypts the session with the engine's key log and reports
+// the outcome on the stream and in the transaction's "decryption"
+// attribute. It does nothing when no keys were supplied for the capture.
+func (e *Engine) decryptTLS(stream *domain.Stream, tx *domain.Transaction, name string,
+	ch *tlsdissect.ClientHello, sh *tlsdissect.ServerHello, client, server *reassembly.Flow) *tlsdissect.Session {
+	if e.keys.Len() == 0 {
+		return nil
+	}
+
+	sess, err := tlsdissect.Decrypt(ch, sh, client.Data, server.Data, e.keys)
+	if err != nil {
+		tx.Attributes["decryption"] = err.Error()
+		switch {
+		case errors.Is(err, tlsdissect.ErrUnsupportedCipher):
+			raise(stream, domain.SeverityNormal, "TLS Not Decrypted: %s is not supported for decryption for %s",
+				tlsdissect.CipherName(sh.CipherSuite), name)
+		default:
+			raise(stream, domain.SeverityNormal, "TLS Not Decrypted: %s for %s", err, name)
+		}
+		return nil
+	}
+
+	opened := sess.Client.Decrypted + sess.Server.Decrypted
+	failed := sess.Client.Failed + sess.Server.Failed
+	tx.Attributes["decrypted_records"] = strconv.Itoa(opened)
+	if opened == 0 {
+		tx.Attributes["decryption"] = "failed"
+		raise(stream, domain.SeverityWarning, "TLS Decryption Failed: none of %d encrypted records authenticated with the logged keys for %s (key log from another session?)",
+			failed, name)
+		return nil
+	}
+
+	tx.Attributes["decryption"] = "ok"
+	if failed > 0 {
+		tx.Attributes["decryption"] = "partial"
+		raise(stream, domain.SeverityWarning, "TLS Decryption Incomplete: %d of %d encrypted records failed to authenticate for %s",
+			failed, opened+failed, name)
+	}
+	if sess.ALPN != "" {
+		tx.Attributes["alpn"] = sess.ALPN
+	}
+	return sess
+}
+
+// dissectDecrypted runs the HTTP/1 and HTTP/2 dissectors on the plaintext
+// of a decrypted session
+func (e *Engine) dissectDecrypted(stream *domain.Stream, sess *tlsdissect.Session, client, server *reassembly.Flow) {
+	pc := plainFlow(client, sess.Client)
+	ps := plainFlow(server, sess.Server)
+
+	if cStart, sStart, ok := http2.Start(pc.Data, ps.Data); ok {
+		e.analyzeHTTP2(stream, pc, ps, cStart, sStart)
+	} else if http1.IsRequestStart(pc.Data) || http1.IsResponseStart(ps.Data) {
+		e.analyzeHTTP1(stream, pc, ps)
+	}
+
+	app := stream.Protocol
+	if app == "TLS" {
+		app = "unrecognized"
+	}
+	raise(stream, domain.SeverityNormal, "TLS Decrypted: %s with %s, %d record(s), application protocol %s",
+		tlsdissect.VersionName(sess.Version), tlsdissect.CipherName(sess.CipherSuite), sess.Client.Decrypted+sess.Server.Decrypted, app)
+}
+
+// plainFlow turns decrypted records into a flow whose bytes carry the time
+// of the packet that completed their record
+func plainFlow(enc *reassembly.Flow, p *tlsdissect.Plaintext) *reassembly.Flow {
+	f := &reassembly.Flow{Data: p.Data, FIN: enc.FIN, RST: enc.RST}
+	for _, r := range p.Records {
+		c := enc.ChunkAt(r.RecordEnd - 1)
+		if c == nil {
+			continue
+		}
+		f.Chunks = append(f.Chunks, reassembly.Chunk{
+			Offset:      r.Offset,
+			Len:         r.Len,
+			Timestamp:   c.Timestamp,
+			PacketIndex: c.PacketIndex,
+		})
+	}
+	return f
+}
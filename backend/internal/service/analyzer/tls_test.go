@@ -0,0 +1,312 @@
+package analyzer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	tlsdissect "pcap-analyzer/internal/service/dissector/tls"
+)
+
+// recorder keeps a copy of everything written to a connection
+type recorder struct {
+	net.Conn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	r.buf.Write(p)
+	r.mu.Unlock()
+	return r.Conn.Write(p)
+}
+
+// selfSigned returns a certificate for example.com valid until notAfter
+func selfSigned(t *testing.T, notAfter time.Time) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    notAfter.AddDate(-2, 0, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsExchange runs a handshake over a pipe, sends request and answers it
+// with response, and returns the bytes each side sent
+func tlsExchange(t *testing.T, cli, srv *tls.Config, request, response string) (client, server []byte) {
+	t.Helper()
+	c, s := net.Pipe()
+	cr, sr := &recorder{Conn: c}, &recorder{Conn: s}
+	sc, cc := tls.Server(sr, srv), tls.Client(cr, cli)
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		if _, err := sc.Read(buf); err != nil {
+			done <- err
+			return
+		}
+		_, err := sc.Write([]byte(response))
+		done <- err
+	}()
+	if _, err := cc.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.Read(make([]byte, 4096)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	s.Close()
+	return cr.buf.Bytes(), sr.buf.Bytes()
+}
+
+// tlsStream carries the bytes of each side in one packet
+func tlsStream(client, server []byte) *domain.Stream {
+	c := newConn(40000, 443).handshake(0)
+	c.send(true, 10*time.Millisecond, string(client))
+	c.send(false, 20*time.Millisecond, string(server))
+	return c.finish()
+}
+
+func tlsTransaction(t *testing.T, stream *domain.Stream) *domain.Transaction {
+	t.Helper()
+	for _, tx := range stream.Transactions {
+		if tx.Protocol == "TLS" {
+			return tx
+		}
+	}
+	t.Fatalf("no TLS transaction in %+v", stream.Transactions)
+	return nil
+}
+
+func TestTLSDecryptionReport(t *testing.T) {
+	const (
+		request  = "GET /secret HTTP/1.1\r\nHost: example.com\r\n\r\n"
+		response = "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	)
+	session := func(version uint16) (client, server []byte, keys *tlsdissect.KeyLog) {
+		var keyLog bytes.Buffer
+		client, server = tlsExchange(t,
+			&tls.Config{ServerName: "example.com", InsecureSkipVerify: true, KeyLogWriter: &keyLog},
+			&tls.Config{Certificates: []tls.Certificate{selfSigned(t, testStart.AddDate(1, 0, 0))}, MaxVersion: version},
+			request, response)
+		keys, err := tlsdissect.ParseKeyLog(&keyLog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client, server, keys
+	}
+	// wrongKeys logs another session's secrets under this session's random
+	wrongKeys := func(client []byte, version uint16) *tlsdissect.KeyLog {
+		var keyLog bytes.Buffer
+		tlsExchange(t,
+			&tls.Config{ServerName: "example.com", InsecureSkipVerify: true, KeyLogWriter: &keyLog},
+			&tls.Config{Certificates: []tls.Certificate{selfSigned(t, testStart.AddDate(1, 0, 0))}, MaxVersion: version},
+			request, response)
+		random := hex.EncodeToString(tlsdissect.ParseSide(client).ClientHello.Random)
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(keyLog.String()), "\n") {
+			f := strings.Fields(line)
+			lines = append(lines, f[0]+" "+random+" "+f[2])
+		}
+		keys := tlsdissect.NewKeyLog()
+		keys.Add([]byte(strings.Join(lines, "\n")))
+		return keys
+	}
+
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		name := tlsdissect.VersionName(version)
+		t.Run(name+" decrypted", func(t *testing.T) {
+			client, server, keys := session(version)
+			s := tlsStream(client, server)
+			e := NewEngine()
+			e.SetKeyLog(keys)
+			e.AnalyzeStream(s)
+
+			tx := tlsTransaction(t, s)
+			if tx.Attributes["decryption"] != "ok" {
+				t.Errorf("decryption = %q, want ok", tx.Attributes["decryption"])
+			}
+			if f := findAnalysis(s, "TLS Decrypted: "+name); !strings.HasSuffix(f, "application protocol HTTP") {
+				t.Errorf("decrypted finding = %q in %q", f, s.Analysis)
+			}
+			var found bool
+			for _, h := range s.Transactions {
+				found = found || (h.Protocol == "HTTP" && h.Target == "/secret" && h.Status == 200)
+			}
+			if !found {
+				t.Errorf("decrypted HTTP exchange missing from %+v", s.Transactions)
+			}
+		})
+
+		t.Run(name+" record corrupted", func(t *testing.T) {
+			client, server, keys := session(version)
+			server = append([]byte(nil), server...)
+			server[len(server)-1] ^= 1
+			s := tlsStream(client, server)
+			e := NewEngine()
+			e.SetKeyLog(keys)
+			e.AnalyzeStream(s)
+
+			if got := tlsTransaction(t, s).Attributes["decryption"]; got != "partial" {
+				t.Errorf("decryption = %q, want partial", got)
+			}
+			if f := findAnalysis(s, "TLS Decryption Incomplete: 1 of"); f == "" {
+				t.Errorf("no incomplete finding in %q", s.Analysis)
+			}
+		})
+
+		t.Run(name+" wrong keys", func(t *testing.T) {
+			client, server, _ := session(version)
+			s := tlsStream(client, server)
+			e := NewEngine()
+			e.SetKeyLog(wrongKeys(client, version))
+			e.AnalyzeStream(s)
+
+			if got := tlsTransaction(t, s).Attributes["decryption"]; got != "failed" {
+				t.Errorf("decryption = %q, want failed", got)
+			}
+			if !hasAnalysis(s, "TLS Decryption Failed") {
+				t.Errorf("findings = %q", s.Analysis)
+			}
+		})
+	}
+
+	t.Run("no key for the session", func(t *testing.T) {
+		client, server, _ := session(tls.VersionTLS12)
+		_, _, other := session(tls.VersionTLS12)
+		s := tlsStream(client, server)
+		e := NewEngine()
+		e.SetKeyLog(other)
+		e.AnalyzeStream(s)
+
+		if got := tlsTransaction(t, s).Attributes["decryption"]; got != tlsdissect.ErrNoKey.Error() {
+			t.Errorf("decryption = %q, want %q", got, tlsdissect.ErrNoKey)
+		}
+		if !hasAnalysis(s, "TLS Not Decrypted: no key log entry for this session for example.com") {
+			t.Errorf("findings = %q", s.Analysis)
+		}
+	})
+
+	t.Run("no key log", func(t *testing.T) {
+		client, server, _ := session(tls.VersionTLS12)
+		s := tlsStream(client, server)
+		NewEngine().AnalyzeStream(s)
+
+		if got, ok := tlsTransaction(t, s).Attributes["decryption"]; ok {
+			t.Errorf("decryption = %q without a key log", got)
+		}
+	})
+}
+
+// clientHello returns the first record a TLS 1.2 client sends to example.com
+func clientHello(t *testing.T) []byte {
+	t.Helper()
+	client, _ := tlsExchange(t,
+		&tls.Config{ServerName: "example.com", InsecureSkipVerify: true},
+		&tls.Config{Certificates: []tls.Certificate{selfSigned(t, testStart.AddDate(1, 0, 0))}, MaxVersion: tls.VersionTLS12},
+		"ping", "pong")
+	recs := tlsdissect.ParseRecords(client)
+	if len(recs) == 0 {
+		t.Fatal("no ClientHello record")
+	}
+	return client[:5+len(recs[0].Fragment)]
+}
+
+func TestTLSHandshakeFailure(t *testing.T) {
+	ms := time.Millisecond
+	c := newConn(40000, 443).handshake(0)
+	c.send(true, 10*ms, string(clientHello(t)))
+	c.send(false, 12*ms, string([]byte{tlsdissect.RecordAlert, 3, 3, 0, 2, tlsdissect.AlertLevelFatal, 40}))
+	c.send(false, 13*ms, "", "FIN", "ACK")
+	s := c.finish()
+	NewEngine().AnalyzeStream(s)
+
+	tx := tlsTransaction(t, s)
+	if tx.Status != 40 || tx.Attributes["alert_sender"] != "server" || tx.Error != "server sent fatal alert handshake_failure" {
+		t.Errorf("transaction = status %d, sender %q, error %q", tx.Status, tx.Attributes["alert_sender"], tx.Error)
+	}
+	if !hasAnalysis(s, "TLS Handshake Failure: server sent fatal alert handshake_failure (40) for example.com") {
+		t.Errorf("findings = %q", s.Analysis)
+	}
+	if s.Severity != domain.SeverityCritical {
+		t.Errorf("severity = %s, want critical", s.Severity)
+	}
+	if hasAnalysis(s, "TLS Reset After ClientHello") {
+		t.Errorf("an alert was reported as a reset: %q", s.Analysis)
+	}
+}
+
+func TestTLSExpiredCertificate(t *testing.T) {
+	client, server := tlsExchange(t,
+		&tls.Config{ServerName: "example.com", InsecureSkipVerify: true},
+		&tls.Config{Certificates: []tls.Certificate{selfSigned(t, testStart.AddDate(0, 0, -10))}, MaxVersion: tls.VersionTLS12},
+		"ping", "pong")
+	s := tlsStream(client, server)
+	NewEngine().AnalyzeStream(s)
+
+	if !hasAnalysis(s, `Expired TLS Certificate: "example.com" expired 2024-12-22 (10 days before capture) for example.com`) {
+		t.Errorf("findings = %q", s.Analysis)
+	}
+	if s.Severity != domain.SeverityCritical {
+		t.Errorf("severity = %s, want critical", s.Severity)
+	}
+	if got := tlsTransaction(t, s).Attributes["cert_not_after"]; got != "2024-12-22T00:00:00Z" {
+		t.Errorf("cert_not_after = %q", got)
+	}
+}
+
+func TestTLSResetAfterClientHello(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name  string
+		reset time.Duration
+		want  bool
+	}{
+		{"reset right after the hello", 40 * ms, true},
+		{"reset after the window", 3 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConn(40000, 443).handshake(0)
+			c.send(true, 10*ms, string(clientHello(t)))
+			c.send(false, 10*ms+tt.reset, "", "RST")
+			s := c.finish()
+			NewEngine().AnalyzeStream(s)
+
+			f := findAnalysis(s, "TLS Reset After ClientHello")
+			if got := f != ""; got != tt.want {
+				t.Fatalf("reset finding = %q, want %v", f, tt.want)
+			}
+			if tt.want && !strings.HasPrefix(f, "TLS Reset After ClientHello: connection reset 40ms after the hello for example.com") {
+				t.Errorf("reset finding = %q", f)
+			}
+		})
+	}
+}
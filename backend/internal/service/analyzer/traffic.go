@@ -0,0 +1,222 @@
+package analyzer
+
+import (
+	"sort"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// seriesBuckets are the widths picked automatically, finest first
+var seriesBuckets = []time.Duration{
+	100 * time.Millisecond, time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute, time.Hour,
+}
+
+const (
+	seriesTargetBuckets = 1000  // automatic widths stay under this many buckets
+	seriesMaxBuckets    = 20000 // a configured width finer than this falls back to automatic
+	trafficTopEntries   = 100   // talkers and conversations kept
+)
+
+// SetTimeSeriesBucket sets the width of the capture time series buckets;
+// zero picks one from the capture duration
+func (e *Engine) SetTimeSeriesBucket(d time.Duration) {
+	e.seriesBucket = d
+}
+
+// seriesBucket returns the configured width, unless it would split the
+// capture into too many buckets, or the finest automatic one that fits
+func seriesBucket(configured, span time.Duration) time.Duration {
+	if configured > 0 && span/configured < seriesMaxBuckets {
+		return configured
+	}
+	for _, b := range seriesBuckets {
+		if span/b < seriesTargetBuckets {
+			return b
+		}
+	}
+	return seriesBuckets[len(seriesBuckets)-1]
+}
+
+// captureStats totals the traffic of every stream and ARP packet into a
+// time series, the protocol mix, top talkers and top conversations. It runs
+// after AnalyzeStream, which classifies streams and marks retransmissions.
+func (e *Engine) captureStats(streams []*domain.Stream, arp []*domain.ARPPacket) *domain.CaptureStats {
+	var start, end time.Time
+	span := func(t time.Time) {
+		if start.IsZero() || t.Before(start) {
+			start = t
+		}
+		if t.After(end) {
+			end = t
+		}
+	}
+	for _, s := range streams {
+		for _, pkt := range s.Packets {
+			span(pkt.Timestamp)
+		}
+	}
+	for _, a := range arp {
+		span(a.Timestamp)
+	}
+
+	stats := &domain.CaptureStats{}
+	if start.IsZero() {
+		return stats
+	}
+	bucket := seriesBucket(e.seriesBucket, end.Sub(start))
+	origin := start.Truncate(bucket)
+	stats.Start, stats.Bucket = origin, bucket
+	stats.Series = make([]*domain.TimeBucket, int(end.Sub(origin)/bucket)+1)
+	for i := range stats.Series {
+		stats.Series[i] = &domain.TimeBucket{Time: origin.Add(time.Duration(i) * bucket), Protocols: map[string]int64{}}
+	}
+	at := func(t time.Time) *domain.TimeBucket {
+		return stats.Series[int(t.Sub(origin)/bucket)]
+	}
+
+	protocols := map[string]*domain.ProtocolShare{}
+	talkers := map[string]*domain.Talker{}
+	talkerStreams := map[string]map[string]bool{}
+	conversations := map[[2]string]*domain.Conversation{}
+	conversationProtocols := map[[2]string]map[string]bool{}
+	share := func(name string) *domain.ProtocolShare {
+		p, ok := protocols[name]
+		if !ok {
+			p = &domain.ProtocolShare{Protocol: name}
+			protocols[name] = p
+		}
+		return p
+	}
+	talker := func(ip, stream string) *domain.Talker {
+		t, ok := talkers[ip]
+		if !ok {
+			t = &domain.Talker{IP: ip}
+			talkers[ip] = t
+			talkerStreams[ip] = map[string]bool{}
+		}
+		if stream != "" && !talkerStreams[ip][stream] {
+			talkerStreams[ip][stream] = true
+			t.Streams++
+		}
+		return t
+	}
+	conversation := func(src, dst, protocol string, t time.Time) (*domain.Conversation, bool) {
+		k, forward := [2]string{src, dst}, true
+		if dst < src {
+			k, forward = [2]string{dst, src}, false
+		}
+		c, ok := conversations[k]
+		if !ok {
+			c = &domain.Conversation{AddressA: k[0], AddressB: k[1], Start: t}
+			conversations[k] = c
+			conversationProtocols[k] = map[string]bool{}
+		}
+		if t.Before(c.Start) {
+			c.Start = t
+		}
+		if t.After(c.End) {
+			c.End = t
+		}
+		conversationProtocols[k][protocol] = true
+		return c, forward
+	}
+	count := func(src, dst, protocol, stream string, t time.Time, length int) {
+		b := at(t)
+		b.Packets++
+		b.Bytes += int64(length)
+		b.Protocols[protocol] += int64(length)
+		p := share(protocol)
+		p.Packets++
+		p.Bytes += int64(length)
+		sender, receiver := talker(src, stream), talker(dst, stream)
+		sender.Packets++
+		sender.BytesSent += int64(length)
+		receiver.Packets++
+		receiver.BytesReceived += int64(length)
+		c, forward := conversation(src, dst, protocol, t)
+		c.Packets++
+		if forward {
+			c.BytesAtoB += int64(length)
+		} else {
+			c.BytesBtoA += int64(length)
+		}
+	}
+
+	for _, s := range streams {
+		protocol := s.Protocol
+		if protocol == "" {
+			protocol = s.Transport
+		}
+		share(protocol).Streams++
+		k := [2]string{s.ClientIP, s.ServerIP}
+		for _, pkt := range s.Packets {
+			count(pkt.SrcIP, pkt.DstIP, protocol, s.ID, pkt.Timestamp, pkt.Length)
+			b := at(pkt.Timestamp)
+			if hasFlag(pkt, "SYN") && !hasFlag(pkt, "ACK") {
+				b.NewConnections++
+			}
+			if hasFlag(pkt, "RST") {
+				b.Resets++
+			}
+			if pkt.IsRetrans {
+				b.Retransmissions++
+			}
+		}
+		if len(s.Packets) > 0 {
+			if k[1] < k[0] {
+				k = [2]string{k[1], k[0]}
+			}
+			if c, ok := conversations[k]; ok {
+				c.Streams++
+			}
+		}
+	}
+	for _, a := range arp {
+		count(a.SenderIP, a.TargetIP, "ARP", "", a.Timestamp, a.Length)
+	}
+
+	for _, p := range protocols {
+		stats.Protocols = append(stats.Protocols, p)
+	}
+	sort.Slice(stats.Protocols, func(i, j int) bool {
+		a, b := stats.Protocols[i], stats.Protocols[j]
+		return a.Bytes > b.Bytes || (a.Bytes == b.Bytes && a.Protocol < b.Protocol)
+	})
+
+	for _, t := range talkers {
+		stats.Talkers = append(stats.Talkers, t)
+	}
+	sort.Slice(stats.Talkers, func(i, j int) bool {
+		a, b := stats.Talkers[i], stats.Talkers[j]
+		ta, tb := a.BytesSent+a.BytesReceived, b.BytesSent+b.BytesReceived
+		return ta > tb || (ta == tb && a.IP < b.IP)
+	})
+	if len(stats.Talkers) > trafficTopEntries {
+		stats.Talkers = stats.Talkers[:trafficTopEntries]
+	}
+
+	for k, c := range conversations {
+		for p := range conversationProtocols[k] {
+			c.Protocols = append(c.Protocols, p)
+		}
+		sort.Strings(c.Protocols)
+		stats.Conversations = append(stats.Conversations, c)
+	}
+	sort.Slice(stats.Conversations, func(i, j int) bool {
+		a, b := stats.Conversations[i], stats.Conversations[j]
+		ta, tb := a.BytesAtoB+a.BytesBtoA, b.BytesAtoB+b.BytesBtoA
+		if ta != tb {
+			return ta > tb
+		}
+		if a.AddressA != b.AddressA {
+			return a.AddressA < b.AddressA
+		}
+		return a.AddressB < b.AddressB
+	})
+	if len(stats.Conversations) > trafficTopEntries {
+		stats.Conversations = stats.Conversations[:trafficTopEntries]
+	}
+	return stats
+}
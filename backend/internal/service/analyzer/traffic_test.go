@@ -0,0 +1,131 @@
+package analyzer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+func TestSeriesBucket(t *testing.T) {
+	tests := []struct {
+		configured, span, want time.Duration
+	}{
+		{0, 30 * time.Second, 100 * time.Millisecond},
+		{0, 2 * time.Minute, time.Second},
+		{0, 48 * time.Hour, 5 * time.Minute},
+		{0, 365 * 24 * time.Hour, time.Hour},
+		{time.Second, 10 * time.Second, time.Second},
+		// Too fine for the capture: fall back to the automatic width
+		{10 * time.Millisecond, 10 * time.Minute, time.Second},
+	}
+	for _, tt := range tests {
+		if got := seriesBucket(tt.configured, tt.span); got != tt.want {
+			t.Errorf("seriesBucket(%v, %v) = %v, want %v", tt.configured, tt.span, got, tt.want)
+		}
+	}
+}
+
+func TestCaptureStats(t *testing.T) {
+	ms := time.Millisecond
+	const (
+		request  = "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+		response = "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+	)
+	web := newConn(40000, 80).handshake(0)
+	web.send(true, 10*ms, request)
+	web.resend(web.send(false, 150*ms, response), 1200*ms)
+	web.send(true, 1500*ms, "", "RST")
+
+	dns := newDatagrams(5353, 53)
+	dns.stream.ClientIP = "10.0.0.3"
+	dns.stream.ID = domain.GenerateStreamID("10.0.0.3", "10.0.0.2", 5353, 53)
+	dns.send(true, 2100*ms, string(make([]byte, 30)))
+	dns.send(false, 2200*ms, string(make([]byte, 100)))
+
+	arp := []*domain.ARPPacket{{Timestamp: testStart.Add(2500 * ms), Length: 42, Operation: 1, SenderIP: "10.0.0.3", TargetIP: "10.0.0.1"}}
+
+	streams := []*domain.Stream{web.finish(), dns.finish()}
+	e := NewEngine()
+	e.SetTimeSeriesBucket(time.Second)
+	for _, s := range streams {
+		e.AnalyzeStream(s)
+	}
+	stats := e.AnalyzeCapture(streams, arp).Stats
+
+	syn := 54
+	httpBytes := int64(4*syn + 54 + len(request) + 2*(54+len(response)))
+	dnsBytes := int64(42 + 30 + 42 + 100)
+
+	if stats.Bucket != time.Second || !stats.Start.Equal(testStart) || len(stats.Series) != 3 {
+		t.Fatalf("series = %d buckets of %v from %v", len(stats.Series), stats.Bucket, stats.Start)
+	}
+	buckets := []domain.TimeBucket{
+		{Packets: 5, Bytes: int64(3*syn + 54 + len(request) + 54 + len(response)), NewConnections: 1,
+			Protocols: map[string]int64{"HTTP": int64(3*syn + 54 + len(request) + 54 + len(response))}},
+		{Packets: 2, Bytes: int64(54 + len(response) + syn), Resets: 1, Retransmissions: 1,
+			Protocols: map[string]int64{"HTTP": int64(54 + len(response) + syn)}},
+		{Packets: 3, Bytes: dnsBytes + 42, Protocols: map[string]int64{"DNS": dnsBytes, "ARP": 42}},
+	}
+	for i, want := range buckets {
+		got := stats.Series[i]
+		if got.Packets != want.Packets || got.Bytes != want.Bytes || got.NewConnections != want.NewConnections ||
+			got.Resets != want.Resets || got.Retransmissions != want.Retransmissions ||
+			fmt.Sprint(got.Protocols) != fmt.Sprint(want.Protocols) {
+			t.Errorf("bucket %d = %+v, want %+v", i, *got, want)
+		}
+		if !got.Time.Equal(testStart.Add(time.Duration(i) * time.Second)) {
+			t.Errorf("bucket %d starts at %v", i, got.Time)
+		}
+	}
+
+	wantProtocols := []domain.ProtocolShare{
+		{Protocol: "HTTP", Streams: 1, Packets: 7, Bytes: httpBytes},
+		{Protocol: "DNS", Streams: 1, Packets: 2, Bytes: dnsBytes},
+		{Protocol: "ARP", Packets: 1, Bytes: 42},
+	}
+	if len(stats.Protocols) != len(wantProtocols) {
+		t.Fatalf("got %d protocols, want %d", len(stats.Protocols), len(wantProtocols))
+	}
+	for i, want := range wantProtocols {
+		if *stats.Protocols[i] != want {
+			t.Errorf("protocol %d = %+v, want %+v", i, *stats.Protocols[i], want)
+		}
+	}
+
+	wantTalkers := []domain.Talker{
+		{IP: "10.0.0.2", Packets: 9, BytesSent: int64(syn) + 2*int64(54+len(response)) + 142, BytesReceived: int64(3*syn+54+len(request)) + 72, Streams: 2},
+		{IP: "10.0.0.1", Packets: 8, BytesSent: int64(3*syn + 54 + len(request)), BytesReceived: int64(syn) + 2*int64(54+len(response)) + 42, Streams: 1},
+		{IP: "10.0.0.3", Packets: 3, BytesSent: 72 + 42, BytesReceived: 142, Streams: 1},
+	}
+	if len(stats.Talkers) != len(wantTalkers) {
+		t.Fatalf("got %d talkers, want %d", len(stats.Talkers), len(wantTalkers))
+	}
+	for i, want := range wantTalkers {
+		if *stats.Talkers[i] != want {
+			t.Errorf("talker %d = %+v, want %+v", i, *stats.Talkers[i], want)
+		}
+	}
+
+	wantConversations := []struct {
+		a, b      string
+		bytes     int64
+		streams   int
+		protocols string
+	}{
+		{"10.0.0.1", "10.0.0.2", httpBytes, 1, "[HTTP]"},
+		{"10.0.0.2", "10.0.0.3", dnsBytes, 1, "[DNS]"},
+		{"10.0.0.1", "10.0.0.3", 42, 0, "[ARP]"},
+	}
+	if len(stats.Conversations) != len(wantConversations) {
+		t.Fatalf("got %d conversations, want %d", len(stats.Conversations), len(wantConversations))
+	}
+	for i, want := range wantConversations {
+		c := stats.Conversations[i]
+		if c.AddressA != want.a || c.AddressB != want.b || c.BytesAtoB+c.BytesBtoA != want.bytes ||
+			c.Streams != want.streams || fmt.Sprint(c.Protocols) != want.protocols {
+			t.Errorf("conversation %d = %+v, want %+v", i, *c, want)
+		}
+	}
+}
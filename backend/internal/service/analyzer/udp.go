@@ -0,0 +1,291 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/icmp"
+)
+
+const (
+	udpBurstBin = 100 * time.Millisecond
+	// udpMinSequenced is the fewest packets an extractor must recognise
+	// before loss is measured, and udpSequenceMatch the share it must match
+	udpMinSequenced  = 5
+	udpSequenceMatch = 0.9
+	// udpMinBurstPackets keeps short exchanges out of the burstiness check
+	udpMinBurstPackets = 50
+	// udpMaxBurstBins bounds the span burstiness is measured over (about a
+	// day), which a bogus timestamp could otherwise make arbitrarily long
+	udpMaxBurstBins = 1 << 20
+)
+
+// analyzeUDP computes per-direction rates, jitter, burstiness and, where a
+// sequence extractor recognises the payload, loss. It reports one-way flows,
+// senders that ignore ICMP unreachables, loss, jitter and bursts.
+func (e *Engine) analyzeUDP(stream *domain.Stream) {
+	if stream.Transport != "UDP" {
+		return
+	}
+
+	var fwd, rev []*domain.PacketMeta
+	for _, pkt := range stream.Packets {
+		if stream.FromClient(pkt) {
+			fwd = append(fwd, pkt)
+		} else {
+			rev = append(rev, pkt)
+		}
+	}
+
+	m := &domain.UDPMetrics{
+		ClientToServer: udpDirection(fwd),
+		ServerToClient: udpDirection(rev),
+	}
+	m.Unidirectional = len(fwd) == 0 || len(rev) == 0
+	stream.Metrics.UDP = m
+
+	e.detectUDPUnreachable(stream)
+	if len(stream.Transactions) > 0 || stream.Protocol == "RTP" || stream.Protocol == "RTCP" {
+		return // the protocol dissectors report on this flow
+	}
+	if stream.Protocol == "DHCP" || stream.Protocol == "DHCPv6" {
+		return // exchanges span several flows and are analyzed for the capture
+	}
+	e.detectUnidirectionalUDP(stream, m)
+	e.detectUDPQuality(stream, "client→server", &m.ClientToServer)
+	e.detectUDPQuality(stream, "server→client", &m.ServerToClient)
+}
+
+func udpDirection(pkts []*domain.PacketMeta) domain.UDPDirection {
+	d := domain.UDPDirection{Packets: len(pkts)}
+	if len(pkts) == 0 {
+		return d
+	}
+	for _, pkt := range pkts {
+		d.Bytes += int64(pkt.PayloadLen)
+	}
+
+	span := pkts[len(pkts)-1].Timestamp.Sub(pkts[0].Timestamp)
+	if span > 0 {
+		d.PacketsPerSec = float64(len(pkts)) / span.Seconds()
+		d.BytesPerSec = float64(d.Bytes) / span.Seconds()
+	}
+
+	// Inter-arrival times and their variation (IPDV, RFC 5481)
+	if len(pkts) > 1 {
+		var sum, variation float64
+		var prevGap float64
+		for i := 1; i < len(pkts); i++ {
+			gap := msBetween(pkts[i-1].Timestamp, pkts[i].Timestamp)
+			sum += gap
+			if gap > d.MaxGapMs {
+				d.MaxGapMs = gap
+			}
+			if i > 1 {
+				variation += math.Abs(gap - prevGap)
+			}
+			prevGap = gap
+		}
+		d.MeanInterArrivalMs = sum / float64(len(pkts)-1)
+		if len(pkts) > 2 {
+			d.JitterMs = variation / float64(len(pkts)-2)
+		}
+	}
+
+	d.Burstiness = burstiness(pkts)
+	measureSequence(&d, pkts)
+	return d
+}
+
+// burstiness is the busiest 100ms bin relative to the average bin. Packets
+// are in capture order, whose timestamps need not increase (merged
+// captures, clock steps), so bins count from the earliest one.
+func burstiness(pkts []*domain.PacketMeta) float64 {
+	first, last := pkts[0].Timestamp, pkts[0].Timestamp
+	for _, pkt := range pkts[1:] {
+		if pkt.Timestamp.Before(first) {
+			first = pkt.Timestamp
+		}
+		if pkt.Timestamp.After(last) {
+			last = pkt.Timestamp
+		}
+	}
+	bins := int64(last.Sub(first)/udpBurstBin) + 1
+	if bins < 2 || bins > udpMaxBurstBins {
+		return 1
+	}
+	counts := make(map[int64]int)
+	peak := 0
+	for _, pkt := range pkts {
+		i := int64(pkt.Timestamp.Sub(first) / udpBurstBin)
+		counts[i]++
+		if counts[i] > peak {
+			peak = counts[i]
+		}
+	}
+	return float64(peak) / (float64(len(pkts)) / float64(bins))
+}
+
+// measureSequence fills the loss fields using the first registered extractor
+// that recognises nearly all packets of the direction
+func measureSequence(d *domain.UDPDirection, pkts []*domain.PacketMeta) {
+	if len(pkts) < udpMinSequenced {
+		return
+	}
+
+	for _, x := range sequenceExtractors() {
+		var seqs []uint64
+		var bits uint
+		for _, pkt := range pkts {
+			if s, b, ok := x.Sequence(pkt.Payload); ok {
+				seqs = append(seqs, s)
+				bits = b
+			}
+		}
+		if len(seqs) < udpMinSequenced || float64(len(seqs)) < udpSequenceMatch*float64(len(pkts)) {
+			continue
+		}
+
+		d.SequenceSource = x.Name()
+		seen := map[int64]bool{}
+		var highest, lowest int64
+		for i, s := range seqs {
+			ext := int64(s)
+			if i > 0 {
+				ext = unwrapSequence(highest, s, bits)
+			} else {
+				highest, lowest = ext, ext
+			}
+			switch {
+			case seen[ext]:
+				d.Duplicates++
+				continue
+			case ext < highest:
+				d.Reordered++
+			}
+			seen[ext] = true
+			if ext > highest {
+				highest = ext
+			}
+			if ext < lowest {
+				lowest = ext
+			}
+		}
+		d.Expected = int(highest - lowest + 1)
+		d.Lost = d.Expected - len(seen)
+		if d.Expected > 0 {
+			d.LossPercent = float64(d.Lost) / float64(d.Expected) * 100
+		}
+		return
+	}
+}
+
+// unwrapSequence extends a bits-wide sequence number to the value closest to
+// the highest one seen so far
+func unwrapSequence(highest int64, seq uint64, bits uint) int64 {
+	if bits == 0 || bits >= 63 {
+		return int64(seq)
+	}
+	mod := int64(1) << bits
+	delta := (int64(seq) - highest%mod + mod) % mod
+	if delta >= mod/2 {
+		delta -= mod
+	}
+	return highest + delta
+}
+
+func msBetween(a, b time.Time) float64 {
+	return float64(b.Sub(a)) / float64(time.Millisecond)
+}
+
+func isUnreachable(kind string) bool {
+	switch kind {
+	case icmp.KindPortUnreachable, icmp.KindHostUnreachable, icmp.KindNetUnreachable,
+		icmp.KindProtoUnreachable, icmp.KindAdminProhibited, icmp.KindUnreachable:
+		return true
+	}
+	return false
+}
+
+// detectUDPUnreachable reports a sender that kept transmitting after the
+// destination (or a router) answered with an ICMP unreachable
+func (e *Engine) detectUDPUnreachable(stream *domain.Stream) {
+	for _, ev := range stream.ICMPEvents {
+		if !isUnreachable(ev.Kind) {
+			continue
+		}
+		// The error goes back to the sender of the quoted packet
+		after := 0
+		for _, pkt := range stream.Packets {
+			if pkt.SrcIP == reportedTo(stream, ev) && pkt.Timestamp.After(ev.Timestamp) {
+				after++
+			}
+		}
+		if after > 0 {
+			raise(stream, domain.SeverityWarning, "UDP Sender Ignored ICMP Unreachable: %d packet(s) sent after %s reported %s",
+				after, ev.Reporter, ev.Kind)
+		}
+		return
+	}
+}
+
+// reportedTo returns the address an ICMP error about the stream was sent to
+func reportedTo(stream *domain.Stream, ev *domain.ICMPEvent) string {
+	if ev.Reporter == stream.ServerIP {
+		return stream.ClientIP
+	}
+	if ev.Reporter == stream.ClientIP {
+		return stream.ServerIP
+	}
+	return stream.ClientIP
+}
+
+func (e *Engine) detectUnidirectionalUDP(stream *domain.Stream, m *domain.UDPMetrics) {
+	if !m.Unidirectional {
+		return
+	}
+	sent := m.ClientToServer.Packets + m.ServerToClient.Packets
+	if sent < e.thresholds.UDPUnidirectionalMinPackets || isGroupAddress(stream.ServerIP) {
+		return
+	}
+
+	reason := ""
+	for _, ev := range stream.ICMPEvents {
+		if isUnreachable(ev.Kind) {
+			reason = fmt.Sprintf(" (%s reported %s)", ev.Reporter, ev.Kind)
+			break
+		}
+	}
+	raise(stream, domain.SeverityWarning, "Unidirectional UDP Flow: %d packet(s) to %s:%d with no response%s",
+		sent, stream.ServerIP, stream.ServerPort, reason)
+}
+
+func (e *Engine) detectUDPQuality(stream *domain.Stream, dir string, d *domain.UDPDirection) {
+	if d.SequenceSource != "" {
+		if d.LossPercent > e.thresholds.UDPLossPercent {
+			raise(stream, domain.SeverityWarning, "UDP Packet Loss (%s): %.1f%% %s (%d of %d lost, %d reordered, %d duplicated)",
+				d.SequenceSource, d.LossPercent, dir, d.Lost, d.Expected, d.Reordered, d.Duplicates)
+		}
+		if d.JitterMs > e.thresholds.UDPJitterMs {
+			raise(stream, domain.SeverityWarning, "High UDP Jitter (%s): %.1fms %s (mean inter-arrival %.1fms)",
+				d.SequenceSource, d.JitterMs, dir, d.MeanInterArrivalMs)
+		}
+	}
+	if d.Packets >= udpMinBurstPackets && d.Burstiness > e.thresholds.UDPBurstinessRatio {
+		raise(stream, domain.SeverityWarning, "Bursty UDP Flow: busiest 100ms carried %.0fx the average rate %s (%.0f packets/s)",
+			d.Burstiness, dir, d.PacketsPerSec)
+	}
+}
+
+// isGroupAddress reports multicast and broadcast destinations, which are
+// one-way by design
+func isGroupAddress(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	return ip.IsMulticast() || ip.Equal(net.IPv4bcast) || (ip.To4() != nil && ip.To4()[3] == 255)
+}
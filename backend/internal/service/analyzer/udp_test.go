@@ -0,0 +1,51 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+func TestBurstiness(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(offsets ...time.Duration) []*domain.PacketMeta {
+		pkts := make([]*domain.PacketMeta, len(offsets))
+		for i, o := range offsets {
+			pkts[i] = &domain.PacketMeta{Timestamp: base.Add(o)}
+		}
+		return pkts
+	}
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		pkts []*domain.PacketMeta
+		want float64
+	}{
+		{"even", at(0, 100*ms, 200*ms, 300*ms), 1},
+		{"burst", at(0, 10*ms, 20*ms, 399*ms), 3},
+		{"earlier than the first", at(0, -1*time.Second, 10*ms, 20*ms), 8.25},
+		{"bogus timestamp", at(0, 10*ms, 100*365*24*time.Hour), 1},
+		{"single bin", at(0, 10*ms, 20*ms), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := burstiness(tt.pkts); got != tt.want {
+				t.Errorf("burstiness = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUDPDirectionOutOfOrder(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var pkts []*domain.PacketMeta
+	for i := 0; i < 60; i++ {
+		pkts = append(pkts, &domain.PacketMeta{Timestamp: base.Add(time.Duration(i) * 20 * time.Millisecond), PayloadLen: 100})
+	}
+	// A merged capture puts a packet from seconds earlier at the end
+	pkts = append(pkts, &domain.PacketMeta{Timestamp: base.Add(-10 * time.Second), PayloadLen: 100})
+	if d := udpDirection(pkts); d.Packets != 61 || d.Burstiness <= 1 {
+		t.Errorf("direction = %+v", d)
+	}
+}
@@ -0,0 +1,224 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// analyzeCalls rebuilds SIP calls from the INVITE/BYE/CANCEL transactions of
+// all streams, links the RTP sources their SDP negotiated, and reports failed
+// calls, missing or one-way audio and poor quality on the signaling stream.
+func (e *Engine) analyzeCalls(streams []*domain.Stream) []*domain.Call {
+	type sipRef struct {
+		stream *domain.Stream
+		tx     *domain.Transaction
+	}
+	var refs []sipRef
+	var media []*domain.RTPStats
+	reports := map[uint32]*domain.RTCPReport{}
+	for _, s := range streams {
+		for _, tx := range s.Transactions {
+			if tx.Protocol == "SIP" {
+				refs = append(refs, sipRef{s, tx})
+			}
+		}
+		media = append(media, s.Metrics.RTP...)
+		for _, r := range s.Metrics.RTCP {
+			reports[r.Source] = r // keep the latest report per source
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].tx.RequestTime.Before(refs[j].tx.RequestTime) })
+
+	// Receiver reports tell what the far end experienced
+	for _, st := range media {
+		if r, ok := reports[st.SSRC]; ok && st.ClockRate > 0 {
+			st.RemoteLossPercent = r.FractionLost * 100
+			st.RemoteJitterMs = float64(r.Jitter) / float64(st.ClockRate) * 1000
+		}
+	}
+
+	var calls []*domain.Call
+	byID := map[string]*domain.Call{}
+	signaling := map[*domain.Call]*domain.Stream{}
+	for _, r := range refs {
+		tx := r.tx
+		id := tx.Attributes["call_id"]
+		call, ok := byID[id]
+		if !ok {
+			if tx.Method != "INVITE" {
+				continue // REGISTER, OPTIONS... outside a call
+			}
+			call = &domain.Call{
+				CallID:      id,
+				From:        tx.Attributes["from"],
+				To:          tx.Attributes["to"],
+				InviteTime:  tx.RequestTime,
+				FinalStatus: tx.Status,
+				CallerMedia: tx.Attributes["offer"],
+				CalleeMedia: tx.Attributes["answer"],
+				Codec:       tx.Attributes["answer_codec"],
+				Signaling:   r.stream.ID,
+				Media:       []*domain.RTPStats{},
+				Issues:      []string{},
+			}
+			if call.Codec == "" {
+				call.Codec = tx.Attributes["offer_codec"]
+			}
+			if tx.Status >= 200 && tx.Status < 300 {
+				call.AnswerTime = tx.ResponseTime
+				call.SetupTime = tx.ResponseTime.Sub(tx.RequestTime)
+			}
+			switch {
+			case tx.Status == 0:
+				call.State = "unanswered"
+			case tx.Status < 300:
+				call.State = "answered"
+			case tx.Status == 487:
+				call.State = "cancelled"
+			default:
+				call.State = "failed"
+			}
+			byID[id] = call
+			signaling[call] = r.stream
+			calls = append(calls, call)
+			continue
+		}
+
+		switch tx.Method {
+		case "BYE":
+			if call.EndTime.IsZero() {
+				call.EndTime = tx.RequestTime
+				if call.State == "answered" {
+					call.State = "completed"
+				}
+			}
+		case "CANCEL":
+			call.State = "cancelled"
+		}
+	}
+
+	linked := map[*domain.RTPStats]bool{}
+	for _, call := range calls {
+		for _, st := range media {
+			if linked[st] || !overlapsCall(st, call) {
+				continue
+			}
+			switch {
+			case call.CalleeMedia != "" && st.Dst == call.CalleeMedia:
+				st.Direction = "caller->callee"
+			case call.CallerMedia != "" && st.Dst == call.CallerMedia:
+				st.Direction = "callee->caller"
+			default:
+				continue
+			}
+			st.CallID = call.CallID
+			if st.Codec == "" {
+				st.Codec = call.Codec
+			}
+			linked[st] = true
+			call.Media = append(call.Media, st)
+			if call.MOS == 0 || st.MOS < call.MOS {
+				call.MOS = st.MOS
+			}
+		}
+		e.detectCallIssues(call, signaling[call], streams)
+	}
+
+	e.detectUnlinkedOneWayRTP(streams, linked)
+	return calls
+}
+
+// overlapsCall keeps media ports reused by a later call out of an earlier one
+func overlapsCall(st *domain.RTPStats, call *domain.Call) bool {
+	if st.EndTime.Before(call.InviteTime) {
+		return false
+	}
+	return call.EndTime.IsZero() || !st.StartTime.After(call.EndTime)
+}
+
+func (e *Engine) detectCallIssues(call *domain.Call, sigStream *domain.Stream, streams []*domain.Stream) {
+	report := func(stream *domain.Stream, severity domain.Severity, format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		call.Issues = append(call.Issues, msg)
+		raise(stream, severity, "%s", msg)
+	}
+
+	switch call.State {
+	case "failed":
+		severity := domain.SeverityWarning
+		if call.FinalStatus >= 500 {
+			severity = domain.SeverityCritical
+		}
+		report(sigStream, severity, "SIP Call Failed: INVITE to %s answered %d (Call-ID %s)", call.To, call.FinalStatus, call.CallID)
+		return
+	case "unanswered":
+		report(sigStream, domain.SeverityWarning, "SIP Call Unanswered: INVITE to %s got no final response (Call-ID %s)", call.To, call.CallID)
+		return
+	case "cancelled":
+		return
+	}
+
+	forward, reverse := 0, 0
+	for _, st := range call.Media {
+		if st.Direction == "caller->callee" {
+			forward += st.Packets
+		} else {
+			reverse += st.Packets
+		}
+	}
+	switch {
+	case forward == 0 && reverse == 0:
+		report(sigStream, domain.SeverityCritical, "No RTP Media: call to %s was answered but no RTP reached %s or %s (Call-ID %s)",
+			call.To, call.CallerMedia, call.CalleeMedia, call.CallID)
+	case forward == 0 || reverse == 0:
+		dir, silent := "caller->callee", "callee->caller"
+		if forward == 0 {
+			dir, silent = silent, dir
+		}
+		msg := fmt.Sprintf("One-Way Audio: call to %s has %d RTP packets %s and none %s (Call-ID %s)",
+			call.To, forward+reverse, dir, silent, call.CallID)
+		report(sigStream, domain.SeverityCritical, "%s", msg)
+		for _, st := range call.Media {
+			for _, s := range streams {
+				if s.ID == st.Stream {
+					raise(s, domain.SeverityCritical, "%s", msg)
+				}
+			}
+		}
+	}
+
+	if len(call.Media) > 0 && call.MOS < e.thresholds.RTPMinMOS {
+		report(sigStream, domain.SeverityWarning, "Poor Call Quality: worst estimated MOS %.2f for call to %s (Call-ID %s)",
+			call.MOS, call.To, call.CallID)
+	}
+}
+
+// detectUnlinkedOneWayRTP flags RTP without signaling that only flows in one
+// direction of its 5-tuple
+func (e *Engine) detectUnlinkedOneWayRTP(streams []*domain.Stream, linked map[*domain.RTPStats]bool) {
+	for _, s := range streams {
+		if len(s.Metrics.RTP) == 0 {
+			continue
+		}
+		fromClient, fromServer := 0, 0
+		skip := false
+		for _, st := range s.Metrics.RTP {
+			if linked[st] {
+				skip = true
+				break
+			}
+			if st.Src == fmt.Sprintf("%s:%d", s.ClientIP, s.ClientPort) {
+				fromClient += st.Packets
+			} else {
+				fromServer += st.Packets
+			}
+		}
+		if skip || (fromClient > 0 && fromServer > 0) || fromClient+fromServer < udpMinBurstPackets {
+			continue
+		}
+		raise(s, domain.SeverityWarning, "One-Way RTP: %d packets in one direction and none in return (no SIP signaling captured)",
+			fromClient+fromServer)
+	}
+}
@@ -0,0 +1,83 @@
+// Package classify labels a stream with its application protocol from the
+// first payload in each direction, independently of the ports in use.
+// Signatures and heuristics score the payload; a port-hint table breaks
+// ties and labels streams whose payload says nothing.
+package classify
+
+// Confidence scores, from 0 (unknown) to 100 (confirmed by a dissector)
+const (
+	Certain   = 100 // a dissector decoded the protocol
+	Signature = 90  // a magic value or fixed header matched
+	Heuristic = 60  // the payload is plausible for the protocol
+	PortOnly  = 30  // only the port hint suggests the protocol
+
+	// Confirmed is the lowest score that rules out other protocols; below
+	// it every dissector may still look at the stream
+	Confirmed = 50
+
+	// portBonus is added when a matcher agrees with the port hint
+	portBonus = 10
+)
+
+// Sources of a classification
+const (
+	SourceSignature = "signature"
+	SourceHeuristic = "heuristic"
+	SourcePort      = "port"
+	SourceDissector = "dissector"
+)
+
+// Input is what the classifier sees of a stream. Client and Server hold the
+// first payload sent in each direction; either may be empty.
+type Input struct {
+	Transport  string // "TCP" or "UDP"
+	ClientPort uint16
+	ServerPort uint16
+	Client     []byte
+	Server     []byte
+}
+
+// Result is the chosen label. Protocol is empty when nothing matched.
+type Result struct {
+	Protocol   string
+	Confidence int
+	Source     string
+}
+
+// Classifier matches payloads against the built-in matchers and a port-hint
+// table
+type Classifier struct {
+	hints PortHints
+}
+
+// New returns a classifier using the default port hints with overrides
+// applied on top; an override mapping a port to "" removes its hint
+func New(overrides PortHints) *Classifier {
+	return &Classifier{hints: DefaultPortHints().Merge(overrides)}
+}
+
+// Classify picks the best-scoring protocol for the stream
+func (c *Classifier) Classify(in Input) Result {
+	hint := c.hints.Lookup(in.Transport, in.ServerPort, in.ClientPort)
+	var best Result
+	for _, m := range matchers {
+		if m.transport != "" && m.transport != in.Transport {
+			continue
+		}
+		score := m.match(in.Client, in.Server)
+		if score <= 0 {
+			continue
+		}
+		source := sourceOf(score)
+		if m.protocol == hint {
+			score = min(score+portBonus, Certain-1)
+		}
+		if score > best.Confidence {
+			best = Result{Protocol: m.protocol, Confidence: score, Source: source}
+		}
+	}
+	if best.Protocol == "" && hint != "" {
+		best = Result{Protocol: hint, Confidence: PortOnly, Source: SourcePort}
+	}
+	return best
+}
@@ -0,0 +1,104 @@
+package classify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	opaque := []byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04}
+	tests := []struct {
+		name string
+		in   Input
+		want Result
+	}{
+		{"signature beats the port hint",
+			Input{Transport: "TCP", ServerPort: 443, Client: []byte("SSH-2.0-OpenSSH_9.6\r\n")},
+			Result{"SSH", magic, SourceSignature}},
+		{"signature on its own port",
+			Input{Transport: "TCP", ServerPort: 80, Client: []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n")},
+			Result{"HTTP", Certain - 1, SourceSignature}},
+		{"signature on another port",
+			Input{Transport: "TCP", ServerPort: 9999, Client: []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n")},
+			Result{"HTTP", Signature, SourceSignature}},
+		{"heuristic",
+			Input{Transport: "TCP", ServerPort: 9999, Client: []byte("GET /index.html\r\n")},
+			Result{"HTTP", Heuristic, SourceHeuristic}},
+		{"weak match helped by the port",
+			Input{Transport: "TCP", ServerPort: 5432, Client: []byte("Q\x00\x00\x00\x0dSELECT 1\x00")},
+			Result{"PostgreSQL", weak + portBonus, SourceHeuristic}},
+		{"port only",
+			Input{Transport: "TCP", ServerPort: 5432, Client: opaque},
+			Result{"PostgreSQL", PortOnly, SourcePort}},
+		{"client port hint",
+			Input{Transport: "UDP", ServerPort: 40000, ClientPort: 123, Client: opaque},
+			Result{"NTP", PortOnly, SourcePort}},
+		{"hint for the other transport",
+			Input{Transport: "UDP", ServerPort: 5432, Client: opaque},
+			Result{}},
+		{"nothing",
+			Input{Transport: "TCP", ServerPort: 9999, Client: opaque},
+			Result{}},
+	}
+	c := New(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Classify(tt.in); got != tt.want {
+				t.Errorf("Classify = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortHintOverrides(t *testing.T) {
+	opaque := []byte{0xde, 0xad, 0xbe, 0xef}
+	c := New(PortHints{"TCP/9999": "Redis", "443": "", "tcp/5432": "CockroachDB"})
+	tests := []struct {
+		transport string
+		port      uint16
+		want      string
+	}{
+		{"TCP", 9999, "Redis"},
+		{"UDP", 9999, ""},
+		{"TCP", 443, ""},
+		{"UDP", 443, ""},
+		{"TCP", 5432, "CockroachDB"},
+		{"TCP", 22, "SSH"},
+	}
+	for _, tt := range tests {
+		got := c.Classify(Input{Transport: tt.transport, ServerPort: tt.port, ClientPort: 50000, Client: opaque})
+		if got.Protocol != tt.want || (tt.want != "" && (got.Confidence != PortOnly || got.Source != SourcePort)) {
+			t.Errorf("%s/%d = %+v, want %q from the port", tt.transport, tt.port, got, tt.want)
+		}
+	}
+	// Overrides leave the defaults alone
+	if got := DefaultPortHints().Lookup("TCP", 443, 0); got != "TLS" {
+		t.Errorf("default hint for tcp/443 = %q", got)
+	}
+}
+
+func TestLoadPortHints(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	h, err := LoadPortHints(write("hints.yaml", "tcp/9999: Redis\n\"8080\": \"\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h["tcp/9999"] != "Redis" || h["8080"] != "" || len(h) != 2 {
+		t.Errorf("hints = %v", h)
+	}
+	if _, err := LoadPortHints(write("hints.json", `{"sctp/9999": "X"}`)); err == nil {
+		t.Error("accepted an unknown transport")
+	}
+	if _, err := LoadPortHints(write("port.json", `{"70000": "X"}`)); err == nil {
+		t.Error("accepted an invalid port")
+	}
+}
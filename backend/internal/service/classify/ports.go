@@ -0,0 +1,109 @@
+package classify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PortHints maps "tcp/<port>" or "udp/<port>" to the protocol usually
+// found there. A bare "<port>" key applies to both transports.
+type PortHints map[string]string
+
+// DefaultPortHints returns the built-in table
+func DefaultPortHints() PortHints {
+	return PortHints{
+		"tcp/21": "FTP", "tcp/22": "SSH", "tcp/25": "SMTP", "tcp/587": "SMTP",
+		"tcp/80": "HTTP", "tcp/8080": "HTTP", "tcp/8000": "HTTP",
+		"tcp/110": "POP3", "tcp/143": "IMAP",
+		"tcp/443": "TLS", "tcp/465": "TLS", "tcp/636": "TLS", "tcp/993": "TLS", "tcp/995": "TLS", "tcp/8443": "TLS",
+		"53": "DNS",
+		"88": "Kerberos", "389": "LDAP", "tcp/3268": "LDAP",
+		"tcp/139": "NetBIOS", "tcp/445": "SMB", "2049": "NFS",
+		"udp/67": "DHCP", "udp/68": "DHCP", "udp/546": "DHCPv6", "udp/547": "DHCPv6", "udp/123": "NTP",
+		"udp/161": "SNMP", "udp/162": "SNMP", "udp/514": "Syslog", "udp/1900": "SSDP",
+		"tcp/1883": "MQTT", "tcp/5672": "AMQP", "tcp/9092": "Kafka",
+		"tcp/3306": "MySQL", "tcp/5432": "PostgreSQL", "tcp/6379": "Redis",
+		"tcp/3389": "RDP", "udp/443": "QUIC", "5060": "SIP",
+	}
+}
+
+// Merge returns a copy of h with the overrides applied. Overrides mapping
+// to "" delete the hint.
+func (h PortHints) Merge(overrides PortHints) PortHints {
+	out := make(PortHints, len(h)+len(overrides))
+	for k, v := range h {
+		out[k] = v
+	}
+	for k, v := range overrides {
+		k = strings.ToLower(k)
+		if v == "" {
+			delete(out, k)
+			// Clearing a bare port clears both transports
+			if !strings.Contains(k, "/") {
+				delete(out, "tcp/"+k)
+				delete(out, "udp/"+k)
+			}
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// Lookup returns the hint for the server port, else the client port
+func (h PortHints) Lookup(transport string, serverPort, clientPort uint16) string {
+	for _, port := range []uint16{serverPort, clientPort} {
+		p := strconv.Itoa(int(port))
+		if v, ok := h[strings.ToLower(transport)+"/"+p]; ok {
+			return v
+		}
+		if v, ok := h[p]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// Validate checks every key names a port, optionally with a transport
+func (h PortHints) Validate() error {
+	for k := range h {
+		port := k
+		if i := strings.IndexByte(k, '/'); i >= 0 {
+			switch strings.ToLower(k[:i]) {
+			case "tcp", "udp":
+			default:
+				return fmt.Errorf("port hint %q: transport must be tcp or udp", k)
+			}
+			port = k[i+1:]
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("port hint %q: invalid port", k)
+		}
+	}
+	return nil
+}
+
+// LoadPortHints reads port hint overrides from a JSON or YAML file
+func LoadPortHints(path string) (PortHints, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading port hints: %v", err)
+	}
+	var h PortHints
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &h)
+	default:
+		err = json.Unmarshal(data, &h)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing port hints %s: %v", path, err)
+	}
+	return h, h.Validate()
+}
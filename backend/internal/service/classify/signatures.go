@@ -0,0 +1,526 @@
+package classify
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"pcap-analyzer/internal/service/dissector/dns"
+	"pcap-analyzer/internal/service/dissector/http1"
+	"pcap-analyzer/internal/service/dissector/http2"
+	"pcap-analyzer/internal/service/dissector/mysql"
+	"pcap-analyzer/internal/service/dissector/nfs"
+	"pcap-analyzer/internal/service/dissector/postgres"
+	"pcap-analyzer/internal/service/dissector/quic"
+	"pcap-analyzer/internal/service/dissector/redis"
+	"pcap-analyzer/internal/service/dissector/sip"
+	tlsdissect "pcap-analyzer/internal/service/dissector/tls"
+)
+
+// Scores for fixed magic values, stronger than a plain header match, and
+// for loose patterns that need a port hint to be trusted
+const (
+	magic = Signature + 5
+	weak  = Heuristic - 20
+)
+
+// matcher scores how well the first client and server payloads fit one
+// protocol; 0 means no match
+type matcher struct {
+	protocol  string
+	transport string // "TCP", "UDP" or "" for both
+	match     func(client, server []byte) int
+}
+
+// sourceOf names the kind of evidence behind a matcher's score
+func sourceOf(score int) string {
+	if score >= Signature {
+		return SourceSignature
+	}
+	return SourceHeuristic
+}
+
+// matchers are tried in order; on equal scores the first wins
+var matchers = []matcher{
+	{"HTTP2", "TCP", matchHTTP2},
+	{"SSH", "TCP", matchSSH},
+	{"TLS", "TCP", matchTLS},
+	{"SIP", "", matchSIP},
+	{"SSDP", "UDP", matchSSDP},
+	{"HTTP", "TCP", matchHTTP},
+	{"SMB", "TCP", matchSMB},
+	{"NetBIOS", "TCP", matchNetBIOS},
+	{"NFS", "TCP", matchNFS},
+	{"LDAP", "", matchLDAP},
+	{"Kerberos", "", matchKerberos},
+	{"MQTT", "TCP", matchMQTT},
+	{"AMQP", "TCP", matchAMQP},
+	{"Kafka", "TCP", matchKafka},
+	{"MySQL", "TCP", matchMySQL},
+	{"PostgreSQL", "TCP", matchPostgreSQL},
+	{"Redis", "TCP", matchRedis},
+	{"RDP", "TCP", matchRDP},
+	{"SMTP", "TCP", matchSMTP},
+	{"FTP", "TCP", matchFTP},
+	{"POP3", "TCP", matchPOP3},
+	{"IMAP", "TCP", matchIMAP},
+	{"QUIC", "UDP", matchQUIC},
+	{"DHCP", "UDP", matchDHCP},
+	{"SNMP", "UDP", matchSNMP},
+	{"NTP", "UDP", matchNTP},
+	{"DNS", "UDP", matchDNS},
+	{"DNS", "TCP", matchDNSTCP},
+	{"Syslog", "UDP", matchSyslog},
+}
+
+func matchHTTP2(c, s []byte) int {
+	if bytes.HasPrefix(c, []byte(http2.Preface)) {
+		return magic
+	}
+	return 0
+}
+
+func matchSSH(c, s []byte) int {
+	if bytes.HasPrefix(c, []byte("SSH-")) || bytes.HasPrefix(s, []byte("SSH-")) {
+		return magic
+	}
+	return 0
+}
+
+func matchTLS(c, s []byte) int {
+	switch {
+	case isHello(c, 1) || isHello(s, 2):
+		return magic
+	case tlsdissect.LooksLikeTLS(c) && tlsdissect.LooksLikeTLS(s):
+		return Heuristic
+	}
+	return 0
+}
+
+// isHello reports whether b starts with a handshake record carrying a
+// message of type typ (1 ClientHello, 2 ServerHello)
+func isHello(b []byte, typ byte) bool {
+	return tlsdissect.LooksLikeTLS(b) && b[0] == tlsdissect.RecordHandshake && len(b) > 5 && b[5] == typ
+}
+
+func matchSIP(c, s []byte) int {
+	if sip.IsStart(c) || sip.IsStart(s) {
+		return magic
+	}
+	return 0
+}
+
+func matchSSDP(c, s []byte) int {
+	if bytes.HasPrefix(c, []byte("M-SEARCH * HTTP/1.1")) || bytes.HasPrefix(c, []byte("NOTIFY * HTTP/1.1")) {
+		return magic
+	}
+	return 0
+}
+
+func matchHTTP(c, s []byte) int {
+	if http1.IsResponseStart(s) {
+		return Signature
+	}
+	if !http1.IsRequestStart(c) {
+		return 0
+	}
+	line := c
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	if bytes.Contains(line, []byte(" HTTP/1.")) {
+		return Signature
+	}
+	return Heuristic
+}
+
+// matchSMB looks for an SMB1, SMB2/3 or SMB3 transform header behind the
+// 4-byte NetBIOS session framing
+func matchSMB(c, s []byte) int {
+	for _, b := range [][]byte{c, s} {
+		if len(b) >= 8 && b[0] == 0 && b[5] == 'S' && b[6] == 'M' && b[7] == 'B' &&
+			(b[4] == 0xfe || b[4] == 0xff || b[4] == 0xfd) {
+			return magic
+		}
+	}
+	return 0
+}
+
+// matchNetBIOS recognises a session request (0x81) answered positively (0x82)
+func matchNetBIOS(c, s []byte) int {
+	if len(c) < 4 || c[0] != 0x81 || int(binary.BigEndian.Uint16(c[2:])) != len(c)-4 {
+		return 0
+	}
+	if len(s) == 4 && s[0] == 0x82 {
+		return Signature
+	}
+	return Heuristic
+}
+
+// berLen reads a BER length at b, returning the content length and the
+// size of the length field
+func berLen(b []byte) (n, size int, ok bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+	if b[0] < 0x80 {
+		return int(b[0]), 1, true
+	}
+	k := int(b[0] & 0x7f)
+	if k == 0 || k > 4 || len(b) < 1+k {
+		return 0, 0, false
+	}
+	for _, x := range b[1 : 1+k] {
+		n = n<<8 | int(x)
+	}
+	return n, 1 + k, true
+}
+
+// berSequence returns the contents of the SEQUENCE at the start of b,
+// cut to what was captured
+func berSequence(b []byte) ([]byte, bool) {
+	if len(b) < 2 || b[0] != 0x30 {
+		return nil, false
+	}
+	n, size, ok := berLen(b[1:])
+	if !ok || n < 3 {
+		return nil, false
+	}
+	body := b[1+size:]
+	return body[:min(n, len(body))], true
+}
+
+// isLDAPMessage checks for an LDAPMessage: SEQUENCE { messageID INTEGER,
+// protocolOp [APPLICATION n] }
+func isLDAPMessage(b []byte) bool {
+	body, ok := berSequence(b)
+	if !ok || len(body) < 3 || body[0] != 0x02 || body[1] < 1 || body[1] > 4 || len(body) < 2+int(body[1]) {
+		return false
+	}
+	rest := body[2+int(body[1]):]
+	if len(rest) == 0 {
+		return false
+	}
+	switch op := rest[0]; {
+	case op >= 0x60 && op <= 0x79: // constructed operations, bind to intermediate
+		return true
+	case op == 0x42 || op == 0x4a || op == 0x50: // unbind, delete, abandon
+		return true
+	}
+	return false
+}
+
+func matchLDAP(c, s []byte) int {
+	if isLDAPMessage(c) {
+		if len(s) == 0 || isLDAPMessage(s) {
+			return Signature
+		}
+		return Heuristic
+	}
+	return 0
+}
+
+// Kerberos message tags: AS-REQ, AS-REP, TGS-REQ, TGS-REP, KRB-ERROR
+func isKerberos(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+	switch b[0] {
+	case 0x6a, 0x6b, 0x6c, 0x6d, 0x7e:
+	default:
+		return false
+	}
+	n, size, ok := berLen(b[1:])
+	return ok && len(b) > 1+size && n >= len(b)-1-size && b[1+size] == 0x30
+}
+
+// matchKerberos accepts messages as sent over UDP or with the 4-byte
+// record mark used over TCP
+func matchKerberos(c, s []byte) int {
+	for _, b := range [][]byte{c, s} {
+		if isKerberos(b) {
+			return Signature
+		}
+		if len(b) > 4 && int(binary.BigEndian.Uint32(b)) >= len(b)-4 && isKerberos(b[4:]) {
+			return Signature
+		}
+	}
+	return 0
+}
+
+// matchMQTT looks for a CONNECT packet naming the protocol
+func matchMQTT(c, s []byte) int {
+	if len(c) < 2 || c[0] != 0x10 {
+		return 0
+	}
+	i := 1
+	for i < len(c) && i < 5 && c[i]&0x80 != 0 {
+		i++
+	}
+	rest := c[min(i+1, len(c)):]
+	if bytes.HasPrefix(rest, []byte("\x00\x04MQTT")) || bytes.HasPrefix(rest, []byte("\x00\x06MQIsdp")) {
+		return magic
+	}
+	return 0
+}
+
+func matchAMQP(c, s []byte) int {
+	if len(c) >= 8 && bytes.HasPrefix(c, []byte("AMQP")) {
+		return magic
+	}
+	return 0
+}
+
+// matchKafka checks for a size-prefixed request header with a known API key
+// and version; a response echoing the correlation ID confirms it
+func matchKafka(c, s []byte) int {
+	if len(c) < 14 {
+		return 0
+	}
+	size := int(binary.BigEndian.Uint32(c))
+	apiKey := int16(binary.BigEndian.Uint16(c[4:]))
+	version := int16(binary.BigEndian.Uint16(c[6:]))
+	clientID := int16(binary.BigEndian.Uint16(c[12:]))
+	if size < 10 || size < len(c)-4 || size > 100<<20 || apiKey < 0 || apiKey > 80 || version < 0 || version > 20 {
+		return 0
+	}
+	if clientID < -1 || int(clientID) > size {
+		return 0
+	}
+	if clientID > 0 && len(c) >= 14+int(clientID) {
+		for _, x := range c[14 : 14+int(clientID)] {
+			if x < 0x20 || x > 0x7e {
+				return 0
+			}
+		}
+	}
+	if len(s) >= 8 && bytes.Equal(s[4:8], c[8:12]) {
+		return Signature
+	}
+	return Heuristic
+}
+
+func matchMySQL(c, s []byte) int {
+	switch {
+	case mysql.IsGreeting(s):
+		return Signature
+	case mysql.LooksLikeCommand(c):
+		return weak
+	}
+	return 0
+}
+
+func matchPostgreSQL(c, s []byte) int {
+	switch {
+	case postgres.IsStartup(c):
+		return Signature
+	case postgres.LooksLikeQuery(c):
+		return weak
+	}
+	return 0
+}
+
+func matchRedis(c, s []byte) int {
+	if redis.LooksLikeCommand(c) {
+		return Heuristic + 10
+	}
+	return 0
+}
+
+// matchNFS looks for a record-marked RPC call to the NFS program
+func matchNFS(c, s []byte) int {
+	if nfs.IsCall(c) {
+		return Signature
+	}
+	return 0
+}
+
+// matchRDP looks for an X.224 Connection Request inside a TPKT
+func matchRDP(c, s []byte) int {
+	if len(c) >= 7 && c[0] == 3 && c[1] == 0 && int(binary.BigEndian.Uint16(c[2:])) >= len(c) && c[5] == 0xe0 {
+		return Signature
+	}
+	return 0
+}
+
+// banner reports whether the server greeted with code followed by a space
+// or hyphen and mentions one of the given words
+func banner(s []byte, code string, words ...string) bool {
+	if !bytes.HasPrefix(s, []byte(code)) || len(s) <= len(code) || (s[len(code)] != ' ' && s[len(code)] != '-') {
+		return false
+	}
+	line := s
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.ToUpper(line)
+	for _, w := range words {
+		if bytes.Contains(line, []byte(w)) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCommand(c []byte, cmds ...string) bool {
+	for _, cmd := range cmds {
+		if len(c) > len(cmd) && bytes.EqualFold(c[:len(cmd)], []byte(cmd)) && (c[len(cmd)] == ' ' || c[len(cmd)] == '\r') {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSMTP(c, s []byte) int {
+	switch {
+	case banner(s, "220", "SMTP"):
+		return Signature
+	case hasCommand(c, "EHLO", "HELO"):
+		return Signature
+	}
+	return 0
+}
+
+func matchFTP(c, s []byte) int {
+	switch {
+	case banner(s, "220", "FTP"):
+		return Signature
+	case banner(s, "220", "") && hasCommand(c, "USER", "FEAT", "SYST", "OPTS"):
+		return Heuristic
+	}
+	return 0
+}
+
+func matchPOP3(c, s []byte) int {
+	switch {
+	case bytes.HasPrefix(s, []byte("+OK")) && bytes.Contains(bytes.ToUpper(s[:min(len(s), 80)]), []byte("POP")):
+		return Signature
+	case bytes.HasPrefix(s, []byte("+OK")) && hasCommand(c, "CAPA", "USER", "APOP", "STLS"):
+		return Heuristic
+	}
+	return 0
+}
+
+func matchIMAP(c, s []byte) int {
+	if bytes.HasPrefix(s, []byte("* OK")) || bytes.HasPrefix(s, []byte("* PREAUTH")) {
+		return Signature
+	}
+	return 0
+}
+
+// matchQUIC looks for a client Initial: a long header with a version the
+// dissector knows, or any version in a datagram padded to the 1200 bytes
+// clients must send
+func matchQUIC(c, s []byte) int {
+	if !quic.IsLongHeader(c) {
+		return 0
+	}
+	if quic.Known(binary.BigEndian.Uint32(c[1:])) {
+		return Signature
+	}
+	if len(c) >= 1200 {
+		return Heuristic
+	}
+	return 0
+}
+
+// matchDHCP checks the BOOTP header and the DHCP magic cookie
+func matchDHCP(c, s []byte) int {
+	if len(c) >= 240 && (c[0] == 1 || c[0] == 2) && c[1] == 1 && c[2] == 6 &&
+		binary.BigEndian.Uint32(c[236:]) == 0x63825363 {
+		return magic
+	}
+	return 0
+}
+
+// matchSNMP checks for SEQUENCE { version INTEGER (0, 1 or 3), ... } with a
+// community string and PDU for v1/v2c
+func matchSNMP(c, s []byte) int {
+	body, ok := berSequence(c)
+	if !ok || len(body) < 5 || body[0] != 0x02 || body[1] != 1 {
+		return 0
+	}
+	switch body[2] {
+	case 0, 1:
+		if body[3] != 0x04 {
+			return 0
+		}
+		n := int(body[4])
+		if len(body) > 5+n && body[5+n] >= 0xa0 && body[5+n] <= 0xa8 {
+			return Signature
+		}
+		return Heuristic
+	case 3:
+		if body[3] == 0x30 {
+			return Signature
+		}
+	}
+	return 0
+}
+
+// matchNTP checks the version and mode of the 48-byte header: a client
+// request (mode 3) answered by a server reply (mode 4) is certain
+func matchNTP(c, s []byte) int {
+	ntp := func(b []byte, mode byte) bool {
+		if len(b) < 48 {
+			return false
+		}
+		version := b[0] >> 3 & 7
+		return version >= 1 && version <= 4 && b[0]&7 == mode
+	}
+	switch {
+	case ntp(c, 3) && ntp(s, 4):
+		return Signature
+	case len(c) == 48 && (ntp(c, 3) || ntp(c, 1)):
+		return Heuristic
+	}
+	return 0
+}
+
+// isDNS checks the header counts before decoding: standard queries carry
+// one to a few questions and no answers
+func isDNS(b []byte) bool {
+	if len(b) < 12 {
+		return false
+	}
+	qd := binary.BigEndian.Uint16(b[4:])
+	an := binary.BigEndian.Uint16(b[6:])
+	opcode := b[2] >> 3 & 0xf
+	response := b[2]&0x80 != 0
+	if opcode > 5 || qd > 4 || (!response && (qd == 0 || an != 0)) {
+		return false
+	}
+	_, err := dns.Parse(b)
+	return err == nil
+}
+
+func matchDNS(c, s []byte) int {
+	switch {
+	case isDNS(c) && isDNS(s):
+		return Signature
+	case isDNS(c):
+		return Heuristic
+	}
+	return 0
+}
+
+func matchDNSTCP(c, s []byte) int {
+	if len(c) > 2 && int(binary.BigEndian.Uint16(c)) == len(c)-2 && isDNS(c[2:]) {
+		return Heuristic
+	}
+	return 0
+}
+
+// matchSyslog looks for the <PRI> prefix of a syslog message
+func matchSyslog(c, s []byte) int {
+	if len(c) < 4 || c[0] != '<' {
+		return 0
+	}
+	i := 1
+	for i < len(c) && i <= 4 && c[i] >= '0' && c[i] <= '9' {
+		i++
+	}
+	if i > 1 && i < len(c) && c[i] == '>' {
+		return Heuristic
+	}
+	return 0
+}
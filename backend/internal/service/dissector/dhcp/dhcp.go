@@ -0,0 +1,140 @@
+// Package dhcp decodes DHCP (BOOTP with the DHCP magic cookie) and DHCPv6
+// messages: the message type, transaction ID, client and server
+// identifiers, the address handed out and the reason for a refusal.
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// DHCP message types (option 53)
+const (
+	Discover = 1
+	Offer    = 2
+	Request  = 3
+	Decline  = 4
+	Ack      = 5
+	Nak      = 6
+	Release  = 7
+	Inform   = 8
+)
+
+var typeNames = map[int]string{
+	Discover: "DISCOVER", Offer: "OFFER", Request: "REQUEST", Decline: "DECLINE",
+	Ack: "ACK", Nak: "NAK", Release: "RELEASE", Inform: "INFORM",
+}
+
+// Ports are the server and client ports of DHCP and DHCPv6
+const (
+	ServerPort   = 67
+	ClientPort   = 68
+	ServerPortV6 = 547
+	ClientPortV6 = 546
+)
+
+const (
+	bootpLen    = 236
+	magicCookie = 0x63825363
+)
+
+// Message is a decoded DHCP or DHCPv6 message
+type Message struct {
+	Version     int // 4 or 6
+	Type        int
+	XID         uint32
+	Client      string // hardware address, or the DHCPv6 client DUID in hex
+	Server      string // server identifier: an address, or the DHCPv6 server DUID in hex
+	YourIP      string // address offered or acknowledged (DHCP only)
+	RequestedIP string // DHCP only
+	Relayed     bool   // passed through a relay agent
+	Status      int    // DHCPv6 status code, 0 on success
+	Text        string // NAK message or DHCPv6 status message
+}
+
+// TypeName returns the name of the message type
+func (m *Message) TypeName() string {
+	names := typeNames
+	if m.Version == 6 {
+		names = typeNamesV6
+	}
+	if name, ok := names[m.Type]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE-%d", m.Type)
+}
+
+// FromClient reports whether the message is sent by a client
+func (m *Message) FromClient() bool {
+	if m.Version == 6 {
+		return m.Type != Advertise && m.Type != Reply && m.Type != Reconfigure
+	}
+	return m.Type != Offer && m.Type != Ack && m.Type != Nak
+}
+
+// Failed reports a refusal: a NAK, or a DHCPv6 reply with an error status
+func (m *Message) Failed() bool {
+	if m.Version == 6 {
+		return m.Status != 0
+	}
+	return m.Type == Nak
+}
+
+// Parse decodes a DHCP message from a UDP payload. BOOTP messages without
+// a DHCP message type are not returned.
+func Parse(data []byte) *Message {
+	if len(data) < bootpLen+4 || data[0] < 1 || data[0] > 2 || binary.BigEndian.Uint32(data[bootpLen:]) != magicCookie {
+		return nil
+	}
+	m := &Message{
+		Version: 4,
+		XID:     binary.BigEndian.Uint32(data[4:]),
+		YourIP:  addr4(data[16:20]),
+		Relayed: binary.BigEndian.Uint32(data[24:]) != 0,
+	}
+	if hlen := int(data[2]); hlen > 0 && hlen <= 16 {
+		m.Client = net.HardwareAddr(data[28 : 28+hlen]).String()
+	}
+
+	opts := data[bootpLen+4:]
+	for len(opts) > 0 {
+		code := opts[0]
+		if code == 0 {
+			opts = opts[1:]
+			continue
+		}
+		if code == 255 || len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			break
+		}
+		val := opts[2 : 2+int(opts[1])]
+		opts = opts[2+len(val):]
+		switch code {
+		case 53:
+			if len(val) == 1 {
+				m.Type = int(val[0])
+			}
+		case 54:
+			if len(val) == 4 {
+				m.Server = addr4(val)
+			}
+		case 50:
+			if len(val) == 4 {
+				m.RequestedIP = addr4(val)
+			}
+		case 56:
+			m.Text = string(val)
+		}
+	}
+	if m.Type == 0 {
+		return nil
+	}
+	return m
+}
+
+func addr4(b []byte) string {
+	if binary.BigEndian.Uint32(b) == 0 {
+		return ""
+	}
+	return net.IP(b).String()
+}
@@ -0,0 +1,159 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// bootp builds a DHCP message from op, the relay agent address and its
+// options, with a fixed transaction ID, client MAC and offered address
+func bootp(op byte, giaddr byte, opts ...[]byte) []byte {
+	b := make([]byte, bootpLen, bootpLen+64)
+	b[0], b[1], b[2] = op, 1, 6
+	binary.BigEndian.PutUint32(b[4:], 0xdeadbeef)
+	if op == 2 {
+		copy(b[16:], []byte{192, 168, 1, 10})
+	}
+	b[27] = giaddr
+	copy(b[28:], []byte{0x02, 0, 0, 0, 0, 0x01})
+	b = binary.BigEndian.AppendUint32(b, magicCookie)
+	for _, o := range opts {
+		b = append(b, o...)
+	}
+	return append(b, 255)
+}
+
+func opt(code byte, val ...byte) []byte {
+	return append([]byte{code, byte(len(val))}, val...)
+}
+
+func opt6(code uint16, val ...[]byte) []byte {
+	v := bytes.Join(val, nil)
+	b := binary.BigEndian.AppendUint16(nil, code)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+	return append(b, v...)
+}
+
+func msg6(typ byte, opts ...[]byte) []byte {
+	return append([]byte{typ, 0x12, 0x34, 0x56}, bytes.Join(opts, nil)...)
+}
+
+var (
+	clientDUID = []byte{0, 3, 0, 1, 0x02, 0, 0, 0, 0, 0x01}
+	serverDUID = []byte{0, 1, 0, 1, 0xaa, 0xbb}
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Message
+	}{
+		{
+			name: "request",
+			data: bootp(1, 0, opt(53, Request), opt(50, 192, 168, 1, 10), opt(54, 192, 168, 1, 1)),
+			want: Message{Version: 4, Type: Request, XID: 0xdeadbeef, Client: "02:00:00:00:00:01", Server: "192.168.1.1", RequestedIP: "192.168.1.10"},
+		},
+		{
+			name: "relayed ack with padding",
+			data: bootp(2, 1, []byte{0, 0}, opt(53, Ack), opt(54, 192, 168, 1, 1)),
+			want: Message{Version: 4, Type: Ack, XID: 0xdeadbeef, Client: "02:00:00:00:00:01", Server: "192.168.1.1", YourIP: "192.168.1.10", Relayed: true},
+		},
+		{
+			name: "nak",
+			data: bootp(2, 0, opt(53, Nak), opt(56, []byte("wrong network")...)),
+			want: Message{Version: 4, Type: Nak, XID: 0xdeadbeef, Client: "02:00:00:00:00:01", YourIP: "192.168.1.10", Text: "wrong network"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Parse(tt.data)
+			if m == nil || *m != tt.want {
+				t.Errorf("Parse = %+v, want %+v", m, tt.want)
+			}
+		})
+	}
+
+	if Parse(bootp(1, 0)) != nil {
+		t.Error("BOOTP message without a DHCP type parsed")
+	}
+	if m := Parse(bootp(2, 0, opt(53, Nak))); !m.Failed() || m.FromClient() || m.TypeName() != "NAK" {
+		t.Errorf("NAK = %+v", m)
+	}
+}
+
+func TestParseV6(t *testing.T) {
+	noAddrs := append([]byte{0, 2}, "no addresses"...)
+	iaNA := append(make([]byte, 12), opt6(13, noAddrs)...)
+	reply := msg6(Reply, opt6(1, clientDUID), opt6(2, serverDUID), opt6(3, iaNA))
+	relay := append([]byte{RelayReply, 0}, make([]byte, 32)...)
+	relay = append(relay, opt6(9, reply)...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want Message
+	}{
+		{
+			name: "solicit",
+			data: msg6(Solicit, opt6(1, clientDUID), opt6(8, []byte{0, 0})),
+			want: Message{Version: 6, Type: Solicit, XID: 0x123456, Client: "00030001020000000001"},
+		},
+		{
+			name: "status inside IA_NA",
+			data: reply,
+			want: Message{Version: 6, Type: Reply, XID: 0x123456, Client: "00030001020000000001", Server: "00010001aabb", Status: 2, Text: "no addresses"},
+		},
+		{
+			name: "relayed",
+			data: relay,
+			want: Message{Version: 6, Type: Reply, XID: 0x123456, Client: "00030001020000000001", Server: "00010001aabb", Status: 2, Text: "no addresses", Relayed: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := ParseV6(tt.data)
+			if m == nil || *m != tt.want {
+				t.Errorf("ParseV6 = %+v, want %+v", m, tt.want)
+			}
+		})
+	}
+
+	// Relays nested past the hop limit are refused
+	nested := reply
+	for i := 0; i <= maxRelayDepth; i++ {
+		nested = append(append([]byte{RelayForward, byte(i)}, make([]byte, 32)...), opt6(9, nested)...)
+	}
+	if m := ParseV6(nested); m != nil {
+		t.Errorf("ParseV6 of %d nested relays = %+v", maxRelayDepth+1, m)
+	}
+}
+
+// Every prefix of a message must parse without panicking
+func TestTruncated(t *testing.T) {
+	v4 := bootp(2, 1, opt(53, Nak), opt(54, 10, 0, 0, 1), opt(56, []byte("no")...))
+	for i := 0; i <= len(v4); i++ {
+		Parse(v4[:i])
+	}
+	iaNA := append(make([]byte, 12), opt6(13, []byte{0, 2})...)
+	v6 := append(append([]byte{RelayForward, 0}, make([]byte, 32)...), opt6(9, msg6(Reply, opt6(1, clientDUID), opt6(3, iaNA)))...)
+	for i := 0; i <= len(v6); i++ {
+		ParseV6(v6[:i])
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(bootp(1, 0, opt(53, Discover), opt(50, 10, 0, 0, 2)))
+	f.Add(bootp(2, 1, opt(53, Nak), opt(56, []byte("no")...)))
+	f.Add(msg6(Reply, opt6(1, clientDUID), opt6(13, []byte{0, 1})))
+	f.Add(append(append([]byte{RelayForward, 0}, make([]byte, 32)...), opt6(9, msg6(Solicit))...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, m := range []*Message{Parse(data), ParseV6(data)} {
+			if m != nil {
+				m.TypeName()
+				m.FromClient()
+			}
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+)
+
+// DHCPv6 message types (RFC 8415)
+const (
+	Solicit            = 1
+	Advertise          = 2
+	RequestV6          = 3
+	Confirm            = 4
+	Renew              = 5
+	Rebind             = 6
+	Reply              = 7
+	ReleaseV6          = 8
+	DeclineV6          = 9
+	Reconfigure        = 10
+	InformationRequest = 11
+	RelayForward       = 12
+	RelayReply         = 13
+)
+
+var typeNamesV6 = map[int]string{
+	Solicit: "SOLICIT", Advertise: "ADVERTISE", RequestV6: "REQUEST", Confirm: "CONFIRM",
+	Renew: "RENEW", Rebind: "REBIND", Reply: "REPLY", ReleaseV6: "RELEASE", DeclineV6: "DECLINE",
+	Reconfigure: "RECONFIGURE", InformationRequest: "INFORMATION-REQUEST",
+}
+
+var statusNames = map[int]string{
+	0: "Success",
+	1: "UnspecFail",
+	2: "NoAddrsAvail",
+	3: "NoBinding",
+	4: "NotOnLink",
+	5: "UseMulticast",
+	6: "NoPrefixAvail",
+}
+
+// StatusName returns the name of a DHCPv6 status code
+func StatusName(code int) string {
+	if name, ok := statusNames[code]; ok {
+		return name
+	}
+	return "status " + strconv.Itoa(code)
+}
+
+// maxRelayDepth bounds the relay messages unwrapped (RFC 8415 HOP_COUNT_LIMIT)
+const maxRelayDepth = 8
+
+// ParseV6 decodes a DHCPv6 message from a UDP payload, unwrapping relay
+// messages to the client message they carry
+func ParseV6(data []byte) *Message {
+	relayed := false
+	for depth := 0; len(data) > 0 && (data[0] == RelayForward || data[0] == RelayReply); depth++ {
+		if depth == maxRelayDepth || len(data) < 34 {
+			return nil
+		}
+		inner, ok := options6(data[34:])[9]
+		if !ok {
+			return nil
+		}
+		data, relayed = inner, true
+	}
+	if len(data) < 4 || typeNamesV6[int(data[0])] == "" {
+		return nil
+	}
+
+	m := &Message{
+		Version: 6,
+		Type:    int(data[0]),
+		XID:     uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3]),
+		Relayed: relayed,
+	}
+	opts := options6(data[4:])
+	m.Client = hex.EncodeToString(opts[1])
+	m.Server = hex.EncodeToString(opts[2])
+	if st, ok := opts[13]; ok {
+		m.setStatus(st)
+	}
+	// Address and prefix failures are reported inside IA_NA and IA_PD
+	for _, code := range []uint16{3, 25} {
+		if ia, ok := opts[code]; ok && len(ia) >= 12 && m.Status == 0 {
+			if st, ok := options6(ia[12:])[13]; ok {
+				m.setStatus(st)
+			}
+		}
+	}
+	return m
+}
+
+func (m *Message) setStatus(opt []byte) {
+	if len(opt) < 2 {
+		return
+	}
+	m.Status = int(binary.BigEndian.Uint16(opt))
+	m.Text = string(opt[2:])
+}
+
+// options6 indexes a DHCPv6 option list by code, keeping the first of each
+func options6(b []byte) map[uint16][]byte {
+	opts := map[uint16][]byte{}
+	for len(b) >= 4 {
+		code, n := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+n {
+			break
+		}
+		if _, ok := opts[code]; !ok {
+			opts[code] = b[4 : 4+n]
+		}
+		b = b[4+n:]
+	}
+	return opts
+}
@@ -0,0 +1,148 @@
+// Package dns decodes DNS messages carried over UDP datagrams or
+// length-prefixed TCP streams.
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Response codes reported by the analyzer
+const (
+	RCodeNoError  = 0
+	RCodeServFail = 2
+	RCodeNXDomain = 3
+)
+
+// headerLen is the size of the fixed DNS header
+const headerLen = 12
+
+// Message is a decoded DNS message
+type Message struct {
+	ID        uint16
+	Response  bool
+	RCode     int
+	Truncated bool
+	Name      string // first question
+	Type      string // first question type, e.g. "A"
+	Answers   []string
+	MinTTL    uint32 // smallest answer TTL, 0 if no answers
+	Size      int
+
+	// Offset is the position of the message in the flow data (TCP only)
+	Offset int
+}
+
+var rcodeNames = map[int]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+// RCodeName returns the mnemonic of a response code
+func RCodeName(rcode int) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// Parse decodes one DNS message
+func Parse(data []byte) (msg *Message, err error) {
+	if len(data) < headerLen {
+		return nil, fmt.Errorf("dns message of %d bytes is shorter than its header", len(data))
+	}
+	// gopacket's decoder panics on some malformed records; one bad packet
+	// must not take down the whole analysis
+	defer func() {
+		if r := recover(); r != nil {
+			msg, err = nil, fmt.Errorf("malformed dns message: %v", r)
+		}
+	}()
+
+	var d layers.DNS
+	if err := d.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return nil, err
+	}
+	if len(d.Questions) == 0 && !d.QR {
+		return nil, fmt.Errorf("query without question")
+	}
+
+	msg = &Message{
+		ID:        d.ID,
+		Response:  d.QR,
+		RCode:     int(d.ResponseCode),
+		Truncated: d.TC,
+		Size:      len(data),
+	}
+	if len(d.Questions) > 0 {
+		msg.Name = string(d.Questions[0].Name)
+		msg.Type = d.Questions[0].Type.String()
+	}
+	for i, rr := range d.Answers {
+		msg.Answers = append(msg.Answers, rr.Type.String()+" "+rdata(rr))
+		if i == 0 || rr.TTL < msg.MinTTL {
+			msg.MinTTL = rr.TTL
+		}
+	}
+	return msg, nil
+}
+
+func rdata(rr layers.DNSResourceRecord) string {
+	switch rr.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		return rr.IP.String()
+	case layers.DNSTypeCNAME:
+		return string(rr.CNAME)
+	case layers.DNSTypeNS:
+		return string(rr.NS)
+	case layers.DNSTypePTR:
+		return string(rr.PTR)
+	case layers.DNSTypeMX:
+		return fmt.Sprintf("%d %s", rr.MX.Preference, rr.MX.Name)
+	case layers.DNSTypeSRV:
+		return fmt.Sprintf("%d %d %d %s", rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port, rr.SRV.Name)
+	case layers.DNSTypeTXT:
+		parts := make([]string, 0, len(rr.TXTs))
+		for _, t := range rr.TXTs {
+			parts = append(parts, string(t))
+		}
+		return strings.Join(parts, " ")
+	case layers.DNSTypeSOA:
+		return string(rr.SOA.MName)
+	}
+	return fmt.Sprintf("(%d bytes)", len(rr.Data))
+}
+
+// ParseTCP decodes the length-prefixed messages of a reassembled TCP flow,
+// stopping at the first incomplete or undecodable one.
+func ParseTCP(data []byte) []*Message {
+	var msgs []*Message
+	pos := 0
+	for pos+2 <= len(data) {
+		n := int(binary.BigEndian.Uint16(data[pos:]))
+		if n == 0 || pos+2+n > len(data) {
+			break
+		}
+		msg, err := Parse(data[pos+2 : pos+2+n])
+		if err != nil {
+			break
+		}
+		msg.Offset = pos
+		msgs = append(msgs, msg)
+		pos += 2 + n
+	}
+	return msgs
+}
@@ -0,0 +1,127 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func response(t testing.TB) []byte {
+	t.Helper()
+	d := &layers.DNS{
+		ID: 0x1234, QR: true, RD: true, RA: true,
+		Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte("example.com"), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 300, CNAME: []byte("web.example.com")},
+			{Name: []byte("web.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.IPv4(192, 0, 2, 1)},
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := d.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	msg, err := Parse(response(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 0x1234 || !msg.Response || msg.Name != "example.com" || msg.Type != "A" {
+		t.Errorf("message = %+v", msg)
+	}
+	if len(msg.Answers) != 2 || msg.Answers[1] != "A 192.0.2.1" || msg.MinTTL != 60 {
+		t.Errorf("answers = %v with min TTL %d, want a CNAME and A 192.0.2.1 with 60", msg.Answers, msg.MinTTL)
+	}
+}
+
+func TestParseErrorAndTruncated(t *testing.T) {
+	d := &layers.DNS{
+		ID: 9, QR: true, TC: true, ResponseCode: layers.DNSResponseCodeNXDomain,
+		Questions: []layers.DNSQuestion{{Name: []byte("missing.example.com"), Type: layers.DNSTypeAAAA, Class: layers.DNSClassIN}},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := d.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	msg, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.RCode != RCodeNXDomain || RCodeName(msg.RCode) != "NXDOMAIN" || !msg.Truncated || msg.Type != "AAAA" || len(msg.Answers) != 0 {
+		t.Errorf("message = %+v", msg)
+	}
+
+	// Over TCP each message keeps its offset in the flow
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(data)))
+	framed = append(framed, data...)
+	framed = append(framed, framed...)
+	msgs := ParseTCP(framed)
+	if len(msgs) != 2 || msgs[0].Offset != 0 || msgs[1].Offset != 2+len(data) || msgs[1].RCode != RCodeNXDomain {
+		t.Errorf("TCP messages = %+v", msgs)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	header := func(qd, an uint16) []byte {
+		b := make([]byte, headerLen)
+		binary.BigEndian.PutUint16(b[0:], 1)
+		b[2] = 0x80 // response
+		binary.BigEndian.PutUint16(b[4:], qd)
+		binary.BigEndian.PutUint16(b[6:], an)
+		return b
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", []byte{0, 1, 0x80}},
+		{"missing question", header(1, 0)},
+		{"missing answers", header(0, 5)},
+		{"compression loop", append(header(1, 0), 0xc0, headerLen, 0, 1, 0, 1)},
+		{"label past end", append(header(1, 0), 63, 'a')},
+		{"rdata past end", append(header(0, 1), 0, 0, 1, 0, 1, 0, 0, 0, 60, 0xff, 0xff, 1, 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg, err := Parse(tt.data); err == nil {
+				t.Errorf("Parse = %+v, want an error", msg)
+			}
+		})
+	}
+}
+
+// Every prefix of a message must fail cleanly or parse, over UDP and TCP
+func TestTruncated(t *testing.T) {
+	data := response(t)
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(data)))
+	framed = append(framed, data...)
+	framed = append(framed, framed...)
+	for i := 0; i <= len(framed); i++ {
+		if i <= len(data) {
+			Parse(data[:i])
+		}
+		if msgs := ParseTCP(framed[:i]); len(msgs) > 2 {
+			t.Fatalf("%d messages from two", len(msgs))
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	data := response(f)
+	f.Add(data)
+	f.Add(append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if msg, err := Parse(data); err == nil && msg.Size != len(data) {
+			t.Fatalf("size = %d, want %d", msg.Size, len(data))
+		}
+		ParseTCP(data)
+	})
+}
@@ -0,0 +1,322 @@
+// Package http1 parses HTTP/1.0 and HTTP/1.1 messages out of reassembled
+// TCP flows. It handles pipelining, keep-alive, chunked transfer encoding
+// and close-delimited bodies, and frames bodies across lost segments.
+package http1
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// maxBody bounds the body and chunk sizes framed with Flow.Skip so its
+// offsets can't overflow; no capture holds that much of one message
+const maxBody = 1 << 50
+
+var methods = []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+// Request is one parsed request. Offsets index the client flow data.
+type Request struct {
+	Method        string
+	URI           string
+	Version       string
+	Host          string
+	ContentLength int64 // body bytes, -1 if unknown
+	Start         int
+	HeaderEnd     int
+	End           int
+	Complete      bool
+	// AfterLoss is set when bytes before the request were skipped or
+	// lost, so earlier requests may be missing
+	AfterLoss bool
+	bodyGap   bool // the body ran through a gap at End
+}
+
+// Response is one parsed response. Offsets index the server flow data.
+type Response struct {
+	Version       string
+	StatusCode    int
+	Reason        string
+	ContentLength int64
+	Start         int
+	HeaderEnd     int
+	End           int
+	Complete      bool // false if the data ended before the body did
+	// AfterLoss is set when bytes before the response were skipped or
+	// lost, so earlier responses may be missing
+	AfterLoss bool
+	bodyGap   bool // the body ran through a gap at End
+}
+
+// IsRequestStart reports whether data begins with an HTTP/1.x request line
+func IsRequestStart(data []byte) bool {
+	for _, m := range methods {
+		if len(data) > len(m) && string(data[:len(m)]) == m && data[len(m)] == ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// IsResponseStart reports whether data begins with an HTTP/1.x status line
+func IsResponseStart(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("HTTP/1."))
+}
+
+// ParseRequests returns the requests found in the client's byte stream.
+// Bodies are framed across gaps in the flow; after a malformed message or
+// a gap that swallowed a message boundary it resynchronizes on the next
+// request line.
+func ParseRequests(flow *reassembly.Flow) []Request {
+	var reqs []Request
+	// lossFrom is where a gap means a lost message: one at expected may
+	// have been crossed by the previous body
+	pos, expected, lossFrom := 0, 0, 0
+	for pos < len(flow.Data) {
+		if !IsRequestStart(flow.Contiguous(pos)) {
+			pos = resync(flow, pos, IsRequestStart)
+			continue
+		}
+
+		req, ok := parseRequest(flow, pos)
+		if !ok {
+			pos++
+			continue
+		}
+		req.AfterLoss = pos != expected || flow.HasGap(lossFrom, pos+1)
+		reqs = append(reqs, req)
+		pos, expected, lossFrom = req.End, req.End, req.End
+		if req.bodyGap {
+			lossFrom++
+		}
+	}
+	return reqs
+}
+
+func parseRequest(flow *reassembly.Flow, start int) (Request, bool) {
+	req := Request{Start: start, ContentLength: 0}
+
+	line, headers, headLen, ok := splitHead(flow.Contiguous(start))
+	if !ok {
+		return req, false
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/1.") {
+		return req, false
+	}
+	req.Method, req.URI, req.Version = parts[0], parts[1], parts[2]
+	req.Host = headers["host"]
+	req.HeaderEnd = start + headLen
+
+	// Requests without Content-Length or chunked encoding have no body
+	switch {
+	case strings.Contains(strings.ToLower(headers["transfer-encoding"]), "chunked"):
+		end, size, ok := chunkedEnd(flow, req.HeaderEnd)
+		req.ContentLength = size
+		req.End, req.Complete = end, ok
+	case headers["content-length"] != "":
+		n, err := strconv.ParseInt(headers["content-length"], 10, 64)
+		if err != nil || n < 0 {
+			return req, false
+		}
+		req.ContentLength = n
+		req.End, req.Complete = fixedEnd(flow, start, headLen, n)
+		req.bodyGap = req.Complete && flow.HasGap(req.End, req.End+1)
+	default:
+		req.End, req.Complete = req.HeaderEnd, true
+	}
+	return req, true
+}
+
+// ParseResponses returns the responses in the server's byte stream. The
+// requests are needed because a HEAD response has headers but no body.
+// closed tells whether the server ended the connection, which completes a
+// close-delimited body.
+func ParseResponses(flow *reassembly.Flow, reqs []Request, closed bool) []Response {
+	var resps []Response
+	// lossFrom is where a gap means a lost message: one at expected may
+	// have been crossed by the previous body
+	pos, expected, lossFrom := 0, 0, 0
+	reqIdx := 0
+	for pos < len(flow.Data) {
+		if !IsResponseStart(flow.Contiguous(pos)) {
+			pos = resync(flow, pos, IsResponseStart)
+			continue
+		}
+
+		method := ""
+		if reqIdx < len(reqs) {
+			method = reqs[reqIdx].Method
+		}
+		resp, ok := parseResponse(flow, pos, method, closed)
+		if !ok {
+			pos++
+			continue
+		}
+		resp.AfterLoss = pos != expected || flow.HasGap(lossFrom, pos+1)
+		resps = append(resps, resp)
+		// Interim 1xx responses precede the final one for the same request
+		if resp.StatusCode >= 200 {
+			reqIdx++
+		}
+		pos, expected, lossFrom = resp.End, resp.End, resp.End
+		if resp.bodyGap {
+			lossFrom++
+		}
+	}
+	return resps
+}
+
+func parseResponse(flow *reassembly.Flow, start int, method string, closed bool) (Response, bool) {
+	resp := Response{Start: start}
+
+	line, headers, headLen, ok := splitHead(flow.Contiguous(start))
+	if !ok {
+		return resp, false
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return resp, false
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || code < 100 || code > 999 {
+		return resp, false
+	}
+	resp.Version, resp.StatusCode = parts[0], code
+	if len(parts) == 3 {
+		resp.Reason = parts[2]
+	}
+	resp.HeaderEnd = start + headLen
+
+	switch {
+	case code < 200 || code == 204 || code == 304 || method == "HEAD":
+		resp.End, resp.Complete = resp.HeaderEnd, true
+	case strings.Contains(strings.ToLower(headers["transfer-encoding"]), "chunked"):
+		end, size, ok := chunkedEnd(flow, resp.HeaderEnd)
+		resp.ContentLength = size
+		resp.End, resp.Complete = end, ok
+	case headers["content-length"] != "":
+		n, err := strconv.ParseInt(headers["content-length"], 10, 64)
+		if err != nil || n < 0 {
+			return resp, false
+		}
+		resp.ContentLength = n
+		resp.End, resp.Complete = fixedEnd(flow, start, headLen, n)
+		resp.bodyGap = resp.Complete && flow.HasGap(resp.End, resp.End+1)
+	default:
+		// Body runs until the server closes the connection
+		resp.ContentLength = int64(len(flow.Data) - resp.HeaderEnd)
+		resp.End, resp.Complete = len(flow.Data), closed
+	}
+	return resp, true
+}
+
+// splitHead parses the start line and headers at the beginning of data and
+// returns the length of the head. Header names are lowercased.
+func splitHead(data []byte) (string, map[string]string, int, bool) {
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	sepLen := 4
+	if end < 0 {
+		end = bytes.Index(data, []byte("\n\n"))
+		sepLen = 2
+	}
+	if end < 0 {
+		return "", nil, 0, false
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(data[:end]), "\r\n", "\n"), "\n")
+	headers := make(map[string]string, len(lines)-1)
+	for _, l := range lines[1:] {
+		name, value, found := strings.Cut(l, ":")
+		if !found {
+			continue
+		}
+		headers[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return lines[0], headers, end + sepLen, true
+}
+
+// fixedEnd returns where a message starting at start with a head of headLen
+// bytes and an n byte body ends, counting body bytes lost in gaps. A body
+// running past the capture ends the message at the end of the data.
+func fixedEnd(flow *reassembly.Flow, start, headLen int, n int64) (int, bool) {
+	if n > maxBody {
+		return len(flow.Data), false
+	}
+	return skip(flow, start, int64(headLen)+n)
+}
+
+// skip frames n stream bytes from start, which must be the start of data
+// already parsed, and clamps a position past the capture to its end
+func skip(flow *reassembly.Flow, start int, n int64) (int, bool) {
+	end, ok := flow.Skip(start, n)
+	return min(end, len(flow.Data)), ok
+}
+
+// chunkedEnd walks a chunked body and returns where it ends and its decoded
+// size. Chunk data may span gaps; chunk size lines may not.
+func chunkedEnd(flow *reassembly.Flow, pos int) (int, int64, bool) {
+	var size int64
+	for {
+		line := flow.Contiguous(pos)
+		lineEnd := bytes.Index(line, []byte("\r\n"))
+		if lineEnd < 0 {
+			return min(pos+len(line), len(flow.Data)), size, false
+		}
+		sizeField, _, _ := strings.Cut(string(line[:lineEnd]), ";")
+		n, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || n < 0 {
+			return pos, size, false
+		}
+
+		if n == 0 {
+			// Optional trailers end with an empty line
+			pos += lineEnd + 2
+			trailers := flow.Contiguous(pos)
+			trailerEnd := bytes.Index(trailers, []byte("\r\n"))
+			for trailerEnd > 0 {
+				pos += trailerEnd + 2
+				trailers = trailers[trailerEnd+2:]
+				trailerEnd = bytes.Index(trailers, []byte("\r\n"))
+			}
+			if trailerEnd < 0 {
+				return min(pos+len(trailers), len(flow.Data)), size, false
+			}
+			return pos + 2, size, true
+		}
+
+		// Compared without adding to pos, which a size near MaxInt64 overflows
+		if n > math.MaxInt64-size {
+			return len(flow.Data), size, false
+		}
+		size += n
+		if n > maxBody {
+			return len(flow.Data), size, false
+		}
+		end, ok := skip(flow, pos, int64(lineEnd+2)+n+2)
+		if !ok {
+			return end, size, false
+		}
+		pos = end
+	}
+}
+
+// resync finds the next message start at a line start or right after a gap
+func resync(flow *reassembly.Flow, from int, isStart func([]byte) bool) int {
+	data, gaps := flow.Data, flow.Gaps
+	g := sort.Search(len(gaps), func(k int) bool { return gaps[k].Offset >= from })
+	for i := from; i < len(data); i++ {
+		for g < len(gaps) && gaps[g].Offset < i {
+			g++
+		}
+		afterGap := g < len(gaps) && gaps[g].Offset == i
+		if (i == 0 || data[i-1] == '\n' || afterGap) && isStart(flow.Contiguous(i)) {
+			return i
+		}
+	}
+	return len(data)
+}
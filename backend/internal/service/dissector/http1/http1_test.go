@@ -0,0 +1,247 @@
+package http1
+
+import (
+	"testing"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flow wraps data in a single-chunk flow with the given gaps
+func flow(data string, gaps ...reassembly.Gap) *reassembly.Flow {
+	return &reassembly.Flow{
+		Data:   []byte(data),
+		Chunks: []reassembly.Chunk{{Len: len(data)}},
+		Gaps:   gaps,
+	}
+}
+
+func TestParseRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		methods  []string
+		lengths  []int64
+		complete bool // of the last request
+	}{
+		{
+			name:     "content-length",
+			data:     "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello",
+			methods:  []string{"POST"},
+			lengths:  []int64{5},
+			complete: true,
+		},
+		{
+			name:     "chunked with trailer",
+			data:     "POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n3;ext=1\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\n",
+			methods:  []string{"POST"},
+			lengths:  []int64{8},
+			complete: true,
+		},
+		{
+			name:     "pipelined",
+			data:     "GET /a HTTP/1.1\r\n\r\nPOST /b HTTP/1.1\r\nContent-Length: 2\r\n\r\nhiHEAD /c HTTP/1.1\r\n\r\n",
+			methods:  []string{"GET", "POST", "HEAD"},
+			lengths:  []int64{0, 2, 0},
+			complete: true,
+		},
+		{
+			name:     "truncated body",
+			data:     "POST /a HTTP/1.1\r\nContent-Length: 10\r\n\r\nhello",
+			methods:  []string{"POST"},
+			lengths:  []int64{10},
+			complete: false,
+		},
+		{
+			name:     "truncated chunk",
+			data:     "POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\na\r\nhello",
+			methods:  []string{"POST"},
+			lengths:  []int64{10},
+			complete: false,
+		},
+		{
+			name:     "huge chunk size",
+			data:     "POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n7ffffffffffffffe\r\nhello\r\n",
+			methods:  []string{"POST"},
+			lengths:  []int64{0x7ffffffffffffffe},
+			complete: false,
+		},
+		{
+			name:     "huge content-length",
+			data:     "POST /a HTTP/1.1\r\nContent-Length: 9223372036854775807\r\n\r\nhello",
+			methods:  []string{"POST"},
+			lengths:  []int64{9223372036854775807},
+			complete: false,
+		},
+		{
+			name: "headers not finished",
+			data: "GET /a HTTP/1.1\r\nHost: x\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs := ParseRequests(flow(tt.data))
+			if len(reqs) != len(tt.methods) {
+				t.Fatalf("got %d requests, want %d", len(reqs), len(tt.methods))
+			}
+			for i, req := range reqs {
+				if req.Method != tt.methods[i] || req.ContentLength != tt.lengths[i] {
+					t.Errorf("request %d = %s with %d body bytes, want %s with %d", i, req.Method, req.ContentLength, tt.methods[i], tt.lengths[i])
+				}
+				if req.End < req.Start || req.End > len(tt.data) {
+					t.Errorf("request %d ends at %d, outside the data", i, req.End)
+				}
+				if req.AfterLoss {
+					t.Errorf("request %d marked after a loss", i)
+				}
+			}
+			if len(reqs) > 0 && reqs[len(reqs)-1].Complete != tt.complete {
+				t.Errorf("complete = %v, want %v", reqs[len(reqs)-1].Complete, tt.complete)
+			}
+		})
+	}
+}
+
+func TestParseRequestsGaps(t *testing.T) {
+	type want struct {
+		uri       string
+		length    int64
+		complete  bool
+		afterLoss bool
+	}
+	tests := []struct {
+		name string
+		data string
+		gaps []reassembly.Gap
+		want []want
+	}{
+		{
+			// "hel" + 4 lost + "lo!" makes the 10 body bytes
+			name: "body spans a gap",
+			data: "POST /a HTTP/1.1\r\nContent-Length: 10\r\n\r\nhello!GET /b HTTP/1.1\r\n\r\n",
+			gaps: []reassembly.Gap{{Offset: 43, Missing: 4}},
+			want: []want{{"/a", 10, true, false}, {"/b", 0, true, false}},
+		},
+		{
+			name: "body lost right after the head",
+			data: "POST /a HTTP/1.1\r\nContent-Length: 5\r\n\r\nGET /b HTTP/1.1\r\n\r\n",
+			gaps: []reassembly.Gap{{Offset: 39, Missing: 5}},
+			want: []want{{"/a", 5, true, false}, {"/b", 0, true, false}},
+		},
+		{
+			// "hel" + 5 lost + "lo" makes the 10 byte chunk
+			name: "chunk spans a gap",
+			data: "POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\na\r\nhello\r\n0\r\n\r\nGET /b HTTP/1.1\r\n\r\n",
+			gaps: []reassembly.Gap{{Offset: 54, Missing: 5}},
+			want: []want{{"/a", 10, true, false}, {"/b", 0, true, false}},
+		},
+		{
+			name: "whole request lost",
+			data: "GET /a HTTP/1.1\r\n\r\nGET /c HTTP/1.1\r\n\r\n",
+			gaps: []reassembly.Gap{{Offset: 19, Missing: 19}},
+			want: []want{{"/a", 0, true, false}, {"/c", 0, true, true}},
+		},
+		{
+			name: "head cut by a gap",
+			data: "GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\n\r\n",
+			gaps: []reassembly.Gap{{Offset: 20, Missing: 3}},
+			want: []want{{"/b", 0, true, true}},
+		},
+		{
+			name: "body ends in a gap",
+			data: "POST /a HTTP/1.1\r\nContent-Length: 10\r\n\r\nhel",
+			gaps: []reassembly.Gap{{Offset: 43, Missing: 1000}},
+			want: []want{{"/a", 10, false, false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs := ParseRequests(flow(tt.data, tt.gaps...))
+			if len(reqs) != len(tt.want) {
+				t.Fatalf("got %d requests %+v, want %d", len(reqs), reqs, len(tt.want))
+			}
+			for i, w := range tt.want {
+				r := reqs[i]
+				if r.URI != w.uri || r.ContentLength != w.length || r.Complete != w.complete || r.AfterLoss != w.afterLoss {
+					t.Errorf("request %d = %s with %d body bytes (complete %v, after loss %v), want %+v",
+						i, r.URI, r.ContentLength, r.Complete, r.AfterLoss, w)
+				}
+			}
+		})
+	}
+}
+
+func TestParseResponses(t *testing.T) {
+	reqs := ParseRequests(flow("GET /a HTTP/1.1\r\n\r\nHEAD /b HTTP/1.1\r\n\r\nGET /c HTTP/1.1\r\n\r\n"))
+	data := "HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabc" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nwxyz\r\n0\r\n\r\n"
+	resps := ParseResponses(flow(data), reqs, false)
+	if len(resps) != 4 {
+		t.Fatalf("got %d responses, want 4", len(resps))
+	}
+	want := []struct {
+		code   int
+		length int64
+	}{{100, 0}, {200, 3}, {200, 0}, {200, 4}}
+	for i, w := range want {
+		if resps[i].StatusCode != w.code || resps[i].ContentLength != w.length || !resps[i].Complete {
+			t.Errorf("response %d = %d with %d body bytes (complete %v), want %d with %d", i,
+				resps[i].StatusCode, resps[i].ContentLength, resps[i].Complete, w.code, w.length)
+		}
+	}
+
+	closeDelimited := ParseResponses(flow("HTTP/1.0 200 OK\r\n\r\nbody"), nil, true)
+	if len(closeDelimited) != 1 || !closeDelimited[0].Complete || closeDelimited[0].ContentLength != 4 {
+		t.Errorf("close-delimited response = %+v", closeDelimited)
+	}
+
+	// The first response is lost; the second resynchronizes after the gap
+	lost := ParseResponses(flow("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi",
+		reassembly.Gap{Offset: 0, Missing: 40}), reqs, false)
+	if len(lost) != 1 || !lost[0].AfterLoss || lost[0].ContentLength != 2 {
+		t.Errorf("response after a lost one = %+v", lost)
+	}
+}
+
+// Every prefix of a valid exchange must parse without panicking, with
+// offsets inside the data
+func TestTruncated(t *testing.T) {
+	client := []byte("POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\nGET /b HTTP/1.1\r\nContent-Length: 2\r\n\r\nhi")
+	server := []byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\nHTTP/1.1 404 Not Found\r\nContent-Length: 1\r\n\r\nx")
+	for i := 0; i <= len(client); i++ {
+		checkOffsets(t, client[:i], server[:min(i, len(server))])
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"), []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	f.Add([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nffffffffffffffff\r\n"), []byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n7fffffffffffffff\r\nx\r\n"))
+	f.Add([]byte("PUT / HTTP/1.1\r\nContent-Length: 9223372036854775807\r\n\r\n"), []byte("HTTP/1.1 200 OK\n\nclose"))
+	f.Fuzz(func(t *testing.T, client, server []byte) {
+		checkOffsets(t, client, server)
+	})
+}
+
+// checkOffsets parses the flows whole and with a gap in the middle
+func checkOffsets(t *testing.T, client, server []byte) {
+	t.Helper()
+	flows := [][2]*reassembly.Flow{
+		{flow(string(client)), flow(string(server))},
+		{flow(string(client), reassembly.Gap{Offset: len(client) / 2, Missing: 7}),
+			flow(string(server), reassembly.Gap{Offset: len(server) / 2, Missing: 7})},
+	}
+	for _, fl := range flows {
+		reqs := ParseRequests(fl[0])
+		for _, req := range reqs {
+			if req.Start < 0 || req.End < req.Start || req.End > len(client) {
+				t.Fatalf("request at %d-%d outside %d bytes", req.Start, req.End, len(client))
+			}
+		}
+		for _, resp := range ParseResponses(fl[1], reqs, true) {
+			if resp.Start < 0 || resp.End < resp.Start || resp.End > len(server) {
+				t.Fatalf("response at %d-%d outside %d bytes", resp.Start, resp.End, len(server))
+			}
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Package http2 parses HTTP/2 frames (RFC 9113) out of reassembled TCP byte
+// streams and decodes their HPACK header blocks. It handles prior-knowledge
+// h2c, the HTTP/1.1 Upgrade to h2c, and decrypted h2 over TLS.
+package http2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/net/http2/hpack"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// Preface is the client connection preface
+const Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const frameHeaderLen = 9
+
+// maxFrameLen is the largest frame size a peer may allow (2^24-1); anything
+// larger means we lost framing
+const maxFrameLen = 1<<24 - 1
+
+// FrameType is the HTTP/2 frame type
+type FrameType uint8
+
+const (
+	FrameData FrameType = iota
+	FrameHeaders
+	FramePriority
+	FrameRSTStream
+	FrameSettings
+	FramePushPromise
+	FramePing
+	FrameGoAway
+	FrameWindowUpdate
+	FrameContinuation
+)
+
+var frameNames = [...]string{"DATA", "HEADERS", "PRIORITY", "RST_STREAM", "SETTINGS", "PUSH_PROMISE", "PING", "GOAWAY", "WINDOW_UPDATE", "CONTINUATION"}
+
+func (t FrameType) String() string {
+	if int(t) < len(frameNames) {
+		return frameNames[t]
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(t))
+}
+
+// Frame flags
+const (
+	FlagEndStream  = 0x01
+	FlagAck        = 0x01
+	FlagEndHeaders = 0x04
+	FlagPadded     = 0x08
+	FlagPriority   = 0x20
+)
+
+// SettingInitialWindowSize is the SETTINGS parameter that sizes new stream windows
+const SettingInitialWindowSize = 0x4
+
+// DefaultWindowSize is the initial flow-control window of connections and streams
+const DefaultWindowSize = 65535
+
+// Setting is one SETTINGS parameter
+type Setting struct {
+	ID    uint16
+	Value uint32
+}
+
+// Frame is one decoded frame. Offset indexes the flow data.
+type Frame struct {
+	Type     FrameType
+	Flags    uint8
+	StreamID uint32
+	Length   int // payload length, which is what DATA charges to flow control
+	Offset   int
+
+	// Headers holds the decoded block of HEADERS or PUSH_PROMISE, with any
+	// CONTINUATION frames merged in
+	Headers []hpack.HeaderField
+	// ErrorCode is set on RST_STREAM and GOAWAY
+	ErrorCode uint32
+	// LastStreamID and Debug are set on GOAWAY
+	LastStreamID uint32
+	Debug        string
+	// Increment is set on WINDOW_UPDATE
+	Increment uint32
+	Settings  []Setting
+}
+
+// EndStream reports whether the frame closes its stream for the sender
+func (f *Frame) EndStream() bool {
+	return (f.Type == FrameData || f.Type == FrameHeaders) && f.Flags&FlagEndStream != 0
+}
+
+// Header returns the value of the first header field with the given name
+func (f *Frame) Header(name string) string {
+	for _, h := range f.Headers {
+		if h.Name == name {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// Side is one direction of an HTTP/2 connection
+type Side struct {
+	Frames []*Frame
+	// HeaderErr is set once HPACK decoding failed, for example because the
+	// capture started mid-connection. Later header blocks are not decoded.
+	HeaderErr error
+	// Lost is set when parsing stopped at a gap that swallowed a frame
+	// header or part of a header block
+	Lost bool
+}
+
+// Start finds where the HTTP/2 frames begin in each direction. With prior
+// knowledge the client opens with the preface; after an HTTP/1.1 Upgrade the
+// preface follows the request and the server's frames follow its 101.
+func Start(client, server []byte) (clientStart, serverStart int, ok bool) {
+	if bytes.HasPrefix(client, []byte(Preface)) {
+		return len(Preface), 0, true
+	}
+	if !bytes.HasPrefix(server, []byte("HTTP/1.1 101")) {
+		return 0, 0, false
+	}
+	i := bytes.Index(client, []byte(Preface))
+	j := bytes.Index(server, []byte("\r\n\r\n"))
+	if i < 0 || j < 0 {
+		return 0, 0, false
+	}
+	return i + len(Preface), j + 4, true
+}
+
+// Parse decodes the frames of a flow starting at offset. Frames are framed
+// across gaps: a DATA frame whose payload was partly lost keeps its length,
+// and other frames keep what was captured. Parsing stops at the first
+// incomplete or implausible frame, when a frame header falls in a gap, or
+// when part of a header block was lost, since HPACK state depends on every
+// block.
+func Parse(flow *reassembly.Flow, offset int) *Side {
+	side := &Side{}
+	dec := hpack.NewDecoder(4096, nil)
+	// Peers may announce a larger table than the default; accept what the encoder uses
+	dec.SetAllowedMaxDynamicTableSize(1 << 20)
+
+	var pending *Frame // HEADERS or PUSH_PROMISE awaiting CONTINUATION
+	var block []byte
+
+	// framed is false once a frame ended in a gap, losing the next header
+	pos, framed := offset, true
+	for pos < len(flow.Data) {
+		data := flow.Contiguous(pos)
+		if !framed || len(data) < frameHeaderLen {
+			side.Lost = !framed || pos+len(data) < len(flow.Data)
+			break
+		}
+		h := data[:frameHeaderLen]
+		length := int(h[0])<<16 | int(h[1])<<8 | int(h[2])
+		if length > maxFrameLen {
+			break
+		}
+		end, ok := flow.Skip(pos, int64(frameHeaderLen+length))
+		if end > len(flow.Data) {
+			break
+		}
+		f := &Frame{
+			Type:     FrameType(h[3]),
+			Flags:    h[4],
+			StreamID: binary.BigEndian.Uint32(h[5:]) & 0x7fffffff,
+			Length:   length,
+			Offset:   pos,
+		}
+		payload := data[frameHeaderLen:min(len(data), frameHeaderLen+length)]
+		pos, framed = end, ok
+
+		if len(payload) < length && (pending != nil || f.Type == FrameHeaders ||
+			f.Type == FramePushPromise || f.Type == FrameContinuation) {
+			side.Lost = true
+			break
+		}
+
+		if pending != nil {
+			// Only CONTINUATION may follow an unfinished header block
+			if f.Type != FrameContinuation || f.StreamID != pending.StreamID {
+				break
+			}
+			block = append(block, payload...)
+			if f.Flags&FlagEndHeaders != 0 {
+				side.decodeHeaders(dec, pending, block)
+				pending, block = nil, nil
+			}
+			continue
+		}
+
+		// Partly lost frames keep what was captured of their payload
+		switch f.Type {
+		case FrameHeaders, FramePushPromise:
+			frag, ok := headerFragment(f, payload)
+			if !ok {
+				return side
+			}
+			side.Frames = append(side.Frames, f)
+			if f.Flags&FlagEndHeaders != 0 {
+				side.decodeHeaders(dec, f, frag)
+			} else {
+				pending, block = f, append([]byte(nil), frag...)
+			}
+			continue
+		case FrameRSTStream:
+			if len(payload) >= 4 {
+				f.ErrorCode = binary.BigEndian.Uint32(payload)
+			}
+		case FrameGoAway:
+			if len(payload) >= 8 {
+				f.LastStreamID = binary.BigEndian.Uint32(payload) & 0x7fffffff
+				f.ErrorCode = binary.BigEndian.Uint32(payload[4:])
+				f.Debug = string(payload[8:])
+			}
+		case FrameWindowUpdate:
+			if len(payload) >= 4 {
+				f.Increment = binary.BigEndian.Uint32(payload) & 0x7fffffff
+			}
+		case FrameSettings:
+			for i := 0; i+6 <= len(payload); i += 6 {
+				f.Settings = append(f.Settings, Setting{
+					ID:    binary.BigEndian.Uint16(payload[i:]),
+					Value: binary.BigEndian.Uint32(payload[i+2:]),
+				})
+			}
+		}
+		side.Frames = append(side.Frames, f)
+	}
+	return side
+}
+
+// headerFragment strips padding, priority and the promised stream ID from a
+// HEADERS or PUSH_PROMISE payload
+func headerFragment(f *Frame, payload []byte) ([]byte, bool) {
+	pad := 0
+	if f.Flags&FlagPadded != 0 {
+		if len(payload) < 1 {
+			return nil, false
+		}
+		pad = int(payload[0])
+		payload = payload[1:]
+	}
+	skip := 0
+	if f.Type == FrameHeaders && f.Flags&FlagPriority != 0 {
+		skip = 5
+	} else if f.Type == FramePushPromise {
+		skip = 4
+	}
+	if skip+pad > len(payload) {
+		return nil, false
+	}
+	return payload[skip : len(payload)-pad], true
+}
+
+func (s *Side) decodeHeaders(dec *hpack.Decoder, f *Frame, block []byte) {
+	if s.HeaderErr != nil {
+		return
+	}
+	fields, err := dec.DecodeFull(block)
+	if err != nil {
+		s.HeaderErr = err
+		return
+	}
+	f.Headers = fields
+}
+
+var errorCodeNames = map[uint32]string{
+	0x0: "NO_ERROR",
+	0x1: "PROTOCOL_ERROR",
+	0x2: "INTERNAL_ERROR",
+	0x3: "FLOW_CONTROL_ERROR",
+	0x4: "SETTINGS_TIMEOUT",
+	0x5: "STREAM_CLOSED",
+	0x6: "FRAME_SIZE_ERROR",
+	0x7: "REFUSED_STREAM",
+	0x8: "CANCEL",
+	0x9: "COMPRESSION_ERROR",
+	0xa: "CONNECT_ERROR",
+	0xb: "ENHANCE_YOUR_CALM",
+	0xc: "INADEQUATE_SECURITY",
+	0xd: "HTTP_1_1_REQUIRED",
+}
+
+// Error codes used by the analyzer
+const (
+	ErrCodeNo     uint32 = 0x0
+	ErrCodeCancel uint32 = 0x8
+)
+
+// ErrorCodeName returns the RFC name of an RST_STREAM or GOAWAY error code
+func ErrorCodeName(code uint32) string {
+	if name, ok := errorCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", code)
+}
@@ -0,0 +1,261 @@
+package http2
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flow wraps data in a flow, leaving out the bytes in [from, to) as a gap
+func flow(data []byte, from, to int) *reassembly.Flow {
+	f := &reassembly.Flow{Data: append(append([]byte(nil), data[:from]...), data[to:]...)}
+	f.Chunks = []reassembly.Chunk{{Len: len(f.Data)}}
+	if to > from {
+		f.Gaps = []reassembly.Gap{{Offset: from, Missing: int64(to - from)}}
+	}
+	return f
+}
+
+// whole wraps data in a flow without gaps
+func whole(data []byte) *reassembly.Flow {
+	return flow(data, 0, 0)
+}
+
+// exchange builds the client and server sides of a gRPC call whose request
+// headers span a CONTINUATION frame
+func exchange(t testing.TB) (client, server []byte) {
+	t.Helper()
+	encode := func(fields ...hpack.HeaderField) []byte {
+		var buf bytes.Buffer
+		enc := hpack.NewEncoder(&buf)
+		for _, f := range fields {
+			if err := enc.WriteField(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return buf.Bytes()
+	}
+
+	var c bytes.Buffer
+	c.WriteString(Preface)
+	fr := http2.NewFramer(&c, nil)
+	fr.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 20})
+	block := encode(
+		hpack.HeaderField{Name: ":method", Value: "POST"},
+		hpack.HeaderField{Name: ":path", Value: "/pkg.Svc/Call"},
+		hpack.HeaderField{Name: "content-type", Value: "application/grpc"},
+	)
+	fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: block[:4], PadLength: 3})
+	fr.WriteContinuation(1, true, block[4:])
+	fr.WriteData(1, true, []byte{0, 0, 0, 0, 1, 'x'})
+
+	var s bytes.Buffer
+	fr = http2.NewFramer(&s, nil)
+	fr.WriteSettings()
+	fr.WriteSettingsAck()
+	fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, EndHeaders: true, BlockFragment: encode(hpack.HeaderField{Name: ":status", Value: "200"})})
+	fr.WriteWindowUpdate(0, 1000)
+	fr.WriteRSTStream(1, http2.ErrCodeCancel)
+	fr.WriteGoAway(1, http2.ErrCodeNo, []byte("bye"))
+	return c.Bytes(), s.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	client, server := exchange(t)
+	cs, ss, ok := Start(client, server)
+	if !ok || cs != len(Preface) || ss != 0 {
+		t.Fatalf("Start = %d, %d, %v", cs, ss, ok)
+	}
+
+	c := Parse(whole(client), cs)
+	if c.HeaderErr != nil {
+		t.Fatal(c.HeaderErr)
+	}
+	if len(c.Frames) != 3 {
+		t.Fatalf("got %d client frames, want SETTINGS, HEADERS and DATA", len(c.Frames))
+	}
+	if s := c.Frames[0].Settings; len(s) != 1 || s[0] != (Setting{ID: SettingInitialWindowSize, Value: 1 << 20}) {
+		t.Errorf("settings = %v", s)
+	}
+	h := c.Frames[1]
+	if h.Header(":path") != "/pkg.Svc/Call" || !IsGRPC(h.Header("content-type")) {
+		t.Errorf("headers = %v", h.Headers)
+	}
+	if !c.Frames[2].EndStream() || c.Frames[2].Length != 6 {
+		t.Errorf("data = %+v", c.Frames[2])
+	}
+
+	s := Parse(whole(server), ss)
+	types := make([]FrameType, len(s.Frames))
+	for i, f := range s.Frames {
+		types[i] = f.Type
+	}
+	want := []FrameType{FrameSettings, FrameSettings, FrameHeaders, FrameWindowUpdate, FrameRSTStream, FrameGoAway}
+	if len(types) != len(want) {
+		t.Fatalf("server frames = %v, want %v", types, want)
+	}
+	if s.Frames[2].Header(":status") != "200" || s.Frames[3].Increment != 1000 || s.Frames[4].ErrorCode != ErrCodeCancel {
+		t.Errorf("server frames = %+v", s.Frames)
+	}
+	if g := s.Frames[5]; g.LastStreamID != 1 || g.Debug != "bye" {
+		t.Errorf("GOAWAY = %+v", g)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	for ct, want := range map[string]bool{
+		"application/grpc":            true,
+		"application/grpc+proto":      true,
+		"application/grpc; charset=x": true,
+		"application/grpc-web":        false,
+		"application/json":            false,
+	} {
+		if got := IsGRPC(ct); got != want {
+			t.Errorf("IsGRPC(%q) = %v, want %v", ct, got, want)
+		}
+	}
+	for code, want := range map[int]string{0: "OK", 14: "UNAVAILABLE", 16: "UNAUTHENTICATED", 17: "CODE_17", -1: "CODE_-1"} {
+		if got := GRPCCodeName(code); got != want {
+			t.Errorf("GRPCCodeName(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestStartUpgrade(t *testing.T) {
+	client := []byte("GET / HTTP/1.1\r\nUpgrade: h2c\r\n\r\n" + Preface)
+	server := []byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: h2c\r\n\r\n")
+	cs, ss, ok := Start(client, server)
+	if !ok || cs != len(client) || ss != len(server) {
+		t.Errorf("Start = %d, %d, %v, want %d, %d, true", cs, ss, ok, len(client), len(server))
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+	}{
+		{"padding longer than payload", []byte{0, 0, 2, 1, FlagPadded | FlagEndHeaders, 0, 0, 0, 1, 5, 0x82}, 0},
+		{"priority past end", []byte{0, 0, 3, 1, FlagPriority | FlagEndHeaders, 0, 0, 0, 1, 0, 0, 0}, 0},
+		{"continuation on another stream", []byte{0, 0, 1, 1, 0, 0, 0, 0, 1, 0x82, 0, 0, 1, 9, FlagEndHeaders, 0, 0, 0, 3, 0x84}, 1},
+		{"short rst_stream", []byte{0, 0, 2, 3, 0, 0, 0, 0, 1, 0, 8}, 1},
+		{"short goaway", []byte{0, 0, 4, 7, 0, 0, 0, 0, 0, 0, 0, 0, 1}, 1},
+		{"frame past end", []byte{0, 0, 9, 0, 0, 0, 0, 0, 1, 'x'}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if side := Parse(whole(tt.data), 0); len(side.Frames) != tt.frames {
+				t.Errorf("got %d frames, want %d", len(side.Frames), tt.frames)
+			}
+		})
+	}
+}
+
+func TestParseGaps(t *testing.T) {
+	headers := func(fr *http2.Framer, id uint32, path string) {
+		var buf bytes.Buffer
+		enc := hpack.NewEncoder(&buf)
+		enc.WriteField(hpack.HeaderField{Name: ":method", Value: "POST"})
+		enc.WriteField(hpack.HeaderField{Name: ":path", Value: path})
+		fr.WriteHeaders(http2.HeadersFrameParam{StreamID: id, EndHeaders: true, BlockFragment: buf.Bytes()})
+	}
+	var b bytes.Buffer
+	fr := http2.NewFramer(&b, nil)
+	headers(fr, 1, "/a")
+	dataAt := b.Len()
+	fr.WriteData(1, true, bytes.Repeat([]byte{'x'}, 1000))
+	headersAt := b.Len()
+	headers(fr, 3, "/b")
+	goAwayAt := b.Len()
+	fr.WriteGoAway(3, http2.ErrCodeNo, bytes.Repeat([]byte{'d'}, 100))
+	fr.WriteWindowUpdate(0, 1000)
+	data := b.Bytes()
+
+	tests := []struct {
+		name     string
+		from, to int
+		types    []FrameType
+		lost     bool
+	}{
+		{"DATA payload lost", dataAt + 100, dataAt + 600,
+			[]FrameType{FrameHeaders, FrameData, FrameHeaders, FrameGoAway, FrameWindowUpdate}, false},
+		{"GOAWAY debug data lost", goAwayAt + 20, goAwayAt + 60,
+			[]FrameType{FrameHeaders, FrameData, FrameHeaders, FrameGoAway, FrameWindowUpdate}, false},
+		{"HEADERS block lost", headersAt + 10, headersAt + 12,
+			[]FrameType{FrameHeaders, FrameData}, true},
+		{"frame header lost", headersAt - 10, headersAt + 3,
+			[]FrameType{FrameHeaders, FrameData}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			side := Parse(flow(data, tt.from, tt.to), 0)
+			var types []FrameType
+			for _, f := range side.Frames {
+				types = append(types, f.Type)
+			}
+			if fmt.Sprint(types) != fmt.Sprint(tt.types) || side.Lost != tt.lost {
+				t.Fatalf("frames = %v (lost %v), want %v (lost %v)", types, side.Lost, tt.types, tt.lost)
+			}
+			if side.HeaderErr != nil {
+				t.Fatal(side.HeaderErr)
+			}
+			if d := side.Frames[1]; d.Length != 1000 || !d.EndStream() {
+				t.Errorf("DATA = %d bytes, end stream %v, want 1000 and true", d.Length, d.EndStream())
+			}
+			if len(side.Frames) > 2 && side.Frames[2].Header(":path") != "/b" {
+				t.Errorf("headers after the gap = %v", side.Frames[2].Headers)
+			}
+			if len(side.Frames) > 3 && (side.Frames[3].LastStreamID != 3 || side.Frames[4].Increment != 1000) {
+				t.Errorf("control frames = %+v, %+v", side.Frames[3], side.Frames[4])
+			}
+		})
+	}
+}
+
+// Every prefix of a connection must parse without panicking, with frames
+// inside the data
+func TestTruncated(t *testing.T) {
+	client, server := exchange(t)
+	for _, data := range [][]byte{client, server} {
+		for i := 0; i <= len(data); i++ {
+			checkFrames(t, data[:i])
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	client, server := exchange(f)
+	f.Add(client)
+	f.Add(server)
+	f.Add([]byte{0, 0, 2, 1, FlagPadded | FlagEndHeaders, 0, 0, 0, 1, 5, 0x82})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkFrames(t, data)
+	})
+}
+
+func checkFrames(t *testing.T, data []byte) {
+	t.Helper()
+	cs, ss, _ := Start(data, data)
+	for _, start := range []int{0, cs, ss} {
+		for _, fr := range Parse(whole(data), start).Frames {
+			if fr.Offset < start || fr.Offset+frameHeaderLen+fr.Length > len(data) {
+				t.Fatalf("frame at %d with %d bytes outside %d bytes", fr.Offset, fr.Length, len(data))
+			}
+		}
+		// With a gap the frames stay inside the data, counting missing bytes
+		if start < len(data) {
+			gapped := flow(data, start+(len(data)-start)/3, start+(len(data)-start)/2)
+			for _, fr := range Parse(gapped, start).Frames {
+				if fr.Offset < start || fr.Offset+frameHeaderLen > len(gapped.Data) {
+					t.Fatalf("frame at %d outside %d bytes", fr.Offset, len(gapped.Data))
+				}
+			}
+		}
+	}
+}
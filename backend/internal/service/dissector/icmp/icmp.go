@@ -0,0 +1,215 @@
+// Package icmp decodes ICMP and ICMPv6 messages, including the header of
+// the original packet quoted in error messages, which identifies the flow
+// the error refers to.
+package icmp
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// Message kinds
+const (
+	KindEchoRequest      = "echo-request"
+	KindEchoReply        = "echo-reply"
+	KindFragNeeded       = "fragmentation-needed" // ICMPv4 type 3 code 4
+	KindPacketTooBig     = "packet-too-big"       // ICMPv6 type 2
+	KindNetUnreachable   = "net-unreachable"
+	KindHostUnreachable  = "host-unreachable"
+	KindPortUnreachable  = "port-unreachable"
+	KindProtoUnreachable = "protocol-unreachable"
+	KindAdminProhibited  = "admin-prohibited"
+	KindUnreachable      = "unreachable" // other destination-unreachable codes
+	KindTTLExceeded      = "ttl-exceeded"
+	KindReassemblyTime   = "reassembly-time-exceeded"
+	KindRedirect         = "redirect"
+	KindParameterProblem = "parameter-problem"
+	KindOther            = "other"
+)
+
+// Message is a decoded ICMP or ICMPv6 message
+type Message struct {
+	Version int // 4 or 6
+	Type    uint8
+	Code    uint8
+	Kind    string
+
+	MTU     int    // next-hop MTU of fragmentation-needed / packet-too-big
+	Gateway string // redirect target
+
+	// Original holds the quoted header of the packet that caused an error
+	Original *Original
+}
+
+// IsError reports whether the message is an error about another packet
+func (m *Message) IsError() bool {
+	return m.Original != nil
+}
+
+// Original is the quoted IP header and the first transport bytes
+type Original struct {
+	SrcIP     string
+	DstIP     string
+	Transport string // "TCP", "UDP" or "" for other protocols
+	SrcPort   uint16
+	DstPort   uint16
+}
+
+// ParseV4 decodes an ICMPv4 message (starting at the type byte)
+func ParseV4(data []byte) *Message {
+	if len(data) < 8 {
+		return nil
+	}
+	m := &Message{Version: 4, Type: data[0], Code: data[1], Kind: KindOther}
+
+	switch m.Type {
+	case 0:
+		m.Kind = KindEchoReply
+	case 8:
+		m.Kind = KindEchoRequest
+	case 3:
+		switch m.Code {
+		case 0:
+			m.Kind = KindNetUnreachable
+		case 1:
+			m.Kind = KindHostUnreachable
+		case 2:
+			m.Kind = KindProtoUnreachable
+		case 3:
+			m.Kind = KindPortUnreachable
+		case 4:
+			m.Kind = KindFragNeeded
+			m.MTU = int(binary.BigEndian.Uint16(data[6:8]))
+		case 9, 10, 13:
+			m.Kind = KindAdminProhibited
+		default:
+			m.Kind = KindUnreachable
+		}
+	case 5:
+		m.Kind = KindRedirect
+		m.Gateway = net.IP(data[4:8]).String()
+	case 11:
+		m.Kind = KindTTLExceeded
+		if m.Code == 1 {
+			m.Kind = KindReassemblyTime
+		}
+	case 12:
+		m.Kind = KindParameterProblem
+	}
+
+	if m.Type == 3 || m.Type == 5 || m.Type == 11 || m.Type == 12 {
+		m.Original = quotedV4(data[8:])
+	}
+	return m
+}
+
+// ParseV6 decodes an ICMPv6 message (starting at the type byte)
+func ParseV6(data []byte) *Message {
+	if len(data) < 8 {
+		return nil
+	}
+	m := &Message{Version: 6, Type: data[0], Code: data[1], Kind: KindOther}
+
+	switch m.Type {
+	case 128:
+		m.Kind = KindEchoRequest
+	case 129:
+		m.Kind = KindEchoReply
+	case 1:
+		switch m.Code {
+		case 0:
+			m.Kind = KindNetUnreachable
+		case 1, 5, 6:
+			m.Kind = KindAdminProhibited
+		case 3:
+			m.Kind = KindHostUnreachable
+		case 4:
+			m.Kind = KindPortUnreachable
+		default:
+			m.Kind = KindUnreachable
+		}
+	case 2:
+		m.Kind = KindPacketTooBig
+		m.MTU = int(binary.BigEndian.Uint32(data[4:8]))
+	case 3:
+		m.Kind = KindTTLExceeded
+		if m.Code == 1 {
+			m.Kind = KindReassemblyTime
+		}
+	case 4:
+		m.Kind = KindParameterProblem
+	case 137:
+		m.Kind = KindRedirect
+		if len(data) >= 40 {
+			m.Gateway = net.IP(data[8:24]).String()
+			m.Original = redirectedHeaderV6(data[40:], net.IP(data[24:40]).String())
+		}
+		return m
+	}
+
+	if m.Type >= 1 && m.Type <= 4 {
+		m.Original = quotedV6(data[8:])
+	}
+	return m
+}
+
+func quotedV4(b []byte) *Original {
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return nil
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if ihl < 20 || len(b) < ihl {
+		return nil
+	}
+	o := &Original{
+		SrcIP: net.IP(b[12:16]).String(),
+		DstIP: net.IP(b[16:20]).String(),
+	}
+	quotePorts(o, b[9], b[ihl:])
+	return o
+}
+
+func quotedV6(b []byte) *Original {
+	if len(b) < 40 || b[0]>>4 != 6 {
+		return nil
+	}
+	o := &Original{
+		SrcIP: net.IP(b[8:24]).String(),
+		DstIP: net.IP(b[24:40]).String(),
+	}
+	quotePorts(o, b[6], b[40:])
+	return o
+}
+
+// redirectedHeaderV6 finds the Redirected Header option (type 4) that
+// carries the original packet; without it only the destination is known.
+func redirectedHeaderV6(opts []byte, dst string) *Original {
+	for len(opts) >= 8 {
+		n := int(opts[1]) * 8
+		if n == 0 || n > len(opts) {
+			break
+		}
+		if opts[0] == 4 {
+			if o := quotedV6(opts[8:n]); o != nil {
+				return o
+			}
+		}
+		opts = opts[n:]
+	}
+	return &Original{DstIP: dst}
+}
+
+func quotePorts(o *Original, proto byte, b []byte) {
+	switch proto {
+	case 6:
+		o.Transport = "TCP"
+	case 17:
+		o.Transport = "UDP"
+	default:
+		return
+	}
+	if len(b) >= 4 {
+		o.SrcPort = binary.BigEndian.Uint16(b[0:2])
+		o.DstPort = binary.BigEndian.Uint16(b[2:4])
+	}
+}
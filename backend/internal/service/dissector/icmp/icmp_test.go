@@ -0,0 +1,74 @@
+package icmp
+
+import (
+	"testing"
+)
+
+// portUnreachable quotes a UDP datagram 10.0.0.1:5353 > 10.0.0.2:53
+var portUnreachable = []byte{
+	3, 3, 0, 0, 0, 0, 0, 0,
+	0x45, 0, 0, 28, 0, 0, 0, 0, 64, 17, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2,
+	0x14, 0xe9, 0, 53, 0, 8, 0, 0,
+}
+
+// packetTooBig quotes a TCP segment 2001:db8::1:443 > 2001:db8::2:50000
+var packetTooBig = append([]byte{
+	2, 0, 0, 0, 0, 0, 5, 0x00,
+	0x60, 0, 0, 0, 0, 20, 6, 64,
+	0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+	0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+}, 0x01, 0xbb, 0xc3, 0x50)
+
+func TestParse(t *testing.T) {
+	m := ParseV4(portUnreachable)
+	if m == nil || m.Kind != KindPortUnreachable || !m.IsError() {
+		t.Fatalf("ParseV4 = %+v", m)
+	}
+	if o := *m.Original; o != (Original{SrcIP: "10.0.0.1", DstIP: "10.0.0.2", Transport: "UDP", SrcPort: 5353, DstPort: 53}) {
+		t.Errorf("original = %+v", o)
+	}
+
+	m = ParseV6(packetTooBig)
+	if m == nil || m.Kind != KindPacketTooBig || m.MTU != 1280 {
+		t.Fatalf("ParseV6 = %+v", m)
+	}
+	if o := m.Original; o == nil || o.Transport != "TCP" || o.SrcPort != 443 || o.DstPort != 50000 {
+		t.Errorf("original = %+v", o)
+	}
+}
+
+func TestParseFragNeeded(t *testing.T) {
+	data := append([]byte(nil), portUnreachable...)
+	data[1] = 4                // fragmentation needed
+	data[6], data[7] = 5, 0x78 // next-hop MTU 1400
+	m := ParseV4(data)
+	if m == nil || m.Kind != KindFragNeeded || m.MTU != 1400 {
+		t.Fatalf("ParseV4 = %+v", m)
+	}
+	if m.Original == nil || m.Original.DstPort != 53 {
+		t.Errorf("original = %+v", m.Original)
+	}
+}
+
+// Every prefix of a message must parse without panicking
+func TestTruncated(t *testing.T) {
+	for i := 0; i <= len(packetTooBig); i++ {
+		if i <= len(portUnreachable) {
+			ParseV4(portUnreachable[:i])
+		}
+		ParseV6(packetTooBig[:i])
+	}
+	if ParseV4(portUnreachable[:7]) != nil {
+		t.Error("7-byte message parsed")
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(portUnreachable)
+	f.Add(packetTooBig)
+	f.Add(append([]byte{137, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 40)...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseV4(data)
+		ParseV6(data)
+	})
+}
@@ -0,0 +1,202 @@
+package kafka
+
+import "fmt"
+
+// API keys the dissector decodes further than the header
+const (
+	APIProduce          = 0
+	APIFetch            = 1
+	APIMetadata         = 3
+	APIOffsetCommit     = 8
+	APIOffsetFetch      = 9
+	APIFindCoordinator  = 10
+	APIJoinGroup        = 11
+	APIHeartbeat        = 12
+	APILeaveGroup       = 13
+	APISyncGroup        = 14
+	APIListGroups       = 16
+	APISaslHandshake    = 17
+	APIApiVersions      = 18
+	APIInitProducerID   = 22
+	APIAddOffsetsToTxn  = 25
+	APIEndTxn           = 26
+	APISaslAuthenticate = 36
+	APIDescribeCluster  = 60
+	APIConsumerGroupHB  = 68
+)
+
+var apiNames = []string{
+	"Produce", "Fetch", "ListOffsets", "Metadata", "LeaderAndIsr", "StopReplica", "UpdateMetadata",
+	"ControlledShutdown", "OffsetCommit", "OffsetFetch", "FindCoordinator", "JoinGroup", "Heartbeat",
+	"LeaveGroup", "SyncGroup", "DescribeGroups", "ListGroups", "SaslHandshake", "ApiVersions",
+	"CreateTopics", "DeleteTopics", "DeleteRecords", "InitProducerId", "OffsetForLeaderEpoch",
+	"AddPartitionsToTxn", "AddOffsetsToTxn", "EndTxn", "WriteTxnMarkers", "TxnOffsetCommit",
+	"DescribeAcls", "CreateAcls", "DeleteAcls", "DescribeConfigs", "AlterConfigs",
+	"AlterReplicaLogDirs", "DescribeLogDirs", "SaslAuthenticate", "CreatePartitions",
+	"CreateDelegationToken", "RenewDelegationToken", "ExpireDelegationToken",
+	"DescribeDelegationToken", "DeleteGroups", "ElectLeaders", "IncrementalAlterConfigs",
+	"AlterPartitionReassignments", "ListPartitionReassignments", "OffsetDelete",
+	"DescribeClientQuotas", "AlterClientQuotas", "DescribeUserScramCredentials",
+	"AlterUserScramCredentials", "Vote", "BeginQuorumEpoch", "EndQuorumEpoch", "DescribeQuorum",
+	"AlterPartition", "UpdateFeatures", "Envelope", "FetchSnapshot", "DescribeCluster",
+	"DescribeProducers", "BrokerRegistration", "BrokerHeartbeat", "UnregisterBroker",
+	"DescribeTransactions", "ListTransactions", "AllocateProducerIds", "ConsumerGroupHeartbeat",
+}
+
+// APIName returns the name of an API key
+func APIName(key int16) string {
+	if key >= 0 && int(key) < len(apiNames) {
+		return apiNames[key]
+	}
+	return fmt.Sprintf("API%d", key)
+}
+
+// firstFlexible is the first version of each API that uses compact,
+// tagged encodings (KIP-482); APIs from 44 on were flexible from the start
+// and SaslHandshake never is
+var firstFlexible = []int16{
+	9, 12, 6, 9, 4, 2, 6, 3, 8, 6, 3, 6, 4, 4, 4, 5, 3, -1, 3, 5, 4, 2, 2, 4, 3, 3, 3, 1, 3,
+	2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
+}
+
+func flexible(key, version int16) bool {
+	if key < 0 {
+		return false
+	}
+	if int(key) >= len(firstFlexible) {
+		return true
+	}
+	first := firstFlexible[key]
+	return first >= 0 && version >= first
+}
+
+// topLevelError locates the error code of responses that carry one at the
+// start of the body: after a 4-byte throttle time from version throttleFrom
+// on (-1: never)
+type topLevelError struct {
+	throttleFrom int16
+	maxVersion   int16 // later versions moved the error
+}
+
+var topLevelErrors = map[int16]topLevelError{
+	APIFindCoordinator:  {1, 3},
+	APIJoinGroup:        {2, 99},
+	APIHeartbeat:        {1, 99},
+	APILeaveGroup:       {1, 99},
+	APISyncGroup:        {1, 99},
+	APIListGroups:       {1, 99},
+	APISaslHandshake:    {-1, 99},
+	APIApiVersions:      {-1, 99},
+	APIInitProducerID:   {0, 99},
+	APIAddOffsetsToTxn:  {0, 99},
+	APIEndTxn:           {0, 99},
+	APISaslAuthenticate: {-1, 99},
+	APIDescribeCluster:  {0, 99},
+	APIConsumerGroupHB:  {0, 99},
+}
+
+// groupAPIs name a consumer group (or coordinator key) in their first
+// request field
+var groupAPIs = map[int16]bool{
+	APIOffsetCommit: true, APIOffsetFetch: true, APIFindCoordinator: true, APIJoinGroup: true,
+	APIHeartbeat: true, APILeaveGroup: true, APISyncGroup: true, APIConsumerGroupHB: true,
+}
+
+var errorNames = map[int16]string{
+	-1:  "UNKNOWN_SERVER_ERROR",
+	1:   "OFFSET_OUT_OF_RANGE",
+	2:   "CORRUPT_MESSAGE",
+	3:   "UNKNOWN_TOPIC_OR_PARTITION",
+	4:   "INVALID_FETCH_SIZE",
+	5:   "LEADER_NOT_AVAILABLE",
+	6:   "NOT_LEADER_OR_FOLLOWER",
+	7:   "REQUEST_TIMED_OUT",
+	8:   "BROKER_NOT_AVAILABLE",
+	9:   "REPLICA_NOT_AVAILABLE",
+	10:  "MESSAGE_TOO_LARGE",
+	11:  "STALE_CONTROLLER_EPOCH",
+	12:  "OFFSET_METADATA_TOO_LARGE",
+	13:  "NETWORK_EXCEPTION",
+	14:  "COORDINATOR_LOAD_IN_PROGRESS",
+	15:  "COORDINATOR_NOT_AVAILABLE",
+	16:  "NOT_COORDINATOR",
+	17:  "INVALID_TOPIC_EXCEPTION",
+	18:  "RECORD_LIST_TOO_LARGE",
+	19:  "NOT_ENOUGH_REPLICAS",
+	20:  "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	21:  "INVALID_REQUIRED_ACKS",
+	22:  "ILLEGAL_GENERATION",
+	23:  "INCONSISTENT_GROUP_PROTOCOL",
+	24:  "INVALID_GROUP_ID",
+	25:  "UNKNOWN_MEMBER_ID",
+	26:  "INVALID_SESSION_TIMEOUT",
+	27:  "REBALANCE_IN_PROGRESS",
+	28:  "INVALID_COMMIT_OFFSET_SIZE",
+	29:  "TOPIC_AUTHORIZATION_FAILED",
+	30:  "GROUP_AUTHORIZATION_FAILED",
+	31:  "CLUSTER_AUTHORIZATION_FAILED",
+	32:  "INVALID_TIMESTAMP",
+	33:  "UNSUPPORTED_SASL_MECHANISM",
+	34:  "ILLEGAL_SASL_STATE",
+	35:  "UNSUPPORTED_VERSION",
+	36:  "TOPIC_ALREADY_EXISTS",
+	37:  "INVALID_PARTITIONS",
+	38:  "INVALID_REPLICATION_FACTOR",
+	39:  "INVALID_REPLICA_ASSIGNMENT",
+	40:  "INVALID_CONFIG",
+	41:  "NOT_CONTROLLER",
+	42:  "INVALID_REQUEST",
+	43:  "UNSUPPORTED_FOR_MESSAGE_FORMAT",
+	44:  "POLICY_VIOLATION",
+	45:  "OUT_OF_ORDER_SEQUENCE_NUMBER",
+	46:  "DUPLICATE_SEQUENCE_NUMBER",
+	47:  "INVALID_PRODUCER_EPOCH",
+	48:  "INVALID_TXN_STATE",
+	49:  "INVALID_PRODUCER_ID_MAPPING",
+	50:  "INVALID_TRANSACTION_TIMEOUT",
+	51:  "CONCURRENT_TRANSACTIONS",
+	52:  "TRANSACTION_COORDINATOR_FENCED",
+	53:  "TRANSACTIONAL_ID_AUTHORIZATION_FAILED",
+	54:  "SECURITY_DISABLED",
+	55:  "OPERATION_NOT_ATTEMPTED",
+	56:  "KAFKA_STORAGE_ERROR",
+	57:  "LOG_DIR_NOT_FOUND",
+	58:  "SASL_AUTHENTICATION_FAILED",
+	59:  "UNKNOWN_PRODUCER_ID",
+	60:  "REASSIGNMENT_IN_PROGRESS",
+	72:  "LISTENER_NOT_FOUND",
+	74:  "FENCED_LEADER_EPOCH",
+	75:  "UNKNOWN_LEADER_EPOCH",
+	76:  "UNSUPPORTED_COMPRESSION_TYPE",
+	77:  "STALE_BROKER_EPOCH",
+	78:  "OFFSET_NOT_AVAILABLE",
+	79:  "MEMBER_ID_REQUIRED",
+	80:  "PREFERRED_LEADER_NOT_AVAILABLE",
+	81:  "GROUP_MAX_SIZE_REACHED",
+	82:  "FENCED_INSTANCE_ID",
+	89:  "THROTTLING_QUOTA_EXCEEDED",
+	100: "UNKNOWN_TOPIC_ID",
+}
+
+// ErrorName returns the name of a Kafka error code
+func ErrorName(code int16) string {
+	if name, ok := errorNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("ERROR_%d", code)
+}
+
+// IsError reports whether an error code means the request failed. A first
+// JoinGroup asking for a member ID and group members told to rejoin during
+// a rebalance are part of the group protocol.
+func IsError(key, code int16) bool {
+	switch code {
+	case 0:
+		return false
+	case 79: // MEMBER_ID_REQUIRED
+		return key != APIJoinGroup
+	case 27: // REBALANCE_IN_PROGRESS
+		return key != APIHeartbeat && key != APISyncGroup && key != APIOffsetCommit && key != APIConsumerGroupHB
+	}
+	return true
+}
@@ -0,0 +1,285 @@
+package kafka
+
+import "encoding/binary"
+
+const maxTopics = 8 // topic names kept per request
+
+// reader is a bounds-checked cursor over Kafka primitives; after an
+// overrun ok is false and every read returns zero values. Flexible
+// versions use compact (varint-length) strings, bytes and arrays.
+type reader struct {
+	b    []byte
+	ok   bool
+	flex bool
+}
+
+func newReader(b []byte) *reader {
+	return &reader{b: b, ok: true}
+}
+
+func (r *reader) take(n int) []byte {
+	if !r.ok || n < 0 || len(r.b) < n {
+		r.ok = false
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) i16() int16 {
+	if b := r.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *reader) i32() int32 {
+	if b := r.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *reader) uvarint() int {
+	if !r.ok {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 || v > maxFrameLen {
+		r.ok = false
+		return 0
+	}
+	r.b = r.b[n:]
+	return int(v)
+}
+
+// length reads the length of a string, bytes or array; -1 is null
+func (r *reader) length(wide bool) int {
+	switch {
+	case r.flex:
+		return r.uvarint() - 1
+	case wide:
+		return int(r.i32())
+	}
+	return int(r.i16())
+}
+
+func (r *reader) str() string {
+	return string(r.take(max(r.length(false), 0)))
+}
+
+// bytes skips a bytes field, returning its length
+func (r *reader) bytes() int {
+	n := max(r.length(true), 0)
+	r.take(n)
+	return n
+}
+
+func (r *reader) array() int {
+	n := r.length(true)
+	if n > maxFrameLen/4 {
+		r.ok = false
+		return 0
+	}
+	return max(n, 0)
+}
+
+// tags skips the tagged fields of a flexible structure
+func (r *reader) tags() {
+	if !r.flex {
+		return
+	}
+	for n := r.uvarint(); n > 0 && r.ok; n-- {
+		r.uvarint()
+		r.take(r.uvarint())
+	}
+}
+
+func decodeRequest(b []byte) *Request {
+	r := newReader(b)
+	req := &Request{APIKey: r.i16(), Version: r.i16(), CorrelationID: r.i32(), Acks: -1}
+	req.ClientID = r.str() // a nullable string even in flexible headers
+	r.flex = flexible(req.APIKey, req.Version)
+	r.tags()
+	if !r.ok {
+		return req
+	}
+
+	v := req.Version
+	switch {
+	case req.APIKey == APIProduce:
+		if v >= 3 {
+			r.str() // transactional ID
+		}
+		req.Acks = r.i16()
+		r.i32() // timeout
+		for n := r.array(); n > 0 && r.ok; n-- {
+			if name := r.str(); r.ok && len(req.Topics) < maxTopics {
+				req.Topics = append(req.Topics, name)
+			}
+			for p := r.array(); p > 0 && r.ok; p-- {
+				r.i32()
+				r.bytes()
+				r.tags()
+			}
+			r.tags()
+		}
+	case req.APIKey == APIFetch:
+		if v < 15 {
+			r.i32() // replica ID
+		}
+		req.MaxWaitMs = r.i32()
+		r.i32() // min bytes
+		if v >= 3 {
+			r.i32()
+		}
+		if v >= 4 {
+			r.take(1)
+		}
+		if v >= 7 {
+			r.take(8) // session ID and epoch
+		}
+		for n := r.array(); n > 0 && r.ok; n-- {
+			if v < 13 {
+				if name := r.str(); r.ok && len(req.Topics) < maxTopics {
+					req.Topics = append(req.Topics, name)
+				}
+			} else {
+				r.take(16) // topic ID
+			}
+			for p := r.array(); p > 0 && r.ok; p-- {
+				r.i32()
+				if v >= 9 {
+					r.i32()
+				}
+				r.take(8) // fetch offset
+				if v >= 12 {
+					r.i32()
+				}
+				if v >= 5 {
+					r.take(8)
+				}
+				r.i32()
+				r.tags()
+			}
+			r.tags()
+		}
+	case groupAPIs[req.APIKey]:
+		req.Group = r.str()
+	}
+	return req
+}
+
+func decodeResponse(b []byte, req *Request) *Response {
+	r := newReader(b)
+	resp := &Response{CorrelationID: r.i32(), Errors: map[int16]int{}}
+	// ApiVersions responses keep the old header so clients can parse them
+	// before knowing the broker's versions
+	r.flex = flexible(req.APIKey, req.Version)
+	if r.flex && req.APIKey != APIApiVersions {
+		r.tags()
+	}
+	errorCode := func() {
+		if code := r.i16(); r.ok && code != 0 {
+			resp.Errors[code]++
+		}
+	}
+
+	v := req.Version
+	switch req.APIKey {
+	case APIProduce:
+		for n := r.array(); n > 0 && r.ok; n-- {
+			r.str()
+			for p := r.array(); p > 0 && r.ok; p-- {
+				r.i32()
+				errorCode()
+				r.take(8) // base offset
+				if v >= 2 {
+					r.take(8)
+				}
+				if v >= 5 {
+					r.take(8)
+				}
+				if v >= 8 {
+					for e := r.array(); e > 0 && r.ok; e-- {
+						r.i32()
+						r.str()
+						r.tags()
+					}
+					r.str() // error message
+				}
+				r.tags()
+			}
+			r.tags()
+		}
+		if v >= 1 {
+			resp.ThrottleMs = r.i32()
+		}
+		resp.Decoded = r.ok
+	case APIFetch:
+		if v >= 1 {
+			resp.ThrottleMs = r.i32()
+		}
+		if v >= 7 {
+			errorCode()
+			r.i32() // session ID
+		}
+		resp.Decoded = r.ok
+		for n := r.array(); n > 0 && r.ok; n-- {
+			if v < 13 {
+				r.str()
+			} else {
+				r.take(16)
+			}
+			for p := r.array(); p > 0 && r.ok; p-- {
+				r.i32()
+				errorCode()
+				r.take(8) // high watermark
+				if v >= 4 {
+					r.take(8)
+				}
+				if v >= 5 {
+					r.take(8)
+				}
+				if v >= 4 {
+					for a := r.array(); a > 0 && r.ok; a-- {
+						r.take(16)
+						r.tags()
+					}
+				}
+				if v >= 11 {
+					r.i32()
+				}
+				resp.Bytes += int64(r.bytes())
+				r.tags()
+			}
+			r.tags()
+		}
+	case APIOffsetCommit:
+		if v >= 3 {
+			resp.ThrottleMs = r.i32()
+		}
+		for n := r.array(); n > 0 && r.ok; n-- {
+			r.str()
+			for p := r.array(); p > 0 && r.ok; p-- {
+				r.i32()
+				errorCode()
+				r.tags()
+			}
+			r.tags()
+		}
+		resp.Decoded = r.ok
+	default:
+		loc, ok := topLevelErrors[req.APIKey]
+		if !ok || v > loc.maxVersion {
+			break
+		}
+		if loc.throttleFrom >= 0 && v >= loc.throttleFrom {
+			resp.ThrottleMs = r.i32()
+		}
+		errorCode()
+		resp.Decoded = r.ok
+	}
+	return resp
+}
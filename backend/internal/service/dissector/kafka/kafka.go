@@ -0,0 +1,170 @@
+// Package kafka decodes the Kafka wire protocol from reassembled TCP
+// flows: size-prefixed request and response headers paired by correlation
+// ID, with the topics, acks and error codes of produce and fetch requests
+// and the error code of the group and authentication APIs.
+package kafka
+
+import (
+	"encoding/binary"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+const (
+	maxFrameLen   = 100 << 20
+	maxClientID   = 256
+	minRequestLen = 10 // api key, version, correlation ID, client ID length
+)
+
+// Request is one client request. Offsets index the client flow data.
+type Request struct {
+	APIKey        int16
+	Version       int16
+	CorrelationID int32
+	ClientID      string
+	Topics        []string // Produce and Fetch topics (names; Fetch v13+ uses IDs)
+	Group         string   // consumer group, or coordinator key for FindCoordinator
+	Acks          int16    // Produce; 0 means the broker sends no response
+	MaxWaitMs     int32    // Fetch: how long the broker may hold the request
+	Offset        int
+	End           int
+}
+
+// Response is one broker response. Offsets index the server flow data.
+type Response struct {
+	CorrelationID int32
+	// Errors counts the error codes found in the response, top-level and
+	// per partition; Decoded is false when the body was not understood
+	Errors     map[int16]int
+	Decoded    bool
+	ThrottleMs int32
+	Bytes      int64 // Fetch: record bytes returned
+	Offset     int
+	End        int
+	Lost       bool // framing was lost after this response
+}
+
+// FirstError returns the first failing error code of the response
+func (r *Response) FirstError(key int16) (int16, bool) {
+	var first int16
+	found := false
+	for code := range r.Errors {
+		if IsError(key, code) && (!found || code < first) {
+			first, found = code, true
+		}
+	}
+	return first, found
+}
+
+// Exchange pairs a request with its response (nil if none was captured)
+type Exchange struct {
+	Request  *Request
+	Response *Response
+}
+
+// Conversation is a decoded Kafka connection
+type Conversation struct {
+	Exchanges []Exchange
+}
+
+// IsRequest reports whether data begins with a plausible request header
+func IsRequest(data []byte) bool {
+	if len(data) < 4+minRequestLen {
+		return false
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	key := int16(binary.BigEndian.Uint16(data[4:]))
+	version := int16(binary.BigEndian.Uint16(data[6:]))
+	clientID := int(int16(binary.BigEndian.Uint16(data[12:])))
+	if size < minRequestLen || size > maxFrameLen || key < 0 || int(key) >= len(apiNames) || version < 0 || version > 20 {
+		return false
+	}
+	if clientID < -1 || clientID > maxClientID || clientID > size-minRequestLen {
+		return false
+	}
+	if clientID > 0 && len(data) >= 14+clientID {
+		for _, c := range data[14 : 14+clientID] {
+			if c < 0x20 || c > 0x7e {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Parse decodes a Kafka connection from its client and server flows
+func Parse(client, server *reassembly.Flow) *Conversation {
+	conv := &Conversation{}
+	byID := map[int32]int{}
+	for _, f := range frames(client, IsRequest) {
+		req := decodeRequest(f.data)
+		req.Offset, req.End = f.offset, f.end
+		byID[req.CorrelationID] = len(conv.Exchanges)
+		conv.Exchanges = append(conv.Exchanges, Exchange{Request: req})
+	}
+	isResponse := func(data []byte) bool {
+		if len(data) < 8 {
+			return false
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		_, ok := byID[int32(binary.BigEndian.Uint32(data[4:]))]
+		return ok && size >= 4 && size <= maxFrameLen
+	}
+	for _, f := range frames(server, isResponse) {
+		id := int32(binary.BigEndian.Uint32(f.data))
+		i, ok := byID[id]
+		if !ok || conv.Exchanges[i].Response != nil {
+			continue
+		}
+		resp := decodeResponse(f.data, conv.Exchanges[i].Request)
+		resp.Offset, resp.End, resp.Lost = f.offset, f.end, f.lost
+		conv.Exchanges[i].Response = resp
+	}
+	return conv
+}
+
+// frame is one size-prefixed message: its captured bytes after the size
+// and its extent in the flow
+type frame struct {
+	data   []byte
+	offset int
+	end    int
+	lost   bool
+}
+
+// frames splits a flow into size-prefixed messages. After a gap swallows a
+// size field, framing resumes at the next TCP segment that starts a
+// plausible message.
+func frames(flow *reassembly.Flow, start func([]byte) bool) []frame {
+	var out []frame
+	pos := 0
+	for pos < len(flow.Data) {
+		hdr := flow.Contiguous(pos)
+		if !start(hdr) {
+			pos = resync(flow, pos+1, start)
+			continue
+		}
+		n := int(binary.BigEndian.Uint32(hdr))
+		body := hdr[4:]
+		if len(body) > n {
+			body = body[:n]
+		}
+		end, ok := flow.Skip(pos, int64(4+n))
+		out = append(out, frame{data: body, offset: pos, end: end, lost: !ok})
+		if !ok {
+			pos = resync(flow, end, start)
+			continue
+		}
+		pos = end
+	}
+	return out
+}
+
+func resync(flow *reassembly.Flow, from int, start func([]byte) bool) int {
+	for p := flow.NextPacketStart(from); p < len(flow.Data); p = flow.NextPacketStart(p + 1) {
+		if start(flow.Contiguous(p)) {
+			return p
+		}
+	}
+	return len(flow.Data)
+}
@@ -0,0 +1,172 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flow lays segments out back to back, one chunk each
+func flow(segs ...[]byte) *reassembly.Flow {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &reassembly.Flow{}
+	for i, s := range segs {
+		f.Chunks = append(f.Chunks, reassembly.Chunk{Offset: len(f.Data), Len: len(s), Timestamp: base.Add(time.Duration(i) * time.Millisecond), PacketIndex: i})
+		f.Data = append(f.Data, s...)
+	}
+	return f
+}
+
+// msg size-prefixes the encoded fields: integers by their width, strings
+// with an int16 length and byte slices with an int32 length
+func msg(fields ...any) []byte {
+	var b []byte
+	for _, f := range fields {
+		switch v := f.(type) {
+		case int8:
+			b = append(b, byte(v))
+		case int16:
+			b = binary.BigEndian.AppendUint16(b, uint16(v))
+		case int32:
+			b = binary.BigEndian.AppendUint32(b, uint32(v))
+		case int64:
+			b = binary.BigEndian.AppendUint64(b, uint64(v))
+		case string:
+			b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+			b = append(b, v...)
+		case []byte:
+			b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+		}
+	}
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...)
+}
+
+// conversation is a produce that hits a leader change, a fetch, a
+// heartbeat during a rebalance and a flexible ApiVersions
+func conversation() (client, server [][]byte) {
+	records := []byte("0123456789")
+	client = [][]byte{
+		msg(int16(APIProduce), int16(3), int32(1), "app",
+			int16(-1), int16(1), int32(30000),
+			int32(1), "orders", int32(1), int32(0), records),
+		msg(int16(APIFetch), int16(4), int32(2), "app",
+			int32(-1), int32(500), int32(1), int32(1<<20), int8(0),
+			int32(1), "orders", int32(1), int32(0), int64(42), int32(1<<20)),
+		msg(int16(APIHeartbeat), int16(1), int32(3), "app", "g1", int32(7), "member-1"),
+		msg(int16(APIApiVersions), int16(3), int32(4), "app", int8(0), int8(0), int8(0), int8(0)),
+	}
+	server = [][]byte{
+		msg(int32(1), int32(1), "orders", int32(1), int32(0), int16(6), int64(-1), int64(-1), int32(0)),
+		msg(int32(2), int32(0), int32(1), "orders", int32(1), int32(0), int16(0), int64(43), int64(43), int32(0), records),
+		msg(int32(3), int32(0), int16(27)),
+		msg(int32(4), int16(0), int8(1), int32(0), int8(0)),
+	}
+	return client, server
+}
+
+func TestParse(t *testing.T) {
+	c, s := conversation()
+	for i, req := range c {
+		if !IsRequest(req) {
+			t.Errorf("request %d not recognized", i)
+		}
+	}
+	conv := Parse(flow(c...), flow(s...))
+	if len(conv.Exchanges) != 4 {
+		t.Fatalf("got %d exchanges, want 4", len(conv.Exchanges))
+	}
+	for i, ex := range conv.Exchanges {
+		if ex.Response == nil || !ex.Response.Decoded || ex.Response.CorrelationID != ex.Request.CorrelationID {
+			t.Errorf("exchange %d: %s answered %+v", i, APIName(ex.Request.APIKey), ex.Response)
+		}
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	produce, fetch, heartbeat := conv.Exchanges[0], conv.Exchanges[1], conv.Exchanges[2]
+	if r := produce.Request; r.ClientID != "app" || r.Acks != 1 || len(r.Topics) != 1 || r.Topics[0] != "orders" {
+		t.Errorf("produce = %+v", r)
+	}
+	if code, ok := produce.Response.FirstError(APIProduce); !ok || code != 6 {
+		t.Errorf("produce error = %s, %v, want NOT_LEADER_OR_FOLLOWER", ErrorName(code), ok)
+	}
+	if r := fetch.Request; r.MaxWaitMs != 500 || len(r.Topics) != 1 {
+		t.Errorf("fetch = %+v", r)
+	}
+	if fetch.Response.Bytes != 10 {
+		t.Errorf("fetched %d bytes, want 10", fetch.Response.Bytes)
+	}
+	if heartbeat.Request.Group != "g1" || heartbeat.Response.Errors[27] != 1 {
+		t.Errorf("heartbeat = %+v answered %+v", heartbeat.Request, heartbeat.Response)
+	}
+	if _, ok := heartbeat.Response.FirstError(APIHeartbeat); ok {
+		t.Error("a rebalance counted as a heartbeat failure")
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	header := func(key, version int16) []any {
+		return []any{key, version, int32(1), "app"}
+	}
+	tests := []struct {
+		name string
+		req  []byte
+	}{
+		{"huge topic array", msg(append(header(APIProduce, 3), int16(-1), int16(1), int32(0), int32(1<<30))...)},
+		{"negative partitions", msg(append(header(APIProduce, 3), int16(-1), int16(1), int32(0), int32(1), "t", int32(-5))...)},
+		{"huge compact length", msg(append(header(APIProduce, 9), int8(0), int8(0x7f), int8(-1), int8(-1), int8(-1), int8(-1), int8(0x7f))...)},
+		{"huge tag count", msg(append(header(APIHeartbeat, 4), int8(-1), int8(-1), int8(0x7f))...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := Parse(flow(tt.req), flow())
+			if len(conv.Exchanges) != 1 {
+				t.Errorf("got %d exchanges, want 1", len(conv.Exchanges))
+			}
+		})
+	}
+}
+
+// Every prefix of a conversation must parse without panicking
+func TestTruncated(t *testing.T) {
+	c, s := conversation()
+	client, server := bytes.Join(c, nil), bytes.Join(s, nil)
+	for i := 0; i <= len(client); i++ {
+		check(t, client[:i], server[:min(i, len(server))])
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	c, s := conversation()
+	f.Add(bytes.Join(c, nil), bytes.Join(s, nil))
+	for i := range c {
+		f.Add(c[i], s[i])
+	}
+	f.Fuzz(func(t *testing.T, client, server []byte) {
+		check(t, client, server)
+	})
+}
+
+// check parses the flows whole and split in two segments
+func check(t *testing.T, client, server []byte) {
+	t.Helper()
+	flows := [][2]*reassembly.Flow{
+		{flow(client), flow(server)},
+		{flow(client[:len(client)/2], client[len(client)/2:]), flow(server[:len(server)/2], server[len(server)/2:])},
+	}
+	for _, fl := range flows {
+		for _, ex := range Parse(fl[0], fl[1]).Exchanges {
+			if r := ex.Request; r.Offset < 0 || r.End < r.Offset {
+				t.Fatalf("request at %d-%d", r.Offset, r.End)
+			}
+			if r := ex.Response; r != nil && (r.Offset < 0 || r.End < r.Offset || r.Bytes < 0) {
+				t.Fatalf("response = %+v", r)
+			}
+		}
+	}
+}
@@ -0,0 +1,398 @@
+// Package mqtt decodes MQTT 3.1, 3.1.1 and 5.0 control packets from
+// reassembled TCP flows and pairs them with their acknowledgements:
+// CONNECT with CONNACK, QoS 1 and 2 PUBLISH with PUBACK or PUBREC/PUBCOMP
+// in either direction, SUBSCRIBE and UNSUBSCRIBE with their acks and
+// PINGREQ with PINGRESP.
+package mqtt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// Control packet types
+const (
+	Connect     = 1
+	ConnAck     = 2
+	Publish     = 3
+	PubAck      = 4
+	PubRec      = 5
+	PubRel      = 6
+	PubComp     = 7
+	Subscribe   = 8
+	SubAck      = 9
+	Unsubscribe = 10
+	UnsubAck    = 11
+	PingReq     = 12
+	PingResp    = 13
+	Disconnect  = 14
+	Auth        = 15
+)
+
+var typeNames = []string{
+	"RESERVED", "CONNECT", "CONNACK", "PUBLISH", "PUBACK", "PUBREC", "PUBREL", "PUBCOMP",
+	"SUBSCRIBE", "SUBACK", "UNSUBSCRIBE", "UNSUBACK", "PINGREQ", "PINGRESP", "DISCONNECT", "AUTH",
+}
+
+// TypeName returns the name of a control packet type
+func TypeName(t byte) string {
+	return typeNames[t&0x0f]
+}
+
+const maxTopics = 8 // subscription filters kept per packet
+
+// Packet is one control packet. Offsets index the data of the flow it was
+// sent on.
+type Packet struct {
+	Type       byte
+	FromClient bool
+	At         time.Time
+	PacketID   uint16
+	QoS        byte
+	Retain     bool
+	Dup        bool
+	Topic      string   // PUBLISH
+	Filters    []string // SUBSCRIBE and UNSUBSCRIBE topic filters
+	Code       byte     // CONNACK return code, MQTT 5 reason code of an ack
+	Codes      []byte   // SUBACK and MQTT 5 UNSUBACK return codes
+	Payload    int      // PUBLISH payload length
+
+	// CONNECT
+	Version      byte // protocol level: 3 (3.1), 4 (3.1.1) or 5
+	ClientID     string
+	KeepAlive    uint16 // seconds
+	CleanSession bool
+	Username     bool
+
+	Offset int
+	End    int
+}
+
+// Exchange pairs a packet with the packet that completes it. For QoS 2
+// publishes Received is the PUBREC and Response the PUBCOMP.
+type Exchange struct {
+	Request  *Packet
+	Received *Packet
+	Response *Packet
+}
+
+// Conversation is a decoded MQTT connection
+type Conversation struct {
+	Connect   *Packet // nil if the connection was captured after CONNECT
+	Exchanges []Exchange
+	Packets   []*Packet // every packet of both directions in time order
+}
+
+// Version returns the negotiated protocol level, assuming 3.1.1 when the
+// CONNECT was not captured
+func (c *Conversation) Version() byte {
+	if c.Connect != nil {
+		return c.Connect.Version
+	}
+	return 4
+}
+
+// VersionName returns the MQTT release of a protocol level
+func VersionName(level byte) string {
+	switch level {
+	case 3:
+		return "3.1"
+	case 4:
+		return "3.1.1"
+	case 5:
+		return "5.0"
+	}
+	return fmt.Sprintf("level %d", level)
+}
+
+// IsConnect reports whether data begins with a CONNECT packet
+func IsConnect(data []byte) bool {
+	if len(data) < 2 || data[0] != Connect<<4 {
+		return false
+	}
+	_, n, ok := remainingLength(data[1:])
+	if !ok {
+		return false
+	}
+	name := data[1+n:]
+	return len(name) >= 6 && (string(name[:6]) == "\x00\x04MQTT" || len(name) >= 8 && string(name[:8]) == "\x00\x06MQIsdp")
+}
+
+// isStart reports whether data begins with a plausible fixed header
+func isStart(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	typ, flags := data[0]>>4, data[0]&0x0f
+	switch typ {
+	case 0:
+		return false
+	case Publish:
+		if flags>>1&3 == 3 {
+			return false
+		}
+	case PubRel, Subscribe, Unsubscribe:
+		if flags != 2 {
+			return false
+		}
+	default:
+		if flags != 0 {
+			return false
+		}
+	}
+	_, _, ok := remainingLength(data[1:])
+	return ok
+}
+
+// remainingLength decodes the variable-length remaining length field
+func remainingLength(b []byte) (length, n int, ok bool) {
+	mult := 1
+	for n < 4 && n < len(b) {
+		c := b[n]
+		length += int(c&0x7f) * mult
+		n++
+		if c&0x80 == 0 {
+			return length, n, true
+		}
+		mult <<= 7
+	}
+	return 0, 0, false
+}
+
+// Parse decodes an MQTT connection from its client and server flows
+func Parse(client, server *reassembly.Flow) *Conversation {
+	conv := &Conversation{}
+	version := byte(4)
+	clientPkts := packets(client, true, &version)
+	conv.Packets = append(clientPkts, packets(server, false, &version)...)
+	sort.SliceStable(conv.Packets, func(i, j int) bool { return conv.Packets[i].At.Before(conv.Packets[j].At) })
+
+	type key struct {
+		fromClient bool
+		id         uint16
+	}
+	pending := map[key]int{} // PUBLISH, SUBSCRIBE, UNSUBSCRIBE awaiting their ack
+	var connect []int        // awaiting CONNACK, oldest first
+	ping := -1               // the last PINGREQ, while unanswered
+	add := func(p *Packet) int {
+		conv.Exchanges = append(conv.Exchanges, Exchange{Request: p})
+		return len(conv.Exchanges) - 1
+	}
+	// ack completes the exchange the peer of its sender opened
+	ack := func(p *Packet, final bool) {
+		k := key{!p.FromClient, p.PacketID}
+		i, ok := pending[k]
+		if !ok {
+			return
+		}
+		if final {
+			conv.Exchanges[i].Response = p
+			delete(pending, k)
+		} else {
+			conv.Exchanges[i].Received = p
+		}
+	}
+
+	for _, p := range conv.Packets {
+		switch p.Type {
+		case Connect:
+			if p.FromClient {
+				if conv.Connect == nil {
+					conv.Connect = p
+				}
+				connect = append(connect, add(p))
+			}
+		case ConnAck:
+			if len(connect) > 0 && !p.FromClient {
+				conv.Exchanges[connect[0]].Response = p
+				connect = connect[1:]
+			}
+		case Publish:
+			i := add(p)
+			if p.QoS > 0 {
+				pending[key{p.FromClient, p.PacketID}] = i
+			}
+		case Subscribe, Unsubscribe:
+			if p.FromClient {
+				pending[key{true, p.PacketID}] = add(p)
+			}
+		case PubAck, PubComp, SubAck, UnsubAck:
+			ack(p, true)
+		case PubRec:
+			ack(p, false)
+		case PingReq:
+			// Clients ping once per keep alive interval, so an earlier
+			// ping still open when the next one goes out was not answered
+			if p.FromClient {
+				ping = add(p)
+			}
+		case PingResp:
+			if ping >= 0 && !p.FromClient {
+				conv.Exchanges[ping].Response = p
+				ping = -1
+			}
+		}
+	}
+	return conv
+}
+
+// packets frames the control packets of one flow. The protocol level of
+// the CONNECT decides how later packets are decoded.
+func packets(flow *reassembly.Flow, fromClient bool, version *byte) []*Packet {
+	var out []*Packet
+	pos := 0
+	for pos < len(flow.Data) {
+		hdr := flow.Contiguous(pos)
+		if !isStart(hdr) {
+			pos = resync(flow, pos+1)
+			continue
+		}
+		length, n, _ := remainingLength(hdr[1:])
+		end, ok := flow.Skip(pos, int64(1+n+length))
+		body := hdr[1+n:]
+		if len(body) > length {
+			body = body[:length]
+		}
+		p := decode(hdr[0], body, length, *version)
+		p.FromClient, p.At, p.Offset, p.End = fromClient, flow.TimeAt(pos), pos, end
+		if p.Type == Connect && p.Version != 0 {
+			*version = p.Version
+		}
+		out = append(out, p)
+		if !ok {
+			pos = resync(flow, end)
+			continue
+		}
+		pos = end
+	}
+	return out
+}
+
+func resync(flow *reassembly.Flow, from int) int {
+	for p := flow.NextPacketStart(from); p < len(flow.Data); p = flow.NextPacketStart(p + 1) {
+		if isStart(flow.Contiguous(p)) {
+			return p
+		}
+	}
+	return len(flow.Data)
+}
+
+// cursor is a bounds-checked reader over a packet's variable header
+type cursor struct {
+	b  []byte
+	ok bool
+}
+
+func (c *cursor) take(n int) []byte {
+	if !c.ok || n < 0 || len(c.b) < n {
+		c.ok = false
+		return nil
+	}
+	v := c.b[:n]
+	c.b = c.b[n:]
+	return v
+}
+
+func (c *cursor) byte() byte {
+	if b := c.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (c *cursor) u16() uint16 {
+	if b := c.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (c *cursor) str() string {
+	return string(c.take(int(c.u16())))
+}
+
+// properties skips an MQTT 5 property list
+func (c *cursor) properties() {
+	n, size, ok := remainingLength(c.b)
+	if !ok {
+		c.ok = false
+		return
+	}
+	c.take(size + n)
+}
+
+// decode reads the variable header of one packet. length is the full
+// remaining length; body may be shorter when the capture cut it.
+func decode(first byte, body []byte, length int, version byte) *Packet {
+	p := &Packet{Type: first >> 4}
+	c := &cursor{b: body, ok: true}
+	v5 := version == 5
+	switch p.Type {
+	case Connect:
+		name := c.str()
+		p.Version = c.byte()
+		if name != "MQTT" && name != "MQIsdp" {
+			p.Version = 0
+		}
+		flags := c.byte()
+		p.CleanSession = flags&0x02 != 0
+		p.Username = flags&0x80 != 0
+		p.KeepAlive = c.u16()
+		if p.Version == 5 {
+			c.properties()
+		}
+		p.ClientID = c.str()
+	case ConnAck:
+		c.byte() // session present
+		p.Code = c.byte()
+	case Publish:
+		p.QoS = first >> 1 & 3
+		p.Retain = first&1 != 0
+		p.Dup = first&8 != 0
+		p.Topic = c.str()
+		header := 2 + len(p.Topic)
+		if p.QoS > 0 {
+			p.PacketID = c.u16()
+			header += 2
+		}
+		if v5 {
+			before := len(c.b)
+			c.properties()
+			header += before - len(c.b)
+		}
+		p.Payload = max(length-header, 0)
+	case PubAck, PubRec, PubRel, PubComp:
+		p.PacketID = c.u16()
+		if v5 && length > 2 {
+			p.Code = c.byte()
+		}
+	case Subscribe, Unsubscribe:
+		p.PacketID = c.u16()
+		if v5 {
+			c.properties()
+		}
+		for c.ok && len(c.b) > 0 && len(p.Filters) < maxTopics {
+			p.Filters = append(p.Filters, c.str())
+			if p.Type == Subscribe {
+				c.byte() // options
+			}
+		}
+	case SubAck, UnsubAck:
+		p.PacketID = c.u16()
+		if v5 {
+			c.properties()
+		}
+		if p.Type == SubAck || v5 {
+			p.Codes = append(p.Codes, c.b...)
+		}
+	case Disconnect:
+		if v5 && length > 0 {
+			p.Code = c.byte()
+		}
+	}
+	return p
+}
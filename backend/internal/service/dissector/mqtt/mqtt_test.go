@@ -0,0 +1,194 @@
+package mqtt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flow lays segments out back to back, one chunk each, starting at start
+// and 2ms apart, so that client and server segments interleave
+func flow(start time.Duration, segs ...[]byte) *reassembly.Flow {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(start)
+	f := &reassembly.Flow{}
+	for i, s := range segs {
+		f.Chunks = append(f.Chunks, reassembly.Chunk{Offset: len(f.Data), Len: len(s), Timestamp: base.Add(time.Duration(i) * 2 * time.Millisecond), PacketIndex: i})
+		f.Data = append(f.Data, s...)
+	}
+	return f
+}
+
+// pkt builds a control packet from its first byte and body
+func pkt(first byte, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	out := []byte{first}
+	for n := len(b); ; {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			c |= 0x80
+		}
+		out = append(out, c)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, b...)
+}
+
+func str(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func raw(b ...byte) []byte {
+	return b
+}
+
+// session is an MQTT 5 connection that subscribes, publishes at QoS 1,
+// receives a QoS 2 publish and pings
+func session() (client, server [][]byte) {
+	client = [][]byte{
+		pkt(Connect<<4, str("MQTT"), raw(5, 0x82, 0, 60, 5, 0x11, 0, 0, 0, 30), str("sensor-1"), str("u")),
+		pkt(Subscribe<<4|2, raw(0, 1, 0), str("a/#"), raw(1), str("b"), raw(0)),
+		pkt(Publish<<4|2, str("t/a"), raw(0, 2, 0), []byte("hello")),
+		pkt(PubRec<<4, raw(0, 3)),
+		bytes.Join([][]byte{pkt(PubComp<<4, raw(0, 3)), pkt(PingReq << 4)}, nil),
+	}
+	server = [][]byte{
+		pkt(ConnAck<<4, raw(0, 0, 0)),
+		pkt(SubAck<<4, raw(0, 1, 0, 1, 0x87)),
+		bytes.Join([][]byte{pkt(PubAck<<4, raw(0, 2)), pkt(Publish<<4|4, str("t/b"), raw(0, 3, 0), []byte("x"))}, nil),
+		pkt(PubRel<<4|2, raw(0, 3)),
+		pkt(PingResp << 4),
+	}
+	return client, server
+}
+
+func TestParse(t *testing.T) {
+	c, s := session()
+	if !IsConnect(c[0]) {
+		t.Error("CONNECT not recognized")
+	}
+	conv := Parse(flow(0, c...), flow(time.Millisecond, s...))
+	if conn := conv.Connect; conn == nil || conv.Version() != 5 || conn.ClientID != "sensor-1" || conn.KeepAlive != 60 || !conn.Username || !conn.CleanSession {
+		t.Fatalf("connect = %+v", conv.Connect)
+	}
+	if len(conv.Packets) != 12 {
+		t.Errorf("got %d packets, want 12", len(conv.Packets))
+	}
+
+	want := []struct {
+		req, resp  byte
+		fromClient bool
+	}{
+		{Connect, ConnAck, true},
+		{Subscribe, SubAck, true},
+		{Publish, PubAck, true},
+		{Publish, PubComp, false},
+		{PingReq, PingResp, true},
+	}
+	if len(conv.Exchanges) != len(want) {
+		t.Fatalf("got %d exchanges, want %d", len(conv.Exchanges), len(want))
+	}
+	for i, w := range want {
+		ex := conv.Exchanges[i]
+		if ex.Request.Type != w.req || ex.Request.FromClient != w.fromClient || ex.Response == nil || ex.Response.Type != w.resp {
+			t.Errorf("exchange %d = %s answered %+v, want %s answered %s", i, TypeName(ex.Request.Type), ex.Response, TypeName(w.req), TypeName(w.resp))
+		}
+	}
+
+	sub := conv.Exchanges[1]
+	if f := sub.Request.Filters; len(f) != 2 || f[0] != "a/#" || f[1] != "b" {
+		t.Errorf("filters = %q", f)
+	}
+	if codes := sub.Response.Codes; !bytes.Equal(codes, []byte{1, 0x87}) {
+		t.Errorf("SUBACK codes = %v", codes)
+	}
+	if p := conv.Exchanges[2].Request; p.Topic != "t/a" || p.QoS != 1 || p.PacketID != 2 || p.Payload != 5 {
+		t.Errorf("publish = %+v", p)
+	}
+	if ex := conv.Exchanges[3]; ex.Received == nil || ex.Received.Type != PubRec || ex.Request.Payload != 1 {
+		t.Errorf("QoS 2 publish = %+v received %+v", ex.Request, ex.Received)
+	}
+}
+
+func TestParsePings(t *testing.T) {
+	// The broker missed the second ping and answered the third
+	ping, pong := pkt(PingReq<<4), pkt(PingResp<<4)
+	client := flow(0, ping, ping, ping)
+	server := &reassembly.Flow{Data: append(append([]byte(nil), pong...), pong...)}
+	base := client.Chunks[0].Timestamp
+	server.Chunks = []reassembly.Chunk{
+		{Offset: 0, Len: 2, Timestamp: base.Add(500 * time.Microsecond)},
+		{Offset: 2, Len: 2, Timestamp: base.Add(4500 * time.Microsecond), PacketIndex: 1},
+	}
+
+	conv := Parse(client, server)
+	if len(conv.Exchanges) != 3 {
+		t.Fatalf("got %d exchanges, want 3", len(conv.Exchanges))
+	}
+	for i, answered := range []bool{true, false, true} {
+		if got := conv.Exchanges[i].Response != nil; got != answered {
+			t.Errorf("ping %d answered = %v, want %v", i, got, answered)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   byte
+		body    []byte
+		version byte
+	}{
+		{"connect without name", Connect << 4, nil, 4},
+		{"property length past end", Connect << 4, append(str("MQTT"), 5, 0, 0, 60, 0xff, 0xff, 0xff, 0x7f), 5},
+		{"unterminated property length", Publish << 4, append(str("t"), 0xff, 0xff, 0xff, 0xff), 5},
+		{"topic past end", Publish << 4, []byte{0xff, 0xff, 't'}, 4},
+		{"filter past end", Subscribe<<4 | 2, []byte{0, 1, 0, 9, 'a'}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p := decode(tt.first, tt.body, len(tt.body), tt.version); p.Payload < 0 || len(p.Filters) > maxTopics {
+				t.Errorf("packet = %+v", p)
+			}
+		})
+	}
+}
+
+// Every prefix of a session must parse without panicking
+func TestTruncated(t *testing.T) {
+	c, s := session()
+	client, server := bytes.Join(c, nil), bytes.Join(s, nil)
+	for i := 0; i <= len(client); i++ {
+		check(t, client[:i], server[:min(i, len(server))])
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	c, s := session()
+	f.Add(bytes.Join(c, nil), bytes.Join(s, nil))
+	f.Add(c[0], s[0])
+	f.Add(pkt(Connect<<4, str("MQIsdp"), raw(3, 2, 0, 10), str("old")), pkt(ConnAck<<4, raw(0, 5)))
+	f.Fuzz(func(t *testing.T, client, server []byte) {
+		check(t, client, server)
+	})
+}
+
+// check parses the flows whole and split in two segments
+func check(t *testing.T, client, server []byte) {
+	t.Helper()
+	flows := [][2]*reassembly.Flow{
+		{flow(0, client), flow(time.Millisecond, server)},
+		{flow(0, client[:len(client)/2], client[len(client)/2:]), flow(time.Millisecond, server[:len(server)/2], server[len(server)/2:])},
+	}
+	for _, fl := range flows {
+		for _, p := range Parse(fl[0], fl[1]).Packets {
+			if p.Offset < 0 || p.End < p.Offset || p.Payload < 0 {
+				t.Fatalf("packet = %+v", p)
+			}
+		}
+	}
+}
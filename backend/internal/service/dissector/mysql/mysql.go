@@ -0,0 +1,520 @@
+// Package mysql decodes the MySQL client/server protocol from reassembled
+// TCP flows: the connection handshake, commands and their responses.
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// Command codes
+const (
+	ComQuit            = 0x01
+	ComInitDB          = 0x02
+	ComQuery           = 0x03
+	ComFieldList       = 0x04
+	ComStatistics      = 0x09
+	ComProcessInfo     = 0x0a
+	ComProcessKill     = 0x0c
+	ComPing            = 0x0e
+	ComChangeUser      = 0x11
+	ComBinlogDump      = 0x12
+	ComStmtPrepare     = 0x16
+	ComStmtExecute     = 0x17
+	ComStmtSendLong    = 0x18
+	ComStmtClose       = 0x19
+	ComStmtReset       = 0x1a
+	ComSetOption       = 0x1b
+	ComStmtFetch       = 0x1c
+	ComResetConnection = 0x1f
+)
+
+const (
+	clientSSL        = 0x00000800
+	clientConnectDB  = 0x00000008
+	maxPacketPayload = 0xffffff
+)
+
+var commandNames = map[byte]string{
+	ComQuit:            "QUIT",
+	ComInitDB:          "INIT_DB",
+	ComQuery:           "QUERY",
+	ComFieldList:       "FIELD_LIST",
+	ComStatistics:      "STATISTICS",
+	ComProcessInfo:     "PROCESS_INFO",
+	ComProcessKill:     "PROCESS_KILL",
+	ComPing:            "PING",
+	ComChangeUser:      "CHANGE_USER",
+	ComBinlogDump:      "BINLOG_DUMP",
+	ComStmtPrepare:     "PREPARE",
+	ComStmtExecute:     "EXECUTE",
+	ComStmtSendLong:    "SEND_LONG_DATA",
+	ComStmtClose:       "CLOSE_STMT",
+	ComStmtReset:       "RESET_STMT",
+	ComSetOption:       "SET_OPTION",
+	ComStmtFetch:       "FETCH",
+	ComResetConnection: "RESET_CONNECTION",
+}
+
+// CommandName returns the name of a command code without its COM_ prefix
+func CommandName(code byte) string {
+	if name, ok := commandNames[code]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// Response kinds
+const (
+	ResponseOK        = "OK"
+	ResponseError     = "ERR"
+	ResponseResultSet = "RESULTSET"
+	ResponseEOF       = "EOF"
+	ResponseInfile    = "LOCAL_INFILE"
+)
+
+// Packet is one protocol packet. Offsets index the flow data; Body holds
+// the captured part of the payload, which may be cut short by a gap.
+type Packet struct {
+	Offset int
+	End    int
+	Seq    byte
+	Len    int
+	Body   []byte
+	Lost   bool // framing was lost after this packet
+}
+
+// Handshake describes the connection phase
+type Handshake struct {
+	ServerVersion string
+	ConnectionID  uint32
+	User          string
+	Database      string
+	TLS           bool // the client switched to TLS after the greeting
+	Offset        int  // client handshake response in the client flow
+	End           int
+	Result        *Response // final authentication packet, nil if not seen
+}
+
+// Command is one client command. Offsets index the client flow data.
+type Command struct {
+	Code      byte
+	Name      string
+	Statement string // query text, schema name or the prepared statement executed
+	StmtID    uint32 // EXECUTE, FETCH, CLOSE_STMT, RESET_STMT
+	Offset    int
+	End       int
+	Truncated bool // the statement was longer than the captured payload
+}
+
+// ExpectsResponse reports whether the server answers this command
+func (c Command) ExpectsResponse() bool {
+	switch c.Code {
+	case ComQuit, ComStmtSendLong, ComStmtClose:
+		return false
+	}
+	return true
+}
+
+// Response is the server's answer to one command. Offsets index the server
+// flow data.
+type Response struct {
+	Kind         string
+	ErrorCode    int
+	SQLState     string
+	Message      string
+	AffectedRows uint64
+	Columns      int
+	Rows         int
+	StmtID       uint32 // PREPARE
+	Offset       int
+	End          int
+	Incomplete   bool // a gap or the end of the capture cut the response short
+}
+
+// Exchange pairs a command with its response (nil if none was captured)
+type Exchange struct {
+	Command  Command
+	Response *Response
+}
+
+// Conversation is a decoded MySQL connection
+type Conversation struct {
+	Handshake *Handshake // nil if the capture started after the handshake
+	Exchanges []Exchange
+}
+
+// IsGreeting reports whether data begins with a server greeting
+// (protocol version 10 handshake packet with sequence id 0)
+func IsGreeting(data []byte) bool {
+	if len(data) < 5+1 || data[3] != 0 || data[4] != 10 {
+		return false
+	}
+	n := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	if n < 30 || n > 1024 {
+		return false
+	}
+	// The server version is a printable NUL-terminated string
+	version := data[5:]
+	end := bytes.IndexByte(version, 0)
+	if end <= 0 {
+		return false
+	}
+	for _, c := range version[:end] {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// LooksLikeCommand reports whether data begins with a plausible command
+// packet, for connections captured after the handshake
+func LooksLikeCommand(data []byte) bool {
+	if len(data) < 5 || data[3] != 0 {
+		return false
+	}
+	n := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	if n == 0 {
+		return false
+	}
+	switch data[4] {
+	case ComQuery, ComStmtPrepare:
+		return n > 1 && len(data) > 5 && (isStatementStart(data[5]) || data[5] == 0)
+	case ComPing, ComQuit, ComStatistics, ComResetConnection:
+		return n == 1
+	case ComInitDB:
+		return n > 1
+	case ComStmtExecute, ComStmtClose, ComStmtReset, ComStmtFetch:
+		return n >= 5
+	}
+	return false
+}
+
+func isStatementStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '(' || c == '/' || c == ' ' || c == '\n' || c == '\t' || c == '-'
+}
+
+// Parse decodes a MySQL connection from its client and server flows
+func Parse(client, server *reassembly.Flow) *Conversation {
+	conv := &Conversation{}
+	cpkts := packets(client, true)
+	spkts := packets(server, false)
+
+	// Connection phase: greeting, handshake response, authentication
+	si, ci := 0, 0
+	if len(spkts) > 0 && spkts[0].Seq == 0 && len(spkts[0].Body) > 0 && spkts[0].Body[0] == 10 {
+		hs := parseGreeting(spkts[0].Body)
+		si = 1
+		if len(cpkts) > 0 && cpkts[0].Seq == 1 {
+			parseHandshakeResponse(hs, cpkts[0])
+			ci = 1
+		}
+		if !hs.TLS {
+			// Authentication continues until the server sends OK or ERR;
+			// auth switch and more-data packets carry on the sequence
+			for ; si < len(spkts); si++ {
+				p := spkts[si]
+				if len(p.Body) > 0 && (p.Body[0] == 0x00 || p.Body[0] == 0xff) && p.Seq >= 2 {
+					r := parseFirst(p)
+					hs.Result = &r
+					si++
+					break
+				}
+			}
+			for ci < len(cpkts) && cpkts[ci].Seq != 0 {
+				ci++
+			}
+		}
+		conv.Handshake = hs
+		if hs.TLS {
+			return conv
+		}
+	}
+
+	// Commands start with sequence id 0, responses with 1
+	var cmds []Command
+	for _, p := range cpkts[ci:] {
+		if p.Seq != 0 || len(p.Body) == 0 {
+			continue
+		}
+		cmds = append(cmds, parseCommand(p))
+	}
+	var resps []*Response
+	var group []Packet
+	flush := func() {
+		if len(group) > 0 {
+			resps = append(resps, parseResponse(group))
+		}
+		group = nil
+	}
+	for _, p := range spkts[si:] {
+		if p.Seq == 1 {
+			flush()
+		}
+		if p.Seq == 1 || len(group) > 0 {
+			group = append(group, p)
+		}
+	}
+	flush()
+
+	// Commands are not pipelined, so responses answer them in order.
+	// Responses sent before a command (its predecessor's response was lost,
+	// or the capture began mid-exchange) cannot answer it.
+	prepared := map[uint32]string{}
+	ri := 0
+	for _, c := range cmds {
+		ex := Exchange{Command: c}
+		sent := client.TimeAt(c.Offset)
+		for ri < len(resps) && server.TimeAt(resps[ri].Offset).Before(sent) {
+			ri++
+		}
+		if c.ExpectsResponse() && ri < len(resps) {
+			ex.Response = resps[ri]
+			ri++
+		}
+		switch c.Code {
+		case ComStmtPrepare:
+			if ex.Response != nil && ex.Response.Kind == ResponseOK {
+				prepared[ex.Response.StmtID] = c.Statement
+			}
+		case ComStmtExecute, ComStmtFetch, ComStmtReset, ComStmtClose:
+			ex.Command.Statement = prepared[c.StmtID]
+		}
+		conv.Exchanges = append(conv.Exchanges, ex)
+	}
+	return conv
+}
+
+// packets frames a flow into protocol packets. After a gap swallows a
+// packet header, framing resumes at the next TCP segment that starts a
+// command (client) or a response (server).
+func packets(flow *reassembly.Flow, client bool) []Packet {
+	var pkts []Packet
+	pos := 0
+	for pos < len(flow.Data) {
+		hdr := flow.Contiguous(pos)
+		if len(hdr) < 4 {
+			pos = resync(flow, pos+1, client)
+			continue
+		}
+		n := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+		end, ok := flow.Skip(pos, int64(4+n))
+		body := hdr[4:]
+		if len(body) > n {
+			body = body[:n]
+		}
+		pkts = append(pkts, Packet{Offset: pos, End: end, Seq: hdr[3], Len: n, Body: body, Lost: !ok})
+		if !ok {
+			pos = resync(flow, end, client)
+			continue
+		}
+		pos = end
+	}
+	return pkts
+}
+
+func resync(flow *reassembly.Flow, from int, client bool) int {
+	for p := flow.NextPacketStart(from); p < len(flow.Data); p = flow.NextPacketStart(p + 1) {
+		hdr := flow.Contiguous(p)
+		if len(hdr) < 5 {
+			continue
+		}
+		if client && LooksLikeCommand(hdr) || !client && hdr[3] == 1 {
+			return p
+		}
+	}
+	return len(flow.Data)
+}
+
+func parseGreeting(b []byte) *Handshake {
+	hs := &Handshake{}
+	r := b[1:]
+	if i := bytes.IndexByte(r, 0); i >= 0 {
+		hs.ServerVersion = string(r[:i])
+		r = r[i+1:]
+	}
+	if len(r) >= 4 {
+		hs.ConnectionID = binary.LittleEndian.Uint32(r)
+	}
+	return hs
+}
+
+func parseHandshakeResponse(hs *Handshake, p Packet) {
+	hs.Offset, hs.End = p.Offset, p.End
+	b := p.Body
+	if len(b) < 4 {
+		return
+	}
+	caps := binary.LittleEndian.Uint32(b)
+	// An SSL request is the first 32 bytes of a handshake response
+	if p.Len == 32 && caps&clientSSL != 0 {
+		hs.TLS = true
+		return
+	}
+	if len(b) < 32 {
+		return
+	}
+	r := b[32:]
+	i := bytes.IndexByte(r, 0)
+	if i < 0 {
+		return
+	}
+	hs.User = string(r[:i])
+	r = r[i+1:]
+	// Auth response: length-prefixed (1 byte) in all clients since 4.1
+	if len(r) == 0 || int(r[0])+1 > len(r) {
+		return
+	}
+	r = r[1+int(r[0]):]
+	if caps&clientConnectDB != 0 {
+		if i := bytes.IndexByte(r, 0); i >= 0 {
+			hs.Database = string(r[:i])
+		}
+	}
+}
+
+func parseCommand(p Packet) Command {
+	c := Command{Code: p.Body[0], Name: CommandName(p.Body[0]), Offset: p.Offset, End: p.End}
+	arg := p.Body[1:]
+	switch c.Code {
+	case ComQuery, ComStmtPrepare, ComInitDB, ComFieldList:
+		c.Statement = strings.TrimSpace(string(arg))
+		c.Truncated = len(arg) < p.Len-1 || p.Len == maxPacketPayload
+		if c.Code == ComQuery && len(arg) > 2 && arg[0] == 0 && arg[1] == 1 {
+			// MariaDB/MySQL 8 query attributes prefix: no attributes
+			c.Statement = strings.TrimSpace(string(arg[2:]))
+		}
+	case ComStmtExecute, ComStmtFetch, ComStmtReset, ComStmtClose:
+		if len(arg) >= 4 {
+			c.StmtID = binary.LittleEndian.Uint32(arg)
+		}
+	}
+	return c
+}
+
+// parseFirst decodes a response from its first packet
+func parseFirst(p Packet) Response {
+	r := Response{Offset: p.Offset, End: p.End}
+	b := p.Body
+	if len(b) == 0 {
+		r.Incomplete = true
+		return r
+	}
+	switch {
+	case b[0] == 0x00 && p.Len >= 7:
+		r.Kind = ResponseOK
+		r.AffectedRows, _ = lenenc(b[1:])
+		if p.Len >= 12 && len(b) >= 5 {
+			// COM_STMT_PREPARE OK carries the statement id
+			r.StmtID = binary.LittleEndian.Uint32(b[1:])
+		}
+	case b[0] == 0xff:
+		r.Kind = ResponseError
+		if len(b) >= 3 {
+			r.ErrorCode = int(binary.LittleEndian.Uint16(b[1:]))
+		}
+		msg := b[min(3, len(b)):]
+		if len(msg) >= 6 && msg[0] == '#' {
+			r.SQLState = string(msg[1:6])
+			msg = msg[6:]
+		}
+		r.Message = string(msg)
+	case b[0] == 0xfe && p.Len < 9:
+		r.Kind = ResponseEOF
+	case b[0] == 0xfb:
+		r.Kind = ResponseInfile
+	default:
+		r.Kind = ResponseResultSet
+		r.Columns = columnCount(b)
+	}
+	return r
+}
+
+// parseResponse decodes the packets answering one command. Result sets are
+// column count, column definitions, an optional EOF, rows and a terminating
+// EOF or OK; with multiple result sets the sequence repeats.
+func parseResponse(group []Packet) *Response {
+	r := parseFirst(group[0])
+	r.End = group[len(group)-1].End
+	for _, p := range group {
+		r.Incomplete = r.Incomplete || p.Lost
+	}
+	if r.Kind != ResponseResultSet {
+		return &r
+	}
+
+	rest := group[1:]
+	cols := r.Columns
+	for len(rest) > 0 || cols > 0 {
+		// Column definitions, then the EOF that older servers send after them
+		if cols > len(rest) {
+			r.Incomplete = true
+			return &r
+		}
+		rest = rest[cols:]
+		if len(rest) > 0 && isEOF(rest[0]) {
+			rest = rest[1:]
+		}
+		for len(rest) > 0 {
+			p := rest[0]
+			rest = rest[1:]
+			if len(p.Body) > 0 && p.Body[0] == 0xff {
+				e := parseFirst(p)
+				r.Kind, r.ErrorCode, r.SQLState, r.Message = ResponseError, e.ErrorCode, e.SQLState, e.Message
+				return &r
+			}
+			if isEOF(p) {
+				break
+			}
+			r.Rows++
+		}
+		// Another result set follows if packets remain
+		cols = 0
+		if len(rest) > 0 {
+			cols = columnCount(rest[0].Body)
+			rest = rest[1:]
+		}
+	}
+	return &r
+}
+
+// isEOF reports whether p ends a run of rows: an EOF packet, or the OK
+// packet that replaces it when CLIENT_DEPRECATE_EOF is set
+func isEOF(p Packet) bool {
+	return len(p.Body) > 0 && p.Body[0] == 0xfe && p.Len < maxPacketPayload
+}
+
+// columnCount reads the column count that starts a result set. Garbage can
+// encode up to 2^64-1, so the count is capped to stay a valid int.
+func columnCount(b []byte) int {
+	n, _ := lenenc(b)
+	return int(min(n, maxPacketPayload))
+}
+
+// lenenc reads a length-encoded integer
+func lenenc(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	switch b[0] {
+	case 0xfc:
+		if len(b) >= 3 {
+			return uint64(binary.LittleEndian.Uint16(b[1:])), 3
+		}
+	case 0xfd:
+		if len(b) >= 4 {
+			return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16, 4
+		}
+	case 0xfe:
+		if len(b) >= 9 {
+			return binary.LittleEndian.Uint64(b[1:]), 9
+		}
+	default:
+		return uint64(b[0]), 1
+	}
+	return 0, 0
+}
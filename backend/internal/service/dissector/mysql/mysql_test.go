@@ -0,0 +1,164 @@
+package mysql
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flow lays segments out back to back, one chunk each, starting at start
+// and 2ms apart, so that client and server segments interleave
+func flow(start time.Duration, segs ...[]byte) *reassembly.Flow {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(start)
+	f := &reassembly.Flow{}
+	for i, s := range segs {
+		f.Chunks = append(f.Chunks, reassembly.Chunk{Offset: len(f.Data), Len: len(s), Timestamp: base.Add(time.Duration(i) * 2 * time.Millisecond), PacketIndex: i})
+		f.Data = append(f.Data, s...)
+	}
+	return f
+}
+
+func packet(seq byte, body ...byte) []byte {
+	n := len(body)
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq}, body...)
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// conversation is a login, a SELECT with one row, and a prepared statement
+// whose execution fails
+func conversation() (client, server [][]byte) {
+	greeting := append([]byte{10}, "8.0.36\x00"...)
+	greeting = append(greeting, 42, 0, 0, 0)
+	greeting = append(greeting, make([]byte, 24)...)
+
+	login := []byte{clientConnectDB, 0, 0, 0}
+	login = append(login, make([]byte, 28)...)
+	login = append(login, "root\x00"...)
+	login = append(login, 1, 0xaa)
+	login = append(login, "shop\x00"...)
+
+	client = [][]byte{
+		packet(1, login...),
+		packet(0, append([]byte{ComQuery}, "SELECT 1"...)...),
+		packet(0, append([]byte{ComStmtPrepare}, "INSERT INTO t VALUES (?)"...)...),
+		packet(0, ComStmtExecute, 7, 0, 0, 0, 0, 1, 0, 0, 0),
+		packet(0, ComQuit),
+	}
+	server = [][]byte{
+		join(packet(0, greeting...), packet(2, 0, 0, 0, 2, 0, 0, 0)),
+		join(packet(1, 1), packet(2, 3, 'd', 'e', 'f'), packet(3, 0xfe, 0, 0, 2, 0), packet(4, 1, '1'), packet(5, 0xfe, 0, 0, 2, 0)),
+		packet(1, 0, 7, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0),
+		packet(1, append([]byte{0xff, 0x26, 0x04}, "#23000Duplicate entry"...)...),
+	}
+	return client, server
+}
+
+func TestParse(t *testing.T) {
+	c, s := conversation()
+	if !IsGreeting(s[0]) {
+		t.Error("greeting not recognized")
+	}
+	conv := Parse(flow(0, c...), flow(time.Millisecond, s...))
+
+	hs := conv.Handshake
+	if hs == nil || hs.ServerVersion != "8.0.36" || hs.ConnectionID != 42 || hs.User != "root" || hs.Database != "shop" {
+		t.Fatalf("handshake = %+v", hs)
+	}
+	if hs.Result == nil || hs.Result.Kind != ResponseOK {
+		t.Errorf("login result = %+v", hs.Result)
+	}
+
+	if len(conv.Exchanges) != 4 {
+		t.Fatalf("got %d exchanges, want 4", len(conv.Exchanges))
+	}
+	want := []struct {
+		name, statement, kind string
+	}{
+		{"QUERY", "SELECT 1", ResponseResultSet},
+		{"PREPARE", "INSERT INTO t VALUES (?)", ResponseOK},
+		{"EXECUTE", "INSERT INTO t VALUES (?)", ResponseError},
+		{"QUIT", "", ""},
+	}
+	for i, w := range want {
+		ex := conv.Exchanges[i]
+		kind := ""
+		if ex.Response != nil {
+			kind = ex.Response.Kind
+		}
+		if ex.Command.Name != w.name || ex.Command.Statement != w.statement || kind != w.kind {
+			t.Errorf("exchange %d = %s %q answered %q, want %s %q answered %q", i, ex.Command.Name, ex.Command.Statement, kind, w.name, w.statement, w.kind)
+		}
+	}
+	if r := conv.Exchanges[0].Response; r.Columns != 1 || r.Rows != 1 || r.Incomplete {
+		t.Errorf("result set = %+v", r)
+	}
+	if r := conv.Exchanges[2].Response; r.ErrorCode != 1062 || r.SQLState != "23000" || r.Message != "Duplicate entry" {
+		t.Errorf("error = %+v", r)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	huge := []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	tests := []struct {
+		name   string
+		server []byte
+	}{
+		{"negative column count", join(packet(1, huge...), packet(2, 'c'))},
+		{"negative second result set", join(packet(1, 1), packet(2, 'c'), packet(3, 0xfe, 0, 0, 2, 0), packet(4, 0xfe, 0, 0, 2, 0), packet(5, huge...), packet(6, 'c'))},
+		{"missing column definitions", packet(1, 0xfc, 0xff, 0xff)},
+		{"empty response", packet(1)},
+	}
+	cmd := packet(0, append([]byte{ComQuery}, "SELECT 1"...)...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := Parse(flow(0, cmd), flow(time.Millisecond, tt.server))
+			if len(conv.Exchanges) != 1 || conv.Exchanges[0].Response == nil {
+				t.Fatalf("exchanges = %+v", conv.Exchanges)
+			}
+		})
+	}
+}
+
+// Every prefix of a conversation must parse without panicking, with
+// offsets inside the data
+func TestTruncated(t *testing.T) {
+	c, s := conversation()
+	client, server := join(c...), join(s...)
+	for i := 0; i <= len(server); i++ {
+		check(t, client[:min(i, len(client))], server[:i])
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	c, s := conversation()
+	f.Add(join(c...), join(s...))
+	f.Add(packet(0, ComQuery, 'S'), packet(1, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff))
+	f.Fuzz(func(t *testing.T, client, server []byte) {
+		check(t, client, server)
+	})
+}
+
+// check parses the flows whole and split in two segments
+func check(t *testing.T, client, server []byte) {
+	t.Helper()
+	flows := [][2]*reassembly.Flow{
+		{flow(0, client), flow(time.Millisecond, server)},
+		{flow(0, client[:len(client)/2], client[len(client)/2:]), flow(time.Millisecond, server[:len(server)/2], server[len(server)/2:])},
+	}
+	for _, fl := range flows {
+		conv := Parse(fl[0], fl[1])
+		for _, ex := range conv.Exchanges {
+			if c := ex.Command; c.Offset < 0 || c.End < c.Offset {
+				t.Fatalf("command at %d-%d", c.Offset, c.End)
+			}
+			if r := ex.Response; r != nil && (r.Offset < 0 || r.End < r.Offset) {
+				t.Fatalf("response at %d-%d", r.Offset, r.End)
+			}
+		}
+	}
+}
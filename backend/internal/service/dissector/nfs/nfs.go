@@ -0,0 +1,225 @@
+package nfs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+const maxHandle = 128
+
+// Call is one RPC call to the NFS program. Offsets index the client flow
+// data.
+type Call struct {
+	XID       uint32
+	Version   uint32
+	Minor     uint32 // NFSv4 minor version
+	Procedure uint32
+	Op        string   // procedure name; for an NFSv4 COMPOUND its main operation
+	Ops       []string // NFSv4 COMPOUND operations, as far as they could be decoded
+	Handle    string   // hex of the file handle operated on (v3 first handle, v4 PUTFH)
+	Name      string   // file name argument (LOOKUP, CREATE, REMOVE, v4 OPEN...)
+	Count     uint32   // READ/WRITE byte count
+	Private   bool     // RPCSEC_GSS privacy: the arguments are encrypted
+	Offset    int
+	End       int
+}
+
+// Reply is the server's answer to a call. Offsets index the server flow
+// data.
+type Reply struct {
+	XID    uint32
+	Error  string // RPC-level failure (denied or not accepted), empty if accepted
+	Status uint32 // NFS status of an accepted reply
+	Handle string // hex of the file handle a LOOKUP, CREATE or v4 GETFH returned
+	Offset int
+	End    int
+	Lost   bool // framing was lost after this reply
+}
+
+// Exchange pairs a call with its reply (nil if none was captured)
+type Exchange struct {
+	Call  *Call
+	Reply *Reply
+	File  string // name of the file operated on, if the capture shows it
+}
+
+// Conversation is a decoded NFS connection
+type Conversation struct {
+	Exchanges []Exchange
+}
+
+// Parse decodes an NFS connection from its client and server flows
+func Parse(client, server *reassembly.Flow) *Conversation {
+	conv := &Conversation{}
+	byXID := map[uint32]int{}
+	for _, rec := range records(client, IsCall) {
+		c := parseCall(rec.data)
+		if c == nil {
+			continue
+		}
+		c.Offset, c.End = rec.offset, rec.end
+		byXID[c.XID] = len(conv.Exchanges)
+		conv.Exchanges = append(conv.Exchanges, Exchange{Call: c})
+	}
+	for _, rec := range records(server, isReply) {
+		x := newXDR(rec.data)
+		xid := x.u32()
+		i, ok := byXID[xid]
+		if !ok || conv.Exchanges[i].Reply != nil {
+			continue
+		}
+		r := parseReply(x, conv.Exchanges[i].Call)
+		r.XID, r.Offset, r.End, r.Lost = xid, rec.offset, rec.end, rec.lost
+		conv.Exchanges[i].Reply = r
+	}
+
+	// File names come from LOOKUP, CREATE and OPEN; later operations name
+	// the file by the handle the server returned
+	files := map[string]string{}
+	for i := range conv.Exchanges {
+		ex := &conv.Exchanges[i]
+		c, r := ex.Call, ex.Reply
+		if c.Name != "" && r != nil && r.Handle != "" {
+			files[r.Handle] = c.Name
+		}
+		switch {
+		case c.Name != "":
+			ex.File = c.Name
+		case c.Handle != "":
+			if name, ok := files[c.Handle]; ok {
+				ex.File = name
+			} else {
+				ex.File = ShortHandle(c.Handle)
+			}
+		}
+	}
+	return conv
+}
+
+// ShortHandle labels a file handle whose name is unknown
+func ShortHandle(handle string) string {
+	h := fnv.New32a()
+	h.Write([]byte(handle))
+	return fmt.Sprintf("fh:%08x", h.Sum32())
+}
+
+func parseCall(b []byte) *Call {
+	x := newXDR(b)
+	c := &Call{XID: x.u32()}
+	x.skip(12) // message type, RPC version, program
+	c.Version = x.u32()
+	c.Procedure = x.u32()
+	flavor := x.u32()
+	cred := newXDR(x.opaque(400))
+	x.u32() // verifier flavor
+	x.opaque(400)
+	if !x.ok {
+		return nil
+	}
+	if flavor == authGSS {
+		cred.skip(8) // version, procedure
+		cred.u32()   // sequence number
+		switch cred.u32() {
+		case 2: // integrity: arguments follow their length and sequence number
+			x.skip(8)
+		case 3:
+			c.Private = true
+		}
+	}
+
+	switch c.Version {
+	case 3:
+		parseCall3(c, x)
+	case 4:
+		c.Op = "NULL"
+		if c.Procedure == 1 {
+			parseCompound(c, x)
+		}
+	default:
+		c.Op = fmt.Sprintf("PROC%d", c.Procedure)
+	}
+	return c
+}
+
+func handle(x *xdr) string {
+	return hex.EncodeToString(x.opaque(maxHandle))
+}
+
+var procs3 = []string{
+	"NULL", "GETATTR", "SETATTR", "LOOKUP", "ACCESS", "READLINK", "READ", "WRITE", "CREATE",
+	"MKDIR", "SYMLINK", "MKNOD", "REMOVE", "RMDIR", "RENAME", "LINK", "READDIR", "READDIRPLUS",
+	"FSSTAT", "FSINFO", "PATHCONF", "COMMIT",
+}
+
+func parseCall3(c *Call, x *xdr) {
+	if int(c.Procedure) >= len(procs3) {
+		c.Op = fmt.Sprintf("PROC%d", c.Procedure)
+		return
+	}
+	c.Op = procs3[c.Procedure]
+	if c.Private || c.Procedure == 0 {
+		return
+	}
+	switch c.Op {
+	case "LOOKUP", "CREATE", "MKDIR", "SYMLINK", "MKNOD", "REMOVE", "RMDIR", "RENAME":
+		x.opaque(maxHandle) // directory
+		c.Name = x.str()
+	case "LINK":
+		c.Handle = handle(x)
+		x.opaque(maxHandle)
+		c.Name = x.str()
+	case "READ", "WRITE":
+		c.Handle = handle(x)
+		x.u64() // offset
+		c.Count = x.u32()
+	default:
+		c.Handle = handle(x)
+	}
+}
+
+// parseReply reads an accepted reply's NFS status and, for calls that
+// name a file, the handle returned for it
+func parseReply(x *xdr, c *Call) *Reply {
+	r := &Reply{}
+	x.u32() // message type
+	if x.u32() == 1 {
+		if x.u32() == 0 { // RPC_MISMATCH
+			r.Error = "RPC_MISMATCH"
+		} else {
+			r.Error = rpcError(true, x.u32())
+		}
+		return r
+	}
+	x.u32() // verifier flavor
+	x.opaque(400)
+	if stat := x.u32(); stat != 0 {
+		r.Error = rpcError(false, stat)
+		return r
+	}
+	if c.Private || !x.ok {
+		return r
+	}
+	if c.Version == 4 {
+		parseCompoundReply(r, x, c)
+		return r
+	}
+	r.Status = x.u32()
+	if r.Status != 0 || !x.ok {
+		return r
+	}
+	switch c.Op {
+	case "LOOKUP":
+		r.Handle = handle(x)
+	case "CREATE", "MKDIR", "SYMLINK", "MKNOD":
+		if x.u32() == 1 {
+			r.Handle = handle(x)
+		}
+	}
+	if !x.ok {
+		r.Handle = ""
+	}
+	return r
+}
@@ -0,0 +1,184 @@
+package nfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flow lays segments out back to back, one chunk each
+func flow(segs ...[]byte) *reassembly.Flow {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &reassembly.Flow{}
+	for i, s := range segs {
+		f.Chunks = append(f.Chunks, reassembly.Chunk{Offset: len(f.Data), Len: len(s), Timestamp: base.Add(time.Duration(i) * time.Millisecond), PacketIndex: i})
+		f.Data = append(f.Data, s...)
+	}
+	return f
+}
+
+// enc builds XDR: uint32s, []byte as opaque and strings as string
+func enc(fields ...any) []byte {
+	var b []byte
+	for _, f := range fields {
+		switch v := f.(type) {
+		case int:
+			b = binary.BigEndian.AppendUint32(b, uint32(v))
+		case []byte:
+			b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+			b = append(b, make([]byte, (4-len(v)%4)%4)...)
+		case string:
+			b = append(b, enc([]byte(v))...)
+		}
+	}
+	return b
+}
+
+// marked marks body as a single-fragment RPC record
+func marked(body []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body))|lastFragment), body...)
+}
+
+func call(xid, version, proc int, args []byte) []byte {
+	hdr := enc(xid, msgCall, 2, programNFS, version, proc, 1, []byte("unix cred"), 0, []byte{})
+	return marked(append(hdr, args...))
+}
+
+func reply(xid int, body []byte) []byte {
+	return marked(append(enc(xid, msgReply, 0, 0, []byte{}, 0), body...))
+}
+
+var (
+	dirFH  = []byte("root-handle")
+	fileFH = []byte("file-handle-a")
+	v4FH   = []byte("file-handle-b")
+)
+
+// conversation looks up and reads a file over NFSv3, then does the same
+// over NFSv4 with COMPOUNDs
+func conversation() (client, server [][]byte) {
+	client = [][]byte{
+		call(1, 3, 3, enc(dirFH, "a.txt")),
+		call(2, 3, 6, enc(fileFH, 0, 0, 4096)),
+		call(3, 4, 1, enc("", 1, 3, op4Putfh, dirFH, op4Lookup, "b.txt", op4Getfh)),
+		call(4, 4, 1, enc("", 1, 2, op4Putfh, v4FH, op4Read, 0, 0, 0, 0, 0, 0, 100)),
+	}
+	server = [][]byte{
+		reply(1, enc(0, fileFH)),
+		reply(2, enc(0)),
+		reply(3, enc(0, "", 3, op4Putfh, 0, op4Lookup, 0, op4Getfh, 0, v4FH)),
+		reply(4, enc(0, "", 2, op4Putfh, 0, op4Read, 0)),
+	}
+	return client, server
+}
+
+func TestParse(t *testing.T) {
+	c, s := conversation()
+	if !IsCall(c[0]) || !isReply(s[0]) {
+		t.Fatal("records not recognized")
+	}
+	conv := Parse(flow(c...), flow(s...))
+	want := []struct {
+		version uint32
+		op      string
+		file    string
+		count   uint32
+	}{
+		{3, "LOOKUP", "a.txt", 0},
+		{3, "READ", "a.txt", 4096},
+		{4, "LOOKUP", "b.txt", 0},
+		{4, "READ", "b.txt", 100},
+	}
+	if len(conv.Exchanges) != len(want) {
+		t.Fatalf("got %d exchanges, want %d", len(conv.Exchanges), len(want))
+	}
+	for i, w := range want {
+		ex := conv.Exchanges[i]
+		if ex.Call.Version != w.version || ex.Call.Op != w.op || ex.File != w.file || ex.Call.Count != w.count {
+			t.Errorf("exchange %d = v%d %s %q count %d, want v%d %s %q count %d", i,
+				ex.Call.Version, ex.Call.Op, ex.File, ex.Call.Count, w.version, w.op, w.file, w.count)
+		}
+		if ex.Reply == nil || ex.Reply.Error != "" || ex.Reply.Status != 0 {
+			t.Errorf("reply %d = %+v", i, ex.Reply)
+		}
+	}
+	if ops := conv.Exchanges[2].Call.Ops; len(ops) != 3 || ops[2] != "GETFH" {
+		t.Errorf("ops = %v", ops)
+	}
+}
+
+func TestParseRecords(t *testing.T) {
+	lookup := call(1, 3, 3, enc(dirFH, "a.txt"))[4:]
+	// The same call split into two fragments
+	fragmented := binary.BigEndian.AppendUint32(nil, 56)
+	fragmented = append(fragmented, lookup[:56]...)
+	fragmented = binary.BigEndian.AppendUint32(fragmented, uint32(len(lookup)-56)|lastFragment)
+	fragmented = append(fragmented, lookup[56:]...)
+
+	tests := []struct {
+		name   string
+		client []byte
+		server []byte
+		op     string
+		err    string
+	}{
+		{"fragmented call", fragmented, reply(1, enc(0, fileFH)), "LOOKUP", ""},
+		{"denied", call(1, 3, 0, nil), marked(enc(1, msgReply, 1, 1, 1)), "NULL", "AUTH_BADCRED"},
+		{"rpc mismatch", call(1, 3, 0, nil), marked(enc(1, msgReply, 1, 0, 2, 2)), "NULL", "RPC_MISMATCH"},
+		{"not accepted", call(1, 3, 0, nil), marked(enc(1, msgReply, 0, 0, []byte{}, 3)), "NULL", "PROC_UNAVAIL"},
+		{"unknown procedure", call(1, 3, 99, nil), reply(1, enc(0)), "PROC99", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := Parse(flow(tt.client), flow(tt.server))
+			if len(conv.Exchanges) != 1 || conv.Exchanges[0].Reply == nil {
+				t.Fatalf("exchanges = %+v", conv.Exchanges)
+			}
+			if ex := conv.Exchanges[0]; ex.Call.Op != tt.op || ex.Reply.Error != tt.err {
+				t.Errorf("call %s answered %q, want %s answered %q", ex.Call.Op, ex.Reply.Error, tt.op, tt.err)
+			}
+		})
+	}
+}
+
+// Every prefix of a conversation must parse without panicking
+func TestTruncated(t *testing.T) {
+	c, s := conversation()
+	client, server := bytes.Join(c, nil), bytes.Join(s, nil)
+	for i := 0; i <= len(client); i++ {
+		check(t, client[:i], server[:min(i, len(server))])
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	c, s := conversation()
+	f.Add(bytes.Join(c, nil), bytes.Join(s, nil))
+	f.Add(c[2], s[2])
+	f.Add(call(1, 4, 1, enc("", 1, 1, op4Open, 0, 0, 0, 0, 0, []byte("owner"), 1, 0, 1, 0, []byte{}, 0, "new.txt")), s[0])
+	f.Fuzz(func(t *testing.T, client, server []byte) {
+		check(t, client, server)
+	})
+}
+
+// check parses the flows whole and split in two segments
+func check(t *testing.T, client, server []byte) {
+	t.Helper()
+	flows := [][2]*reassembly.Flow{
+		{flow(client), flow(server)},
+		{flow(client[:len(client)/2], client[len(client)/2:]), flow(server[:len(server)/2], server[len(server)/2:])},
+	}
+	for _, fl := range flows {
+		for _, ex := range Parse(fl[0], fl[1]).Exchanges {
+			if c := ex.Call; c.Offset < 0 || c.End < c.Offset {
+				t.Fatalf("call at %d-%d", c.Offset, c.End)
+			}
+			if r := ex.Reply; r != nil && (r.Offset < 0 || r.End < r.Offset) {
+				t.Fatalf("reply at %d-%d", r.Offset, r.End)
+			}
+		}
+	}
+}
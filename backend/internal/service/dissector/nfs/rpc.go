@@ -0,0 +1,206 @@
+// Package nfs decodes NFSv3 and NFSv4 over ONC RPC from reassembled TCP
+// flows. Calls and replies are framed by RPC record marking and paired by
+// transaction ID (XID).
+package nfs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+const (
+	programNFS = 100003
+
+	msgCall  = 0
+	msgReply = 1
+
+	authGSS = 6 // RPCSEC_GSS
+
+	lastFragment   = 0x80000000
+	maxRecordLen   = 16 << 20
+	minCallHeader  = 40 // xid through an empty verifier
+	minReplyHeader = 12
+)
+
+// RPC accept_stat values
+var acceptStatNames = map[uint32]string{
+	1: "PROG_UNAVAIL",
+	2: "PROG_MISMATCH",
+	3: "PROC_UNAVAIL",
+	4: "GARBAGE_ARGS",
+	5: "SYSTEM_ERR",
+}
+
+// RPC auth_stat values of a denied reply
+var authStatNames = map[uint32]string{
+	1:  "AUTH_BADCRED",
+	2:  "AUTH_REJECTEDCRED",
+	3:  "AUTH_BADVERF",
+	4:  "AUTH_REJECTEDVERF",
+	5:  "AUTH_TOOWEAK",
+	6:  "AUTH_INVALIDRESP",
+	7:  "AUTH_FAILED",
+	13: "RPCSEC_GSS_CREDPROBLEM",
+	14: "RPCSEC_GSS_CTXPROBLEM",
+}
+
+// record is one RPC message: the captured bytes of its first fragment and
+// its extent in the flow
+type record struct {
+	data   []byte
+	offset int
+	end    int
+	lost   bool // framing was lost after this record
+}
+
+// IsCall reports whether data begins with a record-marked RPC call to the
+// NFS program
+func IsCall(data []byte) bool {
+	if len(data) < 4+minCallHeader {
+		return false
+	}
+	mark := binary.BigEndian.Uint32(data)
+	if n := mark &^ lastFragment; n < minCallHeader || n > maxRecordLen {
+		return false
+	}
+	b := data[4:]
+	return be(b[4:]) == msgCall && be(b[8:]) == 2 && be(b[12:]) == programNFS
+}
+
+// isReply reports whether data begins with a record-marked RPC reply
+func isReply(data []byte) bool {
+	if len(data) < 4+minReplyHeader {
+		return false
+	}
+	mark := binary.BigEndian.Uint32(data)
+	n := mark &^ lastFragment
+	return n >= minReplyHeader && n <= maxRecordLen && be(data[8:]) == msgReply && be(data[12:]) <= 1
+}
+
+// records frames the RPC records of a flow. Fragments after the first are
+// skipped; the fields decoded here all sit at the start of a record. After
+// a gap swallows a record mark, framing resumes at the next TCP segment
+// that starts a plausible record.
+func records(flow *reassembly.Flow, start func([]byte) bool) []record {
+	var recs []record
+	pos := 0
+	for pos < len(flow.Data) {
+		hdr := flow.Contiguous(pos)
+		if !start(hdr) {
+			pos = resync(flow, pos+1, start)
+			continue
+		}
+		rec := record{offset: pos}
+		ok := true
+		for {
+			mark := binary.BigEndian.Uint32(flow.Contiguous(pos))
+			n := int(mark &^ lastFragment)
+			if rec.data == nil {
+				frag := flow.Contiguous(pos)[4:]
+				rec.data = frag[:min(n, len(frag))]
+			}
+			pos, ok = flow.Skip(pos, int64(4+n))
+			if !ok || mark&lastFragment != 0 || len(flow.Contiguous(pos)) < 4 {
+				break
+			}
+		}
+		rec.end = pos
+		rec.lost = !ok
+		recs = append(recs, rec)
+		if !ok {
+			pos = resync(flow, pos, start)
+		}
+	}
+	return recs
+}
+
+func resync(flow *reassembly.Flow, from int, start func([]byte) bool) int {
+	for p := flow.NextPacketStart(from); p < len(flow.Data); p = flow.NextPacketStart(p + 1) {
+		if start(flow.Contiguous(p)) {
+			return p
+		}
+	}
+	return len(flow.Data)
+}
+
+func be(b []byte) uint32 {
+	return binary.BigEndian.Uint32(b)
+}
+
+// xdr is a bounds-checked XDR cursor; after an overrun ok is false and
+// every read returns zero values
+type xdr struct {
+	b  []byte
+	ok bool
+}
+
+func newXDR(b []byte) *xdr {
+	return &xdr{b: b, ok: true}
+}
+
+func (x *xdr) u32() uint32 {
+	if !x.ok || len(x.b) < 4 {
+		x.ok = false
+		return 0
+	}
+	v := be(x.b)
+	x.b = x.b[4:]
+	return v
+}
+
+func (x *xdr) u64() uint64 {
+	hi := x.u32()
+	return uint64(hi)<<32 | uint64(x.u32())
+}
+
+func (x *xdr) skip(n int) {
+	if !x.ok || n < 0 || len(x.b) < n {
+		x.ok = false
+		return
+	}
+	x.b = x.b[n:]
+}
+
+// opaque reads variable-length opaque data, refusing lengths above max
+func (x *xdr) opaque(max int) []byte {
+	n := int(x.u32())
+	if !x.ok || n > max || len(x.b) < n {
+		x.ok = false
+		return nil
+	}
+	v := x.b[:n]
+	x.b = x.b[n:]
+	x.skip((4 - n%4) % 4)
+	return v
+}
+
+func (x *xdr) str() string {
+	return string(x.opaque(1024))
+}
+
+// bitmap skips a bitmap4
+func (x *xdr) bitmap() {
+	n := int(x.u32())
+	if n > 8 {
+		x.ok = false
+		return
+	}
+	x.skip(4 * n)
+}
+
+// rpcError describes a reply that was denied or not accepted
+func rpcError(rejected bool, stat uint32) string {
+	names := acceptStatNames
+	if rejected {
+		names = authStatNames
+	}
+	if name, ok := names[stat]; ok {
+		return name
+	}
+	if rejected {
+		return fmt.Sprintf("RPC_DENIED(%d)", stat)
+	}
+	return fmt.Sprintf("RPC_ERROR(%d)", stat)
+}
@@ -0,0 +1,118 @@
+package nfs
+
+import "fmt"
+
+// NFS status codes the analyzer treats specially (nfsstat3 and nfsstat4
+// share their numbering)
+const (
+	StatusOK    = 0
+	StatusNoEnt = 2
+	StatusDelay = 10008 // NFS3ERR_JUKEBOX, NFS4ERR_DELAY
+)
+
+var statusNames = map[uint32]string{
+	1:     "PERM",
+	2:     "NOENT",
+	5:     "IO",
+	6:     "NXIO",
+	13:    "ACCES",
+	17:    "EXIST",
+	18:    "XDEV",
+	19:    "NODEV",
+	20:    "NOTDIR",
+	21:    "ISDIR",
+	22:    "INVAL",
+	27:    "FBIG",
+	28:    "NOSPC",
+	30:    "ROFS",
+	31:    "MLINK",
+	63:    "NAMETOOLONG",
+	66:    "NOTEMPTY",
+	69:    "DQUOT",
+	70:    "STALE",
+	71:    "REMOTE",
+	10001: "BADHANDLE",
+	10002: "NOT_SYNC",
+	10003: "BAD_COOKIE",
+	10004: "NOTSUPP",
+	10005: "TOOSMALL",
+	10006: "SERVERFAULT",
+	10007: "BADTYPE",
+	10009: "SAME",
+	10010: "DENIED",
+	10011: "EXPIRED",
+	10012: "LOCKED",
+	10013: "GRACE",
+	10014: "FHEXPIRED",
+	10015: "SHARE_DENIED",
+	10016: "WRONGSEC",
+	10017: "CLID_INUSE",
+	10018: "RESOURCE",
+	10019: "MOVED",
+	10020: "NOFILEHANDLE",
+	10021: "MINOR_VERS_MISMATCH",
+	10022: "STALE_CLIENTID",
+	10023: "STALE_STATEID",
+	10024: "OLD_STATEID",
+	10025: "BAD_STATEID",
+	10026: "BAD_SEQID",
+	10027: "NOT_SAME",
+	10028: "LOCK_RANGE",
+	10029: "SYMLINK",
+	10030: "RESTOREFH",
+	10031: "LEASE_MOVED",
+	10032: "ATTRNOTSUPP",
+	10033: "NO_GRACE",
+	10034: "RECLAIM_BAD",
+	10035: "RECLAIM_CONFLICT",
+	10036: "BADXDR",
+	10037: "LOCKS_HELD",
+	10038: "OPENMODE",
+	10039: "BADOWNER",
+	10040: "BADCHAR",
+	10041: "BADNAME",
+	10042: "BAD_RANGE",
+	10043: "LOCK_NOTSUPP",
+	10044: "OP_ILLEGAL",
+	10045: "DEADLOCK",
+	10046: "FILE_OPEN",
+	10047: "ADMIN_REVOKED",
+	10048: "CB_PATH_DOWN",
+	10052: "BADSESSION",
+	10053: "BADSLOT",
+	10054: "COMPLETE_ALREADY",
+	10055: "CONN_NOT_BOUND_TO_SESSION",
+	10057: "SEQ_MISORDERED",
+	10071: "RETRY_UNCACHED_REP",
+}
+
+// StatusName returns the name of an NFS status for the protocol version,
+// e.g. NFS3ERR_NOENT or NFS4ERR_DELAY
+func StatusName(version, status uint32) string {
+	if status == StatusOK {
+		return fmt.Sprintf("NFS%d_OK", version)
+	}
+	name, ok := statusNames[status]
+	switch {
+	case status == StatusDelay && version == 3:
+		name = "JUKEBOX"
+	case status == StatusDelay:
+		name = "DELAY"
+	case !ok:
+		name = fmt.Sprint(status)
+	}
+	return fmt.Sprintf("NFS%dERR_%s", version, name)
+}
+
+// IsError reports whether a status means the operation failed. A lookup
+// or open of a name that does not exist is how clients test for files and
+// is not counted.
+func IsError(op string, status uint32) bool {
+	if status == StatusOK {
+		return false
+	}
+	if status == StatusNoEnt && (op == "LOOKUP" || op == "OPEN") {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package nfs
+
+import "fmt"
+
+// NFSv4 operations
+const (
+	op4Access    = 3
+	op4Close     = 4
+	op4Commit    = 5
+	op4Delegret  = 8
+	op4Getattr   = 9
+	op4Getfh     = 10
+	op4Link      = 11
+	op4Locku     = 14
+	op4Lookup    = 15
+	op4Lookupp   = 16
+	op4Open      = 18
+	op4Putfh     = 22
+	op4Putpubfh  = 23
+	op4Putrootfh = 24
+	op4Read      = 25
+	op4Readdir   = 26
+	op4Readlink  = 27
+	op4Remove    = 28
+	op4Rename    = 29
+	op4Renew     = 30
+	op4Restorefh = 31
+	op4Savefh    = 32
+	op4Secinfo   = 33
+	op4Setattr   = 34
+	op4Write     = 38
+	op4Sequence  = 53
+	op4Reclaim   = 58
+)
+
+var ops4 = map[uint32]string{
+	3: "ACCESS", 4: "CLOSE", 5: "COMMIT", 6: "CREATE", 7: "DELEGPURGE", 8: "DELEGRETURN",
+	9: "GETATTR", 10: "GETFH", 11: "LINK", 12: "LOCK", 13: "LOCKT", 14: "LOCKU", 15: "LOOKUP",
+	16: "LOOKUPP", 17: "NVERIFY", 18: "OPEN", 19: "OPENATTR", 20: "OPEN_CONFIRM",
+	21: "OPEN_DOWNGRADE", 22: "PUTFH", 23: "PUTPUBFH", 24: "PUTROOTFH", 25: "READ",
+	26: "READDIR", 27: "READLINK", 28: "REMOVE", 29: "RENAME", 30: "RENEW", 31: "RESTOREFH",
+	32: "SAVEFH", 33: "SECINFO", 34: "SETATTR", 35: "SETCLIENTID", 36: "SETCLIENTID_CONFIRM",
+	37: "VERIFY", 38: "WRITE", 39: "RELEASE_LOCKOWNER", 40: "BACKCHANNEL_CTL",
+	41: "BIND_CONN_TO_SESSION", 42: "EXCHANGE_ID", 43: "CREATE_SESSION", 44: "DESTROY_SESSION",
+	45: "FREE_STATEID", 46: "GET_DIR_DELEGATION", 47: "GETDEVICEINFO", 48: "GETDEVICELIST",
+	49: "LAYOUTCOMMIT", 50: "LAYOUTGET", 51: "LAYOUTRETURN", 52: "SECINFO_NO_NAME",
+	53: "SEQUENCE", 54: "SET_SSV", 55: "TEST_STATEID", 56: "WANT_DELEGATION",
+	57: "DESTROY_CLIENTID", 58: "RECLAIM_COMPLETE", 10044: "ILLEGAL",
+}
+
+func opName4(op uint32) string {
+	if name, ok := ops4[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP%d", op)
+}
+
+// housekeeping4 operations set up or inspect state around the operation a
+// COMPOUND is for
+var housekeeping4 = map[uint32]bool{
+	op4Sequence: true, op4Putfh: true, op4Putpubfh: true, op4Putrootfh: true,
+	op4Getattr: true, op4Getfh: true, op4Savefh: true, op4Restorefh: true,
+}
+
+const stateidLen = 16
+
+// parseCompound decodes the operations of a COMPOUND call until one whose
+// arguments it cannot size. The call's Op is the first operation that is
+// not housekeeping.
+func parseCompound(c *Call, x *xdr) {
+	c.Op = "COMPOUND"
+	if c.Private {
+		return
+	}
+	x.str() // tag
+	c.Minor = x.u32()
+	n := x.u32()
+	var main uint32
+	found := false
+	for i := uint32(0); i < n && x.ok && i < 64; i++ {
+		op := x.u32()
+		if !x.ok {
+			break
+		}
+		c.Ops = append(c.Ops, opName4(op))
+		if !found && !housekeeping4[op] {
+			main, found = op, true
+		}
+		if !compoundArgs(c, x, op) {
+			break
+		}
+	}
+	switch {
+	case found:
+		c.Op = opName4(main)
+	case len(c.Ops) > 0:
+		c.Op = c.Ops[len(c.Ops)-1]
+	}
+}
+
+// compoundArgs consumes one operation's arguments, reporting whether the
+// next operation can be found
+func compoundArgs(c *Call, x *xdr, op uint32) bool {
+	switch op {
+	case op4Getfh, op4Lookupp, op4Putpubfh, op4Putrootfh, op4Readlink, op4Restorefh, op4Savefh:
+	case op4Sequence:
+		x.skip(stateidLen + 16) // session ID, sequence, slot, highest slot, cache flag
+	case op4Putfh:
+		c.Handle = handle(x)
+	case op4Access, op4Reclaim:
+		x.u32()
+	case op4Close:
+		x.skip(4 + stateidLen)
+	case op4Commit:
+		x.skip(12)
+	case op4Delegret:
+		x.skip(stateidLen)
+	case op4Getattr:
+		x.bitmap()
+	case op4Lookup, op4Link, op4Remove, op4Secinfo:
+		c.Name = x.str()
+	case op4Rename:
+		c.Name = x.str()
+		x.str()
+	case op4Locku:
+		x.skip(8 + stateidLen + 16)
+	case op4Renew:
+		x.u64()
+	case op4Read:
+		x.skip(stateidLen + 8)
+		c.Count = x.u32()
+	case op4Readdir:
+		x.skip(8 + 8 + 8)
+		x.bitmap()
+	case op4Setattr:
+		x.skip(stateidLen)
+		x.bitmap()
+		x.opaque(1 << 16)
+	case op4Write:
+		x.skip(stateidLen + 8 + 4)
+		c.Count = x.u32()
+		return false // the data follows
+	case op4Open:
+		return openArgs(c, x)
+	default:
+		return false
+	}
+	return x.ok
+}
+
+// openArgs reads an OPEN's arguments for the file name of a CLAIM_NULL open
+func openArgs(c *Call, x *xdr) bool {
+	x.skip(12) // seqid, share access, share deny
+	x.u64()    // client ID
+	x.opaque(1024)
+	if x.u32() == 1 { // OPEN4_CREATE
+		switch x.u32() {
+		case 0, 1: // UNCHECKED4, GUARDED4
+			x.bitmap()
+			x.opaque(1 << 16)
+		case 2: // EXCLUSIVE4
+			x.skip(8)
+		case 3: // EXCLUSIVE4_1
+			x.skip(8)
+			x.bitmap()
+			x.opaque(1 << 16)
+		default:
+			return false
+		}
+	}
+	switch x.u32() {
+	case 0: // CLAIM_NULL
+		c.Name = x.str()
+	case 1: // CLAIM_PREVIOUS
+		x.u32()
+	case 2: // CLAIM_DELEGATE_CUR
+		x.skip(stateidLen)
+		c.Name = x.str()
+	case 3: // CLAIM_DELEGATE_PREV
+		c.Name = x.str()
+	case 4, 6: // CLAIM_FH, CLAIM_DELEG_PREV_FH
+	case 5: // CLAIM_DELEG_CUR_FH
+		x.skip(stateidLen)
+	default:
+		return false
+	}
+	return x.ok
+}
+
+// parseCompoundReply reads a COMPOUND reply's status and the file handle a
+// GETFH returned after the call's LOOKUP or OPEN
+func parseCompoundReply(r *Reply, x *xdr, c *Call) {
+	r.Status = x.u32()
+	x.str() // tag
+	n := x.u32()
+	for i := uint32(0); i < n && x.ok && i < 64; i++ {
+		op := x.u32()
+		if x.u32() != 0 || !x.ok {
+			return
+		}
+		switch op {
+		case op4Putfh, op4Putpubfh, op4Putrootfh, op4Savefh, op4Restorefh, op4Lookup, op4Lookupp,
+			op4Remove, op4Renew, op4Reclaim:
+		case op4Sequence:
+			x.skip(stateidLen + 20)
+		case op4Getfh:
+			if h := handle(x); x.ok && c.Name != "" {
+				r.Handle = h
+			}
+		case op4Getattr:
+			x.bitmap()
+			x.opaque(1 << 16)
+		case op4Access:
+			x.skip(8)
+		case op4Close:
+			x.skip(stateidLen)
+		case op4Open:
+			x.skip(stateidLen + 20 + 4) // stateid, change info, flags
+			x.bitmap()
+			if x.u32() != 0 { // a delegation, whose size varies
+				return
+			}
+		default:
+			return
+		}
+	}
+}
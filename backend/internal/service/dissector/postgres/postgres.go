@@ -0,0 +1,378 @@
+// Package postgres decodes the PostgreSQL frontend/backend protocol (v3)
+// from reassembled TCP flows: startup, simple and extended queries and the
+// server's answers up to each ReadyForQuery.
+package postgres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// Untyped startup-phase request codes
+const (
+	protocolV3  = 196608
+	sslRequest  = 80877103
+	cancelCode  = 80877102
+	gssRequest  = 80877104
+	maxStartLen = 10000
+)
+
+// Message is one protocol message. Offsets index the flow data; Body holds
+// the captured part of the payload after the length.
+type Message struct {
+	Type   byte
+	Offset int
+	End    int
+	Len    int // payload length, excluding type and length
+	Body   []byte
+	Lost   bool // framing was lost after this message
+}
+
+// Startup describes the connection's startup phase
+type Startup struct {
+	User        string
+	Database    string
+	Application string
+	TLS         bool // the server accepted an SSLRequest
+	Cancel      bool // a CancelRequest connection
+}
+
+// Query is one unit of client work that the server answers with a single
+// ReadyForQuery: a simple query, or extended-protocol messages up to a Sync.
+// Offsets index the client flow data.
+type Query struct {
+	Method    string // "QUERY", "EXECUTE", "PREPARE", "DESCRIBE", "CLOSE", "SYNC", "FUNCTION_CALL" or "STARTUP"
+	Statement string
+	Offset    int
+	End       int
+	Truncated bool // the statement was longer than the captured payload
+}
+
+// Error is the content of an ErrorResponse
+type Error struct {
+	Severity string
+	Code     string // SQLSTATE
+	Message  string
+}
+
+// Result is the server's answer to a Query. Offsets index the server flow data.
+type Result struct {
+	Offset     int
+	End        int
+	Rows       int
+	Tags       []string // CommandComplete tags, e.g. "SELECT 5"
+	Error      *Error
+	TxStatus   byte // ReadyForQuery status: 'I' idle, 'T' in transaction, 'E' failed transaction
+	Ready      bool // ended with ReadyForQuery
+	Incomplete bool // a gap cut the answer short
+}
+
+// Exchange pairs a query with its result (nil if none was captured)
+type Exchange struct {
+	Query  Query
+	Result *Result
+}
+
+// Conversation is a decoded PostgreSQL connection
+type Conversation struct {
+	Startup   *Startup // nil if the capture started after the startup phase
+	Exchanges []Exchange
+}
+
+const (
+	clientTypes = "QPBEDSCHXFdcfp"
+	serverTypes = "RSKZTDCENI123nstAGHWVcdv"
+)
+
+// IsStartup reports whether data begins with a StartupMessage, SSLRequest,
+// GSSENCRequest or CancelRequest
+func IsStartup(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	n := binary.BigEndian.Uint32(data)
+	code := binary.BigEndian.Uint32(data[4:])
+	switch code {
+	case sslRequest, gssRequest:
+		return n == 8
+	case cancelCode:
+		return n == 16
+	case protocolV3:
+		return n > 8 && n < maxStartLen
+	}
+	return false
+}
+
+// LooksLikeQuery reports whether data begins with a Query or Parse message,
+// for connections captured after the startup phase
+func LooksLikeQuery(data []byte) bool {
+	if len(data) < 6 || (data[0] != 'Q' && data[0] != 'P') {
+		return false
+	}
+	n := binary.BigEndian.Uint32(data[1:])
+	return n > 4 && n < 1<<30
+}
+
+// Parse decodes a PostgreSQL connection from its client and server flows
+func Parse(client, server *reassembly.Flow) *Conversation {
+	conv := &Conversation{}
+	cpos, spos := 0, 0
+	var queries []Query
+
+	// Startup phase: optional SSL/GSS negotiation answered by a single byte,
+	// then the StartupMessage
+	for IsStartup(client.Contiguous(cpos)) {
+		data := client.Contiguous(cpos)
+		n := int(binary.BigEndian.Uint32(data))
+		code := binary.BigEndian.Uint32(data[4:])
+		if conv.Startup == nil {
+			conv.Startup = &Startup{}
+		}
+		end, _ := client.Skip(cpos, int64(n))
+		switch code {
+		case sslRequest, gssRequest:
+			reply := server.Contiguous(spos)
+			if len(reply) == 0 || reply[0] != 'N' {
+				conv.Startup.TLS = len(reply) > 0
+				return conv
+			}
+			spos++
+			cpos = end
+			continue
+		case cancelCode:
+			conv.Startup.Cancel = true
+			return conv
+		}
+		parseStartupParams(conv.Startup, data[8:min(n, len(data))])
+		queries = append(queries, Query{Method: "STARTUP", Offset: cpos, End: end})
+		cpos = end
+		break
+	}
+
+	queries = append(queries, parseQueries(messages(client, cpos, true))...)
+	results := parseResults(messages(server, spos, false))
+
+	// Answers come back in request order, also when the client pipelines;
+	// results sent before a query was (the capture began mid-exchange)
+	// cannot answer it
+	ri := 0
+	for _, q := range queries {
+		ex := Exchange{Query: q}
+		sent := client.TimeAt(q.Offset)
+		for ri < len(results) && server.TimeAt(results[ri].Offset).Before(sent) {
+			ri++
+		}
+		if ri < len(results) {
+			ex.Result = results[ri]
+			ri++
+		}
+		conv.Exchanges = append(conv.Exchanges, ex)
+	}
+	return conv
+}
+
+func parseStartupParams(s *Startup, b []byte) {
+	parts := bytes.Split(b, []byte{0})
+	for i := 0; i+1 < len(parts); i += 2 {
+		switch string(parts[i]) {
+		case "user":
+			s.User = string(parts[i+1])
+		case "database":
+			s.Database = string(parts[i+1])
+		case "application_name":
+			s.Application = string(parts[i+1])
+		}
+	}
+}
+
+// messages frames typed messages from pos on. After a gap swallows a
+// header, framing resumes at the next TCP segment that starts with a
+// plausible message.
+func messages(flow *reassembly.Flow, pos int, client bool) []Message {
+	types := serverTypes
+	if client {
+		types = clientTypes
+	}
+	var msgs []Message
+	for pos < len(flow.Data) {
+		hdr := flow.Contiguous(pos)
+		if !plausible(hdr, types) {
+			pos = resync(flow, pos+1, types)
+			continue
+		}
+		n := int(binary.BigEndian.Uint32(hdr[1:]))
+		end, ok := flow.Skip(pos, int64(1+n))
+		body := hdr[5:]
+		if len(body) > n-4 {
+			body = body[:n-4]
+		}
+		msgs = append(msgs, Message{Type: hdr[0], Offset: pos, End: end, Len: n - 4, Body: body, Lost: !ok})
+		if !ok {
+			pos = resync(flow, end, types)
+			continue
+		}
+		pos = end
+	}
+	return msgs
+}
+
+func plausible(hdr []byte, types string) bool {
+	if len(hdr) < 5 || strings.IndexByte(types, hdr[0]) < 0 {
+		return false
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	return n >= 4 && n < 1<<30
+}
+
+func resync(flow *reassembly.Flow, from int, types string) int {
+	for p := flow.NextPacketStart(from); p < len(flow.Data); p = flow.NextPacketStart(p + 1) {
+		if plausible(flow.Contiguous(p), types) {
+			return p
+		}
+	}
+	return len(flow.Data)
+}
+
+// parseQueries groups client messages into units answered by one
+// ReadyForQuery: each Query and FunctionCall, and extended-protocol
+// messages up to each Sync
+func parseQueries(msgs []Message) []Query {
+	var queries []Query
+	prepared := map[string]string{}
+	var unit *Query
+	var hasParse, hasExecute, hasDescribe, hasClose bool
+
+	for _, m := range msgs {
+		switch m.Type {
+		case 'Q':
+			queries = append(queries, Query{
+				Method:    "QUERY",
+				Statement: strings.TrimSpace(cstring(m.Body)),
+				Offset:    m.Offset,
+				End:       m.End,
+				Truncated: len(m.Body) < m.Len,
+			})
+			continue
+		case 'F':
+			queries = append(queries, Query{Method: "FUNCTION_CALL", Offset: m.Offset, End: m.End})
+			continue
+		case 'X', 'd', 'c', 'f', 'p':
+			// Terminate, COPY data and authentication are not queries
+			continue
+		}
+
+		if unit == nil {
+			unit = &Query{Offset: m.Offset}
+			hasParse, hasExecute, hasDescribe, hasClose = false, false, false, false
+		}
+		unit.End = m.End
+		switch m.Type {
+		case 'P':
+			hasParse = true
+			name := cstring(m.Body)
+			rest := m.Body[min(len(name)+1, len(m.Body)):]
+			query := strings.TrimSpace(cstring(rest))
+			prepared[name] = query
+			unit.Statement = query
+			unit.Truncated = len(m.Body) < m.Len && bytes.IndexByte(rest, 0) < 0
+		case 'B':
+			portal := cstring(m.Body)
+			rest := m.Body[min(len(portal)+1, len(m.Body)):]
+			if q, ok := prepared[cstring(rest)]; ok && unit.Statement == "" {
+				unit.Statement = q
+			}
+		case 'E':
+			hasExecute = true
+		case 'D':
+			hasDescribe = true
+		case 'C':
+			hasClose = true
+		case 'S':
+			switch {
+			case hasExecute:
+				unit.Method = "EXECUTE"
+			case hasParse:
+				unit.Method = "PREPARE"
+			case hasDescribe:
+				unit.Method = "DESCRIBE"
+			case hasClose:
+				unit.Method = "CLOSE"
+			default:
+				unit.Method = "SYNC"
+			}
+			queries = append(queries, *unit)
+			unit = nil
+		}
+	}
+	return queries
+}
+
+// parseResults splits server messages into answers ending at ReadyForQuery.
+// Notices, notifications and parameter changes between answers are
+// asynchronous and start nothing.
+func parseResults(msgs []Message) []*Result {
+	var results []*Result
+	var cur *Result
+	for _, m := range msgs {
+		if cur == nil {
+			if m.Type == 'N' || m.Type == 'A' || m.Type == 'S' {
+				continue
+			}
+			cur = &Result{Offset: m.Offset}
+			results = append(results, cur)
+		}
+		cur.End = m.End
+		cur.Incomplete = cur.Incomplete || m.Lost
+		switch m.Type {
+		case 'D':
+			cur.Rows++
+		case 'C':
+			cur.Tags = append(cur.Tags, cstring(m.Body))
+		case 'E':
+			if cur.Error == nil {
+				cur.Error = parseError(m.Body)
+			}
+		case 'Z':
+			if len(m.Body) > 0 {
+				cur.TxStatus = m.Body[0]
+			}
+			cur.Ready = true
+			cur = nil
+		}
+	}
+	return results
+}
+
+// parseError reads the fields of an ErrorResponse
+func parseError(b []byte) *Error {
+	e := &Error{}
+	for len(b) > 1 && b[0] != 0 {
+		field := b[0]
+		value := cstring(b[1:])
+		switch field {
+		case 'V':
+			e.Severity = value
+		case 'S':
+			if e.Severity == "" {
+				e.Severity = value
+			}
+		case 'C':
+			e.Code = value
+		case 'M':
+			e.Message = value
+		}
+		b = b[min(len(value)+2, len(b)):]
+	}
+	return e
+}
+
+// cstring returns b up to its first NUL
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
@@ -0,0 +1,160 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flow lays segments out back to back, one chunk each, starting at start
+// and 2ms apart, so that client and server segments interleave
+func flow(start time.Duration, segs ...[]byte) *reassembly.Flow {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(start)
+	f := &reassembly.Flow{}
+	for i, s := range segs {
+		f.Chunks = append(f.Chunks, reassembly.Chunk{Offset: len(f.Data), Len: len(s), Timestamp: base.Add(time.Duration(i) * 2 * time.Millisecond), PacketIndex: i})
+		f.Data = append(f.Data, s...)
+	}
+	return f
+}
+
+func msg(typ byte, body string) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{typ}, uint32(4+len(body)))
+	return append(b, body...)
+}
+
+func untyped(code uint32, body string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	b = binary.BigEndian.AppendUint32(b, code)
+	return append(b, body...)
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// conversation declines TLS, logs in, runs a simple query and an extended
+// query that fails
+func conversation() (client, server [][]byte) {
+	client = [][]byte{
+		untyped(sslRequest, ""),
+		untyped(protocolV3, "user\x00alice\x00database\x00shop\x00application_name\x00psql\x00\x00"),
+		msg('Q', "SELECT 1\x00"),
+		join(
+			msg('P', "s1\x00INSERT INTO t VALUES ($1)\x00\x00\x00"),
+			msg('B', "\x00s1\x00\x00\x00\x00\x00\x00\x00"),
+			msg('E', "\x00\x00\x00\x00\x00"),
+			msg('S', ""),
+		),
+	}
+	server = [][]byte{
+		{'N'},
+		join(msg('R', "\x00\x00\x00\x00"), msg('S', "TimeZone\x00UTC\x00"), msg('K', "\x00\x00\x00\x01\x00\x00\x00\x02"), msg('Z', "I")),
+		join(msg('T', "\x00\x01?column?\x00"), msg('D', "\x00\x01\x00\x00\x00\x011"), msg('C', "SELECT 1\x00"), msg('Z', "I")),
+		join(msg('1', ""), msg('2', ""), msg('E', "SERROR\x00VERROR\x00C23505\x00Mduplicate key\x00\x00"), msg('Z', "E")),
+	}
+	return client, server
+}
+
+func TestParse(t *testing.T) {
+	c, s := conversation()
+	conv := Parse(flow(0, c...), flow(time.Millisecond, s...))
+
+	if st := conv.Startup; st == nil || st.User != "alice" || st.Database != "shop" || st.Application != "psql" || st.TLS {
+		t.Fatalf("startup = %+v", conv.Startup)
+	}
+	if len(conv.Exchanges) != 3 {
+		t.Fatalf("got %d exchanges, want 3", len(conv.Exchanges))
+	}
+	want := []struct {
+		method, statement string
+		tx                byte
+	}{
+		{"STARTUP", "", 'I'},
+		{"QUERY", "SELECT 1", 'I'},
+		{"EXECUTE", "INSERT INTO t VALUES ($1)", 'E'},
+	}
+	for i, w := range want {
+		ex := conv.Exchanges[i]
+		if ex.Query.Method != w.method || ex.Query.Statement != w.statement {
+			t.Errorf("query %d = %s %q, want %s %q", i, ex.Query.Method, ex.Query.Statement, w.method, w.statement)
+		}
+		if ex.Result == nil || !ex.Result.Ready || ex.Result.TxStatus != w.tx {
+			t.Errorf("result %d = %+v, want ready with status %c", i, ex.Result, w.tx)
+		}
+	}
+	if r := conv.Exchanges[1].Result; r.Rows != 1 || len(r.Tags) != 1 || r.Tags[0] != "SELECT 1" {
+		t.Errorf("query result = %+v", r)
+	}
+	if e := conv.Exchanges[2].Result.Error; e == nil || e.Severity != "ERROR" || e.Code != "23505" || e.Message != "duplicate key" {
+		t.Errorf("error = %+v", e)
+	}
+}
+
+func TestParseStartup(t *testing.T) {
+	tests := []struct {
+		name         string
+		client       []byte
+		server       []byte
+		tls, cancel  bool
+		hasExchanges bool
+	}{
+		{"tls accepted", untyped(sslRequest, ""), []byte{'S'}, true, false, false},
+		{"cancel", untyped(cancelCode, "\x00\x00\x00\x01\x00\x00\x00\x02"), nil, false, true, false},
+		{"startup cut short", untyped(protocolV3, "user\x00al"), nil, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := Parse(flow(0, tt.client), flow(time.Millisecond, tt.server))
+			if conv.Startup == nil || conv.Startup.TLS != tt.tls || conv.Startup.Cancel != tt.cancel {
+				t.Fatalf("startup = %+v", conv.Startup)
+			}
+			if len(conv.Exchanges) > 0 != tt.hasExchanges {
+				t.Errorf("exchanges = %+v", conv.Exchanges)
+			}
+		})
+	}
+}
+
+// Every prefix of a conversation must parse without panicking, with
+// offsets inside the data
+func TestTruncated(t *testing.T) {
+	c, s := conversation()
+	client, server := join(c...), join(s...)
+	for i := 0; i <= len(client); i++ {
+		check(t, client[:i], server[:min(i, len(server))])
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	c, s := conversation()
+	f.Add(join(c...), join(s...))
+	f.Add(join(c[2:]...), join(s[2:]...))
+	f.Add(msg('P', "\x00"), msg('E', "S"))
+	f.Fuzz(func(t *testing.T, client, server []byte) {
+		check(t, client, server)
+	})
+}
+
+// check parses the flows whole and split in two segments
+func check(t *testing.T, client, server []byte) {
+	t.Helper()
+	flows := [][2]*reassembly.Flow{
+		{flow(0, client), flow(time.Millisecond, server)},
+		{flow(0, client[:len(client)/2], client[len(client)/2:]), flow(time.Millisecond, server[:len(server)/2], server[len(server)/2:])},
+	}
+	for _, fl := range flows {
+		conv := Parse(fl[0], fl[1])
+		for _, ex := range conv.Exchanges {
+			if q := ex.Query; q.Offset < 0 || q.End < q.Offset {
+				t.Fatalf("query at %d-%d", q.Offset, q.End)
+			}
+			if r := ex.Result; r != nil && (r.Offset < 0 || r.End < r.Offset) {
+				t.Fatalf("result at %d-%d", r.Offset, r.End)
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Initial salts from RFC 9001 section 5.2, RFC 9369 section 3.3.1 and
+// draft-ietf-quic-tls-29 (shared by drafts 29 to 32)
+var (
+	saltV1      = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	saltV2      = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+	saltDraft29 = []byte{0xaf, 0xbf, 0xec, 0x28, 0x99, 0x93, 0xd2, 0x4c, 0x9e, 0x97, 0x86, 0xf1, 0x9c, 0x61, 0x11, 0xe0, 0x43, 0x90, 0xa8, 0x99}
+)
+
+func initialSalt(v uint32) ([]byte, bool) {
+	switch {
+	case v == Version1:
+		return saltV1, true
+	case v == Version2:
+		return saltV2, true
+	case v >= 0xff00001d && v <= 0xff000020:
+		return saltDraft29, true
+	}
+	return nil, false
+}
+
+// keys protects the Initial packets sent by one endpoint
+type keys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// InitialKeys holds both directions of Initial protection for a connection
+type InitialKeys struct {
+	client, server *keys
+}
+
+// NewInitialKeys derives the Initial keys from the Destination Connection
+// ID of the client's first Initial packet
+func NewInitialKeys(version uint32, dcid []byte) (*InitialKeys, error) {
+	salt, ok := initialSalt(version)
+	if !ok {
+		return nil, errors.New("quic: no initial salt for version")
+	}
+	prefix := "quic "
+	if version == Version2 {
+		prefix = "quicv2 "
+	}
+
+	secret := hkdfExtract(salt, dcid)
+	client, err := newKeys(hkdfExpandLabel(secret, "client in", 32), prefix)
+	if err != nil {
+		return nil, err
+	}
+	server, err := newKeys(hkdfExpandLabel(secret, "server in", 32), prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &InitialKeys{client: client, server: server}, nil
+}
+
+func newKeys(secret []byte, prefix string) (*keys, error) {
+	block, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"key", 16))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"hp", 16))
+	if err != nil {
+		return nil, err
+	}
+	return &keys{aead: aead, iv: hkdfExpandLabel(secret, prefix+"iv", 12), hp: hp}, nil
+}
+
+// Decrypt removes header and packet protection from an Initial packet sent
+// by the client (fromClient) or the server and returns its frames payload.
+// The packet is not modified.
+func (k *InitialKeys) Decrypt(p *Packet, fromClient bool) ([]byte, error) {
+	if p.Type != PacketInitial {
+		return nil, errors.New("quic: not an Initial packet")
+	}
+	key := k.server
+	if fromClient {
+		key = k.client
+	}
+
+	// The sample starts 4 bytes after the packet number offset
+	if p.pnOffset+4+16 > len(p.raw) {
+		return nil, errShort
+	}
+	var mask [16]byte
+	key.hp.Encrypt(mask[:], p.raw[p.pnOffset+4:p.pnOffset+20])
+
+	first := p.raw[0] ^ (mask[0] & 0x0f)
+	pnLen := int(first&0x03) + 1
+	header := make([]byte, p.pnOffset+pnLen)
+	copy(header, p.raw)
+	header[0] = first
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		header[p.pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[p.pnOffset+i])
+	}
+
+	// Initial packet numbers start at zero, so the truncated value is
+	// the full one for the handshake packets we care about
+	nonce := make([]byte, len(key.iv))
+	copy(nonce, key.iv)
+	var pnBytes [8]byte
+	binary.BigEndian.PutUint64(pnBytes[:], pn)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-8+i] ^= pnBytes[i]
+	}
+
+	return key.aead.Open(nil, nonce, p.raw[len(header):], header)
+}
+
+// CryptoFrame is a chunk of the TLS handshake stream
+type CryptoFrame struct {
+	Offset uint64
+	Data   []byte
+}
+
+// ConnectionClose is a CONNECTION_CLOSE frame
+type ConnectionClose struct {
+	Application bool // 0x1d: closed by the application rather than the transport
+	ErrorCode   uint64
+	Reason      string
+}
+
+// Frames is what an Initial packet carries that matters for analysis
+type Frames struct {
+	Crypto []CryptoFrame
+	Close  *ConnectionClose
+}
+
+// ParseFrames decodes a decrypted Initial or Handshake payload. Only the
+// frame types allowed in those packets are understood; parsing stops at
+// the first other frame.
+func ParseFrames(payload []byte) Frames {
+	var f Frames
+	r := &reader{b: payload}
+	for r.pos < len(payload) && !r.err {
+		switch typ := r.varint(); typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			r.varint() // largest acknowledged
+			r.varint() // ack delay
+			ranges := r.varint()
+			r.varint() // first range
+			for i := uint64(0); i < ranges && !r.err; i++ {
+				r.varint() // gap
+				r.varint() // range length
+			}
+			if typ == 0x03 {
+				r.varint() // ECT0
+				r.varint() // ECT1
+				r.varint() // ECN-CE
+			}
+		case 0x06: // CRYPTO
+			offset := r.varint()
+			data := r.bytes(int(r.varint()))
+			if !r.err {
+				f.Crypto = append(f.Crypto, CryptoFrame{Offset: offset, Data: data})
+			}
+		case 0x1c, 0x1d: // CONNECTION_CLOSE
+			cc := &ConnectionClose{Application: typ == 0x1d, ErrorCode: r.varint()}
+			if typ == 0x1c {
+				r.varint() // frame type that triggered the error
+			}
+			cc.Reason = string(r.bytes(int(r.varint())))
+			if !r.err {
+				f.Close = cc
+			}
+		default:
+			return f
+		}
+	}
+	return f
+}
+
+// AssembleCrypto orders CRYPTO frames by offset and returns the contiguous
+// handshake bytes from offset zero, stopping at the first gap
+func AssembleCrypto(frames []CryptoFrame) []byte {
+	sorted := append([]CryptoFrame(nil), frames...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	var out []byte
+	for _, fr := range sorted {
+		end := fr.Offset + uint64(len(fr.Data))
+		if fr.Offset > uint64(len(out)) {
+			break
+		}
+		if end > uint64(len(out)) {
+			out = append(out, fr.Data[uint64(len(out))-fr.Offset:]...)
+		}
+	}
+	return out
+}
+
+// CryptoErrorAlert returns the TLS alert carried by a QUIC CRYPTO_ERROR
+// transport error code (0x0100-0x01ff)
+func CryptoErrorAlert(code uint64) (uint8, bool) {
+	if code >= 0x0100 && code <= 0x01ff {
+		return uint8(code - 0x0100), true
+	}
+	return 0, false
+}
+
+func hkdfExtract(salt, ikm []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+// hkdfExpandLabel is HKDF-Expand-Label from RFC 8446 section 7.1 with an
+// empty context
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	full := "tls13 " + label
+	info := make([]byte, 0, 4+len(full))
+	info = append(info, byte(length>>8), byte(length), byte(len(full)))
+	info = append(info, full...)
+	info = append(info, 0)
+
+	var out, prev []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(prev)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		prev = mac.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
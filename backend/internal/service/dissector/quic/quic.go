@@ -0,0 +1,253 @@
+// Package quic parses QUIC packet headers (RFC 9000, RFC 9369) and decrypts
+// Initial packets, whose keys are derived from the client's Destination
+// Connection ID and therefore readable by any observer.
+package quic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Known versions
+const (
+	Version1       uint32 = 0x00000001
+	Version2       uint32 = 0x6b3343cf
+	VersionDraft29 uint32 = 0xff00001d
+)
+
+// PacketType identifies the QUIC packet type, independent of the version's
+// on-the-wire encoding
+type PacketType uint8
+
+const (
+	PacketInitial PacketType = iota
+	Packet0RTT
+	PacketHandshake
+	PacketRetry
+	PacketVersionNegotiation
+	Packet1RTT
+)
+
+var packetTypeNames = [...]string{"Initial", "0-RTT", "Handshake", "Retry", "VersionNegotiation", "1-RTT"}
+
+func (t PacketType) String() string {
+	if int(t) < len(packetTypeNames) {
+		return packetTypeNames[t]
+	}
+	return "unknown"
+}
+
+const maxCIDLen = 20
+
+var errShort = errors.New("quic: packet truncated")
+
+// Packet is one QUIC packet. A UDP datagram may carry several coalesced
+// long-header packets followed by at most one short-header packet.
+type Packet struct {
+	Long    bool
+	Type    PacketType
+	Version uint32 // 0 for short-header packets
+	DCID    []byte
+	SCID    []byte // long header only
+	Token   []byte // Initial and Retry only
+
+	// SupportedVersions lists the versions offered in a Version Negotiation packet
+	SupportedVersions []uint32
+
+	raw      []byte // the whole packet
+	pnOffset int    // start of the protected packet number
+}
+
+// Parse splits a UDP datagram into its QUIC packets. shortDCIDLen is the
+// connection ID length expected in a short header, which the header itself
+// does not encode; pass -1 when it is unknown.
+func Parse(datagram []byte, shortDCIDLen int) ([]*Packet, error) {
+	var pkts []*Packet
+	for len(datagram) > 0 {
+		if datagram[0]&0x80 == 0 {
+			p, err := parseShort(datagram, shortDCIDLen)
+			if err != nil {
+				return pkts, err
+			}
+			return append(pkts, p), nil
+		}
+		p, n, err := parseLong(datagram)
+		if err != nil {
+			return pkts, err
+		}
+		pkts = append(pkts, p)
+		datagram = datagram[n:]
+		// Anything after a Retry or Version Negotiation is not QUIC
+		if p.Type == PacketRetry || p.Type == PacketVersionNegotiation {
+			break
+		}
+		// Coalesced packets are padded with zeros up to the datagram size
+		if len(datagram) > 0 && datagram[0] == 0 {
+			break
+		}
+	}
+	if len(pkts) == 0 {
+		return nil, errShort
+	}
+	return pkts, nil
+}
+
+// IsLongHeader reports whether b starts like a QUIC long-header packet
+func IsLongHeader(b []byte) bool {
+	if len(b) < 7 || b[0]&0x80 == 0 {
+		return false
+	}
+	version := be32(b[1:])
+	// Version Negotiation is the only long header allowed to clear the fixed bit
+	return version == 0 || b[0]&0x40 != 0
+}
+
+// IsShortHeader reports whether b could be a short-header packet carrying
+// a connection ID of dcidLen bytes
+func IsShortHeader(b []byte, dcidLen int) bool {
+	return len(b) > 1+dcidLen && b[0]&0xc0 == 0x40
+}
+
+func parseShort(b []byte, dcidLen int) (*Packet, error) {
+	if b[0]&0x40 == 0 {
+		return nil, errors.New("quic: fixed bit not set")
+	}
+	p := &Packet{Type: Packet1RTT, raw: b}
+	if dcidLen >= 0 {
+		if len(b) < 1+dcidLen {
+			return nil, errShort
+		}
+		p.DCID = b[1 : 1+dcidLen]
+		p.pnOffset = 1 + dcidLen
+	}
+	return p, nil
+}
+
+func parseLong(b []byte) (*Packet, int, error) {
+	if len(b) < 7 {
+		return nil, 0, errShort
+	}
+	p := &Packet{Long: true, Version: be32(b[1:])}
+	r := &reader{b: b, pos: 5}
+
+	dcid := r.bytes(int(r.u8()))
+	scid := r.bytes(int(r.u8()))
+	if r.err || len(dcid) > maxCIDLen || len(scid) > maxCIDLen {
+		return nil, 0, errShort
+	}
+	p.DCID, p.SCID = dcid, scid
+
+	if p.Version == 0 {
+		p.Type = PacketVersionNegotiation
+		for r.pos+4 <= len(b) {
+			p.SupportedVersions = append(p.SupportedVersions, be32(b[r.pos:]))
+			r.pos += 4
+		}
+		p.raw = b
+		return p, len(b), nil
+	}
+	if b[0]&0x40 == 0 {
+		return nil, 0, errors.New("quic: fixed bit not set")
+	}
+
+	p.Type = longType(p.Version, (b[0]>>4)&0x03)
+	if p.Type == PacketRetry {
+		// The token runs up to the 16-byte integrity tag
+		if len(b)-r.pos < 16 {
+			return nil, 0, errShort
+		}
+		p.Token = b[r.pos : len(b)-16]
+		p.raw = b
+		return p, len(b), nil
+	}
+	if p.Type == PacketInitial {
+		p.Token = r.bytes(int(r.varint()))
+	}
+	length := r.varint()
+	if r.err || uint64(len(b)-r.pos) < length {
+		return nil, 0, errShort
+	}
+	p.pnOffset = r.pos
+	end := r.pos + int(length)
+	p.raw = b[:end]
+	return p, end, nil
+}
+
+// longType maps the two type bits to a packet type. QUIC v2 rotates the
+// encoding so that ossified middleboxes do not rely on it.
+func longType(version uint32, bits byte) PacketType {
+	if version == Version2 {
+		return [...]PacketType{PacketRetry, PacketInitial, Packet0RTT, PacketHandshake}[bits]
+	}
+	return [...]PacketType{PacketInitial, Packet0RTT, PacketHandshake, PacketRetry}[bits]
+}
+
+// VersionName returns a readable name for a QUIC version
+func VersionName(v uint32) string {
+	switch {
+	case v == Version1:
+		return "QUICv1"
+	case v == Version2:
+		return "QUICv2"
+	case v&0xffffff00 == 0xff000000:
+		return fmt.Sprintf("draft-%d", v&0xff)
+	case v&0x0f0f0f0f == 0x0a0a0a0a:
+		return fmt.Sprintf("GREASE(0x%08x)", v)
+	}
+	return fmt.Sprintf("0x%08x", v)
+}
+
+// Known reports whether v is a version whose Initial packets can be decrypted
+func Known(v uint32) bool {
+	_, ok := initialSalt(v)
+	return ok
+}
+
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// reader is a bounds-checked cursor; after an overrun err is set and
+// every read returns zero values
+type reader struct {
+	b   []byte
+	pos int
+	err bool
+}
+
+func (r *reader) u8() uint8 {
+	if r.err || r.pos >= len(r.b) {
+		r.err = true
+		return 0
+	}
+	v := r.b[r.pos]
+	r.pos++
+	return v
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err || n < 0 || r.pos+n > len(r.b) {
+		r.err = true
+		return nil
+	}
+	v := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return v
+}
+
+// varint decodes a QUIC variable-length integer (RFC 9000 section 16)
+func (r *reader) varint() uint64 {
+	first := r.u8()
+	if r.err {
+		return 0
+	}
+	n := 1 << (first >> 6)
+	v := uint64(first & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(r.u8())
+	}
+	if r.err {
+		return 0
+	}
+	return v
+}
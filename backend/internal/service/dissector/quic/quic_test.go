@@ -0,0 +1,194 @@
+package quic
+
+import (
+	"bytes"
+	"testing"
+)
+
+var dcid = []byte{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08}
+
+// frames is a CRYPTO frame split in two, out of order, then a
+// CONNECTION_CLOSE carrying a TLS alert
+var frames = []byte{
+	0x06, 0x03, 0x02, 'l', 'o',
+	0x06, 0x00, 0x03, 'h', 'e', 'l',
+	0x1c, 0x41, 0x28, 0x06, 0x03, 'b', 'a', 'd',
+}
+
+// initial builds a client Initial packet protected with the keys derived
+// from dcid, padded with zeros after the frames
+func initial(t testing.TB, version uint32) []byte {
+	t.Helper()
+	k, err := NewInitialKeys(version, dcid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := append(append([]byte(nil), frames...), make([]byte, 40)...)
+	const pnLen = 2
+	length := pnLen + len(payload) + k.client.aead.Overhead()
+
+	typeBits := byte(0)
+	if version == Version2 {
+		typeBits = 1
+	}
+	header := []byte{0xc0 | typeBits<<4 | (pnLen - 1), byte(version >> 24), byte(version >> 16), byte(version >> 8), byte(version)}
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, 0, 0) // no SCID, no token
+	header = append(header, 0x40|byte(length>>8), byte(length))
+	pnOffset := len(header)
+	header = append(header, 0, 0) // packet number 0
+
+	nonce := append([]byte(nil), k.client.iv...)
+	pkt := k.client.aead.Seal(header, nonce, payload, header)
+
+	var mask [16]byte
+	k.client.hp.Encrypt(mask[:], pkt[pnOffset+4:pnOffset+20])
+	pkt[0] ^= mask[0] & 0x0f
+	for i := 0; i < pnLen; i++ {
+		pkt[pnOffset+i] ^= mask[1+i]
+	}
+	return pkt
+}
+
+func TestParseAndDecrypt(t *testing.T) {
+	for _, version := range []uint32{Version1, Version2, VersionDraft29} {
+		t.Run(VersionName(version), func(t *testing.T) {
+			pkt := initial(t, version)
+			// A short-header packet may follow the coalesced long ones
+			datagram := append(append([]byte(nil), pkt...), 0x40, 1, 2, 3, 4, 5)
+			pkts, err := Parse(datagram, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pkts) != 2 || pkts[0].Type != PacketInitial || pkts[1].Type != Packet1RTT {
+				t.Fatalf("packets = %+v", pkts)
+			}
+			if !bytes.Equal(pkts[0].DCID, dcid) || pkts[0].Version != version {
+				t.Errorf("initial = %+v", pkts[0])
+			}
+			if !bytes.Equal(pkts[1].DCID, []byte{1, 2, 3, 4}) {
+				t.Errorf("short header DCID = %x, want 01020304", pkts[1].DCID)
+			}
+
+			k, err := NewInitialKeys(version, dcid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := k.Decrypt(pkts[0], false); err == nil {
+				t.Error("decrypted a client packet with the server keys")
+			}
+			payload, err := k.Decrypt(pkts[0], true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := ParseFrames(payload)
+			if got := string(AssembleCrypto(f.Crypto)); got != "hello" {
+				t.Errorf("crypto = %q, want hello", got)
+			}
+			if f.Close == nil || f.Close.Reason != "bad" {
+				t.Fatalf("close = %+v", f.Close)
+			}
+			if alert, ok := CryptoErrorAlert(f.Close.ErrorCode); !ok || alert != 40 {
+				t.Errorf("alert = %d, %v, want 40", alert, ok)
+			}
+		})
+	}
+}
+
+func TestHeaderForms(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		long, short bool
+	}{
+		{"initial", []byte{0xc0, 0, 0, 0, 1, 0, 0}, true, false},
+		{"version negotiation", []byte{0x80, 0, 0, 0, 0, 0, 0}, true, false},
+		{"long without fixed bit", []byte{0x80, 0, 0, 0, 1, 0, 0}, false, false},
+		{"short", []byte{0x41, 1, 2, 3, 4, 5}, false, true},
+		{"short without room for the DCID", []byte{0x41, 1, 2, 3, 4}, false, false},
+		{"short without fixed bit", []byte{0x01, 1, 2, 3, 4, 5}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsLongHeader(tt.data); got != tt.long {
+				t.Errorf("IsLongHeader = %v, want %v", got, tt.long)
+			}
+			if got := IsShortHeader(tt.data, 4); got != tt.short {
+				t.Errorf("IsShortHeader = %v, want %v", got, tt.short)
+			}
+		})
+	}
+}
+
+func TestParseVersionNegotiation(t *testing.T) {
+	data := []byte{0x80, 0, 0, 0, 0, 1, 0xaa, 1, 0xbb, 0, 0, 0, 1, 0x6b, 0x33, 0x43, 0xcf}
+	pkts, err := Parse(data, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkts) != 1 || pkts[0].Type != PacketVersionNegotiation || len(pkts[0].SupportedVersions) != 2 {
+		t.Errorf("packets = %+v", pkts)
+	}
+}
+
+func TestAssembleCryptoGap(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []CryptoFrame
+		want   string
+	}{
+		{"empty", nil, ""},
+		{"gap", []CryptoFrame{{Offset: 0, Data: []byte("ab")}, {Offset: 3, Data: []byte("d")}}, "ab"},
+		{"overlap", []CryptoFrame{{Offset: 0, Data: []byte("abc")}, {Offset: 1, Data: []byte("bcd")}}, "abcd"},
+		{"offset overflow", []CryptoFrame{{Offset: 0, Data: []byte("a")}, {Offset: 1<<64 - 1, Data: []byte("xy")}}, "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(AssembleCrypto(tt.frames)); got != tt.want {
+				t.Errorf("AssembleCrypto = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Every prefix of a packet and of its frames must parse without panicking
+func TestTruncated(t *testing.T) {
+	pkt := initial(t, Version1)
+	for i := 0; i <= len(pkt); i++ {
+		parse(pkt[:i])
+		if i <= len(frames) {
+			ParseFrames(frames[:i])
+		}
+	}
+	if _, err := Parse(pkt[:len(pkt)-1], -1); err == nil {
+		t.Error("truncated Initial parsed")
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(initial(f, Version1))
+	f.Add(initial(f, Version2))
+	f.Add([]byte{0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+	f.Add([]byte{0x40, 1, 2, 3, 4, 5, 6, 7, 8})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parse(data)
+		ParseFrames(data)
+	})
+}
+
+func parse(data []byte) {
+	pkts, _ := Parse(data, 8)
+	for _, p := range pkts {
+		if p.Type != PacketInitial || !Known(p.Version) {
+			continue
+		}
+		k, err := NewInitialKeys(p.Version, p.DCID)
+		if err != nil {
+			continue
+		}
+		if payload, err := k.Decrypt(p, true); err == nil {
+			AssembleCrypto(ParseFrames(payload).Crypto)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// Package redis decodes the Redis serialization protocol (RESP2 and RESP3)
+// from reassembled TCP flows and pairs pipelined commands with replies.
+package redis
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+const (
+	maxDepth  = 16
+	maxArgLen = 128
+	maxArgs   = 2 // arguments kept for display after the command name
+	// maxLen bounds bulk lengths and aggregate counts at the server's
+	// proto-max-bulk-len; anything larger means we lost framing
+	maxLen = 512 << 20
+)
+
+// Command is one client command. Offsets index the client flow data.
+type Command struct {
+	Name     string   // upper-cased, with the subcommand for container commands, e.g. "CONFIG GET"
+	Args     []string // the first arguments after the name, shortened
+	Argc     int
+	Blocking bool // waits server-side by design (BLPOP, XREAD BLOCK, WAIT...)
+	Offset   int
+	End      int
+}
+
+// Reply is one server reply. Offsets index the server flow data.
+type Reply struct {
+	Type       byte   // RESP type marker: '+', '-', ':', '$', '*', '!', '>'...
+	Text       string // simple string, error message or integer
+	Len        int    // bulk length or aggregate element count, -1 for null
+	Offset     int
+	End        int
+	Incomplete bool // a gap or the end of the capture cut the reply short
+}
+
+// IsError reports whether the reply is an error
+func (r Reply) IsError() bool {
+	return r.Type == '-' || r.Type == '!'
+}
+
+// ErrorPrefix returns the error code that starts an error reply,
+// e.g. "WRONGTYPE" or "ERR"
+func (r Reply) ErrorPrefix() string {
+	if i := strings.IndexByte(r.Text, ' '); i > 0 {
+		return r.Text[:i]
+	}
+	return r.Text
+}
+
+// Exchange pairs a command with its reply (nil if none was captured)
+type Exchange struct {
+	Command Command
+	Reply   *Reply
+}
+
+// Conversation is a decoded Redis connection
+type Conversation struct {
+	Exchanges []Exchange
+	// PubSub is set once the connection subscribed or started MONITOR;
+	// from then on the server pushes messages that answer no command
+	PubSub bool
+	// Lost is set when reply framing was lost, after which commands are
+	// left unpaired rather than matched to the wrong replies
+	Lost bool
+}
+
+// subcommands are commands whose first argument selects the operation
+var subcommands = map[string]bool{
+	"ACL": true, "CLIENT": true, "CLUSTER": true, "COMMAND": true, "CONFIG": true, "DEBUG": true,
+	"FUNCTION": true, "LATENCY": true, "MEMORY": true, "MODULE": true, "OBJECT": true,
+	"SCRIPT": true, "SLOWLOG": true, "XGROUP": true, "XINFO": true,
+}
+
+var blocking = map[string]bool{
+	"BLPOP": true, "BRPOP": true, "BRPOPLPUSH": true, "BLMOVE": true, "BLMPOP": true,
+	"BZPOPMIN": true, "BZPOPMAX": true, "BZMPOP": true, "WAIT": true, "WAITAOF": true,
+}
+
+var knownCommands = map[string]bool{
+	"PING": true, "AUTH": true, "HELLO": true, "SELECT": true, "GET": true, "SET": true,
+	"MGET": true, "MSET": true, "DEL": true, "EXISTS": true, "EXPIRE": true, "INCR": true,
+	"HGET": true, "HSET": true, "HGETALL": true, "LPUSH": true, "RPUSH": true, "SADD": true,
+	"ZADD": true, "INFO": true, "CLIENT": true, "MULTI": true, "EXEC": true, "EVALSHA": true,
+	"EVAL": true, "SUBSCRIBE": true, "PUBLISH": true, "KEYS": true, "SCAN": true, "QUIT": true,
+}
+
+// LooksLikeCommand reports whether data begins with a RESP command array
+// whose first element is a familiar command name
+func LooksLikeCommand(data []byte) bool {
+	if len(data) < 4 || data[0] != '*' {
+		return false
+	}
+	i := bytes.Index(data, []byte("\r\n$"))
+	if i < 2 {
+		return false
+	}
+	if n, err := strconv.Atoi(string(data[1:i])); err != nil || n < 1 {
+		return false
+	}
+	rest := data[i+3:]
+	j := bytes.Index(rest, []byte("\r\n"))
+	if j < 1 {
+		return false
+	}
+	n, err := strconv.Atoi(string(rest[:j]))
+	if err != nil || n < 1 || n > 32 || len(rest) < j+2+n {
+		return false
+	}
+	return knownCommands[strings.ToUpper(string(rest[j+2:j+2+n]))]
+}
+
+// Parse decodes a Redis connection from its client and server flows.
+// Replies answer commands in order, so pipelining needs no further care.
+func Parse(client, server *reassembly.Flow) *Conversation {
+	conv := &Conversation{}
+	cmds := parseCommands(client)
+	replies, lost := parseReplies(server)
+
+	ri := 0
+	for _, c := range cmds {
+		ex := Exchange{Command: c}
+		if !conv.PubSub && ri < len(replies) {
+			ex.Reply = replies[ri]
+			ri++
+		}
+		switch c.Name {
+		case "SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE", "MONITOR":
+			conv.PubSub = true
+		}
+		conv.Exchanges = append(conv.Exchanges, ex)
+	}
+	if lost >= 0 && lost < len(conv.Exchanges) && !conv.PubSub {
+		// Replies past the loss may belong to any command after it
+		conv.Lost = true
+		for i := lost; i < len(conv.Exchanges); i++ {
+			conv.Exchanges[i].Reply = nil
+		}
+	}
+	return conv
+}
+
+func parseCommands(flow *reassembly.Flow) []Command {
+	var cmds []Command
+	pos := 0
+	for pos < len(flow.Data) {
+		data := flow.Contiguous(pos)
+		if len(data) == 0 {
+			pos = resync(flow, pos+1, true)
+			continue
+		}
+		if data[0] != '*' {
+			// Inline command: a plain text line
+			eol := bytes.IndexByte(data, '\n')
+			if eol < 0 {
+				break
+			}
+			fields := strings.Fields(string(data[:eol]))
+			if len(fields) > 0 {
+				cmds = append(cmds, newCommand(fields, len(fields), pos, pos+eol+1))
+			}
+			pos += eol + 1
+			continue
+		}
+
+		var args []string
+		argc := 0
+		end, ok := walk(flow, pos, 0, func(typ byte, text string, depth int) {
+			if depth == 1 {
+				argc++
+				if len(args) < maxArgs+2 {
+					args = append(args, text)
+				} else if strings.EqualFold(text, "BLOCK") {
+					args = append(args, text)
+				}
+			}
+		})
+		if argc > 0 {
+			cmds = append(cmds, newCommand(args, argc, pos, end))
+		}
+		if !ok {
+			pos = resync(flow, end, true)
+			continue
+		}
+		pos = end
+	}
+	return cmds
+}
+
+func newCommand(args []string, argc, offset, end int) Command {
+	c := Command{Name: strings.ToUpper(args[0]), Argc: argc, Offset: offset, End: end}
+	args = args[1:]
+	if subcommands[c.Name] && len(args) > 0 {
+		c.Name += " " + strings.ToUpper(args[0])
+		args = args[1:]
+	}
+	for _, a := range args {
+		if strings.EqualFold(a, "BLOCK") && (c.Name == "XREAD" || c.Name == "XREADGROUP") {
+			c.Blocking = true
+		}
+	}
+	c.Blocking = c.Blocking || blocking[c.Name]
+	if len(args) > maxArgs {
+		args = args[:maxArgs]
+	}
+	for _, a := range args {
+		if len(a) > maxArgLen {
+			a = a[:maxArgLen] + "..."
+		}
+		c.Args = append(c.Args, a)
+	}
+	return c
+}
+
+// parseReplies frames the server's replies. lost is the index of the first
+// reply framed after a loss, or -1.
+func parseReplies(flow *reassembly.Flow) (replies []*Reply, lost int) {
+	lost = -1
+	pos := 0
+	for pos < len(flow.Data) {
+		data := flow.Contiguous(pos)
+		if len(data) == 0 || !isType(data[0]) {
+			if lost < 0 {
+				lost = len(replies)
+			}
+			pos = resync(flow, pos+1, false)
+			continue
+		}
+		r := &Reply{Type: data[0], Offset: pos, Len: -1}
+		end, ok := walk(flow, pos, 0, func(typ byte, text string, depth int) {
+			if depth == 0 {
+				switch typ {
+				case '+', '-', '!', ':', ',', '#', '(':
+					r.Text = text
+				}
+			}
+		})
+		if n, err := strconv.Atoi(header(data)); err == nil {
+			r.Len = n
+		}
+		r.End = end
+		r.Incomplete = !ok
+		// Out-of-band pushes (RESP3) answer no command
+		if r.Type != '>' {
+			replies = append(replies, r)
+		}
+		if !ok {
+			if lost < 0 {
+				lost = len(replies)
+			}
+			pos = resync(flow, end, false)
+			continue
+		}
+		pos = end
+	}
+	return replies, lost
+}
+
+// header returns the text after the type marker of the line at data
+func header(data []byte) string {
+	if i := bytes.Index(data, []byte("\r\n")); i > 0 {
+		return string(data[1:i])
+	}
+	return ""
+}
+
+func isType(c byte) bool {
+	return strings.IndexByte("+-:$*_,#!=(%~>|", c) >= 0
+}
+
+// walk steps over the value at pos, calling visit for each scalar with its
+// nesting depth, and returns the offset after it. ok is false when the
+// value's end was not captured.
+func walk(flow *reassembly.Flow, pos, depth int, visit func(typ byte, text string, depth int)) (int, bool) {
+	data := flow.Contiguous(pos)
+	eol := bytes.Index(data, []byte("\r\n"))
+	if eol < 1 || depth > maxDepth {
+		return pos, false
+	}
+	typ, line := data[0], string(data[1:eol])
+	next := pos + eol + 2
+
+	switch typ {
+	case '+', '-', ':', ',', '#', '(', '_':
+		visit(typ, line, depth)
+		return next, true
+	case '$', '!', '=':
+		n, err := strconv.Atoi(line)
+		if err != nil || n > maxLen {
+			return next, false
+		}
+		if n < 0 {
+			visit(typ, "", depth)
+			return next, true
+		}
+		body := flow.Contiguous(next)
+		visit(typ, string(body[:min(n, len(body))]), depth)
+		return flow.Skip(next, int64(n)+2)
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(line)
+		if err != nil || n > maxLen {
+			return next, false
+		}
+		if typ == '%' || typ == '|' {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			end, ok := walk(flow, next, depth+1, visit)
+			if !ok {
+				return end, false
+			}
+			next = end
+		}
+		if typ == '|' {
+			// Attributes precede the value they describe
+			return walk(flow, next, depth, visit)
+		}
+		return next, true
+	}
+	return pos, false
+}
+
+func resync(flow *reassembly.Flow, from int, client bool) int {
+	for p := flow.NextPacketStart(from); p < len(flow.Data); p = flow.NextPacketStart(p + 1) {
+		data := flow.Contiguous(p)
+		if client && LooksLikeCommand(data) || !client && len(data) > 0 && isType(data[0]) && bytes.Contains(data, []byte("\r\n")) {
+			return p
+		}
+	}
+	return len(flow.Data)
+}
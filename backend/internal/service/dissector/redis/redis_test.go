@@ -0,0 +1,136 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flow lays segments out back to back, one chunk each
+func flow(segs ...string) *reassembly.Flow {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &reassembly.Flow{}
+	for i, s := range segs {
+		f.Chunks = append(f.Chunks, reassembly.Chunk{Offset: len(f.Data), Len: len(s), Timestamp: base.Add(time.Duration(i) * time.Millisecond), PacketIndex: i})
+		f.Data = append(f.Data, s...)
+	}
+	return f
+}
+
+// pipeline is a pipelined batch, a blocking pop and an inline command
+var pipeline = struct{ client, server []string }{
+	client: []string{
+		"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nhello\r\n*2\r\n$4\r\nLLEN\r\n$1\r\nk\r\n*3\r\n$6\r\nCONFIG\r\n$3\r\nGET\r\n$7\r\nmaxconn\r\n",
+		"*3\r\n$5\r\nBLPOP\r\n$1\r\nq\r\n$1\r\n0\r\n",
+		"PING\r\n",
+	},
+	server: []string{
+		"+OK\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n*2\r\n$7\r\nmaxconn\r\n$2\r\n10\r\n",
+		">3\r\n$7\r\nmessage\r\n$1\r\nc\r\n$1\r\nx\r\n*-1\r\n",
+		"+PONG\r\n",
+	},
+}
+
+func TestParse(t *testing.T) {
+	if !LooksLikeCommand([]byte(pipeline.client[0])) {
+		t.Error("SET not recognized as a command")
+	}
+	conv := Parse(flow(pipeline.client...), flow(pipeline.server...))
+	if conv.Lost || conv.PubSub {
+		t.Errorf("conversation lost %v, pubsub %v", conv.Lost, conv.PubSub)
+	}
+	want := []struct {
+		name  string
+		reply byte
+		len   int
+	}{
+		{"SET", '+', -1},
+		{"LLEN", '-', -1},
+		{"CONFIG GET", '*', 2},
+		{"BLPOP", '*', -1},
+		{"PING", '+', -1},
+	}
+	if len(conv.Exchanges) != len(want) {
+		t.Fatalf("got %d exchanges, want %d", len(conv.Exchanges), len(want))
+	}
+	for i, w := range want {
+		ex := conv.Exchanges[i]
+		if ex.Command.Name != w.name || ex.Reply == nil || ex.Reply.Type != w.reply || ex.Reply.Len != w.len {
+			t.Errorf("exchange %d = %+v answered %+v, want %s answered %c with length %d", i, ex.Command, ex.Reply, w.name, w.reply, w.len)
+		}
+	}
+	if c := conv.Exchanges[2].Command; len(c.Args) != 1 || c.Args[0] != "maxconn" {
+		t.Errorf("CONFIG GET args = %v", c.Args)
+	}
+	if !conv.Exchanges[3].Command.Blocking {
+		t.Error("BLPOP not blocking")
+	}
+	if r := conv.Exchanges[1].Reply; !r.IsError() || r.ErrorPrefix() != "WRONGTYPE" {
+		t.Errorf("error reply = %+v", r)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		server string
+	}{
+		{"huge bulk length", "$9223372036854775807\r\nx\r\n"},
+		{"bulk past the end", "$100\r\nabc"},
+		{"huge aggregate", "*9223372036854775807\r\n:1\r\n"},
+		{"huge map", "%4611686018427387904\r\n:1\r\n"},
+		{"bad length", "$x\r\n"},
+		{"deep nesting", strings.Repeat("*1\r\n", 2*maxDepth) + ":1\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := Parse(flow("*1\r\n$4\r\nPING\r\n"), flow(tt.server))
+			if len(conv.Exchanges) != 1 {
+				t.Fatalf("exchanges = %+v", conv.Exchanges)
+			}
+			if r := conv.Exchanges[0].Reply; r != nil && (r.End < r.Offset || !r.Incomplete) {
+				t.Errorf("reply = %+v, want an incomplete reply", r)
+			}
+		})
+	}
+}
+
+// Every prefix of a conversation must parse without panicking. Ends may lie
+// past the data: they count bytes the capture cut off.
+func TestTruncated(t *testing.T) {
+	client := strings.Join(pipeline.client, "")
+	server := strings.Join(pipeline.server, "")
+	for i := 0; i <= len(server); i++ {
+		check(t, client[:min(i, len(client))], server[:i])
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(strings.Join(pipeline.client, ""), strings.Join(pipeline.server, ""))
+	f.Add("*1\r\n$9\r\nSUBSCRIBE\r\n", ">3\r\n$9\r\nsubscribe\r\n$1\r\nc\r\n:1\r\n")
+	f.Add("HELLO 3\r\n", "%1\r\n$6\r\nserver\r\n$5\r\nredis\r\n|1\r\n+a\r\n:1\r\n:2\r\n")
+	f.Fuzz(func(t *testing.T, client, server string) {
+		check(t, client, server)
+	})
+}
+
+// check parses the flows whole and split in two segments
+func check(t *testing.T, client, server string) {
+	t.Helper()
+	flows := [][2]*reassembly.Flow{
+		{flow(client), flow(server)},
+		{flow(client[:len(client)/2], client[len(client)/2:]), flow(server[:len(server)/2], server[len(server)/2:])},
+	}
+	for _, fl := range flows {
+		for _, ex := range Parse(fl[0], fl[1]).Exchanges {
+			if c := ex.Command; c.Offset < 0 || c.End < c.Offset {
+				t.Fatalf("command at %d-%d", c.Offset, c.End)
+			}
+			if r := ex.Reply; r != nil && (r.Offset < 0 || r.End < r.Offset) {
+				t.Fatalf("reply at %d-%d", r.Offset, r.End)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package rtp decodes RTP and RTCP headers (RFC 3550) and implements the
+// receiver-side quality estimates: interarrival jitter and an E-model MOS.
+package rtp
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Header is the fixed RTP header
+type Header struct {
+	PayloadType uint8
+	Marker      bool
+	Sequence    uint16
+	Timestamp   uint32
+	SSRC        uint32
+}
+
+// StaticPayloadTypes are the RTP/AVP assignments of RFC 3551 as
+// "encoding/clock rate"
+var StaticPayloadTypes = map[uint8]string{
+	0:  "PCMU/8000",
+	3:  "GSM/8000",
+	4:  "G723/8000",
+	8:  "PCMA/8000",
+	9:  "G722/8000",
+	13: "CN/8000",
+	18: "G729/8000",
+	34: "H263/90000",
+}
+
+// StaticClockRate returns the clock rate of a static payload type, 0 if the
+// type is dynamic or unknown
+func StaticClockRate(pt uint8) int {
+	name, ok := StaticPayloadTypes[pt]
+	if !ok {
+		return 0
+	}
+	_, rate, _ := strings.Cut(name, "/")
+	n, _ := strconv.Atoi(rate)
+	return n
+}
+
+// IsRTCP reports whether p looks like an RTCP packet (version 2, packet
+// type 200-204). RTP and RTCP share the version bits, so this check must
+// run before ParseRTP when both may share a port.
+func IsRTCP(p []byte) bool {
+	return len(p) >= 8 && p[0]>>6 == 2 && p[1] >= 200 && p[1] <= 204
+}
+
+// ParseRTP decodes the RTP header of p
+func ParseRTP(p []byte) (Header, bool) {
+	if len(p) < 12 || p[0]>>6 != 2 || IsRTCP(p) {
+		return Header{}, false
+	}
+	cc := int(p[0] & 0x0f)
+	if len(p) < 12+4*cc {
+		return Header{}, false
+	}
+	return Header{
+		PayloadType: p[1] & 0x7f,
+		Marker:      p[1]&0x80 != 0,
+		Sequence:    binary.BigEndian.Uint16(p[2:4]),
+		Timestamp:   binary.BigEndian.Uint32(p[4:8]),
+		SSRC:        binary.BigEndian.Uint32(p[8:12]),
+	}, true
+}
+
+// ReportBlock is one reception report of an RTCP SR or RR
+type ReportBlock struct {
+	Reporter       uint32 // SSRC of the sender of the report
+	Source         uint32 // SSRC being reported on
+	FractionLost   float64
+	CumulativeLost int32
+	Jitter         uint32 // in timestamp units of the source
+}
+
+// ParseRTCP returns the reception reports of a (compound) RTCP packet
+func ParseRTCP(p []byte) []ReportBlock {
+	var blocks []ReportBlock
+	for len(p) >= 8 && p[0]>>6 == 2 {
+		count := int(p[0] & 0x1f)
+		pt := p[1]
+		n := (int(binary.BigEndian.Uint16(p[2:4])) + 1) * 4
+		if n > len(p) {
+			break
+		}
+		pkt := p[:n]
+		p = p[n:]
+		if len(pkt) < 8 {
+			continue // no room for the sender's SSRC
+		}
+
+		var reports []byte
+		switch pt {
+		case 200: // SR: header, SSRC, 20-byte sender info
+			if len(pkt) < 28 {
+				continue
+			}
+			reports = pkt[28:]
+		case 201: // RR: header, SSRC
+			reports = pkt[8:]
+		default:
+			continue
+		}
+		reporter := binary.BigEndian.Uint32(pkt[4:8])
+		for i := 0; i < count && len(reports) >= 24; i++ {
+			lost := int32(binary.BigEndian.Uint32(reports[4:8])&0xffffff) << 8 >> 8
+			blocks = append(blocks, ReportBlock{
+				Reporter:       reporter,
+				Source:         binary.BigEndian.Uint32(reports[0:4]),
+				FractionLost:   float64(reports[4]) / 256,
+				CumulativeLost: lost,
+				Jitter:         binary.BigEndian.Uint32(reports[12:16]),
+			})
+			reports = reports[24:]
+		}
+	}
+	return blocks
+}
+
+// Jitter is the RFC 3550 (section 6.4.1) interarrival jitter estimator
+type Jitter struct {
+	ClockRate int
+
+	started bool
+	transit float64
+	j       float64
+	max     float64
+}
+
+// Add feeds one packet: its arrival time in seconds and RTP timestamp
+func (e *Jitter) Add(arrival float64, ts uint32) {
+	transit := arrival*float64(e.ClockRate) - float64(ts)
+	if e.started {
+		d := math.Abs(transit - e.transit)
+		// Timestamp jumps (e.g. after silence suppression or a wrap) are not jitter
+		if d < float64(e.ClockRate) {
+			e.j += (d - e.j) / 16
+			if e.j > e.max {
+				e.max = e.j
+			}
+		}
+	}
+	e.transit = transit
+	e.started = true
+}
+
+// Ms returns the current jitter in milliseconds
+func (e *Jitter) Ms() float64 { return e.toMs(e.j) }
+
+// MaxMs returns the largest jitter seen in milliseconds
+func (e *Jitter) MaxMs() float64 { return e.toMs(e.max) }
+
+func (e *Jitter) toMs(v float64) float64 {
+	if e.ClockRate == 0 {
+		return 0
+	}
+	return v / float64(e.ClockRate) * 1000
+}
+
+// MOS estimates the mean opinion score (1-4.5) from one-way latency,
+// jitter and loss with the simplified ITU-T G.107 E-model commonly used by
+// network monitors.
+func MOS(latencyMs, jitterMs, lossPercent float64) float64 {
+	effective := latencyMs + 2*jitterMs + 10
+	r := 93.2 - effective/40
+	if effective >= 160 {
+		r = 93.2 - (effective-120)/10
+	}
+	r -= 2.5 * lossPercent
+	if r < 0 {
+		return 1
+	}
+	if r > 100 {
+		r = 100
+	}
+	return 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+}
+
+// StandardClockRates are the RTP clock rates tried when a dynamic payload
+// type has no SDP description
+var StandardClockRates = []int{8000, 16000, 48000, 90000}
+
+// EstimateClockRate picks the standard clock rate closest to the observed
+// ratio of timestamp advance to elapsed time
+func EstimateClockRate(tsDelta uint32, seconds float64) int {
+	best := StandardClockRates[0]
+	if seconds <= 0 {
+		return best
+	}
+	observed := float64(tsDelta) / seconds
+	for _, rate := range StandardClockRates {
+		if math.Abs(observed-float64(rate)) < math.Abs(observed-float64(best)) {
+			best = rate
+		}
+	}
+	return best
+}
@@ -0,0 +1,80 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// receiverReport is an RR from SSRC 1 with one report block on SSRC 2:
+// 25% lost, 10 cumulative, jitter 80
+func receiverReport() []byte {
+	p := []byte{0x81, 201, 0, 7}
+	p = binary.BigEndian.AppendUint32(p, 1)
+	p = binary.BigEndian.AppendUint32(p, 2)
+	p = append(p, 64, 0, 0, 10)
+	p = binary.BigEndian.AppendUint32(p, 1000) // highest sequence
+	p = binary.BigEndian.AppendUint32(p, 80)   // jitter
+	return append(p, make([]byte, 8)...)       // LSR, DLSR
+}
+
+func TestParseRTCP(t *testing.T) {
+	sender := append([]byte{0x80, 200, 0, 6}, make([]byte, 24)...)
+	blocks := ParseRTCP(append(sender, receiverReport()...))
+	if len(blocks) != 1 {
+		t.Fatalf("got %d report blocks, want 1", len(blocks))
+	}
+	want := ReportBlock{Reporter: 1, Source: 2, FractionLost: 0.25, CumulativeLost: 10, Jitter: 80}
+	if blocks[0] != want {
+		t.Errorf("block = %+v, want %+v", blocks[0], want)
+	}
+}
+
+func TestParseRTCPTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"4-byte RR", []byte{0x81, 201, 0, 0}},
+		{"4-byte RR before a full one", append([]byte{0x81, 201, 0, 0}, receiverReport()...)},
+		{"4-byte SR", []byte{0x81, 200, 0, 0}},
+		{"count beyond the blocks", []byte{0x9f, 201, 0, 1, 0, 0, 0, 1}},
+		{"length beyond the data", []byte{0x81, 201, 0, 9, 0, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ParseRTCP(tt.data)
+		})
+	}
+	rr := receiverReport()
+	for i := 0; i <= len(rr); i++ {
+		ParseRTCP(rr[:i])
+	}
+	if blocks := ParseRTCP(tests[1].data); len(blocks) != 1 {
+		t.Errorf("got %d blocks after an empty RR, want 1", len(blocks))
+	}
+}
+
+func TestParseRTP(t *testing.T) {
+	p := []byte{0x80, 0x80 | 8, 0x12, 0x34, 0, 0, 1, 0, 0xde, 0xad, 0xbe, 0xef}
+	h, ok := ParseRTP(p)
+	if !ok || h.PayloadType != 8 || !h.Marker || h.Sequence != 0x1234 || h.Timestamp != 256 || h.SSRC != 0xdeadbeef {
+		t.Errorf("ParseRTP = %+v, %v", h, ok)
+	}
+	for i := 0; i < len(p); i++ {
+		if _, ok := ParseRTP(p[:i]); ok {
+			t.Errorf("%d-byte header parsed", i)
+		}
+	}
+	if _, ok := ParseRTP([]byte{0x8f, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); ok {
+		t.Error("header with 15 CSRCs missing parsed")
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(receiverReport())
+	f.Add([]byte{0x80, 8, 0, 1, 0, 0, 0, 160, 0, 0, 0, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseRTP(data)
+		ParseRTCP(data)
+	})
+}
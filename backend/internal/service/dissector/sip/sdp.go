@@ -0,0 +1,104 @@
+package sip
+
+import (
+	"strconv"
+	"strings"
+
+	"pcap-analyzer/internal/service/dissector/rtp"
+)
+
+// SDP is the part of a session description needed to find media streams
+type SDP struct {
+	Address string // session-level c= address
+	Media   []Media
+}
+
+// Media is one m= line
+type Media struct {
+	Type    string // "audio", "video"
+	Address string // media-level c= address, else the session one
+	Port    uint16
+	Proto   string         // "RTP/AVP", "RTP/SAVP"...
+	Formats []int          // payload types in preference order
+	RTPMap  map[int]string // payload type -> "PCMU/8000"
+}
+
+// Codec returns the encoding of the preferred payload type, e.g. "PCMU/8000"
+func (m Media) Codec() string {
+	if len(m.Formats) == 0 {
+		return ""
+	}
+	pt := m.Formats[0]
+	if name, ok := m.RTPMap[pt]; ok {
+		return name
+	}
+	if name, ok := rtp.StaticPayloadTypes[uint8(pt)]; ok {
+		return name
+	}
+	return strconv.Itoa(pt)
+}
+
+// ParseSDP decodes the connection and media lines of a session description
+func ParseSDP(body []byte) *SDP {
+	sdp := &SDP{}
+	var cur *Media
+	for _, line := range strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n") {
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		value := line[2:]
+		switch line[0] {
+		case 'c':
+			// c=IN IP4 192.0.2.1
+			fields := strings.Fields(value)
+			if len(fields) < 3 {
+				continue
+			}
+			addr := strings.SplitN(fields[2], "/", 2)[0]
+			if cur != nil {
+				cur.Address = addr
+			} else {
+				sdp.Address = addr
+			}
+		case 'm':
+			// m=audio 49170 RTP/AVP 0 8 101
+			fields := strings.Fields(value)
+			if len(fields) < 3 {
+				continue
+			}
+			port, _ := strconv.Atoi(strings.SplitN(fields[1], "/", 2)[0])
+			sdp.Media = append(sdp.Media, Media{Type: fields[0], Port: uint16(port), Proto: fields[2], RTPMap: map[int]string{}})
+			cur = &sdp.Media[len(sdp.Media)-1]
+			for _, f := range fields[3:] {
+				if pt, err := strconv.Atoi(f); err == nil {
+					cur.Formats = append(cur.Formats, pt)
+				}
+			}
+		case 'a':
+			// a=rtpmap:101 telephone-event/8000
+			if cur == nil || !strings.HasPrefix(value, "rtpmap:") {
+				continue
+			}
+			pt, enc, ok := strings.Cut(strings.TrimPrefix(value, "rtpmap:"), " ")
+			if n, err := strconv.Atoi(pt); ok && err == nil {
+				cur.RTPMap[n] = strings.TrimSpace(enc)
+			}
+		}
+	}
+	for i := range sdp.Media {
+		if sdp.Media[i].Address == "" {
+			sdp.Media[i].Address = sdp.Address
+		}
+	}
+	return sdp
+}
+
+// Audio returns the first audio media line, if any
+func (s *SDP) Audio() (Media, bool) {
+	for _, m := range s.Media {
+		if m.Type == "audio" && m.Port != 0 {
+			return m, true
+		}
+	}
+	return Media{}, false
+}
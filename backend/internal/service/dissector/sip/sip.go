@@ -0,0 +1,162 @@
+// Package sip parses SIP messages (RFC 3261) and the SDP bodies (RFC 4566)
+// that negotiate the media streams of a call.
+package sip
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Message is one SIP request or response
+type Message struct {
+	Request    bool
+	Method     string // request method
+	URI        string // request URI
+	StatusCode int    // response status
+	Reason     string
+
+	CallID     string
+	From       string // URI from the From header
+	To         string
+	FromTag    string
+	ToTag      string
+	CSeq       int
+	CSeqMethod string
+
+	SDP *SDP // parsed body, if application/sdp
+
+	Offset int // position in the flow data (TCP)
+	Size   int
+}
+
+// IsStart reports whether data begins with a SIP request or status line
+func IsStart(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("SIP/2.0 ")) {
+		return true
+	}
+	line := data
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return bytes.HasSuffix(bytes.TrimRight(line, "\r"), []byte(" SIP/2.0"))
+}
+
+// Parse decodes one message. The body is taken from Content-Length, or the
+// rest of data for datagrams without one.
+func Parse(data []byte) (*Message, bool) {
+	headerEnd := bytes.Index(data, []byte("\r\n\r\n"))
+	sep := 4
+	if headerEnd < 0 {
+		headerEnd = bytes.Index(data, []byte("\n\n"))
+		sep = 2
+	}
+	if headerEnd < 0 || !IsStart(data) {
+		return nil, false
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(data[:headerEnd]), "\r\n", "\n"), "\n")
+	msg := &Message{}
+	first := strings.SplitN(lines[0], " ", 3)
+	if len(first) < 3 {
+		return nil, false
+	}
+	if first[0] == "SIP/2.0" {
+		code, err := strconv.Atoi(first[1])
+		if err != nil {
+			return nil, false
+		}
+		msg.StatusCode, msg.Reason = code, first[2]
+	} else {
+		msg.Request, msg.Method, msg.URI = true, first[0], first[1]
+	}
+
+	contentLength := -1
+	contentType := ""
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "call-id", "i":
+			msg.CallID = value
+		case "from", "f":
+			msg.From, msg.FromTag = nameAddr(value)
+		case "to", "t":
+			msg.To, msg.ToTag = nameAddr(value)
+		case "cseq":
+			if num, method, ok := strings.Cut(value, " "); ok {
+				msg.CSeq, _ = strconv.Atoi(num)
+				msg.CSeqMethod = strings.TrimSpace(method)
+			}
+		case "content-length", "l":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+			contentLength = n
+		case "content-type", "c":
+			contentType = strings.ToLower(value)
+		}
+	}
+
+	bodyStart := headerEnd + sep
+	bodyEnd := len(data)
+	if contentLength >= 0 {
+		// Compared without adding to bodyStart, which a huge length overflows
+		if contentLength > len(data)-bodyStart {
+			return nil, false // incomplete
+		}
+		bodyEnd = bodyStart + contentLength
+	}
+	msg.Size = bodyEnd
+	if strings.HasPrefix(contentType, "application/sdp") && bodyEnd > bodyStart {
+		msg.SDP = ParseSDP(data[bodyStart:bodyEnd])
+	}
+	return msg, true
+}
+
+// ParseStream splits a reassembled TCP flow into messages
+func ParseStream(data []byte) []*Message {
+	var msgs []*Message
+	pos := 0
+	for pos < len(data) {
+		// Skip keep-alive CRLFs between messages
+		for pos < len(data) && (data[pos] == '\r' || data[pos] == '\n') {
+			pos++
+		}
+		if pos >= len(data) {
+			break
+		}
+		msg, ok := Parse(data[pos:])
+		if !ok || msg.Size == 0 {
+			break
+		}
+		msg.Offset = pos
+		msgs = append(msgs, msg)
+		pos += msg.Size
+	}
+	return msgs
+}
+
+// nameAddr extracts the URI and tag parameter of a From/To header
+func nameAddr(v string) (uri, tag string) {
+	rest := v
+	if i := strings.Index(v, "<"); i >= 0 {
+		if j := strings.Index(v[i:], ">"); j >= 0 {
+			uri = v[i+1 : i+j]
+			rest = v[i+j+1:]
+		}
+	} else {
+		uri, rest, _ = strings.Cut(v, ";")
+		rest = ";" + rest
+	}
+	for _, param := range strings.Split(rest, ";") {
+		if k, val, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(k, "tag") {
+			tag = val
+		}
+	}
+	return strings.TrimSpace(uri), tag
+}
@@ -0,0 +1,75 @@
+package sip
+
+import (
+	"strconv"
+	"testing"
+)
+
+const sdp = "v=0\r\no=- 1 1 IN IP4 192.0.2.1\r\ns=-\r\nc=IN IP4 192.0.2.1\r\nt=0 0\r\n" +
+	"m=audio 49170 RTP/AVP 0 101\r\na=rtpmap:101 telephone-event/8000\r\n"
+
+func invite(body string) string {
+	return "INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: abc@192.0.2.1\r\n" +
+		"From: Alice <sip:alice@example.com>;tag=1\r\n" +
+		"To: <sip:bob@example.com>\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestParse(t *testing.T) {
+	msg, ok := Parse([]byte(invite(sdp)))
+	if !ok {
+		t.Fatal("INVITE not parsed")
+	}
+	if !msg.Request || msg.Method != "INVITE" || msg.CallID != "abc@192.0.2.1" || msg.FromTag != "1" || msg.CSeq != 1 {
+		t.Errorf("message = %+v", msg)
+	}
+	if msg.SDP == nil || len(msg.SDP.Media) != 1 || msg.SDP.Media[0].Port != 49170 || msg.SDP.Media[0].Codec() != "PCMU/8000" {
+		t.Errorf("SDP = %+v", msg.SDP)
+	}
+}
+
+func TestParseStream(t *testing.T) {
+	ok := "SIP/2.0 200 OK\r\nCall-ID: abc@192.0.2.1\r\nCSeq: 1 INVITE\r\nContent-Length: 0\r\n\r\n"
+	msgs := ParseStream([]byte(invite(sdp) + "\r\n" + ok))
+	if len(msgs) != 2 || msgs[1].StatusCode != 200 {
+		t.Fatalf("got %d messages, want the INVITE and its 200", len(msgs))
+	}
+}
+
+func TestParseBadContentLength(t *testing.T) {
+	head := "SIP/2.0 200 OK\r\nCall-ID: x\r\nContent-Length: "
+	for _, length := range []string{"-5", "9223372036854775807", "99999999999999999999", "x", "10"} {
+		data := []byte(head + length + "\r\n\r\nshort")
+		if msg, ok := Parse(data); ok {
+			t.Errorf("Content-Length %s: parsed %+v", length, msg)
+		}
+		if msgs := ParseStream(data); len(msgs) != 0 {
+			t.Errorf("Content-Length %s: stream gave %d messages", length, len(msgs))
+		}
+	}
+}
+
+// Every prefix of a message must parse without panicking
+func TestTruncated(t *testing.T) {
+	data := []byte(invite(sdp) + invite(""))
+	for i := 0; i <= len(data); i++ {
+		Parse(data[:i])
+		for _, msg := range ParseStream(data[:i]) {
+			if msg.Size <= 0 || msg.Offset+msg.Size > i {
+				t.Fatalf("message at %d of %d bytes outside %d", msg.Offset, msg.Size, i)
+			}
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte(invite(sdp)))
+	f.Add([]byte("SIP/2.0 180 Ringing\nl: 9223372036854775807\n\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Parse(data)
+		ParseStream(data)
+	})
+}
@@ -0,0 +1,379 @@
+// Package smb decodes SMB2 and SMB3 from reassembled TCP flows (direct TCP
+// on port 445 or NetBIOS session service on 139): commands with their tree,
+// file, status and credit fields, paired with responses by message ID.
+package smb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// Commands
+const (
+	CmdNegotiate      = 0x00
+	CmdSessionSetup   = 0x01
+	CmdLogoff         = 0x02
+	CmdTreeConnect    = 0x03
+	CmdTreeDisconnect = 0x04
+	CmdCreate         = 0x05
+	CmdClose          = 0x06
+	CmdFlush          = 0x07
+	CmdRead           = 0x08
+	CmdWrite          = 0x09
+	CmdLock           = 0x0a
+	CmdIoctl          = 0x0b
+	CmdCancel         = 0x0c
+	CmdEcho           = 0x0d
+	CmdQueryDirectory = 0x0e
+	CmdChangeNotify   = 0x0f
+	CmdQueryInfo      = 0x10
+	CmdSetInfo        = 0x11
+	CmdOplockBreak    = 0x12
+)
+
+var commandNames = []string{
+	"NEGOTIATE", "SESSION_SETUP", "LOGOFF", "TREE_CONNECT", "TREE_DISCONNECT", "CREATE", "CLOSE",
+	"FLUSH", "READ", "WRITE", "LOCK", "IOCTL", "CANCEL", "ECHO", "QUERY_DIRECTORY", "CHANGE_NOTIFY",
+	"QUERY_INFO", "SET_INFO", "OPLOCK_BREAK",
+}
+
+// CommandName returns the name of an SMB2 command
+func CommandName(cmd uint16) string {
+	if int(cmd) < len(commandNames) {
+		return commandNames[cmd]
+	}
+	return fmt.Sprintf("COMMAND_0x%02x", cmd)
+}
+
+// Header flags
+const (
+	flagResponse = 0x01
+	flagAsync    = 0x02
+	flagRelated  = 0x04
+)
+
+const (
+	headerLen   = 64
+	maxFrameLen = 16 << 20
+)
+
+// Message is one SMB2 request or response. Offsets index the flow data and
+// cover the NetBIOS frame carrying the message; compounded messages share
+// their frame.
+type Message struct {
+	Command   uint16
+	Status    uint32
+	Flags     uint32
+	MessageID uint64
+	TreeID    uint32
+	SessionID uint64
+	// CreditCharge is the credits a request consumes; Credits is the
+	// number a request asks for or a response grants
+	CreditCharge uint16
+	Credits      uint16
+
+	FileID  string // hex of the 16-byte file ID, for commands on an open file
+	Path    string // TREE_CONNECT share path or CREATE file name
+	Length  uint32 // READ/WRITE length
+	Dialect uint16 // NEGOTIATE response
+
+	Offset int
+	End    int
+	Lost   bool // framing was lost after this message
+}
+
+// IsResponse reports whether the message was sent by the server
+func (m *Message) IsResponse() bool {
+	return m.Flags&flagResponse != 0
+}
+
+// Exchange pairs a request with its final response and, for asynchronous
+// operations, the interim STATUS_PENDING response
+type Exchange struct {
+	Request  *Message
+	Interim  *Message
+	Response *Message
+	Share    string // share path of the request's tree, if it was connected in the capture
+	File     string // file name of the request's file ID, if it was opened in the capture
+}
+
+// Conversation is a decoded SMB connection
+type Conversation struct {
+	Dialect   uint16 // negotiated dialect, 0 if the negotiation was not captured
+	SMB1      bool   // the client opened with an SMB1 negotiate
+	Encrypted bool   // SMB3 transform headers: the messages cannot be read
+	Exchanges []Exchange
+	// Requests and Responses in flow order, interim responses included,
+	// for credit accounting
+	Requests  []*Message
+	Responses []*Message
+}
+
+// DialectName returns the name of an SMB2 dialect revision
+func DialectName(d uint16) string {
+	switch d {
+	case 0x0202:
+		return "SMB 2.0.2"
+	case 0x0210:
+		return "SMB 2.1"
+	case 0x0300:
+		return "SMB 3.0"
+	case 0x0302:
+		return "SMB 3.0.2"
+	case 0x0311:
+		return "SMB 3.1.1"
+	case 0x02ff:
+		return "SMB 2.???"
+	}
+	return fmt.Sprintf("0x%04x", d)
+}
+
+// IsStart reports whether data begins with a NetBIOS session message
+// carrying an SMB1, SMB2 or SMB3 transform header
+func IsStart(data []byte) bool {
+	return len(data) >= 8 && data[0] == 0 && data[5] == 'S' && data[6] == 'M' && data[7] == 'B' &&
+		(data[4] == 0xfe || data[4] == 0xff || data[4] == 0xfd)
+}
+
+// Parse decodes an SMB connection from its client and server flows
+func Parse(client, server *reassembly.Flow) *Conversation {
+	conv := &Conversation{}
+	conv.Requests = messages(client, conv)
+	conv.Responses = messages(server, conv)
+
+	trees := map[uint32]string{}
+	files := map[string]string{}
+	byID := map[uint64]int{}
+	var prevCreate string
+	for i, m := range conv.Requests {
+		if m.Command == CmdCancel {
+			continue
+		}
+		ex := Exchange{Request: m}
+		if m.Flags&flagRelated != 0 && m.FileID == relatedFileID && i > 0 {
+			// Related compound operations act on the file the previous
+			// request opened
+			ex.File = prevCreate
+		}
+		conv.Exchanges = append(conv.Exchanges, ex)
+		byID[m.MessageID] = len(conv.Exchanges) - 1
+		if m.Command == CmdCreate {
+			prevCreate = m.Path
+		}
+	}
+	for _, r := range conv.Responses {
+		i, ok := byID[r.MessageID]
+		if !ok {
+			continue
+		}
+		ex := &conv.Exchanges[i]
+		if r.Status == StatusPending && r.Flags&flagAsync != 0 {
+			ex.Interim = r
+			continue
+		}
+		if ex.Response == nil {
+			ex.Response = r
+		}
+	}
+
+	// Learn share and file names, then apply them to the exchanges
+	for i := range conv.Exchanges {
+		ex := &conv.Exchanges[i]
+		req, resp := ex.Request, ex.Response
+		if resp != nil && resp.Status == StatusSuccess {
+			switch req.Command {
+			case CmdTreeConnect:
+				trees[resp.TreeID] = req.Path
+			case CmdCreate:
+				if resp.FileID != "" {
+					files[resp.FileID] = req.Path
+				}
+			}
+		}
+		ex.Share = trees[req.TreeID]
+		switch {
+		case req.Command == CmdCreate:
+			ex.File = req.Path
+		case req.FileID != "" && req.FileID != relatedFileID:
+			if name, ok := files[req.FileID]; ok {
+				ex.File = name
+			}
+		}
+	}
+	return conv
+}
+
+// relatedFileID is the all-ones file ID that related compound requests use
+var relatedFileID = strings.Repeat("ff", 16)
+
+// messages frames the NetBIOS session messages of a flow and decodes the
+// SMB2 messages they carry. After a gap swallows a frame header, framing
+// resumes at the next TCP segment that starts an SMB message.
+func messages(flow *reassembly.Flow, conv *Conversation) []*Message {
+	var msgs []*Message
+	pos := 0
+	for pos < len(flow.Data) {
+		hdr := flow.Contiguous(pos)
+		if !IsStart(hdr) {
+			pos = resync(flow, pos+1)
+			continue
+		}
+		n := int(hdr[1])<<16 | int(hdr[2])<<8 | int(hdr[3])
+		if n < 4 || n > maxFrameLen {
+			pos = resync(flow, pos+1)
+			continue
+		}
+		end, ok := flow.Skip(pos, int64(4+n))
+		body := hdr[4:]
+		if len(body) > n {
+			body = body[:n]
+		}
+		switch body[0] {
+		case 0xfd:
+			conv.Encrypted = true
+		case 0xff:
+			conv.SMB1 = true
+		case 0xfe:
+			frame := decodeFrame(body)
+			for _, m := range frame {
+				m.Offset, m.End = pos, end
+				if m.Command == CmdNegotiate && m.IsResponse() && m.Dialect != 0x02ff {
+					conv.Dialect = m.Dialect
+				}
+			}
+			if !ok && len(frame) > 0 {
+				frame[len(frame)-1].Lost = true
+			}
+			msgs = append(msgs, frame...)
+		}
+		if !ok {
+			pos = resync(flow, end)
+			continue
+		}
+		pos = end
+	}
+	return msgs
+}
+
+func resync(flow *reassembly.Flow, from int) int {
+	for p := flow.NextPacketStart(from); p < len(flow.Data); p = flow.NextPacketStart(p + 1) {
+		if IsStart(flow.Contiguous(p)) {
+			return p
+		}
+	}
+	return len(flow.Data)
+}
+
+// decodeFrame decodes the compounded SMB2 messages of one frame, as far as
+// they were captured
+func decodeFrame(b []byte) []*Message {
+	var msgs []*Message
+	for len(b) >= headerLen && b[0] == 0xfe && string(b[1:4]) == "SMB" {
+		m := decodeHeader(b)
+		next := binary.LittleEndian.Uint32(b[20:])
+		body := b[headerLen:]
+		if next > headerLen && int(next) <= len(b) {
+			body = b[headerLen:next]
+		}
+		decodeBody(m, b, body)
+		msgs = append(msgs, m)
+		if next == 0 || int(next) >= len(b) {
+			break
+		}
+		b = b[next:]
+	}
+	return msgs
+}
+
+func decodeHeader(b []byte) *Message {
+	le := binary.LittleEndian
+	m := &Message{
+		CreditCharge: le.Uint16(b[6:]),
+		Status:       le.Uint32(b[8:]),
+		Command:      le.Uint16(b[12:]),
+		Credits:      le.Uint16(b[14:]),
+		Flags:        le.Uint32(b[16:]),
+		MessageID:    le.Uint64(b[24:]),
+		SessionID:    le.Uint64(b[40:]),
+	}
+	if m.Flags&flagAsync == 0 {
+		m.TreeID = le.Uint32(b[36:])
+	}
+	return m
+}
+
+// decodeBody reads the fields the analyzer uses. msg is the message from
+// its header on, since name offsets count from there.
+func decodeBody(m *Message, msg, body []byte) {
+	le := binary.LittleEndian
+	fileID := func(at int) {
+		if len(body) >= at+16 {
+			m.FileID = hex.EncodeToString(body[at : at+16])
+		}
+	}
+	name := func(offAt int) string {
+		if len(body) < offAt+4 {
+			return ""
+		}
+		off, n := int(le.Uint16(body[offAt:])), int(le.Uint16(body[offAt+2:]))
+		if off < headerLen || off > len(msg) {
+			return ""
+		}
+		return utf16String(msg[off:min(off+n, len(msg))])
+	}
+
+	if m.IsResponse() {
+		if m.Status != StatusSuccess {
+			return
+		}
+		switch m.Command {
+		case CmdNegotiate:
+			if len(body) >= 6 {
+				m.Dialect = le.Uint16(body[4:])
+			}
+		case CmdCreate:
+			fileID(64)
+		case CmdRead:
+			if len(body) >= 8 {
+				m.Length = le.Uint32(body[4:])
+			}
+		case CmdWrite:
+			if len(body) >= 8 {
+				m.Length = le.Uint32(body[4:])
+			}
+		}
+		return
+	}
+
+	switch m.Command {
+	case CmdTreeConnect:
+		m.Path = name(4)
+	case CmdCreate:
+		m.Path = name(44)
+	case CmdRead, CmdWrite:
+		if len(body) >= 8 {
+			m.Length = le.Uint32(body[4:])
+		}
+		fileID(16)
+	case CmdClose, CmdFlush, CmdLock, CmdIoctl, CmdQueryDirectory, CmdChangeNotify:
+		fileID(8)
+	case CmdQueryInfo:
+		fileID(24)
+	case CmdSetInfo:
+		fileID(16)
+	}
+}
+
+// utf16String decodes little-endian UTF-16
+func utf16String(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
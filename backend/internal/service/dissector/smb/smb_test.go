@@ -0,0 +1,229 @@
+package smb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"pcap-analyzer/internal/service/reassembly"
+)
+
+// flow lays segments out back to back, one chunk each
+func flow(segs ...[]byte) *reassembly.Flow {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &reassembly.Flow{}
+	for i, s := range segs {
+		f.Chunks = append(f.Chunks, reassembly.Chunk{Offset: len(f.Data), Len: len(s), Timestamp: base.Add(time.Duration(i) * time.Millisecond), PacketIndex: i})
+		f.Data = append(f.Data, s...)
+	}
+	return f
+}
+
+// message is an SMB2 header followed by body
+type message struct {
+	cmd    uint16
+	status uint32
+	flags  uint32
+	id     uint64
+	tree   uint32
+	body   []byte
+}
+
+// frame compounds messages into one NetBIOS session message, aligning
+// each to 8 bytes
+func frame(msgs ...message) []byte {
+	le := binary.LittleEndian
+	var b []byte
+	for i, m := range msgs {
+		h := make([]byte, headerLen, headerLen+len(m.body)+7)
+		copy(h, "\xfeSMB")
+		le.PutUint16(h[4:], headerLen)
+		le.PutUint32(h[8:], m.status)
+		le.PutUint16(h[12:], m.cmd)
+		le.PutUint16(h[14:], 1)
+		le.PutUint32(h[16:], m.flags)
+		le.PutUint64(h[24:], m.id)
+		le.PutUint32(h[36:], m.tree)
+		h = append(h, m.body...)
+		if i < len(msgs)-1 {
+			for len(h)%8 != 0 {
+				h = append(h, 0)
+			}
+			le.PutUint32(h[20:], uint32(len(h)))
+		}
+		b = append(b, h...)
+	}
+	n := len(b)
+	return append([]byte{0, byte(n >> 16), byte(n >> 8), byte(n)}, b...)
+}
+
+func utf16le(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// named is a request body whose name offset and length sit at offAt
+func named(size, offAt int, name string) []byte {
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint16(b[offAt:], uint16(headerLen+size))
+	binary.LittleEndian.PutUint16(b[offAt+2:], uint16(2*len(name)))
+	return append(b, utf16le(name)...)
+}
+
+func withFileID(size, at int, id byte) []byte {
+	b := make([]byte, size)
+	copy(b[at:], bytes.Repeat([]byte{id}, 16))
+	binary.LittleEndian.PutUint32(b[4:], 5) // READ/WRITE length
+	return b
+}
+
+// conversation negotiates, connects a share, opens a file and reads it in
+// one compound, then writes to it asynchronously
+func conversation() (client, server [][]byte) {
+	negotiated := make([]byte, 64)
+	binary.LittleEndian.PutUint16(negotiated[4:], 0x0311)
+	client = [][]byte{
+		frame(message{cmd: CmdNegotiate, body: make([]byte, 36)}),
+		frame(message{cmd: CmdTreeConnect, id: 1, body: named(8, 4, `\\srv\share`)}),
+		frame(
+			message{cmd: CmdCreate, id: 2, tree: 5, body: named(56, 44, "a.txt")},
+			message{cmd: CmdRead, id: 3, tree: 5, flags: flagRelated, body: withFileID(48, 16, 0xff)},
+		),
+		frame(message{cmd: CmdWrite, id: 4, tree: 5, body: withFileID(48, 16, 0x11)}),
+	}
+	server = [][]byte{
+		frame(message{cmd: CmdNegotiate, flags: flagResponse, body: negotiated}),
+		frame(message{cmd: CmdTreeConnect, flags: flagResponse, id: 1, tree: 5, body: make([]byte, 16)}),
+		frame(
+			message{cmd: CmdCreate, flags: flagResponse, id: 2, tree: 5, body: withFileID(88, 64, 0x11)},
+			message{cmd: CmdRead, flags: flagResponse | flagRelated, id: 3, tree: 5, body: withFileID(16, 0, 0)},
+		),
+		frame(message{cmd: CmdWrite, status: StatusPending, flags: flagResponse | flagAsync, id: 4, body: make([]byte, 8)}),
+		frame(message{cmd: CmdWrite, flags: flagResponse | flagAsync, id: 4, body: withFileID(16, 0, 0)}),
+	}
+	return client, server
+}
+
+func TestParse(t *testing.T) {
+	c, s := conversation()
+	if !IsStart(c[0]) {
+		t.Error("NEGOTIATE not recognized as an SMB start")
+	}
+	conv := Parse(flow(c...), flow(s...))
+	if conv.Dialect != 0x0311 || conv.SMB1 || conv.Encrypted {
+		t.Errorf("dialect %s, SMB1 %v, encrypted %v", DialectName(conv.Dialect), conv.SMB1, conv.Encrypted)
+	}
+	if len(conv.Requests) != 5 || len(conv.Responses) != 6 {
+		t.Fatalf("got %d requests and %d responses, want 5 and 6", len(conv.Requests), len(conv.Responses))
+	}
+
+	want := []struct {
+		cmd         uint16
+		share, file string
+	}{
+		{CmdNegotiate, "", ""},
+		{CmdTreeConnect, "", ""},
+		{CmdCreate, `\\srv\share`, "a.txt"},
+		{CmdRead, `\\srv\share`, "a.txt"},
+		{CmdWrite, `\\srv\share`, "a.txt"},
+	}
+	if len(conv.Exchanges) != len(want) {
+		t.Fatalf("got %d exchanges, want %d", len(conv.Exchanges), len(want))
+	}
+	for i, w := range want {
+		ex := conv.Exchanges[i]
+		if ex.Request.Command != w.cmd || ex.Share != w.share || ex.File != w.file || ex.Response == nil {
+			t.Errorf("exchange %d = %s on %q %q, want %s on %q %q", i, CommandName(ex.Request.Command), ex.Share, ex.File, CommandName(w.cmd), w.share, w.file)
+		}
+	}
+	if tc := conv.Exchanges[1].Request; tc.Path != `\\srv\share` {
+		t.Errorf("tree path = %q", tc.Path)
+	}
+	if w := conv.Exchanges[4]; w.Interim == nil || w.Response.Status != StatusSuccess || w.Request.Length != 5 {
+		t.Errorf("write = %+v", w)
+	}
+}
+
+func TestCredits(t *testing.T) {
+	req := frame(message{cmd: CmdRead, id: 7, body: withFileID(48, 16, 1)})
+	binary.LittleEndian.PutUint16(req[4+6:], 2) // credit charge
+	resp := frame(message{cmd: CmdRead, flags: flagResponse, id: 7, body: withFileID(16, 0, 0)})
+	binary.LittleEndian.PutUint16(resp[4+14:], 32) // credits granted
+
+	conv := Parse(flow(req), flow(resp))
+	if len(conv.Exchanges) != 1 || conv.Exchanges[0].Response == nil {
+		t.Fatalf("exchanges = %+v", conv.Exchanges)
+	}
+	if ex := conv.Exchanges[0]; ex.Request.CreditCharge != 2 || ex.Response.Credits != 32 {
+		t.Errorf("charge %d, granted %d, want 2 and 32", ex.Request.CreditCharge, ex.Response.Credits)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	header := func(next uint32) []byte {
+		b := frame(message{cmd: CmdCreate, body: named(56, 44, "x")})
+		binary.LittleEndian.PutUint32(b[4+20:], next)
+		return b
+	}
+	short := frame(message{cmd: CmdCreate, body: named(56, 44, "x")})
+	binary.LittleEndian.PutUint16(short[4+headerLen+46:], 0xffff) // name runs past the frame
+	tests := []struct {
+		name string
+		data []byte
+		msgs int
+	}{
+		{"next command inside header", header(8), 1},
+		{"next command past frame", header(0xffffffff), 1},
+		{"name past frame", short, 1},
+		{"frame too short", []byte{0, 0, 0, 2, 0xfe, 'S', 'M', 'B'}, 0},
+		{"smb1", []byte{0, 0, 0, 4, 0xff, 'S', 'M', 'B'}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := Parse(flow(tt.data), flow())
+			if len(conv.Requests) != tt.msgs {
+				t.Errorf("got %d requests, want %d", len(conv.Requests), tt.msgs)
+			}
+		})
+	}
+}
+
+// Every prefix of a conversation must parse without panicking
+func TestTruncated(t *testing.T) {
+	c, s := conversation()
+	client, server := bytes.Join(c, nil), bytes.Join(s, nil)
+	for i := 0; i <= len(server); i++ {
+		check(t, client[:min(i, len(client))], server[:i])
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	c, s := conversation()
+	f.Add(bytes.Join(c, nil), bytes.Join(s, nil))
+	f.Add(c[2], s[2])
+	f.Fuzz(func(t *testing.T, client, server []byte) {
+		check(t, client, server)
+	})
+}
+
+// check parses the flows whole and split in two segments
+func check(t *testing.T, client, server []byte) {
+	t.Helper()
+	flows := [][2]*reassembly.Flow{
+		{flow(client), flow(server)},
+		{flow(client[:len(client)/2], client[len(client)/2:]), flow(server[:len(server)/2], server[len(server)/2:])},
+	}
+	for _, fl := range flows {
+		conv := Parse(fl[0], fl[1])
+		for _, m := range append(conv.Requests, conv.Responses...) {
+			if m.Offset < 0 || m.End < m.Offset {
+				t.Fatalf("message at %d-%d", m.Offset, m.End)
+			}
+		}
+	}
+}
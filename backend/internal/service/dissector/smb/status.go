@@ -0,0 +1,100 @@
+package smb
+
+import "fmt"
+
+// NT status codes the analyzer treats specially
+const (
+	StatusSuccess               = 0x00000000
+	StatusPending               = 0x00000103
+	StatusNotifyCleanup         = 0x0000010b
+	StatusNotifyEnumDir         = 0x0000010c
+	StatusBufferOverflow        = 0x80000005
+	StatusNoMoreFiles           = 0x80000006
+	StatusEndOfFile             = 0xc0000011
+	StatusMoreProcessing        = 0xc0000016
+	StatusAccessDenied          = 0xc0000022
+	StatusObjectNameNotFound    = 0xc0000034
+	StatusObjectPathNotFound    = 0xc000003a
+	StatusLogonFailure          = 0xc000006d
+	StatusBadNetworkName        = 0xc00000cc
+	StatusCancelled             = 0xc0000120
+	StatusNetworkSessionExpired = 0xc000035c
+)
+
+var statusNames = map[uint32]string{
+	StatusSuccess:               "STATUS_SUCCESS",
+	StatusPending:               "STATUS_PENDING",
+	StatusNotifyCleanup:         "STATUS_NOTIFY_CLEANUP",
+	StatusNotifyEnumDir:         "STATUS_NOTIFY_ENUM_DIR",
+	StatusBufferOverflow:        "STATUS_BUFFER_OVERFLOW",
+	StatusNoMoreFiles:           "STATUS_NO_MORE_FILES",
+	0xc000000d:                  "STATUS_INVALID_PARAMETER",
+	0xc000000f:                  "STATUS_NO_SUCH_FILE",
+	0xc0000010:                  "STATUS_INVALID_DEVICE_REQUEST",
+	StatusEndOfFile:             "STATUS_END_OF_FILE",
+	StatusMoreProcessing:        "STATUS_MORE_PROCESSING_REQUIRED",
+	StatusAccessDenied:          "STATUS_ACCESS_DENIED",
+	0xc0000023:                  "STATUS_BUFFER_TOO_SMALL",
+	0xc0000033:                  "STATUS_OBJECT_NAME_INVALID",
+	StatusObjectNameNotFound:    "STATUS_OBJECT_NAME_NOT_FOUND",
+	0xc0000035:                  "STATUS_OBJECT_NAME_COLLISION",
+	StatusObjectPathNotFound:    "STATUS_OBJECT_PATH_NOT_FOUND",
+	0xc0000043:                  "STATUS_SHARING_VIOLATION",
+	0xc0000054:                  "STATUS_FILE_LOCK_CONFLICT",
+	0xc0000055:                  "STATUS_LOCK_NOT_GRANTED",
+	0xc0000061:                  "STATUS_PRIVILEGE_NOT_HELD",
+	StatusLogonFailure:          "STATUS_LOGON_FAILURE",
+	0xc000006e:                  "STATUS_ACCOUNT_RESTRICTION",
+	0xc0000071:                  "STATUS_PASSWORD_EXPIRED",
+	0xc0000072:                  "STATUS_ACCOUNT_DISABLED",
+	0xc000007f:                  "STATUS_DISK_FULL",
+	0xc000009a:                  "STATUS_INSUFFICIENT_RESOURCES",
+	0xc00000b5:                  "STATUS_IO_TIMEOUT",
+	0xc00000ba:                  "STATUS_FILE_IS_A_DIRECTORY",
+	0xc00000bb:                  "STATUS_NOT_SUPPORTED",
+	StatusBadNetworkName:        "STATUS_BAD_NETWORK_NAME",
+	0xc00000d0:                  "STATUS_REQUEST_NOT_ACCEPTED",
+	0xc0000101:                  "STATUS_DIRECTORY_NOT_EMPTY",
+	0xc0000103:                  "STATUS_NOT_A_DIRECTORY",
+	StatusCancelled:             "STATUS_CANCELLED",
+	0xc0000128:                  "STATUS_FILE_CLOSED",
+	0xc0000184:                  "STATUS_INVALID_DEVICE_STATE",
+	0xc0000203:                  "STATUS_USER_SESSION_DELETED",
+	0xc000020c:                  "STATUS_CONNECTION_DISCONNECTED",
+	0xc0000225:                  "STATUS_NOT_FOUND",
+	0xc000022a:                  "STATUS_DUPLICATE_OBJECTID",
+	StatusNetworkSessionExpired: "STATUS_NETWORK_SESSION_EXPIRED",
+	0xc000cf00:                  "STATUS_SMB_BAD_CLUSTER_DIALECT",
+	0xc0000466:                  "STATUS_SERVER_UNAVAILABLE",
+	0xc00000c9:                  "STATUS_NETWORK_NAME_DELETED",
+	0xc000019c:                  "STATUS_FS_DRIVER_REQUIRED",
+	0xc0000257:                  "STATUS_PATH_NOT_COVERED",
+}
+
+// StatusName returns the name of an NT status code
+func StatusName(status uint32) string {
+	if name, ok := statusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08x", status)
+}
+
+// IsError reports whether a final response status means the command
+// failed. Warnings that are part of normal operation (end of a directory
+// listing or file, more authentication legs, a closed change notification)
+// and name lookups that find nothing are not errors.
+func IsError(cmd uint16, status uint32) bool {
+	switch status {
+	case StatusSuccess, StatusBufferOverflow, StatusNoMoreFiles, StatusNotifyCleanup, StatusNotifyEnumDir:
+		return false
+	case StatusMoreProcessing:
+		return cmd != CmdSessionSetup
+	case StatusEndOfFile:
+		return cmd != CmdRead
+	case StatusObjectNameNotFound, StatusObjectPathNotFound:
+		return cmd != CmdCreate
+	case StatusCancelled:
+		return cmd != CmdChangeNotify
+	}
+	return status>>30 == 3 // severity bits: error
+}
@@ -0,0 +1,427 @@
+package tls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Decryption errors
+var (
+	ErrNoKey             = errors.New("no key log entry for this session")
+	ErrUnsupportedCipher = errors.New("cipher suite not supported for decryption")
+	ErrNoHandshake       = errors.New("ClientHello or ServerHello not captured")
+)
+
+// VersionTLS13 and VersionTLS12 are the wire version numbers
+const (
+	VersionTLS12 = 0x0303
+	VersionTLS13 = 0x0304
+)
+
+// handshake message types seen only in decrypted TLS 1.3 handshakes
+const handshakeEncryptedExtensions = 8
+
+// aeadSuite describes an AEAD cipher suite
+type aeadSuite struct {
+	keyLen int
+	ivLen  int // fixed IV: 4 bytes for TLS 1.2 GCM, else 12
+	chacha bool
+	sha384 bool
+}
+
+var aeadSuites = map[uint16]aeadSuite{
+	// TLS 1.3
+	0x1301: {keyLen: 16, ivLen: 12},
+	0x1302: {keyLen: 32, ivLen: 12, sha384: true},
+	0x1303: {keyLen: 32, ivLen: 12, chacha: true},
+	// TLS 1.2 AES-GCM
+	0x009c: {keyLen: 16, ivLen: 4},
+	0x009d: {keyLen: 32, ivLen: 4, sha384: true},
+	0x009e: {keyLen: 16, ivLen: 4},
+	0x009f: {keyLen: 32, ivLen: 4, sha384: true},
+	0xc02b: {keyLen: 16, ivLen: 4},
+	0xc02c: {keyLen: 32, ivLen: 4, sha384: true},
+	0xc02f: {keyLen: 16, ivLen: 4},
+	0xc030: {keyLen: 32, ivLen: 4, sha384: true},
+	// TLS 1.2 ChaCha20-Poly1305
+	0xcca8: {keyLen: 32, ivLen: 12, chacha: true},
+	0xcca9: {keyLen: 32, ivLen: 12, chacha: true},
+	0xccaa: {keyLen: 32, ivLen: 12, chacha: true},
+}
+
+func (s aeadSuite) hash() func() hash.Hash {
+	if s.sha384 {
+		return sha512.New384
+	}
+	return sha256.New
+}
+
+func (s aeadSuite) aead(key []byte) (cipher.AEAD, error) {
+	if s.chacha {
+		return chacha20poly1305.New(key)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PlainRecord maps a run of decrypted application data back to the record
+// that carried it
+type PlainRecord struct {
+	Offset int // position in Plaintext.Data
+	Len    int
+	// RecordOffset and RecordEnd delimit the ciphertext record in the flow data
+	RecordOffset int
+	RecordEnd    int
+}
+
+// Plaintext is the decrypted application data of one direction
+type Plaintext struct {
+	Data      []byte
+	Records   []PlainRecord
+	Alerts    []Alert // alerts that were encrypted on the wire
+	Decrypted int     // encrypted records opened
+	Failed    int     // encrypted records that did not authenticate
+}
+
+// Session is a decrypted TLS connection
+type Session struct {
+	Version     uint16
+	CipherSuite uint16
+	// ALPN and Certificates come from the encrypted TLS 1.3 handshake
+	ALPN           string
+	Certificates   [][]byte
+	Client, Server *Plaintext
+}
+
+// Decrypt decrypts both directions of a TLS 1.2 or 1.3 connection using
+// the secrets logged for its client random. Only AEAD suites are supported.
+func Decrypt(ch *ClientHello, sh *ServerHello, client, server []byte, keys *KeyLog) (*Session, error) {
+	if ch == nil || sh == nil {
+		return nil, ErrNoHandshake
+	}
+	secrets := keys.Lookup(ch.Random)
+	if secrets == nil {
+		return nil, ErrNoKey
+	}
+	suite, ok := aeadSuites[sh.CipherSuite]
+	if !ok {
+		return nil, ErrUnsupportedCipher
+	}
+
+	sess := &Session{Version: sh.SelectedVersion, CipherSuite: sh.CipherSuite}
+	if sh.SelectedVersion == VersionTLS13 {
+		if secrets.ClientTraffic == nil && secrets.ClientHandshake == nil {
+			return nil, ErrNoKey
+		}
+		var serverHS []byte
+		sess.Client, _ = decrypt13(client, suite, secrets.ClientHandshake, secrets.ClientTraffic)
+		sess.Server, serverHS = decrypt13(server, suite, secrets.ServerHandshake, secrets.ServerTraffic)
+		sess.ALPN, sess.Certificates = parseEncryptedHandshake(serverHS)
+		return sess, nil
+	}
+
+	if secrets.MasterSecret == nil {
+		return nil, ErrNoKey
+	}
+	// key_block = client_write_key, server_write_key, client_write_IV, server_write_IV
+	seed := append(append([]byte(nil), sh.Random...), ch.Random...)
+	block := prf12(suite.hash(), secrets.MasterSecret, "key expansion", seed, 2*suite.keyLen+2*suite.ivLen)
+	ck, block := block[:suite.keyLen], block[suite.keyLen:]
+	sk, block := block[:suite.keyLen], block[suite.keyLen:]
+	civ, siv := block[:suite.ivLen], block[suite.ivLen:]
+
+	var err error
+	if sess.Client, err = decrypt12(client, suite, ck, civ); err != nil {
+		return nil, err
+	}
+	if sess.Server, err = decrypt12(server, suite, sk, siv); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// trafficKeys is one epoch of TLS 1.3 record protection
+type trafficKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	seq  uint64
+}
+
+func newTrafficKeys(suite aeadSuite, secret []byte) *trafficKeys {
+	h := suite.hash()
+	aead, err := suite.aead(expandLabel(h, secret, "key", suite.keyLen))
+	if err != nil {
+		return nil
+	}
+	return &trafficKeys{aead: aead, iv: expandLabel(h, secret, "iv", suite.ivLen)}
+}
+
+func (k *trafficKeys) open(rec Record, header []byte) ([]byte, bool) {
+	if k == nil {
+		return nil, false
+	}
+	nonce := make([]byte, len(k.iv))
+	copy(nonce, k.iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(k.seq >> (8 * i))
+	}
+	plain, err := k.aead.Open(nil, nonce, rec.Fragment, header)
+	if err != nil {
+		return nil, false
+	}
+	k.seq++
+	return plain, true
+}
+
+// decrypt13 opens the encrypted records of one TLS 1.3 direction. Records
+// move from the handshake keys to the application keys, and on through key
+// updates; each record is tried with the current and the next epoch.
+func decrypt13(data []byte, suite aeadSuite, handshakeSecret, trafficSecret []byte) (*Plaintext, []byte) {
+	out := &Plaintext{}
+	var hs []byte
+	h := suite.hash()
+
+	appSecret := trafficSecret
+	var current, next *trafficKeys
+	if handshakeSecret != nil {
+		current = newTrafficKeys(suite, handshakeSecret)
+		if trafficSecret != nil {
+			next = newTrafficKeys(suite, trafficSecret)
+		}
+	} else if trafficSecret != nil {
+		current = newTrafficKeys(suite, trafficSecret)
+		appSecret = expandLabel(h, trafficSecret, "traffic upd", h().Size())
+		next = newTrafficKeys(suite, appSecret)
+	}
+	opened := false
+
+	for _, rec := range ParseRecords(data) {
+		if rec.Type != RecordApplicationData {
+			continue // cleartext hellos and compatibility ChangeCipherSpec
+		}
+		header := data[rec.Offset : rec.Offset+5]
+		plain, ok := current.open(rec, header)
+		if !ok && next != nil {
+			if plain, ok = next.open(rec, header); ok {
+				current = next
+				next = nil
+				if appSecret != nil {
+					appSecret = expandLabel(h, appSecret, "traffic upd", h().Size())
+					next = newTrafficKeys(suite, appSecret)
+				}
+			}
+		}
+		if !ok {
+			// Without the handshake secret, records before the first
+			// application record cannot be opened and are not failures
+			if opened || handshakeSecret != nil {
+				out.Failed++
+			}
+			continue
+		}
+		opened = true
+		out.Decrypted++
+
+		// Strip padding; the last non-zero byte is the real content type
+		i := len(plain) - 1
+		for i >= 0 && plain[i] == 0 {
+			i--
+		}
+		if i < 0 {
+			continue
+		}
+		content, typ := plain[:i], plain[i]
+		switch typ {
+		case RecordHandshake:
+			hs = append(hs, content...)
+		case RecordAlert:
+			if len(content) == 2 {
+				out.Alerts = append(out.Alerts, Alert{Level: content[0], Description: content[1], Offset: rec.Offset})
+			}
+		case RecordApplicationData:
+			out.add(content, rec)
+		}
+	}
+	return out, hs
+}
+
+// decrypt12 opens the records one TLS 1.2 direction sent after its
+// ChangeCipherSpec
+func decrypt12(data []byte, suite aeadSuite, key, iv []byte) (*Plaintext, error) {
+	aead, err := suite.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	out := &Plaintext{}
+	encrypted := false
+	var seq uint64
+	for _, rec := range ParseRecords(data) {
+		if rec.Type == RecordChangeCipherSpec {
+			encrypted, seq = true, 0
+			continue
+		}
+		if !encrypted {
+			continue
+		}
+
+		var nonce, ciphertext []byte
+		if suite.chacha {
+			nonce = make([]byte, len(iv))
+			copy(nonce, iv)
+			for i := 0; i < 8; i++ {
+				nonce[len(nonce)-1-i] ^= byte(seq >> (8 * i))
+			}
+			ciphertext = rec.Fragment
+		} else {
+			if len(rec.Fragment) < 8 {
+				out.Failed++
+				seq++
+				continue
+			}
+			nonce = append(append([]byte(nil), iv...), rec.Fragment[:8]...)
+			ciphertext = rec.Fragment[8:]
+		}
+		plainLen := len(ciphertext) - aead.Overhead()
+		if plainLen < 0 {
+			out.Failed++
+			seq++
+			continue
+		}
+		var aad [13]byte
+		binary.BigEndian.PutUint64(aad[:], seq)
+		aad[8] = rec.Type
+		binary.BigEndian.PutUint16(aad[9:], rec.Version)
+		binary.BigEndian.PutUint16(aad[11:], uint16(plainLen))
+		seq++
+
+		plain, err := aead.Open(nil, nonce, ciphertext, aad[:])
+		if err != nil {
+			out.Failed++
+			continue
+		}
+		out.Decrypted++
+		switch rec.Type {
+		case RecordAlert:
+			if len(plain) == 2 {
+				out.Alerts = append(out.Alerts, Alert{Level: plain[0], Description: plain[1], Offset: rec.Offset})
+			}
+		case RecordApplicationData:
+			out.add(plain, rec)
+		}
+	}
+	return out, nil
+}
+
+func (p *Plaintext) add(plain []byte, rec Record) {
+	if len(plain) == 0 {
+		return
+	}
+	p.Records = append(p.Records, PlainRecord{
+		Offset:       len(p.Data),
+		Len:          len(plain),
+		RecordOffset: rec.Offset,
+		RecordEnd:    rec.Offset + 5 + len(rec.Fragment),
+	})
+	p.Data = append(p.Data, plain...)
+}
+
+// parseEncryptedHandshake reads the ALPN from EncryptedExtensions and the
+// chain from a TLS 1.3 Certificate message
+func parseEncryptedHandshake(hs []byte) (alpn string, certs [][]byte) {
+	for len(hs) >= 4 {
+		typ := hs[0]
+		n := int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3])
+		if 4+n > len(hs) {
+			break
+		}
+		body := hs[4 : 4+n]
+		hs = hs[4+n:]
+
+		switch typ {
+		case handshakeEncryptedExtensions:
+			r := &reader{b: body, ok: true}
+			exts := &reader{b: r.vec16(), ok: r.ok}
+			for exts.ok && len(exts.b) >= 4 {
+				extType := exts.u16()
+				data := exts.vec16()
+				if exts.ok && extType == ExtALPN {
+					er := &reader{b: data, ok: true}
+					list := &reader{b: er.vec16(), ok: er.ok}
+					if proto := list.vec8(); list.ok {
+						alpn = string(proto)
+					}
+				}
+			}
+		case HandshakeCertificate:
+			r := &reader{b: body, ok: true}
+			r.vec8() // certificate_request_context
+			if len(r.b) < 3 || !r.ok {
+				continue
+			}
+			list := r.b[3:]
+			for len(list) >= 3 {
+				c := int(list[0])<<16 | int(list[1])<<8 | int(list[2])
+				if 3+c+2 > len(list) {
+					break
+				}
+				certs = append(certs, list[3:3+c])
+				list = list[3+c:]
+				ext := int(binary.BigEndian.Uint16(list))
+				if 2+ext > len(list) {
+					break
+				}
+				list = list[2+ext:]
+			}
+		}
+	}
+	return alpn, certs
+}
+
+// expandLabel is HKDF-Expand-Label (RFC 8446 section 7.1) with an empty context
+func expandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	full := "tls13 " + label
+	info := make([]byte, 0, 4+len(full))
+	info = append(info, byte(length>>8), byte(length), byte(len(full)))
+	info = append(info, full...)
+	info = append(info, 0)
+
+	var out, prev []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac := hmac.New(h, secret)
+		mac.Write(prev)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		prev = mac.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+// prf12 is the TLS 1.2 PRF (RFC 5246 section 5)
+func prf12(h func() hash.Hash, secret []byte, label string, seed []byte, length int) []byte {
+	labelSeed := append([]byte(label), seed...)
+	var out []byte
+	a := labelSeed
+	for len(out) < length {
+		mac := hmac.New(h, secret)
+		mac.Write(a)
+		a = mac.Sum(nil)
+
+		mac = hmac.New(h, secret)
+		mac.Write(a)
+		mac.Write(labelSeed)
+		out = append(out, mac.Sum(nil)...)
+	}
+	return out[:length]
+}
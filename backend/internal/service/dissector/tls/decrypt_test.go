@@ -0,0 +1,301 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	stdtls "crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// unhex decodes hex written with spaces, as in the RFC traces
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Secrets and records of the simple 1-RTT handshake in RFC 8448 section 3
+var (
+	rfc8448ServerHandshake = unhex("b6 7b 7d 69 0c c1 6c 4e 75 e5 42 13 cb 2d 37 b4 e9 c9 12 bc de d9 10 5d 42 be fd 59 d3 91 ad 38")
+	rfc8448ClientHandshake = unhex("b3 ed db 12 6e 06 7f 35 a7 80 b3 ab f4 5e 2d 8f 3b 1a 95 07 38 f5 2e 96 00 74 6a 0e 27 a5 5a 21")
+	rfc8448ClientTraffic   = unhex("9e 40 64 6c e7 9a 7f 9d c0 5a f8 88 9b ce 65 52 87 5a fa 0b 06 df 00 87 f7 92 eb b7 c1 75 04 a5")
+	// The client's Finished, under the client handshake keys
+	rfc8448ClientFinished = unhex(`17 03 03 00 35 75 ec 4d c2 38 cc e6 0b 29 80 44 a7 1e 21 9c 56 cc 77 b0 51
+		7f e9 b9 3c 7a 4b fc 44 d8 7f 38 f8 03 38 ac 98 fc 46 de b3 84 bd 1c ae ac ab 68 67 d7 26 c4 05 46`)
+	// Fifty bytes 00..31 of application data, under the client traffic keys
+	rfc8448ClientData = unhex(`17 03 03 00 43 a2 3f 70 54 b6 2c 94 d0 af fa fe 82 28 ba 55 cb ef ac ea 42
+		f9 14 aa 66 bc ab 3f 2b 98 19 a8 a5 b4 6b 39 5b d5 4a 9a 20 44 1e 2b 62 97 4e 1f 5a 62 92 a2 97 70 14
+		bd 1e 3d ea e6 3a ee bb 21 69 49 15 e4`)
+)
+
+func TestExpandLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  []byte
+		key, iv string
+	}{
+		{"server handshake", rfc8448ServerHandshake, "3f ce 51 60 09 c2 17 27 d0 f2 e4 e8 6e e4 03 bc", "5d 31 3e b2 67 12 76 ee 13 00 0b 30"},
+		{"client handshake", rfc8448ClientHandshake, "db fa a6 93 d1 76 2c 5b 66 6a f5 d9 50 25 8d 01", "5b d3 c7 1b 83 6e 0b 76 bb 73 26 5f"},
+		{"client traffic", rfc8448ClientTraffic, "17 42 2d da 59 6e d5 d9 ac d8 90 e3 c6 3f 50 51", "5b 78 92 3d ee 08 57 90 33 e5 23 d9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandLabel(sha256.New, tt.secret, "key", 16); !bytes.Equal(got, unhex(tt.key)) {
+				t.Errorf("key = %x, want %x", got, unhex(tt.key))
+			}
+			if got := expandLabel(sha256.New, tt.secret, "iv", 12); !bytes.Equal(got, unhex(tt.iv)) {
+				t.Errorf("iv = %x, want %x", got, unhex(tt.iv))
+			}
+		})
+	}
+}
+
+// The TLS 1.2 PRF test vectors published with RFC 5246's errata
+func TestPRF12(t *testing.T) {
+	sha256Out := unhex(`e3f229ba727be17b8d122620557cd453c2aab21d07c3d495329b52d4e61edb5a6b301791e90d35c9c9a46b4e14baf9af
+		0fa022f7077def17abfd3797c0564bab4fbc91666e9def9b97fce34f796789baa48082d122ee42c5a72e5a5110fff70187347b66`)
+	if got := prf12(sha256.New, unhex("9bbe436ba940f017b17652849a71db35"), "test label", unhex("a0ba9f936cda311827a6f796ffd5198c"), 100); !bytes.Equal(got, sha256Out) {
+		t.Errorf("SHA-256 PRF = %x", got)
+	}
+	sha384Out := unhex(`7b0c18e9ced410ed1804f2cfa34a336a1c14dffb4900bb5fd7942107e81c83cde9ca0faa60be9fe34f82b1233c9146a0
+		e534cb400fed2700884f9dc236f80edd8bfa961144c9e8d792eca722a7b32fc3d416d473ebc2c5fd4abfdad05d9184259b5bf8cd
+		4d90fa0d31e2dec479e4f1a26066f2eea9a69236a3e52655c9e9aee691c8f3a26854308d5eaa3be85e0990703d73e56f`)
+	if got := prf12(sha512.New384, unhex("b80b733d6ceefcdc71566ea48e5567df"), "test label", unhex("cd665cf6a8447dd6ff8b27555edb7465"), 148); !bytes.Equal(got, sha384Out) {
+		t.Errorf("SHA-384 PRF = %x", got)
+	}
+}
+
+func TestDecrypt13RFC8448(t *testing.T) {
+	data := append(append([]byte(nil), rfc8448ClientFinished...), rfc8448ClientData...)
+	want := make([]byte, 50)
+	for i := range want {
+		want[i] = byte(i)
+	}
+
+	p, hs := decrypt13(data, aeadSuites[0x1301], rfc8448ClientHandshake, rfc8448ClientTraffic)
+	if p.Decrypted != 2 || p.Failed != 0 {
+		t.Fatalf("decrypted %d, failed %d records, want 2 and 0", p.Decrypted, p.Failed)
+	}
+	if !bytes.Equal(p.Data, want) {
+		t.Errorf("application data = %x, want %x", p.Data, want)
+	}
+	if len(hs) != 36 || hs[0] != 20 {
+		t.Errorf("handshake = %x, want a 32 byte Finished", hs)
+	}
+	if r := p.Records; len(r) != 1 || r[0].RecordOffset != len(rfc8448ClientFinished) || r[0].RecordEnd != len(data) {
+		t.Errorf("records = %+v", r)
+	}
+
+	// Without the handshake secret the Finished can't be opened, which is
+	// not a failure; the application record still is
+	p, _ = decrypt13(data, aeadSuites[0x1301], nil, rfc8448ClientTraffic)
+	if p.Decrypted != 1 || p.Failed != 0 || !bytes.Equal(p.Data, want) {
+		t.Errorf("traffic secret only: decrypted %d, failed %d, data %x", p.Decrypted, p.Failed, p.Data)
+	}
+
+	// A flipped bit fails authentication
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-1] ^= 1
+	if p, _ = decrypt13(tampered, aeadSuites[0x1301], rfc8448ClientHandshake, rfc8448ClientTraffic); p.Decrypted != 1 || p.Failed != 1 {
+		t.Errorf("tampered: decrypted %d, failed %d, want 1 and 1", p.Decrypted, p.Failed)
+	}
+}
+
+// seal13 encrypts TLS 1.3 records under secret, starting at sequence 0
+func seal13(t *testing.T, secret []byte, contents ...[]byte) []byte {
+	t.Helper()
+	label := func(name string, n int) []byte {
+		full := "tls13 " + name
+		info := append([]byte{byte(n >> 8), byte(n), byte(len(full))}, full...)
+		info = append(info, 0)
+		out := make([]byte, n)
+		if _, err := hkdf.Expand(sha256.New, secret, info).Read(out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	block, err := aes.NewCipher(label("key", 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := label("iv", 12)
+
+	var out []byte
+	for seq, content := range contents {
+		nonce := append([]byte(nil), iv...)
+		for i := 0; i < 8; i++ {
+			nonce[11-i] ^= byte(uint64(seq) >> (8 * i))
+		}
+		inner := append(append([]byte(nil), content...), RecordApplicationData)
+		header := []byte{RecordApplicationData, 3, 3, 0, 0}
+		binary.BigEndian.PutUint16(header[3:], uint16(len(inner)+aead.Overhead()))
+		out = append(out, header...)
+		out = aead.Seal(out, nonce, inner, header)
+	}
+	return out
+}
+
+// nextSecret is the RFC 8446 section 7.2 key update, computed with HKDF
+// directly rather than with expandLabel
+func nextSecret(t *testing.T, secret []byte) []byte {
+	t.Helper()
+	full := "tls13 traffic upd"
+	info := append([]byte{0, 32, byte(len(full))}, full...)
+	info = append(info, 0)
+	out := make([]byte, 32)
+	if _, err := hkdf.Expand(sha256.New, secret, info).Read(out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestDecrypt13KeyUpdate(t *testing.T) {
+	secret0 := rfc8448ClientTraffic
+	secret1 := nextSecret(t, secret0)
+	secret2 := nextSecret(t, secret1)
+	var data []byte
+	data = append(data, seal13(t, secret0, []byte("a"), []byte("b"))...)
+	data = append(data, seal13(t, secret1, []byte("c"))...)
+	data = append(data, seal13(t, secret2, []byte("d"), []byte("e"))...)
+
+	p, _ := decrypt13(data, aeadSuites[0x1301], nil, secret0)
+	if p.Decrypted != 5 || p.Failed != 0 || string(p.Data) != "abcde" {
+		t.Errorf("decrypted %d, failed %d, data %q; want 5, 0 and abcde", p.Decrypted, p.Failed, p.Data)
+	}
+
+	// Starting from the handshake keys, the first application record moves
+	// to the logged traffic secret and later ones through its updates
+	hs := seal13(t, rfc8448ClientHandshake, []byte("hs"))
+	p, _ = decrypt13(append(hs, data...), aeadSuites[0x1301], rfc8448ClientHandshake, secret0)
+	if p.Decrypted != 6 || p.Failed != 0 || string(p.Data) != "hsabcde" {
+		t.Errorf("from the handshake: decrypted %d, failed %d, data %q", p.Decrypted, p.Failed, p.Data)
+	}
+}
+
+// Connections between crypto/tls endpoints, decrypted with the key log
+// they wrote
+func TestDecryptLoopback(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint16
+		suite   uint16
+	}{
+		{"TLS 1.2 AES-128-GCM", stdtls.VersionTLS12, stdtls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		{"TLS 1.2 AES-256-GCM", stdtls.VersionTLS12, stdtls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		{"TLS 1.2 ChaCha20-Poly1305", stdtls.VersionTLS12, stdtls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		{"TLS 1.3", stdtls.VersionTLS13, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keyLog bytes.Buffer
+			cli := &stdtls.Config{ServerName: "example.com", InsecureSkipVerify: true, KeyLogWriter: &keyLog, NextProtos: []string{"http/1.1"}}
+			srv := &stdtls.Config{
+				Certificates: []stdtls.Certificate{certificate(t)},
+				MinVersion:   tt.version,
+				MaxVersion:   tt.version,
+				NextProtos:   []string{"http/1.1"},
+			}
+			if tt.suite != 0 {
+				srv.CipherSuites = []uint16{tt.suite}
+			}
+			client, server := connect(t, cli, srv, "first", "second")
+
+			keys, err := ParseKeyLog(&keyLog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch, sh := ParseSide(client).ClientHello, ParseSide(server).ServerHello
+			sess, err := Decrypt(ch, sh, client, server, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sess.Version != tt.version || (tt.suite != 0 && sess.CipherSuite != tt.suite) {
+				t.Errorf("session = version %x suite %x", sess.Version, sess.CipherSuite)
+			}
+			if got := string(sess.Client.Data); got != "firstsecond" {
+				t.Errorf("client plaintext = %q", got)
+			}
+			if got := string(sess.Server.Data); got != "echo: firstecho: second" {
+				t.Errorf("server plaintext = %q", got)
+			}
+			if sess.Client.Failed+sess.Server.Failed != 0 {
+				t.Errorf("%d client and %d server records failed", sess.Client.Failed, sess.Server.Failed)
+			}
+			if tt.version == stdtls.VersionTLS13 && (sess.ALPN != "http/1.1" || len(sess.Certificates) != 1) {
+				t.Errorf("encrypted handshake: ALPN %q, %d certificates", sess.ALPN, len(sess.Certificates))
+			}
+
+			// Secrets of another connection don't authenticate anything
+			other := NewKeyLog()
+			var otherLog bytes.Buffer
+			cli.KeyLogWriter = &otherLog
+			connect(t, cli, srv, "x")
+			other.Read(strings.NewReader(replaceRandom(otherLog.String(), ch.Random)))
+			sess, err = Decrypt(ch, sh, client, server, other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sess.Client.Decrypted+sess.Server.Decrypted != 0 || sess.Client.Failed+sess.Server.Failed == 0 {
+				t.Errorf("wrong keys: decrypted %d, failed %d",
+					sess.Client.Decrypted+sess.Server.Decrypted, sess.Client.Failed+sess.Server.Failed)
+			}
+
+			if _, err := Decrypt(ch, sh, client, server, NewKeyLog()); err != ErrNoKey {
+				t.Errorf("empty key log: err = %v, want ErrNoKey", err)
+			}
+		})
+	}
+}
+
+// replaceRandom rewrites every client random in a key log to random
+func replaceRandom(log string, random []byte) string {
+	var out []string
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		f := strings.Fields(line)
+		f[1] = hex.EncodeToString(random)
+		out = append(out, strings.Join(f, " "))
+	}
+	return strings.Join(out, "\n")
+}
+
+func TestDecryptErrors(t *testing.T) {
+	random := bytes.Repeat([]byte{7}, 32)
+	keys := NewKeyLog()
+	keys.Add([]byte("CLIENT_RANDOM " + hex.EncodeToString(random) + " " + strings.Repeat("00", 48)))
+	ch := &ClientHello{Random: random}
+
+	tests := []struct {
+		name string
+		ch   *ClientHello
+		sh   *ServerHello
+		keys *KeyLog
+		err  error
+	}{
+		{"no ServerHello", ch, nil, keys, ErrNoHandshake},
+		{"no key", &ClientHello{Random: make([]byte, 32)}, &ServerHello{CipherSuite: 0xc02f, SelectedVersion: VersionTLS12}, keys, ErrNoKey},
+		{"CBC suite", ch, &ServerHello{CipherSuite: 0xc013, SelectedVersion: VersionTLS12}, keys, ErrUnsupportedCipher},
+		{"TLS 1.3 without its secrets", ch, &ServerHello{CipherSuite: 0x1301, SelectedVersion: VersionTLS13}, keys, ErrNoKey},
+		{"nil key log", ch, &ServerHello{CipherSuite: 0xc02f, SelectedVersion: VersionTLS12}, nil, ErrNoKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.ch, tt.sh, nil, nil, tt.keys); err != tt.err {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package tls
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JA3 returns the JA3 string and its MD5 hash for a ClientHello.
+// GREASE values are dropped as in the reference implementation.
+func JA3(ch *ClientHello) (string, string) {
+	join := func(vals []uint16) string {
+		parts := make([]string, 0, len(vals))
+		for _, v := range vals {
+			if !IsGREASE(v) {
+				parts = append(parts, strconv.Itoa(int(v)))
+			}
+		}
+		return strings.Join(parts, "-")
+	}
+
+	formats := make([]string, 0, len(ch.PointFormats))
+	for _, f := range ch.PointFormats {
+		formats = append(formats, strconv.Itoa(int(f)))
+	}
+
+	s := fmt.Sprintf("%d,%s,%s,%s,%s", ch.Version, join(ch.CipherSuites), join(ch.Extensions),
+		join(ch.SupportedGroups), strings.Join(formats, "-"))
+	sum := md5.Sum([]byte(s))
+	return s, hex.EncodeToString(sum[:])
+}
+
+// JA4 returns the JA4 fingerprint of a ClientHello. quic selects the "q"
+// transport prefix for hellos carried in QUIC Initial packets.
+func JA4(ch *ClientHello, quic bool) string {
+	transport := "t"
+	if quic {
+		transport = "q"
+	}
+
+	sni := "i"
+	if ch.ServerName != "" {
+		sni = "d"
+	}
+
+	var ciphers, exts []string
+	for _, c := range ch.CipherSuites {
+		if !IsGREASE(c) {
+			ciphers = append(ciphers, fmt.Sprintf("%04x", c))
+		}
+	}
+	extCount := 0
+	for _, e := range ch.Extensions {
+		if IsGREASE(e) {
+			continue
+		}
+		extCount++
+		if e != ExtServerName && e != ExtALPN {
+			exts = append(exts, fmt.Sprintf("%04x", e))
+		}
+	}
+
+	alpn := "00"
+	if len(ch.ALPN) > 0 && ch.ALPN[0] != "" {
+		first := ch.ALPN[0]
+		alpn = string(first[0]) + string(first[len(first)-1])
+	}
+
+	a := fmt.Sprintf("%s%s%s%02d%02d%s", transport, ja4Version(ch.MaxVersion()), sni,
+		min(len(ciphers), 99), min(extCount, 99), alpn)
+
+	sort.Strings(ciphers)
+	sort.Strings(exts)
+
+	c := strings.Join(exts, ",")
+	var sigs []string
+	for _, s := range ch.SignatureAlgs {
+		if !IsGREASE(s) {
+			sigs = append(sigs, fmt.Sprintf("%04x", s))
+		}
+	}
+	if len(sigs) > 0 {
+		c += "_" + strings.Join(sigs, ",")
+	}
+
+	return a + "_" + ja4Hash(ciphers, strings.Join(ciphers, ",")) + "_" + ja4Hash(exts, c)
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+// ja4Hash is the truncated SHA-256 used by JA4, all zeros for an empty list
+func ja4Hash(list []string, s string) string {
+	if len(list) == 0 {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,190 @@
+package tls
+
+import (
+	"encoding/binary"
+)
+
+// ClientHello holds the fields needed for SNI/ALPN reporting and fingerprinting
+type ClientHello struct {
+	Version           uint16 // legacy_version
+	Random            []byte
+	CipherSuites      []uint16
+	Extensions        []uint16 // in wire order
+	ServerName        string
+	ALPN              []string
+	SupportedVersions []uint16
+	SupportedGroups   []uint16
+	PointFormats      []uint8
+	SignatureAlgs     []uint16
+}
+
+// ServerHello holds the negotiated parameters
+type ServerHello struct {
+	Version     uint16 // legacy_version
+	Random      []byte
+	CipherSuite uint16
+	Extensions  []uint16
+	// SelectedVersion comes from supported_versions (TLS 1.3), else Version
+	SelectedVersion uint16
+	ALPN            string
+}
+
+// MaxVersion returns the highest version the client offered
+func (ch *ClientHello) MaxVersion() uint16 {
+	max := ch.Version
+	for _, v := range ch.SupportedVersions {
+		if !IsGREASE(v) && v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// reader is a bounds-checked big-endian cursor
+type reader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *reader) u8() uint8 {
+	if len(r.b) < 1 {
+		r.ok = false
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) u16() uint16 {
+	if len(r.b) < 2 {
+		r.ok = false
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || len(r.b) < n {
+		r.ok = false
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) vec8() []byte  { return r.bytes(int(r.u8())) }
+func (r *reader) vec16() []byte { return r.bytes(int(r.u16())) }
+
+func u16List(b []byte) []uint16 {
+	out := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		out = append(out, binary.BigEndian.Uint16(b[i:]))
+	}
+	return out
+}
+
+func parseClientHello(body []byte) (*ClientHello, bool) {
+	r := &reader{b: body, ok: true}
+	ch := &ClientHello{}
+
+	ch.Version = r.u16()
+	ch.Random = r.bytes(32)
+	r.vec8() // session id
+	ch.CipherSuites = u16List(r.vec16())
+	r.vec8() // compression methods
+	if !r.ok {
+		return nil, false
+	}
+	if len(r.b) == 0 {
+		return ch, true // no extensions (SSLv3-era hello)
+	}
+
+	exts := &reader{b: r.vec16(), ok: r.ok}
+	for exts.ok && len(exts.b) >= 4 {
+		typ := exts.u16()
+		data := exts.vec16()
+		if !exts.ok {
+			break
+		}
+		ch.Extensions = append(ch.Extensions, typ)
+		parseClientExtension(ch, typ, data)
+	}
+	return ch, true
+}
+
+func parseClientExtension(ch *ClientHello, typ uint16, data []byte) {
+	r := &reader{b: data, ok: true}
+	switch typ {
+	case ExtServerName:
+		list := &reader{b: r.vec16(), ok: r.ok}
+		for list.ok && len(list.b) > 0 {
+			nameType := list.u8()
+			name := list.vec16()
+			if list.ok && nameType == 0 {
+				ch.ServerName = string(name)
+				return
+			}
+		}
+	case ExtALPN:
+		list := &reader{b: r.vec16(), ok: r.ok}
+		for list.ok && len(list.b) > 0 {
+			proto := list.vec8()
+			if list.ok {
+				ch.ALPN = append(ch.ALPN, string(proto))
+			}
+		}
+	case ExtSupportedVersions:
+		ch.SupportedVersions = u16List(r.vec8())
+	case ExtSupportedGroups:
+		ch.SupportedGroups = u16List(r.vec16())
+	case ExtECPointFormats:
+		ch.PointFormats = append([]uint8(nil), r.vec8()...)
+	case ExtSignatureAlgorithms:
+		ch.SignatureAlgs = u16List(r.vec16())
+	}
+}
+
+func parseServerHello(body []byte) (*ServerHello, bool) {
+	r := &reader{b: body, ok: true}
+	sh := &ServerHello{}
+
+	sh.Version = r.u16()
+	sh.Random = r.bytes(32)
+	r.vec8() // session id
+	sh.CipherSuite = r.u16()
+	r.u8() // compression method
+	if !r.ok {
+		return nil, false
+	}
+	sh.SelectedVersion = sh.Version
+
+	if len(r.b) == 0 {
+		return sh, true
+	}
+	exts := &reader{b: r.vec16(), ok: r.ok}
+	for exts.ok && len(exts.b) >= 4 {
+		typ := exts.u16()
+		data := exts.vec16()
+		if !exts.ok {
+			break
+		}
+		sh.Extensions = append(sh.Extensions, typ)
+		switch typ {
+		case ExtSupportedVersions:
+			if len(data) == 2 {
+				sh.SelectedVersion = binary.BigEndian.Uint16(data)
+			}
+		case ExtALPN:
+			er := &reader{b: data, ok: true}
+			list := &reader{b: er.vec16(), ok: er.ok}
+			if proto := list.vec8(); list.ok {
+				sh.ALPN = string(proto)
+			}
+		}
+	}
+	return sh, true
+}
@@ -0,0 +1,100 @@
+package tls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// Secrets are the keys logged for one session, keyed by its client random
+type Secrets struct {
+	MasterSecret    []byte // TLS 1.2 and earlier
+	ClientHandshake []byte // TLS 1.3
+	ServerHandshake []byte
+	ClientTraffic   []byte
+	ServerTraffic   []byte
+}
+
+// KeyLog holds session secrets in the NSS key log format written by
+// browsers and TLS libraries when SSLKEYLOGFILE is set
+type KeyLog struct {
+	sessions map[string]*Secrets
+}
+
+// NewKeyLog returns an empty key log
+func NewKeyLog() *KeyLog {
+	return &KeyLog{sessions: map[string]*Secrets{}}
+}
+
+// ParseKeyLog reads an NSS key log. Comments, unknown labels and malformed
+// lines are skipped.
+func ParseKeyLog(r io.Reader) (*KeyLog, error) {
+	k := NewKeyLog()
+	return k, k.Read(r)
+}
+
+// Read adds the entries of an NSS key log to k
+func (k *KeyLog) Read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		k.addLine(sc.Text())
+	}
+	return sc.Err()
+}
+
+// Add adds key log text, such as the contents of a pcapng Decryption
+// Secrets Block
+func (k *KeyLog) Add(data []byte) {
+	k.Read(bytes.NewReader(data))
+}
+
+func (k *KeyLog) addLine(line string) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+		return
+	}
+	random, err1 := hex.DecodeString(fields[1])
+	secret, err2 := hex.DecodeString(fields[2])
+	if err1 != nil || err2 != nil || len(random) != 32 {
+		return
+	}
+
+	s := k.sessions[string(random)]
+	if s == nil {
+		s = &Secrets{}
+	}
+	switch fields[0] {
+	case "CLIENT_RANDOM":
+		s.MasterSecret = secret
+	case "CLIENT_HANDSHAKE_TRAFFIC_SECRET":
+		s.ClientHandshake = secret
+	case "SERVER_HANDSHAKE_TRAFFIC_SECRET":
+		s.ServerHandshake = secret
+	case "CLIENT_TRAFFIC_SECRET_0":
+		s.ClientTraffic = secret
+	case "SERVER_TRAFFIC_SECRET_0":
+		s.ServerTraffic = secret
+	default:
+		return
+	}
+	k.sessions[string(random)] = s
+}
+
+// Len returns the number of sessions with at least one secret
+func (k *KeyLog) Len() int {
+	if k == nil {
+		return 0
+	}
+	return len(k.sessions)
+}
+
+// Lookup returns the secrets logged for a client random, or nil
+func (k *KeyLog) Lookup(clientRandom []byte) *Secrets {
+	if k == nil {
+		return nil
+	}
+	return k.sessions[string(clientRandom)]
+}
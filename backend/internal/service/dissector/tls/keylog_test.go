@@ -0,0 +1,68 @@
+package tls
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseKeyLog(t *testing.T) {
+	r1 := strings.Repeat("01", 32)
+	r2 := strings.Repeat("02", 32)
+	log := strings.Join([]string{
+		"# SSL/TLS secrets log file, generated by NSS",
+		"CLIENT_RANDOM " + r1 + " " + strings.Repeat("aa", 48),
+		"CLIENT_HANDSHAKE_TRAFFIC_SECRET " + r2 + " " + strings.Repeat("b1", 32),
+		"SERVER_HANDSHAKE_TRAFFIC_SECRET " + r2 + " " + strings.Repeat("b2", 32),
+		"CLIENT_TRAFFIC_SECRET_0 " + r2 + " " + strings.Repeat("b3", 32),
+		"SERVER_TRAFFIC_SECRET_0 " + r2 + " " + strings.Repeat("b4", 32),
+		"EXPORTER_SECRET " + strings.Repeat("03", 32) + " " + strings.Repeat("cc", 32),
+		"CLIENT_RANDOM " + strings.Repeat("04", 31) + " " + strings.Repeat("dd", 48),
+		"CLIENT_RANDOM " + strings.Repeat("zz", 32) + " " + strings.Repeat("dd", 48),
+		"CLIENT_RANDOM " + strings.Repeat("05", 32),
+		"",
+	}, "\n")
+
+	keys, err := ParseKeyLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Len() != 2 {
+		t.Fatalf("got %d sessions, want 2", keys.Len())
+	}
+
+	s := keys.Lookup(bytes.Repeat([]byte{1}, 32))
+	if s == nil || !bytes.Equal(s.MasterSecret, bytes.Repeat([]byte{0xaa}, 48)) || s.ClientTraffic != nil {
+		t.Errorf("TLS 1.2 secrets = %+v", s)
+	}
+	s = keys.Lookup(bytes.Repeat([]byte{2}, 32))
+	if s == nil || s.MasterSecret != nil {
+		t.Fatalf("TLS 1.3 secrets = %+v", s)
+	}
+	for i, secret := range [][]byte{s.ClientHandshake, s.ServerHandshake, s.ClientTraffic, s.ServerTraffic} {
+		if !bytes.Equal(secret, bytes.Repeat([]byte{0xb1 + byte(i)}, 32)) {
+			t.Errorf("secret %d = %x", i, secret)
+		}
+	}
+	if keys.Lookup(bytes.Repeat([]byte{3}, 32)) != nil {
+		t.Error("a session was added for an unknown label")
+	}
+}
+
+func TestKeyLogAdd(t *testing.T) {
+	random := bytes.Repeat([]byte{9}, 32)
+	r := strings.Repeat("09", 32)
+	keys := NewKeyLog()
+	// Secrets for one session may come from several blocks
+	keys.Add([]byte("CLIENT_TRAFFIC_SECRET_0 " + r + " " + strings.Repeat("11", 32) + "\n"))
+	keys.Add([]byte("SERVER_TRAFFIC_SECRET_0 " + r + " " + strings.Repeat("22", 32)))
+	s := keys.Lookup(random)
+	if keys.Len() != 1 || s == nil || s.ClientTraffic == nil || s.ServerTraffic == nil {
+		t.Errorf("merged secrets = %+v", s)
+	}
+
+	var nilLog *KeyLog
+	if nilLog.Len() != 0 || nilLog.Lookup(random) != nil {
+		t.Error("a nil key log has entries")
+	}
+}
@@ -0,0 +1,203 @@
+// Package tls decodes the cleartext part of TLS connections: the record
+// layer, ClientHello, ServerHello, Certificate (TLS 1.2 and earlier) and
+// Alert messages. Records and handshake messages are reassembled across
+// TCP segments and TLS records.
+package tls
+
+import (
+	"encoding/binary"
+)
+
+// Record content types
+const (
+	RecordChangeCipherSpec = 20
+	RecordAlert            = 21
+	RecordHandshake        = 22
+	RecordApplicationData  = 23
+)
+
+// Handshake message types
+const (
+	HandshakeClientHello = 1
+	HandshakeServerHello = 2
+	HandshakeCertificate = 11
+)
+
+// Extension types used here
+const (
+	ExtServerName          = 0x0000
+	ExtSupportedGroups     = 0x000a
+	ExtECPointFormats      = 0x000b
+	ExtSignatureAlgorithms = 0x000d
+	ExtALPN                = 0x0010
+	ExtSupportedVersions   = 0x002b
+)
+
+// Record is one TLS record. Offset is its position in the flow data.
+type Record struct {
+	Type     uint8
+	Version  uint16
+	Offset   int
+	Fragment []byte
+}
+
+// Alert is a TLS alert. Alerts sent after ChangeCipherSpec are encrypted,
+// so only their presence is known.
+type Alert struct {
+	Level       uint8
+	Description uint8
+	Encrypted   bool
+	Offset      int
+}
+
+// Side is everything decoded from one direction of a connection
+type Side struct {
+	Records      int
+	ClientHello  *ClientHello
+	ServerHello  *ServerHello
+	Certificates [][]byte // DER, leaf first
+	Alerts       []Alert
+	// Offsets of the first ClientHello/ServerHello record, for timing
+	HelloOffset int
+	// Encrypted is set once the sender switched to encrypted records
+	Encrypted bool
+}
+
+// LooksLikeTLS reports whether data starts with a plausible TLS record header
+func LooksLikeTLS(data []byte) bool {
+	return len(data) >= 5 && data[0] >= RecordChangeCipherSpec && data[0] <= RecordApplicationData &&
+		data[1] == 0x03 && data[2] <= 0x04
+}
+
+// ParseRecords splits data into records, stopping at the first incomplete or
+// malformed one.
+func ParseRecords(data []byte) []Record {
+	var recs []Record
+	pos := 0
+	for pos+5 <= len(data) {
+		if !LooksLikeTLS(data[pos:]) {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+3 : pos+5]))
+		if pos+5+n > len(data) {
+			break
+		}
+		recs = append(recs, Record{
+			Type:     data[pos],
+			Version:  binary.BigEndian.Uint16(data[pos+1 : pos+3]),
+			Offset:   pos,
+			Fragment: data[pos+5 : pos+5+n],
+		})
+		pos += 5 + n
+	}
+	return recs
+}
+
+// ParseSide decodes the records sent by one side of the connection
+func ParseSide(data []byte) *Side {
+	side := &Side{HelloOffset: -1}
+	recs := ParseRecords(data)
+	side.Records = len(recs)
+
+	// Handshake messages can span records; collect the plaintext handshake
+	// stream, remembering which record each byte came from.
+	var hs []byte
+	var hsOffsets []int
+	for _, r := range recs {
+		switch r.Type {
+		case RecordChangeCipherSpec:
+			side.Encrypted = true
+		case RecordAlert:
+			if side.Encrypted || len(r.Fragment) != 2 {
+				side.Alerts = append(side.Alerts, Alert{Encrypted: true, Offset: r.Offset})
+			} else {
+				side.Alerts = append(side.Alerts, Alert{Level: r.Fragment[0], Description: r.Fragment[1], Offset: r.Offset})
+			}
+		case RecordHandshake:
+			if side.Encrypted {
+				continue
+			}
+			for range r.Fragment {
+				hsOffsets = append(hsOffsets, r.Offset)
+			}
+			hs = append(hs, r.Fragment...)
+		case RecordApplicationData:
+			side.Encrypted = true
+		}
+	}
+
+	parseHandshakeMessages(side, hs, hsOffsets)
+	return side
+}
+
+// ParseHandshake decodes bare handshake messages without the record layer,
+// as carried in QUIC CRYPTO frames
+func ParseHandshake(hs []byte) *Side {
+	side := &Side{HelloOffset: -1}
+	parseHandshakeMessages(side, hs, nil)
+	return side
+}
+
+// parseHandshakeMessages fills side from the handshake byte stream. offsets
+// maps each byte to the record it came from, nil when there are no records.
+func parseHandshakeMessages(side *Side, hs []byte, offsets []int) {
+	offsetOf := func(pos int) int {
+		if offsets == nil {
+			return pos
+		}
+		return offsets[pos]
+	}
+
+	pos := 0
+	for pos+4 <= len(hs) {
+		msgType := hs[pos]
+		n := int(hs[pos+1])<<16 | int(hs[pos+2])<<8 | int(hs[pos+3])
+		if pos+4+n > len(hs) {
+			break
+		}
+		body := hs[pos+4 : pos+4+n]
+
+		switch msgType {
+		case HandshakeClientHello:
+			if ch, ok := parseClientHello(body); ok && side.ClientHello == nil {
+				side.ClientHello = ch
+				side.HelloOffset = offsetOf(pos)
+			}
+		case HandshakeServerHello:
+			if sh, ok := parseServerHello(body); ok && side.ServerHello == nil {
+				side.ServerHello = sh
+				side.HelloOffset = offsetOf(pos)
+			}
+		case HandshakeCertificate:
+			side.Certificates = parseCertificates(body)
+		}
+		pos += 4 + n
+	}
+}
+
+func parseCertificates(body []byte) [][]byte {
+	if len(body) < 3 {
+		return nil
+	}
+	total := int(body[0])<<16 | int(body[1])<<8 | int(body[2])
+	list := body[3:]
+	if total < len(list) {
+		list = list[:total]
+	}
+
+	var certs [][]byte
+	for len(list) >= 3 {
+		n := int(list[0])<<16 | int(list[1])<<8 | int(list[2])
+		if 3+n > len(list) {
+			break
+		}
+		certs = append(certs, list[3:3+n])
+		list = list[3+n:]
+	}
+	return certs
+}
+
+// IsGREASE reports whether v is a reserved GREASE value (RFC 8701)
+func IsGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
@@ -0,0 +1,195 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdtls "crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder keeps a copy of everything written to a connection
+type recorder struct {
+	net.Conn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	r.buf.Write(p)
+	r.mu.Unlock()
+	return r.Conn.Write(p)
+}
+
+// certificate returns a self-signed ECDSA certificate for example.com
+func certificate(t testing.TB) stdtls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), DNSNames: []string{"example.com"}, NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdtls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// connect runs a TLS connection over a pipe: the handshake, then each
+// message written by the client and echoed back by the server with a
+// prefix. It returns what each side sent.
+func connect(t testing.TB, cli, srv *stdtls.Config, messages ...string) (client, server []byte) {
+	t.Helper()
+	c, s := net.Pipe()
+	cr, sr := &recorder{Conn: c}, &recorder{Conn: s}
+	sc, cc := stdtls.Server(sr, srv), stdtls.Client(cr, cli)
+	done := make(chan error, 1)
+	go func() {
+		if err := sc.Handshake(); err != nil {
+			done <- err
+			return
+		}
+		for range messages {
+			buf := make([]byte, 1024)
+			n, err := sc.Read(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			if _, err := sc.Write(append([]byte("echo: "), buf[:n]...)); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	if err := cc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		if _, err := cc.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		if _, err := cc.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	s.Close()
+	return cr.buf.Bytes(), sr.buf.Bytes()
+}
+
+// handshake runs a TLS 1.2 handshake over a pipe and returns what each
+// side sent
+func handshake(t testing.TB) (client, server []byte) {
+	t.Helper()
+	return connect(t,
+		&stdtls.Config{ServerName: "example.com", InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}},
+		&stdtls.Config{Certificates: []stdtls.Certificate{certificate(t)}, MaxVersion: stdtls.VersionTLS12, NextProtos: []string{"h2"}},
+	)
+}
+
+func TestParseSide(t *testing.T) {
+	client, server := handshake(t)
+
+	cs := ParseSide(client)
+	if cs.ClientHello == nil {
+		t.Fatal("no ClientHello")
+	}
+	if cs.ClientHello.ServerName != "example.com" {
+		t.Errorf("SNI = %q, want example.com", cs.ClientHello.ServerName)
+	}
+	if len(cs.ClientHello.ALPN) != 2 || cs.ClientHello.ALPN[0] != "h2" {
+		t.Errorf("ALPN = %v, want [h2 http/1.1]", cs.ClientHello.ALPN)
+	}
+	if !cs.Encrypted {
+		t.Error("client side not marked encrypted after ChangeCipherSpec")
+	}
+
+	ss := ParseSide(server)
+	if ss.ServerHello == nil {
+		t.Fatal("no ServerHello")
+	}
+	if ss.ServerHello.SelectedVersion != stdtls.VersionTLS12 || ss.ServerHello.ALPN != "h2" {
+		t.Errorf("ServerHello = %+v, want TLS 1.2 with h2", ss.ServerHello)
+	}
+	if len(ss.Certificates) != 1 {
+		t.Errorf("got %d certificates, want 1", len(ss.Certificates))
+	}
+}
+
+func TestParseAlerts(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []Alert
+	}{
+		{"fatal in the clear", []byte{RecordAlert, 3, 3, 0, 2, AlertLevelFatal, 40},
+			[]Alert{{Level: AlertLevelFatal, Description: 40}}},
+		{"after ChangeCipherSpec", []byte{RecordChangeCipherSpec, 3, 3, 0, 1, 1, RecordAlert, 3, 3, 0, 2, 0xaa, 0xbb},
+			[]Alert{{Encrypted: true, Offset: 6}}},
+		{"encrypted length", []byte{RecordAlert, 3, 3, 0, 3, 1, 2, 3},
+			[]Alert{{Encrypted: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseSide(tt.data).Alerts
+			if len(got) != len(tt.want) {
+				t.Fatalf("alerts = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("alert %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+	if name := AlertName(40); name != "handshake_failure" {
+		t.Errorf("AlertName(40) = %q", name)
+	}
+}
+
+// Every prefix of a handshake must parse without panicking
+func TestTruncated(t *testing.T) {
+	client, server := handshake(t)
+	for _, data := range [][]byte{client, server} {
+		for i := 0; i <= len(data); i++ {
+			parse(data[:i])
+			if i >= 5 {
+				parse(data[5:i]) // bare handshake messages, as in QUIC
+			}
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	client, server := handshake(f)
+	f.Add(client)
+	f.Add(server)
+	f.Add([]byte{RecordAlert, 3, 3, 0, 2, 2, 40})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parse(data)
+	})
+}
+
+func parse(data []byte) {
+	for _, side := range []*Side{ParseSide(data), ParseHandshake(data)} {
+		if ch := side.ClientHello; ch != nil {
+			JA3(ch)
+			JA4(ch, false)
+			ch.MaxVersion()
+		}
+	}
+}
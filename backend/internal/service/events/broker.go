@@ -0,0 +1,127 @@
+package events
+
+import (
+	"sync"
+)
+
+// Event types pushed to analysis subscribers
+const (
+	TypeProgress = "progress"
+	TypeFinding  = "finding"
+	TypeStatus   = "status"
+)
+
+// Event is a single message published for an analysis
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// Progress describes how far an analysis has got
+type Progress struct {
+	Stage         string  `json:"stage"` // "parsing", "analyzing", "saving"
+	Percent       int     `json:"percent"`
+	BytesRead     int64   `json:"bytes_read"`
+	TotalBytes    int64   `json:"total_bytes"`
+	Packets       int64   `json:"packets"`
+	PacketsPerSec float64 `json:"packets_per_sec"`
+	Flows         int     `json:"flows"`
+	ETASeconds    float64 `json:"eta_seconds"`
+}
+
+// Finding is a partial result for a stream that finished before the whole capture
+type Finding struct {
+	StreamHash string   `json:"stream_hash"`
+	ClientIP   string   `json:"client_ip"`
+	ServerIP   string   `json:"server_ip"`
+	ServerPort uint16   `json:"server_port"`
+	Protocol   string   `json:"protocol"`
+	Severity   string   `json:"severity"`
+	Issues     []string `json:"issues"`
+}
+
+// Broker fans analysis events out to any number of subscribers
+type Broker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan Event]struct{}
+	last map[string]Event
+}
+
+// Default is the process-wide broker used by the API handlers
+var Default = NewBroker()
+
+func NewBroker() *Broker {
+	return &Broker{
+		subs: make(map[string]map[chan Event]struct{}),
+		last: make(map[string]Event),
+	}
+}
+
+// Subscribe registers for events of one analysis. The latest progress event,
+// if any, is replayed first. The channel is closed when the analysis finishes;
+// call the returned func to unsubscribe early.
+func (b *Broker) Subscribe(id string) (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+
+	b.mu.Lock()
+	if b.subs[id] == nil {
+		b.subs[id] = make(map[chan Event]struct{})
+	}
+	b.subs[id][ch] = struct{}{}
+	if ev, ok := b.last[id]; ok {
+		ch <- ev
+	}
+	b.mu.Unlock()
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[id][ch]; ok {
+			delete(b.subs[id], ch)
+			close(ch)
+		}
+		if len(b.subs[id]) == 0 {
+			delete(b.subs, id)
+		}
+	}
+	return ch, cancel
+}
+
+// Publish sends an event to all current subscribers. Slow subscribers miss
+// events rather than blocking the analysis.
+func (b *Broker) Publish(id string, ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ev.Type == TypeProgress {
+		b.last[id] = ev
+	}
+	for ch := range b.subs[id] {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Close publishes a final event and disconnects all subscribers of an analysis
+func (b *Broker) Close(id string, final Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[id] {
+		// Make room so the final event is never the one dropped
+		select {
+		case ch <- final:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- final
+		}
+		close(ch)
+	}
+	delete(b.subs, id)
+	delete(b.last, id)
+}
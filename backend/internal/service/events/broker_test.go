@@ -0,0 +1,84 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	progress := func(pct int) Event {
+		return Event{Type: TypeProgress, Data: Progress{Percent: pct}}
+	}
+
+	first, cancelFirst := b.Subscribe("a")
+	b.Publish("a", progress(10))
+	b.Publish("a", Event{Type: TypeFinding, Data: "s"})
+	b.Publish("b", progress(99))
+
+	// A late subscriber starts from the latest progress only
+	second, cancelSecond := b.Subscribe("a")
+	defer cancelSecond()
+	b.Publish("a", progress(20))
+
+	want := []Event{progress(10), {Type: TypeFinding, Data: "s"}, progress(20)}
+	for i, w := range want {
+		if ev := <-first; ev != w {
+			t.Errorf("first event %d = %+v, want %+v", i, ev, w)
+		}
+	}
+	for _, w := range []Event{progress(10), progress(20)} {
+		if ev := <-second; ev != w {
+			t.Errorf("second got %+v, want %+v", ev, w)
+		}
+	}
+
+	// Unsubscribing closes the channel and stops delivery
+	cancelFirst()
+	cancelFirst()
+	if _, ok := <-first; ok {
+		t.Error("channel open after unsubscribe")
+	}
+	b.Publish("a", progress(30))
+	if ev := <-second; ev != progress(30) {
+		t.Errorf("second got %+v after the first left", ev)
+	}
+
+	// Close delivers the final event, disconnects and forgets the analysis
+	b.Close("a", Event{Type: TypeStatus, Data: "completed"})
+	if ev := <-second; ev.Type != TypeStatus {
+		t.Errorf("final event = %+v", ev)
+	}
+	if _, ok := <-second; ok {
+		t.Error("channel open after close")
+	}
+	late, cancelLate := b.Subscribe("a")
+	defer cancelLate()
+	select {
+	case ev := <-late:
+		t.Errorf("replayed %+v after close", ev)
+	default:
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	ch, cancel := b.Subscribe("a")
+	defer cancel()
+
+	// Publishing never blocks on a full channel, and the final event still
+	// gets through
+	for i := 0; i < cap(ch)+10; i++ {
+		b.Publish("a", Event{Type: TypeFinding, Data: i})
+	}
+	b.Close("a", Event{Type: TypeStatus, Data: "failed"})
+
+	var last Event
+	n := 0
+	for ev := range ch {
+		last = ev
+		n++
+	}
+	if n != cap(ch) || last.Type != TypeStatus {
+		t.Errorf("received %d events ending with %+v", n, last)
+	}
+}
@@ -0,0 +1,84 @@
+package lifecycle
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+// DeleteAnalysis removes an analysis with its streams, packets and job
+// record. The capture file and key log are removed too when they live under
+// uploadDir; files ingested from elsewhere (dev ingest) are left alone.
+// Child rows are deleted explicitly since databases adopted from before
+// migrations have no cascades.
+func DeleteAnalysis(id, uploadDir string) error {
+	var analysis model.Analysis
+	if err := db.DB.Where("id = ?", id).First(&analysis).Error; err != nil {
+		return err
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := DeleteResults(tx, id); err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", id).Delete(&model.Job{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&model.Analysis{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if analysis.FilePath != "" && isWithin(analysis.FilePath, uploadDir) {
+		if err := os.Remove(analysis.FilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if analysis.KeyLogPath != "" && isWithin(analysis.KeyLogPath, uploadDir) {
+		if err := os.Remove(analysis.KeyLogPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteResults removes everything an analysis run produced, keeping the
+// analysis record itself
+func DeleteResults(tx *gorm.DB, id string) error {
+	if err := tx.Where("analysis_id = ?", id).Delete(&model.CaptureStats{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("analysis_id = ?", id).Delete(&model.Finding{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("analysis_id = ?", id).Delete(&model.Call{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("analysis_id = ?", id).Delete(&model.Transaction{}).Error; err != nil {
+		return err
+	}
+	streamIDs := tx.Model(&model.Stream{}).Select("id").Where("analysis_id = ?", id)
+	if err := tx.Where("stream_id IN (?)", streamIDs).Delete(&model.Packet{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("analysis_id = ?", id).Delete(&model.Stream{}).Error
+}
+
+func isWithin(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
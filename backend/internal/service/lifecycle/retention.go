@@ -0,0 +1,107 @@
+package lifecycle
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+// RetentionPolicy expires finished analyses by age and by the total size of
+// the captures stored under UploadDir. A zero MaxAge or MaxTotalBytes
+// disables that rule.
+type RetentionPolicy struct {
+	MaxAge        time.Duration
+	MaxTotalBytes int64
+	Interval      time.Duration
+	UploadDir     string
+}
+
+// Enabled reports whether any expiry rule is set
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxTotalBytes > 0
+}
+
+// Run applies the policy every Interval until ctx is done
+func (p RetentionPolicy) Run(ctx context.Context) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := p.Apply(); err != nil {
+			log.Printf("retention: %v", err)
+		} else if n > 0 {
+			log.Printf("retention: expired %d analyses", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Apply runs one retention pass and returns how many analyses were deleted.
+// Analyses that are still processing are never expired.
+func (p RetentionPolicy) Apply() (int, error) {
+	deleted := 0
+
+	if p.MaxAge > 0 {
+		var expired []model.Analysis
+		cutoff := time.Now().Add(-p.MaxAge)
+		if err := db.DB.Select("id").
+			Where("created_at < ? AND status <> ?", cutoff, "processing").
+			Find(&expired).Error; err != nil {
+			return deleted, err
+		}
+		for _, a := range expired {
+			if err := DeleteAnalysis(a.ID, p.UploadDir); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+
+	if p.MaxTotalBytes > 0 {
+		// Only uploads count: deleting an analysis of a file ingested from
+		// elsewhere frees no disk
+		var analyses []model.Analysis
+		if err := db.DB.Select("id", "file_path", "file_size", "status").
+			Order("created_at asc").
+			Find(&analyses).Error; err != nil {
+			return deleted, err
+		}
+		var total int64
+		var candidates []model.Analysis
+		for _, a := range analyses {
+			if a.FilePath == "" || !isWithin(a.FilePath, p.UploadDir) {
+				continue
+			}
+			total += a.FileSize
+			if a.Status != "processing" {
+				candidates = append(candidates, a)
+			}
+		}
+
+		// Oldest first until we are back under quota
+		for _, a := range candidates {
+			if total <= p.MaxTotalBytes {
+				break
+			}
+			if err := DeleteAnalysis(a.ID, p.UploadDir); err != nil {
+				return deleted, err
+			}
+			total -= a.FileSize
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,78 @@
+package lifecycle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+func TestRetentionQuota(t *testing.T) {
+	dir := t.TempDir()
+	conn, err := db.Connect("sqlite", filepath.Join(dir, "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	prev := db.DB
+	db.DB = conn.Session(&gorm.Session{Logger: logger.Discard})
+	defer func() { db.DB = prev }()
+
+	uploads := filepath.Join(dir, "uploads")
+	if err := os.Mkdir(uploads, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now().Add(-time.Hour)
+	for i, a := range []struct {
+		id, dir, status string
+		size            int64
+	}{
+		{"ingested", dir, "complete", 1000}, // outside the upload dir: frees nothing
+		{"oldest", uploads, "complete", 100},
+		{"processing", uploads, "processing", 100},
+		{"newer", uploads, "complete", 100},
+	} {
+		path := filepath.Join(a.dir, a.id+".pcap")
+		if err := os.WriteFile(path, make([]byte, a.size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := db.DB.Create(&model.Analysis{ID: a.id, FilePath: path, FileSize: a.size, Status: a.status, CreatedAt: base.Add(time.Duration(i) * time.Minute)}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 300 bytes of uploads against a 250 byte quota: only the oldest upload goes
+	policy := RetentionPolicy{MaxTotalBytes: 250, UploadDir: uploads}
+	if n, err := policy.Apply(); n != 1 || err != nil {
+		t.Fatalf("Apply = %d, %v", n, err)
+	}
+	var left []string
+	db.DB.Model(&model.Analysis{}).Order("created_at").Pluck("id", &left)
+	if len(left) != 3 || left[0] != "ingested" || left[1] != "processing" || left[2] != "newer" {
+		t.Errorf("analyses left = %v", left)
+	}
+	if _, err := os.Stat(filepath.Join(uploads, "oldest.pcap")); !os.IsNotExist(err) {
+		t.Errorf("oldest upload still on disk: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ingested.pcap")); err != nil {
+		t.Errorf("ingested capture removed: %v", err)
+	}
+
+	// Running analyses are never expired, even when over quota
+	policy.MaxTotalBytes = 50
+	if n, err := policy.Apply(); n != 1 || err != nil {
+		t.Fatalf("Apply = %d, %v", n, err)
+	}
+	if n, err := policy.Apply(); n != 0 || err != nil {
+		t.Errorf("Apply with only a running upload left = %d, %v", n, err)
+	}
+}
@@ -1,13 +1,20 @@
 package pcap
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+
+	"pcap-analyzer/internal/domain"
+	"pcap-analyzer/internal/service/dissector/icmp"
 )
 
 // PacketMeta contains minimal metadata for analysis
@@ -17,22 +24,57 @@ type PacketMeta struct {
 	DstIP      string
 	SrcPort    uint16
 	DstPort    uint16
-	Protocol   string
-	Length     int
+	Transport  string // "TCP", "UDP", "ICMP", "ICMPv6" or "ARP"
+	Protocol   string // transport label; streams are classified by the analyzer
+	Length     int    // frame length on the wire, even when the snap length cut it
 	Flags      []string
 	Seq        uint32
 	Ack        uint32
 	Window     uint16
 	PayloadLen int
 	Payload    []byte
-	MSS        uint16
+	ECN        uint8              // IP ECN codepoint
+	TTL        uint8              // IPv4 TTL or IPv6 hop limit
+	IPID       uint16             // IPv4 identification, 0 for IPv6
+	TCPOptions *domain.TCPOptions // nil without any option parsed
+	ICMP       *icmp.Message      // set for ICMP and ICMPv6 packets
+	ARP        *ARP               // set for ARP packets, which have no IP header
+}
+
+// ARP holds the addresses of an ARP request or reply
+type ARP struct {
+	Operation uint16
+	SrcMAC    string // Ethernet source
+	SenderMAC string
+	SenderIP  string
+	TargetMAC string
+	TargetIP  string
 }
 
 // StreamingParser handles PCAP parsing
 type StreamingParser struct {
 	FilePath string
+	FileSize int64
+
+	bytesRead   int64 // atomic
+	packetsRead int64 // atomic
 }
 
+// Progress is a point-in-time view of how far the parser has read into the file
+type Progress struct {
+	BytesRead   int64
+	TotalBytes  int64
+	PacketsRead int64
+}
+
+// File format framing overhead, used to turn packet lengths into file offsets
+const (
+	pcapGlobalHeaderLen = 24
+	pcapRecordHeaderLen = 16
+	pcapngEPBOverhead   = 32
+	pcapngMagic         = 0x0A0D0D0A
+)
+
 // NewStreamingParser creates a new parser
 func NewStreamingParser(filePath string) *StreamingParser {
 	return &StreamingParser{
@@ -42,11 +84,25 @@ func NewStreamingParser(filePath string) *StreamingParser {
 
 // Parse streams packets to a channel
 func (p *StreamingParser) Parse() (<-chan PacketMeta, error) {
+	return p.ParseContext(context.Background())
+}
+
+// ParseContext is like Parse but stops reading and closes the channel once ctx is done
+func (p *StreamingParser) ParseContext(ctx context.Context) (<-chan PacketMeta, error) {
 	handle, err := pcap.OpenOffline(p.FilePath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening pcap: %v", err)
 	}
 
+	isNG := false
+	if fi, err := os.Stat(p.FilePath); err == nil {
+		p.FileSize = fi.Size()
+		isNG = isPcapNG(p.FilePath)
+	}
+	if !isNG {
+		atomic.StoreInt64(&p.bytesRead, pcapGlobalHeaderLen)
+	}
+
 	out := make(chan PacketMeta, 1000)
 
 	go func() {
@@ -62,29 +118,92 @@ func (p *StreamingParser) Parse() (<-chan PacketMeta, error) {
 		}
 
 		for packet := range packetSource.Packets() {
+			atomic.AddInt64(&p.bytesRead, recordSize(packet.Metadata().CaptureLength, isNG))
+			atomic.AddInt64(&p.packetsRead, 1)
+
 			meta := extractMeta(packet)
 			if meta != nil {
-				out <- *meta
+				select {
+				case out <- *meta:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
+
+		// Account for trailing blocks (pcapng stats, padding) we don't see
+		atomic.StoreInt64(&p.bytesRead, p.FileSize)
 	}()
 
 	return out, nil
 }
 
-func extractMeta(packet gopacket.Packet) *PacketMeta {
-	ipLayer := packet.Layer(layers.LayerTypeIPv4)
-	if ipLayer == nil {
-		return nil // Only IPv4 for now
+// Progress is safe to call while Parse is running
+func (p *StreamingParser) Progress() Progress {
+	read := atomic.LoadInt64(&p.bytesRead)
+	if p.FileSize > 0 && read > p.FileSize {
+		read = p.FileSize
 	}
-	ip, _ := ipLayer.(*layers.IPv4)
+	return Progress{
+		BytesRead:   read,
+		TotalBytes:  p.FileSize,
+		PacketsRead: atomic.LoadInt64(&p.packetsRead),
+	}
+}
+
+// recordSize estimates the on-disk size of a packet record.
+// Exact for classic pcap; pcapng ignores options and non-packet blocks.
+func recordSize(captureLen int, isNG bool) int64 {
+	if isNG {
+		padded := (captureLen + 3) &^ 3
+		return int64(pcapngEPBOverhead + padded)
+	}
+	return int64(pcapRecordHeaderLen + captureLen)
+}
+
+func isPcapNG(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	var magic [4]byte
+	if _, err := f.Read(magic[:]); err != nil {
+		return false
+	}
+	return binary.BigEndian.Uint32(magic[:]) == pcapngMagic
+}
 
+// layerBytes returns a layer's header and payload as one slice
+func layerBytes(l gopacket.Layer) []byte {
+	b := make([]byte, 0, len(l.LayerContents())+len(l.LayerPayload()))
+	b = append(b, l.LayerContents()...)
+	return append(b, l.LayerPayload()...)
+}
+
+func extractMeta(packet gopacket.Packet) *PacketMeta {
 	meta := &PacketMeta{
 		Timestamp: packet.Metadata().Timestamp,
-		SrcIP:     ip.SrcIP.String(),
-		DstIP:     ip.DstIP.String(),
 		Length:    len(packet.Data()),
 	}
+	if wire := packet.Metadata().Length; wire > meta.Length {
+		meta.Length = wire
+	}
+	if arp, ok := packet.Layer(layers.LayerTypeARP).(*layers.ARP); ok {
+		return arpMeta(meta, packet, arp)
+	}
+	if ip4, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		meta.SrcIP, meta.DstIP = ip4.SrcIP.String(), ip4.DstIP.String()
+		meta.ECN = ip4.TOS & 3
+		meta.TTL, meta.IPID = ip4.TTL, ip4.Id
+	} else if ip6, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+		meta.SrcIP, meta.DstIP = ip6.SrcIP.String(), ip6.DstIP.String()
+		meta.ECN = ip6.TrafficClass & 3
+		meta.TTL = ip6.HopLimit
+	} else {
+		return nil
+	}
 
 	// Transport Layer
 	tcpLayer := packet.Layer(layers.LayerTypeTCP)
@@ -96,6 +215,7 @@ func extractMeta(packet gopacket.Packet) *PacketMeta {
 		meta.SrcPort = uint16(tcp.SrcPort)
 		meta.DstPort = uint16(tcp.DstPort)
 		meta.Protocol = "TCP"
+		meta.Transport = "TCP"
 		meta.Seq = tcp.Seq
 		meta.Ack = tcp.Ack
 		meta.Window = tcp.Window
@@ -118,22 +238,46 @@ func extractMeta(packet gopacket.Packet) *PacketMeta {
 		if tcp.PSH {
 			meta.Flags = append(meta.Flags, "PSH")
 		}
-
-		// Extract MSS
-		for _, opt := range tcp.Options {
-			if opt.OptionType == layers.TCPOptionKindMSS && len(opt.OptionData) == 2 {
-				meta.MSS = binary.BigEndian.Uint16(opt.OptionData)
-			}
+		if tcp.URG {
+			meta.Flags = append(meta.Flags, "URG")
+		}
+		if tcp.ECE {
+			meta.Flags = append(meta.Flags, "ECE")
+		}
+		if tcp.CWR {
+			meta.Flags = append(meta.Flags, "CWR")
 		}
+		meta.TCPOptions = tcpOptions(tcp.Options)
 	} else if udpLayer != nil {
 		udp, _ := udpLayer.(*layers.UDP)
 		meta.SrcPort = uint16(udp.SrcPort)
 		meta.DstPort = uint16(udp.DstPort)
 		meta.Protocol = "UDP"
+		meta.Transport = "UDP"
 		meta.PayloadLen = len(udp.Payload)
 		payload = udp.Payload
+	} else if icmpLayer := packet.Layer(layers.LayerTypeICMPv4); icmpLayer != nil {
+		payload = layerBytes(icmpLayer)
+		meta.ICMP = icmp.ParseV4(payload)
+		meta.Transport = "ICMP"
+	} else if icmpLayer := packet.Layer(layers.LayerTypeICMPv6); icmpLayer != nil {
+		payload = layerBytes(icmpLayer)
+		meta.ICMP = icmp.ParseV6(payload)
+		meta.Transport = "ICMPv6"
 	} else {
-		return nil // Skip non-TCP/UDP
+		return nil // Skip other transports
+	}
+
+	if meta.ICMP != nil {
+		meta.Protocol = meta.Transport
+		meta.PayloadLen = len(payload)
+		// Echo requests and replies pair up by identifier
+		if meta.ICMP.Kind == icmp.KindEchoRequest || meta.ICMP.Kind == icmp.KindEchoReply {
+			meta.SrcPort = binary.BigEndian.Uint16(payload[4:6])
+			meta.DstPort = meta.SrcPort
+		}
+	} else if meta.Transport != "TCP" && meta.Transport != "UDP" {
+		return nil // truncated ICMP header
 	}
 
 	// Copy Payload (Limit to 2KB)
@@ -146,29 +290,75 @@ func extractMeta(packet gopacket.Packet) *PacketMeta {
 		copy(meta.Payload, payload[:limit])
 	}
 
-	// Application Protocol Detection (DPI)
-	if len(payload) > 0 {
-		// HTTP Methods
-		sPayload := string(payload)
-		if len(sPayload) > 5 {
-			prefix := sPayload[:5]
-			if prefix[:3] == "GET" || prefix[:4] == "POST" || prefix[:3] == "PUT" || prefix[:4] == "HEAD" || prefix[:4] == "HTTP" {
-				meta.Protocol = "HTTP"
-			} else if prefix[:4] == "SSH-" {
-				meta.Protocol = "SSH"
-			}
-		}
+	return meta
+}
 
-		// TLS (Handshake 0x16 + Version 0x03 0x01/02/03)
-		if len(payload) > 5 && payload[0] == 0x16 && payload[1] == 0x03 {
-			meta.Protocol = "TLS"
-		}
+// TCP Fast Open: option 34, or the experimental option 254 with its magic
+const (
+	tcpOptionFastOpen     = 34
+	tcpOptionExperimental = 254
+	tcpFastOpenMagic      = 0xF989
+)
 
-		// DNS (Port 53 heuristic for now)
-		if meta.Protocol == "UDP" && (meta.SrcPort == 53 || meta.DstPort == 53) {
-			meta.Protocol = "DNS"
+// tcpOptions picks the options the analyzer uses out of a TCP header,
+// skipping malformed ones
+func tcpOptions(opts []layers.TCPOption) *domain.TCPOptions {
+	o := &domain.TCPOptions{}
+	found := false
+	for _, opt := range opts {
+		data := opt.OptionData
+		switch {
+		case opt.OptionType == layers.TCPOptionKindMSS && len(data) == 2:
+			o.MSS = binary.BigEndian.Uint16(data)
+		case opt.OptionType == layers.TCPOptionKindWindowScale && len(data) == 1:
+			o.HasWindowScale, o.WindowScale = true, data[0]
+		case opt.OptionType == layers.TCPOptionKindSACKPermitted:
+			o.SACKPermitted = true
+		case opt.OptionType == layers.TCPOptionKindSACK && len(data) > 0 && len(data)%8 == 0:
+			for i := 0; i < len(data); i += 8 {
+				o.SACK = append(o.SACK, domain.SACKBlock{
+					Left:  binary.BigEndian.Uint32(data[i:]),
+					Right: binary.BigEndian.Uint32(data[i+4:]),
+				})
+			}
+		case opt.OptionType == layers.TCPOptionKindTimestamps && len(data) == 8:
+			o.HasTimestamps = true
+			o.TSval, o.TSecr = binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])
+		case opt.OptionType == tcpOptionFastOpen:
+			o.FastOpen, o.FastOpenCookie = true, append([]byte(nil), data...)
+		case opt.OptionType == tcpOptionExperimental && len(data) >= 2 && binary.BigEndian.Uint16(data) == tcpFastOpenMagic:
+			o.FastOpen, o.FastOpenCookie = true, append([]byte(nil), data[2:]...)
+		default:
+			continue
 		}
+		found = true
+	}
+	if !found {
+		return nil
 	}
+	return o
+}
 
+// arpMeta fills meta from an IPv4-over-Ethernet ARP packet; other
+// hardware and protocol types are skipped
+func arpMeta(meta *PacketMeta, packet gopacket.Packet, arp *layers.ARP) *PacketMeta {
+	if arp.AddrType != layers.LinkTypeEthernet || arp.Protocol != layers.EthernetTypeIPv4 ||
+		len(arp.SourceHwAddress) != 6 || len(arp.SourceProtAddress) != 4 ||
+		len(arp.DstHwAddress) != 6 || len(arp.DstProtAddress) != 4 {
+		return nil
+	}
+	a := &ARP{
+		Operation: arp.Operation,
+		SenderMAC: net.HardwareAddr(arp.SourceHwAddress).String(),
+		SenderIP:  net.IP(arp.SourceProtAddress).String(),
+		TargetMAC: net.HardwareAddr(arp.DstHwAddress).String(),
+		TargetIP:  net.IP(arp.DstProtAddress).String(),
+	}
+	if eth, ok := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet); ok {
+		a.SrcMAC = eth.SrcMAC.String()
+	}
+	meta.Transport, meta.Protocol = "ARP", "ARP"
+	meta.SrcIP, meta.DstIP = a.SenderIP, a.TargetIP
+	meta.ARP = a
 	return meta
 }
@@ -0,0 +1,73 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// pcapng block types and the Decryption Secrets Block payload type for TLS
+// key logs (draft-ietf-opsawg-pcapng)
+const (
+	pcapngDSB          = 0x0000000A
+	pcapngByteOrder    = 0x1A2B3C4D
+	secretsTypeTLSKeys = 0x544c534b // "TLSK"
+)
+
+// ReadTLSSecrets returns the NSS key log text embedded in the Decryption
+// Secrets Blocks of a pcapng file. Classic pcap files have none.
+func ReadTLSSecrets(path string) ([][]byte, error) {
+	if !isPcapNG(path) {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var secrets [][]byte
+	order := binary.ByteOrder(binary.LittleEndian)
+	var hdr [12]byte
+	for {
+		if _, err := io.ReadFull(f, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return secrets, nil
+			}
+			return secrets, err
+		}
+		// Each section header sets the byte order of the blocks that follow
+		if binary.BigEndian.Uint32(hdr[:4]) == pcapngMagic {
+			if binary.BigEndian.Uint32(hdr[8:]) == pcapngByteOrder {
+				order = binary.BigEndian
+			} else {
+				order = binary.LittleEndian
+			}
+		}
+		blockType := order.Uint32(hdr[:4])
+		blockLen := int64(order.Uint32(hdr[4:8]))
+		if blockLen < 12 || blockLen%4 != 0 {
+			return secrets, errors.New("pcapng: malformed block length")
+		}
+
+		if blockType == pcapngDSB && blockLen >= 20 {
+			// body runs to the end of the block, trailing length included
+			body := make([]byte, blockLen-8)
+			copy(body, hdr[8:])
+			if _, err := io.ReadFull(f, body[4:]); err != nil {
+				return secrets, err
+			}
+			secretsType := order.Uint32(body[:4])
+			n := int(order.Uint32(body[4:8]))
+			if secretsType == secretsTypeTLSKeys && 8+n <= len(body) {
+				secrets = append(secrets, body[8:8+n])
+			}
+			continue
+		}
+		// Skip the rest of the body and the trailing length
+		if _, err := f.Seek(blockLen-12, io.SeekCurrent); err != nil {
+			return secrets, err
+		}
+	}
+}
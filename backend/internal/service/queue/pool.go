@@ -0,0 +1,289 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"pcap-analyzer/internal/model"
+)
+
+// ErrCancelled is the cancellation cause for jobs stopped through Pool.Cancel
+var ErrCancelled = errors.New("job cancelled")
+
+// ErrRunningElsewhere is returned by Pool.Cancel for a job that another
+// process sharing the queue is running
+var ErrRunningElsewhere = errors.New("job is running in another process")
+
+// errShutdown is the cancellation cause for jobs interrupted by Pool.Stop
+var errShutdown = errors.New("worker pool shutting down")
+
+// Retry backoff: base * 2^(attempt-1), capped
+const (
+	retryBaseDelay = 5 * time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+// heartbeatInterval is how often running jobs renew their claim
+const heartbeatInterval = 10 * time.Second
+
+// HandlerFunc runs one job. It should return promptly once ctx is done.
+type HandlerFunc func(ctx context.Context, job *model.Job) error
+
+// Pool runs queued jobs on a fixed number of workers
+type Pool struct {
+	queue   Queue
+	workers int
+	handler HandlerFunc
+
+	// OnFailed is called when a job has used up its attempts
+	OnFailed func(job *model.Job, err error)
+	// OnCancelled is called when a job is cancelled, queued or running
+	OnCancelled func(job *model.Job)
+
+	ctx    context.Context
+	stop   context.CancelCauseFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	active map[string]*activeJob
+}
+
+type activeJob struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+func NewPool(q Queue, workers int, handler HandlerFunc) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{
+		queue:   q,
+		workers: workers,
+		handler: handler,
+		active:  make(map[string]*activeJob),
+	}
+}
+
+// Start requeues jobs interrupted by a previous shutdown or crash, then
+// launches the workers.
+func (p *Pool) Start() error {
+	p.ctx, p.stop = context.WithCancelCause(context.Background())
+
+	n, err := p.queue.RequeueInterrupted(p.ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("queue: requeued %d interrupted job(s)", n)
+	}
+
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return nil
+}
+
+// Stop interrupts running jobs, puts them back in the queue and waits for
+// the workers to exit.
+func (p *Pool) Stop() {
+	p.stop(errShutdown)
+	p.wg.Wait()
+	p.queue.Close()
+}
+
+// Queue returns the underlying job store
+func (p *Pool) Queue() Queue {
+	return p.queue
+}
+
+// Enqueue adds a job with the default retry budget if none is set
+func (p *Pool) Enqueue(ctx context.Context, job *model.Job) error {
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = 3
+	}
+	return p.queue.Enqueue(ctx, job)
+}
+
+// Cancel stops a job whether it is still queued or already running. It
+// reports false if the job has already finished. Running jobs can only be
+// cancelled by the process that is running them; others get
+// ErrRunningElsewhere.
+func (p *Pool) Cancel(ctx context.Context, id string) (bool, error) {
+	// A job that was just dequeued may not be registered as active yet
+	for attempt := 0; ; attempt++ {
+		if p.cancelActive(id) {
+			return true, nil
+		}
+
+		cancelled, err := p.queue.Cancel(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if cancelled {
+			break
+		}
+		job, err := p.queue.Get(ctx, id)
+		if err != nil || job.Status != StatusRunning {
+			return false, err
+		}
+		if attempt == 10 {
+			return false, ErrRunningElsewhere
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if p.OnCancelled != nil {
+		if job, err := p.queue.Get(ctx, id); err == nil {
+			p.OnCancelled(job)
+		}
+	}
+	return true, nil
+}
+
+func (p *Pool) cancelActive(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	active, running := p.active[id]
+	if running {
+		active.cancel(ErrCancelled)
+	}
+	return running
+}
+
+// Wait blocks until the job is no longer running in this process
+func (p *Pool) Wait(ctx context.Context, id string) error {
+	p.mu.Lock()
+	active, running := p.active[id]
+	p.mu.Unlock()
+	if !running {
+		return nil
+	}
+
+	select {
+	case <-active.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for {
+		job, err := p.queue.Dequeue(p.ctx)
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return
+			}
+			log.Printf("queue: dequeue failed: %v", err)
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		p.run(job)
+	}
+}
+
+func (p *Pool) run(job *model.Job) {
+	ctx, cancel := context.WithCancelCause(p.ctx)
+	active := &activeJob{cancel: cancel, done: make(chan struct{})}
+	p.mu.Lock()
+	p.active[job.ID] = active
+	p.mu.Unlock()
+
+	stopHeartbeat := p.heartbeat(job)
+	err := p.safeHandle(ctx, job)
+	stopHeartbeat()
+	cause := context.Cause(ctx)
+	cancel(nil)
+	defer func() {
+		p.mu.Lock()
+		delete(p.active, job.ID)
+		p.mu.Unlock()
+		close(active.done)
+	}()
+
+	// Queue bookkeeping must happen even though p.ctx may be done
+	bg := context.Background()
+	switch {
+	case err == nil:
+		p.report(job, p.queue.Finish(bg, job, StatusDone, ""))
+
+	case errors.Is(cause, ErrCancelled):
+		p.report(job, p.queue.Finish(bg, job, StatusCancelled, ErrCancelled.Error()))
+		if p.OnCancelled != nil {
+			p.OnCancelled(job)
+		}
+
+	case errors.Is(cause, errShutdown):
+		// Not the job's fault; don't charge it an attempt
+		job.Attempts--
+		p.report(job, p.queue.Retry(bg, job, time.Now()))
+
+	case job.Attempts < job.MaxAttempts:
+		job.LastError = err.Error()
+		delay := backoff(job.Attempts)
+		log.Printf("queue: job %s attempt %d failed, retrying in %s: %v", job.ID, job.Attempts, delay, err)
+		p.report(job, p.queue.Retry(bg, job, time.Now().Add(delay)))
+
+	default:
+		p.report(job, p.queue.Finish(bg, job, StatusFailed, err.Error()))
+		if p.OnFailed != nil {
+			p.OnFailed(job, err)
+		}
+	}
+}
+
+// heartbeat renews the claim on a job until the returned func is called
+func (p *Pool) heartbeat(job *model.Job) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.report(job, p.queue.Heartbeat(context.Background(), job))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// safeHandle turns a handler panic into a job failure instead of killing the worker
+func (p *Pool) safeHandle(ctx context.Context, job *model.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return p.handler(ctx, job)
+}
+
+func (p *Pool) report(job *model.Job, err error) {
+	if err != nil {
+		log.Printf("queue: updating job %s: %v", job.ID, err)
+	}
+}
+
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay
+	for i := 1; i < attempt && d < retryMaxDelay; i++ {
+		d *= 2
+	}
+	if d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return d
+}
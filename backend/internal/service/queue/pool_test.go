@@ -0,0 +1,253 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+// newSQLQueue opens a queue in a fresh database holding the analyses that
+// jobs with the given IDs belong to
+func newSQLQueue(t *testing.T, ids ...string) *SQLQueue {
+	t.Helper()
+	conn, err := db.Connect("sqlite", filepath.Join(t.TempDir(), "queue.db")+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err := conn.Create(&model.Analysis{ID: id}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &SQLQueue{db: conn.Session(&gorm.Session{Logger: logger.Discard}), notify: make(chan struct{}, 1)}
+}
+
+// waitFor polls cond until it holds or a few seconds have passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func jobStatus(t *testing.T, q Queue, id string) *model.Job {
+	t.Helper()
+	job, err := q.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Get(%s) = %v", id, err)
+	}
+	return job
+}
+
+func TestPoolPriority(t *testing.T) {
+	q := newSQLQueue(t, "low", "high-old", "high-new", "clamped", "negative")
+	var mu sync.Mutex
+	var order []string
+	pool := NewPool(q, 1, func(ctx context.Context, job *model.Job) error {
+		mu.Lock()
+		order = append(order, job.ID)
+		mu.Unlock()
+		return nil
+	})
+
+	base := time.Now().Add(-time.Minute)
+	for i, j := range []struct {
+		id       string
+		priority int
+	}{{"low", 0}, {"high-old", 5}, {"high-new", 5}, {"clamped", 20}, {"negative", -3}} {
+		job := &model.Job{ID: j.id, Priority: j.priority, CreatedAt: base.Add(time.Duration(i) * time.Second)}
+		if err := pool.Enqueue(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job := jobStatus(t, q, "clamped"); job.Priority != MaxPriority || job.MaxAttempts != 3 {
+		t.Errorf("clamped job = %+v", job)
+	}
+
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "all jobs", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 5
+	})
+	pool.Stop()
+
+	want := []string{"clamped", "high-old", "high-new", "low", "negative"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("ran %v, want %v", order, want)
+		}
+	}
+}
+
+func TestPoolRetry(t *testing.T) {
+	q := newSQLQueue(t, "j")
+	failed := make(chan error, 1)
+	pool := NewPool(q, 1, func(ctx context.Context, job *model.Job) error {
+		if job.Attempts == 1 {
+			return errors.New("disk full")
+		}
+		panic("corrupt capture")
+	})
+	pool.OnFailed = func(job *model.Job, err error) { failed <- err }
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	enqueued := time.Now()
+	if err := pool.Enqueue(context.Background(), &model.Job{ID: "j", MaxAttempts: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first failure is retried after the backoff
+	var job *model.Job
+	waitFor(t, "the retry", func() bool {
+		job = jobStatus(t, q, "j")
+		return job.Status == StatusQueued && job.Attempts == 1
+	})
+	if job.LastError != "disk full" || job.RunAt.Before(enqueued.Add(retryBaseDelay)) {
+		t.Errorf("retried job = %+v", job)
+	}
+
+	// Bring the retry forward; a panic on the last attempt fails the job
+	q.db.Model(&model.Job{}).Where("id = ?", "j").Update("run_at", time.Now())
+	q.wake()
+	select {
+	case err := <-failed:
+		if err.Error() != "panic: corrupt capture" {
+			t.Errorf("failure = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job never failed")
+	}
+	if job = jobStatus(t, q, "j"); job.Status != StatusFailed || job.Attempts != 2 {
+		t.Errorf("failed job = %+v", job)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{7, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	q := newSQLQueue(t, "running", "queued", "elsewhere")
+	started := make(chan string, 1)
+	pool := NewPool(q, 1, func(ctx context.Context, job *model.Job) error {
+		started <- job.ID
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	cancelled := make(chan string, 2)
+	pool.OnCancelled = func(job *model.Job) { cancelled <- job.ID }
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+	ctx := context.Background()
+
+	// Running in this process
+	if err := pool.Enqueue(ctx, &model.Job{ID: "running"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if ok, err := pool.Cancel(ctx, "running"); !ok || err != nil {
+		t.Fatalf("Cancel(running) = %v, %v", ok, err)
+	}
+	if id := <-cancelled; id != "running" {
+		t.Errorf("OnCancelled(%s)", id)
+	}
+	if err := pool.Wait(ctx, "running"); err != nil {
+		t.Fatal(err)
+	}
+	if job := jobStatus(t, q, "running"); job.Status != StatusCancelled || job.LastError != ErrCancelled.Error() {
+		t.Errorf("cancelled running job = %+v", job)
+	}
+
+	// Still waiting to run
+	if err := pool.Enqueue(ctx, &model.Job{ID: "queued", RunAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := pool.Cancel(ctx, "queued"); !ok || err != nil {
+		t.Fatalf("Cancel(queued) = %v, %v", ok, err)
+	}
+	if id := <-cancelled; id != "queued" {
+		t.Errorf("OnCancelled(%s)", id)
+	}
+
+	// Already finished, or unknown
+	if ok, err := pool.Cancel(ctx, "queued"); ok || err != nil {
+		t.Errorf("Cancel(finished) = %v, %v", ok, err)
+	}
+	if _, err := pool.Cancel(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Cancel(missing) = %v", err)
+	}
+
+	// Running in another process sharing the queue
+	q.db.Create(&model.Job{ID: "elsewhere", Status: StatusRunning, RunAt: time.Now()})
+	if ok, err := pool.Cancel(ctx, "elsewhere"); ok || err != ErrRunningElsewhere {
+		t.Errorf("Cancel(elsewhere) = %v, %v", ok, err)
+	}
+	if job := jobStatus(t, q, "elsewhere"); job.Status != StatusRunning {
+		t.Errorf("job running elsewhere = %+v", job)
+	}
+}
+
+func TestPoolRequeue(t *testing.T) {
+	q := newSQLQueue(t, "crashed")
+	ctx := context.Background()
+
+	// A crash left one job running; the next process picks it up again
+	q.db.Create(&model.Job{ID: "crashed", Status: StatusRunning, Attempts: 1, MaxAttempts: 3, RunAt: time.Now()})
+	ran := make(chan *model.Job, 1)
+	pool := NewPool(q, 1, func(ctx context.Context, job *model.Job) error {
+		ran <- job
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if job := <-ran; job.ID != "crashed" || job.Attempts != 2 {
+		t.Errorf("requeued job = %+v", job)
+	}
+
+	// Stopping puts the running job back without charging an attempt
+	pool.Stop()
+	if job := jobStatus(t, q, "crashed"); job.Status != StatusQueued || job.Attempts != 1 {
+		t.Errorf("job after shutdown = %+v", job)
+	}
+	if n, err := q.RequeueInterrupted(ctx); n != 0 || err != nil {
+		t.Errorf("RequeueInterrupted = %d, %v", n, err)
+	}
+}
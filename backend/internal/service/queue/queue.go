@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"pcap-analyzer/internal/model"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// MaxPriority bounds Job.Priority; values outside 0..MaxPriority are clamped
+const MaxPriority = 9
+
+var ErrNotFound = errors.New("job not found")
+
+// Queue is a durable store of analysis jobs. Implementations must survive a
+// process restart: jobs left "running" by a process that is gone are handed
+// back by RequeueInterrupted.
+type Queue interface {
+	// Enqueue stores a new job and makes it available to Dequeue
+	Enqueue(ctx context.Context, job *model.Job) error
+	// Dequeue blocks until a job is due, marks it running and bumps Attempts
+	Dequeue(ctx context.Context) (*model.Job, error)
+	// Retry puts a running job back in the queue, not to run before runAt
+	Retry(ctx context.Context, job *model.Job, runAt time.Time) error
+	// Heartbeat renews the claim on a running job while its handler runs,
+	// so other processes sharing the queue don't reclaim it
+	Heartbeat(ctx context.Context, job *model.Job) error
+	// Finish records a terminal status (done, failed or cancelled)
+	Finish(ctx context.Context, job *model.Job, status, lastErr string) error
+	// Cancel removes a job that has not started yet. It reports false if the
+	// job exists but is no longer queued, and ErrNotFound if it doesn't exist.
+	Cancel(ctx context.Context, id string) (bool, error)
+	// Get returns a job by ID
+	Get(ctx context.Context, id string) (*model.Job, error)
+	// RequeueInterrupted returns jobs left running by a process that is gone to the queue
+	RequeueInterrupted(ctx context.Context) (int, error)
+	Close() error
+}
+
+// Open creates a queue for the given backend: "sqlite" (the default) or "redis"
+func Open(backend, redisAddr string) (Queue, error) {
+	switch backend {
+	case "", "sqlite":
+		return NewSQLQueue(), nil
+	case "redis":
+		if redisAddr == "" {
+			return nil, fmt.Errorf("redis queue requires an address")
+		}
+		return NewRedisQueue(redisAddr, "pcap:jobs:")
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", backend)
+	}
+}
+
+func clampPriority(p int) int {
+	if p < 0 {
+		return 0
+	}
+	if p > MaxPriority {
+		return MaxPriority
+	}
+	return p
+}
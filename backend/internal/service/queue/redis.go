@@ -0,0 +1,386 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"pcap-analyzer/internal/model"
+)
+
+// Jobs that reach a terminal state are kept this long for status lookups
+const finishedJobTTL = 7 * 24 * time.Hour
+
+// leaseDuration is how long a claim on a job lasts without a heartbeat;
+// the pool renews it every heartbeatInterval
+const leaseDuration = 3 * heartbeatInterval
+
+// RedisQueue keeps jobs in Redis so several server processes can share them.
+// Every move of a job between the sets below is a single Lua script, so a
+// crash or a lost connection never leaves a job in none of them.
+//
+// Keys (under prefix):
+//
+//	job:<id>  JSON-encoded model.Job
+//	ready     sorted set of runnable job IDs, scored by priority then age
+//	delayed   sorted set of retrying job IDs, scored by run-at (unix ms)
+//	leases    sorted set of job IDs claimed by a worker, scored by lease expiry (unix ms)
+type RedisQueue struct {
+	client *respClient
+	prefix string
+
+	notify chan struct{}
+}
+
+// storeScript saves a job, drops its lease and, if a sorted set is given,
+// adds it there.
+// KEYS: job, leases[, destination]; ARGV: data, TTL seconds (0 keeps it), id[, score]
+const storeScript = `
+if ARGV[2] ~= '0' then
+	redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+redis.call('ZREM', KEYS[2], ARGV[3])
+if KEYS[3] then
+	redis.call('ZADD', KEYS[3], ARGV[4], ARGV[3])
+end
+return 1`
+
+// claimScript pops the first ready job and leases it, returning its data.
+// KEYS: ready, leases; ARGV: lease expiry, job key prefix
+const claimScript = `
+local popped = redis.call('ZPOPMIN', KEYS[1])
+if #popped == 0 then
+	return false
+end
+local data = redis.call('GET', ARGV[2] .. popped[1])
+if not data then
+	return false
+end
+redis.call('ZADD', KEYS[2], ARGV[1], popped[1])
+return data`
+
+// promoteScript moves a retry whose backoff has elapsed to the ready set.
+// KEYS: delayed, ready; ARGV: ready score, id
+const promoteScript = `
+if redis.call('ZREM', KEYS[1], ARGV[2]) == 1 then
+	redis.call('ZADD', KEYS[2], ARGV[1], ARGV[2])
+end
+return 1`
+
+// reclaimScript requeues a job whose lease is still expired.
+// KEYS: leases, ready, job; ARGV: now, ready score, id, data
+const reclaimScript = `
+local lease = redis.call('ZSCORE', KEYS[1], ARGV[3])
+if not lease or tonumber(lease) > tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[3])
+redis.call('SET', KEYS[3], ARGV[4])
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[3])
+return 1`
+
+// cancelScript cancels a job still waiting in ready or delayed, provided it
+// is unchanged since the caller read it, returning -1 if it changed.
+// KEYS: job, ready, delayed; ARGV: data read, cancelled data, TTL seconds, id
+const cancelScript = `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return -1
+end
+local removed = redis.call('ZREM', KEYS[2], ARGV[4]) + redis.call('ZREM', KEYS[3], ARGV[4])
+if removed == 0 then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'EX', ARGV[3])
+return 1`
+
+func NewRedisQueue(addr, prefix string) (*RedisQueue, error) {
+	q := &RedisQueue{
+		client: newRESPClient(addr),
+		prefix: prefix,
+		notify: make(chan struct{}, 1),
+	}
+	if _, err := q.client.Do("PING"); err != nil {
+		return nil, fmt.Errorf("connecting to redis at %s: %v", addr, err)
+	}
+	return q, nil
+}
+
+func (q *RedisQueue) key(parts ...string) string {
+	k := q.prefix
+	for _, p := range parts {
+		k += p
+	}
+	return k
+}
+
+// readyScore orders by priority (highest first), then enqueue time (oldest first).
+// Unix milliseconds stay below 1e13, so each priority band gets its own range.
+func readyScore(job *model.Job) string {
+	score := float64(MaxPriority-job.Priority)*1e13 + float64(job.CreatedAt.UnixMilli())
+	return strconv.FormatFloat(score, 'f', 0, 64)
+}
+
+func (q *RedisQueue) eval(script string, keys []string, args ...string) (interface{}, error) {
+	cmd := append([]string{"EVAL", script, strconv.Itoa(len(keys))}, keys...)
+	return q.client.Do(append(cmd, args...)...)
+}
+
+// store saves a job and releases its lease; a non-empty set also receives
+// the job ID with the given score
+func (q *RedisQueue) store(job *model.Job, set, score string) error {
+	job.UpdatedAt = time.Now()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	ttl := "0"
+	if job.Status == StatusDone || job.Status == StatusFailed || job.Status == StatusCancelled {
+		ttl = strconv.Itoa(int(finishedJobTTL.Seconds()))
+	}
+	keys := []string{q.key("job:", job.ID), q.key("leases")}
+	if set != "" {
+		keys = append(keys, q.key(set))
+	}
+	_, err = q.eval(storeScript, keys, string(data), ttl, job.ID, score)
+	return err
+}
+
+func (q *RedisQueue) save(job *model.Job) error {
+	return q.store(job, "", "")
+}
+
+func leaseExpiry() string {
+	return strconv.FormatInt(time.Now().Add(leaseDuration).UnixMilli(), 10)
+}
+
+func (q *RedisQueue) Enqueue(ctx context.Context, job *model.Job) error {
+	now := time.Now()
+	job.Status = StatusQueued
+	job.Priority = clampPriority(job.Priority)
+	job.CreatedAt = now
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+
+	var err error
+	if job.RunAt.After(now) {
+		err = q.store(job, "delayed", strconv.FormatInt(job.RunAt.UnixMilli(), 10))
+	} else {
+		err = q.store(job, "ready", readyScore(job))
+	}
+	if err == nil {
+		q.wake()
+	}
+	return err
+}
+
+func (q *RedisQueue) Dequeue(ctx context.Context) (*model.Job, error) {
+	for {
+		job, err := q.claim()
+		if err != nil || job != nil {
+			return job, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.notify:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// claim leases the first ready job. A job whose claimer dies before
+// marking it running keeps its lease until it expires, then is reclaimed.
+func (q *RedisQueue) claim() (*model.Job, error) {
+	if _, err := q.reclaimExpired(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := q.promoteDelayed(); err != nil {
+		return nil, err
+	}
+
+	data, err := replyString(q.eval(claimScript, []string{q.key("ready"), q.key("leases")}, leaseExpiry(), q.key("job:")))
+	if err == errNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job model.Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, err
+	}
+	job.Status = StatusRunning
+	job.Attempts++
+	job.UpdatedAt = time.Now()
+	encoded, err := json.Marshal(&job)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := q.client.Do("SET", q.key("job:", job.ID), string(encoded)); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// promoteDelayed moves retries whose backoff has elapsed into the ready set
+func (q *RedisQueue) promoteDelayed() error {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	due, err := replyStrings(q.client.Do("ZRANGEBYSCORE", q.key("delayed"), "-inf", now))
+	if err != nil {
+		return err
+	}
+	for _, id := range due {
+		job, err := q.Get(context.Background(), id)
+		if err == ErrNotFound {
+			if _, err := q.client.Do("ZREM", q.key("delayed"), id); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := q.eval(promoteScript, []string{q.key("delayed"), q.key("ready")}, readyScore(job), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reclaimExpired requeues jobs whose lease ran out: their worker's process
+// stopped renewing it, so it crashed or lost Redis
+func (q *RedisQueue) reclaimExpired(ctx context.Context) (int, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	ids, err := replyStrings(q.client.Do("ZRANGEBYSCORE", q.key("leases"), "-inf", now))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, id := range ids {
+		job, err := q.Get(ctx, id)
+		if err == ErrNotFound {
+			if _, err := q.client.Do("ZREM", q.key("leases"), id); err != nil {
+				return count, err
+			}
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		job.Status = StatusQueued
+		job.RunAt = time.Now()
+		job.UpdatedAt = job.RunAt
+		data, err := json.Marshal(job)
+		if err != nil {
+			return count, err
+		}
+		moved, err := replyInt(q.eval(reclaimScript, []string{q.key("leases"), q.key("ready"), q.key("job:", id)},
+			now, readyScore(job), id, string(data)))
+		if err != nil {
+			return count, err
+		}
+		count += int(moved)
+	}
+	if count > 0 {
+		q.wake()
+	}
+	return count, nil
+}
+
+func (q *RedisQueue) Heartbeat(ctx context.Context, job *model.Job) error {
+	_, err := q.client.Do("ZADD", q.key("leases"), "XX", leaseExpiry(), job.ID)
+	return err
+}
+
+func (q *RedisQueue) Retry(ctx context.Context, job *model.Job, runAt time.Time) error {
+	job.Status = StatusQueued
+	job.RunAt = runAt
+	err := q.store(job, "delayed", strconv.FormatInt(runAt.UnixMilli(), 10))
+	if err == nil {
+		q.wake()
+	}
+	return err
+}
+
+func (q *RedisQueue) Finish(ctx context.Context, job *model.Job, status, lastErr string) error {
+	job.Status = status
+	job.LastError = lastErr
+	return q.save(job)
+}
+
+// Cancel retries until the job stays unchanged between being read and the
+// script running, so a concurrent claim or promotion is never overwritten
+func (q *RedisQueue) Cancel(ctx context.Context, id string) (bool, error) {
+	ttl := strconv.Itoa(int(finishedJobTTL.Seconds()))
+	for {
+		data, err := q.getData(id)
+		if err != nil {
+			return false, err
+		}
+		var job model.Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return false, err
+		}
+		job.Status = StatusCancelled
+		job.UpdatedAt = time.Now()
+		cancelled, err := json.Marshal(&job)
+		if err != nil {
+			return false, err
+		}
+
+		n, err := replyInt(q.eval(cancelScript, []string{q.key("job:", id), q.key("ready"), q.key("delayed")},
+			data, string(cancelled), ttl, id))
+		if err != nil || n >= 0 {
+			return n == 1, err
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+	}
+}
+
+func (q *RedisQueue) Get(ctx context.Context, id string) (*model.Job, error) {
+	data, err := q.getData(id)
+	if err != nil {
+		return nil, err
+	}
+	var job model.Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// getData returns a job as stored
+func (q *RedisQueue) getData(id string) (string, error) {
+	data, err := replyString(q.client.Do("GET", q.key("job:", id)))
+	if err == errNil {
+		return "", ErrNotFound
+	}
+	return data, err
+}
+
+// RequeueInterrupted requeues only jobs whose lease expired, so it is safe
+// for every process sharing Redis to call; jobs live workers hold keep
+// their leases.
+func (q *RedisQueue) RequeueInterrupted(ctx context.Context) (int, error) {
+	return q.reclaimExpired(ctx)
+}
+
+func (q *RedisQueue) Close() error {
+	return q.client.Close()
+}
+
+func (q *RedisQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
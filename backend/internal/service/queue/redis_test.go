@@ -0,0 +1,187 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"pcap-analyzer/internal/model"
+)
+
+func newRedisQueue(t *testing.T) (*RedisQueue, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	q, err := NewRedisQueue(mr.Addr(), "t:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q, mr
+}
+
+// claimNow dequeues without waiting
+func claimNow(t *testing.T, q *RedisQueue) *model.Job {
+	t.Helper()
+	job, err := q.claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestRedisQueueOrder(t *testing.T) {
+	q, mr := newRedisQueue(t)
+	ctx := context.Background()
+	for _, j := range []struct {
+		id       string
+		priority int
+	}{{"low", 0}, {"high-old", 5}, {"high-new", 5}, {"clamped", 20}} {
+		if err := q.Enqueue(ctx, &model.Job{ID: j.id, Priority: j.priority}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond) // distinct enqueue times
+	}
+	if err := q.Enqueue(ctx, &model.Job{ID: "later", Priority: MaxPriority, RunAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"clamped", "high-old", "high-new", "low"} {
+		job := claimNow(t, q)
+		if job == nil || job.ID != want || job.Status != StatusRunning || job.Attempts != 1 {
+			t.Fatalf("claimed %+v, want %s", job, want)
+		}
+		if lease, err := mr.ZScore("t:leases", want); err != nil || lease < float64(time.Now().UnixMilli()) {
+			t.Errorf("lease on %s = %v, %v", want, lease, err)
+		}
+	}
+	// The delayed job isn't due yet
+	if job := claimNow(t, q); job != nil {
+		t.Errorf("claimed %+v before its run time", job)
+	}
+}
+
+func TestRedisQueueRetryAndFinish(t *testing.T) {
+	q, mr := newRedisQueue(t)
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, &model.Job{ID: "j", MaxAttempts: 3}); err != nil {
+		t.Fatal(err)
+	}
+	job := claimNow(t, q)
+
+	// A retry drops the lease and waits in delayed until its backoff ends
+	job.LastError = "disk full"
+	if err := q.Retry(ctx, job, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("t:leases") || claimNow(t, q) != nil {
+		t.Fatal("retry claimable before its run time")
+	}
+	mr.ZAdd("t:delayed", float64(time.Now().Add(-time.Second).UnixMilli()), "j")
+	if job = claimNow(t, q); job == nil || job.Attempts != 2 || job.LastError != "disk full" {
+		t.Fatalf("retried job = %+v", job)
+	}
+
+	// Terminal states expire and release the lease
+	if err := q.Finish(ctx, job, StatusDone, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := q.Get(ctx, "j"); got.Status != StatusDone || mr.TTL("t:job:j") != finishedJobTTL || mr.Exists("t:leases") {
+		t.Errorf("finished job = %+v, TTL %s", got, mr.TTL("t:job:j"))
+	}
+}
+
+func TestRedisQueueLeases(t *testing.T) {
+	q, mr := newRedisQueue(t)
+	ctx := context.Background()
+	for _, id := range []string{"alive", "crashed"} {
+		if err := q.Enqueue(ctx, &model.Job{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+		claimNow(t, q)
+	}
+
+	// Let both leases lapse; only the heartbeat keeps one alive
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	for _, id := range []string{"alive", "crashed"} {
+		if _, err := q.client.Do("ZADD", q.key("leases"), past, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Heartbeat(ctx, &model.Job{ID: "alive"}); err != nil {
+		t.Fatal(err)
+	}
+	// A heartbeat never recreates a lease that was already given up
+	if err := q.Heartbeat(ctx, &model.Job{ID: "finished"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := q.RequeueInterrupted(ctx); n != 1 || err != nil {
+		t.Fatalf("RequeueInterrupted = %d, %v", n, err)
+	}
+	if members, _ := mr.ZMembers("t:leases"); len(members) != 1 || members[0] != "alive" {
+		t.Errorf("leases = %v", members)
+	}
+	if job := claimNow(t, q); job == nil || job.ID != "crashed" || job.Attempts != 2 {
+		t.Errorf("reclaimed job = %+v", job)
+	}
+
+	// A lease on a job that has expired is dropped
+	mr.ZAdd("t:leases", 1, "gone")
+	if n, err := q.RequeueInterrupted(ctx); n != 0 || err != nil {
+		t.Errorf("RequeueInterrupted = %d, %v", n, err)
+	}
+	if members, _ := mr.ZMembers("t:leases"); len(members) != 2 || members[0] == "gone" || members[1] == "gone" {
+		t.Errorf("leases = %v", members)
+	}
+}
+
+func TestRedisQueueCancel(t *testing.T) {
+	q, mr := newRedisQueue(t)
+	ctx := context.Background()
+	for _, j := range []*model.Job{{ID: "ready"}, {ID: "delayed", RunAt: time.Now().Add(time.Hour)}, {ID: "running"}} {
+		if err := q.Enqueue(ctx, j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Enqueued last at the same priority, so only "running" is left to claim
+	mr.ZRem("t:ready", "running")
+	mr.ZAdd("t:ready", 0, "running")
+	claimNow(t, q)
+
+	for _, id := range []string{"ready", "delayed"} {
+		if ok, err := q.Cancel(ctx, id); !ok || err != nil {
+			t.Errorf("Cancel(%s) = %v, %v", id, ok, err)
+		}
+		if job, _ := q.Get(ctx, id); job.Status != StatusCancelled || mr.TTL("t:job:"+id) != finishedJobTTL {
+			t.Errorf("cancelled job = %+v", job)
+		}
+	}
+	if mr.Exists("t:ready") || mr.Exists("t:delayed") {
+		t.Error("cancelled jobs still queued")
+	}
+	if ok, err := q.Cancel(ctx, "running"); ok || err != nil {
+		t.Errorf("Cancel(running) = %v, %v", ok, err)
+	}
+	if job, _ := q.Get(ctx, "running"); job.Status != StatusRunning {
+		t.Errorf("running job = %+v", job)
+	}
+	if _, err := q.Cancel(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Cancel(missing) = %v", err)
+	}
+
+	// A job that changed since it was read is left alone
+	if err := q.Enqueue(ctx, &model.Job{ID: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := replyInt(q.eval(cancelScript, []string{q.key("job:", "changed"), q.key("ready"), q.key("delayed")},
+		"stale", "cancelled", "60", "changed"))
+	if n != -1 || err != nil {
+		t.Errorf("cancel of a stale read = %d, %v", n, err)
+	}
+	if job, _ := q.Get(ctx, "changed"); job.Status != StatusQueued {
+		t.Errorf("job after a stale cancel = %+v", job)
+	}
+}
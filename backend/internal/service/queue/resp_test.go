@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  interface{}
+		err   error
+	}{
+		{"status", "+OK\r\n", "OK", nil},
+		{"error", "-ERR unknown command\r\n", nil, redisError("ERR unknown command")},
+		{"integer", ":-42\r\n", int64(-42), nil},
+		{"bulk", "$7\r\nhe\r\nllo\r\n", "he\r\nllo", nil},
+		{"empty bulk", "$0\r\n\r\n", "", nil},
+		{"nil bulk", "$-1\r\n", nil, errNil},
+		{"array with nil", "*3\r\n$1\r\na\r\n$-1\r\n:1\r\n", []interface{}{"a", nil, int64(1)}, nil},
+		{"nested array", "*1\r\n*1\r\n+x\r\n", []interface{}{[]interface{}{"x"}}, nil},
+		{"nil array", "*-1\r\n", nil, errNil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReply(bufio.NewReader(strings.NewReader(tt.reply)))
+			if err != tt.err || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readReply = %#v, %v, want %#v, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+
+	for _, bad := range []string{"OK\n", "+OK\n", "?x\r\n", ":x\r\n", "$x\r\n", "$5\r\nhe", "*2\r\n+a\r\n", ""} {
+		if got, err := readReply(bufio.NewReader(strings.NewReader(bad))); err == nil {
+			t.Errorf("readReply(%q) = %#v, want an error", bad, got)
+		}
+	}
+}
+
+// fakeRedis answers each command read from conn with the next reply and
+// sends the commands it read on the returned channel
+func fakeRedis(conn net.Conn, replies ...string) <-chan []interface{} {
+	cmds := make(chan []interface{}, len(replies))
+	go func() {
+		defer close(cmds)
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for _, reply := range replies {
+			cmd, err := readReply(r)
+			if err != nil {
+				return
+			}
+			cmds <- cmd.([]interface{})
+			if _, err := conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+	return cmds
+}
+
+func TestRESPClient(t *testing.T) {
+	client, server := net.Pipe()
+	c := newRESPClient("unused")
+	c.conn, c.r = client, bufio.NewReader(client)
+	cmds := fakeRedis(server, "+OK\r\n", "-WRONGTYPE bad\r\n", "$-1\r\n", "*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+
+	if reply, err := c.Do("SET", "k", "two\r\nlines"); err != nil || reply != "OK" {
+		t.Errorf("SET = %v, %v", reply, err)
+	}
+	if cmd := <-cmds; !reflect.DeepEqual(cmd, []interface{}{"SET", "k", "two\r\nlines"}) {
+		t.Errorf("server read %q", cmd)
+	}
+
+	// Error and nil replies leave the connection usable
+	var rerr redisError
+	if _, err := c.Do("INCR", "k"); !errors.As(err, &rerr) || c.conn == nil {
+		t.Errorf("INCR = %v, conn %v", err, c.conn)
+	}
+	if _, err := replyString(c.Do("GET", "missing")); err != errNil || c.conn == nil {
+		t.Errorf("GET = %v, conn %v", err, c.conn)
+	}
+	if ids, err := replyStrings(c.Do("ZRANGE", "z", "0", "-1")); err != nil || !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Errorf("ZRANGE = %q, %v", ids, err)
+	}
+
+	// An I/O error drops the connection so the next command redials
+	if _, err := c.Do("PING"); err == nil || c.conn != nil {
+		t.Errorf("PING on a closed connection = %v, conn %v", err, c.conn)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
+
+func TestReplyHelpers(t *testing.T) {
+	if _, err := replyInt("1", nil); err == nil {
+		t.Error("replyInt accepted a string")
+	}
+	if _, err := replyString(int64(1), nil); err == nil {
+		t.Error("replyString accepted an integer")
+	}
+	if _, err := replyStrings("a", nil); err == nil {
+		t.Error("replyStrings accepted a string")
+	}
+	if n, err := replyInt(nil, errNil); err != errNil || n != 0 {
+		t.Errorf("replyInt passed on %d, %v", n, err)
+	}
+}
@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"pcap-analyzer/internal/db"
+	"pcap-analyzer/internal/model"
+)
+
+const pollInterval = time.Second
+
+// SQLQueue keeps jobs in the application database. It is meant for a single
+// server process; claiming is done with a conditional UPDATE so several
+// workers in that process never pick the same job.
+type SQLQueue struct {
+	db     *gorm.DB
+	notify chan struct{}
+}
+
+func NewSQLQueue() *SQLQueue {
+	return &SQLQueue{
+		db:     db.DB,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (q *SQLQueue) Enqueue(ctx context.Context, job *model.Job) error {
+	job.Status = StatusQueued
+	job.Priority = clampPriority(job.Priority)
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	if err := q.db.WithContext(ctx).Create(job).Error; err != nil {
+		return err
+	}
+	q.wake()
+	return nil
+}
+
+func (q *SQLQueue) Dequeue(ctx context.Context) (*model.Job, error) {
+	for {
+		job, err := q.claim(ctx)
+		if err != nil || job != nil {
+			return job, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.notify:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (q *SQLQueue) claim(ctx context.Context) (*model.Job, error) {
+	tx := q.db.WithContext(ctx)
+
+	var job model.Job
+	err := tx.Where("status = ? AND run_at <= ?", StatusQueued, time.Now()).
+		Order("priority desc, created_at asc").
+		First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := tx.Model(&model.Job{}).
+		Where("id = ? AND status = ?", job.ID, StatusQueued).
+		Updates(map[string]interface{}{
+			"status":     StatusRunning,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"updated_at": time.Now(),
+		})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		// Another worker got there first; let the caller look again
+		q.wake()
+		return nil, nil
+	}
+
+	job.Status = StatusRunning
+	job.Attempts++
+	return &job, nil
+}
+
+func (q *SQLQueue) Retry(ctx context.Context, job *model.Job, runAt time.Time) error {
+	job.Status = StatusQueued
+	job.RunAt = runAt
+	err := q.db.WithContext(ctx).Model(&model.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":     StatusQueued,
+		"run_at":     runAt,
+		"attempts":   job.Attempts,
+		"last_error": job.LastError,
+		"updated_at": time.Now(),
+	}).Error
+	if err == nil {
+		q.wake()
+	}
+	return err
+}
+
+// Heartbeat is a no-op: the SQL queue serves a single process, which
+// requeues its own running jobs at startup
+func (q *SQLQueue) Heartbeat(ctx context.Context, job *model.Job) error {
+	return nil
+}
+
+func (q *SQLQueue) Finish(ctx context.Context, job *model.Job, status, lastErr string) error {
+	job.Status = status
+	job.LastError = lastErr
+	return q.db.WithContext(ctx).Model(&model.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":     status,
+		"last_error": lastErr,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+func (q *SQLQueue) Cancel(ctx context.Context, id string) (bool, error) {
+	res := q.db.WithContext(ctx).Model(&model.Job{}).
+		Where("id = ? AND status = ?", id, StatusQueued).
+		Updates(map[string]interface{}{"status": StatusCancelled, "updated_at": time.Now()})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return true, nil
+	}
+	if _, err := q.Get(ctx, id); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (q *SQLQueue) Get(ctx context.Context, id string) (*model.Job, error) {
+	var job model.Job
+	err := q.db.WithContext(ctx).Where("id = ?", id).First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q *SQLQueue) RequeueInterrupted(ctx context.Context) (int, error) {
+	res := q.db.WithContext(ctx).Model(&model.Job{}).
+		Where("status = ?", StatusRunning).
+		Updates(map[string]interface{}{"status": StatusQueued, "run_at": time.Now(), "updated_at": time.Now()})
+	if res.Error == nil && res.RowsAffected > 0 {
+		q.wake()
+	}
+	return int(res.RowsAffected), res.Error
+}
+
+func (q *SQLQueue) Close() error {
+	return nil
+}
+
+func (q *SQLQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
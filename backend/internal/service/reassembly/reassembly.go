@@ -0,0 +1,233 @@
+package reassembly
+
+import (
+	"sort"
+	"time"
+
+	"pcap-analyzer/internal/domain"
+)
+
+// Chunk maps a run of reassembled bytes back to the packet that carried them
+type Chunk struct {
+	Offset      int // position in Flow.Data
+	Len         int
+	Timestamp   time.Time
+	PacketIndex int // index into Stream.Packets
+}
+
+// Gap marks bytes missing from Flow.Data, either lost or cut off by the
+// parser's payload snap length. Data continues directly after the gap.
+type Gap struct {
+	Offset  int // position in Flow.Data where the missing bytes belong
+	Missing int64
+}
+
+// Flow is one direction of a TCP connection with its payload in sequence order
+type Flow struct {
+	Data   []byte
+	Chunks []Chunk
+	Gaps   []Gap
+	FIN    bool
+	RST    bool
+	// Packets skipped as retransmissions of bytes already in Data
+	Retransmitted int
+}
+
+// Reassemble rebuilds both directions of a TCP stream. Retransmitted and
+// overlapping segments are deduplicated; out-of-order segments are placed by
+// sequence number.
+func Reassemble(stream *domain.Stream) (client, server *Flow) {
+	var clientSegs, serverSegs []segment
+	client, server = &Flow{}, &Flow{}
+
+	for i, pkt := range stream.Packets {
+		flow, segs := server, &serverSegs
+		if stream.FromClient(pkt) {
+			flow, segs = client, &clientSegs
+		}
+		for _, f := range pkt.Flags {
+			switch f {
+			case "FIN":
+				flow.FIN = true
+			case "RST":
+				flow.RST = true
+			}
+		}
+		isSYN := false
+		for _, f := range pkt.Flags {
+			if f == "SYN" {
+				isSYN = true
+			}
+		}
+		*segs = append(*segs, segment{pkt: pkt, index: i, syn: isSYN})
+	}
+
+	client.build(clientSegs)
+	server.build(serverSegs)
+	return client, server
+}
+
+type segment struct {
+	pkt   *domain.PacketMeta
+	index int
+	syn   bool
+	rel   int64 // sequence offset relative to the first payload byte
+}
+
+func (f *Flow) build(segs []segment) {
+	// Base the sequence space on the SYN if we saw it, else the first data segment
+	var base uint32
+	synBased := false
+	for _, s := range segs {
+		if s.syn {
+			base, synBased = s.pkt.Seq+1, true
+			break
+		}
+	}
+
+	data := segs[:0]
+	haveBase := synBased
+	for _, s := range segs {
+		if s.pkt.PayloadLen == 0 {
+			continue
+		}
+		if !haveBase {
+			base, haveBase = s.pkt.Seq, true
+		}
+		// int32 difference handles sequence number wraparound
+		s.rel = int64(int32(s.pkt.Seq - base))
+		data = append(data, s)
+	}
+	sort.SliceStable(data, func(i, j int) bool { return data[i].rel < data[j].rel })
+
+	// Without a SYN the capture may start mid-stream; begin at the first byte we have
+	var next int64
+	if !synBased && len(data) > 0 {
+		next = data[0].rel
+	}
+
+	for _, s := range data {
+		end := s.rel + int64(s.pkt.PayloadLen)
+		if end <= next {
+			f.Retransmitted++
+			continue
+		}
+		if s.rel > next {
+			f.Gaps = append(f.Gaps, Gap{Offset: len(f.Data), Missing: s.rel - next})
+			next = s.rel
+		}
+
+		skip := int(next - s.rel)
+		available := s.pkt.Payload
+		if skip < len(available) {
+			chunk := available[skip:]
+			f.Chunks = append(f.Chunks, Chunk{
+				Offset:      len(f.Data),
+				Len:         len(chunk),
+				Timestamp:   s.pkt.Timestamp,
+				PacketIndex: s.index,
+			})
+			f.Data = append(f.Data, chunk...)
+		}
+		// Payload truncated by the snap length: the tail is missing
+		if have := int64(len(available)); have < int64(s.pkt.PayloadLen) {
+			missing := int64(s.pkt.PayloadLen) - have
+			if int64(skip) > have {
+				missing = end - next
+			}
+			f.Gaps = append(f.Gaps, Gap{Offset: len(f.Data), Missing: missing})
+		}
+		next = end
+	}
+}
+
+// TimeAt returns the capture time of the packet carrying Data[offset].
+// Offsets past the end map to the last chunk.
+func (f *Flow) TimeAt(offset int) time.Time {
+	c := f.ChunkAt(offset)
+	if c == nil {
+		return time.Time{}
+	}
+	return c.Timestamp
+}
+
+// ChunkAt returns the chunk containing Data[offset]
+func (f *Flow) ChunkAt(offset int) *Chunk {
+	if len(f.Chunks) == 0 {
+		return nil
+	}
+	i := sort.Search(len(f.Chunks), func(i int) bool {
+		return f.Chunks[i].Offset+f.Chunks[i].Len > offset
+	})
+	if i == len(f.Chunks) {
+		i--
+	}
+	return &f.Chunks[i]
+}
+
+// HasGap reports whether bytes are missing anywhere in [start, end). A gap
+// at start counts: its bytes belong right before Data[start].
+func (f *Flow) HasGap(start, end int) bool {
+	for _, g := range f.Gaps {
+		if g.Offset >= start && g.Offset < end {
+			return true
+		}
+	}
+	return false
+}
+
+// Closed reports whether the sender finished or aborted the connection
+func (f *Flow) Closed() bool {
+	return f.FIN || f.RST
+}
+
+// Contiguous returns the data from offset up to the next gap
+func (f *Flow) Contiguous(offset int) []byte {
+	if offset >= len(f.Data) {
+		return nil
+	}
+	for _, g := range f.Gaps {
+		if g.Offset > offset {
+			return f.Data[offset:g.Offset]
+		}
+	}
+	return f.Data[offset:]
+}
+
+// Skip returns the offset in Data that lies n stream bytes past offset,
+// counting the bytes missing in gaps, so length-prefixed framing survives
+// truncated payloads. ok is false if that position was not captured; the
+// offset is then where data resumes.
+func (f *Flow) Skip(offset int, n int64) (int, bool) {
+	pos := offset
+	for _, g := range f.Gaps {
+		if g.Offset <= offset {
+			continue
+		}
+		avail := int64(g.Offset - pos)
+		if n < avail {
+			return pos + int(n), true
+		}
+		n -= avail
+		pos = g.Offset
+		if n < g.Missing {
+			return pos, false
+		}
+		n -= g.Missing
+	}
+	end := pos + int(n)
+	return end, end <= len(f.Data)
+}
+
+// NextPacketStart returns the offset of the first packet boundary at or
+// after offset, or len(Data) if there is none. Dissectors resynchronize
+// there after losing their framing.
+func (f *Flow) NextPacketStart(offset int) int {
+	i := sort.Search(len(f.Chunks), func(i int) bool {
+		return f.Chunks[i].Offset >= offset
+	})
+	if i == len(f.Chunks) {
+		return len(f.Data)
+	}
+	return f.Chunks[i].Offset
+}